@@ -2,16 +2,28 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 
 	"github.com/cofedish/3x-UI-agents/agent/api"
 	"github.com/cofedish/3x-UI-agents/agent/config"
+	"github.com/cofedish/3x-UI-agents/agent/middleware"
 	xrayConfig "github.com/cofedish/3x-UI-agents/config"
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/gin-gonic/gin"
 )
 
-// Run starts the agent in server mode.
+// Run starts the agent in server mode and blocks until SIGINT or SIGTERM, after which
+// in-flight requests are drained, the database is checkpointed and closed and the rate
+// limiter stopped. SIGHUP reloads the configuration and certificates in place.
 func Run() error {
 	logger.Info("=== Starting 3x-ui Agent ===")
 
@@ -32,15 +44,159 @@ func Run() error {
 	if err := database.InitDB(dbPath); err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
+	defer func() {
+		// Fold the WAL into the database file so a stopped agent leaves one consistent file
+		if err := database.Checkpoint(); err != nil {
+			logger.Warning("Failed to checkpoint database:", err)
+		}
+		if err := database.CloseDB(); err != nil {
+			logger.Warning("Failed to close database:", err)
+		}
+	}()
+
+	// Register with the panel on first start if an enrollment token is set
+	if err := enroll(cfg); err != nil {
+		return fmt.Errorf("failed to enroll: %w", err)
+	}
 
 	// Setup router
-	router := api.SetupRouter(cfg)
+	var keyring *middleware.JWTKeyring
+	if cfg.UsesAuth("jwt") {
+		// A secret rotated by the panel replaces AGENT_JWT_SECRET
+		keyring, err = middleware.NewJWTKeyring(cfg.JWTSecret, settingSecretStore{})
+		if err != nil {
+			return fmt.Errorf("failed to load JWT secret: %w", err)
+		}
+		if keyring.Current() == "" {
+			return fmt.Errorf("JWT auth requires AGENT_JWT_SECRET or enrollment")
+		}
+	}
+
+	// Bind the agent to the controller that manages it
+	var binding *middleware.ControllerBinding
+	if cfg.ControllerBinding != "off" {
+		store := settingBindingStore{}
+		if cfg.ControllerBinding == "reset" {
+			if err := store.reset(); err != nil {
+				return fmt.Errorf("failed to reset controller binding: %w", err)
+			}
+			logger.Info("Controller binding reset, the next controller to manage this agent will be recorded")
+		}
+		binding, err = middleware.NewControllerBinding(store, cfg.ControllerIdentity)
+		if err != nil {
+			return fmt.Errorf("failed to load controller binding: %w", err)
+		}
+		if identity := binding.Identity(); identity != "" {
+			logger.Info(fmt.Sprintf("Bound to controller: %s", identity))
+		}
+	}
+	router, rateLimiter, verifier, err := newRouter(cfg, keyring, binding)
+	if err != nil {
+		return err
+	}
+	// The rate limiter is replaced on reload
+	defer func() { rateLimiter.Stop() }()
+
+	server, err := api.NewServer(cfg, router, verifier)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go api.RunTrafficCollector(ctx)
 
 	// Start server
 	logger.Info("Starting agent API server...")
-	if err := api.StartServer(cfg, router); err != nil {
-		return fmt.Errorf("failed to start server: %w", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Run(ctx)
+	}()
+
+	for {
+		select {
+		case err := <-serveErr:
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("failed to start server: %w", err)
+			}
+			logger.Info("=== 3x-ui Agent stopped ===")
+			return nil
+		case <-hup:
+			logger.Info("Received SIGHUP, reloading configuration and certificates...")
+			next, nextLimiter, err := reload(cfg, server, keyring, binding)
+			if err != nil {
+				logger.Error("Reload failed, keeping the current configuration:", err)
+				continue
+			}
+			rateLimiter.Stop()
+			cfg, rateLimiter = next, nextLimiter
+			logger.Info("Configuration and certificates reloaded")
+		}
 	}
+}
 
-	return nil
+// newRouter creates the rate limiter, client certificate checks and router for cfg.
+// keyring and binding may be nil. The caller stops the rate limiter.
+func newRouter(cfg *config.AgentConfig, keyring *middleware.JWTKeyring, binding *middleware.ControllerBinding) (*gin.Engine, *middleware.RateLimiter, *middleware.ClientCertVerifier, error) {
+	// Name policy and revocation checks for mTLS client certificates
+	var verifier *middleware.ClientCertVerifier
+	if cfg.UsesAuth("mtls") {
+		policy, err := middleware.NewCertPolicy(cfg.AllowedClientNames)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load client certificate policy: %w", err)
+		}
+		revocation, err := middleware.NewRevocationChecker(cfg.CRLFile, cfg.CertDenylist)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load certificate revocation list: %w", err)
+		}
+		verifier = &middleware.ClientCertVerifier{Policy: policy, Revocation: revocation}
+	}
+
+	identify := middleware.MTLSIdentity
+	if keyring != nil {
+		identify = middleware.FirstIdentity(middleware.MTLSIdentity, middleware.JWTIdentity(api.JWTConfig(cfg, keyring)))
+	}
+	rateLimiter := middleware.NewRateLimiter(middleware.Limit{PerMinute: cfg.RateLimit, Burst: cfg.RateLimitBurst}, cfg.RateLimitIdentities, identify)
+	return api.SetupRouter(cfg, rateLimiter, verifier, keyring, binding), rateLimiter, verifier, nil
+}
+
+// reload loads the configuration again, from cfg.EnvFile if set, and switches server to
+// a router and certificates built from it. Settings that need a restart are kept as they
+// are and logged. It returns the new configuration and rate limiter.
+func reload(cfg *config.AgentConfig, server *api.Server, keyring *middleware.JWTKeyring, binding *middleware.ControllerBinding) (*config.AgentConfig, *middleware.RateLimiter, error) {
+	if cfg.EnvFile != "" {
+		if err := config.LoadEnvFile(cfg.EnvFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	next, err := config.LoadConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if next.UsesAuth("jwt") && keyring == nil {
+		return nil, nil, fmt.Errorf("enabling JWT auth requires a restart")
+	}
+	if binding != nil && !slices.Equal(next.ControlAuth, []string{"mtls"}) {
+		return nil, nil, fmt.Errorf("controller binding requires mTLS on control routes; turning it off requires a restart")
+	}
+	if changed := cfg.RestartRequired(next); len(changed) > 0 {
+		logger.Warning("Changes to", strings.Join(changed, ", "), "take effect after a restart")
+	}
+	// Keep them as they run, so the next reload warns again
+	next.ListenAddr, next.JWTSecret, next.ShutdownTimeout = cfg.ListenAddr, cfg.JWTSecret, cfg.ShutdownTimeout
+	next.ControllerBinding, next.ControllerIdentity = cfg.ControllerBinding, cfg.ControllerIdentity
+
+	router, rateLimiter, verifier, err := newRouter(next, keyring, binding)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := server.Reload(next, router, verifier); err != nil {
+		rateLimiter.Stop()
+		return nil, nil, err
+	}
+	return next, rateLimiter, nil
 }
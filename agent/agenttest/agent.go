@@ -0,0 +1,1103 @@
+// Package agenttest provides an in-process mock agent for exercising RemoteConnector,
+// the health job, aggregation and task execution against the full /api/v1 surface.
+//
+// The mock keeps inbounds, clients and traffic in memory, authenticates with a signed JWT
+// like a real agent and lets callers script failures and latency per route:
+//
+//	agent := agenttest.New()
+//	defer agent.Close()
+//	agent.Fail("GET /api/v1/system/stats", agenttest.Fault{Status: 500, Times: 2})
+//	agent.Delay("GET /api/v1/inbounds", 3*time.Second)
+//	server := agent.Server(7, "agent-7")
+//
+// server can be stored in the database and resolved through GetConnector, or passed to
+// service.NewRemoteConnector directly. NewTLS starts an agent that serves HTTPS and also
+// accepts panels by their client certificate, for the mTLS path of the connector.
+package agenttest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/middleware"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Secret is the JWT secret of mock agents.
+const Secret = "agenttest-secret"
+
+// Fault is a scripted answer of a route that replaces its normal response.
+type Fault struct {
+	Status  int           // HTTP status, default 500
+	Code    string        // Error code, default "MOCK_FAILURE"
+	Message string        // Error message, default "scripted failure"
+	Latency time.Duration // Delay before answering
+	Drop    bool          // Close the connection without answering, as an unreachable agent
+	Times   int           // Number of requests to fail, 0 = until Reset
+}
+
+// Request is a request received by the mock agent.
+type Request struct {
+	Method string
+	Route  string // Gin route pattern, e.g. "/api/v1/inbounds/:id"
+	Path   string
+	Body   []byte
+	Time   time.Time
+}
+
+// Agent is an in-process agent serving the /api/v1 API over plain HTTP, or HTTPS with
+// NewTLS.
+type Agent struct {
+	URL string
+
+	httpServer *httptest.Server
+	keyring    *middleware.JWTKeyring
+
+	mu          sync.Mutex
+	inbounds    []*model.Inbound
+	nextId      int
+	outbounds   []*model.OutboundTraffics
+	online      []string
+	xrayRunning bool
+	xrayVersion string
+	version     string
+	blocklist   []*model.BlockedIP
+	certs       []*service.CertInfo
+	settings    json.RawMessage
+	logs        []string
+	traffic     xray.Traffic
+	configEpoch int
+	faults      map[string]*Fault
+	delays      map[string]time.Duration
+	requests    []Request
+}
+
+// New starts a mock agent with Xray running and no inbounds. Gin is switched to test mode
+// to keep route debug output out of test logs.
+func New() *Agent {
+	a := newAgent()
+	a.httpServer = httptest.NewServer(a.router())
+	a.URL = a.httpServer.URL
+	return a
+}
+
+// NewTLS starts a mock agent like New that serves HTTPS with the agent certificate of
+// certs, e.g. issued by service.PanelCAService for "127.0.0.1". Besides JWT it accepts
+// client certificates signed by the CA of certs, like an agent allowing both methods.
+func NewTLS(certs *service.AgentCertificates) (*Agent, error) {
+	cert, err := tls.X509KeyPair([]byte(certs.CertPem), []byte(certs.KeyPem))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(certs.CAPem)) {
+		return nil, fmt.Errorf("invalid CA certificate")
+	}
+	a := newAgent()
+	a.httpServer = httptest.NewUnstartedServer(a.router())
+	a.httpServer.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS13,
+	}
+	a.httpServer.StartTLS()
+	a.URL = a.httpServer.URL
+	return a, nil
+}
+
+// newAgent creates the state of a mock agent.
+func newAgent() *Agent {
+	gin.SetMode(gin.TestMode)
+	a := &Agent{
+		nextId:      1,
+		xrayRunning: true,
+		xrayVersion: "25.10.15",
+		version:     "mock",
+		settings:    json.RawMessage(`{}`),
+		faults:      map[string]*Fault{},
+		delays:      map[string]time.Duration{},
+	}
+	a.keyring, _ = middleware.NewJWTKeyring(Secret, nil)
+	return a
+}
+
+// JWTSecret returns the current JWT secret of the agent, which differs from Secret once
+// the panel rotated it.
+func (a *Agent) JWTSecret() string {
+	return a.keyring.Current()
+}
+
+// Close shuts the agent down. Requests sent afterwards fail as if the agent were unreachable.
+func (a *Agent) Close() {
+	a.httpServer.CloseClientConnections()
+	a.httpServer.Close()
+}
+
+// Server returns a server record pointing at the agent with JWT auth.
+func (a *Agent) Server(id int, name string) *model.Server {
+	return &model.Server{
+		Id:       id,
+		Name:     name,
+		Endpoint: a.URL,
+		AuthType: "jwt",
+		AuthData: fmt.Sprintf(`{"secret":%q}`, Secret),
+		Status:   "pending",
+		Enabled:  true,
+	}
+}
+
+// MTLSServer returns a server record pointing at a NewTLS agent with mTLS auth, using the
+// panel client certificate of certs.
+func (a *Agent) MTLSServer(id int, name string, certs *service.AgentCertificates) *model.Server {
+	authData, _ := json.Marshal(map[string]string{
+		"certPem": certs.ClientCertPem,
+		"keyPem":  certs.ClientKeyPem,
+		"caPem":   certs.CAPem,
+	})
+	return &model.Server{
+		Id:       id,
+		Name:     name,
+		Endpoint: a.URL,
+		AuthType: "mtls",
+		AuthData: string(authData),
+		Status:   "pending",
+		Enabled:  true,
+	}
+}
+
+// Fail scripts a fault for route, given as "METHOD /api/v1/pattern" with Gin parameters,
+// e.g. "PUT /api/v1/inbounds/:id".
+func (a *Agent) Fail(route string, fault Fault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults[route] = &fault
+}
+
+// Delay adds latency to every answer of route.
+func (a *Agent) Delay(route string, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.delays[route] = latency
+}
+
+// Reset removes all scripted faults and latency.
+func (a *Agent) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = map[string]*Fault{}
+	a.delays = map[string]time.Duration{}
+}
+
+// Requests returns the requests received so far, oldest first.
+func (a *Agent) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Request(nil), a.requests...)
+}
+
+// Count returns the number of requests received for route.
+func (a *Agent) Count(route string) int {
+	count := 0
+	for _, req := range a.Requests() {
+		if req.Method+" "+req.Route == route {
+			count++
+		}
+	}
+	return count
+}
+
+// AddInbound stores an inbound as if it had been created on the agent and returns its ID.
+func (a *Agent) AddInbound(inbound *model.Inbound) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addInbound(inbound)
+}
+
+// Inbounds returns copies of the stored inbounds.
+func (a *Agent) Inbounds() []*model.Inbound {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbounds := make([]*model.Inbound, 0, len(a.inbounds))
+	for _, inbound := range a.inbounds {
+		copied := *inbound
+		copied.ClientStats = append([]xray.ClientTraffic(nil), inbound.ClientStats...)
+		inbounds = append(inbounds, &copied)
+	}
+	return inbounds
+}
+
+// AddTraffic adds up and down bytes to a client and its inbound, as traffic collected by Xray.
+func (a *Agent) AddTraffic(email string, up, down int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, inbound := range a.inbounds {
+		for i := range inbound.ClientStats {
+			stat := &inbound.ClientStats[i]
+			if stat.Email != email {
+				continue
+			}
+			stat.Up += up
+			stat.Down += down
+			stat.AllTime += up + down
+			stat.UpdatedAt = time.Now().Unix()
+			inbound.Up += up
+			inbound.Down += down
+			inbound.AllTime += up + down
+			a.traffic.Up += up
+			a.traffic.Down += down
+		}
+	}
+}
+
+// SetOnline sets the emails reported as online.
+func (a *Agent) SetOnline(emails ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.online = emails
+}
+
+// SetCerts sets the certificates reported by /api/v1/certificates.
+func (a *Agent) SetCerts(certs ...*service.CertInfo) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.certs = certs
+}
+
+// SetOutbounds sets the reported outbound traffics.
+func (a *Agent) SetOutbounds(outbounds ...*model.OutboundTraffics) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.outbounds = outbounds
+}
+
+// SetLogs sets the log lines returned by /api/v1/logs.
+func (a *Agent) SetLogs(lines ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.logs = lines
+}
+
+// AppendLogs adds log lines, which open /api/v1/logs/stream connections receive.
+func (a *Agent) AppendLogs(lines ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.logs = append(a.logs, lines...)
+}
+
+// XrayRunning reports whether the simulated Xray is running.
+func (a *Agent) XrayRunning() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.xrayRunning
+}
+
+// Blocklist returns the last blocklist pushed to the agent.
+func (a *Agent) Blocklist() []*model.BlockedIP {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.blocklist
+}
+
+// Settings returns the last fleet settings pushed to the agent.
+func (a *Agent) Settings() json.RawMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.settings
+}
+
+// addInbound stores an inbound and its client stats. Callers hold a.mu.
+func (a *Agent) addInbound(inbound *model.Inbound) int {
+	stored := *inbound
+	stored.Id = a.nextId
+	a.nextId++
+	if stored.Tag == "" {
+		stored.Tag = fmt.Sprintf("inbound-%d", stored.Port)
+	}
+	if stored.Version == 0 {
+		stored.Version = 1
+	}
+	stored.ClientStats = nil
+	for _, client := range settingsClients(stored.Settings) {
+		stored.ClientStats = append(stored.ClientStats, clientStat(stored.Id, client))
+	}
+	a.inbounds = append(a.inbounds, &stored)
+	return stored.Id
+}
+
+// findInbound returns the stored inbound with id. Callers hold a.mu.
+func (a *Agent) findInbound(id int) *model.Inbound {
+	for _, inbound := range a.inbounds {
+		if inbound.Id == id {
+			return inbound
+		}
+	}
+	return nil
+}
+
+// settingsClients returns the clients in inbound settings.
+func settingsClients(settings string) []map[string]any {
+	var parsed struct {
+		Clients []map[string]any `json:"clients"`
+	}
+	_ = json.Unmarshal([]byte(settings), &parsed)
+	return parsed.Clients
+}
+
+// setSettingsClients replaces the clients in the settings of an inbound.
+func setSettingsClients(inbound *model.Inbound, clients []map[string]any) {
+	settings := map[string]any{}
+	_ = json.Unmarshal([]byte(inbound.Settings), &settings)
+	settings["clients"] = clients
+	if data, err := json.Marshal(settings); err == nil {
+		inbound.Settings = string(data)
+	}
+}
+
+// clientStat returns the empty traffic record of a client.
+func clientStat(inboundId int, client map[string]any) xray.ClientTraffic {
+	email, _ := client["email"].(string)
+	enable, ok := client["enable"].(bool)
+	total, _ := client["totalGB"].(float64)
+	expiryTime, _ := client["expiryTime"].(float64)
+	return xray.ClientTraffic{
+		InboundId:  inboundId,
+		Email:      email,
+		Enable:     enable || !ok,
+		Total:      int64(total),
+		ExpiryTime: int64(expiryTime),
+		UpdatedAt:  time.Now().Unix(),
+	}
+}
+
+// response is the standard agent response envelope.
+type response struct {
+	Success bool       `json:"success"`
+	Data    any        `json:"data,omitempty"`
+	Page    *page      `json:"page,omitempty"`
+	Cursor  int64      `json:"cursor,omitempty"`
+	Error   *errorInfo `json:"error,omitempty"`
+}
+
+type page struct {
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	Total  int64 `json:"total"`
+}
+
+type errorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func ok(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, response{Success: true, Data: data})
+}
+
+func fail(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, response{Success: false, Error: &errorInfo{Code: code, Message: message}})
+}
+
+// paginate returns the page of items requested with ?limit=&offset=, or all of them.
+func paginate[T any](c *gin.Context, items []T) ([]T, *page) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return items, nil
+	}
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	p := &page{Limit: limit, Offset: offset, Total: int64(len(items))}
+	if offset >= len(items) {
+		return []T{}, p
+	}
+	return items[offset:min(offset+limit, len(items))], p
+}
+
+// script records the request and applies the scripted latency and faults of its route.
+func (a *Agent) script(c *gin.Context) {
+	route := c.Request.Method + " " + c.FullPath()
+	var body []byte
+	if c.Request.Body != nil {
+		body, _ = c.GetRawData()
+		c.Request.Body = newBody(body)
+	}
+
+	a.mu.Lock()
+	a.requests = append(a.requests, Request{Method: c.Request.Method, Route: c.FullPath(), Path: c.Request.URL.Path, Body: body, Time: time.Now()})
+	delay := a.delays[route]
+	var fault Fault
+	scripted, hasFault := a.faults[route]
+	if hasFault {
+		fault = *scripted
+		if scripted.Times > 0 {
+			scripted.Times--
+			if scripted.Times == 0 {
+				delete(a.faults, route)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	time.Sleep(delay + fault.Latency)
+	if !hasFault {
+		c.Next()
+		a.recordConfigEpoch(c)
+		return
+	}
+	if fault.Drop {
+		if hijacker, ok := c.Writer.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+		c.Abort()
+		return
+	}
+	status, code, message := fault.Status, fault.Code, fault.Message
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	if code == "" {
+		code = "MOCK_FAILURE"
+	}
+	if message == "" {
+		message = "scripted failure"
+	}
+	fail(c, status, code, message)
+}
+
+// recordConfigEpoch keeps the config epoch of a successful change, like a real agent.
+func (a *Agent) recordConfigEpoch(c *gin.Context) {
+	epoch, err := strconv.Atoi(c.GetHeader(service.ConfigEpochHeader))
+	if err != nil || c.Writer.Status() >= http.StatusMultipleChoices {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configEpoch = max(a.configEpoch, epoch)
+}
+
+// ConfigEpoch returns the config epoch of the last change the agent applied.
+func (a *Agent) ConfigEpoch() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.configEpoch
+}
+
+// newBody returns a request body reading data again after it was consumed.
+func newBody(data []byte) io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(data))
+}
+
+// router builds the routes of the agent API.
+func (a *Agent) router() *gin.Engine {
+	router := gin.New()
+	router.UseRawPath = true
+	router.Use(a.script)
+
+	v1 := router.Group("/api/v1")
+	v1.GET("/health", a.health)
+
+	protected := v1.Group("")
+	jwtConfig := middleware.JWTConfig{Keyring: a.keyring, Audience: "x-ui-agent", ClockSkew: time.Minute}
+	protected.Use(middleware.RouteAuth([]string{"mtls", "jwt"}, nil, nil, jwtConfig))
+
+	protected.GET("/info", a.info)
+	protected.POST("/auth/rotate", a.rotateSecret)
+	protected.GET("/inbounds", a.listInbounds)
+	protected.GET("/inbounds/:id", a.getInbound)
+	protected.POST("/inbounds", a.createInbound)
+	protected.PUT("/inbounds/:id", a.updateInbound)
+	protected.DELETE("/inbounds/:id", a.deleteInbound)
+	protected.POST("/inbounds/:id/clients", a.addClient)
+	protected.PUT("/inbounds/:id/clients/:index", a.updateClient)
+	protected.DELETE("/inbounds/:id/clients/:email", a.deleteClient)
+	protected.POST("/inbounds/:id/clients/:email/reset-traffic", a.resetClientTraffic)
+	protected.POST("/inbounds/:id/reset-traffic", a.resetInboundTraffic)
+
+	protected.GET("/traffic", a.getTraffic)
+	protected.GET("/traffic/clients", a.getClientTraffics)
+	protected.GET("/traffic/outbounds", a.locked(func(c *gin.Context) { ok(c, a.outbounds) }))
+	protected.GET("/clients/online", a.locked(func(c *gin.Context) { ok(c, append([]string{}, a.online...)) }))
+	protected.GET("/clients/online/details", a.onlineDetails)
+
+	protected.POST("/xray/start", a.setXray(true))
+	protected.POST("/xray/stop", a.setXray(false))
+	protected.POST("/xray/restart", a.setXray(true))
+	protected.GET("/xray/version", a.locked(func(c *gin.Context) { ok(c, gin.H{"version": a.xrayVersion}) }))
+	protected.GET("/xray/config", a.xrayConfig)
+	protected.GET("/xray/capabilities", a.locked(func(c *gin.Context) {
+		ok(c, gin.H{"xrayVersion": a.xrayVersion, "known": true, "features": gin.H{}})
+	}))
+	protected.POST("/xray/install", a.installXray)
+	protected.POST("/agent/update", a.updateAgent)
+	protected.GET("/tasks/:id", a.getTask)
+	protected.GET("/xray/keys/:kind", func(c *gin.Context) { ok(c, gin.H{"kind": c.Param("kind"), "mock": true}) })
+	protected.POST("/xray/api/users", func(c *gin.Context) { ok(c, nil) })
+	protected.DELETE("/xray/api/inbounds/:tag/users/:email", func(c *gin.Context) { ok(c, nil) })
+	protected.GET("/xray/api/stats", a.queryStats)
+
+	protected.GET("/system/stats", a.systemStats)
+	protected.GET("/system/connections", a.connections)
+	protected.GET("/logs", a.getLogs)
+	protected.GET("/logs/stream", a.streamLogs)
+	protected.GET("/geofiles", func(c *gin.Context) {
+		ok(c, []gin.H{{"name": "geoip.dat", "size": 1 << 20, "sha256": strings.Repeat("0", 64), "modifiedAt": 0}})
+	})
+	protected.POST("/geofiles/update", func(c *gin.Context) { ok(c, nil) })
+	protected.PUT("/blocklist", a.syncBlocklist)
+	protected.GET("/settings", a.locked(func(c *gin.Context) { ok(c, a.settings) }))
+	protected.PUT("/settings", a.applySettings)
+
+	protected.POST("/certificates/generate", a.generateCert)
+	protected.GET("/certificates", func(c *gin.Context) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		certs := a.certs
+		if certs == nil {
+			certs = []*service.CertInfo{}
+		}
+		ok(c, certs)
+	})
+	protected.POST("/backup", func(c *gin.Context) {
+		ok(c, gin.H{"data": base64.StdEncoding.EncodeToString([]byte("SQLite format 3\x00mock"))})
+	})
+	protected.POST("/restore", func(c *gin.Context) { ok(c, nil) })
+
+	return router
+}
+
+// locked runs handler while holding a.mu.
+func (a *Agent) locked(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		handler(c)
+	}
+}
+
+func (a *Agent) health(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ok(c, gin.H{
+		"status":       "online",
+		"xray_running": a.xrayRunning,
+		"version":      a.version,
+		"xray_version": a.xrayVersion,
+		"timestamp":    time.Now().Unix(),
+		"config_epoch": a.configEpoch,
+	})
+}
+
+func (a *Agent) info(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ok(c, gin.H{"version": a.version, "xray_version": a.xrayVersion, "os": "linux", "arch": "amd64", "kernel": "mock", "uptime": 3600})
+}
+
+func (a *Agent) rotateSecret(c *gin.Context) {
+	var req struct {
+		Secret string `json:"secret" binding:"required"`
+		Grace  int    `json:"grace"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	grace := time.Duration(req.Grace) * time.Second
+	if grace <= 0 {
+		grace = 5 * time.Minute
+	}
+	if err := a.keyring.Rotate(req.Secret, grace); err != nil {
+		fail(c, http.StatusBadRequest, "ROTATION_FAILED", err.Error())
+		return
+	}
+	ok(c, gin.H{"rotatedAt": time.Now().Unix(), "grace": int(grace.Seconds())})
+}
+
+func (a *Agent) listInbounds(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	items, p := paginate(c, a.inbounds)
+	c.JSON(http.StatusOK, response{Success: true, Data: items, Page: p})
+}
+
+// inboundParam returns the inbound named by the :id parameter, or answers 404.
+// Callers hold a.mu.
+func (a *Agent) inboundParam(c *gin.Context) *model.Inbound {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_ID", "Invalid inbound ID")
+		return nil
+	}
+	inbound := a.findInbound(id)
+	if inbound == nil {
+		fail(c, http.StatusNotFound, "NOT_FOUND", "Inbound not found")
+	}
+	return inbound
+}
+
+func (a *Agent) getInbound(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if inbound := a.inboundParam(c); inbound != nil {
+		ok(c, inbound)
+	}
+}
+
+func (a *Agent) createInbound(c *gin.Context) {
+	inbound := &model.Inbound{}
+	if err := c.ShouldBindJSON(inbound); err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, existing := range a.inbounds {
+		if existing.Port == inbound.Port {
+			fail(c, http.StatusBadRequest, "PORT_IN_USE", "Port is already in use")
+			return
+		}
+	}
+	id := a.addInbound(inbound)
+	ok(c, a.findInbound(id))
+}
+
+func (a *Agent) updateInbound(c *gin.Context) {
+	update := &model.Inbound{}
+	if err := c.ShouldBindJSON(update); err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	// Optimistic locking as on the real agent: a stale version gets the current inbound
+	if update.Version != 0 && update.Version != inbound.Version {
+		c.AbortWithStatusJSON(http.StatusConflict, response{Success: false, Data: inbound, Error: &errorInfo{Code: "VERSION_CONFLICT", Message: "inbound was changed by someone else"}})
+		return
+	}
+	stats := inbound.ClientStats
+	update.Id, update.Version, update.ClientStats = inbound.Id, inbound.Version+1, stats
+	update.Up, update.Down, update.AllTime = inbound.Up, inbound.Down, inbound.AllTime
+	*inbound = *update
+	ok(c, inbound)
+}
+
+func (a *Agent) deleteInbound(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	for i, existing := range a.inbounds {
+		if existing == inbound {
+			a.inbounds = append(a.inbounds[:i], a.inbounds[i+1:]...)
+			break
+		}
+	}
+	ok(c, nil)
+}
+
+func (a *Agent) addClient(c *gin.Context) {
+	payload := &model.Inbound{}
+	if err := c.ShouldBindJSON(payload); err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	clients := settingsClients(inbound.Settings)
+	for _, client := range settingsClients(payload.Settings) {
+		stat := clientStat(inbound.Id, client)
+		for _, existing := range inbound.ClientStats {
+			if strings.EqualFold(existing.Email, stat.Email) {
+				fail(c, http.StatusBadRequest, "DUPLICATE_EMAIL", "Duplicate email: "+stat.Email)
+				return
+			}
+		}
+		clients = append(clients, client)
+		inbound.ClientStats = append(inbound.ClientStats, stat)
+	}
+	setSettingsClients(inbound, clients)
+	inbound.Version++
+	ok(c, nil)
+}
+
+func (a *Agent) updateClient(c *gin.Context) {
+	payload := &model.Inbound{}
+	if err := c.ShouldBindJSON(payload); err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	clients := settingsClients(inbound.Settings)
+	updates := settingsClients(payload.Settings)
+	if err != nil || index < 0 || index >= len(clients) || len(updates) == 0 {
+		fail(c, http.StatusNotFound, "NOT_FOUND", "Client not found")
+		return
+	}
+	oldEmail, _ := clients[index]["email"].(string)
+	clients[index] = updates[0]
+	setSettingsClients(inbound, clients)
+	updated := clientStat(inbound.Id, updates[0])
+	for i := range inbound.ClientStats {
+		if inbound.ClientStats[i].Email == oldEmail {
+			inbound.ClientStats[i].Email = updated.Email
+			inbound.ClientStats[i].Enable = updated.Enable
+		}
+	}
+	inbound.Version++
+	ok(c, nil)
+}
+
+func (a *Agent) deleteClient(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	// Like the real agent, the route parameter is the protocol's client ID
+	key := "id"
+	switch inbound.Protocol {
+	case model.Trojan:
+		key = "password"
+	case model.Shadowsocks:
+		key = "email"
+	}
+	clientId := c.Param("email")
+	email := ""
+	clients := make([]map[string]any, 0)
+	for _, client := range settingsClients(inbound.Settings) {
+		if client[key] == clientId {
+			email, _ = client["email"].(string)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	setSettingsClients(inbound, clients)
+	stats := make([]xray.ClientTraffic, 0, len(inbound.ClientStats))
+	for _, stat := range inbound.ClientStats {
+		if stat.Email != email {
+			stats = append(stats, stat)
+		}
+	}
+	inbound.ClientStats = stats
+	inbound.Version++
+	ok(c, nil)
+}
+
+func (a *Agent) resetClientTraffic(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	for i := range inbound.ClientStats {
+		if inbound.ClientStats[i].Email == c.Param("email") {
+			inbound.ClientStats[i].Up, inbound.ClientStats[i].Down = 0, 0
+			inbound.ClientStats[i].UpdatedAt = time.Now().Unix()
+		}
+	}
+	ok(c, nil)
+}
+
+func (a *Agent) resetInboundTraffic(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbound := a.inboundParam(c)
+	if inbound == nil {
+		return
+	}
+	inbound.Up, inbound.Down = 0, 0
+	inbound.LastTrafficResetTime = time.Now().Unix() * 1000
+	for i := range inbound.ClientStats {
+		inbound.ClientStats[i].Up, inbound.ClientStats[i].Down = 0, 0
+		inbound.ClientStats[i].UpdatedAt = time.Now().Unix()
+	}
+	ok(c, nil)
+}
+
+func (a *Agent) getTraffic(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	traffic := a.traffic
+	traffic.IsInbound = true
+	if c.Query("reset") == "true" {
+		a.traffic = xray.Traffic{}
+	}
+	ok(c, traffic)
+}
+
+func (a *Agent) getClientTraffics(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cursor := time.Now().Unix()
+	since, _ := strconv.ParseInt(c.Query("since"), 10, 64)
+	traffics := make([]*xray.ClientTraffic, 0)
+	for _, inbound := range a.inbounds {
+		for i := range inbound.ClientStats {
+			stat := inbound.ClientStats[i]
+			if since > 0 && stat.UpdatedAt < since {
+				continue
+			}
+			traffics = append(traffics, &stat)
+		}
+	}
+	items, p := paginate(c, traffics)
+	c.JSON(http.StatusOK, response{Success: true, Data: items, Page: p, Cursor: cursor})
+}
+
+func (a *Agent) onlineDetails(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	details := make([]gin.H, 0, len(a.online))
+	for i, email := range a.online {
+		details = append(details, gin.H{
+			"email":       email,
+			"connections": 1,
+			"ips":         []gin.H{{"ip": fmt.Sprintf("203.0.113.%d", i+1), "lastSeen": time.Now().Unix()}},
+		})
+	}
+	ok(c, details)
+}
+
+func (a *Agent) setXray(running bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.xrayRunning = running
+		ok(c, nil)
+	}
+}
+
+func (a *Agent) xrayConfig(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbounds := make([]*xray.InboundConfig, 0, len(a.inbounds))
+	for _, inbound := range a.inbounds {
+		if inbound.Enable {
+			inbounds = append(inbounds, inbound.GenXrayInboundConfig())
+		}
+	}
+	config, _ := json.Marshal(gin.H{"inbounds": inbounds})
+	ok(c, gin.H{"config": string(config)})
+}
+
+func (a *Agent) installXray(c *gin.Context) {
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Version == "" {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", "version is required")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.xrayVersion = strings.TrimPrefix(body.Version, "v")
+	if c.Query("async") == "true" {
+		// The mock installs at once, so the task is already done
+		ok(c, gin.H{"taskId": "install-" + body.Version})
+		return
+	}
+	ok(c, nil)
+}
+
+func (a *Agent) updateAgent(c *gin.Context) {
+	var body struct {
+		Version string `json:"version"`
+		URL     string `json:"url"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Version == "" || body.URL == "" {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", "version and url are required")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The mock installs and restarts at once
+	a.version = strings.TrimPrefix(body.Version, "v")
+	ok(c, gin.H{"success": true, "version": body.Version})
+}
+
+func (a *Agent) getTask(c *gin.Context) {
+	id := c.Param("id")
+	if !strings.HasPrefix(id, "install-") {
+		fail(c, http.StatusNotFound, "NOT_FOUND", "task not found")
+		return
+	}
+	ok(c, service.TaskProgress{Id: id, Operation: "install_xray", Status: "completed", Percent: 100, Stage: "done"})
+}
+
+func (a *Agent) queryStats(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := map[string]int64{}
+	for _, inbound := range a.inbounds {
+		for _, stat := range inbound.ClientStats {
+			for name, value := range map[string]int64{
+				"user>>>" + stat.Email + ">>>traffic>>>uplink":   stat.Up,
+				"user>>>" + stat.Email + ">>>traffic>>>downlink": stat.Down,
+			} {
+				if strings.Contains(name, c.Query("pattern")) {
+					stats[name] = value
+				}
+			}
+		}
+	}
+	ok(c, stats)
+}
+
+func (a *Agent) systemStats(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ok(c, gin.H{
+		"cpuUsage":        12.5,
+		"cpuCores":        2,
+		"memTotal":        4 << 30,
+		"memUsed":         1 << 30,
+		"memUsage":        25.0,
+		"diskTotal":       40 << 30,
+		"diskUsed":        10 << 30,
+		"diskUsage":       25.0,
+		"uptime":          3600,
+		"xrayConnections": len(a.online),
+		"appMem":          24 << 20,
+		"appGoroutines":   16,
+		"appUptime":       3600,
+		"xrayUptime":      3600,
+	})
+}
+
+func (a *Agent) connections(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts := map[int]int{}
+	for _, inbound := range a.inbounds {
+		counts[inbound.Port] = 0
+	}
+	ok(c, counts)
+}
+
+func (a *Agent) getLogs(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	count, err := strconv.Atoi(c.DefaultQuery("count", "100"))
+	if err != nil || count < 0 {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", "Invalid count")
+		return
+	}
+	logs := a.logs
+	if len(logs) > count {
+		logs = logs[len(logs)-count:]
+	}
+	ok(c, append([]string{}, logs...))
+}
+
+// streamLogs sends the last log lines and then every appended one, whatever the source.
+func (a *Agent) streamLogs(c *gin.Context) {
+	req, err := service.ParseLogStreamRequest(c.Query("source"), c.Query("level"), c.Query("tail"))
+	if err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	service.ServeLogStream(c.Writer, c.Request, func(ctx context.Context, send func(*logtail.Line) error) error {
+		a.mu.Lock()
+		next := max(0, len(a.logs)-req.Tail)
+		a.mu.Unlock()
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			a.mu.Lock()
+			lines := append([]string{}, a.logs[min(next, len(a.logs)):]...)
+			next = len(a.logs)
+			a.mu.Unlock()
+			for _, text := range lines {
+				if level := logtail.LineLevel(text); level >= req.Level {
+					if err := send(&logtail.Line{Source: req.Source, Level: level.String(), Text: text}); err != nil {
+						return err
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (a *Agent) syncBlocklist(c *gin.Context) {
+	var entries []*model.BlockedIP
+	if err := c.ShouldBindJSON(&entries); err != nil {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.blocklist = entries
+	ok(c, nil)
+}
+
+func (a *Agent) applySettings(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil || !json.Valid(body) {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", "Invalid settings")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.settings = body
+	ok(c, nil)
+}
+
+func (a *Agent) generateCert(c *gin.Context) {
+	var body struct {
+		Domain string `json:"domain"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Domain == "" {
+		fail(c, http.StatusBadRequest, "INVALID_INPUT", "domain is required")
+		return
+	}
+	now := time.Now()
+	ok(c, gin.H{
+		"domain":    body.Domain,
+		"issuedBy":  "Mock CA",
+		"notBefore": now.Unix(),
+		"notAfter":  now.Add(90 * 24 * time.Hour).Unix(),
+		"validDays": 90,
+		"isValid":   true,
+	})
+}
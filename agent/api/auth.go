@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/middleware"
+	"github.com/cofedish/3x-UI-agents/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultRotationGrace is how long the replaced JWT secret stays valid after a rotation.
+	defaultRotationGrace = 5 * time.Minute
+	// maxRotationGrace caps the grace period a panel may ask for.
+	maxRotationGrace = time.Hour
+)
+
+// RotateJWTSecret replaces the JWT secret of the agent with the one sent by the panel.
+// The request is authenticated with the current secret; the replaced one keeps working
+// for the grace period (seconds, default 300, at most 3600) so in-flight requests pass.
+// POST /api/v1/auth/rotate
+func RotateJWTSecret(keyring *middleware.JWTKeyring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keyring == nil {
+			respondError(c, "JWT_NOT_ENABLED", "JWT auth is not enabled on this agent", http.StatusConflict)
+			return
+		}
+
+		var req struct {
+			Secret string `json:"secret" binding:"required"`
+			Grace  int    `json:"grace"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondInvalid(c, err)
+			return
+		}
+		grace := time.Duration(req.Grace) * time.Second
+		if grace <= 0 {
+			grace = defaultRotationGrace
+		}
+		grace = min(grace, maxRotationGrace)
+
+		if err := keyring.Rotate(req.Secret, grace); err != nil {
+			logger.Warning("JWT secret rotation failed:", err)
+			respondError(c, "ROTATION_FAILED", err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("JWT secret rotated, the previous secret is accepted for", grace)
+		respondSuccess(c, gin.H{"rotatedAt": time.Now().Unix(), "grace": int(grace.Seconds())})
+	}
+}
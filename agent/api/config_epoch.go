@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// configEpochMiddleware records the config epoch the panel sends with a change once the
+// change succeeded, so /health tells the panel whether the agent has every change it made.
+// Epochs only move forward; concurrent changes may carry the same one.
+func configEpochMiddleware() gin.HandlerFunc {
+	var mu sync.Mutex
+	settingService := &service.SettingService{}
+	return func(c *gin.Context) {
+		header := c.GetHeader(service.ConfigEpochHeader)
+		if header == "" {
+			c.Next()
+			return
+		}
+		epoch, err := strconv.Atoi(header)
+		if err != nil || epoch <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error": gin.H{
+					"code":    "INVALID_CONFIG_EPOCH",
+					"message": "Invalid " + service.ConfigEpochHeader + " header",
+				},
+			})
+			return
+		}
+
+		c.Next()
+		if c.Writer.Status() >= http.StatusMultipleChoices {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		current, err := settingService.GetConfigEpoch()
+		if err == nil && epoch <= current {
+			return
+		}
+		if err := settingService.SetConfigEpoch(epoch); err != nil {
+			logger.Error("Failed to record config epoch:", err)
+		}
+	}
+}
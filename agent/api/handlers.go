@@ -2,9 +2,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -19,6 +20,8 @@ import (
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/util/sys"
 	"github.com/cofedish/3x-UI-agents/web/service"
 	"github.com/cofedish/3x-UI-agents/xray"
 	"github.com/gin-gonic/gin"
@@ -28,21 +31,32 @@ import (
 	"github.com/shirou/gopsutil/v4/load"
 	"github.com/shirou/gopsutil/v4/mem"
 	psnet "github.com/shirou/gopsutil/v4/net"
+	"gorm.io/gorm"
 )
 
 // AgentHandlers contains all agent API handlers.
 type AgentHandlers struct {
-	inboundService *service.InboundService
-	xrayService    *service.XrayService
-	serverService  *service.ServerService
+	inboundService  *service.InboundService
+	xrayService     *service.XrayService
+	serverService   *service.ServerService
+	outboundService *service.OutboundService
+	blockedIPs      *service.BlockedIPService
+	fleetSettings   *service.FleetSettingsService
+	settingService  *service.SettingService
+	publicIP        *service.PublicIPService
 }
 
 // NewAgentHandlers creates a new AgentHandlers instance.
 func NewAgentHandlers() *AgentHandlers {
 	return &AgentHandlers{
-		inboundService: &service.InboundService{},
-		xrayService:    &service.XrayService{},
-		serverService:  &service.ServerService{},
+		inboundService:  &service.InboundService{},
+		xrayService:     &service.XrayService{},
+		serverService:   &service.ServerService{},
+		outboundService: &service.OutboundService{},
+		blockedIPs:      &service.BlockedIPService{},
+		fleetSettings:   &service.FleetSettingsService{},
+		settingService:  &service.SettingService{},
+		publicIP:        &service.PublicIPService{},
 	}
 }
 
@@ -59,10 +73,22 @@ func ensureXrayRunning(c *gin.Context, xs *service.XrayService) bool {
 type StandardResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
+	Page    *PageInfo   `json:"page,omitempty"`   // Set for paginated listings
+	Cursor  int64       `json:"cursor,omitempty"` // Set for incremental listings; pass as ?since= next time
 	Error   *ErrorInfo  `json:"error,omitempty"`
 	TraceID string      `json:"trace_id,omitempty"`
 }
 
+// PageInfo describes the page of a paginated listing.
+type PageInfo struct {
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	Total  int64 `json:"total"`
+}
+
+// maxPageLimit is the largest page size accepted by paginated listings.
+const maxPageLimit = 1000
+
 // ErrorInfo contains error details.
 type ErrorInfo struct {
 	Code    string      `json:"code"`
@@ -79,6 +105,33 @@ func respondSuccess(c *gin.Context, data interface{}) {
 	})
 }
 
+// respondPage sends one page of a paginated listing.
+func respondPage(c *gin.Context, data interface{}, page *PageInfo) {
+	c.JSON(http.StatusOK, StandardResponse{
+		Success: true,
+		Data:    data,
+		Page:    page,
+		TraceID: c.GetString("trace_id"),
+	})
+}
+
+// parsePage reads the limit and offset query parameters. It returns nil without a limit,
+// in which case the full listing is returned as before pagination existed.
+func parsePage(c *gin.Context) (*PageInfo, error) {
+	if c.Query("limit") == "" {
+		return nil, nil
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative")
+	}
+	return &PageInfo{Limit: limit, Offset: offset}, nil
+}
+
 // respondError sends an error response.
 func respondError(c *gin.Context, code string, message string, statusCode int) {
 	c.JSON(statusCode, StandardResponse{
@@ -91,6 +144,35 @@ func respondError(c *gin.Context, code string, message string, statusCode int) {
 	})
 }
 
+// respondInvalid sends a 400 response listing the invalid fields of a request payload.
+// err is a binding error or a validation error from the service package.
+func respondInvalid(c *gin.Context, err error) {
+	info := &ErrorInfo{Code: "VALIDATION_FAILED", Message: err.Error()}
+	if fields := service.ValidationFields(err); fields != nil {
+		info.Details = fields
+	} else {
+		validationErr := service.NewValidationError(err)
+		info.Message = validationErr.Error()
+		info.Details = validationErr.Fields
+	}
+	c.JSON(http.StatusBadRequest, StandardResponse{
+		Success: false,
+		Error:   info,
+		TraceID: c.GetString("trace_id"),
+	})
+}
+
+// respondConflict sends a 409 response carrying the current state of the object whose
+// update was based on an outdated version.
+func respondConflict(c *gin.Context, err error, current interface{}) {
+	c.JSON(http.StatusConflict, StandardResponse{
+		Success: false,
+		Data:    current,
+		Error:   &ErrorInfo{Code: "VERSION_CONFLICT", Message: err.Error()},
+		TraceID: c.GetString("trace_id"),
+	})
+}
+
 // Health returns the agent health status.
 // GET /api/v1/health
 func (h *AgentHandlers) Health(c *gin.Context) {
@@ -102,12 +184,16 @@ func (h *AgentHandlers) Health(c *gin.Context) {
 	// xray_running is reported separately and no longer degrades status.
 	status := "online"
 
+	epoch, _ := h.settingService.GetConfigEpoch()
+
 	respondSuccess(c, gin.H{
 		"status":       status,
 		"xray_running": isRunning,
 		"version":      config.GetVersion(),
 		"xray_version": xrayVersion,
+		"xray_error":   h.xrayService.GetXrayErrorMsg(),
 		"timestamp":    time.Now().Unix(),
+		"config_epoch": epoch,
 	})
 }
 
@@ -134,20 +220,39 @@ func (h *AgentHandlers) Info(c *gin.Context) {
 	})
 }
 
-// ListInbounds returns all inbounds.
+// ListInbounds returns all inbounds, or one page of them with ?limit=&offset=.
 // GET /api/v1/inbounds
 func (h *AgentHandlers) ListInbounds(c *gin.Context) {
+	page, err := parsePage(c)
+	if err != nil {
+		respondError(c, "INVALID_INPUT", err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	db := database.GetDB()
 	var inbounds []*model.Inbound
 
 	// Get all inbounds (agent manages local server only)
-	err := db.Preload("ClientStats").Find(&inbounds).Error
+	query := db.Model(model.Inbound{}).Order("id")
+	if page != nil {
+		if err := db.Model(model.Inbound{}).Count(&page.Total).Error; err != nil {
+			logger.Error("Failed to count inbounds:", err)
+			respondError(c, "DB_ERROR", "Failed to list inbounds", http.StatusInternalServerError)
+			return
+		}
+		query = query.Limit(page.Limit).Offset(page.Offset)
+	}
+	err = query.Preload("ClientStats").Find(&inbounds).Error
 	if err != nil {
 		logger.Error("Failed to list inbounds:", err)
 		respondError(c, "DB_ERROR", "Failed to list inbounds", http.StatusInternalServerError)
 		return
 	}
 
+	if page != nil {
+		respondPage(c, inbounds, page)
+		return
+	}
 	respondSuccess(c, inbounds)
 }
 
@@ -183,7 +288,15 @@ func (h *AgentHandlers) AddInbound(c *gin.Context) {
 	var inbound model.Inbound
 
 	if err := c.ShouldBindJSON(&inbound); err != nil {
-		respondError(c, "INVALID_INPUT", "Invalid inbound data: "+err.Error(), http.StatusBadRequest)
+		respondInvalid(c, err)
+		return
+	}
+	if err := service.ValidateInbound(&inbound); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	if err := service.GetXrayCapabilities(h.xrayService.GetXrayVersion()).CheckInbound(&inbound); err != nil {
+		respondError(c, "UNSUPPORTED_FEATURE", err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -212,7 +325,15 @@ func (h *AgentHandlers) UpdateInbound(c *gin.Context) {
 
 	var inbound model.Inbound
 	if err := c.ShouldBindJSON(&inbound); err != nil {
-		respondError(c, "INVALID_INPUT", "Invalid inbound data: "+err.Error(), http.StatusBadRequest)
+		respondInvalid(c, err)
+		return
+	}
+	if err := service.ValidateInbound(&inbound); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	if err := service.GetXrayCapabilities(h.xrayService.GetXrayVersion()).CheckInbound(&inbound); err != nil {
+		respondError(c, "UNSUPPORTED_FEATURE", err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -223,6 +344,11 @@ func (h *AgentHandlers) UpdateInbound(c *gin.Context) {
 	}
 
 	_, _, err = h.inboundService.UpdateInbound(&inbound)
+	var conflictErr *service.InboundConflictError
+	if errors.As(err, &conflictErr) {
+		respondConflict(c, err, conflictErr.Current)
+		return
+	}
 	if err != nil {
 		logger.Error("Failed to update inbound:", err)
 		respondError(c, "OPERATION_FAILED", "Failed to update inbound: "+err.Error(), http.StatusInternalServerError)
@@ -266,24 +392,85 @@ func (h *AgentHandlers) AddClient(c *gin.Context) {
 
 	var inbound model.Inbound
 	if err := c.ShouldBindJSON(&inbound); err != nil {
-		respondError(c, "INVALID_INPUT", "Invalid client data: "+err.Error(), http.StatusBadRequest)
+		respondInvalid(c, err)
 		return
 	}
+	inbound.Id = id
 
-	if !ensureXrayRunning(c, h.xrayService) {
+	existing, err := h.inboundService.GetInbound(id)
+	if err != nil {
+		respondError(c, "NOT_FOUND", "Inbound not found", http.StatusNotFound)
+		return
+	}
+	if err := service.ValidateClientPayload(&inbound, existing.Protocol); err != nil {
+		respondInvalid(c, err)
 		return
 	}
 
-	inbound.Id = id
+	if !ensureXrayRunning(c, h.xrayService) {
+		return
+	}
 
-	_, err = h.inboundService.AddInboundClient(&inbound)
+	needRestart, err := h.inboundService.AddInboundClient(&inbound)
 	if err != nil {
 		logger.Error("Failed to add client:", err)
 		respondError(c, "OPERATION_FAILED", "Failed to add client: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondSuccess(c, gin.H{"success": true})
+	respondSuccess(c, gin.H{"success": true, "restarted": h.applyClientChange(needRestart)})
+}
+
+// UpdateClient updates the client at the given index of an inbound.
+// PUT /api/v1/inbounds/:id/clients/:index
+func (h *AgentHandlers) UpdateClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, "INVALID_ID", "Invalid inbound ID", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		respondError(c, "INVALID_INPUT", "Invalid client index", http.StatusBadRequest)
+		return
+	}
+
+	var inbound model.Inbound
+	if err := c.ShouldBindJSON(&inbound); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+
+	if !ensureXrayRunning(c, h.xrayService) {
+		return
+	}
+
+	existing, err := h.inboundService.GetInbound(id)
+	if err != nil {
+		respondError(c, "NOT_FOUND", "Inbound not found", http.StatusNotFound)
+		return
+	}
+	clients, err := h.inboundService.GetClients(existing)
+	if err != nil || index >= len(clients) {
+		respondError(c, "NOT_FOUND", "Client not found", http.StatusNotFound)
+		return
+	}
+
+	inbound.Id = id
+	if err := service.ValidateClientPayload(&inbound, existing.Protocol); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	clientId := h.inboundService.GetClientId(existing.Protocol, clients[index])
+
+	needRestart, err := h.inboundService.UpdateInboundClient(&inbound, clientId)
+	if err != nil {
+		logger.Error("Failed to update client:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to update client: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, gin.H{"success": true, "restarted": h.applyClientChange(needRestart)})
 }
 
 // DeleteClient deletes a client from an inbound.
@@ -305,21 +492,99 @@ func (h *AgentHandlers) DeleteClient(c *gin.Context) {
 		return
 	}
 
-	_, err = h.inboundService.DelInboundClient(id, email)
+	needRestart, err := h.inboundService.DelInboundClient(id, email)
 	if err != nil {
 		logger.Error("Failed to delete client:", err)
 		respondError(c, "OPERATION_FAILED", "Failed to delete client: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondSuccess(c, gin.H{"success": true})
+	respondSuccess(c, gin.H{"success": true, "restarted": h.applyClientChange(needRestart)})
+}
+
+// ResetClientTraffic resets the traffic of a single client.
+// POST /api/v1/inbounds/:id/clients/:email/reset-traffic
+func (h *AgentHandlers) ResetClientTraffic(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, "INVALID_ID", "Invalid inbound ID", http.StatusBadRequest)
+		return
+	}
+
+	if h.xrayService.IsXrayRunning() {
+		if _, _, err := h.flushTraffic(); err != nil {
+			logger.Warning("Failed to store traffic before reset:", err)
+		}
+	}
+
+	needRestart, err := h.inboundService.ResetClientTraffic(id, c.Param("email"))
+	if err != nil {
+		logger.Error("Failed to reset client traffic:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to reset client traffic: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, gin.H{"success": true, "restarted": h.applyClientChange(needRestart)})
+}
+
+// ResetInboundTraffic resets the traffic of an inbound and all of its clients.
+// Used by the panel to apply daily/weekly/monthly reset schedules.
+// POST /api/v1/inbounds/:id/reset-traffic
+func (h *AgentHandlers) ResetInboundTraffic(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, "INVALID_ID", "Invalid inbound ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.inboundService.GetInbound(id); err != nil {
+		respondError(c, "NOT_FOUND", "Inbound not found", http.StatusNotFound)
+		return
+	}
+	// Store pending Xray counters first so they roll into all_time before the reset
+	if h.xrayService.IsXrayRunning() {
+		if _, _, err := h.flushTraffic(); err != nil {
+			logger.Warning("Failed to store traffic before reset:", err)
+		}
+	}
+	if err := h.inboundService.ResetInboundTraffic(id); err != nil {
+		logger.Error("Failed to reset inbound traffic:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to reset inbound traffic: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.inboundService.ResetAllClientTraffics(id); err != nil {
+		logger.Error("Failed to reset client traffics:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to reset client traffics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Clients disabled by depletion are enabled again; restart so Xray picks them up
+	restarted := false
+	if h.xrayService.IsXrayRunning() {
+		restarted = h.applyClientChange(true)
+	}
+
+	respondSuccess(c, gin.H{"success": true, "restarted": restarted})
+}
+
+// applyClientChange restarts Xray only when a client change could not be applied
+// through the Xray API. Hot changes keep existing connections alive on busy nodes.
+// It reports whether Xray was restarted.
+func (h *AgentHandlers) applyClientChange(needRestart bool) bool {
+	if !needRestart {
+		return false
+	}
+	if err := h.xrayService.RestartXray(false); err != nil {
+		logger.Warning("Failed to restart Xray after client change:", err)
+		return false
+	}
+	return true
 }
 
 // GetTraffic returns traffic statistics.
 // GET /api/v1/traffic
 func (h *AgentHandlers) GetTraffic(c *gin.Context) {
-	// Use XrayService to get traffic
-	traffics, clientTraffics, err := h.xrayService.GetXrayTraffic()
+	traffics, clientTraffics, err := h.flushTraffic()
 	if err != nil {
 		logger.Error("Failed to get traffic:", err)
 		respondError(c, "OPERATION_FAILED", "Failed to get traffic: "+err.Error(), http.StatusInternalServerError)
@@ -332,19 +597,101 @@ func (h *AgentHandlers) GetTraffic(c *gin.Context) {
 	})
 }
 
-// GetClientTraffics returns client traffic statistics.
+// flushTraffic reads (and resets) the Xray counters and stores them in the agent database,
+// so up/down and all_time stay current. Xray counters are reset on read, so data that is
+// fetched without being stored would be lost.
+func (h *AgentHandlers) flushTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	traffics, clientTraffics, err := h.xrayService.GetXrayTraffic()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err, needRestart0 := h.inboundService.AddTraffic(traffics, clientTraffics)
+	if err != nil {
+		logger.Warning("Failed to store inbound traffic:", err)
+	}
+	err, needRestart1 := h.outboundService.AddTraffic(traffics, clientTraffics)
+	if err != nil {
+		logger.Warning("Failed to store outbound traffic:", err)
+	}
+	// Clients that just ran out of traffic or expired must be removed from Xray
+	if needRestart0 || needRestart1 {
+		h.applyClientChange(true)
+	}
+
+	return traffics, clientTraffics, nil
+}
+
+// GetClientTraffics returns client traffic statistics, or one page of them with ?limit=&offset=.
+// With ?since=<cursor> only rows changed since that cursor are returned, together with the
+// cursor for the next sync.
 // GET /api/v1/traffic/clients
 func (h *AgentHandlers) GetClientTraffics(c *gin.Context) {
+	page, err := parsePage(c)
+	if err != nil {
+		respondError(c, "INVALID_INPUT", err.Error(), http.StatusBadRequest)
+		return
+	}
+	var since int64
+	if value := c.Query("since"); value != "" {
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || since < 0 {
+			respondError(c, "INVALID_INPUT", "since must be a cursor returned by a previous sync", http.StatusBadRequest)
+			return
+		}
+	}
+	// Taken before the query; rows changed in this second are returned again next time
+	cursor := time.Now().Unix()
+
 	db := database.GetDB()
 	var traffics []*xray.ClientTraffic
 
-	err := db.Find(&traffics).Error
+	// Rows from before updated_at existed have none, so a full listing must not filter
+	query := db.Model(xray.ClientTraffic{})
+	if since > 0 {
+		query = query.Where("updated_at >= ?", since)
+	}
+	if page != nil {
+		if err := query.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+			logger.Error("Failed to count client traffics:", err)
+			respondError(c, "DB_ERROR", "Failed to get client traffics", http.StatusInternalServerError)
+			return
+		}
+		query = query.Limit(page.Limit).Offset(page.Offset)
+	}
+	err = query.Order("id").Find(&traffics).Error
 	if err != nil {
 		logger.Error("Failed to get client traffics:", err)
 		respondError(c, "DB_ERROR", "Failed to get client traffics", http.StatusInternalServerError)
 		return
 	}
 
+	c.JSON(http.StatusOK, StandardResponse{
+		Success: true,
+		Data:    traffics,
+		Page:    page,
+		Cursor:  cursor,
+		TraceID: c.GetString("trace_id"),
+	})
+}
+
+// GetOutboundTraffics returns the traffic totals of each outbound tag, e.g. WARP and direct.
+// Pending Xray counters are stored first, so the totals are current.
+// GET /api/v1/traffic/outbounds
+func (h *AgentHandlers) GetOutboundTraffics(c *gin.Context) {
+	if h.xrayService.IsXrayRunning() {
+		if _, _, err := h.flushTraffic(); err != nil {
+			logger.Warning("Failed to flush traffic before reading outbound traffics:", err)
+		}
+	}
+
+	traffics, err := h.outboundService.GetOutboundsTraffic()
+	if err != nil {
+		logger.Error("Failed to get outbound traffics:", err)
+		respondError(c, "DB_ERROR", "Failed to get outbound traffics", http.StatusInternalServerError)
+		return
+	}
+
 	respondSuccess(c, traffics)
 }
 
@@ -356,6 +703,12 @@ func (h *AgentHandlers) GetOnlineClients(c *gin.Context) {
 	respondSuccess(c, emails)
 }
 
+// GetOnlineClientDetails returns online clients with source IPs and GeoIP data.
+// GET /api/v1/clients/online/details
+func (h *AgentHandlers) GetOnlineClientDetails(c *gin.Context) {
+	respondSuccess(c, h.inboundService.GetOnlineClientDetails())
+}
+
 // StartXray starts the Xray service.
 // POST /api/v1/xray/start
 func (h *AgentHandlers) StartXray(c *gin.Context) {
@@ -426,6 +779,72 @@ func (h *AgentHandlers) GetXrayConfig(c *gin.Context) {
 	respondSuccess(c, gin.H{"config": string(configBytes)})
 }
 
+// GetCapabilities returns the optional Xray features supported by the agent's core.
+// GET /api/v1/xray/capabilities
+func (h *AgentHandlers) GetCapabilities(c *gin.Context) {
+	respondSuccess(c, service.GetXrayCapabilities(h.xrayService.GetXrayVersion()))
+}
+
+// XrayAddUser adds a user to a running inbound via the Xray API.
+// POST /api/v1/xray/api/users
+func (h *AgentHandlers) XrayAddUser(c *gin.Context) {
+	if !ensureXrayRunning(c, h.xrayService) {
+		return
+	}
+
+	var req service.XrayUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	if req.InboundTag == "" || req.Protocol == "" || req.User == nil {
+		respondError(c, "INVALID_INPUT", "protocol, inboundTag and user are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.xrayService.AddUserByApi(req.Protocol, req.InboundTag, req.User); err != nil {
+		logger.Error("Failed to add user via Xray API:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to add user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, gin.H{"success": true})
+}
+
+// XrayRemoveUser removes a user from a running inbound via the Xray API.
+// DELETE /api/v1/xray/api/inbounds/:tag/users/:email
+func (h *AgentHandlers) XrayRemoveUser(c *gin.Context) {
+	if !ensureXrayRunning(c, h.xrayService) {
+		return
+	}
+
+	if err := h.xrayService.RemoveUserByApi(c.Param("tag"), c.Param("email")); err != nil {
+		logger.Error("Failed to remove user via Xray API:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to remove user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, gin.H{"success": true})
+}
+
+// XrayQueryStats returns raw Xray counters matching a pattern. The counters are not reset,
+// as the traffic collector relies on being the only one to reset them.
+// GET /api/v1/xray/api/stats?pattern=user>>>
+func (h *AgentHandlers) XrayQueryStats(c *gin.Context) {
+	if !ensureXrayRunning(c, h.xrayService) {
+		return
+	}
+
+	stats, err := h.xrayService.QueryStatsByApi(c.Query("pattern"))
+	if err != nil {
+		logger.Error("Failed to query Xray stats:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to query stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, stats)
+}
+
 // GetSystemStats returns system resource statistics.
 // GET /api/v1/system/stats
 func (h *AgentHandlers) GetSystemStats(c *gin.Context) {
@@ -500,33 +919,8 @@ func (h *AgentHandlers) GetSystemStats(c *gin.Context) {
 	stats["tcpConnections"] = tcpCount
 	stats["udpConnections"] = udpCount
 
-	// Public IPs (best-effort: first non-loopback address)
-	publicIPv4 := ""
-	publicIPv6 := ""
-	if ifs, err := net.Interfaces(); err == nil {
-		for _, iface := range ifs {
-			if (iface.Flags&net.FlagUp) == 0 || (iface.Flags&net.FlagLoopback) != 0 {
-				continue
-			}
-			addrs, _ := iface.Addrs()
-			for _, a := range addrs {
-				if ipNet, ok := a.(*net.IPNet); ok {
-					if ip := ipNet.IP; ip != nil && !ip.IsLoopback() {
-						if v4 := ip.To4(); v4 != nil && publicIPv4 == "" {
-							publicIPv4 = v4.String()
-						} else if v4 == nil && publicIPv6 == "" {
-							publicIPv6 = ip.String()
-						}
-					}
-				}
-			}
-			if publicIPv4 != "" && publicIPv6 != "" {
-				break
-			}
-		}
-	}
-	stats["publicIPv4"] = publicIPv4
-	stats["publicIPv6"] = publicIPv6
+	// Public IPs, cached by the detector
+	stats["publicIPv4"], stats["publicIPv6"] = h.publicIP.Detect()
 
 	// System info
 	hostInfo, err := host.Info()
@@ -546,9 +940,27 @@ func (h *AgentHandlers) GetSystemStats(c *gin.Context) {
 
 	stats["xrayConnections"] = 0
 
+	// The agent's own usage, so runaway agents stand out on the fleet dashboard
+	stats["appMem"], stats["appGoroutines"], stats["appUptime"] = service.ProcessStats()
+	stats["xrayUptime"] = h.xrayService.GetXrayUptime()
+
 	respondSuccess(c, stats)
 }
 
+// GetConnectionCounts returns the established TCP connections per local port, so the
+// panel can attribute them to inbounds.
+// GET /api/v1/system/connections
+func (h *AgentHandlers) GetConnectionCounts(c *gin.Context) {
+	counts, err := sys.GetConnectionsByPort()
+	if err != nil {
+		logger.Error("Failed to get connection counts:", err)
+		respondError(c, "SYSTEM_ERROR", "Failed to get connection counts", http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, counts)
+}
+
 // GetLogs returns recent log entries.
 // GET /api/v1/logs
 func (h *AgentHandlers) GetLogs(c *gin.Context) {
@@ -579,9 +991,30 @@ func (h *AgentHandlers) GetLogs(c *gin.Context) {
 	respondSuccess(c, logs)
 }
 
-// readLogFile securely reads the last N lines from the agent log file.
-// Only reads from the configured log file path to prevent path traversal attacks.
-func (h *AgentHandlers) readLogFile(count int) ([]string, error) {
+// StreamLogs live-tails the agent log or the Xray logs over a WebSocket, sending each
+// line as JSON ({"source", "level", "text"}) and pinging every 30 seconds.
+// GET /api/v1/logs/stream?source=agent|xray|access&level=info&tail=100
+func (h *AgentHandlers) StreamLogs(c *gin.Context) {
+	req, err := service.ParseLogStreamRequest(c.Query("source"), c.Query("level"), c.Query("tail"))
+	if err != nil {
+		respondError(c, "INVALID_INPUT", err.Error(), http.StatusBadRequest)
+		return
+	}
+	logFile, err := agentLogFile()
+	if err != nil {
+		logger.Warning("Failed to stream log file:", err)
+		respondError(c, "LOG_READ_ERROR", "Unable to read logs", http.StatusInternalServerError)
+		return
+	}
+
+	service.ServeLogStream(c.Writer, c.Request, func(ctx context.Context, send func(*logtail.Line) error) error {
+		return service.FollowLogs(ctx, logFile, req, send)
+	})
+}
+
+// agentLogFile returns the configured agent log file (AGENT_LOG_FILE). Only paths in
+// the log directories of the agent are allowed, to prevent path traversal attacks.
+func agentLogFile() (string, error) {
 	logFile := os.Getenv("AGENT_LOG_FILE")
 	if logFile == "" {
 		logFile = "/var/log/x-ui-agent/agent.log"
@@ -609,7 +1042,17 @@ func (h *AgentHandlers) readLogFile(count int) ([]string, error) {
 	}
 
 	if !allowed {
-		return nil, fmt.Errorf("log file path not in allowlist: %s", logFile)
+		return "", fmt.Errorf("log file path not in allowlist: %s", logFile)
+	}
+	return logFile, nil
+}
+
+// readLogFile securely reads the last N lines from the agent log file.
+// Only reads from the configured log file path to prevent path traversal attacks.
+func (h *AgentHandlers) readLogFile(count int) ([]string, error) {
+	logFile, err := agentLogFile()
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if file exists
@@ -683,6 +1126,190 @@ func (h *AgentHandlers) UpdateGeoFiles(c *gin.Context) {
 	respondSuccess(c, gin.H{"success": true})
 }
 
+// GenerateKey generates UUIDs or key pairs with the agent's Xray binary.
+// GET /api/v1/xray/keys/:kind?sni=... (kind: uuid, x25519, mldsa65, mlkem768, vlessenc, ech, wireguard)
+func (h *AgentHandlers) GenerateKey(c *gin.Context) {
+	key, err := h.serverService.GenerateKey(c.Param("kind"), c.Query("sni"))
+	if err != nil {
+		logger.Error("Failed to generate key:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to generate key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, key)
+}
+
+// GetGeoFiles returns size, checksum and modification time of the installed geo files.
+// GET /api/v1/geofiles
+func (h *AgentHandlers) GetGeoFiles(c *gin.Context) {
+	files, err := h.serverService.GetGeofileInfo()
+	if err != nil {
+		logger.Error("Failed to read geo files:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to read geo files: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, files)
+}
+
+// InstallXray downloads, verifies and installs an Xray release.
+// POST /api/v1/xray/install[?async=true]
+// Body: {"version": "v25.10.15", "mirror": "https://..."} (mirror optional)
+// With async=true the install runs in the background and the response carries its task,
+// whose progress is polled with GET /api/v1/tasks/:id.
+func (h *AgentHandlers) InstallXray(c *gin.Context) {
+	var req struct {
+		Version string `json:"version"`
+		Mirror  string `json:"mirror"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version == "" {
+		respondError(c, "INVALID_INPUT", "Version is required", http.StatusBadRequest)
+		return
+	}
+
+	install := func(progress service.ProgressFunc) error {
+		if req.Mirror == "" {
+			return h.serverService.UpdateXrayWithProgress(req.Version, progress)
+		}
+		return h.serverService.UpdateXrayFromMirror(req.Version, req.Mirror, progress)
+	}
+
+	if c.Query("async") == "true" {
+		task := service.StartProgressTask("install_xray", install)
+		c.JSON(http.StatusAccepted, StandardResponse{
+			Success: true,
+			Data:    gin.H{"taskId": task.Id, "task": task},
+			TraceID: c.GetString("trace_id"),
+		})
+		return
+	}
+
+	if err := install(nil); err != nil {
+		logger.Error("Failed to install Xray:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to install Xray: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondSuccess(c, gin.H{"success": true, "version": req.Version})
+}
+
+// GetTask returns the progress of an operation started in the background.
+// GET /api/v1/tasks/:id
+func (h *AgentHandlers) GetTask(c *gin.Context) {
+	task, ok := service.GetProgressTask(c.Param("id"))
+	if !ok {
+		respondError(c, "NOT_FOUND", "Task not found", http.StatusNotFound)
+		return
+	}
+	respondSuccess(c, task)
+}
+
+// SyncBlockedIPs replaces the blocklist with the one pushed by the panel.
+// PUT /api/v1/blocklist
+func (h *AgentHandlers) SyncBlockedIPs(c *gin.Context) {
+	var entries []*model.BlockedIP
+	if err := c.ShouldBindJSON(&entries); err != nil {
+		respondError(c, "INVALID_INPUT", "Invalid blocklist: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changed, err := h.blockedIPs.ReplaceBlockedIPs(entries)
+	if err != nil {
+		logger.Error("Failed to update blocklist:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to update blocklist: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The agent has no restart job, so apply the new routing rule right away, through
+	// the routing API where possible so connections are not dropped
+	if changed && h.xrayService.IsXrayRunning() {
+		if err := h.xrayService.ApplyRoutingChange(); err != nil {
+			logger.Error("Failed to apply blocklist to Xray:", err)
+			respondError(c, "OPERATION_FAILED", "Failed to apply blocklist: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	respondSuccess(c, gin.H{"count": len(entries), "changed": changed})
+}
+
+// GetSettings returns the fleet settings applied on the agent and their version.
+// GET /api/v1/settings
+func (h *AgentHandlers) GetSettings(c *gin.Context) {
+	settings, err := h.fleetSettings.GetFleetSettings(nil)
+	if err != nil {
+		logger.Error("Failed to get settings:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to get settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondSuccess(c, settings)
+}
+
+// ApplySettings stores fleet settings pushed by the panel.
+// PUT /api/v1/settings
+func (h *AgentHandlers) ApplySettings(c *gin.Context) {
+	var req service.FleetSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, "INVALID_INPUT", "Invalid settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateFleetSettings(req.Settings); err != nil {
+		respondError(c, "INVALID_INPUT", "Invalid settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	xrayChanged, err := h.fleetSettings.ApplyFleetSettings(&req)
+	if err != nil {
+		logger.Error("Failed to apply settings:", err)
+		respondError(c, "OPERATION_FAILED", "Failed to apply settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The agent has no restart job, so apply a changed log level or DNS config right away
+	if xrayChanged && h.xrayService.IsXrayRunning() {
+		if err := h.xrayService.RestartXray(false); err != nil {
+			logger.Error("Failed to restart Xray after settings update:", err)
+			respondError(c, "OPERATION_FAILED", "Failed to restart Xray: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	logger.Infof("Applied fleet settings version %d", req.Version)
+	respondSuccess(c, gin.H{"version": req.Version, "xrayChanged": xrayChanged})
+}
+
+// RunTrafficCollector stores the Xray traffic counters in the agent database every
+// trafficCollectInterval seconds (default 10) until ctx is done. The panel reads usage
+// from the agent database, so this keeps up/down, all_time, limits and expiry current.
+// With an interval of 0 traffic is only collected on GET /api/v1/traffic.
+func RunTrafficCollector(ctx context.Context) {
+	h := NewAgentHandlers()
+	settingService := &service.SettingService{}
+	for {
+		interval, err := settingService.GetTrafficCollectInterval()
+		if err != nil {
+			logger.Warning("Invalid traffic collection interval:", err)
+		}
+		wait := time.Duration(interval) * time.Second
+		if interval <= 0 {
+			// Check again later in case the panel pushes an interval
+			wait = 30 * time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if interval > 0 && h.xrayService.IsXrayRunning() {
+			if _, _, err := h.flushTraffic(); err != nil {
+				logger.Warning("Failed to collect traffic:", err)
+			}
+		}
+	}
+}
+
 // openFirewallPorts opens firewall ports for all configured inbounds.
 // This ensures that when Xray restarts with new inbounds, the firewall allows traffic.
 func (h *AgentHandlers) openFirewallPorts() error {
@@ -2,48 +2,75 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net/http"
-	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/cofedish/3x-UI-agents/agent/config"
 	"github.com/cofedish/3x-UI-agents/agent/middleware"
 	"github.com/cofedish/3x-UI-agents/logger"
+	webmiddleware "github.com/cofedish/3x-UI-agents/web/middleware"
+	"github.com/cofedish/3x-UI-agents/web/service"
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter creates and configures the Gin router for agent API.
-func SetupRouter(cfg *config.AgentConfig) *gin.Engine {
+// The caller owns rateLimiter and stops it on shutdown. verifier checks client
+// certificates for mTLS authentication, keyring holds the JWT secrets and binding
+// restricts control routes to one controller; all may be nil.
+func SetupRouter(cfg *config.AgentConfig, rateLimiter *middleware.RateLimiter, verifier *middleware.ClientCertVerifier, keyring *middleware.JWTKeyring, binding *middleware.ControllerBinding) *gin.Engine {
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
+	service.RegisterValidators()
 
 	router := gin.New()
+	// Client IDs in paths may contain escaped slashes, e.g. trojan passwords
+	router.UseRawPath = true
+	// Agents are reached directly, so forwarding headers never name the client
+	if err := router.SetTrustedProxies(nil); err != nil {
+		logger.Warning("Failed to disable trusted proxies:", err)
+	}
 
 	// Global middleware
 	router.Use(gin.Recovery())
 	router.Use(middleware.TraceID())
 	router.Use(middleware.RequestLogger())
+	router.Use(webmiddleware.SecurityHeadersMiddleware(webmiddleware.SecurityHeaders{HSTSMaxAge: cfg.HSTSMaxAge}))
+	if len(cfg.CORSOrigins) > 0 {
+		// Before authentication, so preflight requests are answered without credentials
+		router.Use(webmiddleware.CORSMiddleware(cfg.CORSOrigins))
+	}
 
 	// Rate limiting
-	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit)
 	router.Use(rateLimiter.Middleware())
 
 	// Max body size (10MB)
 	router.Use(middleware.MaxBodySize(10 * 1024 * 1024))
 
-	// Authentication middleware
-	var authMiddleware gin.HandlerFunc
-	if cfg.AuthType == "mtls" {
-		authMiddleware = middleware.MTLSAuth(cfg.CAFile)
-	} else if cfg.AuthType == "jwt" {
-		authMiddleware = middleware.JWTAuth(cfg.JWTSecret)
+	// Authentication middleware, chosen by route class
+	readAuth := middleware.RouteAuth(cfg.ReadAuth, cfg.ReadSources, verifier, JWTConfig(cfg, keyring))
+	controlAuth := middleware.RouteAuth(cfg.ControlAuth, cfg.ControlSources, verifier, JWTConfig(cfg, keyring))
+	authMiddleware := func(c *gin.Context) {
+		if isControlRoute(c) {
+			controlAuth(c)
+		} else {
+			readAuth(c)
+		}
 	}
 
 	// Create handlers
 	handlers := NewAgentHandlers()
 
+	// limitGroup applies the configured rate limit of a route group, if any
+	limitGroup := func(group *gin.RouterGroup, name string) {
+		if limit, ok := cfg.RateLimitGroups[name]; ok {
+			group.Use(rateLimiter.Group(name, middleware.Limit{PerMinute: limit.PerMinute, Burst: limit.Burst}))
+		}
+	}
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
@@ -53,12 +80,26 @@ func SetupRouter(cfg *config.AgentConfig) *gin.Engine {
 		// Protected endpoints
 		protected := v1.Group("")
 		protected.Use(authMiddleware)
+		if binding != nil {
+			protected.Use(binding.Middleware(isControlRoute))
+		}
+		protected.Use(configEpochMiddleware())
 		{
 			// Server info
 			protected.GET("/info", handlers.Info)
 
+			// JWT secret rotation
+			protected.POST("/auth/rotate", RotateJWTSecret(keyring))
+
+			// Self-update from a signed release
+			protected.POST("/agent/update", UpdateAgent(cfg))
+
+			// Progress of operations started in the background
+			protected.GET("/tasks/:id", handlers.GetTask)
+
 			// Inbound management
 			inbounds := protected.Group("/inbounds")
+			limitGroup(inbounds, "inbounds")
 			{
 				inbounds.GET("", handlers.ListInbounds)
 				inbounds.GET("/:id", handlers.GetInbound)
@@ -68,28 +109,56 @@ func SetupRouter(cfg *config.AgentConfig) *gin.Engine {
 
 				// Client management
 				inbounds.POST("/:id/clients", handlers.AddClient)
+				inbounds.PUT("/:id/clients/:index", handlers.UpdateClient)
 				inbounds.DELETE("/:id/clients/:email", handlers.DeleteClient)
+				inbounds.POST("/:id/clients/:email/reset-traffic", handlers.ResetClientTraffic)
+				inbounds.POST("/:id/reset-traffic", handlers.ResetInboundTraffic)
 			}
 
 			// Traffic and stats
-			protected.GET("/traffic", handlers.GetTraffic)
-			protected.GET("/traffic/clients", handlers.GetClientTraffics)
-			protected.GET("/clients/online", handlers.GetOnlineClients)
+			traffic := protected.Group("")
+			limitGroup(traffic, "traffic")
+			{
+				traffic.GET("/traffic", handlers.GetTraffic)
+				traffic.GET("/traffic/clients", handlers.GetClientTraffics)
+				traffic.GET("/traffic/outbounds", handlers.GetOutboundTraffics)
+				traffic.GET("/clients/online", handlers.GetOnlineClients)
+				traffic.GET("/clients/online/details", handlers.GetOnlineClientDetails)
+			}
 
 			// Xray control
 			xrayGroup := protected.Group("/xray")
+			limitGroup(xrayGroup, "xray")
 			{
 				xrayGroup.POST("/start", handlers.StartXray)
 				xrayGroup.POST("/stop", handlers.StopXray)
 				xrayGroup.POST("/restart", handlers.RestartXray)
 				xrayGroup.GET("/version", handlers.GetXrayVersion)
 				xrayGroup.GET("/config", handlers.GetXrayConfig)
+				xrayGroup.GET("/capabilities", handlers.GetCapabilities)
+				xrayGroup.POST("/install", handlers.InstallXray)
+				xrayGroup.GET("/keys/:kind", handlers.GenerateKey)
+
+				// Xray API passthrough (runtime changes without restart)
+				xrayGroup.POST("/api/users", handlers.XrayAddUser)
+				xrayGroup.DELETE("/api/inbounds/:tag/users/:email", handlers.XrayRemoveUser)
+				xrayGroup.GET("/api/stats", handlers.XrayQueryStats)
 			}
 
 			// System operations
-			protected.GET("/system/stats", handlers.GetSystemStats)
-			protected.GET("/logs", handlers.GetLogs)
-			protected.POST("/geofiles/update", handlers.UpdateGeoFiles)
+			system := protected.Group("")
+			limitGroup(system, "system")
+			{
+				system.GET("/system/stats", handlers.GetSystemStats)
+				system.GET("/system/connections", handlers.GetConnectionCounts)
+				system.GET("/logs", handlers.GetLogs)
+				system.GET("/logs/stream", handlers.StreamLogs)
+				system.GET("/geofiles", handlers.GetGeoFiles)
+				system.POST("/geofiles/update", handlers.UpdateGeoFiles)
+				system.PUT("/blocklist", handlers.SyncBlockedIPs)
+				system.GET("/settings", handlers.GetSettings)
+				system.PUT("/settings", handlers.ApplySettings)
+			}
 		}
 	}
 
@@ -105,43 +174,152 @@ func SetupRouter(cfg *config.AgentConfig) *gin.Engine {
 	return router
 }
 
-// StartServer starts the agent API server with TLS.
-func StartServer(cfg *config.AgentConfig, router *gin.Engine) error {
-	logger.Info(fmt.Sprintf("Starting 3x-ui Agent API on %s", cfg.ListenAddr))
-	logger.Info(fmt.Sprintf("Auth type: %s", cfg.AuthType))
+// controlReads are read-only routes in the control class, since they expose client
+// credentials (the Xray config, inbounds with their clients) or panel settings.
+var controlReads = map[string]bool{
+	"/api/v1/xray/config":  true,
+	"/api/v1/inbounds":     true,
+	"/api/v1/inbounds/:id": true,
+	"/api/v1/settings":     true,
+}
+
+// isControlRoute reports whether a request belongs to the control class: every
+// request that changes state, plus controlReads. All other requests are reads.
+func isControlRoute(c *gin.Context) bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return true
+	}
+	return controlReads[c.FullPath()]
+}
+
+// JWTConfig returns the bearer token validation settings of the agent, verifying tokens
+// with the secrets of keyring.
+func JWTConfig(cfg *config.AgentConfig, keyring *middleware.JWTKeyring) middleware.JWTConfig {
+	return middleware.JWTConfig{
+		Keyring:     keyring,
+		Audience:    cfg.JWTAudience,
+		ClockSkew:   time.Duration(cfg.JWTClockSkew) * time.Second,
+		MaxTTL:      time.Duration(cfg.JWTMaxTTL) * time.Second,
+		AllowStatic: cfg.JWTAllowStatic,
+	}
+}
+
+// Server is the agent API server. Its router and TLS settings can be replaced while it
+// runs, which applies to new requests and handshakes without dropping connections.
+type Server struct {
+	cfg       *config.AgentConfig
+	router    atomic.Pointer[gin.Engine]
+	tlsConfig atomic.Pointer[tls.Config]
+}
+
+// NewServer creates the agent API server with TLS for cfg. With mTLS, client
+// certificates rejected by verifier fail the handshake.
+func NewServer(cfg *config.AgentConfig, router *gin.Engine, verifier *middleware.ClientCertVerifier) (*Server, error) {
+	s := &Server{cfg: cfg}
+	if err := s.Reload(cfg, router, verifier); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads the certificates of cfg again and switches to router and verifier. The
+// listen address and shutdown timeout are kept. On error nothing is changed.
+func (s *Server) Reload(cfg *config.AgentConfig, router *gin.Engine, verifier *middleware.ClientCertVerifier) error {
+	var tlsConfig *tls.Config
+	var err error
+	if cfg.UsesAuth("mtls") {
+		tlsConfig, err = newMTLSConfig(cfg, verifier)
+	} else {
+		tlsConfig, err = newTLSConfig(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	// Offered by the server itself only if handshakes used its own TLS settings
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	s.tlsConfig.Store(tlsConfig)
+	s.router.Store(router)
+	return nil
+}
+
+// ServeHTTP passes requests to the current router.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.Load().ServeHTTP(w, r)
+}
+
+// Run serves until ctx is cancelled. It then stops accepting connections and gives
+// in-flight requests up to cfg.ShutdownTimeout seconds to finish before closing them.
+func (s *Server) Run(ctx context.Context) error {
+	logger.Info(fmt.Sprintf("Starting 3x-ui Agent API on %s", s.cfg.ListenAddr))
+	logger.Info(fmt.Sprintf("Auth type: %s", s.cfg.AuthType))
+	if tlsConfig := s.tlsConfig.Load(); tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		logger.Info("Starting mTLS server (TLS 1.3 + client certificate required)...")
+	} else if tlsConfig.ClientAuth == tls.VerifyClientCertIfGiven {
+		logger.Info("Starting mTLS server (TLS 1.3 + client certificate optional for JWT routes)...")
+	} else {
+		logger.Info("Starting HTTPS server...")
+	}
 
-	if cfg.AuthType == "mtls" {
-		// Start with mTLS
-		return startTLSServer(cfg, router)
+	server := &http.Server{
+		Addr:    s.cfg.ListenAddr,
+		Handler: s,
+		TLSConfig: &tls.Config{
+			// Handshakes use the TLS settings of the last reload
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.tlsConfig.Load(), nil
+			},
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return &s.tlsConfig.Load().Certificates[0], nil
+			},
+		},
 	}
 
-	// Start with regular HTTPS (JWT auth)
-	return startHTTPSServer(cfg, router)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down agent API, draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warning("Agent API did not drain in time:", err)
+		return server.Close()
+	}
+	return nil
 }
 
-// startTLSServer starts server with mTLS.
-func startTLSServer(cfg *config.AgentConfig, router *gin.Engine) error {
+// newMTLSConfig creates the TLS settings for mTLS.
+func newMTLSConfig(cfg *config.AgentConfig, verifier *middleware.ClientCertVerifier) (*tls.Config, error) {
 	// Load server certificate
 	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 	if err != nil {
-		return fmt.Errorf("failed to load server certificate: %w", err)
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
 	}
 
 	// Load CA certificate for client verification
-	caCert, err := os.ReadFile(cfg.CAFile)
+	caCertPool, err := middleware.LoadCAPool(cfg.CAFile)
 	if err != nil {
-		return fmt.Errorf("failed to load CA certificate: %w", err)
+		return nil, err
 	}
 
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		return fmt.Errorf("failed to parse CA certificate")
+	// Demand client certificates in the handshake unless some routes accept JWT;
+	// certificates that are sent are still verified against the CA
+	clientAuth := tls.RequireAndVerifyClientCert
+	if !cfg.ClientCertRequired() {
+		clientAuth = tls.VerifyClientCertIfGiven
 	}
 
 	// Configure TLS with client certificate requirement
-	tlsConfig := &tls.Config{
+	return &tls.Config{
 		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientAuth:   clientAuth,
 		ClientCAs:    caCertPool,
 		MinVersion:   tls.VersionTLS13,
 		CipherSuites: []uint16{
@@ -149,39 +327,26 @@ func startTLSServer(cfg *config.AgentConfig, router *gin.Engine) error {
 			tls.TLS_AES_256_GCM_SHA384,
 			tls.TLS_CHACHA20_POLY1305_SHA256,
 		},
-	}
-
-	// Create server
-	server := &http.Server{
-		Addr:      cfg.ListenAddr,
-		Handler:   router,
-		TLSConfig: tlsConfig,
-	}
-
-	logger.Info("Starting mTLS server (TLS 1.3 + client certificate required)...")
-	return server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		// Reject disallowed or revoked client certificates during the handshake
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 {
+				return nil
+			}
+			return verifier.Verify(cs.VerifiedChains[0])
+		},
+	}, nil
 }
 
-// startHTTPSServer starts server with HTTPS (for JWT auth).
-func startHTTPSServer(cfg *config.AgentConfig, router *gin.Engine) error {
-	logger.Info("Starting HTTPS server...")
-
+// newTLSConfig creates the TLS settings for HTTPS (for JWT auth).
+func newTLSConfig(cfg *config.AgentConfig) (*tls.Config, error) {
 	// For JWT, we still use TLS but without client cert verification
 	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 	if err != nil {
-		return fmt.Errorf("failed to load server certificate: %w", err)
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
 	}
 
-	tlsConfig := &tls.Config{
+	return &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   tls.VersionTLS13,
-	}
-
-	server := &http.Server{
-		Addr:      cfg.ListenAddr,
-		Handler:   router,
-		TLSConfig: tlsConfig,
-	}
-
-	return server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+	}, nil
 }
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/config"
+	"github.com/cofedish/3x-UI-agents/agent/middleware"
+)
+
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+// issue signs a leaf certificate for name and returns it with its serial number.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (tls.Certificate, *big.Int) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, template.SerialNumber
+}
+
+func writePem(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake runs a TLS handshake between a client presenting clientCert and a server
+// using serverConfig, and returns the server side error.
+func handshake(t *testing.T, serverConfig *tls.Config, ca *testCA, clientCert tls.Certificate) error {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go func() {
+		client := tls.Client(clientConn, &tls.Config{
+			RootCAs:    roots,
+			ServerName: "127.0.0.1",
+			// Send the certificate even if the server does not list its issuer
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &clientCert, nil
+			},
+		})
+		// TLS 1.3 clients finish before the server has checked their certificate,
+		// so read to let the server's verdict arrive
+		if client.Handshake() == nil {
+			client.Read(make([]byte, 1))
+		}
+		clientConn.Close()
+	}()
+
+	server := tls.Server(serverConn, serverConfig)
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	return server.Handshake()
+}
+
+func TestNewMTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "agent CA")
+	other := newTestCA(t, "other CA")
+
+	serverCert, _ := ca.issue(t, "agent", x509.ExtKeyUsageServerAuth)
+	serverKey, err := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.AgentConfig{
+		CertFile:    filepath.Join(dir, "agent.crt"),
+		KeyFile:     filepath.Join(dir, "agent.key"),
+		CAFile:      filepath.Join(dir, "ca.crt"),
+		ReadAuth:    []string{"mtls"},
+		ControlAuth: []string{"mtls"},
+	}
+	writePem(t, cfg.CertFile, "CERTIFICATE", serverCert.Certificate[0])
+	writePem(t, cfg.KeyFile, "PRIVATE KEY", serverKey)
+	writePem(t, cfg.CAFile, "CERTIFICATE", ca.cert.Raw)
+
+	controller, _ := ca.issue(t, "controller", x509.ExtKeyUsageClientAuth)
+	revoked, revokedSerial := ca.issue(t, "controller", x509.ExtKeyUsageClientAuth)
+	wrongName, _ := ca.issue(t, "panel", x509.ExtKeyUsageClientAuth)
+	unknownCA, _ := other.issue(t, "controller", x509.ExtKeyUsageClientAuth)
+
+	policy, err := middleware.NewCertPolicy([]string{"controller"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation, err := middleware.NewRevocationChecker("", []string{revokedSerial.Text(16)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := newMTLSConfig(cfg, &middleware.ClientCertVerifier{Policy: policy, Revocation: revocation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("expected client certificates to be required, got %v", tlsConfig.ClientAuth)
+	}
+
+	tests := []struct {
+		name   string
+		cert   tls.Certificate
+		accept bool
+	}{
+		{"valid certificate", controller, true},
+		{"unknown CA", unknownCA, false},
+		{"revoked certificate", revoked, false},
+		{"wrong name", wrongName, false},
+		{"no certificate", tls.Certificate{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshake(t, tlsConfig, ca, tt.cert)
+			if tt.accept && err != nil {
+				t.Fatalf("expected the handshake to succeed, got %v", err)
+			}
+			if !tt.accept && err == nil {
+				t.Fatal("expected the handshake to fail")
+			}
+		})
+	}
+}
+
+func TestNewMTLSConfigWithJWTRoutes(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "agent CA")
+	serverCert, _ := ca.issue(t, "agent", x509.ExtKeyUsageServerAuth)
+	serverKey, err := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.AgentConfig{
+		CertFile:    filepath.Join(dir, "agent.crt"),
+		KeyFile:     filepath.Join(dir, "agent.key"),
+		CAFile:      filepath.Join(dir, "ca.crt"),
+		ReadAuth:    []string{"jwt"},
+		ControlAuth: []string{"mtls"},
+	}
+	writePem(t, cfg.CertFile, "CERTIFICATE", serverCert.Certificate[0])
+	writePem(t, cfg.KeyFile, "PRIVATE KEY", serverKey)
+	writePem(t, cfg.CAFile, "CERTIFICATE", ca.cert.Raw)
+
+	tlsConfig, err := newMTLSConfig(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Fatalf("expected client certificates to be optional, got %v", tlsConfig.ClientAuth)
+	}
+	if err := handshake(t, tlsConfig, ca, tls.Certificate{}); err != nil {
+		t.Fatalf("expected a handshake without a certificate to succeed, got %v", err)
+	}
+	unknownCA, _ := newTestCA(t, "other CA").issue(t, "controller", x509.ExtKeyUsageClientAuth)
+	if err := handshake(t, tlsConfig, ca, unknownCA); err == nil {
+		t.Fatal("expected a certificate from an unknown CA to be rejected")
+	}
+}
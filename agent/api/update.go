@@ -0,0 +1,327 @@
+package api
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/config"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// agentReleaseBinary is the path of the agent binary in a release archive.
+	agentReleaseBinary = "x-ui/x-ui"
+	// maxAgentReleaseSize caps the size of a downloaded release archive.
+	maxAgentReleaseSize = 512 << 20
+	// agentDownloadTimeout bounds downloading a release archive.
+	agentDownloadTimeout = 10 * time.Minute
+	// agentRestartDelay leaves the panel time to see the update finish before the
+	// agent restarts.
+	agentRestartDelay = 5 * time.Second
+)
+
+// agentVersionPattern matches release versions such as "v2.8.5".
+var agentVersionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+$`)
+
+// UpdateAgent downloads, verifies and installs an agent release, then restarts the agent
+// through systemd. The release checksum (<url>.sha256) must be signed with the key of
+// AGENT_UPDATE_PUBLIC_KEY (<url>.sha256.sig); the replaced binary is kept as <binary>.old.
+// POST /api/v1/agent/update[?async=true]
+// Body: {"version": "v2.8.5", "url": "https://.../{version}/x-ui-linux-{arch}.tar.gz"}
+// With async=true the update runs in the background and the response carries its task,
+// whose progress is polled with GET /api/v1/tasks/:id.
+func UpdateAgent(cfg *config.AgentConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.UpdatePublicKey == "" {
+			respondError(c, "UPDATE_NOT_ENABLED", "Self-update requires AGENT_UPDATE_PUBLIC_KEY", http.StatusConflict)
+			return
+		}
+
+		var req struct {
+			Version string `json:"version" binding:"required"`
+			URL     string `json:"url" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondInvalid(c, err)
+			return
+		}
+		if !agentVersionPattern.MatchString(req.Version) {
+			respondError(c, "INVALID_INPUT", "Invalid version: "+req.Version, http.StatusBadRequest)
+			return
+		}
+		version := "v" + strings.TrimPrefix(req.Version, "v")
+		arch, err := releaseArch()
+		if err != nil {
+			respondError(c, "UPDATE_NOT_SUPPORTED", err.Error(), http.StatusConflict)
+			return
+		}
+		archiveURL := strings.NewReplacer("{version}", version, "{arch}", arch).Replace(req.URL)
+		if u, err := url.Parse(archiveURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			respondError(c, "INVALID_INPUT", "The release URL must be an https URL", http.StatusBadRequest)
+			return
+		}
+
+		update := func(progress service.ProgressFunc) error {
+			if err := installAgentRelease(cfg, version, archiveURL, progress); err != nil {
+				logger.Error("Failed to update agent:", err)
+				return err
+			}
+			logger.Infof("Agent %s installed, restarting %s", version, cfg.ServiceName)
+			restartAgentService(cfg.ServiceName)
+			return nil
+		}
+
+		if c.Query("async") == "true" {
+			task := service.StartProgressTask("update_agent", update)
+			c.JSON(http.StatusAccepted, StandardResponse{
+				Success: true,
+				Data:    gin.H{"taskId": task.Id, "task": task},
+				TraceID: c.GetString("trace_id"),
+			})
+			return
+		}
+
+		if err := update(nil); err != nil {
+			respondError(c, "OPERATION_FAILED", "Failed to update agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondSuccess(c, gin.H{"success": true, "version": version})
+	}
+}
+
+// installAgentRelease downloads the release archive at archiveURL, verifies its signed
+// checksum and replaces the running binary with the one in the archive once it reports
+// version. progress, if set, is told the percentage done and the current stage.
+func installAgentRelease(cfg *config.AgentConfig, version, archiveURL string, progress service.ProgressFunc) error {
+	if progress == nil {
+		progress = func(int, string) {}
+	}
+	key, err := cfg.UpdateKey()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentDownloadTimeout)
+	defer cancel()
+
+	// 1. The checksum file is what is signed
+	progress(0, "verifying")
+	sums, err := fetchReleaseFile(ctx, archiveURL+".sha256", 64<<10)
+	if err != nil {
+		return fmt.Errorf("failed to get checksum: %w", err)
+	}
+	sig, err := fetchReleaseFile(ctx, archiveURL+".sha256.sig", 1<<10)
+	if err != nil {
+		return fmt.Errorf("failed to get signature: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		// Accept a base64 signature too
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+			sig = decoded
+		}
+	}
+	if !ed25519.Verify(key, sums, sig) {
+		return errors.New("invalid signature of the release checksum")
+	}
+	expected, err := releaseDigest(sums, filepath.Base(archiveURL))
+	if err != nil {
+		return err
+	}
+
+	// 2. Download the archive next to the binary, so it can be renamed into place
+	progress(5, "downloading")
+	archive, err := os.CreateTemp(filepath.Dir(exe), ".x-ui-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := downloadRelease(ctx, archiveURL, archive, expected); err != nil {
+		return err
+	}
+
+	// 3. Extract the binary and make sure it runs and is the requested version
+	progress(70, "installing")
+	next := exe + ".new"
+	if err := extractAgentBinary(archive, next); err != nil {
+		os.Remove(next)
+		return err
+	}
+	out, err := exec.CommandContext(ctx, next, "-v").Output()
+	if err != nil {
+		os.Remove(next)
+		return fmt.Errorf("the new binary does not run: %w", err)
+	}
+	if got := strings.TrimSpace(string(out)); strings.TrimPrefix(got, "v") != strings.TrimPrefix(version, "v") {
+		os.Remove(next)
+		return fmt.Errorf("the release contains version %s instead of %s", got, version)
+	}
+
+	// 4. Keep the running binary as .old and move the new one into place
+	if err := os.Rename(exe, exe+".old"); err != nil {
+		os.Remove(next)
+		return err
+	}
+	if err := os.Rename(next, exe); err != nil {
+		os.Rename(exe+".old", exe)
+		os.Remove(next)
+		return err
+	}
+	progress(95, "restarting")
+	return nil
+}
+
+// fetchReleaseFile downloads a small file of a release, at most limit bytes.
+func fetchReleaseFile(ctx context.Context, fileURL string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", fileURL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// releaseDigest returns the SHA256 digest of file from sha256sum output ("<hex>  <file>"
+// lines). A single line without a file name applies to any file.
+func releaseDigest(sums []byte, file string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(string(sums)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+		if len(fields) == 1 || strings.TrimPrefix(fields[1], "*") == file {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no SHA256 digest for %s in the release checksum", file)
+}
+
+// downloadRelease writes the archive at archiveURL to file and checks its SHA256 digest.
+func downloadRelease(ctx context.Context, archiveURL string, file *os.File, expected string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", archiveURL, resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, maxAgentReleaseSize)); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+// extractAgentBinary writes the agent binary of a release archive to path.
+func extractAgentBinary(archive io.Reader, path string) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s not found in the release archive", agentReleaseBinary)
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || strings.TrimPrefix(header.Name, "./") != agentReleaseBinary {
+			continue
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, reader); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+}
+
+// releaseArch returns the platform name of this build in release archive names, e.g.
+// "amd64" or "armv7".
+func releaseArch() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("self-update is only supported on Linux")
+	}
+	switch runtime.GOARCH {
+	case "amd64", "arm64", "386", "s390x":
+		return runtime.GOARCH, nil
+	case "arm":
+		goarm := "7"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "GOARM" && setting.Value != "" {
+					goarm = setting.Value
+				}
+			}
+		}
+		return "armv" + strings.TrimSuffix(goarm, ",softfloat"), nil
+	}
+	return "", fmt.Errorf("no agent releases for %s", runtime.GOARCH)
+}
+
+// restartAgentService restarts the agent's systemd unit after agentRestartDelay. The
+// agent shuts down gracefully on the SIGTERM systemd sends.
+func restartAgentService(name string) {
+	go func() {
+		time.Sleep(agentRestartDelay)
+		if out, err := exec.Command("systemctl", "restart", name).CombinedOutput(); err != nil {
+			logger.Errorf("Failed to restart %s after the update: %v %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
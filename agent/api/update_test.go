@@ -0,0 +1,75 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/agent/config"
+)
+
+// TestInstallAgentReleaseSignature checks that a release is only downloaded once the
+// checksum file carries a valid signature of the update key.
+func TestInstallAgentReleaseSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.AgentConfig{UpdatePublicKey: base64.StdEncoding.EncodeToString(der)}
+
+	// The archive never matches its digest, so a release that passes the signature check
+	// fails on the download instead of replacing the test binary
+	digest := sha256.Sum256([]byte("signed archive"))
+	sums := []byte(hex.EncodeToString(digest[:]) + "  x-ui-linux-amd64.tar.gz\n")
+	signature := ed25519.Sign(private, sums)
+
+	tests := []struct {
+		name    string
+		sums    []byte
+		sig     []byte
+		wantErr string
+	}{
+		{"valid signature", sums, signature, "checksum mismatch"},
+		{"valid base64 signature", sums, []byte(base64.StdEncoding.EncodeToString(signature) + "\n"), "checksum mismatch"},
+		{"other key", sums, ed25519.Sign(otherKey, sums), "invalid signature"},
+		{"truncated signature", sums, signature[:ed25519.SignatureSize/2], "invalid signature"},
+		{"truncated base64 signature", sums, []byte(base64.StdEncoding.EncodeToString(signature)[:40]), "invalid signature"},
+		{"empty signature", sums, nil, "invalid signature"},
+		{"tampered checksum", []byte(strings.Replace(string(sums), string(sums[0]), "f", 1) + "extra\n"), signature, "invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, ".sha256"):
+					w.Write(tt.sums)
+				case strings.HasSuffix(r.URL.Path, ".sha256.sig"):
+					w.Write(tt.sig)
+				default:
+					w.Write([]byte("tampered archive"))
+				}
+			}))
+			defer server.Close()
+
+			err := installAgentRelease(cfg, "v2.8.5", server.URL+"/x-ui-linux-amd64.tar.gz", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// controllerIdentityKey is the setting holding the controller the agent is bound to.
+const controllerIdentityKey = "agentControllerIdentity"
+
+// settingBindingStore keeps the bound controller identity in the agent's settings table.
+type settingBindingStore struct{}
+
+func (settingBindingStore) Load() (string, error) {
+	var setting model.Setting
+	err := database.GetDB().Model(model.Setting{}).Where("key = ?", controllerIdentityKey).First(&setting).Error
+	if database.IsNotFound(err) {
+		return "", nil
+	}
+	return setting.Value, err
+}
+
+func (settingBindingStore) Save(identity string) error {
+	db := database.GetDB()
+	var setting model.Setting
+	err := db.Model(model.Setting{}).Where("key = ?", controllerIdentityKey).First(&setting).Error
+	if err != nil && !database.IsNotFound(err) {
+		return err
+	}
+	setting.Key = controllerIdentityKey
+	setting.Value = identity
+	return db.Save(&setting).Error
+}
+
+// reset removes the binding, so the next controller to manage the agent is recorded.
+func (settingBindingStore) reset() error {
+	return database.GetDB().Where("key = ?", controllerIdentityKey).Delete(model.Setting{}).Error
+}
+
+// jwtSecretKey is the setting holding the JWT secret set by the last rotation.
+const jwtSecretKey = "agentJwtSecret"
+
+// settingSecretStore keeps the rotated JWT secret in the agent's settings table.
+type settingSecretStore struct{}
+
+func (settingSecretStore) Load() (string, error) {
+	var setting model.Setting
+	err := database.GetDB().Model(model.Setting{}).Where("key = ?", jwtSecretKey).First(&setting).Error
+	if database.IsNotFound(err) {
+		return "", nil
+	}
+	return setting.Value, err
+}
+
+func (settingSecretStore) Save(secret string) error {
+	db := database.GetDB()
+	var setting model.Setting
+	err := db.Model(model.Setting{}).Where("key = ?", jwtSecretKey).First(&setting).Error
+	if err != nil && !database.IsNotFound(err) {
+		return err
+	}
+	setting.Key = jwtSecretKey
+	setting.Value = secret
+	return db.Save(&setting).Error
+}
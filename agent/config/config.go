@@ -2,10 +2,21 @@
 package config
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/util/jwt"
+	"github.com/cofedish/3x-UI-agents/util/secrets"
 )
 
 // AgentConfig holds all configuration for the agent.
@@ -17,14 +28,47 @@ type AgentConfig struct {
 	Tags       []string
 
 	// Controller settings
-	ControllerEndpoint string
+	ControllerEndpoint string // panel URL including its base path, used for enrollment
+
+	// Enrollment: a one-time token from the panel that registers the agent on first start
+	EnrollToken    string
+	PublicEndpoint string // endpoint the panel reaches the agent at, empty = the address it registered from
 
 	// Authentication
 	AuthType  string // "mtls" or "jwt"
 	CertFile  string
 	KeyFile   string
 	CAFile    string
-	JWTSecret string
+	JWTSecret string // value or secret reference ("env:", "file:", "vault:")
+
+	// JWT validation: tokens must be HS256-signed with JWTSecret, carry exp and iat and
+	// name JWTAudience in aud
+	JWTAudience    string
+	JWTClockSkew   int  // seconds of clock difference tolerated for exp, iat and nbf
+	JWTMaxTTL      int  // seconds a token may be valid for (exp - iat), 0 = unlimited
+	JWTAllowStatic bool // also accept the secret itself as a bearer token (legacy panels)
+
+	// Route classes: read routes (info, stats, logs) and control routes (inbound
+	// mutation, Xray start/stop/install, blocklist) may require different auth
+	// methods and source networks
+	ReadAuth       []string     // "mtls" and/or "jwt", default AuthType
+	ControlAuth    []string     // "mtls" and/or "jwt", default AuthType
+	ReadSources    []*net.IPNet // networks allowed to call read routes, empty = any
+	ControlSources []*net.IPNet // networks allowed to call control routes, empty = any
+
+	// Controller binding: "tofu" binds to the first controller that calls a control
+	// route, "reset" forgets the bound controller and binds again, "off" disables it
+	ControllerBinding  string
+	ControllerIdentity string // pinned controller identity ("spki:<hex>")
+
+	// Client certificate policy and revocation (mTLS)
+	AllowedClientNames []string // CN/SAN patterns a client certificate must match, empty = any
+	CRLFile            string   // PEM or DER CRL issued by the CA, reloaded when it changes
+	CertDenylist       []string // revoked serial numbers or SHA-256 fingerprints (hex)
+
+	// Browser access
+	CORSOrigins []string // origins allowed to call the API cross-origin, "*" = any
+	HSTSMaxAge  int      // Strict-Transport-Security max-age in seconds, 0 = disabled
 
 	// Xray settings
 	XrayBinFolder    string
@@ -36,8 +80,28 @@ type AgentConfig struct {
 
 	// Performance
 	MaxConcurrentRequests int
-	RequestTimeout        int // seconds
-	RateLimit             int // requests per minute
+	RequestTimeout        int                   // seconds
+	RateLimit             int                   // requests per minute
+	RateLimitBurst        int                   // requests allowed at once, 0 = RateLimit
+	RateLimitGroups       map[string]GroupLimit // limits of single route groups
+	RateLimitIdentities   map[string]int        // per-identity limits, e.g. "cn:3x-ui-controller" -> 600
+	ShutdownTimeout       int                   // seconds in-flight requests get to finish on shutdown
+
+	// Self-update: release checksums must be signed with UpdatePublicKey, and the agent
+	// restarts through the systemd unit ServiceName after installing a release
+	UpdatePublicKey string // ed25519 public key, PEM or base64 DER, or a secret reference; empty = updates disabled
+	ServiceName     string
+
+	// Reload: SIGHUP reads this file of KEY=VALUE lines into the environment before the
+	// configuration is loaded again, since a service manager only sets it at start
+	EnvFile string
+}
+
+// GroupLimit is the rate limit of one API route group ("inbounds", "traffic", "xray"
+// or "system"), applied per caller in addition to the global limit.
+type GroupLimit struct {
+	PerMinute int
+	Burst     int // 0 = PerMinute
 }
 
 // LoadConfig loads agent configuration from environment variables.
@@ -49,11 +113,22 @@ func LoadConfig() (*AgentConfig, error) {
 		ServerName:            getEnv("AGENT_SERVER_NAME", ""),
 		Tags:                  parseTags(getEnv("AGENT_TAGS", "")),
 		ControllerEndpoint:    getEnv("AGENT_CONTROLLER_ENDPOINT", ""),
+		EnrollToken:           getEnv("AGENT_ENROLL_TOKEN", ""),
+		PublicEndpoint:        getEnv("AGENT_PUBLIC_ENDPOINT", ""),
 		AuthType:              getEnv("AGENT_AUTH_TYPE", "mtls"),
 		CertFile:              getEnv("AGENT_CERT_FILE", "/etc/x-ui-agent/certs/agent.crt"),
 		KeyFile:               getEnv("AGENT_KEY_FILE", "/etc/x-ui-agent/certs/agent.key"),
 		CAFile:                getEnv("AGENT_CA_FILE", "/etc/x-ui-agent/certs/ca.crt"),
 		JWTSecret:             getEnv("AGENT_JWT_SECRET", ""),
+		JWTAudience:           getEnv("AGENT_JWT_AUDIENCE", jwt.DefaultAudience),
+		JWTClockSkew:          getEnvInt("AGENT_JWT_CLOCK_SKEW", 60),
+		JWTMaxTTL:             getEnvInt("AGENT_JWT_MAX_TTL", 3600),
+		JWTAllowStatic:        getEnv("AGENT_JWT_ALLOW_STATIC", "false") == "true",
+		AllowedClientNames:    parseTags(getEnv("AGENT_ALLOWED_CLIENT_NAMES", "")),
+		CRLFile:               getEnv("AGENT_CRL_FILE", ""),
+		CertDenylist:          parseTags(getEnv("AGENT_CERT_DENYLIST", "")),
+		CORSOrigins:           parseTags(getEnv("AGENT_CORS_ORIGINS", "")),
+		HSTSMaxAge:            getEnvInt("AGENT_HSTS_MAX_AGE", 31536000),
 		XrayBinFolder:         getEnv("XRAY_BIN_FOLDER", "/usr/local/x-ui/bin"),
 		XrayConfigFolder:      getEnv("XRAY_CONFIG_FOLDER", "/etc/x-ui"),
 		LogLevel:              getEnv("AGENT_LOG_LEVEL", "info"),
@@ -61,6 +136,43 @@ func LoadConfig() (*AgentConfig, error) {
 		MaxConcurrentRequests: getEnvInt("AGENT_MAX_CONCURRENT", 50),
 		RequestTimeout:        getEnvInt("AGENT_REQUEST_TIMEOUT", 30),
 		RateLimit:             getEnvInt("AGENT_RATE_LIMIT", 100),
+		RateLimitIdentities:   parseLimits(getEnv("AGENT_RATE_LIMIT_IDENTITIES", "")),
+		RateLimitBurst:        getEnvInt("AGENT_RATE_LIMIT_BURST", 0),
+		RateLimitGroups:       parseGroupLimits(getEnv("AGENT_RATE_LIMIT_GROUPS", "")),
+		ShutdownTimeout:       getEnvInt("AGENT_SHUTDOWN_TIMEOUT", 15),
+		ControllerIdentity:    getEnv("AGENT_CONTROLLER_IDENTITY", ""),
+		EnvFile:               getEnv("AGENT_ENV_FILE", ""),
+		UpdatePublicKey:       getEnv("AGENT_UPDATE_PUBLIC_KEY", ""),
+		ServiceName:           getEnv("AGENT_SERVICE_NAME", "x-ui-agent"),
+	}
+
+	cfg.ReadAuth = parseTags(getEnv("AGENT_READ_AUTH", cfg.AuthType))
+	cfg.ControlAuth = parseTags(getEnv("AGENT_CONTROL_AUTH", cfg.AuthType))
+	// Binding identifies controllers by their client certificate, so it is on by default
+	// only when control routes require mTLS
+	defaultBinding := "off"
+	if slices.Equal(cfg.ControlAuth, []string{"mtls"}) {
+		defaultBinding = "tofu"
+	}
+	cfg.ControllerBinding = getEnv("AGENT_CONTROLLER_BINDING", defaultBinding)
+	var err error
+	if cfg.ReadSources, err = parseCIDRs(getEnv("AGENT_READ_CIDRS", "")); err != nil {
+		return nil, err
+	}
+	if cfg.ControlSources, err = parseCIDRs(getEnv("AGENT_CONTROL_CIDRS", "")); err != nil {
+		return nil, err
+	}
+
+	// The JWT secret may be a reference to a secret store, e.g. "vault:secret/data/agent#jwt"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	jwtSecret, err := secrets.Resolve(ctx, cfg.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+	cfg.JWTSecret = jwtSecret
+	if cfg.UpdatePublicKey, err = secrets.Resolve(ctx, cfg.UpdatePublicKey); err != nil {
+		return nil, err
 	}
 
 	// Validate
@@ -71,21 +183,100 @@ func LoadConfig() (*AgentConfig, error) {
 	return cfg, nil
 }
 
+// LoadEnvFile sets the variables of an environment file, such as the EnvironmentFile of
+// the systemd unit: KEY=VALUE lines, optionally quoted or prefixed with "export".
+// Blank lines and lines starting with # are skipped.
+func LoadEnvFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read environment file: %w", err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid line %d in environment file %s", i+1, path)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestartRequired returns the settings that differ between c and next but only take
+// effect when the agent is restarted.
+func (c *AgentConfig) RestartRequired(next *AgentConfig) []string {
+	var changed []string
+	if c.ListenAddr != next.ListenAddr {
+		changed = append(changed, "AGENT_LISTEN_ADDR")
+	}
+	if c.JWTSecret != next.JWTSecret {
+		changed = append(changed, "AGENT_JWT_SECRET")
+	}
+	if c.ControllerBinding != next.ControllerBinding || c.ControllerIdentity != next.ControllerIdentity {
+		changed = append(changed, "AGENT_CONTROLLER_BINDING")
+	}
+	if c.ShutdownTimeout != next.ShutdownTimeout {
+		changed = append(changed, "AGENT_SHUTDOWN_TIMEOUT")
+	}
+	return changed
+}
+
 // Validate checks if configuration is valid.
 func (c *AgentConfig) Validate() error {
 	if c.AuthType != "mtls" && c.AuthType != "jwt" {
 		return fmt.Errorf("invalid auth type: %s (must be 'mtls' or 'jwt')", c.AuthType)
 	}
 
-	if c.AuthType == "mtls" {
+	for _, method := range append(append([]string{}, c.ReadAuth...), c.ControlAuth...) {
+		if method != "mtls" && method != "jwt" {
+			return fmt.Errorf("invalid route auth method: %s (must be 'mtls' or 'jwt')", method)
+		}
+	}
+	if len(c.ReadAuth) == 0 || len(c.ControlAuth) == 0 {
+		return fmt.Errorf("read and control routes need at least one auth method")
+	}
+
+	if c.UsesAuth("mtls") {
 		if c.CertFile == "" || c.KeyFile == "" || c.CAFile == "" {
 			return fmt.Errorf("mTLS requires cert_file, key_file, and ca_file")
 		}
 	}
 
-	if c.AuthType == "jwt" {
-		if c.JWTSecret == "" {
-			return fmt.Errorf("JWT auth requires jwt_secret")
+	if c.UsesAuth("jwt") {
+		// An enrolled agent gets its secret from the panel
+		if c.JWTSecret == "" && c.EnrollToken == "" {
+			return fmt.Errorf("JWT auth requires jwt_secret or an enrollment token")
+		}
+		if c.JWTClockSkew < 0 || c.JWTMaxTTL < 0 {
+			return fmt.Errorf("jwt_clock_skew and jwt_max_ttl must not be negative")
+		}
+	}
+
+	if c.EnrollToken != "" && c.ControllerEndpoint == "" {
+		return fmt.Errorf("enrollment requires controller_endpoint")
+	}
+
+	if c.ControllerBinding != "tofu" && c.ControllerBinding != "reset" && c.ControllerBinding != "off" {
+		return fmt.Errorf("invalid controller binding: %s (must be 'tofu', 'reset' or 'off')", c.ControllerBinding)
+	}
+	if c.ControllerBinding != "off" {
+		// Any holder of the shared JWT secret can mint tokens with any claims, so a token
+		// cannot tell two controllers apart
+		if !slices.Equal(c.ControlAuth, []string{"mtls"}) {
+			return fmt.Errorf("controller binding requires mTLS on control routes: set AGENT_CONTROL_AUTH=mtls or AGENT_CONTROLLER_BINDING=off")
+		}
+		if c.ControllerIdentity != "" && !strings.HasPrefix(c.ControllerIdentity, "spki:") {
+			return fmt.Errorf("invalid controller identity: %s (must be spki:<sha256 of public key>)", c.ControllerIdentity)
 		}
 	}
 
@@ -93,9 +284,48 @@ func (c *AgentConfig) Validate() error {
 		return fmt.Errorf("listen_addr is required")
 	}
 
+	if c.UpdatePublicKey != "" {
+		if _, err := c.UpdateKey(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// UpdateKey returns the ed25519 key agent releases must be signed with, given as PEM or
+// as base64 of the DER encoding (openssl pkey -pubout -outform DER | base64).
+func (c *AgentConfig) UpdateKey() (ed25519.PublicKey, error) {
+	der := []byte(strings.TrimSpace(c.UpdatePublicKey))
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	} else if decoded, err := base64.StdEncoding.DecodeString(string(der)); err == nil {
+		der = decoded
+	} else {
+		return nil, fmt.Errorf("update_public_key is neither PEM nor base64")
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid update_public_key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("update_public_key must be an ed25519 key")
+	}
+	return edKey, nil
+}
+
+// UsesAuth reports whether any route accepts the auth method ("mtls" or "jwt").
+func (c *AgentConfig) UsesAuth(method string) bool {
+	return c.AuthType == method || slices.Contains(c.ReadAuth, method) || slices.Contains(c.ControlAuth, method)
+}
+
+// ClientCertRequired reports whether every route requires mTLS, so client
+// certificates can be demanded in the TLS handshake.
+func (c *AgentConfig) ClientCertRequired() bool {
+	return slices.Equal(c.ReadAuth, []string{"mtls"}) && slices.Equal(c.ControlAuth, []string{"mtls"})
+}
+
 // getEnv retrieves environment variable or returns default.
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -130,3 +360,70 @@ func parseTags(tagsStr string) []string {
 	}
 	return result
 }
+
+// parseCIDRs parses comma-separated networks. Bare addresses are taken as single hosts.
+func parseCIDRs(cidrsStr string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range parseTags(cidrsStr) {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network: %s", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// parseLimits parses comma-separated identity=limit pairs. Malformed pairs are skipped.
+func parseLimits(limitsStr string) map[string]int {
+	limits := make(map[string]int)
+	for _, pair := range parseTags(limitsStr) {
+		identity, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		limits[strings.TrimSpace(identity)] = limit
+	}
+	return limits
+}
+
+// parseGroupLimits parses comma-separated group=perMinute[:burst] pairs, e.g.
+// "xray=20:5,system=60". Malformed pairs are skipped.
+func parseGroupLimits(limitsStr string) map[string]GroupLimit {
+	limits := make(map[string]GroupLimit)
+	for _, pair := range parseTags(limitsStr) {
+		group, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		perMinute, burst, hasBurst := strings.Cut(strings.TrimSpace(value), ":")
+		limit := GroupLimit{}
+		var err error
+		if limit.PerMinute, err = strconv.Atoi(perMinute); err != nil {
+			continue
+		}
+		if hasBurst {
+			if limit.Burst, err = strconv.Atoi(burst); err != nil {
+				continue
+			}
+		}
+		limits[strings.TrimSpace(group)] = limit
+	}
+	return limits
+}
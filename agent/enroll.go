@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/config"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// enrolledServerKey is the setting holding the panel server ID the agent registered as.
+const enrolledServerKey = "agentEnrolledServerId"
+
+// enrollTimeout bounds the registration request to the panel.
+const enrollTimeout = 30 * time.Second
+
+// enrollment is the part of the panel's registration response the agent uses.
+type enrollment struct {
+	ServerId     int    `json:"serverId"`
+	Name         string `json:"name"`
+	AuthType     string `json:"authType"`
+	JWTSecret    string `json:"jwtSecret"`
+	Certificates *struct {
+		CertPem string `json:"certPem"`
+		KeyPem  string `json:"keyPem"`
+		CAPem   string `json:"caPem"`
+	} `json:"certificates"`
+}
+
+// enroll registers the agent with the panel at AGENT_CONTROLLER_ENDPOINT using
+// AGENT_ENROLL_TOKEN, unless it registered before, and stores the credentials it is
+// issued: the JWT secret like a rotated one, or the certificates in the configured files.
+func enroll(cfg *config.AgentConfig) error {
+	if cfg.EnrollToken == "" {
+		return nil
+	}
+	if serverId, err := loadEnrolledServer(); err != nil {
+		return err
+	} else if serverId != "" {
+		logger.Info(fmt.Sprintf("Already enrolled as server %s, ignoring AGENT_ENROLL_TOKEN", serverId))
+		return nil
+	}
+
+	name := cfg.ServerName
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	port := 0
+	if _, p, err := net.SplitHostPort(cfg.ListenAddr); err == nil {
+		port, _ = strconv.Atoi(p)
+	}
+	body, err := json.Marshal(map[string]any{
+		"token":    cfg.EnrollToken,
+		"name":     name,
+		"endpoint": cfg.PublicEndpoint,
+		"port":     port,
+		"tags":     cfg.Tags,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), enrollTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(cfg.ControllerEndpoint, "/") + "/panel/api/agents/register"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the panel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool        `json:"success"`
+		Msg     string      `json:"msg"`
+		Obj     *enrollment `json:"obj"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid registration response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if !result.Success || result.Obj == nil {
+		return fmt.Errorf("panel rejected the registration (HTTP %d): %s", resp.StatusCode, result.Msg)
+	}
+	issued := result.Obj
+	if issued.AuthType != cfg.AuthType {
+		return fmt.Errorf("enrollment token is for %s agents, but AGENT_AUTH_TYPE is %s", issued.AuthType, cfg.AuthType)
+	}
+
+	switch issued.AuthType {
+	case "jwt":
+		if err := (settingSecretStore{}).Save(issued.JWTSecret); err != nil {
+			return fmt.Errorf("failed to save JWT secret: %w", err)
+		}
+	case "mtls":
+		if issued.Certificates == nil {
+			return fmt.Errorf("panel issued no certificates")
+		}
+		files := []struct {
+			path, data string
+			mode       os.FileMode
+		}{
+			{cfg.CertFile, issued.Certificates.CertPem, 0o644},
+			{cfg.KeyFile, issued.Certificates.KeyPem, 0o600},
+			{cfg.CAFile, issued.Certificates.CAPem, 0o644},
+		}
+		for _, file := range files {
+			if err := os.MkdirAll(filepath.Dir(file.path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(file.path, []byte(file.data), file.mode); err != nil {
+				return fmt.Errorf("failed to write %s: %w", file.path, err)
+			}
+		}
+	}
+
+	if err := saveEnrolledServer(strconv.Itoa(issued.ServerId)); err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Enrolled as server %s (ID %d)", issued.Name, issued.ServerId))
+	return nil
+}
+
+func loadEnrolledServer() (string, error) {
+	var setting model.Setting
+	err := database.GetDB().Model(model.Setting{}).Where("key = ?", enrolledServerKey).First(&setting).Error
+	if database.IsNotFound(err) {
+		return "", nil
+	}
+	return setting.Value, err
+}
+
+func saveEnrolledServer(serverId string) error {
+	return database.GetDB().Create(&model.Setting{Key: enrolledServerKey, Value: serverId}).Error
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// BindingStore persists the controller identity an agent is bound to.
+type BindingStore interface {
+	Load() (string, error)
+	Save(identity string) error
+}
+
+// ControllerBinding binds the agent to the controller that first manages it, so a
+// second panel holding a valid certificate or token cannot take the node over silently.
+type ControllerBinding struct {
+	mu       sync.Mutex
+	store    BindingStore
+	identity string
+	pinned   bool
+}
+
+// NewControllerBinding loads the bound identity from store. A non-empty pinned
+// identity is enforced instead of the stored one and never replaced.
+func NewControllerBinding(store BindingStore, pinned string) (*ControllerBinding, error) {
+	if pinned != "" {
+		return &ControllerBinding{store: store, identity: pinned, pinned: true}, nil
+	}
+	identity, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &ControllerBinding{store: store, identity: identity}, nil
+}
+
+// Identity returns the bound controller identity, or "" if the agent is not bound yet.
+func (b *ControllerBinding) Identity() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.identity
+}
+
+// Middleware returns a Gin middleware that runs after authentication on the routes for
+// which control reports true. The first controller calling them is recorded; calls from
+// any other controller identity are rejected with 403 CONTROLLER_MISMATCH.
+func (b *ControllerBinding) Middleware(control func(c *gin.Context) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !control(c) {
+			c.Next()
+			return
+		}
+		identity, ok := ControllerIdentity(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		b.mu.Lock()
+		bound := b.identity
+		if bound == "" {
+			if err := b.store.Save(identity); err != nil {
+				b.mu.Unlock()
+				logger.Error("Failed to record controller identity:", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"success": false,
+					"error": gin.H{
+						"code":    "BINDING_FAILED",
+						"message": "Failed to record controller identity",
+					},
+				})
+				return
+			}
+			b.identity, bound = identity, identity
+			logger.Infof("Agent bound to controller %s", identity)
+		}
+		b.mu.Unlock()
+
+		if identity != bound {
+			logger.Warningf("Rejected %s %s from controller %s: agent is bound to %s", c.Request.Method, c.FullPath(), identity, bound)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error": gin.H{
+					"code":    "CONTROLLER_MISMATCH",
+					"message": "This agent is bound to another controller",
+				},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ControllerIdentity identifies the controller behind an mTLS-authenticated request by
+// the SHA-256 fingerprint of its client certificate public key ("spki:<hex>"), which
+// survives renewals with the same key. Bearer tokens carry no identity: every holder of
+// the shared secret can mint them, so binding only works with mTLS.
+func ControllerIdentity(c *gin.Context) (string, bool) {
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 && len(c.Request.TLS.VerifiedChains[0]) > 0 {
+		return "spki:" + PublicKeyFingerprint(c.Request.TLS.VerifiedChains[0][0]), true
+	}
+	return "", false
+}
+
+// PublicKeyFingerprint returns the hex SHA-256 of the certificate's public key info,
+// as printed by "openssl x509 -pubkey -noout | openssl pkey -pubin -outform DER | sha256sum".
+func PublicKeyFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
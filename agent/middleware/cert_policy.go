@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrCertNotAllowed is returned for client certificates rejected by a CertPolicy.
+var ErrCertNotAllowed = errors.New("client certificate not allowed")
+
+// certNameKinds are the name kinds a CertPolicy pattern can be restricted to.
+var certNameKinds = map[string]bool{"cn": true, "dns": true, "email": true, "uri": true, "ip": true}
+
+// CertPolicy restricts which client certificates are accepted beyond being signed by
+// the CA, so a certificate issued by the same CA for another purpose cannot control
+// the agent.
+type CertPolicy struct {
+	patterns []certPattern
+}
+
+type certPattern struct {
+	kind string // name kind the pattern applies to, "" = CN or DNS SAN
+	glob string
+}
+
+// NewCertPolicy creates a policy from patterns such as "controller.example.com",
+// "*.ops.example.com" or "uri:spiffe://example.com/controller". A pattern is a
+// case-insensitive glob (path.Match syntax) that matches the subject CN or a DNS SAN;
+// a "cn:", "dns:", "email:", "uri:" or "ip:" prefix restricts it to that name kind.
+// No patterns accept every certificate.
+func NewCertPolicy(patterns []string) (*CertPolicy, error) {
+	p := &CertPolicy{}
+	for _, raw := range patterns {
+		pattern := strings.TrimSpace(raw)
+		if pattern == "" {
+			continue
+		}
+		kind := ""
+		if k, rest, ok := strings.Cut(pattern, ":"); ok && certNameKinds[strings.ToLower(k)] {
+			kind, pattern = strings.ToLower(k), rest
+		}
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid client certificate pattern %q: %w", raw, err)
+		}
+		p.patterns = append(p.patterns, certPattern{kind: kind, glob: pattern})
+	}
+	return p, nil
+}
+
+// Allow returns an error unless cert has a name matching one of the patterns.
+func (p *CertPolicy) Allow(cert *x509.Certificate) error {
+	if p == nil || len(p.patterns) == 0 {
+		return nil
+	}
+	names := certNames(cert)
+	for _, pattern := range p.patterns {
+		for _, name := range names {
+			if pattern.kind == "" && name.kind != "cn" && name.kind != "dns" {
+				continue
+			}
+			if pattern.kind != "" && pattern.kind != name.kind {
+				continue
+			}
+			if ok, _ := path.Match(pattern.glob, strings.ToLower(name.value)); ok {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s (CN=%s) matches no allowed name", ErrCertNotAllowed, cert.SerialNumber.Text(16), cert.Subject.CommonName)
+}
+
+// ClientCertVerifier applies the name policy and revocation checks to the verified
+// chain of a client certificate. Either check may be nil.
+type ClientCertVerifier struct {
+	Policy     *CertPolicy
+	Revocation *RevocationChecker
+}
+
+// Verify returns an error if the leaf of chain is not allowed or revoked.
+func (v *ClientCertVerifier) Verify(chain []*x509.Certificate) error {
+	if v == nil || len(chain) == 0 {
+		return nil
+	}
+	if err := v.Policy.Allow(chain[0]); err != nil {
+		return err
+	}
+	return v.Revocation.Check(chain)
+}
+
+type certName struct {
+	kind  string
+	value string
+}
+
+// certNames lists the subject CN and the SANs of cert.
+func certNames(cert *x509.Certificate) []certName {
+	var names []certName
+	if cert.Subject.CommonName != "" {
+		names = append(names, certName{"cn", cert.Subject.CommonName})
+	}
+	for _, dns := range cert.DNSNames {
+		names = append(names, certName{"dns", dns})
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, certName{"email", email})
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, certName{"uri", uri.String()})
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, certName{"ip", ip.String()})
+	}
+	return names
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// minJWTSecretLength is the shortest secret accepted when rotating.
+const minJWTSecretLength = 32
+
+// SecretStore persists the JWT secret set by the last rotation.
+type SecretStore interface {
+	Load() (string, error)
+	Save(secret string) error
+}
+
+// JWTKeyring holds the secret bearer tokens are verified with. A rotation replaces it
+// while the previous secret stays valid for a grace period, so requests signed before
+// the panel switched over still pass.
+type JWTKeyring struct {
+	mu            sync.RWMutex
+	store         SecretStore
+	current       string
+	previous      string
+	previousUntil time.Time
+}
+
+// NewJWTKeyring returns a keyring using the secret saved by the last rotation, or
+// configured if there was none.
+func NewJWTKeyring(configured string, store SecretStore) (*JWTKeyring, error) {
+	keyring := &JWTKeyring{store: store, current: configured}
+	if store != nil {
+		rotated, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		if rotated != "" {
+			keyring.current = rotated
+		}
+	}
+	return keyring, nil
+}
+
+// Secrets returns the secrets tokens may currently be signed with, newest first.
+func (k *JWTKeyring) Secrets() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.previous != "" && time.Now().Before(k.previousUntil) {
+		return []string{k.current, k.previous}
+	}
+	return []string{k.current}
+}
+
+// Current returns the newest secret.
+func (k *JWTKeyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Rotate persists secret and makes it current. The replaced secret is accepted for grace.
+func (k *JWTKeyring) Rotate(secret string, grace time.Duration) error {
+	if len(secret) < minJWTSecretLength {
+		return fmt.Errorf("secret must be at least %d characters", minJWTSecretLength)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if secret == k.current {
+		return nil
+	}
+	if k.store != nil {
+		if err := k.store.Save(secret); err != nil {
+			return fmt.Errorf("failed to save secret: %w", err)
+		}
+	}
+	k.previous, k.previousUntil = k.current, time.Now().Add(grace)
+	k.current = secret
+	return nil
+}
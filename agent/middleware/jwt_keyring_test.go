@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/util/jwt"
+)
+
+// TestJWTKeyringRotation checks that tokens signed with the replaced secret are accepted
+// during the grace period and rejected after it.
+func TestJWTKeyringRotation(t *testing.T) {
+	oldSecret := strings.Repeat("o", minJWTSecretLength)
+	newSecret := strings.Repeat("n", minJWTSecretLength)
+
+	tests := []struct {
+		name    string
+		signer  string
+		grace   time.Duration
+		wantErr error
+	}{
+		{"new secret", newSecret, time.Hour, nil},
+		{"old secret within grace", oldSecret, time.Hour, nil},
+		{"old secret after grace", oldSecret, -time.Second, jwt.ErrSignature},
+		{"new secret after grace", newSecret, -time.Second, nil},
+		{"unknown secret", strings.Repeat("x", minJWTSecretLength), time.Hour, jwt.ErrSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := NewJWTKeyring(oldSecret, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := keyring.Rotate(newSecret, tt.grace); err != nil {
+				t.Fatal(err)
+			}
+			token, _, err := jwt.Issue(tt.signer, "panel", "panel", "", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = JWTConfig{Keyring: keyring}.verify(token)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("expected the token to be accepted, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+type memorySecretStore struct{ secret string }
+
+func (s *memorySecretStore) Load() (string, error)    { return s.secret, nil }
+func (s *memorySecretStore) Save(secret string) error { s.secret = secret; return nil }
+
+// TestJWTKeyringRotationPersists checks that a rotated secret replaces the configured one
+// after a restart, and that short secrets are refused.
+func TestJWTKeyringRotationPersists(t *testing.T) {
+	configured := strings.Repeat("c", minJWTSecretLength)
+	rotated := strings.Repeat("r", minJWTSecretLength)
+	store := &memorySecretStore{}
+
+	keyring, err := NewJWTKeyring(configured, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Rotate("short", time.Hour); err == nil {
+		t.Fatal("expected a short secret to be refused")
+	}
+	if err := keyring.Rotate(rotated, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewJWTKeyring(configured, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.Secrets(); len(got) != 1 || got[0] != rotated {
+		t.Fatalf("expected only the rotated secret after a restart, got %d secrets", len(got))
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+
+	"github.com/op/go-logging"
+)
+
+// TestMain logs to a temporary folder, as the checks report to the logger.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "x-ui-middleware-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("XUI_LOG_FOLDER", dir)
+	logger.InitLogger(logging.ERROR)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
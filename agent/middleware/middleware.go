@@ -2,21 +2,30 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/jwt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // MTLSAuth middleware verifies client certificate.
-// NOTE: The TLS layer (with RequireAndVerifyClientCert) already performs
-// certificate verification. This middleware provides additional validation
-// and extracts client certificate information for logging.
-func MTLSAuth(caFile string) gin.HandlerFunc {
+// NOTE: The TLS layer (with RequireAndVerifyClientCert) already verifies the
+// certificate against the CA pool and applies verifier during the handshake.
+// This middleware rejects requests without a verified chain, re-applies
+// verifier so that a CRL update also cuts off kept-alive connections, and
+// extracts client certificate information for logging.
+func MTLSAuth(verifier *ClientCertVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check if TLS is used
 		if c.Request.TLS == nil {
@@ -32,8 +41,8 @@ func MTLSAuth(caFile string) gin.HandlerFunc {
 		}
 
 		// Verify client certificate (should already be verified by TLS layer)
-		if len(c.Request.TLS.PeerCertificates) == 0 {
-			logger.Warning("No client certificate provided")
+		if len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+			logger.Warning("No verified client certificate provided")
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"error": gin.H{
@@ -45,7 +54,30 @@ func MTLSAuth(caFile string) gin.HandlerFunc {
 		}
 
 		// Get client certificate
-		clientCert := c.Request.TLS.PeerCertificates[0]
+		chain := c.Request.TLS.VerifiedChains[0]
+		clientCert := chain[0]
+
+		if err := verifier.Verify(chain); err != nil {
+			logger.Warning("Rejected client certificate:", err)
+			if errors.Is(err, ErrCertNotAllowed) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"success": false,
+					"error": gin.H{
+						"code":    "CLIENT_CERT_NOT_ALLOWED",
+						"message": "Client certificate is not allowed to access this agent",
+					},
+				})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error": gin.H{
+					"code":    "CLIENT_CERT_REVOKED",
+					"message": "Client certificate has been revoked",
+				},
+			})
+			return
+		}
 
 		// Extract and store client CN for logging/audit
 		c.Set("client_cn", clientCert.Subject.CommonName)
@@ -56,16 +88,111 @@ func MTLSAuth(caFile string) gin.HandlerFunc {
 	}
 }
 
-// JWTAuth middleware verifies Bearer token using static secret.
-//
-// SECURITY NOTE: This is NOT true JWT validation with signing/claims/expiry.
-// It performs static secret comparison for simple deployments where mTLS
-// certificate management is impractical.
+// RouteAuth middleware authenticates one class of routes with any of the given
+// methods ("mtls", "jwt") and, if sources is not empty, only from those networks.
+// A request with a verified client certificate is authenticated by mTLS when it is
+// allowed, otherwise by its Bearer token; requests that fit no allowed method get
+// the error of the first one.
+func RouteAuth(methods []string, sources []*net.IPNet, verifier *ClientCertVerifier, jwtConfig JWTConfig) gin.HandlerFunc {
+	allowMTLS := slices.Contains(methods, "mtls")
+	allowJWT := slices.Contains(methods, "jwt")
+	mtlsAuth := MTLSAuth(verifier)
+	jwtAuth := JWTAuth(jwtConfig)
+
+	return func(c *gin.Context) {
+		if len(sources) > 0 && !sourceAllowed(c.RemoteIP(), sources) {
+			logger.Warning("Request from disallowed network:", c.RemoteIP(), c.FullPath())
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error": gin.H{
+					"code":    "SOURCE_NOT_ALLOWED",
+					"message": "Requests from this network are not allowed for this operation",
+				},
+			})
+			return
+		}
+
+		hasCert := c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0
+		switch {
+		case allowMTLS && (hasCert || !allowJWT):
+			mtlsAuth(c)
+		default:
+			jwtAuth(c)
+		}
+	}
+}
+
+// sourceAllowed reports whether ip is in one of nets.
+func sourceAllowed(ip string, nets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTConfig configures the validation of bearer tokens.
+type JWTConfig struct {
+	Keyring     *JWTKeyring   // HMAC keys of HS256-signed tokens
+	Audience    string        // required "aud" claim, empty = any
+	ClockSkew   time.Duration // tolerance for exp, iat and nbf
+	MaxTTL      time.Duration // maximum exp - iat, 0 = unlimited
+	AllowStatic bool          // also accept the secret itself as a token (legacy panels)
+}
+
+// verify validates a bearer token against the secrets of the keyring and returns its
+// claims. A static token equal to the current secret is only accepted with AllowStatic
+// and gets the subject "token".
+func (j JWTConfig) verify(token string) (*jwt.Claims, error) {
+	if j.Keyring == nil {
+		return nil, jwt.ErrEmptySecret
+	}
+	if j.AllowStatic && secureCompare(token, j.Keyring.Current()) {
+		return &jwt.Claims{Subject: "token"}, nil
+	}
+	var err error
+	for _, secret := range j.Keyring.Secrets() {
+		var claims *jwt.Claims
+		claims, err = jwt.Verify(token, secret, jwt.VerifyOptions{Audience: j.Audience, ClockSkew: j.ClockSkew, MaxTTL: j.MaxTTL})
+		if !errors.Is(err, jwt.ErrSignature) {
+			return claims, err
+		}
+	}
+	return nil, err
+}
+
+// tokenErrorCode maps a token verification error to the error code of the response.
+func tokenErrorCode(err error) (string, string) {
+	switch {
+	case errors.Is(err, jwt.ErrExpired):
+		return "TOKEN_EXPIRED", "Authentication token has expired"
+	case errors.Is(err, jwt.ErrNotYetValid):
+		return "TOKEN_NOT_YET_VALID", "Authentication token is not valid yet, check the clocks of panel and agent"
+	case errors.Is(err, jwt.ErrSignature):
+		return "TOKEN_INVALID_SIGNATURE", "Authentication token signature is invalid"
+	case errors.Is(err, jwt.ErrAudience):
+		return "TOKEN_AUDIENCE_MISMATCH", "Authentication token is not issued for this agent"
+	case errors.Is(err, jwt.ErrMissingClaims), errors.Is(err, jwt.ErrLifetime), errors.Is(err, jwt.ErrAlgorithm):
+		return "TOKEN_CLAIMS_INVALID", "Authentication token claims are invalid: " + err.Error()
+	default:
+		return "INVALID_TOKEN", "Invalid authentication token"
+	}
+}
+
+// JWTAuth middleware verifies the Bearer token as an HS256 JWT: the signature, the exp,
+// iat and nbf claims (with the configured clock skew), the aud claim and the maximum
+// lifetime. Expired, tampered and otherwise invalid tokens are rejected with distinct
+// error codes.
 //
 // PRODUCTION RECOMMENDATION: Use MTLSAuth instead for better security.
 // mTLS provides mutual authentication with certificate rotation and
 // defense against token theft.
-func JWTAuth(secret string) gin.HandlerFunc {
+func JWTAuth(cfg JWTConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -94,19 +221,21 @@ func JWTAuth(secret string) gin.HandlerFunc {
 
 		token := authHeader[7:]
 
-		// Static secret comparison (constant-time to prevent timing attacks)
-		// This is intentionally simple for deployments where mTLS is impractical.
-		if !secureCompare(token, secret) {
+		claims, err := cfg.verify(token)
+		if err != nil {
+			logger.Warning("Rejected bearer token:", err)
+			code, message := tokenErrorCode(err)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"error": gin.H{
-					"code":    "INVALID_TOKEN",
-					"message": "Invalid authentication token",
+					"code":    code,
+					"message": message,
 				},
 			})
 			return
 		}
 
+		c.Set("jwt_subject", claims.Subject)
 		c.Next()
 	}
 }
@@ -170,24 +299,98 @@ func RequestLogger() gin.HandlerFunc {
 	}
 }
 
-// RateLimiter implements a simple token bucket rate limiter.
+// IdentityFunc returns the authenticated identity of a request, or false if the request
+// does not carry valid credentials.
+type IdentityFunc func(c *gin.Context) (string, bool)
+
+// FirstIdentity returns the identity of the first func that authenticates the request,
+// for agents accepting more than one auth method.
+func FirstIdentity(funcs ...IdentityFunc) IdentityFunc {
+	return func(c *gin.Context) (string, bool) {
+		for _, identify := range funcs {
+			if identity, ok := identify(c); ok {
+				return identity, true
+			}
+		}
+		return "", false
+	}
+}
+
+// MTLSIdentity identifies a request by the CN of its client certificate. The TLS layer
+// verifies the certificate against the CA during the handshake, so the CN can be trusted
+// before MTLSAuth runs.
+func MTLSIdentity(c *gin.Context) (string, bool) {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	return "cn:" + c.Request.TLS.PeerCertificates[0].Subject.CommonName, true
+}
+
+// JWTIdentity identifies requests with a valid Bearer token by the token's "sub" claim,
+// or as "jwt:token" if it has none.
+func JWTIdentity(cfg JWTConfig) IdentityFunc {
+	return func(c *gin.Context) (string, bool) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			return "", false
+		}
+		claims, err := cfg.verify(token)
+		if err != nil {
+			return "", false
+		}
+		if claims.Subject == "" {
+			return "jwt:token", true
+		}
+		return "jwt:" + claims.Subject, true
+	}
+}
+
+// Limit configures a token bucket: tokens are refilled continuously at PerMinute per
+// minute up to Burst, and each request takes one.
+type Limit struct {
+	PerMinute int // sustained rate; 0 or less disables limiting
+	Burst     int // bucket capacity, i.e. requests allowed at once; 0 means PerMinute
+}
+
+// capacity returns the bucket size of the limit.
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.PerMinute)
+}
+
+// RateLimiter implements a token bucket rate limiter. Buckets are keyed by the
+// authenticated identity of the caller, so clients behind one NAT do not share a bucket
+// and rotating IPs does not reset it; requests without valid credentials fall back to
+// one bucket per client IP.
 type RateLimiter struct {
-	limit         int // requests per minute
-	buckets       map[string]*tokenBucket
-	mu            sync.RWMutex
-	cleanupTicker *time.Ticker
+	limit          Limit
+	identityLimits map[string]int // per-identity overrides of limit.PerMinute
+	identify       IdentityFunc
+	buckets        map[string]*tokenBucket
+	mu             sync.Mutex
+	cleanupTicker  *time.Ticker
+	done           chan struct{}
+	stopOnce       sync.Once
 }
 
 type tokenBucket struct {
-	tokens     int
+	tokens     float64
 	lastRefill time.Time
+	full       time.Time // when the bucket is full again if left alone
 }
 
-// NewRateLimiter creates a new rate limiter.
-func NewRateLimiter(requestsPerMinute int) *RateLimiter {
+// NewRateLimiter creates a new rate limiter. identityLimits overrides the per-minute
+// rate for single identities, such as "cn:3x-ui-controller"; identify may be nil, in
+// which case all requests are keyed by IP.
+func NewRateLimiter(limit Limit, identityLimits map[string]int, identify IdentityFunc) *RateLimiter {
 	rl := &RateLimiter{
-		limit:   requestsPerMinute,
-		buckets: make(map[string]*tokenBucket),
+		limit:          limit,
+		identityLimits: identityLimits,
+		identify:       identify,
+		buckets:        make(map[string]*tokenBucket),
+		done:           make(chan struct{}),
 	}
 
 	// Cleanup old buckets every 5 minutes
@@ -197,13 +400,18 @@ func NewRateLimiter(requestsPerMinute int) *RateLimiter {
 	return rl
 }
 
-// cleanup removes old buckets.
+// cleanup removes buckets that have refilled completely, since a new bucket is the same.
 func (rl *RateLimiter) cleanup() {
-	for range rl.cleanupTicker.C {
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-rl.cleanupTicker.C:
+		}
 		rl.mu.Lock()
 		now := time.Now()
 		for key, bucket := range rl.buckets {
-			if now.Sub(bucket.lastRefill) > 10*time.Minute {
+			if now.After(bucket.full) {
 				delete(rl.buckets, key)
 			}
 		}
@@ -211,18 +419,43 @@ func (rl *RateLimiter) cleanup() {
 	}
 }
 
-// Middleware returns a Gin middleware function.
+// Middleware returns a Gin middleware function applying the global limit.
 func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+	return rl.handler("", nil)
+}
+
+// Group returns a middleware that applies limit to a route group, such as "xray". The
+// group has its own buckets per caller, checked in addition to the global limit.
+func (rl *RateLimiter) Group(name string, limit Limit) gin.HandlerFunc {
+	return rl.handler(name, &limit)
+}
+
+// handler limits requests and reports the state of the caller's bucket in
+// X-RateLimit-Limit (bucket size), X-RateLimit-Remaining and X-RateLimit-Reset
+// (seconds until the bucket is full again). Rejected requests also get Retry-After.
+func (rl *RateLimiter) handler(group string, groupLimit *Limit) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Use client IP as key
-		clientIP := c.ClientIP()
+		key, limit := rl.keyOf(c)
+		if groupLimit != nil {
+			key, limit = group+"|"+key, *groupLimit
+		}
+		if limit.PerMinute <= 0 {
+			c.Next()
+			return
+		}
 
-		if !rl.allow(clientIP) {
+		allowed, remaining, reset, retryAfter := rl.take(key, limit)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
 				"success": false,
 				"error": gin.H{
 					"code":    "RATE_LIMIT_EXCEEDED",
-					"message": fmt.Sprintf("Rate limit exceeded: %d requests per minute", rl.limit),
+					"message": fmt.Sprintf("Rate limit exceeded: %d requests per minute", limit.PerMinute),
 				},
 			})
 			return
@@ -232,46 +465,61 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 	}
 }
 
-// allow checks if a request is allowed.
-func (rl *RateLimiter) allow(key string) bool {
+// keyOf returns the bucket key of a request and the global limit that applies to it.
+func (rl *RateLimiter) keyOf(c *gin.Context) (string, Limit) {
+	if rl.identify != nil {
+		if identity, ok := rl.identify(c); ok {
+			if perMinute, ok := rl.identityLimits[identity]; ok {
+				return identity, Limit{PerMinute: perMinute, Burst: perMinute}
+			}
+			return identity, rl.limit
+		}
+	}
+	// The connection address, as forwarding headers can be set to anything
+	return "ip:" + c.RemoteIP(), rl.limit
+}
+
+// take refills the bucket of key and takes a token from it if one is left. It returns
+// whether the request is allowed, the whole tokens left, the time until the bucket is
+// full and the time until the next token.
+func (rl *RateLimiter) take(key string, limit Limit) (bool, int, time.Duration, time.Duration) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	now := time.Now()
+	capacity := limit.capacity()
+	perSecond := float64(limit.PerMinute) / 60
 
 	bucket, exists := rl.buckets[key]
 	if !exists {
-		bucket = &tokenBucket{
-			tokens:     rl.limit,
-			lastRefill: now,
-		}
+		bucket = &tokenBucket{tokens: capacity, lastRefill: now}
 		rl.buckets[key] = bucket
 	}
 
-	// Refill tokens based on time elapsed
-	elapsed := now.Sub(bucket.lastRefill)
-	tokensToAdd := int(elapsed.Minutes() * float64(rl.limit))
+	// Refill continuously for the time elapsed
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*perSecond)
+	bucket.lastRefill = now
 
-	if tokensToAdd > 0 {
-		bucket.tokens += tokensToAdd
-		if bucket.tokens > rl.limit {
-			bucket.tokens = rl.limit
-		}
-		bucket.lastRefill = now
-	}
-
-	// Check if request is allowed
-	if bucket.tokens > 0 {
+	allowed := bucket.tokens >= 1
+	if allowed {
 		bucket.tokens--
-		return true
 	}
 
-	return false
+	untilFull := time.Duration((capacity - bucket.tokens) / perSecond * float64(time.Second))
+	bucket.full = now.Add(untilFull)
+	untilNext := time.Duration(0)
+	if bucket.tokens < 1 {
+		untilNext = time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	return allowed, int(bucket.tokens), untilFull, untilNext
 }
 
-// Stop stops the rate limiter cleanup goroutine.
+// Stop stops the rate limiter cleanup goroutine. It is safe to call more than once.
 func (rl *RateLimiter) Stop() {
-	rl.cleanupTicker.Stop()
+	rl.stopOnce.Do(func() {
+		rl.cleanupTicker.Stop()
+		close(rl.done)
+	})
 }
 
 // MaxBodySize middleware limits request body size.
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// crlStatInterval limits how often the CRL file is checked for changes.
+const crlStatInterval = 10 * time.Second
+
+// LoadCAPool reads the PEM certificates in caFile into a pool for verifying client
+// certificates. Unlike tls.LoadX509KeyPair it needs no private key, so CA-only files work.
+func LoadCAPool(caFile string) (*x509.CertPool, error) {
+	caCert, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("failed to parse CA certificate: no PEM certificates in %s", caFile)
+	}
+	return pool, nil
+}
+
+// RevocationChecker rejects client certificates that are revoked by a CRL or listed in a
+// denylist. The CRL file is reloaded when it changes, so revocations take effect without
+// restarting the agent.
+type RevocationChecker struct {
+	crlFile  string
+	denylist map[string]bool // normalized serial numbers and SHA-256 fingerprints
+
+	mu       sync.Mutex
+	crl      *x509.RevocationList
+	revoked  map[string]bool // serial numbers revoked by crl
+	crlMod   time.Time
+	lastStat time.Time
+}
+
+// NewRevocationChecker creates a checker for the given CRL file (PEM or DER, optional)
+// and denylist entries, which are certificate serial numbers or SHA-256 fingerprints in
+// hex, with or without colons.
+func NewRevocationChecker(crlFile string, denylist []string) (*RevocationChecker, error) {
+	r := &RevocationChecker{
+		crlFile:  crlFile,
+		denylist: make(map[string]bool, len(denylist)),
+	}
+	for _, entry := range denylist {
+		if entry = normalizeHex(entry); entry != "" {
+			r.denylist[entry] = true
+		}
+	}
+	if crlFile != "" {
+		if err := r.loadCRL(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Check returns an error if the leaf of a verified chain is revoked. chain[1], if
+// present, is the issuer the CRL signature is checked against.
+func (r *RevocationChecker) Check(chain []*x509.Certificate) error {
+	if r == nil || len(chain) == 0 {
+		return nil
+	}
+	cert := chain[0]
+	serial := normalizeHex(cert.SerialNumber.Text(16))
+	fingerprint := sha256.Sum256(cert.Raw)
+	if r.denylist[serial] || r.denylist[normalizeHex(hex.EncodeToString(fingerprint[:]))] {
+		return fmt.Errorf("client certificate %s (CN=%s) is denylisted", serial, cert.Subject.CommonName)
+	}
+
+	if r.crlFile == "" {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloadIfChanged()
+	if r.crl == nil || string(r.crl.RawIssuer) != string(cert.RawIssuer) {
+		return nil
+	}
+	if len(chain) > 1 {
+		if err := r.crl.CheckSignatureFrom(chain[1]); err != nil {
+			// Fail closed: a CRL that cannot be trusted must not let revoked certificates in
+			return fmt.Errorf("CRL %s is not signed by the client certificate issuer: %w", r.crlFile, err)
+		}
+	}
+	if r.revoked[serial] {
+		return fmt.Errorf("client certificate %s (CN=%s) is revoked", serial, cert.Subject.CommonName)
+	}
+	return nil
+}
+
+// reloadIfChanged reloads the CRL if its file was modified. A CRL that fails to load
+// keeps the previous one in effect. It must be called with r.mu held.
+func (r *RevocationChecker) reloadIfChanged() {
+	if time.Since(r.lastStat) < crlStatInterval {
+		return
+	}
+	r.lastStat = time.Now()
+	info, err := os.Stat(r.crlFile)
+	if err != nil || info.ModTime().Equal(r.crlMod) {
+		return
+	}
+	if err := r.loadCRL(); err != nil {
+		logger.Warning("Failed to reload CRL, keeping the previous one:", err)
+	}
+}
+
+// loadCRL reads and parses the CRL file.
+func (r *RevocationChecker) loadCRL() error {
+	info, err := os.Stat(r.crlFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL: %w", err)
+	}
+	data, err := os.ReadFile(r.crlFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL: %w", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse CRL %s: %w", r.crlFile, err)
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		logger.Warning("CRL", r.crlFile, "is past its next update time", crl.NextUpdate.Format(time.RFC3339))
+	}
+
+	revoked := make(map[string]bool, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[normalizeHex(entry.SerialNumber.Text(16))] = true
+	}
+	r.crl, r.revoked, r.crlMod = crl, revoked, info.ModTime()
+	logger.Info(fmt.Sprintf("Loaded CRL %s with %d revoked certificates", r.crlFile, len(revoked)))
+	return nil
+}
+
+// normalizeHex lowercases a hex serial or fingerprint and strips colons and leading zeros.
+func normalizeHex(value string) string {
+	value = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(value), ":", ""))
+	if trimmed := strings.TrimLeft(value, "0"); trimmed != "" {
+		return trimmed
+	}
+	return value
+}
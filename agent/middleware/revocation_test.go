@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a certificate signed by parent (self-signed if nil).
+func newTestCertificate(t *testing.T, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeTestCRL writes a PEM CRL of issuer revoking serials to dir.
+func writeTestCRL(t *testing.T, dir string, issuer *x509.Certificate, key *ecdsa.PrivateKey, serials ...int64) string {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ca.crl")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRevocationChecker checks the denylist and CRL checks applied to client certificates.
+func TestRevocationChecker(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCertificate(t, "agent CA", 1, nil, nil)
+	good, _ := newTestCertificate(t, "controller", 0x10, ca, caKey)
+	revoked, _ := newTestCertificate(t, "controller", 0x11, ca, caKey)
+	denied, _ := newTestCertificate(t, "controller", 0x12, ca, caKey)
+	// A fingerprint with a leading zero must match although denylist entries are trimmed
+	var denied2 *x509.Certificate
+	var fingerprint [sha256.Size]byte
+	for {
+		denied2, _ = newTestCertificate(t, "controller", 0x13, ca, caKey)
+		if fingerprint = sha256.Sum256(denied2.Raw); fingerprint[0]>>4 == 0 {
+			break
+		}
+	}
+	crlFile := writeTestCRL(t, dir, ca, caKey, 0x11)
+
+	// A CRL that claims the CA's name but is signed with another key
+	forged, forgedKey := newTestCertificate(t, "agent CA", 2, nil, nil)
+	forgedDir := t.TempDir()
+	forgedCRL := writeTestCRL(t, forgedDir, forged, forgedKey)
+
+	denylist := []string{"00:12", strings.ToUpper(hex.EncodeToString(fingerprint[:]))}
+
+	tests := []struct {
+		name     string
+		crlFile  string
+		denylist []string
+		cert     *x509.Certificate
+		wantErr  string
+	}{
+		{"valid certificate", crlFile, denylist, good, ""},
+		{"revoked by CRL", crlFile, denylist, revoked, "is revoked"},
+		{"denylisted serial", crlFile, denylist, denied, "is denylisted"},
+		{"denylisted fingerprint", crlFile, denylist, denied2, "is denylisted"},
+		{"no CRL", "", nil, revoked, ""},
+		{"CRL of another signer", forgedCRL, nil, good, "not signed by the client certificate issuer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker, err := NewRevocationChecker(tt.crlFile, tt.denylist)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checker.Check([]*x509.Certificate{tt.cert, ca})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected the certificate to be accepted, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := NewRevocationChecker(filepath.Join(dir, "missing.crl"), nil); err == nil {
+		t.Fatal("expected a missing CRL file to be an error")
+	}
+}
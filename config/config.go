@@ -66,6 +66,30 @@ func GetBinFolderPath() string {
 	return binFolderPath
 }
 
+// GetAuthDataKey returns the key that encrypts server auth data at rest, set via
+// XUI_AUTHDATA_KEY as a value or a secret reference such as "file:/run/secrets/authdata.key".
+// Empty leaves auth data unencrypted.
+func GetAuthDataKey() string {
+	return os.Getenv("XUI_AUTHDATA_KEY")
+}
+
+// IsReadOnly reports whether XUI_READ_ONLY=true forces the panel into read-only mode,
+// regardless of the readOnly setting.
+func IsReadOnly() bool {
+	return os.Getenv("XUI_READ_ONLY") == "true"
+}
+
+// IsDemo reports whether demo mode is enabled via XUI_DEMO, seeding simulated servers on startup.
+func IsDemo() bool {
+	return os.Getenv("XUI_DEMO") == "true"
+}
+
+// GetChaos returns the fault injection settings of connector calls set via XUI_CHAOS,
+// e.g. "latency=200ms errors=0.05". Empty disables fault injection.
+func GetChaos() string {
+	return os.Getenv("XUI_CHAOS")
+}
+
 func getBaseDir() string {
 	exePath, err := os.Executable()
 	if err != nil {
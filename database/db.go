@@ -4,13 +4,17 @@ package database
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path"
 	"slices"
+	"strings"
 
 	"github.com/cofedish/3x-UI-agents/config"
 	"github.com/cofedish/3x-UI-agents/database/model"
@@ -40,6 +44,30 @@ func initModels() error {
 		&model.HistoryOfSeeders{},
 		&model.Server{},
 		&model.ServerTask{},
+		&model.BlockedIP{},
+		&model.LoginAttempt{},
+		&model.ClientTrafficSample{},
+		&model.TrafficResetEvent{},
+		&model.Reseller{},
+		&model.ResellerClient{},
+		&model.TrialClient{},
+		&model.InboundOwner{},
+		&model.RenewalToken{},
+		&model.GeoFileStatus{},
+		&model.ServerScope{},
+		&model.ServerGroup{},
+		&model.InboundArchive{},
+		&model.ServerMetricSample{},
+		&model.TrafficHistory{},
+		&model.ServerEvent{},
+		&model.Incident{},
+		&model.HealthCheckRun{},
+		&model.AlertRule{},
+		&model.Alert{},
+		&model.Webhook{},
+		&model.ServerAPIKey{},
+		&model.EnrollmentToken{},
+		&model.ServerSettingsSync{},
 	}
 	for _, model := range models {
 		if err := db.AutoMigrate(model); err != nil {
@@ -229,6 +257,166 @@ func runMultiserverMigration() error {
 	return nil
 }
 
+// ensureLocalServer marks the server created by the multi-server migration as local when no
+// server has auth type "local", so the local instance is found by its auth type rather than
+// by ID even in databases written before the auth type was checked.
+func ensureLocalServer() error {
+	var count int64
+	if err := db.Model(&model.Server{}).Where("auth_type = ?", "local").Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	result := db.Model(&model.Server{}).Where("endpoint = ? OR endpoint = ''", "local://").Update("auth_type", "local")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Println("Marked the migrated local server with auth type local")
+	}
+	return nil
+}
+
+// migrateInboundClientIps rebuilds the inbound_client_ips table when it still carries the
+// legacy UNIQUE(client_email) constraint, so the same client can be tracked on every server.
+// Records without a server are assigned to the local server (ID=1).
+func migrateInboundClientIps() error {
+	if !db.Migrator().HasTable("inbound_client_ips") {
+		return nil
+	}
+
+	type indexInfo struct {
+		Name   string
+		Unique bool
+	}
+	type columnInfo struct {
+		Name string
+	}
+
+	var indexes []indexInfo
+	if err := db.Raw("PRAGMA index_list(inbound_client_ips)").Scan(&indexes).Error; err != nil {
+		return err
+	}
+
+	legacy := false
+	for _, index := range indexes {
+		if !index.Unique {
+			continue
+		}
+		var columns []columnInfo
+		db.Raw("PRAGMA index_info(\"" + index.Name + "\")").Scan(&columns)
+		if len(columns) == 1 && columns[0].Name == "client_email" {
+			legacy = true
+			break
+		}
+	}
+	if !legacy {
+		return nil
+	}
+
+	log.Println("Migrating inbound_client_ips to per-server records...")
+
+	serverIdExpr := "1"
+	if db.Migrator().HasColumn(&model.InboundClientIps{}, "server_id") {
+		serverIdExpr = "CASE WHEN server_id IS NULL OR server_id = 0 THEN 1 ELSE server_id END"
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Index names are global in SQLite; drop named indexes so the new table can reuse them
+		for _, index := range indexes {
+			if strings.HasPrefix(index.Name, "sqlite_autoindex_") {
+				continue
+			}
+			if err := tx.Exec("DROP INDEX IF EXISTS \"" + index.Name + "\"").Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().RenameTable("inbound_client_ips", "inbound_client_ips_legacy"); err != nil {
+			return err
+		}
+		if err := tx.AutoMigrate(&model.InboundClientIps{}); err != nil {
+			return err
+		}
+		copySQL := "INSERT INTO inbound_client_ips (id, server_id, client_email, ips) " +
+			"SELECT id, " + serverIdExpr + ", client_email, ips FROM inbound_client_ips_legacy"
+		if err := tx.Exec(copySQL).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable("inbound_client_ips_legacy")
+	})
+}
+
+// migrateOutboundTraffics rebuilds the outbound_traffics table when it still carries the
+// legacy UNIQUE(tag) constraint, so every server can record its own outbounds.
+// Records without a server are assigned to the local server (ID=1).
+func migrateOutboundTraffics() error {
+	if !db.Migrator().HasTable("outbound_traffics") {
+		return nil
+	}
+
+	type indexInfo struct {
+		Name   string
+		Unique bool
+	}
+	type columnInfo struct {
+		Name string
+	}
+
+	var indexes []indexInfo
+	if err := db.Raw("PRAGMA index_list(outbound_traffics)").Scan(&indexes).Error; err != nil {
+		return err
+	}
+
+	legacy := false
+	for _, index := range indexes {
+		if !index.Unique {
+			continue
+		}
+		var columns []columnInfo
+		db.Raw("PRAGMA index_info(\"" + index.Name + "\")").Scan(&columns)
+		if len(columns) == 1 && columns[0].Name == "tag" {
+			legacy = true
+			break
+		}
+	}
+	if !legacy {
+		return nil
+	}
+
+	log.Println("Migrating outbound_traffics to per-server records...")
+
+	serverIdExpr := "1"
+	if db.Migrator().HasColumn(&model.OutboundTraffics{}, "server_id") {
+		serverIdExpr = "CASE WHEN server_id IS NULL OR server_id = 0 THEN 1 ELSE server_id END"
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Index names are global in SQLite; drop named indexes so the new table can reuse them
+		for _, index := range indexes {
+			if strings.HasPrefix(index.Name, "sqlite_autoindex_") {
+				continue
+			}
+			if err := tx.Exec("DROP INDEX IF EXISTS \"" + index.Name + "\"").Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().RenameTable("outbound_traffics", "outbound_traffics_legacy"); err != nil {
+			return err
+		}
+		if err := tx.AutoMigrate(&model.OutboundTraffics{}); err != nil {
+			return err
+		}
+		copySQL := "INSERT INTO outbound_traffics (id, server_id, tag, up, down, total) " +
+			"SELECT id, " + serverIdExpr + ", tag, up, down, total FROM outbound_traffics_legacy"
+		if err := tx.Exec(copySQL).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable("outbound_traffics_legacy")
+	})
+}
+
 // isTableEmpty returns true if the named table contains zero rows.
 func isTableEmpty(tableName string) (bool, error) {
 	var count int64
@@ -260,6 +448,14 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
+	if err := migrateInboundClientIps(); err != nil {
+		return err
+	}
+
+	if err := migrateOutboundTraffics(); err != nil {
+		return err
+	}
+
 	if err := initModels(); err != nil {
 		return err
 	}
@@ -277,7 +473,11 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
-	return runMultiserverMigration()
+	if err := runMultiserverMigration(); err != nil {
+		return err
+	}
+
+	return ensureLocalServer()
 }
 
 // CloseDB closes the database connection if it exists.
@@ -323,6 +523,73 @@ func Checkpoint() error {
 	return nil
 }
 
+// Snapshot writes a consistent copy of the database to path, which must not exist yet,
+// without blocking writers for longer than the copy takes.
+func Snapshot(path string) error {
+	return db.Exec("VACUUM INTO ?", path).Error
+}
+
+// Fingerprint returns the SHA-256 of the rows of the SQLite database at dbPath, leaving
+// out skipTables and skipColumns. Unlike a hash of the file, it only changes when the
+// remaining data does.
+func Fingerprint(dbPath string, skipTables, skipColumns map[string]bool) (string, error) {
+	gdb, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return "", err
+	}
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return "", err
+	}
+	defer sqlDB.Close()
+
+	var tables []string
+	err = gdb.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name").
+		Scan(&tables).Error
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, table := range tables {
+		if skipTables[table] {
+			continue
+		}
+		rows, err := sqlDB.Query(fmt.Sprintf("SELECT * FROM %q ORDER BY rowid", table))
+		if err != nil {
+			return "", err
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		fmt.Fprintf(hash, "table %s\n", table)
+		for rows.Next() {
+			if err := rows.Scan(pointers...); err != nil {
+				rows.Close()
+				return "", err
+			}
+			for i, column := range columns {
+				if !skipColumns[column] {
+					fmt.Fprintf(hash, "%s=%q\x1f", column, fmt.Sprint(values[i]))
+				}
+			}
+			hash.Write([]byte("\n"))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // ValidateSQLiteDB opens the provided sqlite DB path with a throw-away connection
 // and runs a PRAGMA integrity_check to ensure the file is structurally sound.
 // It does not mutate global state or run migrations.
@@ -35,7 +35,13 @@ type Inbound struct {
 	Id                   int                  `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`                                                    // Unique identifier
 	UserId               int                  `json:"-"`                                                                                               // Associated user ID
 	ServerId             int                  `json:"serverId" form:"serverId" gorm:"index"`                                                           // Foreign key to Server (for multi-server support)
-	ServerAddress        string               `json:"serverAddress,omitempty" gorm:"-"`                                                                // Server address/hostname (not stored in DB, populated at runtime)
+	ServerAddress        string               `json:"serverAddress,omitempty" gorm:"-"`                                                                // Address clients connect to (not stored in DB, resolved at runtime from AddressOverride or the server)
+	AddressOverride      string               `json:"addressOverride" form:"addressOverride"`                                                          // Hostname or IP published for this inbound instead of the server's address
+	ServerName           string               `json:"serverName,omitempty" gorm:"-"`                                                                   // Name of the owning server (not stored in DB, populated at runtime)
+	ServerTags           []string             `json:"serverTags,omitempty" gorm:"-"`                                                                   // Tags of the owning server (not stored in DB, populated at runtime)
+	PendingSync          bool                 `json:"pendingSync,omitempty" gorm:"-"`                                                                  // Has mutations queued for an unreachable server (not stored in DB)
+	ResellerId           int                  `json:"resellerId,omitempty" gorm:"-"`                                                                   // Reseller owning the inbound, 0 = admin (not stored in DB, see InboundOwner)
+	Warnings             []string             `json:"warnings,omitempty" gorm:"-"`                                                                     // Soft limit and health flags, e.g. "nearQuota" (not stored in DB, see InboundWarningService)
 	Up                   int64                `json:"up" form:"up"`                                                                                    // Upload traffic in bytes
 	Down                 int64                `json:"down" form:"down"`                                                                                // Download traffic in bytes
 	Total                int64                `json:"total" form:"total"`                                                                              // Total traffic limit in bytes
@@ -45,6 +51,9 @@ type Inbound struct {
 	ExpiryTime           int64                `json:"expiryTime" form:"expiryTime"`                                                                    // Expiration timestamp
 	TrafficReset         string               `json:"trafficReset" form:"trafficReset" gorm:"default:never;index:idx_enable_traffic_reset,priority:2"` // Traffic reset schedule
 	LastTrafficResetTime int64                `json:"lastTrafficResetTime" form:"lastTrafficResetTime" gorm:"default:0"`                               // Last traffic reset timestamp
+	SpeedLimitUp         int                  `json:"speedLimitUp" form:"speedLimitUp" gorm:"default:0"`                                               // Upload rate limit shared by the inbound's clients in Mbit/s, 0 = unlimited
+	SpeedLimitDown       int                  `json:"speedLimitDown" form:"speedLimitDown" gorm:"default:0"`                                           // Download rate limit shared by the inbound's clients in Mbit/s, 0 = unlimited
+	Version              int64                `json:"version" form:"version" gorm:"default:1"`                                                         // Incremented on every config change, for optimistic concurrency
 	ClientStats          []xray.ClientTraffic `gorm:"foreignKey:InboundId;references:Id" json:"clientStats" form:"clientStats"`                        // Client traffic statistics
 
 	// Xray configuration fields
@@ -57,24 +66,359 @@ type Inbound struct {
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
 }
 
-// OutboundTraffics tracks traffic statistics for Xray outbound connections.
+// OutboundTraffics tracks traffic statistics for Xray outbound connections. Each server
+// keeps its own record per outbound tag.
 type OutboundTraffics struct {
 	Id       int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	ServerId int    `json:"serverId" form:"serverId" gorm:"index"` // Foreign key to Server (for multi-server support)
-	Tag      string `json:"tag" form:"tag" gorm:"unique"`
+	ServerId int    `json:"serverId" form:"serverId" gorm:"index;uniqueIndex:idx_outbound_traffics_server_tag,priority:1"` // Foreign key to Server (for multi-server support)
+	Tag      string `json:"tag" form:"tag" gorm:"uniqueIndex:idx_outbound_traffics_server_tag,priority:2"`
 	Up       int64  `json:"up" form:"up" gorm:"default:0"`
 	Down     int64  `json:"down" form:"down" gorm:"default:0"`
 	Total    int64  `json:"total" form:"total" gorm:"default:0"`
+
+	ServerName string `json:"serverName,omitempty" gorm:"-"` // Name of the owning server (not stored in DB, populated at runtime)
 }
 
 // InboundClientIps stores IP addresses associated with inbound clients for access control.
+// Each server keeps its own record per client so IP limits can be enforced fleet-wide.
 type InboundClientIps struct {
 	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
-	ServerId    int    `json:"serverId" form:"serverId" gorm:"index"` // Foreign key to Server (for multi-server support)
-	ClientEmail string `json:"clientEmail" form:"clientEmail" gorm:"unique"`
+	ServerId    int    `json:"serverId" form:"serverId" gorm:"index;uniqueIndex:idx_client_ips_server_email,priority:1"` // Foreign key to Server (for multi-server support)
+	ClientEmail string `json:"clientEmail" form:"clientEmail" gorm:"uniqueIndex:idx_client_ips_server_email,priority:2"`
 	Ips         string `json:"ips" form:"ips"`
 }
 
+// BlockedIP is a fleet-wide blocklist entry pushed to every server.
+// Entries come from admins ("manual") or automatic abuse detection ("auto").
+type BlockedIP struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	IP        string `json:"ip" gorm:"unique;not null"`            // Single address or CIDR (e.g., "203.0.113.0/24")
+	Reason    string `json:"reason"`                               // Why the address was blocked
+	Source    string `json:"source" gorm:"default:'manual';index"` // "manual" or "auto"
+	ExpiresAt int64  `json:"expiresAt" gorm:"default:0;index"`     // Unix timestamp, 0 = never expires
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// LoginAttempt is a panel login attempt, kept so brute-force counters and lockouts
+// survive panel restarts.
+type LoginAttempt struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Username  string `json:"username" gorm:"index"`
+	IP        string `json:"ip" gorm:"index"`
+	Success   bool   `json:"success" gorm:"index"`
+	Reason    string `json:"reason"` // Why a login failed: "invalid_credentials", "locked" or "captcha"; "unlocked" for admin resets
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime;index"`
+}
+
+// ClientTrafficSample is a periodic snapshot of a client's counters on a server.
+// all_time only grows, so usage over a period is the difference between two samples.
+type ClientTrafficSample struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId  int    `json:"serverId" gorm:"index:idx_traffic_sample,priority:1"`
+	Email     string `json:"email" gorm:"index:idx_traffic_sample,priority:2"`
+	InboundId int    `json:"inboundId"`
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+	AllTime   int64  `json:"allTime"`
+	CreatedAt int64  `json:"createdAt" gorm:"index:idx_traffic_sample,priority:3"` // Unix timestamp
+}
+
+// TrafficHistory is the traffic of an inbound or a client on a server during one day.
+// Email is empty for the row of a whole inbound. The counters seen by the last snapshot
+// are kept, so the next snapshot only adds what was used since.
+type TrafficHistory struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId    int    `json:"serverId" gorm:"index:idx_traffic_history,priority:2"`
+	InboundId   int    `json:"inboundId"`
+	Email       string `json:"email" gorm:"index"`
+	Date        string `json:"date" gorm:"index:idx_traffic_history,priority:1"` // YYYY-MM-DD in the panel timezone
+	Up          int64  `json:"up"`                                               // Bytes uploaded during the day
+	Down        int64  `json:"down"`                                             // Bytes downloaded during the day
+	UpCounter   int64  `json:"-"`                                                // Up counter at the last snapshot
+	DownCounter int64  `json:"-"`                                                // Down counter at the last snapshot
+	UpdatedAt   int64  `json:"updatedAt"`                                        // Unix timestamp of the last snapshot
+}
+
+// ServerMetricSample is a periodic snapshot of a server's load kept for history charts
+// and exports. Old samples are downsampled into hourly averages.
+type ServerMetricSample struct {
+	Id         int     `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId   int     `json:"serverId" gorm:"index:idx_metric_sample,priority:1"`
+	Cpu        float64 `json:"cpu"`                                                 // Percentage (0-100)
+	Mem        float64 `json:"mem"`                                                 // Percentage (0-100)
+	Disk       float64 `json:"disk"`                                                // Percentage (0-100)
+	MemUsed    uint64  `json:"memUsed"`                                             // Bytes
+	DiskUsed   uint64  `json:"diskUsed"`                                            // Bytes
+	NetUp      int64   `json:"netUp"`                                               // Bytes/sec
+	NetDown    int64   `json:"netDown"`                                             // Bytes/sec
+	Online     int     `json:"online"`                                              // Online clients
+	Resolution int     `json:"resolution"`                                          // Seconds averaged into the sample, 0 = raw sample
+	CreatedAt  int64   `json:"createdAt" gorm:"index:idx_metric_sample,priority:2"` // Unix timestamp
+}
+
+// TrafficResetEvent records a traffic counter reset performed by the panel.
+// Email is empty when the event covers a whole inbound.
+type TrafficResetEvent struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId  int    `json:"serverId" gorm:"index"`
+	InboundId int    `json:"inboundId"`
+	Email     string `json:"email" gorm:"index"`
+	Up        int64  `json:"up"`     // Counter value before the reset
+	Down      int64  `json:"down"`   // Counter value before the reset
+	Reason    string `json:"reason"` // "daily", "weekly", "monthly" or "manual"
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime;index"`
+}
+
+// Reseller is a restricted account that provisions clients on permitted servers
+// from a pool of traffic and client slots. Resellers authenticate with an API token.
+type Reseller struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Username    string `json:"username" gorm:"unique;not null"`
+	TokenHash   string `json:"-" gorm:"uniqueIndex"`         // SHA-256 of the API token
+	Enable      bool   `json:"enable" gorm:"default:true"`   // Disabled resellers cannot use the API
+	ServerIds   string `json:"serverIds"`                    // JSON array of permitted server IDs
+	TrafficPool int64  `json:"trafficPool" gorm:"default:0"` // Bytes allocatable to clients, 0 = unlimited
+	ClientPool  int    `json:"clientPool" gorm:"default:0"`  // Maximum number of clients, 0 = unlimited
+	Remark      string `json:"remark"`
+	CreatedAt   int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// ServerAPIKey grants read-only API access to the status, traffic and clients of a
+// single server, e.g. for the customer running on it or a downstream system.
+type ServerAPIKey struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId   int    `json:"serverId" gorm:"index"`
+	Name       string `json:"name" form:"name" gorm:"not null"`
+	KeyHash    string `json:"-" gorm:"uniqueIndex"` // SHA-256 of the key
+	Prefix     string `json:"prefix"`               // First characters of the key, to tell keys apart
+	Enable     bool   `json:"enable" form:"enable" gorm:"default:true"`
+	ExpiresAt  int64  `json:"expiresAt" form:"expiresAt"` // Unix timestamp, 0 = never
+	LastUsedAt int64  `json:"lastUsedAt"`
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// EnrollmentToken is a one-time token an agent registers itself with. Registering
+// creates the server and consumes the token.
+type EnrollmentToken struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" form:"name"`                         // Name of the server to create, empty = name reported by the agent
+	TokenHash string `json:"-" gorm:"uniqueIndex"`                     // SHA-256 of the token
+	Prefix    string `json:"prefix"`                                   // First characters of the token, to tell tokens apart
+	AuthType  string `json:"authType" form:"authType" gorm:"not null"` // "mtls" or "jwt"
+	Region    string `json:"region" form:"region"`                     // Region of the server to create
+	Tags      string `json:"tags" form:"tags"`                         // JSON array of tags added to the server
+	ExpiresAt int64  `json:"expiresAt" form:"expiresAt"`               // Unix timestamp
+	UsedAt    int64  `json:"usedAt"`                                   // Unix timestamp of the registration, 0 = unused
+	UsedBy    string `json:"usedBy"`                                   // Address the registration came from
+	ServerId  int    `json:"serverId"`                                 // Server created by the registration
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// ResellerClient records a client created by a reseller and the traffic it was allotted.
+type ResellerClient struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ResellerId int    `json:"resellerId" gorm:"index"`
+	ServerId   int    `json:"serverId" gorm:"uniqueIndex:idx_reseller_client"`
+	InboundId  int    `json:"inboundId"`
+	Email      string `json:"email" gorm:"uniqueIndex:idx_reseller_client"`
+	SubId      string `json:"subId"`
+	Total      int64  `json:"total"` // Allotted traffic in bytes, counted against the pool
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// TrialClient records a time- and traffic-limited client created through the trial API.
+// It is removed from its server once it expires or uses up its traffic.
+type TrialClient struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId  int    `json:"serverId" gorm:"index"`
+	InboundId int    `json:"inboundId"`
+	Email     string `json:"email"`
+	SubId     string `json:"subId"`
+	Total     int64  `json:"total"`               // Traffic quota in bytes
+	ExpiresAt int64  `json:"expiresAt"`           // Unix timestamp
+	Status    string `json:"status" gorm:"index"` // "active" or "removed"
+	RemovedAt int64  `json:"removedAt"`           // Unix timestamp, 0 while active
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// InboundOwner assigns an inbound on a server, and the clients on it, to a reseller.
+// Only the owner sees the inbound through the reseller API; unowned inbounds belong to the admin.
+type InboundOwner struct {
+	Id         int   `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId   int   `json:"serverId" gorm:"uniqueIndex:idx_inbound_owner"`
+	InboundId  int   `json:"inboundId" gorm:"uniqueIndex:idx_inbound_owner"`
+	ResellerId int   `json:"resellerId" gorm:"index"`
+	AssignedAt int64 `json:"assignedAt"` // Unix timestamp of the last assignment or transfer
+}
+
+// RenewalToken is a self-service renewal link issued for a subscription. The renewal
+// is applied to the subscription's clients on every server once payment is confirmed.
+type RenewalToken struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Token        string `json:"token" gorm:"uniqueIndex;not null"`
+	SubId        string `json:"subId" gorm:"index;not null"`
+	Days         int    `json:"days"`                                  // Days added to the expiry time
+	ResetTraffic bool   `json:"resetTraffic"`                          // Reset traffic counters when applied
+	PaymentUrl   string `json:"paymentUrl"`                            // Where the client is sent to pay
+	Status       string `json:"status" gorm:"default:'pending';index"` // "pending", "applying", "applied", "failed" or "cancelled"
+	PaymentId    string `json:"paymentId"`                             // Reference from the payment webhook
+	Error        string `json:"error,omitempty"`
+	ExpiresAt    int64  `json:"expiresAt"` // Unix timestamp after which the link is invalid
+	AppliedAt    int64  `json:"appliedAt"`
+	CreatedAt    int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// ServerEvent is an entry of a server's timeline, such as a status change or a
+// configuration push by the panel.
+type ServerEvent struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId  int    `json:"serverId" gorm:"index:idx_server_event,priority:1"`
+	Type      string `json:"type"` // "status", "xray", "config", "cert" or "agent"
+	Message   string `json:"message"`
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime;index:idx_server_event,priority:2"`
+}
+
+// Incident is an outage of a server: it opens when the server goes offline or into error
+// and closes when it is back online.
+type Incident struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId  int    `json:"serverId" gorm:"index:idx_incident_server,priority:1"`
+	Status    string `json:"status"` // Server status that opened the incident: "offline" or "error"
+	Cause     string `json:"cause"`  // Error reported when the incident opened
+	StartedAt int64  `json:"startedAt" gorm:"index:idx_incident_server,priority:2"`
+	EndedAt   int64  `json:"endedAt"`  // 0 while the incident is open
+	Duration  int64  `json:"duration"` // Seconds, set when the incident closes
+
+	// Owning server name for listings (not stored in DB, populated at runtime)
+	ServerName string `json:"serverName,omitempty" gorm:"-"`
+}
+
+// HealthCheckRun is one sweep of the server health job over the remote servers.
+type HealthCheckRun struct {
+	Id        int   `json:"id" gorm:"primaryKey;autoIncrement"`
+	StartedAt int64 `json:"startedAt" gorm:"index"` // Unix timestamp
+	Duration  int64 `json:"duration"`               // Milliseconds
+	Servers   int   `json:"servers"`                // Remote servers checked
+	Online    int   `json:"online"`
+	Offline   int   `json:"offline"`
+	Errors    int   `json:"errors"`
+}
+
+// AlertRule fires an alert when a metric of a server, or of a client or certificate on
+// it, stays past a threshold for a while.
+type AlertRule struct {
+	Id        int     `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string  `json:"name" form:"name" gorm:"not null"`
+	Metric    string  `json:"metric" form:"metric"`       // server_down, cpu, memory, disk, traffic_quota or cert_expiry
+	Threshold float64 `json:"threshold" form:"threshold"` // Percent used, or days left for cert_expiry
+	Duration  int     `json:"duration" form:"duration"`   // Minutes the threshold must be crossed before firing
+	Severity  string  `json:"severity" form:"severity"`   // info, warning or critical
+	ServerId  int     `json:"serverId" form:"serverId"`   // 0 = all servers
+	Channels  string  `json:"channels" form:"channels"`   // Comma-separated: telegram, webhook, email
+	Enable    bool    `json:"enable" form:"enable"`
+	CreatedAt int64   `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt int64   `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// Alert is a firing of an alert rule for one server, client or certificate.
+type Alert struct {
+	Id         int     `json:"id" gorm:"primaryKey;autoIncrement"`
+	RuleId     int     `json:"ruleId" gorm:"index"`
+	ServerId   int     `json:"serverId" gorm:"index"`
+	Subject    string  `json:"subject"` // Client email or certificate domain, empty for the server itself
+	Metric     string  `json:"metric"`
+	Severity   string  `json:"severity"`
+	Value      float64 `json:"value"` // Value when the alert fired
+	Threshold  float64 `json:"threshold"`
+	StartedAt  int64   `json:"startedAt" gorm:"index"`
+	ResolvedAt int64   `json:"resolvedAt"` // 0 while the alert is firing
+
+	// Names for listings (not stored in DB, populated at runtime)
+	RuleName   string `json:"ruleName,omitempty" gorm:"-"`
+	ServerName string `json:"serverName,omitempty" gorm:"-"`
+}
+
+// Webhook is an outgoing webhook that receives panel events as signed JSON.
+type Webhook struct {
+	Id             int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name           string `json:"name" form:"name" gorm:"not null"`
+	Url            string `json:"url" form:"url"`
+	Secret         string `json:"secret" form:"secret"` // HMAC-SHA256 key of the X-Webhook-Signature header
+	Events         string `json:"events" form:"events"` // Comma-separated event names, empty = all events
+	Enable         bool   `json:"enable" form:"enable"`
+	LastDeliveryAt int64  `json:"lastDeliveryAt"` // Unix timestamp of the last delivery attempt
+	LastStatus     int    `json:"lastStatus"`     // HTTP status of the last delivery, 0 if there was no answer
+	LastError      string `json:"lastError"`
+	CreatedAt      int64  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt      int64  `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// GeoFileStatus tracks scheduled geo file updates of one file on one server.
+type GeoFileStatus struct {
+	Id            int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId      int    `json:"serverId" gorm:"uniqueIndex:idx_geo_file_status"`
+	File          string `json:"file" gorm:"uniqueIndex:idx_geo_file_status"` // e.g. "geoip.dat"
+	LastUpdatedAt int64  `json:"lastUpdatedAt"`                               // Unix timestamp of the last successful update
+	LastAttemptAt int64  `json:"lastAttemptAt"`                               // Unix timestamp of the last attempt
+	Failures      int    `json:"failures"`                                    // Consecutive failed attempts
+	LastError     string `json:"lastError,omitempty"`
+}
+
+// ServerSettingsSync records which fleet settings version a server has applied and
+// the outcome of the last push to it.
+type ServerSettingsSync struct {
+	Id               int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId         int    `json:"serverId" gorm:"uniqueIndex"`
+	AppliedVersion   int    `json:"appliedVersion"`      // Fleet settings version last applied successfully
+	AttemptedVersion int    `json:"attemptedVersion"`    // Fleet settings version of the last push
+	Keys             string `json:"keys"`                // JSON array of the setting keys in the last push
+	Status           string `json:"status" gorm:"index"` // "applied" or "failed"
+	AppliedAt        int64  `json:"appliedAt"`           // Unix timestamp of the last successful push
+	AttemptedAt      int64  `json:"attemptedAt"`         // Unix timestamp of the last push
+	LastError        string `json:"lastError,omitempty"`
+}
+
+// ServerScope is a named selection of servers for aggregated dashboard views, such as
+// "EU production". A server belongs to the scope if it matches any of the criteria.
+type ServerScope struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" gorm:"unique;not null"`
+	Tags      string `json:"tags"`      // JSON array of server tags
+	Regions   string `json:"regions"`   // JSON array of server regions
+	ServerIds string `json:"serverIds"` // JSON array of explicit server IDs
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// ServerGroup is a named, hand-picked set of servers that bulk operations (Xray restarts,
+// geo file updates, inbound deployments) can target as a whole.
+type ServerGroup struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name        string `json:"name" gorm:"unique;not null"`
+	Description string `json:"description"`
+	ServerIds   string `json:"serverIds"` // JSON array of member server IDs
+	CreatedAt   int64  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt   int64  `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// InboundArchive is the full definition of an inbound, with its clients and their
+// traffic, saved when the inbound was deleted from a server so it can be restored.
+type InboundArchive struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ServerId    int    `json:"serverId" gorm:"not null;index"`
+	InboundId   int    `json:"inboundId"` // ID of the inbound on its server before deletion
+	Tag         string `json:"tag"`
+	Remark      string `json:"remark"`
+	Protocol    string `json:"protocol"`
+	Port        int    `json:"port"`
+	ClientCount int    `json:"clientCount"`
+	Definition  string `json:"definition,omitempty"` // JSON of the inbound with settings and client stats
+	ArchivedAt  int64  `json:"archivedAt" gorm:"autoCreateTime"`
+	RestoredAt  int64  `json:"restoredAt"` // 0 until restored
+
+	// Owning server name for listings (not stored in DB, populated at runtime)
+	ServerName string `json:"serverName,omitempty" gorm:"-"`
+}
+
 // HistoryOfSeeders tracks which database seeders have been executed to prevent re-running.
 type HistoryOfSeeders struct {
 	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -120,27 +464,37 @@ type Client struct {
 	SubID      string `json:"subId" form:"subId"`           // Subscription identifier
 	Comment    string `json:"comment" form:"comment"`       // Client comment
 	Reset      int    `json:"reset" form:"reset"`           // Reset period in days
+	SpeedUp    int    `json:"speedLimitUp,omitempty"`       // Upload rate limit in Mbit/s, 0 = unlimited
+	SpeedDown  int    `json:"speedLimitDown,omitempty"`     // Download rate limit in Mbit/s, 0 = unlimited
 	CreatedAt  int64  `json:"created_at,omitempty"`         // Creation timestamp
 	UpdatedAt  int64  `json:"updated_at,omitempty"`         // Last update timestamp
 }
 
 // Server represents a managed VPN server in multi-server architecture.
-// In single-server mode, there is only one server with ID=1 (local).
+// In single-server mode, there is only one server, the local one (auth type "local").
 type Server struct {
 	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
 	Name     string `json:"name" gorm:"unique;not null"` // Unique server name (e.g., "US-East-1")
 	Endpoint string `json:"endpoint" gorm:"not null"`    // Agent endpoint (e.g., "https://vpn1.example.com:2054")
 	Region   string `json:"region"`                      // Geographic region (e.g., "us-east")
+	Timezone string `json:"timezone"`                    // IANA timezone for schedules and reports (e.g., "Europe/Berlin"), empty = panel timezone
 	Tags     string `json:"tags"`                        // JSON array of tags (e.g., ["production", "us"])
 
 	// Authentication
-	AuthType string `json:"authType" gorm:"not null"` // "mtls", "jwt", or "local"
-	AuthData string `json:"authData"`                 // Encrypted secret or certificate reference (encrypted)
+	AuthType string `json:"authType" gorm:"not null"` // "mtls", "jwt", "local" or "demo"
+	AuthData string `json:"authData"`                 // Credentials or a secret reference, encrypted when XUI_AUTHDATA_KEY is set
 
 	// Status
 	Status    string `json:"status" gorm:"default:'pending';index"` // "pending", "online", "offline", "error"
 	LastSeen  int64  `json:"lastSeen"`                              // Unix timestamp of last successful health check
 	LastError string `json:"lastError"`                             // Last error message (if status is "error")
+	ClockSkew int64  `json:"clockSkew"`                             // Seconds the agent clock is ahead of the panel (negative = behind)
+	Warning   string `json:"warning"`                               // Problem that does not make the server unusable, e.g. clock drift
+
+	// Config epoch, bumped on every config change the agent applied
+	ConfigEpoch     int  `json:"configEpoch"`     // Epoch of the last change the panel made
+	AgentEpoch      int  `json:"agentEpoch"`      // Epoch the agent reported in its last health check
+	ConfigOutOfSync bool `json:"configOutOfSync"` // Epochs differed at the last health check
 
 	// Metadata
 	Version     string `json:"version"`     // Agent version
@@ -156,6 +510,12 @@ type Server struct {
 	UpdatedAt int64 `json:"updatedAt" gorm:"autoUpdateTime"`
 }
 
+// IsLocal reports whether the server is the panel's own Xray instance, managed in-process
+// instead of through an agent.
+func (s *Server) IsLocal() bool {
+	return s.AuthType == "local"
+}
+
 // ServerTask represents an operation executed on a managed server.
 // Used for audit logging and async job tracking.
 type ServerTask struct {
@@ -163,11 +523,16 @@ type ServerTask struct {
 	ServerId int    `json:"serverId" gorm:"not null;index"` // Foreign key to Server
 	Server   Server `json:"server" gorm:"foreignKey:ServerId"`
 
+	// Owning server labels for listings (not stored in DB, populated at runtime)
+	ServerName string   `json:"serverName,omitempty" gorm:"-"`
+	ServerTags []string `json:"serverTags,omitempty" gorm:"-"`
+
 	Operation string `json:"operation" gorm:"not null"`                      // Operation type (e.g., "add_inbound", "restart_xray")
 	Status    string `json:"status" gorm:"not null;index;default:'pending'"` // "pending", "running", "completed", "failed"
 
 	// Request/Response
-	RequestData  string `json:"requestData"`  // JSON of input parameters
+	RequestData  string `json:"requestData"`  // JSON of input parameters, credentials masked
+	Payload      string `json:"-"`            // Unmasked input of tasks that are replayed, never exposed
 	ResponseData string `json:"responseData"` // JSON of operation result
 	ErrorMessage string `json:"errorMessage"` // Error details if failed
 
@@ -175,6 +540,12 @@ type ServerTask struct {
 	StartedAt   int64 `json:"startedAt"`                   // Unix timestamp when task started
 	CompletedAt int64 `json:"completedAt"`                 // Unix timestamp when task completed
 	RetryCount  int   `json:"retryCount" gorm:"default:0"` // Number of retry attempts
+	MaxRetries  int   `json:"maxRetries" gorm:"default:0"` // Retries allowed to queued tasks before they fail
+	NextRunAt   int64 `json:"nextRunAt" gorm:"index"`      // Unix timestamp before which a queued task is not run
+
+	// Progress of long operations such as Xray installs
+	Progress int    `json:"progress" gorm:"default:0"` // Percent done
+	Stage    string `json:"stage"`                     // Current step, e.g. "downloading"
 
 	// Audit
 	UserId int `json:"userId"` // Admin user who triggered this operation
@@ -216,3 +216,8 @@ func GetLogs(c int, level string) []string {
 	}
 	return output
 }
+
+// GetLogFilePath returns the path of the log file written by the file backend.
+func GetLogFilePath() string {
+	return filepath.Join(config.GetLogFolder(), logFileName)
+}
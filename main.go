@@ -71,7 +71,7 @@ func runWebServer() {
 
 	sigCh := make(chan os.Signal, 1)
 	// Trap shutdown signals
-	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
 	for {
 		sig := <-sigCh
 
@@ -115,9 +115,16 @@ func runWebServer() {
 			service.StopBot()
 			// ------------------------------------------------------------
 
-			server.Stop()
-			subServer.Stop()
 			log.Println("Shutting down servers.")
+			if err := server.Stop(); err != nil {
+				logger.Warning("Error stopping web server:", err)
+			}
+			if err := subServer.Stop(); err != nil {
+				logger.Warning("Error stopping sub server:", err)
+			}
+			if err := database.CloseDB(); err != nil {
+				logger.Warning("Error closing database:", err)
+			}
 			return
 		}
 	}
@@ -395,6 +402,16 @@ func migrateDb() {
 	fmt.Println("Migration done!")
 }
 
+// restoreStandby replaces the panel database with a standby snapshot. The panel must be stopped.
+func restoreStandby(source, token string) {
+	restored, backup, err := service.RestoreStandbySnapshot(source, token)
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	fmt.Println("Restored standby snapshot", restored)
+	fmt.Println("The previous database was kept as", backup)
+}
+
 // main is the entry point of the 3x-ui application.
 // It parses command-line arguments to run the web server, migrate database, or update settings.
 func main() {
@@ -408,6 +425,12 @@ func main() {
 
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
 
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	var restoreFile string
+	var restoreToken string
+	restoreCmd.StringVar(&restoreFile, "file", "", "Standby snapshot file, directory of snapshots (newest is used) or https URL")
+	restoreCmd.StringVar(&restoreToken, "token", "", "Bearer token for downloading an https snapshot")
+
 	settingCmd := flag.NewFlagSet("setting", flag.ExitOnError)
 	var port int
 	var username string
@@ -451,6 +474,7 @@ func main() {
 		fmt.Println("    agent          run as agent (for remote VPN servers)")
 		fmt.Println("    migrate        migrate form other/old x-ui")
 		fmt.Println("    setting        set settings")
+		fmt.Println("    restore        restore the panel database from a standby snapshot")
 	}
 
 	flag.Parse()
@@ -477,6 +501,17 @@ func main() {
 		}
 	case "migrate":
 		migrateDb()
+	case "restore":
+		err := restoreCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if restoreFile == "" {
+			restoreCmd.Usage()
+			return
+		}
+		restoreStandby(restoreFile, restoreToken)
 	case "setting":
 		err := settingCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -14,9 +14,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cofedish/3x-UI-agents/logger"
-	"github.com/cofedish/3x-UI-agents/util/common"
 	webpkg "github.com/cofedish/3x-UI-agents/web"
 	"github.com/cofedish/3x-UI-agents/web/locale"
 	"github.com/cofedish/3x-UI-agents/web/middleware"
@@ -26,6 +26,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// shutdownTimeout bounds how long Stop waits for in-flight requests.
+const shutdownTimeout = 10 * time.Second
+
 // setEmbeddedTemplates parses and sets embedded templates on the engine
 func setEmbeddedTemplates(engine *gin.Engine) error {
 	t, err := template.New("").Funcs(engine.FuncMap).ParseFS(
@@ -335,18 +338,25 @@ func (s *Server) Start() (err error) {
 }
 
 // Stop gracefully shuts down the subscription server and closes the listener.
+// In-flight requests get up to shutdownTimeout to finish.
 func (s *Server) Stop() error {
-	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	defer s.cancel()
 
-	var err1 error
-	var err2 error
 	if s.httpServer != nil {
-		err1 = s.httpServer.Shutdown(s.ctx)
+		// Shutdown also closes the listener
+		err := s.httpServer.Shutdown(ctx)
+		if err != nil {
+			logger.Warning("Subscription server did not drain in time:", err)
+			s.httpServer.Close()
+		}
+		return err
 	}
 	if s.listener != nil {
-		err2 = s.listener.Close()
+		return s.listener.Close()
 	}
-	return common.Combine(err1, err2)
+	return nil
 }
 
 // GetCtx returns the server's context for cancellation and deadline management.
@@ -4,8 +4,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/web/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +23,8 @@ type SUBController struct {
 
 	subService     *SubService
 	subJsonService *SubJsonService
+	clientUsage    *service.ClientUsageService
+	renewals       *service.RenewalService
 }
 
 // NewSUBController creates a new subscription controller with the given configuration.
@@ -50,6 +54,8 @@ func NewSUBController(
 
 		subService:     sub,
 		subJsonService: NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, sub),
+		clientUsage:    &service.ClientUsageService{},
+		renewals:       &service.RenewalService{},
 	}
 	a.initRouter(g)
 	return a
@@ -60,6 +66,8 @@ func NewSUBController(
 func (a *SUBController) initRouter(g *gin.RouterGroup) {
 	gLink := g.Group(a.subPath)
 	gLink.GET(":subid", a.subs)
+	gLink.GET(":subid/usage", a.usage)
+	gLink.GET(":subid/renew/:token", a.renew)
 	if a.jsonEnabled {
 		gJson := g.Group(a.subJsonPath)
 		gJson.GET(":subid", a.subJsons)
@@ -137,6 +145,44 @@ func (a *SUBController) subs(c *gin.Context) {
 	}
 }
 
+// usage returns the subscription's remaining quota, expiry and per-server usage as JSON.
+// The subId itself is the credential, as for the subscription links.
+func (a *SUBController) usage(c *gin.Context) {
+	subId := c.Param("subid")
+	usage, err := a.clientUsage.GetUsageBySubId(c.Request.Context(), subId)
+	if err != nil {
+		c.String(400, "Error!")
+		return
+	}
+	c.JSON(200, usage)
+}
+
+// renew shows a signed renewal link to the client. Pending renewals redirect to the
+// payment page when one is set; the renewal is applied by the payment webhook.
+func (a *SUBController) renew(c *gin.Context) {
+	renewal, err := a.renewals.VerifyRenewalLink(c.Param("subid"), c.Param("token"), c.Query("sig"))
+	if err != nil {
+		c.String(404, "Error!")
+		return
+	}
+
+	expired := renewal.Status == "pending" && time.Now().Unix() > renewal.ExpiresAt
+	if renewal.Status == "pending" && !expired && renewal.PaymentUrl != "" && c.Query("format") != "json" {
+		c.Redirect(302, renewal.PaymentUrl)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"subId":        renewal.SubId,
+		"days":         renewal.Days,
+		"resetTraffic": renewal.ResetTraffic,
+		"status":       renewal.Status,
+		"expired":      expired,
+		"expiresAt":    renewal.ExpiresAt,
+		"appliedAt":    renewal.AppliedAt,
+	})
+}
+
 // subJsons handles HTTP requests for JSON subscription configurations.
 func (a *SUBController) subJsons(c *gin.Context) {
 	subId := c.Param("subid")
@@ -98,11 +98,13 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 				inbound.StreamSettings = streamSettings
 			}
 		}
+		address := s.SubService.inboundAddress(inbound, host)
+		s.SubService.addTunnelRelays(inbound, address)
 
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
-				newConfigs := s.getConfig(inbound, client, host)
+				newConfigs := s.getConfig(inbound, client, address)
 				configArray = append(configArray, newConfigs...)
 			}
 		}
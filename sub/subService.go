@@ -1,6 +1,7 @@
 package sub
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net"
@@ -28,6 +29,8 @@ type SubService struct {
 	datepicker     string
 	inboundService service.InboundService
 	settingService service.SettingService
+	tunnelService  service.TunnelService
+	serverMgmt     service.ServerManagementService
 }
 
 // NewSubService creates a new subscription service with the given configuration.
@@ -74,6 +77,8 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 				inbound.StreamSettings = streamSettings
 			}
 		}
+		s.address = s.inboundAddress(inbound, host)
+		s.addTunnelRelays(inbound, s.address)
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				link := s.getLink(inbound, client.Email)
@@ -129,6 +134,64 @@ func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error)
 	return inbounds, nil
 }
 
+// inboundAddress returns the address published for an inbound: its address override,
+// the endpoint host of the remote server it belongs to, or else host, the address the
+// subscription was requested on.
+func (s *SubService) inboundAddress(inbound *model.Inbound, host string) string {
+	if inbound.AddressOverride != "" {
+		return inbound.AddressOverride
+	}
+	if inbound.ServerId != 0 && !s.serverMgmt.IsLocalServer(inbound.ServerId) {
+		if address, err := s.serverMgmt.GetPublicHost(inbound.ServerId); err == nil {
+			return address
+		}
+	}
+	return host
+}
+
+// addTunnelRelays adds every tunnel relaying to the inbound as an external proxy, so
+// clients also get links through the tunnel entry points. The direct address is kept.
+func (s *SubService) addTunnelRelays(inbound *model.Inbound, host string) {
+	serverId := inbound.ServerId
+	if serverId == 0 {
+		serverId = s.serverMgmt.GetLocalServerId()
+	}
+	relays := s.tunnelService.GetRelays(context.Background(), serverId, inbound.Id)
+	if len(relays) == 0 {
+		return
+	}
+
+	var stream map[string]any
+	if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil || stream == nil {
+		return
+	}
+	externalProxies, _ := stream["externalProxy"].([]any)
+	if len(externalProxies) == 0 {
+		externalProxies = append(externalProxies, map[string]any{
+			"forceTls": "same",
+			"dest":     host,
+			"port":     float64(inbound.Port),
+			"remark":   "",
+		})
+	}
+	for _, relay := range relays {
+		dest := relay.Host
+		if dest == "" {
+			dest = host
+		}
+		externalProxies = append(externalProxies, map[string]any{
+			"forceTls": "same",
+			"dest":     dest,
+			"port":     float64(relay.Port),
+			"remark":   relay.Remark,
+		})
+	}
+	stream["externalProxy"] = externalProxies
+	if modified, err := json.MarshalIndent(stream, "", "  "); err == nil {
+		inbound.StreamSettings = string(modified)
+	}
+}
+
 func (s *SubService) getClientTraffics(traffics []xray.ClientTraffic, email string) xray.ClientTraffic {
 	for _, traffic := range traffics {
 		if traffic.Email == email {
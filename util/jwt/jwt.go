@@ -0,0 +1,189 @@
+// Package jwt issues and verifies the HS256 JSON Web Tokens the panel uses to
+// authenticate to agents that accept JWT auth.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultAudience is the "aud" claim of tokens issued for agents.
+const DefaultAudience = "x-ui-agent"
+
+// Verification errors. Verify wraps them, so callers can tell them apart with errors.Is.
+var (
+	ErrMalformed     = errors.New("token is malformed")
+	ErrAlgorithm     = errors.New("token algorithm is not HS256")
+	ErrSignature     = errors.New("token signature is invalid")
+	ErrExpired       = errors.New("token has expired")
+	ErrNotYetValid   = errors.New("token is not valid yet")
+	ErrAudience      = errors.New("token audience does not match")
+	ErrMissingClaims = errors.New("token lacks the exp or iat claim")
+	ErrLifetime      = errors.New("token lifetime exceeds the allowed maximum")
+	ErrEmptySecret   = errors.New("secret is empty")
+)
+
+var errUnsupportedAud = errors.New("aud claim must be a string or an array of strings")
+
+// Claims are the registered claims of an agent token.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`           // Unix seconds
+	IssuedAt  int64    `json:"iat"`           // Unix seconds
+	NotBefore int64    `json:"nbf,omitempty"` // Unix seconds, 0 = not set
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Audience is the "aud" claim, which may be a single string or an array.
+type Audience []string
+
+// MarshalJSON writes a single audience as a string, as most issuers do.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errUnsupportedAud
+	}
+	*a = list
+	return nil
+}
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	Audience  string        // Required "aud" value, empty = any
+	ClockSkew time.Duration // Tolerance for exp, nbf and iat between panel and agent clocks
+	MaxTTL    time.Duration // Maximum exp - iat, 0 = unlimited
+	Now       func() time.Time
+}
+
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign returns claims as a token signed with secret.
+func Sign(claims *Claims, secret string) (string, error) {
+	if secret == "" {
+		return "", ErrEmptySecret
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + signature(signingInput, secret), nil
+}
+
+// Issue returns a token for subject and audience issued now and valid for ttl.
+func Issue(secret, issuer, subject, audience string, ttl time.Duration) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		Issuer:    issuer,
+		Subject:   subject,
+		ExpiresAt: now.Add(ttl).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+	}
+	if audience != "" {
+		claims.Audience = Audience{audience}
+	}
+	token, err := Sign(claims, secret)
+	return token, claims, err
+}
+
+// Verify checks the signature and claims of token and returns its claims. The signature
+// is checked before any claim is trusted.
+func Verify(token, secret string, opts VerifyOptions) (*Claims, error) {
+	if secret == "" {
+		return nil, ErrEmptySecret
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	var head struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(rawHeader, &head); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	if head.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: %q", ErrAlgorithm, head.Alg)
+	}
+
+	expected := signature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, ErrSignature
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformed, err)
+	}
+	claims := &Claims{}
+	if err := json.Unmarshal(rawClaims, claims); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformed, err)
+	}
+	if claims.ExpiresAt == 0 || claims.IssuedAt == 0 {
+		return nil, ErrMissingClaims
+	}
+
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+	skew := int64(opts.ClockSkew / time.Second)
+	unix := now.Unix()
+	if unix > claims.ExpiresAt+skew {
+		return nil, fmt.Errorf("%w at %s", ErrExpired, time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	if unix+skew < claims.IssuedAt || (claims.NotBefore != 0 && unix+skew < claims.NotBefore) {
+		return nil, ErrNotYetValid
+	}
+	if opts.MaxTTL > 0 && claims.ExpiresAt-claims.IssuedAt > int64(opts.MaxTTL/time.Second) {
+		return nil, ErrLifetime
+	}
+	if opts.Audience != "" && !claims.hasAudience(opts.Audience) {
+		return nil, ErrAudience
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether audience is one of the "aud" values.
+func (c *Claims) hasAudience(audience string) bool {
+	for _, aud := range c.Audience {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// signature returns the base64url HMAC-SHA256 of the signing input.
+func signature(signingInput, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
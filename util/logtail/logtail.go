@@ -0,0 +1,194 @@
+// Package logtail follows log files like "tail -F" and classifies their lines by level,
+// for live log streams of the panel and the agents.
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// pollInterval is how often a followed file is checked for new lines.
+	pollInterval = 500 * time.Millisecond
+	// maxChunk bounds how much of a file is read at once, e.g. for the initial tail.
+	maxChunk = 1 << 20
+)
+
+// Level is the severity of a log line, from Debug to Error.
+type Level int
+
+// Log levels in increasing severity.
+const (
+	Debug Level = iota
+	Info
+	Notice
+	Warning
+	Error
+)
+
+var levelNames = []string{"debug", "info", "notice", "warning", "error"}
+
+// String returns the lower-case name of the level.
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "info"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named name. Syslog and Xray spellings ("err", "warn")
+// are accepted too.
+func ParseLevel(name string) (Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return Debug, true
+	case "info", "":
+		return Info, true
+	case "notice":
+		return Notice, true
+	case "warning", "warn":
+		return Warning, true
+	case "error", "err":
+		return Error, true
+	}
+	return Info, false
+}
+
+// levelMarkers map the level markers of the panel and agent logger ("INFO - ") and of
+// the Xray error log ("[Info]") to levels.
+var levelMarkers = []struct {
+	marker string
+	level  Level
+}{
+	{" DEBUG - ", Debug}, {"[Debug]", Debug},
+	{" INFO - ", Info}, {"[Info]", Info},
+	{" NOTICE - ", Notice},
+	{" WARNING - ", Warning}, {"[Warning]", Warning},
+	{" ERROR - ", Error}, {"[Error]", Error},
+	{" CRITICAL - ", Error},
+}
+
+// LineLevel returns the level of a log line. Lines without a level marker, such as
+// Xray access log entries, are Info.
+func LineLevel(line string) Level {
+	// Markers follow the timestamp, so only the start of the line is searched
+	head := line
+	if len(head) > 64 {
+		head = head[:64]
+	}
+	head = " " + head
+	for _, m := range levelMarkers {
+		if strings.Contains(head, m.marker) {
+			return m.level
+		}
+	}
+	return Info
+}
+
+// Line is a log line sent to stream subscribers.
+type Line struct {
+	Source string `json:"source"` // Log the line was read from, e.g. "agent" or "xray"
+	Level  string `json:"level"`
+	Text   string `json:"text"`
+}
+
+// Follow calls fn with the last tail lines of the file at path and then with every line
+// appended to it, until ctx is done or fn returns an error. A file that is truncated or
+// replaced, as by log rotation, is followed from its start.
+func Follow(ctx context.Context, path string, tail int, fn func(line string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Initial tail from the end of the file, without a possibly cut first line
+	offset := max(0, info.Size()-maxChunk)
+	chunk, err := readChunk(file, offset, info.Size())
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(chunk, []byte("\n"))
+	if offset > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	pending := lines[len(lines)-1]
+	lines = lines[:len(lines)-1]
+	if tail >= 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+	for _, line := range lines {
+		if err := fn(string(line)); err != nil {
+			return err
+		}
+	}
+	offset += int64(len(chunk))
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := os.Stat(path)
+		if err != nil {
+			// Between rotation and the creation of the new file
+			continue
+		}
+		if !os.SameFile(info, current) {
+			reopened, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			file.Close()
+			file, info = reopened, current
+			offset, pending = 0, nil
+		} else if current.Size() < offset {
+			offset, pending = 0, nil
+		}
+		if current.Size() == offset {
+			continue
+		}
+
+		end := min(current.Size(), offset+maxChunk)
+		chunk, err := readChunk(file, offset, end)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(chunk))
+		lines := bytes.Split(append(pending, chunk...), []byte("\n"))
+		pending = append([]byte(nil), lines[len(lines)-1]...)
+		lines = lines[:len(lines)-1]
+		if len(pending) > maxChunk {
+			// A line that never ends is passed on in pieces
+			lines, pending = append(lines, pending), nil
+		}
+		for _, line := range lines {
+			if err := fn(string(line)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readChunk reads the bytes of file between from and to.
+func readChunk(file *os.File, from, to int64) ([]byte, error) {
+	buf := make([]byte, to-from)
+	n, err := file.ReadAt(buf, from)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,114 @@
+// Package redact masks credentials in payloads before they are logged or persisted.
+package redact
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces the value of a redacted field.
+const Mask = "***"
+
+// sensitiveKeyParts mark a key as a credential when its normalized form contains one of them.
+var sensitiveKeyParts = []string{
+	"password", "passwd", "secret", "token", "privatekey", "authdata", "jwt",
+	"apikey", "authorization", "cookie", "credential", "presharedkey",
+}
+
+// sensitiveKeys mark a key as a credential when its normalized form equals one of them.
+// "key" is the private key of an inline TLS certificate, "pass" a SOCKS/HTTP account
+// password and "psk"/"seed"/"auth" protocol shared secrets.
+var sensitiveKeys = map[string]bool{
+	"key": true, "pass": true, "psk": true, "seed": true, "auth": true,
+}
+
+var (
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	pemPattern    = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// IsSensitiveKey reports whether a field name denotes a credential. Case, "_" and "-"
+// are ignored, so "authData", "auth_data" and "AUTH-DATA" all match.
+func IsSensitiveKey(key string) bool {
+	k := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	if sensitiveKeys[k] {
+		return true
+	}
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(k, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON returns data with every credential field masked. Client "id" fields holding a
+// string (the UUID of a VLESS or VMess client) are masked too, while numeric ids are
+// kept. Strings that contain JSON themselves, such as the settings of an inbound, are
+// redacted recursively. Data that is not JSON is redacted with String.
+func JSON(data []byte) []byte {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return []byte(String(string(data)))
+	}
+	out, err := json.Marshal(Value(v))
+	if err != nil {
+		return []byte(Mask)
+	}
+	return out
+}
+
+// String redacts a JSON document, or masks bearer tokens, JWTs and PEM private keys
+// in free text such as error messages.
+func String(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var v any
+		if json.Unmarshal([]byte(trimmed), &v) == nil {
+			if out, err := json.Marshal(Value(v)); err == nil {
+				return string(out)
+			}
+		}
+	}
+	s = pemPattern.ReplaceAllString(s, Mask)
+	s = jwtPattern.ReplaceAllString(s, Mask)
+	return bearerPattern.ReplaceAllString(s, "${1}"+Mask)
+}
+
+// Value redacts a value decoded from JSON in place and returns it.
+func Value(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for key, value := range t {
+			if masked(key, value) {
+				t[key] = Mask
+				continue
+			}
+			t[key] = Value(value)
+		}
+		return t
+	case []any:
+		for i, value := range t {
+			t[i] = Value(value)
+		}
+		return t
+	case string:
+		return String(t)
+	default:
+		return v
+	}
+}
+
+// masked reports whether the value of key must be replaced by Mask.
+func masked(key string, value any) bool {
+	if value == nil || value == "" {
+		return false
+	}
+	if IsSensitiveKey(key) {
+		return true
+	}
+	_, isString := value.(string)
+	return isString && strings.EqualFold(key, "id")
+}
@@ -0,0 +1,128 @@
+// Package secrets resolves secret references such as "env:AGENT_JWT_SECRET",
+// "file:/run/secrets/token" or "vault:secret/data/x-ui#token" through pluggable
+// providers, so credentials can live in an external secret store instead of the
+// database or plain environment variables.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheTTL is how long values from remote providers are cached, since connectors
+// resolve their credentials on every request.
+const cacheTTL = 5 * time.Minute
+
+// SecretProvider fetches secrets from one store. ref is the part of a reference
+// after "<scheme>:".
+type SecretProvider interface {
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to SecretProvider.
+type ProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// Get calls f.
+func (f ProviderFunc) Get(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]SecretProvider{
+		"env":   ProviderFunc(getEnv),
+		"file":  ProviderFunc(getFile),
+		"vault": &VaultProvider{},
+	}
+	// remote marks providers whose values are cached
+	remote = map[string]bool{"vault": true}
+	cache  = make(map[string]cached)
+)
+
+// Register installs a provider for scheme, replacing any built-in one. Cloud KMS
+// or secret manager integrations register themselves here, e.g. as "awssm" or "gcpsm".
+// Values of registered providers are cached like Vault values.
+func Register(scheme string, p SecretProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	scheme = strings.ToLower(scheme)
+	providers[scheme] = p
+	remote[scheme] = scheme != "env" && scheme != "file"
+	for key := range cache {
+		if strings.HasPrefix(key, scheme+":") {
+			delete(cache, key)
+		}
+	}
+}
+
+// IsReference reports whether value names a secret of a registered provider.
+func IsReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, ":")
+	if !ok {
+		return false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok = providers[strings.ToLower(scheme)]
+	return ok
+}
+
+// Resolve returns the secret value names, or value itself if it is not a reference,
+// so plain values keep working wherever references are accepted.
+func Resolve(ctx context.Context, value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	scheme, ref, _ := strings.Cut(value, ":")
+	scheme = strings.ToLower(scheme)
+	key := scheme + ":" + ref
+
+	mu.RLock()
+	p := providers[scheme]
+	isRemote := remote[scheme]
+	entry, hit := cache[key]
+	mu.RUnlock()
+	if isRemote && hit && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	secret, err := p.Get(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s secret %q: %w", scheme, ref, err)
+	}
+	if isRemote {
+		mu.Lock()
+		cache[key] = cached{value: secret, expires: time.Now().Add(cacheTTL)}
+		mu.Unlock()
+	}
+	return secret, nil
+}
+
+// getEnv reads a secret from an environment variable.
+func getEnv(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// getFile reads a secret from a file, such as a Docker or Kubernetes secret mount.
+// A single trailing newline is dropped.
+func getFile(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
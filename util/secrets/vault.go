@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultProvider reads secrets from HashiCorp Vault KV engines. References look like
+// "secret/data/x-ui/agent-01#token": the API path of the secret, then the field to
+// return ("value" if omitted). Both KV v2 ("<mount>/data/<path>") and KV v1 paths work.
+//
+// The zero value is configured from VAULT_ADDR, VAULT_TOKEN (or VAULT_TOKEN_FILE)
+// and the optional VAULT_NAMESPACE.
+type VaultProvider struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// Get fetches one field of a Vault secret.
+func (v *VaultProvider) Get(ctx context.Context, ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if field == "" {
+		field = "value"
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", fmt.Errorf("secret path is required")
+	}
+
+	addr := v.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token, err := v.token()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	namespace := v.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned HTTP %d", resp.StatusCode)
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	data := secret.Data
+	// KV v2 nests the fields under data.data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, isV2 := data["metadata"]; isV2 {
+			data = nested
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	// Structured fields, such as mTLS auth data stored as an object, are returned as JSON
+	out, err := json.Marshal(value)
+	return string(out), err
+}
+
+// token returns the Vault token from the provider, VAULT_TOKEN or VAULT_TOKEN_FILE.
+func (v *VaultProvider) token() (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	if file := os.Getenv("VAULT_TOKEN_FILE"); file != "" {
+		return getFile(context.Background(), file)
+	}
+	return "", fmt.Errorf("VAULT_TOKEN is not set")
+}
@@ -4,7 +4,25 @@ package sys
 
 import (
 	_ "unsafe"
+
+	"github.com/shirou/gopsutil/v4/net"
 )
 
 //go:linkname HostProc github.com/shirou/gopsutil/v4/internal/common.HostProc
 func HostProc(combineWith ...string) string
+
+// GetConnectionsByPort returns the number of established TCP connections per local port.
+func GetConnectionsByPort() (map[int]int, error) {
+	conns, err := net.Connections("tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int)
+	for _, conn := range conns {
+		if conn.Status == "ESTABLISHED" {
+			counts[int(conn.Laddr.Port)]++
+		}
+	}
+	return counts, nil
+}
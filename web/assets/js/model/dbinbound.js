@@ -12,6 +12,9 @@ class DBInbound {
         this.expiryTime = 0;
         this.trafficReset = "never";
         this.lastTrafficResetTime = 0;
+        this.speedLimitUp = 0;
+        this.speedLimitDown = 0;
+        this.version = 0;
 
         this.listen = "";
         this.port = 0;
@@ -23,6 +26,8 @@ class DBInbound {
         this.clientStats = "";
         this.serverId = 1;
         this.serverAddress = ""
+        this.addressOverride = "";
+        this.pendingSync = false;
         if (data == null) {
             return;
         }
@@ -1800,7 +1800,9 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0
     ) {
         super();
         this.id = id;
@@ -1816,6 +1818,8 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
     }
 
     static fromJson(json = {}) {
@@ -1833,6 +1837,8 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
         );
     }
     get _expiryTime() {
@@ -1940,7 +1946,9 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0
     ) {
         super();
         this.id = id;
@@ -1956,6 +1964,8 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
     }
 
     static fromJson(json = {}) {
@@ -1973,6 +1983,8 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
         );
     }
 
@@ -2085,7 +2097,9 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0
     ) {
         super();
         this.password = password;
@@ -2100,6 +2114,8 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
     }
 
     toJson() {
@@ -2116,6 +2132,8 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            speedLimitUp: this.speedLimitUp,
+            speedLimitDown: this.speedLimitDown,
         };
     }
 
@@ -2133,6 +2151,8 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
         );
     }
 
@@ -2254,7 +2274,9 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0
     ) {
         super();
         this.method = method;
@@ -2270,6 +2292,8 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
     }
 
     toJson() {
@@ -2287,6 +2311,8 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            speedLimitUp: this.speedLimitUp,
+            speedLimitDown: this.speedLimitDown,
         };
     }
 
@@ -2305,6 +2331,8 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
         );
     }
 
@@ -2342,7 +2370,8 @@ Inbound.TunnelSettings = class extends Inbound.Settings {
         port,
         portMap = [],
         network = 'tcp,udp',
-        followRedirect = false
+        followRedirect = false,
+        target = {},
     ) {
         super(protocol);
         this.address = address;
@@ -2350,6 +2379,13 @@ Inbound.TunnelSettings = class extends Inbound.Settings {
         this.portMap = portMap;
         this.network = network;
         this.followRedirect = followRedirect;
+        // Relay target on a managed server; the panel resolves address and port from it
+        this.targetServerId = target.serverId;
+        this.targetInboundId = target.inboundId;
+    }
+
+    get hasTarget() {
+        return this.targetServerId > 0 && this.targetInboundId > 0;
     }
 
     static fromJson(json = {}) {
@@ -2360,6 +2396,7 @@ Inbound.TunnelSettings = class extends Inbound.Settings {
             XrayCommonClass.toHeaders(json.portMap),
             json.network,
             json.followRedirect,
+            json.target,
         );
     }
 
@@ -2370,6 +2407,7 @@ Inbound.TunnelSettings = class extends Inbound.Settings {
             portMap: XrayCommonClass.toV2Headers(this.portMap, false),
             network: this.network,
             followRedirect: this.followRedirect,
+            target: this.hasTarget ? { serverId: this.targetServerId, inboundId: this.targetInboundId } : undefined,
         };
     }
 };
@@ -2520,8 +2558,9 @@ Inbound.WireguardSettings = class extends XrayCommonClass {
 };
 
 Inbound.WireguardSettings.Peer = class extends XrayCommonClass {
-    constructor(privateKey, publicKey, psk = '', allowedIPs = ['10.0.0.2/32'], keepAlive = 0) {
+    constructor(privateKey, publicKey, psk = '', allowedIPs = ['10.0.0.2/32'], keepAlive = 0, email = '') {
         super();
+        this.email = email;
         this.privateKey = privateKey
         this.publicKey = publicKey;
         if (!this.publicKey) {
@@ -2541,7 +2580,8 @@ Inbound.WireguardSettings.Peer = class extends XrayCommonClass {
             json.publicKey,
             json.preSharedKey,
             json.allowedIPs,
-            json.keepAlive
+            json.keepAlive,
+            json.email,
         );
     }
 
@@ -2550,6 +2590,7 @@ Inbound.WireguardSettings.Peer = class extends XrayCommonClass {
             if (a.length > 0 && !a.includes('/')) this.allowedIPs[index] += '/32';
         });
         return {
+            email: this.email ? this.email : undefined,
             privateKey: this.privateKey,
             publicKey: this.publicKey,
             preSharedKey: this.psk.length > 0 ? this.psk : undefined,
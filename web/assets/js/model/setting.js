@@ -8,6 +8,7 @@ class AllSetting {
         this.webKeyFile = "";
         this.webBasePath = "/";
         this.sessionMaxAge = 360;
+        this.trustedProxies = "127.0.0.1,::1";
         this.pageSize = 25;
         this.expireDiff = 0;
         this.trafficDiff = 0;
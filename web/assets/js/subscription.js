@@ -91,6 +91,7 @@
       themeSwitcher,
       app: data,
       links: rawLinks,
+      servers: [],
       lang: '',
       viewportWidth: (typeof window !== 'undefined' ? window.innerWidth : 1024),
     },
@@ -108,6 +109,14 @@
       } catch (e) { /* ignore */ }
       this._onResize = () => { this.viewportWidth = window.innerWidth; };
       window.addEventListener('resize', this._onResize);
+      // Per-server usage is aggregated by the panel and may take a moment, so load it last
+      try {
+        const resp = await fetch(window.location.pathname.replace(/\/+$/, '') + '/usage');
+        if (resp.ok) {
+          const usage = await resp.json();
+          this.servers = usage.servers || [];
+        }
+      } catch (e) { /* the summary above still applies */ }
     },
     beforeDestroy() {
       if (this._onResize) window.removeEventListener('resize', this._onResize);
@@ -152,6 +161,12 @@
       copy,
       open,
       linkName,
+      sizeFormat(size) {
+        return SizeFormatter.sizeFormat(size);
+      },
+      formatDate(ms) {
+        return this.app.datepicker === 'gregorian' ? DateUtil.formatMillis(ms) : DateUtil.convertToJalalian(moment(ms));
+      },
       i18nLabel(key) {
         return '{{ i18n "' + key + '" }}';
       },
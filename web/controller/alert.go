@@ -0,0 +1,129 @@
+// Package controller provides HTTP handlers for alert rules and alerts.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// AlertController handles alert rules and the alerts they fired.
+type AlertController struct {
+	alerts *service.AlertService
+}
+
+// NewAlertController creates a new controller instance.
+func NewAlertController() *AlertController {
+	return &AlertController{
+		alerts: &service.AlertService{},
+	}
+}
+
+// ListAlerts returns fired alerts, newest first.
+// GET /panel/api/alerts
+// Query params: active (true = only firing), rule_id, server_id, limit (default 100, max 1000)
+func (c *AlertController) ListAlerts(ctx *gin.Context) {
+	ruleId, err := strconv.Atoi(ctx.DefaultQuery("rule_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidAlertRuleId"), err)
+		return
+	}
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+
+	alerts, err := c.alerts.GetAlerts(&service.AlertQuery{
+		ActiveOnly: ctx.Query("active") == "true",
+		RuleId:     ruleId,
+		ServerId:   serverId,
+		Limit:      limit,
+	})
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getAlertsFailed"), err)
+		return
+	}
+	jsonObj(ctx, alerts, nil)
+}
+
+// ListRules returns all alert rules.
+// GET /panel/api/alerts/rules
+func (c *AlertController) ListRules(ctx *gin.Context) {
+	rules, err := c.alerts.GetRules()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getAlertRulesFailed"), err)
+		return
+	}
+	jsonObj(ctx, rules, nil)
+}
+
+// AddRule creates an alert rule.
+// POST /panel/api/alerts/rules
+// Body: {"name": "CPU high", "metric": "cpu", "threshold": 90, "duration": 5,
+// "severity": "warning", "serverId": 0, "channels": "telegram,email", "enable": true}
+func (c *AlertController) AddRule(ctx *gin.Context) {
+	rule := &model.AlertRule{}
+	if err := ctx.ShouldBind(rule); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidAlertRule"), err)
+		return
+	}
+	if err := c.alerts.AddRule(rule); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addAlertRuleFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.alertRuleAdded"), rule, nil)
+}
+
+// UpdateRule replaces an alert rule and resolves the alerts it fired.
+// PUT /panel/api/alerts/rules/:id
+func (c *AlertController) UpdateRule(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidAlertRuleId"), err)
+		return
+	}
+
+	rule := &model.AlertRule{}
+	if err := ctx.ShouldBind(rule); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidAlertRule"), err)
+		return
+	}
+	rule.Id = id
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.alertRuleUpdated"), c.alerts.UpdateRule(rule))
+}
+
+// DeleteRule removes an alert rule and resolves the alerts it fired.
+// DELETE /panel/api/alerts/rules/:id
+func (c *AlertController) DeleteRule(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidAlertRuleId"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.alertRuleDeleted"), c.alerts.DeleteRule(id))
+}
+
+// TestChannel sends a test notification through one channel.
+// POST /panel/api/alerts/test
+// Body: {"channel": "telegram" | "webhook" | "email"}
+func (c *AlertController) TestChannel(ctx *gin.Context) {
+	var req struct {
+		Channel string `json:"channel" form:"channel"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.alertTestFailed"), err)
+		return
+	}
+	err := c.alerts.TestChannel(ctx.Request.Context(), req.Channel)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.alertTestFailed"), err)
+		return
+	}
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.alertTestSent"), nil)
+}
@@ -53,12 +53,186 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	serverMgmt := NewServerManagementController()
 	servers.GET("", serverMgmt.ListServers)
 	servers.GET("/stats", serverMgmt.GetServerStats)
+	servers.GET("/overview", serverMgmt.GetOverview)
+	servers.GET("/traffic/alltime", serverMgmt.GetAllTimeTraffic)
+	servers.GET("/billing/export", serverMgmt.ExportBilling)
+	servers.GET("/metrics/export", serverMgmt.ExportMetrics)
+	servers.GET("/anomalies", serverMgmt.GetAnomalies)
+	servers.GET("/connections", serverMgmt.GetInboundConnections)
+	servers.GET("/outbounds/traffic", serverMgmt.GetOutboundTraffic)
+	servers.GET("/xray/versions", serverMgmt.GetXrayVersions)
+	servers.GET("/geofiles", serverMgmt.GetGeoFileStatuses)
+	servers.GET("/xray/upgrade", serverMgmt.GetXrayUpgradeStatus)
+	servers.GET("/ca", serverMgmt.GetCACert)
+	servers.GET("/ca/crl", serverMgmt.GetCRL)
+	servers.POST("/xray/upgrade", serverMgmt.UpgradeXray)
+	servers.GET("/agent/update", serverMgmt.GetAgentUpdateStatus)
+	servers.POST("/agent/update", serverMgmt.UpdateAgents)
+	servers.GET("/tasks", serverMgmt.ListTasks)
+	servers.GET("/incidents", serverMgmt.ListIncidents)
+	servers.GET("/incidents/:id", serverMgmt.GetIncident)
+	servers.GET("/health-runs", serverMgmt.ListHealthRuns)
+	servers.GET("/settings", serverMgmt.GetSettingsStatus)
+	servers.POST("/settings/push", serverMgmt.PushSettings)
+	servers.POST("/deploy-inbound", serverMgmt.DeployInbound)
 	servers.GET("/:id", serverMgmt.GetServer)
 	servers.POST("", serverMgmt.AddServer)
 	servers.PUT("/:id", serverMgmt.UpdateServer)
 	servers.DELETE("/:id", serverMgmt.DeleteServer)
 	servers.GET("/:id/health", serverMgmt.GetServerHealth)
 	servers.GET("/:id/info", serverMgmt.GetServerInfo)
+	servers.GET("/:id/timeline", serverMgmt.GetServerTimeline)
+	servers.GET("/:id/sync-queue", serverMgmt.GetSyncQueue)
+	servers.GET("/:id/connector-stats", serverMgmt.GetConnectorStats)
+	servers.GET("/:id/geofiles", serverMgmt.GetServerGeoFiles)
+	servers.GET("/:id/capabilities", serverMgmt.GetServerCapabilities)
+	servers.GET("/:id/logs/stream", serverMgmt.StreamLogs)
+	servers.POST("/:id/jwt/rotate", serverMgmt.RotateJWTSecret)
+	servers.GET("/:id/certificates", serverMgmt.GetServerCertificates)
+	servers.POST("/:id/certificates/renew", serverMgmt.RenewServerCertificates)
+	servers.POST("/:id/agent/update", serverMgmt.UpdateAgent)
+	servers.POST("/:id/xray/users", serverMgmt.XrayAddUser)
+	servers.DELETE("/:id/xray/inbounds/:tag/users/:email", serverMgmt.XrayRemoveUser)
+	servers.GET("/:id/xray/stats", serverMgmt.XrayQueryStats)
+
+	// API keys scoped to one server, used with the node API
+	apiKeys := NewServerAPIKeyController()
+	servers.GET("/:id/api-keys", apiKeys.ListKeys)
+	servers.POST("/:id/api-keys", apiKeys.AddKey)
+	servers.PUT("/:id/api-keys/:keyId/enable", apiKeys.SetKeyEnable)
+	servers.DELETE("/:id/api-keys/:keyId", apiKeys.DeleteKey)
+
+	// WireGuard peers of an inbound on any server
+	wireguard := NewWireguardController()
+	servers.GET("/:id/wireguard/:inboundId/peers", wireguard.ListPeers)
+	servers.POST("/:id/wireguard/:inboundId/peers", wireguard.AddPeer)
+	servers.DELETE("/:id/wireguard/:inboundId/peers/:email", wireguard.DeletePeer)
+	servers.GET("/:id/wireguard/:inboundId/peers/:email/config", wireguard.GetPeerConfig)
+	servers.GET("/:id/wireguard/:inboundId/peers/:email/qr", wireguard.GetPeerQR)
+
+	// Server status changes, task updates and alerts as server-sent events
+	api.GET("/events", NewEventController().Stream)
+
+	// Search across servers, inbounds, clients and tasks
+	api.GET("/search", serverMgmt.Search)
+
+	// Clients across all servers
+	clients := api.Group("/clients")
+	fleetClients := NewFleetClientController()
+	clients.POST("", fleetClients.CreateClient)
+	clients.GET("/search", fleetClients.SearchClients)
+	clients.GET("/online/all", fleetClients.GetOnlineClients)
+	clients.GET("/stale", fleetClients.GetStaleClients)
+	clients.POST("/stale/cleanup", fleetClients.CleanupStaleClients)
+
+	// Inbounds archived on deletion, restorable on any server
+	archives := api.Group("/archives")
+	archiveMgmt := NewInboundArchiveController()
+	archives.GET("", archiveMgmt.ListArchives)
+	archives.GET("/:id", archiveMgmt.GetArchive)
+	archives.POST("/:id/restore", archiveMgmt.RestoreArchive)
+	archives.DELETE("/:id", archiveMgmt.DeleteArchive)
+
+	// Alert rules and the alerts they fired
+	alerts := api.Group("/alerts")
+	alertMgmt := NewAlertController()
+	alerts.GET("", alertMgmt.ListAlerts)
+	alerts.GET("/rules", alertMgmt.ListRules)
+	alerts.POST("/rules", alertMgmt.AddRule)
+	alerts.PUT("/rules/:id", alertMgmt.UpdateRule)
+	alerts.DELETE("/rules/:id", alertMgmt.DeleteRule)
+	alerts.POST("/test", alertMgmt.TestChannel)
+
+	// Outgoing webhooks that receive panel events
+	webhooks := api.Group("/webhooks")
+	webhookMgmt := NewWebhookController()
+	webhooks.GET("", webhookMgmt.ListWebhooks)
+	webhooks.POST("", webhookMgmt.AddWebhook)
+	webhooks.PUT("/:id", webhookMgmt.UpdateWebhook)
+	webhooks.DELETE("/:id", webhookMgmt.DeleteWebhook)
+	webhooks.POST("/:id/test", webhookMgmt.TestWebhook)
+
+	// Traffic reports from the daily traffic history
+	reports := api.Group("/reports")
+	reports.GET("/traffic", NewReportController().TrafficReport)
+
+	// Panel snapshots exported for disaster recovery
+	standby := NewStandbyController()
+	api.GET("/standby", standby.GetStatus)
+	api.POST("/standby/export", standby.Export)
+
+	// Server tasks; queued operations are run in the background with retries
+	api.GET("/tasks", serverMgmt.ListTasks)
+	api.POST("/tasks", serverMgmt.EnqueueTask)
+	api.GET("/tasks/:id", serverMgmt.GetTask)
+
+	// Fleet-wide IP blocklist
+	blocklist := api.Group("/blocklist")
+	blockedIPs := NewBlockedIPController()
+	blocklist.GET("", blockedIPs.ListBlockedIPs)
+	blocklist.POST("", blockedIPs.AddBlockedIP)
+	blocklist.DELETE("/:id", blockedIPs.DeleteBlockedIP)
+	blocklist.POST("/sync", blockedIPs.SyncBlockedIPs)
+
+	// Failed panel logins and lockouts
+	loginAttempts := api.Group("/login-attempts")
+	loginAttemptMgmt := NewLoginAttemptController()
+	loginAttempts.GET("", loginAttemptMgmt.ListFailedLogins)
+	loginAttempts.POST("/unlock", loginAttemptMgmt.Unlock)
+
+	// Reseller accounts
+	resellers := api.Group("/resellers")
+	resellerMgmt := NewResellerController()
+	resellers.GET("", resellerMgmt.ListResellers)
+	resellers.POST("", resellerMgmt.AddReseller)
+	resellers.PUT("/:id", resellerMgmt.UpdateReseller)
+	resellers.DELETE("/:id", resellerMgmt.DeleteReseller)
+	resellers.POST("/:id/token", resellerMgmt.RegenerateToken)
+	resellers.GET("/:id/usage", resellerMgmt.GetResellerUsage)
+	resellers.GET("/inbounds", resellerMgmt.ListInboundOwners)
+	resellers.POST("/inbounds/transfer", resellerMgmt.TransferInbound)
+
+	// Saved server scopes for aggregated views
+	scopes := api.Group("/scopes")
+	scopeMgmt := NewServerScopeController()
+	scopes.GET("", scopeMgmt.ListScopes)
+	scopes.POST("", scopeMgmt.AddScope)
+	scopes.PUT("/:id", scopeMgmt.UpdateScope)
+	scopes.DELETE("/:id", scopeMgmt.DeleteScope)
+	scopes.GET("/:id/servers", scopeMgmt.GetScopeServers)
+
+	// Server groups targeted by bulk operations
+	groups := api.Group("/groups")
+	groupMgmt := NewServerGroupController()
+	groups.GET("", groupMgmt.ListGroups)
+	groups.POST("", groupMgmt.AddGroup)
+	groups.PUT("/:id", groupMgmt.UpdateGroup)
+	groups.DELETE("/:id", groupMgmt.DeleteGroup)
+	groups.GET("/:id/servers", groupMgmt.GetGroupServers)
+	groups.POST("/:id/xray/restart", groupMgmt.RestartXray)
+	groups.POST("/:id/geofiles/update", groupMgmt.UpdateGeoFiles)
+
+	// Trial clients, removed automatically once they lapse
+	trials := api.Group("/trials")
+	trialMgmt := NewTrialController()
+	trials.GET("", trialMgmt.ListTrials)
+	trials.POST("", trialMgmt.CreateTrial)
+	trials.DELETE("/:id", trialMgmt.EndTrial)
+
+	// One-time tokens new agents register themselves with
+	agents := api.Group("/agents")
+	enrollment := NewEnrollmentController()
+	agents.GET("/tokens", enrollment.ListTokens)
+	agents.POST("/tokens", enrollment.AddToken)
+	agents.DELETE("/tokens/:id", enrollment.DeleteToken)
+
+	// Self-service renewal links
+	renewals := api.Group("/renewals")
+	renewalMgmt := NewRenewalController()
+	renewals.GET("", renewalMgmt.ListRenewals)
+	renewals.POST("", renewalMgmt.CreateRenewal)
+	renewals.DELETE("/:id", renewalMgmt.CancelRenewal)
+	renewals.POST("/:id/apply", renewalMgmt.ApplyRenewal)
 
 	// Extra routes
 	api.GET("/backuptotgbot", a.BackuptoTgbot)
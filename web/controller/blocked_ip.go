@@ -0,0 +1,100 @@
+// Package controller provides HTTP handlers for the fleet-wide IP blocklist.
+package controller
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// blocklistPushTimeout bounds how long pushing the blocklist to all servers may take.
+const blocklistPushTimeout = 30 * time.Second
+
+// BlockedIPController handles blocklist management.
+type BlockedIPController struct {
+	blockedIPs *service.BlockedIPService
+}
+
+// NewBlockedIPController creates a new controller instance.
+func NewBlockedIPController() *BlockedIPController {
+	return &BlockedIPController{
+		blockedIPs: &service.BlockedIPService{},
+	}
+}
+
+// ListBlockedIPs returns all blocklist entries.
+// GET /panel/api/blocklist
+func (c *BlockedIPController) ListBlockedIPs(ctx *gin.Context) {
+	entries, err := c.blockedIPs.GetBlockedIPs()
+	if err != nil {
+		jsonMsg(ctx, "Failed to get blocklist", err)
+		return
+	}
+	jsonObj(ctx, entries, nil)
+}
+
+// AddBlockedIP blocks an address or CIDR on every server.
+// POST /panel/api/blocklist
+// Body: {"ip": "203.0.113.7", "reason": "...", "ttl": 3600} (ttl in seconds, 0 = permanent)
+func (c *BlockedIPController) AddBlockedIP(ctx *gin.Context) {
+	var req struct {
+		IP     string `json:"ip" form:"ip"`
+		Reason string `json:"reason" form:"reason"`
+		TTL    int64  `json:"ttl" form:"ttl"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, "Invalid blocklist entry", err)
+		return
+	}
+	if req.TTL < 0 {
+		jsonMsg(ctx, "TTL must not be negative", nil)
+		return
+	}
+
+	entry, err := c.blockedIPs.AddBlockedIP(req.IP, req.Reason, "manual", time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		jsonMsg(ctx, "Failed to block IP", err)
+		return
+	}
+
+	jsonMsgObj(ctx, "IP blocked", entry, c.push())
+}
+
+// DeleteBlockedIP unblocks an entry on every server.
+// DELETE /panel/api/blocklist/:id
+func (c *BlockedIPController) DeleteBlockedIP(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid blocklist entry ID", err)
+		return
+	}
+
+	if err := c.blockedIPs.DeleteBlockedIP(id); err != nil {
+		jsonMsg(ctx, "Failed to unblock IP", err)
+		return
+	}
+
+	jsonMsg(ctx, "IP unblocked", c.push())
+}
+
+// SyncBlockedIPs pushes the current blocklist to every server.
+// POST /panel/api/blocklist/sync
+func (c *BlockedIPController) SyncBlockedIPs(ctx *gin.Context) {
+	jsonMsg(ctx, "Blocklist synced", c.push())
+}
+
+// push sends the blocklist to all servers, logging failures.
+func (c *BlockedIPController) push() error {
+	pushCtx, cancel := context.WithTimeout(context.Background(), blocklistPushTimeout)
+	defer cancel()
+
+	err := c.blockedIPs.PushBlockedIPs(pushCtx)
+	if err != nil {
+		logger.Warning("Failed to push blocklist to some servers:", err)
+	}
+	return err
+}
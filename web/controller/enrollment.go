@@ -0,0 +1,111 @@
+// Package controller provides HTTP handlers for agent enrollment tokens and agent registration.
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// EnrollmentController handles the management of enrollment tokens by panel admins.
+type EnrollmentController struct {
+	enrollment *service.EnrollmentService
+}
+
+// NewEnrollmentController creates a new controller instance.
+func NewEnrollmentController() *EnrollmentController {
+	return &EnrollmentController{
+		enrollment: &service.EnrollmentService{},
+	}
+}
+
+// ListTokens returns all enrollment tokens, without the tokens themselves.
+// GET /panel/api/agents/tokens
+func (c *EnrollmentController) ListTokens(ctx *gin.Context) {
+	tokens, err := c.enrollment.GetTokens()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getEnrollmentTokensFailed"), err)
+		return
+	}
+	jsonObj(ctx, tokens, nil)
+}
+
+// AddToken creates a one-time enrollment token and returns the token once.
+// POST /panel/api/agents/tokens
+// Body: {"authType": "mtls", "name": "", "region": "eu", "tags": "[\"edge\"]", "expiresAt": 0}
+// (expiresAt 0 = valid for 24 hours)
+func (c *EnrollmentController) AddToken(ctx *gin.Context) {
+	token := &model.EnrollmentToken{}
+	if err := ctx.ShouldBind(token); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidEnrollmentToken"), err)
+		return
+	}
+
+	plain, err := c.enrollment.CreateToken(token)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addEnrollmentTokenFailed"), err)
+		return
+	}
+	logger.Infof("Enrollment token %s created for %s agents", token.Prefix, token.AuthType)
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.enrollmentTokenAdded"), gin.H{"enrollmentToken": token, "token": plain}, nil)
+}
+
+// DeleteToken revokes an enrollment token.
+// DELETE /panel/api/agents/tokens/:id
+func (c *EnrollmentController) DeleteToken(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidEnrollmentToken"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.enrollmentTokenDeleted"), c.enrollment.DeleteToken(id))
+}
+
+// AgentRegistrationController serves the route new agents register themselves with.
+// It is outside the panel login and authenticated by the enrollment token in the body.
+type AgentRegistrationController struct {
+	enrollment *service.EnrollmentService
+}
+
+// NewAgentRegistrationController creates a new AgentRegistrationController and sets up its routes.
+func NewAgentRegistrationController(g *gin.RouterGroup) *AgentRegistrationController {
+	a := &AgentRegistrationController{
+		enrollment: &service.EnrollmentService{},
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the registration route.
+func (a *AgentRegistrationController) initRouter(g *gin.RouterGroup) {
+	g.POST("/panel/api/agents/register", a.register)
+}
+
+// register creates the server of an agent and returns its credentials.
+// Body: {"token": "xet_...", "name": "fra-1", "endpoint": "", "port": 2054, "tags": ["edge"]}
+func (a *AgentRegistrationController) register(c *gin.Context) {
+	req := &service.AgentRegistration{}
+	if err := c.ShouldBindJSON(req); err != nil || req.Token == "" {
+		pureJsonMsg(c, http.StatusBadRequest, false, "Invalid registration")
+		return
+	}
+
+	remoteIp := getRemoteIp(c)
+	enrollment, err := a.enrollment.Register(req, remoteIp)
+	if err != nil {
+		logger.Warningf("Agent registration from %s failed: %v", remoteIp, err)
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, service.ErrInvalidEnrollmentToken) {
+			status = http.StatusUnauthorized
+		}
+		pureJsonMsg(c, status, false, err.Error())
+		return
+	}
+	jsonObj(c, enrollment, nil)
+}
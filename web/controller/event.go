@@ -0,0 +1,39 @@
+// Package controller provides the HTTP handler of the panel event stream.
+package controller
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// EventController streams panel events to the UI and external consumers.
+type EventController struct{}
+
+// NewEventController creates a new controller instance.
+func NewEventController() *EventController {
+	return &EventController{}
+}
+
+// Stream sends server status changes, task updates and alerts as server-sent events
+// until the client disconnects.
+// GET /panel/api/events
+// Query params: types (comma-separated, e.g. "server,alert.update", default all),
+// lastEventId (replays missed events, the Last-Event-ID header takes precedence)
+func (c *EventController) Stream(ctx *gin.Context) {
+	types := make([]string, 0)
+	for _, t := range strings.Split(ctx.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	lastId := ctx.GetHeader("Last-Event-ID")
+	if lastId == "" {
+		lastId = ctx.Query("lastEventId")
+	}
+	id, _ := strconv.ParseInt(lastId, 10, 64)
+
+	service.ServeEventStream(ctx.Writer, ctx.Request, types, id)
+}
@@ -0,0 +1,165 @@
+// Package controller provides HTTP handlers for clients across all servers.
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// FleetClientController handles client operations spanning all servers.
+type FleetClientController struct {
+	search         *service.FleetSearchService
+	globalClients  *service.GlobalClientService
+	online         *service.FleetOnlineService
+	staleClients   *service.StaleClientService
+	settingService *service.SettingService
+}
+
+// NewFleetClientController creates a new controller instance.
+func NewFleetClientController() *FleetClientController {
+	return &FleetClientController{
+		search:         &service.FleetSearchService{},
+		globalClients:  &service.GlobalClientService{},
+		online:         &service.FleetOnlineService{},
+		staleClients:   &service.StaleClientService{},
+		settingService: &service.SettingService{},
+	}
+}
+
+// CreateClient creates the same client in inbounds of several servers and reports the
+// result of each of them.
+// POST /panel/api/clients
+// Body: {"email": "alice", "totalGB": 0, "expiryTime": 0, "targets": [{"serverId": 1,
+// "inboundTag": "inbound-443"}], "rollback": true} (id, password and subId are generated if empty)
+func (c *FleetClientController) CreateClient(ctx *gin.Context) {
+	var req service.GlobalClientRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidClientRequest"), err)
+		return
+	}
+
+	report, err := c.globalClients.Create(ctx.Request.Context(), &req)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.createClientFailed"), err)
+		return
+	}
+
+	total := "Total==" + strconv.Itoa(len(report.Results))
+	failed := "Failed==" + strconv.Itoa(report.Failed)
+	switch {
+	case report.Failed > 0 && req.Rollback:
+		jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.clientRolledBack", failed, total), report, nil)
+	case report.Failed > 0:
+		jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.clientCreatedPartly", failed, total), report, nil)
+	default:
+		jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.clientCreated"), report, nil)
+	}
+}
+
+// SearchClients finds which servers and inbounds clients live on.
+// GET /panel/api/clients/search
+// Query params: email (substring, case-insensitive), subId (exact), page, limit (default 20, max 100)
+func (c *FleetClientController) SearchClients(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	limit = min(max(limit, 1), 100)
+	page = max(page, 1)
+
+	matches, failed, err := c.search.SearchClients(ctx.Request.Context(), ctx.Query("email"), ctx.Query("subId"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.searchFailed"), err)
+		return
+	}
+
+	start := min((page-1)*limit, len(matches))
+	end := min(start+limit, len(matches))
+	jsonObj(ctx, gin.H{
+		"clients":       matches[start:end],
+		"total":         len(matches),
+		"page":          page,
+		"limit":         limit,
+		"failedServers": failed,
+	}, nil)
+}
+
+// GetOnlineClients returns the online clients of all enabled servers, each with its
+// server. The list is cached for a few seconds.
+// GET /panel/api/clients/online/all
+func (c *FleetClientController) GetOnlineClients(ctx *gin.Context) {
+	online, err := c.online.GetOnlineClients(ctx.Request.Context())
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getOnlineClientsFailed"), err)
+		return
+	}
+	jsonObj(ctx, online, nil)
+}
+
+// GetStaleClients lists the clients expired or depleted for more than days on all
+// enabled servers without changing them, as a dry run of the cleanup.
+// GET /panel/api/clients/stale
+// Query params: days (default staleClientDays setting)
+func (c *FleetClientController) GetStaleClients(ctx *gin.Context) {
+	days, err := c.staleDays(ctx.Query("days"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidStaleClientRequest"), err)
+		return
+	}
+
+	report, err := c.staleClients.Cleanup(ctx.Request.Context(), days, "report")
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.staleClientCleanupFailed"), err)
+		return
+	}
+	jsonObj(ctx, report, nil)
+}
+
+// CleanupStaleClients disables or deletes the clients expired or depleted for more
+// than days on all enabled servers now.
+// POST /panel/api/clients/stale/cleanup
+// Body: {"days": 30, "action": "disable"} (days default staleClientDays setting,
+// action "report", "disable" or "delete", default staleClientAction setting)
+func (c *FleetClientController) CleanupStaleClients(ctx *gin.Context) {
+	var req struct {
+		Days   int    `json:"days"`
+		Action string `json:"action"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidStaleClientRequest"), err)
+		return
+	}
+	days, err := c.staleDays(strconv.Itoa(req.Days))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidStaleClientRequest"), err)
+		return
+	}
+	if req.Action == "" {
+		if req.Action, err = c.settingService.GetStaleClientAction(); err != nil {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.staleClientCleanupFailed"), err)
+			return
+		}
+	}
+
+	report, err := c.staleClients.Cleanup(ctx.Request.Context(), days, req.Action)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.staleClientCleanupFailed"), err)
+		return
+	}
+	msg := I18nWeb(ctx, "pages.servers.toasts.staleClientsCleaned", "Count=="+strconv.Itoa(report.Cleaned))
+	jsonMsgObj(ctx, msg, report, nil)
+}
+
+// staleDays parses a days parameter, falling back to the staleClientDays setting if
+// it is empty or 0.
+func (c *FleetClientController) staleDays(value string) (int, error) {
+	if value == "" || value == "0" {
+		return c.settingService.GetStaleClientDays()
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("days must be a positive number: %s", value)
+	}
+	return days, nil
+}
@@ -2,13 +2,15 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
-	"net/url"
+	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/entity"
 	"github.com/cofedish/3x-UI-agents/web/service"
 	"github.com/cofedish/3x-UI-agents/web/session"
 
@@ -17,9 +19,15 @@ import (
 
 // InboundController handles HTTP requests related to Xray inbounds management.
 type InboundController struct {
-	inboundService service.InboundService
-	xrayService    service.XrayService
-	serverMgmt     *service.ServerManagementService
+	inboundService   service.InboundService
+	xrayService      service.XrayService
+	serverMgmt       *service.ServerManagementService
+	billingService   service.BillingService
+	tunnelService    service.TunnelService
+	shareLinkService service.ShareLinkService
+	syncQueue        service.SyncQueueService
+	resellers        service.ResellerService
+	warnings         service.InboundWarningService
 }
 
 // NewInboundController creates a new InboundController and sets up its routes.
@@ -38,6 +46,7 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.GET("/get/:id", a.getInbound)
 	g.GET("/getClientTraffics/:email", a.getClientTraffics)
 	g.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
+	g.GET("/clientLinks/:email", a.getClientLinks)
 
 	g.POST("/add", a.addInbound)
 	g.POST("/del/:id", a.delInbound)
@@ -53,17 +62,17 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.POST("/delDepletedClients/:id", a.delDepletedClients)
 	g.POST("/import", a.importInbound)
 	g.POST("/onlines", a.onlines)
+	g.POST("/onlineDetails", a.onlineDetails)
 	g.POST("/lastOnline", a.lastOnline)
 	g.POST("/updateClientTraffic/:email", a.updateClientTraffic)
 	g.POST("/:id/delClientByEmail/:email", a.delInboundClientByEmail)
 }
 
-// getServerIdFromRequest extracts server_id from query parameter, defaults to 1 for backward compatibility.
+// getServerIdFromRequest extracts server_id from query parameter, defaults to the local server for backward compatibility.
 func (a *InboundController) getServerIdFromRequest(c *gin.Context) int {
-	serverIdStr := c.DefaultQuery("server_id", "1")
-	serverId, err := strconv.Atoi(serverIdStr)
+	serverId, err := strconv.Atoi(c.Query("server_id"))
 	if err != nil || serverId < 0 {
-		return 1
+		return a.serverMgmt.GetLocalServerId()
 	}
 	return serverId
 }
@@ -73,56 +82,43 @@ func (a *InboundController) getServerIdFromRequest(c *gin.Context) int {
 func (a *InboundController) getInbounds(c *gin.Context) {
 	serverId := a.getServerIdFromRequest(c)
 
-	// Helper function to extract hostname from server endpoint
-	getServerHost := func(endpoint string) string {
-		// Extract hostname from endpoint like "https://139.28.223.53:2054"
-		if endpoint == "" || endpoint == "local://" {
-			return ""
+	// All Servers mode: aggregate inbounds from all servers, optionally only those
+	// matching ?tag=, ?region= or ?scope=
+	if serverId == 0 {
+		filter := serverFilterFromQuery(c)
+		servers, err := a.serverMgmt.GetServersByFilter(filter, false)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+			return
 		}
-		// Parse URL
-		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
-			// Remove port if present
-			if host, _, err := net.SplitHostPort(u.Host); err == nil {
-				return host
+		allInbounds := make([]*model.Inbound, 0)
+
+		// Get local inbounds
+		if filter.IsEmpty() || slices.ContainsFunc(servers, func(server *model.Server) bool { return server.IsLocal() }) {
+			user := session.GetLoginUser(c)
+			localInbounds, err := a.inboundService.GetInbounds(user.Id)
+			if err == nil {
+				a.setServerLabels(a.serverMgmt.GetLocalServerId(), localInbounds...)
+				allInbounds = append(allInbounds, localInbounds...)
 			}
-			return u.Host
 		}
-		return endpoint
-	}
 
-	// All Servers mode: aggregate inbounds from all servers
-	if serverId == 0 {
-		allInbounds := make([]*model.Inbound, 0)
+		// Get remote inbounds from the selected servers
+		for _, server := range servers {
+			if !server.Enabled || server.IsLocal() {
+				continue // Skip disabled and local server (already added)
+			}
 
-		// Get local inbounds - no server address needed (will use location.hostname on frontend)
-		user := session.GetLoginUser(c)
-		localInbounds, err := a.inboundService.GetInbounds(user.Id)
-		if err == nil {
-			allInbounds = append(allInbounds, localInbounds...)
-		}
+			connector, err := a.serverMgmt.GetConnector(server.Id)
+			if err != nil {
+				continue // Skip servers we can't connect to
+			}
 
-		// Get remote inbounds from all servers
-		servers, err := a.serverMgmt.GetAllServers()
-		if err == nil {
-			for _, server := range servers {
-				if !server.Enabled || server.Id == 1 {
-					continue // Skip disabled and local server (already added)
-				}
-
-				connector, err := a.serverMgmt.GetConnector(server.Id)
-				if err != nil {
-					continue // Skip servers we can't connect to
-				}
-
-				remoteInbounds, err := connector.ListInbounds(c.Request.Context())
-				if err == nil {
-					// Set server address for each remote inbound
-					serverHost := getServerHost(server.Endpoint)
-					for _, inbound := range remoteInbounds {
-						inbound.ServerAddress = serverHost
-					}
-					allInbounds = append(allInbounds, remoteInbounds...)
-				}
+			remoteInbounds, err := connector.ListInbounds(c.Request.Context())
+			if err == nil {
+				remoteInbounds = a.syncQueue.MarkPending(server.Id, remoteInbounds)
+				a.setServerLabels(server.Id, remoteInbounds...)
+				allInbounds = append(allInbounds, remoteInbounds...)
 			}
 		}
 
@@ -130,14 +126,15 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 		return
 	}
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		user := session.GetLoginUser(c)
 		inbounds, err := a.inboundService.GetInbounds(user.Id)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
 			return
 		}
+		a.setServerLabels(serverId, inbounds...)
 		jsonObj(c, inbounds, nil)
 		return
 	}
@@ -145,7 +142,7 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -155,18 +152,84 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 		return
 	}
 
-	// Set server address for remote server inbounds
-	if serverId > 1 {
-		server, err := a.serverMgmt.GetServer(serverId)
+	inbounds = a.syncQueue.MarkPending(serverId, inbounds)
+	a.setServerLabels(serverId, inbounds...)
+	jsonObj(c, inbounds, nil)
+}
+
+// setServerLabels attaches the resolved address and the owning server's name and tags to
+// inbounds. The address makes links and QR codes generated from them use the inbound's
+// address override, else the server's endpoint or subscription domain; without a known
+// address the frontend falls back to the address the panel is opened on. Soft limit and
+// server warnings are attached as well.
+func (a *InboundController) setServerLabels(serverId int, inbounds ...*model.Inbound) {
+	a.serverMgmt.ResolveServerAddresses(serverId, inbounds...)
+	server, err := a.serverMgmt.GetServer(serverId)
+	for _, inbound := range inbounds {
 		if err == nil {
-			serverHost := getServerHost(server.Endpoint)
-			for _, inbound := range inbounds {
-				inbound.ServerAddress = serverHost
-			}
+			inbound.ServerName = server.Name
+			inbound.ServerTags = service.ServerTags(server)
 		}
 	}
+	a.warnings.Annotate(server, inbounds)
+	if err := a.resellers.MarkInboundOwners(serverId, inbounds); err != nil {
+		logger.Warning("Failed to get inbound owners:", err)
+	}
+}
 
-	jsonObj(c, inbounds, nil)
+// queueMutation queues a mutation of a remote server that is unreachable or still has
+// earlier mutations queued, so it is applied once the server is back.
+func (a *InboundController) queueMutation(c *gin.Context, serverId int, operation string, inboundId int, clientId string, inbound *model.Inbound) {
+	task, err := a.syncQueue.Enqueue(serverId, operation, inboundId, clientId, inbound, session.GetLoginUser(c).Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.queuedForSync"), task, nil)
+}
+
+// respondConflict answers an update based on an outdated inbound version with HTTP 409
+// and the current inbound, and reports whether err was such a conflict.
+func (a *InboundController) respondConflict(c *gin.Context, serverId int, err error) bool {
+	var conflictErr *service.InboundConflictError
+	if !errors.As(err, &conflictErr) {
+		return false
+	}
+	conflictErr.Current.ServerId = serverId
+	a.setServerLabels(serverId, conflictErr.Current)
+	c.JSON(http.StatusConflict, entity.Msg{
+		Success: false,
+		Msg:     I18nWeb(c, "pages.inbounds.toasts.inboundConflict"),
+		Obj:     conflictErr.Current,
+	})
+	return true
+}
+
+// respondInvalid sends the field errors of an inbound or client rejected by an agent.
+// It returns false if err is not a validation error.
+func (a *InboundController) respondInvalid(c *gin.Context, err error) bool {
+	if service.ValidationFields(err) == nil {
+		return false
+	}
+	jsonInvalid(c, I18nWeb(c, "somethingWentWrong"), err)
+	return true
+}
+
+// validateClientPayload checks a client payload before it is applied or queued and
+// responds with its field errors. The stored inbound supplies the protocol on the local
+// server; agents check the protocol specific fields themselves.
+func (a *InboundController) validateClientPayload(c *gin.Context, serverId int, data *model.Inbound) bool {
+	var protocol model.Protocol
+	if a.serverMgmt.IsLocalServer(serverId) {
+		if stored, err := a.inboundService.GetInbound(data.Id); err == nil {
+			protocol = stored.Protocol
+		}
+	}
+	if err := service.ValidateClientPayload(data, protocol); err != nil {
+		jsonInvalid(c, I18nWeb(c, "somethingWentWrong"), err)
+		return false
+	}
+	return true
 }
 
 // getInbound retrieves a specific inbound by its ID.
@@ -180,13 +243,14 @@ func (a *InboundController) getInbound(c *gin.Context) {
 
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		inbound, err := a.inboundService.GetInbound(id)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
 			return
 		}
+		a.setServerLabels(serverId, inbound)
 		jsonObj(c, inbound, nil)
 		return
 	}
@@ -194,7 +258,7 @@ func (a *InboundController) getInbound(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -204,18 +268,27 @@ func (a *InboundController) getInbound(c *gin.Context) {
 		return
 	}
 	// Attach server address so generated links use the remote host
-	if server, err := a.serverMgmt.GetServer(serverId); err == nil {
-		if u, err := url.Parse(server.Endpoint); err == nil && u.Host != "" {
-			if host, _, err := net.SplitHostPort(u.Host); err == nil {
-				inbound.ServerAddress = host
-			} else {
-				inbound.ServerAddress = u.Host
-			}
-		}
-	}
+	a.setServerLabels(serverId, inbound)
 	jsonObj(c, inbound, nil)
 }
 
+// getClientLinks returns the inbounds a client exists on, with the owning server's public
+// host attached, for rendering share links and QR codes per server.
+// Query params: server_id (optional, 0 or omitted = all enabled servers)
+func (a *InboundController) getClientLinks(c *gin.Context) {
+	serverId, err := strconv.Atoi(c.DefaultQuery("server_id", "0"))
+	if err != nil || serverId < 0 {
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	shares, err := a.shareLinkService.GetClientShares(c.Request.Context(), c.Param("email"), serverId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, shares, nil)
+}
+
 // getClientTraffics retrieves client traffic information by email.
 func (a *InboundController) getClientTraffics(c *gin.Context) {
 	email := c.Param("email")
@@ -244,7 +317,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	inbound := &model.Inbound{}
 	err := c.ShouldBind(inbound)
 	if err != nil {
-		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), err)
+		jsonInvalid(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), err)
 		return
 	}
 
@@ -260,8 +333,21 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		inbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
 	}
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	if err := a.tunnelService.ResolveTarget(c.Request.Context(), inbound); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if err := a.serverMgmt.CheckInboundCapabilities(c.Request.Context(), serverId, inbound); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if err := service.ValidateInbound(inbound); err != nil {
+		jsonInvalid(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		inbound, needRestart, err := a.inboundService.AddInbound(inbound)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
@@ -277,11 +363,22 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
+	if a.syncQueue.HasPending(serverId) {
+		a.queueMutation(c, serverId, service.SyncAddInbound, 0, "", inbound)
+		return
+	}
 	err = connector.AddInbound(c.Request.Context(), inbound)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncAddInbound, 0, "", inbound)
+		return
+	}
+	if a.respondInvalid(c, err) {
+		return
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -294,15 +391,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	}
 
 	// Ensure remote host is attached for response so generated links use agent host
-	if server, err := a.serverMgmt.GetServer(serverId); err == nil {
-		if u, err := url.Parse(server.Endpoint); err == nil && u.Host != "" {
-			if host, _, err := net.SplitHostPort(u.Host); err == nil {
-				inbound.ServerAddress = host
-			} else {
-				inbound.ServerAddress = u.Host
-			}
-		}
-	}
+	a.setServerLabels(serverId, inbound)
 
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound, nil)
 }
@@ -318,13 +407,14 @@ func (a *InboundController) delInbound(c *gin.Context) {
 
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		needRestart, err := a.inboundService.DelInbound(id)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 			return
 		}
+		a.releaseInbound(serverId, id)
 		jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), id, nil)
 		if needRestart {
 			a.xrayService.SetToNeedRestart()
@@ -335,11 +425,19 @@ func (a *InboundController) delInbound(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
+	if a.syncQueue.HasPending(serverId) {
+		a.queueMutation(c, serverId, service.SyncDeleteInbound, id, "", nil)
+		return
+	}
 	err = connector.DeleteInbound(c.Request.Context(), id)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncDeleteInbound, id, "", nil)
+		return
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -351,9 +449,17 @@ func (a *InboundController) delInbound(c *gin.Context) {
 		// Don't fail the request - inbound was deleted successfully
 	}
 
+	a.releaseInbound(serverId, id)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), id, nil)
 }
 
+// releaseInbound drops the reseller ownership of a deleted inbound.
+func (a *InboundController) releaseInbound(serverId, inboundId int) {
+	if err := a.resellers.ReleaseInbound(serverId, inboundId); err != nil {
+		logger.Warning("Failed to release inbound ownership:", err)
+	}
+}
+
 // updateInbound updates an existing inbound configuration.
 // Supports optional server_id query parameter for multi-server mode.
 func (a *InboundController) updateInbound(c *gin.Context) {
@@ -367,16 +473,32 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 	}
 	err = c.ShouldBind(inbound)
 	if err != nil {
-		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		jsonInvalid(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
 
 	serverId := a.getServerIdFromRequest(c)
 	inbound.ServerId = serverId
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	if err := a.tunnelService.ResolveTarget(c.Request.Context(), inbound); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if err := a.serverMgmt.CheckInboundCapabilities(c.Request.Context(), serverId, inbound); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if err := service.ValidateInbound(inbound); err != nil {
+		jsonInvalid(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		inbound, needRestart, err := a.inboundService.UpdateInbound(inbound)
+		if a.respondConflict(c, serverId, err) {
+			return
+		}
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 			return
@@ -391,11 +513,25 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
+	if a.syncQueue.HasPending(serverId) {
+		a.queueMutation(c, serverId, service.SyncUpdateInbound, inbound.Id, "", inbound)
+		return
+	}
 	err = connector.UpdateInbound(c.Request.Context(), inbound)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncUpdateInbound, inbound.Id, "", inbound)
+		return
+	}
+	if a.respondConflict(c, serverId, err) {
+		return
+	}
+	if a.respondInvalid(c, err) {
+		return
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -441,14 +577,17 @@ func (a *InboundController) addInboundClient(c *gin.Context) {
 	data := &model.Inbound{}
 	err := c.ShouldBind(data)
 	if err != nil {
-		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		jsonInvalid(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
 
 	serverId := a.getServerIdFromRequest(c)
+	if !a.validateClientPayload(c, serverId, data) {
+		return
+	}
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		needRestart, err := a.inboundService.AddInboundClient(data)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
@@ -464,12 +603,23 @@ func (a *InboundController) addInboundClient(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
+	if a.syncQueue.HasPending(serverId) {
+		a.queueMutation(c, serverId, service.SyncAddClient, data.Id, "", data)
+		return
+	}
 	// AddClient expects the inbound object with client data
 	err = connector.AddClient(c.Request.Context(), data)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncAddClient, data.Id, "", data)
+		return
+	}
+	if a.respondInvalid(c, err) {
+		return
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -489,8 +639,8 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		needRestart, err := a.inboundService.DelInboundClient(id, clientId)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
@@ -506,11 +656,19 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
+	if a.syncQueue.HasPending(serverId) {
+		a.queueMutation(c, serverId, service.SyncDeleteClient, id, clientId, nil)
+		return
+	}
 	err = connector.DeleteClient(c.Request.Context(), id, clientId)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncDeleteClient, id, clientId, nil)
+		return
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -526,14 +684,17 @@ func (a *InboundController) updateInboundClient(c *gin.Context) {
 	inbound := &model.Inbound{}
 	err := c.ShouldBind(inbound)
 	if err != nil {
-		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		jsonInvalid(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
 
 	serverId := a.getServerIdFromRequest(c)
+	if !a.validateClientPayload(c, serverId, inbound) {
+		return
+	}
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		needRestart, err := a.inboundService.UpdateInboundClient(inbound, clientId)
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
@@ -549,20 +710,49 @@ func (a *InboundController) updateInboundClient(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
-	// Extract client from inbound settings
-	if len(inbound.ClientStats) == 0 {
-		jsonMsg(c, "No client data provided", nil)
+	if a.syncQueue.HasPending(serverId) {
+		a.queueMutation(c, serverId, service.SyncUpdateClient, inbound.Id, clientId, inbound)
+		return
+	}
+	// The connector addresses clients by index, so locate clientId in the server's inbound
+	existing, err := connector.GetInbound(c.Request.Context(), inbound.Id)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncUpdateClient, inbound.Id, clientId, inbound)
+		return
+	}
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	clients, err := a.inboundService.GetClients(existing)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	clientIndex := -1
+	for i, client := range clients {
+		if a.inboundService.GetClientId(existing.Protocol, client) == clientId {
+			clientIndex = i
+			break
+		}
+	}
+	if clientIndex < 0 {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), fmt.Errorf("client %s not found", clientId))
 		return
 	}
 
-	// UpdateClient expects inbound with the updated client data and client index
-	// For now, we assume the client to update is at index 0 of ClientStats
-	// TODO: Find actual index based on clientId
-	err = connector.UpdateClient(c.Request.Context(), inbound, 0)
+	err = connector.UpdateClient(c.Request.Context(), inbound, clientIndex)
+	if errors.Is(err, service.ErrAgentUnreachable) {
+		a.queueMutation(c, serverId, service.SyncUpdateClient, inbound.Id, clientId, inbound)
+		return
+	}
+	if a.respondInvalid(c, err) {
+		return
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -579,6 +769,13 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 	}
 	email := c.Param("email")
 
+	// Keep the pre-reset counters for billing exports
+	if traffic, err := a.inboundService.GetClientTrafficByEmail(email); err == nil && traffic != nil {
+		if err := a.billingService.RecordClientResetEvent(a.serverMgmt.GetLocalServerId(), traffic, "manual"); err != nil {
+			logger.Warning("Failed to record traffic reset event:", err)
+		}
+	}
+
 	needRestart, err := a.inboundService.ResetClientTraffic(id, email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
@@ -610,6 +807,14 @@ func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 		return
 	}
 
+	if inbound, err := a.inboundService.GetInbound(id); err == nil {
+		if stats, err := a.billingService.GetLocalClientStats(id); err == nil {
+			if err := a.billingService.RecordResetEvents(inbound.ServerId, inbound, stats, "manual"); err != nil {
+				logger.Warning("Failed to record traffic reset event:", err)
+			}
+		}
+	}
+
 	err = a.inboundService.ResetAllClientTraffics(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
@@ -670,6 +875,50 @@ func (a *InboundController) onlines(c *gin.Context) {
 	jsonObj(c, a.inboundService.GetOnlineClients(), nil)
 }
 
+// onlineDetails retrieves online clients with source IPs, connection counts and GeoIP data.
+// Supports optional server_id query parameter; server_id=0 aggregates all enabled servers,
+// optionally only those matching ?tag=, ?region= or ?scope=. Clients carry the owning
+// server's name and tags.
+func (a *InboundController) onlineDetails(c *gin.Context) {
+	serverId := a.getServerIdFromRequest(c)
+
+	if serverId != 0 {
+		connector, err := a.serverMgmt.GetConnector(serverId)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
+			return
+		}
+		clients, err := connector.GetOnlineClientDetails(c.Request.Context())
+		jsonObj(c, clients, err)
+		return
+	}
+
+	allClients := make([]*service.OnlineClient, 0)
+	servers, err := a.serverMgmt.GetServersByFilter(serverFilterFromQuery(c), true)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.getServersFailed"), err)
+		return
+	}
+	for _, server := range servers {
+		connector, err := a.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			continue // Skip servers we can't connect to
+		}
+		clients, err := connector.GetOnlineClientDetails(c.Request.Context())
+		if err != nil {
+			logger.Warning("Failed to get online clients from server", server.Name, ":", err)
+			continue
+		}
+		tags := service.ServerTags(server)
+		for _, client := range clients {
+			client.ServerName, client.ServerTags = server.Name, tags
+		}
+		allClients = append(allClients, clients...)
+	}
+
+	jsonObj(c, allClients, nil)
+}
+
 // lastOnline retrieves the last online timestamps for clients.
 func (a *InboundController) lastOnline(c *gin.Context) {
 	data, err := a.inboundService.GetClientsLastOnline()
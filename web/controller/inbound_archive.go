@@ -0,0 +1,88 @@
+// Package controller provides HTTP handlers for archived inbounds.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// InboundArchiveController handles inbounds archived on deletion and their restores.
+type InboundArchiveController struct {
+	archives *service.InboundArchiveService
+}
+
+// NewInboundArchiveController creates a new controller instance.
+func NewInboundArchiveController() *InboundArchiveController {
+	return &InboundArchiveController{
+		archives: &service.InboundArchiveService{},
+	}
+}
+
+// ListArchives returns the archived inbounds, newest first, without their definitions.
+// GET /panel/api/archives
+// Query params: server_id (default 0 = all servers)
+func (c *InboundArchiveController) ListArchives(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	archives, err := c.archives.GetArchives(serverId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getArchivesFailed"), err)
+		return
+	}
+	jsonObj(ctx, archives, nil)
+}
+
+// GetArchive returns an archived inbound with its full definition.
+// GET /panel/api/archives/:id
+func (c *InboundArchiveController) GetArchive(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidArchiveId"), err)
+		return
+	}
+	archive, err := c.archives.GetArchive(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getArchivesFailed"), err)
+		return
+	}
+	jsonObj(ctx, archive, nil)
+}
+
+// RestoreArchive adds an archived inbound back with its clients.
+// POST /panel/api/archives/:id/restore
+// Query params: server_id (default 0 = the server it was deleted from)
+func (c *InboundArchiveController) RestoreArchive(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidArchiveId"), err)
+		return
+	}
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	inbound, err := c.archives.Restore(ctx.Request.Context(), id, serverId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.restoreArchiveFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.archiveRestored"), inbound, nil)
+}
+
+// DeleteArchive removes an archived inbound for good.
+// DELETE /panel/api/archives/:id
+func (c *InboundArchiveController) DeleteArchive(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidArchiveId"), err)
+		return
+	}
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.archiveDeleted"), c.archives.DeleteArchive(id))
+}
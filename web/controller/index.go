@@ -1,11 +1,14 @@
 package controller
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"text/template"
 	"time"
 
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/entity"
 	"github.com/cofedish/3x-UI-agents/web/service"
 	"github.com/cofedish/3x-UI-agents/web/session"
 
@@ -18,6 +21,7 @@ type LoginForm struct {
 	Username      string `json:"username" form:"username"`
 	Password      string `json:"password" form:"password"`
 	TwoFactorCode string `json:"twoFactorCode" form:"twoFactorCode"`
+	Captcha       string `json:"captcha" form:"captcha"` // Captcha response, checked once too many logins failed
 }
 
 // IndexController handles the main index and login-related routes.
@@ -27,6 +31,8 @@ type IndexController struct {
 	settingService service.SettingService
 	userService    service.UserService
 	tgbot          service.Tgbot
+	blockedIPs     service.BlockedIPService
+	loginAttempts  service.LoginAttemptService
 }
 
 // NewIndexController creates a new IndexController and initializes its routes.
@@ -71,20 +77,60 @@ func (a *IndexController) login(c *gin.Context) {
 		return
 	}
 
+	// Resolved once from the connection (or a trusted proxy), so the lockout, captcha and
+	// blocklist all count the address the client cannot choose
+	ip := getRemoteIp(c)
+	safeUser := template.HTMLEscapeString(form.Username)
+	status, err := a.loginAttempts.CheckLogin(ip, form.Username)
+	if err != nil {
+		logger.Warning("Unable to check login attempts:", err)
+		status = &service.LoginCheck{}
+	}
+	if status.Locked {
+		logger.Warningf("login for \"%s\" from IP \"%s\" rejected: locked out", safeUser, ip)
+		a.recordLoginAttempt(ip, form.Username, false, service.LoginLocked)
+		minutes := int(math.Ceil(time.Until(time.Unix(status.LockedUntil, 0)).Minutes()))
+		c.JSON(http.StatusOK, entity.Msg{
+			Success: false,
+			Msg:     I18nWeb(c, "pages.login.toasts.tooManyAttempts", "Minutes=="+strconv.Itoa(max(minutes, 1))),
+			Obj:     status,
+		})
+		return
+	}
+	if status.CaptchaRequired {
+		ok, err := a.loginAttempts.VerifyCaptcha(c.Request.Context(), form.Captcha, ip)
+		if err != nil {
+			logger.Warning("Unable to verify captcha:", err)
+		}
+		if !ok {
+			a.recordLoginAttempt(ip, form.Username, false, service.LoginCaptchaFailed)
+			c.JSON(http.StatusOK, entity.Msg{
+				Success: false,
+				Msg:     I18nWeb(c, "pages.login.toasts.captchaRequired"),
+				Obj:     status,
+			})
+			return
+		}
+	}
+
 	user := a.userService.CheckUser(form.Username, form.Password, form.TwoFactorCode)
 	timeStr := time.Now().Format("2006-01-02 15:04:05")
-	safeUser := template.HTMLEscapeString(form.Username)
 	safePass := template.HTMLEscapeString(form.Password)
 
 	if user == nil {
-		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, getRemoteIp(c))
-		a.tgbot.UserLoginNotify(safeUser, safePass, getRemoteIp(c), timeStr, 0)
+		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, ip)
+		a.recordLoginAttempt(ip, form.Username, false, service.LoginInvalidCredentials)
+		a.tgbot.UserLoginNotify(safeUser, safePass, ip, timeStr, 0)
+		if a.blockedIPs.RecordAuthFailure(ip) {
+			a.blockedIPs.SchedulePush()
+		}
 		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
 		return
 	}
 
-	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, getRemoteIp(c))
-	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
+	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, ip)
+	a.recordLoginAttempt(ip, form.Username, true, "")
+	a.tgbot.UserLoginNotify(safeUser, ``, ip, timeStr, 1)
 
 	sessionMaxAge, err := a.settingService.GetSessionMaxAge()
 	if err != nil {
@@ -102,6 +148,13 @@ func (a *IndexController) login(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.login.toasts.successLogin"), nil)
 }
 
+// recordLoginAttempt stores a login attempt for brute-force tracking.
+func (a *IndexController) recordLoginAttempt(ip, username string, success bool, reason string) {
+	if err := a.loginAttempts.RecordAttempt(ip, username, success, reason); err != nil {
+		logger.Warning("Unable to record login attempt:", err)
+	}
+}
+
 // logout handles user logout by clearing the session and redirecting to the login page.
 func (a *IndexController) logout(c *gin.Context) {
 	user := session.GetLoginUser(c)
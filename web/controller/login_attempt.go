@@ -0,0 +1,48 @@
+// Package controller provides HTTP handlers for panel login attempt tracking.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// LoginAttemptController lets admins review failed logins and lift lockouts.
+type LoginAttemptController struct {
+	loginAttempts *service.LoginAttemptService
+}
+
+// NewLoginAttemptController creates a new controller instance.
+func NewLoginAttemptController() *LoginAttemptController {
+	return &LoginAttemptController{
+		loginAttempts: &service.LoginAttemptService{},
+	}
+}
+
+// ListFailedLogins returns the latest failed login attempts, newest first.
+// GET /panel/api/login-attempts?limit=100
+func (c *LoginAttemptController) ListFailedLogins(ctx *gin.Context) {
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	attempts, err := c.loginAttempts.GetRecentFailures(limit)
+	if err != nil {
+		jsonMsg(ctx, "Failed to get login attempts", err)
+		return
+	}
+	jsonObj(ctx, attempts, nil)
+}
+
+// Unlock lifts the lockout of an IP and/or username.
+// POST /panel/api/login-attempts/unlock
+// Body: {"ip": "203.0.113.7", "username": "admin"}
+func (c *LoginAttemptController) Unlock(ctx *gin.Context) {
+	var req struct {
+		IP       string `json:"ip" form:"ip"`
+		Username string `json:"username" form:"username"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, "Invalid unlock request", err)
+		return
+	}
+	jsonMsg(ctx, "Login unlocked", c.loginAttempts.Unlock(req.IP, req.Username))
+}
@@ -0,0 +1,56 @@
+// Package controller provides the Prometheus metrics endpoint of the panel.
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/cofedish/3x-UI-agents/web/session"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController serves panel metrics in the Prometheus text format. Scrapers
+// authenticate with "Authorization: Bearer <metricsToken>", browsers with a panel session.
+type MetricsController struct {
+	serverMgmt     *service.ServerManagementService
+	settingService *service.SettingService
+}
+
+// NewMetricsController creates a new MetricsController and sets up its routes.
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{
+		serverMgmt:     &service.ServerManagementService{},
+		settingService: &service.SettingService{},
+	}
+	g.GET("/panel/metrics", a.checkAuth, a.getMetrics)
+	return a
+}
+
+// checkAuth returns 404 unless the request has a panel session or the metrics token,
+// hiding the endpoint like the rest of the API.
+func (a *MetricsController) checkAuth(c *gin.Context) {
+	if session.IsLogin(c) {
+		c.Next()
+		return
+	}
+	token, _ := a.settingService.GetMetricsToken()
+	bearer := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Next()
+}
+
+// getMetrics writes the metrics of the requests sent to remote agents.
+// GET /panel/metrics
+func (a *MetricsController) getMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := a.serverMgmt.WriteConnectorMetrics(c.Writer); err != nil {
+		logger.Warning("Failed to write metrics:", err)
+	}
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readOnlyExempt lists the non-GET routes, relative to the base path, that stay available
+// in read-only mode: they only read data, or they are needed to sign in and to turn
+// read-only mode off again.
+var readOnlyExempt = map[string]bool{
+	"login":                               true,
+	"getTwoFactorEnable":                  true,
+	"panel/setting/all":                   true,
+	"panel/setting/defaultSettings":       true,
+	"panel/setting/readOnly":              true,
+	"panel/xray/":                         true,
+	"panel/api/inbounds/onlines":          true,
+	"panel/api/inbounds/onlineDetails":    true,
+	"panel/api/inbounds/lastOnline":       true,
+	"panel/api/inbounds/clientIps/:email": true,
+	"panel/api/server/logs/:count":        true,
+	"panel/api/server/xraylogs/:count":    true,
+	"panel/api/server/getNewEchCert":      true,
+}
+
+// ReadOnlyMiddleware returns a middleware that rejects requests changing state with
+// 403 Forbidden while the panel is in read-only mode (the readOnly setting or
+// XUI_READ_ONLY=true). GET requests and the routes in readOnlyExempt are let through,
+// so dashboards and listings keep working.
+func ReadOnlyMiddleware() gin.HandlerFunc {
+	settingService := service.SettingService{}
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		route := strings.TrimPrefix(c.FullPath(), c.GetString("base_path"))
+		if route == "" || readOnlyExempt[route] || !settingService.IsReadOnly() {
+			c.Next()
+			return
+		}
+		pureJsonMsg(c, http.StatusForbidden, false, I18nWeb(c, "readOnlyMode"))
+		c.Abort()
+	}
+}
@@ -0,0 +1,161 @@
+// Package controller provides HTTP handlers for self-service renewal links.
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// renewalWebhookMaxBody bounds the size of payment webhook payloads.
+const renewalWebhookMaxBody = 64 << 10
+
+// RenewalController handles renewal management by panel admins.
+type RenewalController struct {
+	renewals *service.RenewalService
+}
+
+// NewRenewalController creates a new controller instance.
+func NewRenewalController() *RenewalController {
+	return &RenewalController{
+		renewals: &service.RenewalService{},
+	}
+}
+
+// ListRenewals returns all renewal tokens.
+// GET /panel/api/renewals
+func (c *RenewalController) ListRenewals(ctx *gin.Context) {
+	renewals, err := c.renewals.GetRenewals()
+	if err != nil {
+		jsonMsg(ctx, "Failed to get renewals", err)
+		return
+	}
+	jsonObj(ctx, renewals, nil)
+}
+
+// CreateRenewal issues a signed renewal link for a subscription.
+// POST /panel/api/renewals
+// Body: {"subId": "...", "days": 30, "resetTraffic": true, "ttl": 86400, "paymentUrl": "https://..."}
+// (ttl is the link lifetime in seconds, default 7 days)
+func (c *RenewalController) CreateRenewal(ctx *gin.Context) {
+	var req struct {
+		SubId        string `json:"subId" form:"subId"`
+		Days         int    `json:"days" form:"days"`
+		ResetTraffic bool   `json:"resetTraffic" form:"resetTraffic"`
+		TTL          int64  `json:"ttl" form:"ttl"`
+		PaymentUrl   string `json:"paymentUrl" form:"paymentUrl"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, "Invalid renewal data", err)
+		return
+	}
+	if req.TTL == 0 {
+		req.TTL = int64((7 * 24 * time.Hour).Seconds())
+	}
+
+	renewal, err := c.renewals.CreateRenewal(req.SubId, req.Days, req.ResetTraffic, time.Duration(req.TTL)*time.Second, req.PaymentUrl)
+	if err != nil {
+		jsonMsg(ctx, "Failed to create renewal", err)
+		return
+	}
+	link, err := c.renewals.GetRenewalLink(renewal)
+	if err != nil {
+		jsonMsg(ctx, "Failed to sign renewal link", err)
+		return
+	}
+
+	jsonMsgObj(ctx, "Renewal created", gin.H{"renewal": renewal, "link": link}, nil)
+}
+
+// CancelRenewal invalidates a pending renewal.
+// DELETE /panel/api/renewals/:id
+func (c *RenewalController) CancelRenewal(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid renewal ID", err)
+		return
+	}
+
+	jsonMsg(ctx, "Renewal cancelled", c.renewals.CancelRenewal(id))
+}
+
+// ApplyRenewal applies a pending renewal without waiting for the payment webhook.
+// POST /panel/api/renewals/:id/apply
+func (c *RenewalController) ApplyRenewal(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid renewal ID", err)
+		return
+	}
+
+	renewal, err := c.renewals.GetRenewal(id)
+	if err != nil {
+		jsonMsg(ctx, "Renewal not found", err)
+		return
+	}
+	if renewal.Status != "pending" {
+		jsonMsg(ctx, "Renewal is not pending", fmt.Errorf("renewal %d is %s", renewal.Id, renewal.Status))
+		return
+	}
+
+	err = c.renewals.Apply(ctx.Request.Context(), renewal)
+	jsonMsgObj(ctx, "Renewal applied", renewal, err)
+}
+
+// RenewalWebhookController receives payment confirmations for renewals.
+// It is outside the panel login and authenticated by an HMAC of the request body.
+type RenewalWebhookController struct {
+	renewals *service.RenewalService
+}
+
+// NewRenewalWebhookController creates a new RenewalWebhookController and sets up its routes.
+func NewRenewalWebhookController(g *gin.RouterGroup) *RenewalWebhookController {
+	a := &RenewalWebhookController{
+		renewals: &service.RenewalService{},
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the webhook route.
+func (a *RenewalWebhookController) initRouter(g *gin.RouterGroup) {
+	g.POST("/renewal/webhook", a.webhook)
+}
+
+// webhook applies a renewal after payment.
+// Body: {"token": "...", "paymentId": "..."}, header X-Signature: hex HMAC-SHA256 of the body
+func (a *RenewalWebhookController) webhook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, renewalWebhookMaxBody))
+	if err != nil {
+		pureJsonMsg(c, http.StatusBadRequest, false, "Invalid body")
+		return
+	}
+	if err := a.renewals.VerifyWebhookSignature(body, c.GetHeader("X-Signature")); err != nil {
+		logger.Warningf("Rejected renewal webhook from %s: %v", getRemoteIp(c), err)
+		pureJsonMsg(c, http.StatusUnauthorized, false, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Token     string `json:"token"`
+		PaymentId string `json:"paymentId"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Token == "" {
+		pureJsonMsg(c, http.StatusBadRequest, false, "Invalid payload")
+		return
+	}
+
+	renewal, err := a.renewals.ConfirmPayment(c.Request.Context(), req.Token, req.PaymentId)
+	if err != nil {
+		pureJsonMsg(c, http.StatusUnprocessableEntity, false, err.Error())
+		return
+	}
+	jsonObj(c, renewal, nil)
+}
@@ -0,0 +1,84 @@
+// Package controller provides HTTP handlers for traffic reports.
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ReportController handles reports built from the history kept by the panel.
+type ReportController struct {
+	trafficHistory *service.TrafficHistoryService
+}
+
+// NewReportController creates a new controller instance.
+func NewReportController() *ReportController {
+	return &ReportController{
+		trafficHistory: &service.TrafficHistoryService{},
+	}
+}
+
+// TrafficReport returns the traffic of clients or inbounds per day, week or month.
+// GET /panel/api/reports/traffic
+// Query params: from, to (Unix seconds or YYYY-MM-DD, default last 30 days), granularity
+// (day|week|month, default day), server_id, tag, region, scope, email, by (client|inbound,
+// default client), format (json|csv, default json). Days are taken in the panel timezone.
+func (c *ReportController) TrafficReport(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	now := time.Now()
+	from, err := parseBillingTime(ctx.Query("from"), now.AddDate(0, 0, -30), time.Local)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodStart"), err)
+		return
+	}
+	to, err := parseBillingTime(ctx.Query("to"), now, time.Local)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodEnd"), err)
+		return
+	}
+	query := &service.TrafficReportQuery{
+		From:        from,
+		To:          to,
+		Granularity: ctx.DefaultQuery("granularity", service.TrafficReportDay),
+		ServerId:    serverId,
+		Filter:      serverFilterFromQuery(ctx),
+		Email:       ctx.Query("email"),
+	}
+	switch ctx.DefaultQuery("by", "client") {
+	case "client":
+	case "inbound":
+		query.ByInbound = true
+	default:
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidReportGrouping"), nil)
+		return
+	}
+
+	report, err := c.trafficHistory.GetReport(query)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.trafficReportFailed"), err)
+		return
+	}
+
+	switch ctx.DefaultQuery("format", "json") {
+	case "json":
+		jsonObj(ctx, report, nil)
+	case "csv":
+		filename := fmt.Sprintf("traffic-%s-%s-%s.csv", query.Granularity, from.Format("20060102"), to.Format("20060102"))
+		ctx.Header("Content-Type", "text/csv")
+		ctx.Header("Content-Disposition", "attachment; filename="+filename)
+		if err := c.trafficHistory.WriteTrafficReportCSV(ctx.Writer, report); err != nil {
+			logger.Warning("Failed to write traffic report CSV:", err)
+		}
+	default:
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidFormat"), nil)
+	}
+}
@@ -0,0 +1,340 @@
+// Package controller provides HTTP handlers for reseller accounts and the reseller API.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// resellerContextKey is the gin context key holding the authenticated reseller.
+const resellerContextKey = "reseller"
+
+// ResellerController handles reseller account management by panel admins.
+type ResellerController struct {
+	resellers *service.ResellerService
+}
+
+// NewResellerController creates a new controller instance.
+func NewResellerController() *ResellerController {
+	return &ResellerController{
+		resellers: &service.ResellerService{},
+	}
+}
+
+// ListResellers returns all reseller accounts.
+// GET /panel/api/resellers
+func (c *ResellerController) ListResellers(ctx *gin.Context) {
+	resellers, err := c.resellers.GetResellers()
+	if err != nil {
+		jsonMsg(ctx, "Failed to get resellers", err)
+		return
+	}
+	jsonObj(ctx, resellers, nil)
+}
+
+// AddReseller creates a reseller and returns its API token once.
+// POST /panel/api/resellers
+// Body: {"username": "...", "serverIds": "[1,2]", "trafficPool": 1099511627776, "clientPool": 100}
+func (c *ResellerController) AddReseller(ctx *gin.Context) {
+	reseller := &model.Reseller{Enable: true}
+	if err := ctx.ShouldBind(reseller); err != nil {
+		jsonMsg(ctx, "Invalid reseller data", err)
+		return
+	}
+
+	token, err := c.resellers.AddReseller(reseller)
+	if err != nil {
+		jsonMsg(ctx, "Failed to add reseller", err)
+		return
+	}
+
+	logger.Infof("Reseller %s created", reseller.Username)
+	jsonMsgObj(ctx, "Reseller added", gin.H{"reseller": reseller, "token": token}, nil)
+}
+
+// UpdateReseller updates a reseller's permissions and pool limits.
+// PUT /panel/api/resellers/:id
+func (c *ResellerController) UpdateReseller(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid reseller ID", err)
+		return
+	}
+
+	reseller := &model.Reseller{}
+	if err := ctx.ShouldBind(reseller); err != nil {
+		jsonMsg(ctx, "Invalid reseller data", err)
+		return
+	}
+	reseller.Id = id
+
+	jsonMsg(ctx, "Reseller updated", c.resellers.UpdateReseller(reseller))
+}
+
+// DeleteReseller removes a reseller account.
+// DELETE /panel/api/resellers/:id
+func (c *ResellerController) DeleteReseller(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid reseller ID", err)
+		return
+	}
+
+	jsonMsg(ctx, "Reseller deleted", c.resellers.DeleteReseller(id))
+}
+
+// RegenerateToken issues a new API token, invalidating the previous one.
+// POST /panel/api/resellers/:id/token
+func (c *ResellerController) RegenerateToken(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid reseller ID", err)
+		return
+	}
+
+	token, err := c.resellers.RegenerateToken(id)
+	if err != nil {
+		jsonMsg(ctx, "Failed to regenerate token", err)
+		return
+	}
+	jsonMsgObj(ctx, "Token regenerated", gin.H{"token": token}, nil)
+}
+
+// GetResellerUsage returns a reseller's clients and pool consumption.
+// GET /panel/api/resellers/:id/usage
+func (c *ResellerController) GetResellerUsage(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, "Invalid reseller ID", err)
+		return
+	}
+
+	reseller, err := c.resellers.GetReseller(id)
+	if err != nil {
+		jsonMsg(ctx, "Reseller not found", err)
+		return
+	}
+	usage, err := c.resellers.GetPoolUsage(ctx.Request.Context(), reseller)
+	if err != nil {
+		jsonMsg(ctx, "Failed to get pool usage", err)
+		return
+	}
+	clients, err := c.resellers.GetClients(reseller.Id)
+	if err != nil {
+		jsonMsg(ctx, "Failed to get reseller clients", err)
+		return
+	}
+
+	jsonObj(ctx, gin.H{"pool": usage, "clients": clients}, nil)
+}
+
+// ListInboundOwners returns which inbounds are owned by resellers, optionally for one server.
+// GET /panel/api/resellers/inbounds?server_id=2
+func (c *ResellerController) ListInboundOwners(ctx *gin.Context) {
+	serverId, _ := strconv.Atoi(ctx.Query("server_id"))
+	owners, err := c.resellers.GetInboundOwners(serverId)
+	jsonObj(ctx, owners, err)
+}
+
+// TransferInbound assigns an inbound and its clients to a reseller, or back to the admin
+// if resellerId is 0.
+// POST /panel/api/resellers/inbounds/transfer
+// Body: {"serverId": 2, "inboundId": 5, "resellerId": 3}
+func (c *ResellerController) TransferInbound(ctx *gin.Context) {
+	var req struct {
+		ServerId   int `json:"serverId" form:"serverId"`
+		InboundId  int `json:"inboundId" form:"inboundId"`
+		ResellerId int `json:"resellerId" form:"resellerId"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, "Invalid transfer request", err)
+		return
+	}
+
+	err := c.resellers.TransferInbound(ctx.Request.Context(), req.ServerId, req.InboundId, req.ResellerId)
+	if err != nil {
+		jsonMsg(ctx, "Failed to transfer inbound", err)
+		return
+	}
+
+	logger.Infof("Inbound %d on server %d transferred to reseller %d", req.InboundId, req.ServerId, req.ResellerId)
+	jsonMsg(ctx, "Inbound transferred", nil)
+}
+
+// ResellerAPIController serves the restricted API used by resellers.
+// Requests authenticate with "Authorization: Bearer <token>".
+type ResellerAPIController struct {
+	resellers  *service.ResellerService
+	serverMgmt *service.ServerManagementService
+}
+
+// NewResellerAPIController creates a new ResellerAPIController and sets up its routes.
+func NewResellerAPIController(g *gin.RouterGroup) *ResellerAPIController {
+	a := &ResellerAPIController{
+		resellers:  &service.ResellerService{},
+		serverMgmt: &service.ServerManagementService{},
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the reseller API routes.
+func (a *ResellerAPIController) initRouter(g *gin.RouterGroup) {
+	api := g.Group("/reseller/api")
+	api.Use(a.checkToken)
+
+	api.GET("/pool", a.getPool)
+	api.GET("/servers", a.getServers)
+	api.GET("/servers/:id/inbounds", a.getInbounds)
+	api.GET("/inbounds", a.getOwnedInbounds)
+	api.GET("/clients", a.getClients)
+	api.POST("/clients", a.addClient)
+	api.DELETE("/clients/:id", a.delClient)
+}
+
+// checkToken authenticates the reseller and stores it in the request context.
+func (a *ResellerAPIController) checkToken(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	reseller, err := a.resellers.Authenticate(strings.TrimSpace(token))
+	if err != nil {
+		logger.Warningf("Reseller API authentication failed from %s: %v", getRemoteIp(c), err)
+		pureJsonMsg(c, http.StatusUnauthorized, false, "Unauthorized")
+		c.Abort()
+		return
+	}
+	c.Set(resellerContextKey, reseller)
+	c.Next()
+}
+
+// getReseller returns the reseller authenticated by checkToken.
+func (a *ResellerAPIController) getReseller(c *gin.Context) *model.Reseller {
+	return c.MustGet(resellerContextKey).(*model.Reseller)
+}
+
+// getPool returns the reseller's pool limits and consumption.
+func (a *ResellerAPIController) getPool(c *gin.Context) {
+	usage, err := a.resellers.GetPoolUsage(c.Request.Context(), a.getReseller(c))
+	jsonObj(c, usage, err)
+}
+
+// getServers lists the servers the reseller may create clients on.
+func (a *ResellerAPIController) getServers(c *gin.Context) {
+	servers, err := a.resellers.GetPermittedServers(a.getReseller(c))
+	if err != nil {
+		jsonMsg(c, "Failed to get servers", err)
+		return
+	}
+
+	// Only expose what is needed to pick a server
+	result := make([]gin.H, 0, len(servers))
+	for _, server := range servers {
+		result = append(result, gin.H{"id": server.Id, "name": server.Name, "region": server.Region})
+	}
+	jsonObj(c, result, nil)
+}
+
+// getInbounds lists the reseller's inbounds on a permitted server without their settings.
+func (a *ResellerAPIController) getInbounds(c *gin.Context) {
+	serverId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Invalid server ID", err)
+		return
+	}
+
+	servers, err := a.resellers.GetPermittedServers(a.getReseller(c))
+	if err != nil {
+		jsonMsg(c, "Failed to get servers", err)
+		return
+	}
+	permitted := false
+	for _, server := range servers {
+		if server.Id == serverId {
+			permitted = true
+			break
+		}
+	}
+	if !permitted {
+		pureJsonMsg(c, http.StatusForbidden, false, "Server not permitted")
+		return
+	}
+
+	connector, err := a.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		jsonMsg(c, "Failed to connect to server", err)
+		return
+	}
+	inbounds, err := connector.ListInbounds(c.Request.Context())
+	if err != nil {
+		jsonMsg(c, "Failed to get inbounds", err)
+		return
+	}
+
+	reseller := a.getReseller(c)
+	if err := a.resellers.MarkInboundOwners(serverId, inbounds); err != nil {
+		jsonMsg(c, "Failed to get inbounds", err)
+		return
+	}
+
+	// Inbounds of the admin and of other resellers are hidden
+	result := make([]gin.H, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		if !inbound.Enable || inbound.ResellerId != reseller.Id {
+			continue
+		}
+		result = append(result, gin.H{
+			"id":       inbound.Id,
+			"remark":   inbound.Remark,
+			"protocol": inbound.Protocol,
+			"port":     inbound.Port,
+		})
+	}
+	jsonObj(c, result, nil)
+}
+
+// getOwnedInbounds lists the inbounds assigned to the reseller with their clients.
+func (a *ResellerAPIController) getOwnedInbounds(c *gin.Context) {
+	inbounds, err := a.resellers.GetOwnedInbounds(c.Request.Context(), a.getReseller(c))
+	jsonObj(c, inbounds, err)
+}
+
+// getClients lists the clients created by the reseller.
+func (a *ResellerAPIController) getClients(c *gin.Context) {
+	clients, err := a.resellers.GetClients(a.getReseller(c).Id)
+	jsonObj(c, clients, err)
+}
+
+// addClient creates a client on a permitted server, drawing from the pool.
+func (a *ResellerAPIController) addClient(c *gin.Context) {
+	req := &service.ResellerClientRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		jsonMsg(c, "Invalid client data", err)
+		return
+	}
+
+	reseller := a.getReseller(c)
+	client, err := a.resellers.AddClient(c.Request.Context(), reseller, req)
+	if err != nil {
+		jsonMsg(c, "Failed to add client", err)
+		return
+	}
+
+	logger.Infof("Reseller %s created client %s on server %d", reseller.Username, client.Email, client.ServerId)
+	jsonMsgObj(c, "Client added", client, nil)
+}
+
+// delClient deletes one of the reseller's clients.
+func (a *ResellerAPIController) delClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Invalid client ID", err)
+		return
+	}
+
+	jsonMsg(c, "Client deleted", a.resellers.DeleteClient(c.Request.Context(), a.getReseller(c), id))
+}
@@ -1,13 +1,15 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
-	"sync"
 	"time"
 
+	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
 	"github.com/cofedish/3x-UI-agents/web/global"
 	"github.com/cofedish/3x-UI-agents/web/service"
@@ -24,9 +26,14 @@ type ServerController struct {
 	serverService  service.ServerService
 	settingService service.SettingService
 	serverMgmt     *service.ServerManagementService
+	serverScope    service.ServerScopeService
+	metricsHistory service.MetricsHistoryService
 
 	lastStatus *service.Status
 
+	statusInterval  int // Seconds between status refreshes in effect
+	cpuHistoryHours int // Hours of CPU history in effect
+
 	lastVersions        []string
 	lastGetVersionsTime int64 // unix seconds
 }
@@ -47,6 +54,7 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 	g.GET("/status", a.status)
 	g.GET("/aggregatedStatus", a.aggregatedStatus)
 	g.GET("/cpuHistory/:bucket", a.getCpuHistoryBucket)
+	g.GET("/statusConfig", a.getStatusConfig)
 	g.GET("/getXrayVersion", a.getXrayVersion)
 	g.GET("/getConfigJson", a.getConfigJson)
 	g.GET("/getDb", a.getDb)
@@ -55,6 +63,7 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 	g.GET("/getNewmldsa65", a.getNewmldsa65)
 	g.GET("/getNewmlkem768", a.getNewmlkem768)
 	g.GET("/getNewVlessEnc", a.getNewVlessEnc)
+	g.GET("/getNewWireguardKeys", a.getNewWireguardKeys)
 
 	g.POST("/stopXrayService", a.stopXrayService)
 	g.POST("/restartXrayService", a.restartXrayService)
@@ -76,23 +85,45 @@ func (a *ServerController) refreshStatus() {
 	}
 }
 
-// startTask initiates background tasks for continuous status monitoring.
+// startTask initiates background tasks for continuous status monitoring. The interval
+// and CPU history depth come from the settings and apply until the panel restarts.
 func (a *ServerController) startTask() {
+	a.statusInterval, a.cpuHistoryHours = 2, 6
+	if interval, err := a.settingService.GetStatusInterval(); err == nil && interval >= 1 && interval <= 60 {
+		a.statusInterval = interval
+	} else if err != nil {
+		logger.Warning("Failed to get status interval, using the default:", err)
+	}
+	if hours, err := a.settingService.GetCpuHistoryHours(); err == nil && hours >= 1 && hours <= 48 {
+		a.cpuHistoryHours = hours
+	} else if err != nil {
+		logger.Warning("Failed to get CPU history hours, using the default:", err)
+	}
+	a.serverService.SetCpuHistoryDepth(time.Duration(a.cpuHistoryHours)*time.Hour, time.Duration(a.statusInterval)*time.Second)
+
 	webServer := global.GetWebServer()
 	c := webServer.GetCron()
-	c.AddFunc("@every 2s", func() {
+	c.AddFunc(fmt.Sprintf("@every %ds", a.statusInterval), func() {
 		// Always refresh to keep CPU history collected continuously.
-		// Sampling is lightweight and capped to ~6 hours in memory.
+		// Sampling is lightweight and capped to cpuHistoryHours in memory.
 		a.refreshStatus()
 	})
 }
 
-// getServerIdFromRequest extracts server_id from query parameter, defaults to 1 for backward compatibility.
+// getStatusConfig returns the status refresh interval and CPU history depth in effect, so
+// the dashboard polls the status as often as it changes.
+func (a *ServerController) getStatusConfig(c *gin.Context) {
+	jsonObj(c, gin.H{
+		"statusInterval":  a.statusInterval,
+		"cpuHistoryHours": a.cpuHistoryHours,
+	}, nil)
+}
+
+// getServerIdFromRequest extracts server_id from query parameter, defaults to the local server for backward compatibility.
 func (a *ServerController) getServerIdFromRequest(c *gin.Context) int {
-	serverIdStr := c.DefaultQuery("server_id", "1")
-	serverId, err := strconv.Atoi(serverIdStr)
+	serverId, err := strconv.Atoi(c.Query("server_id"))
 	if err != nil || serverId < 1 {
-		return 1
+		return a.serverMgmt.GetLocalServerId()
 	}
 	return serverId
 }
@@ -108,9 +139,13 @@ func convertSystemStatsToStatus(stats *service.SystemStats, health *service.Heal
 	// Determine Xray state from health
 	xrayState := "stop"
 	xrayVersion := "Unknown"
+	xrayError := ""
 	if health != nil {
 		if health.XrayRunning {
 			xrayState = "running"
+		} else if health.XrayError != "" {
+			xrayState = "error"
+			xrayError = health.XrayError
 		}
 		xrayVersion = health.XrayVersion
 	}
@@ -145,11 +180,11 @@ func convertSystemStatsToStatus(stats *service.SystemStats, health *service.Heal
 			"ipv6": stats.PublicIPv6,
 		},
 		"uptime":    stats.Uptime,
-		"appUptime": 0, // Not available from agent
+		"appUptime": stats.AppUptime,
 		"appStats": map[string]interface{}{
-			"threads": 0,
-			"mem":     0,
-			"uptime":  0,
+			"threads": stats.AppGoroutines,
+			"mem":     stats.AppMem,
+			"uptime":  stats.XrayUptime,
 		},
 		"loads":    loads,
 		"tcpCount": stats.TCPConnections,
@@ -157,7 +192,7 @@ func convertSystemStatsToStatus(stats *service.SystemStats, health *service.Heal
 		"xray": map[string]interface{}{
 			"state":    xrayState,
 			"version":  xrayVersion,
-			"errorMsg": "",
+			"errorMsg": xrayError,
 		},
 	}
 }
@@ -167,8 +202,8 @@ func convertSystemStatsToStatus(stats *service.SystemStats, health *service.Heal
 func (a *ServerController) status(c *gin.Context) {
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local cache if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local cache for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		jsonObj(c, a.lastStatus, nil)
 		return
 	}
@@ -176,13 +211,13 @@ func (a *ServerController) status(c *gin.Context) {
 	// Multi-server mode: use connector to get fresh status
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
 	stats, err := connector.GetSystemStats(c.Request.Context())
 	if err != nil {
-		jsonMsg(c, "Failed to get server status", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.getServerStatusFailed"), err)
 		return
 	}
 
@@ -210,6 +245,8 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 		TotalCpuCores  int     `json:"cpuCores"`    // Total CPU cores across all servers
 		TotalMemory    uint64  `json:"totalMemory"` // Total memory across all servers
 		UsedMemory     uint64  `json:"usedMemory"`  // Total used memory
+		TotalSwap      uint64  `json:"totalSwap"`   // Total swap space
+		UsedSwap       uint64  `json:"usedSwap"`    // Total used swap
 		TotalDisk      uint64  `json:"totalDisk"`   // Total disk space
 		UsedDisk       uint64  `json:"usedDisk"`    // Total used disk
 		TotalUpload    uint64  `json:"totalUp"`     // Total upload traffic
@@ -230,18 +267,30 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 	// Get all servers
 	servers, err := a.serverMgmt.GetAllServers()
 	if err != nil {
-		jsonMsg(c, "Failed to get servers", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.getServersFailed"), err)
 		return
 	}
 
-	// Include local server (id=1)
+	// Include the local server
 	aggregated.TotalServers = len(servers) + 1
+	includeLocal := true
+
+	// Optional scope (ID or name) limits the aggregation to the servers it selects
+	if ref := c.Query("scope"); ref != "" {
+		scope, err := a.serverScope.GetScope(ref)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.servers.toasts.getScopeFailed"), err)
+			return
+		}
+		servers, err = a.serverScope.ResolveScope(scope)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.servers.toasts.resolveScopeFailed"), err)
+			return
+		}
+		aggregated.TotalServers = len(servers)
+		includeLocal = slices.ContainsFunc(servers, func(server *model.Server) bool { return server.IsLocal() })
+	}
 
-	// Bounded concurrency for collecting stats
-	maxConcurrency := 10
-	sem := make(chan struct{}, maxConcurrency)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
 
 	// Debug: track which servers contributed to aggregation
 	type ServerDebug struct {
@@ -255,9 +304,6 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 
 	// Helper to aggregate stats
 	aggregateStats := func(serverID int, serverName string, stats interface{}, health *service.HealthStatus) {
-		mu.Lock()
-		defer mu.Unlock()
-
 		aggregated.OnlineServers++
 
 		// Handle local server stats (service.Status)
@@ -266,6 +312,8 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 			aggregated.TotalCpuCores += status.CpuCores
 			aggregated.TotalMemory += status.Mem.Total
 			aggregated.UsedMemory += status.Mem.Current
+			aggregated.TotalSwap += status.Swap.Total
+			aggregated.UsedSwap += status.Swap.Current
 			aggregated.TotalDisk += status.Disk.Total
 			aggregated.UsedDisk += status.Disk.Current
 			aggregated.TotalUpload += status.NetTraffic.Sent
@@ -275,11 +323,11 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 			aggregated.TotalTCP += status.TcpCount
 			aggregated.TotalUDP += status.UdpCount
 
-			// Collect first available public IPs
-			if aggregated.PublicIPv4 == "" && status.PublicIP.IPv4 != "" && status.PublicIP.IPv4 != "0.0.0.0" {
+			// Collect first available public IPs ("N/A" = not detected)
+			if aggregated.PublicIPv4 == "" && status.PublicIP.IPv4 != "" && status.PublicIP.IPv4 != "N/A" {
 				aggregated.PublicIPv4 = status.PublicIP.IPv4
 			}
-			if aggregated.PublicIPv6 == "" && status.PublicIP.IPv6 != "" && status.PublicIP.IPv6 != "::" {
+			if aggregated.PublicIPv6 == "" && status.PublicIP.IPv6 != "" && status.PublicIP.IPv6 != "N/A" {
 				aggregated.PublicIPv6 = status.PublicIP.IPv6
 			}
 
@@ -310,6 +358,8 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 			aggregated.TotalCpuCores += sysStats.CPUCores
 			aggregated.TotalMemory += sysStats.MemTotal
 			aggregated.UsedMemory += sysStats.MemUsed
+			aggregated.TotalSwap += sysStats.SwapTotal
+			aggregated.UsedSwap += sysStats.SwapUsed
 			aggregated.TotalDisk += sysStats.DiskTotal
 			aggregated.UsedDisk += sysStats.DiskUsed
 			aggregated.NetUpSpeed += sysStats.NetOutSpeed
@@ -347,64 +397,55 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 	}
 
 	// Collect local server stats
-	if a.lastStatus != nil {
-		aggregateStats(1, "Local Server", a.lastStatus, nil) // Local status already includes Xray state
-	} else {
-		aggregated.OfflineServers++
+	if includeLocal {
+		if a.lastStatus != nil {
+			aggregateStats(a.serverMgmt.GetLocalServerId(), "Local Server", a.lastStatus, nil) // Local status already includes Xray state
+		} else {
+			aggregated.OfflineServers++
+		}
 	}
 
-	// Collect remote server stats concurrently
+	// Collect remote server stats concurrently; the local server is already processed
+	// via lastStatus and disabled servers count as offline
+	remoteServers := make([]*model.Server, 0, len(servers))
 	for _, server := range servers {
-		wg.Add(1)
-		server := server // Capture loop variable
-
-		go func() {
-			defer wg.Done()
-
-			// Acquire semaphore slot
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			// Skip local server (id=1) as it's already processed via lastStatus
-			if server.Id == 1 {
-				return
-			}
-
-			// Skip disabled servers
-			if !server.Enabled {
-				mu.Lock()
-				aggregated.OfflineServers++
-				mu.Unlock()
-				return
-			}
+		if server.IsLocal() {
+			continue
+		}
+		if !server.Enabled {
+			aggregated.OfflineServers++
+			continue
+		}
+		remoteServers = append(remoteServers, server)
+	}
 
-			// Get connector and fetch stats
+	type remoteStatus struct {
+		stats  *service.SystemStats
+		health *service.HealthStatus
+	}
+	results := service.FanOut(c.Request.Context(), remoteServers, service.FanOutOptions{},
+		func(ctx context.Context, server *model.Server) (*remoteStatus, error) {
 			connector, err := a.serverMgmt.GetConnector(server.Id)
 			if err != nil {
-				mu.Lock()
-				aggregated.OfflineServers++
-				mu.Unlock()
-				return
+				return nil, err
 			}
-
-			ctx := c.Request.Context()
 			stats, err := connector.GetSystemStats(ctx)
 			if err != nil {
-				mu.Lock()
-				aggregated.OfflineServers++
-				mu.Unlock()
-				return
+				return nil, err
 			}
-
 			// Get health status for Xray state
 			health, _ := connector.GetHealth(ctx)
-
-			aggregateStats(server.Id, server.Name, stats, health)
-		}()
+			return &remoteStatus{stats: stats, health: health}, nil
+		})
+	for _, result := range results {
+		if result.Err != nil {
+			logger.Debug("Aggregated status:", result.Err)
+			aggregated.OfflineServers++
+			continue
+		}
+		aggregateStats(result.Server.Id, result.Server.Name, result.Value.stats, result.Value.health)
 	}
 
-	wg.Wait()
-
 	// Calculate average CPU
 	if aggregated.OnlineServers > 0 {
 		aggregated.AvgCpu = aggregated.AvgCpu / float64(aggregated.OnlineServers)
@@ -421,8 +462,8 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 			"total":   aggregated.TotalMemory,
 		},
 		"swap": map[string]uint64{
-			"current": 0,
-			"total":   0,
+			"current": aggregated.UsedSwap,
+			"total":   aggregated.TotalSwap,
 		},
 		"disk": map[string]uint64{
 			"current": aggregated.UsedDisk,
@@ -479,6 +520,9 @@ func (a *ServerController) aggregatedStatus(c *gin.Context) {
 }
 
 // getCpuHistoryBucket retrieves aggregated CPU usage history based on the specified time bucket.
+// Buckets up to 5 minutes of the local server come from the in-memory history; other servers
+// and longer buckets come from the persisted metric samples, which also carry memory, disk and
+// network usage. Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getCpuHistoryBucket(c *gin.Context) {
 	bucketStr := c.Param("bucket")
 	bucket, err := strconv.Atoi(bucketStr)
@@ -493,13 +537,25 @@ func (a *ServerController) getCpuHistoryBucket(c *gin.Context) {
 		120: true, // 2m intervals
 		180: true, // 3m intervals
 		300: true, // 5m intervals
+		// Persisted history only
+		720:    true, // 12m intervals (12h)
+		1440:   true, // 24m intervals (1d)
+		10080:  true, // 168m intervals (7d)
+		43200:  true, // 12h intervals (30d)
+		259200: true, // 3d intervals (180d)
 	}
 	if !allowed[bucket] {
 		jsonMsg(c, "invalid bucket", fmt.Errorf("unsupported bucket"))
 		return
 	}
-	points := a.serverService.AggregateCpuHistory(bucket, 60)
-	jsonObj(c, points, nil)
+	serverId := a.getServerIdFromRequest(c)
+	if serverId == a.serverMgmt.GetLocalServerId() && bucket <= 300 {
+		points := a.serverService.AggregateCpuHistory(bucket, 60)
+		jsonObj(c, points, nil)
+		return
+	}
+	points, err := a.metricsHistory.GetChart(serverId, bucket, 60)
+	jsonObj(c, points, err)
 }
 
 // getXrayVersion retrieves available Xray versions, with caching for 1 minute.
@@ -543,8 +599,8 @@ func (a *ServerController) updateGeofile(c *gin.Context) {
 
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		err := a.serverService.UpdateGeofile(fileName)
 		jsonMsg(c, I18nWeb(c, "pages.index.geofileUpdatePopover"), err)
 		return
@@ -553,7 +609,7 @@ func (a *ServerController) updateGeofile(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -570,8 +626,8 @@ func (a *ServerController) updateGeofile(c *gin.Context) {
 func (a *ServerController) stopXrayService(c *gin.Context) {
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		err := a.serverService.StopXrayService()
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "pages.xray.stopError"), err)
@@ -584,7 +640,7 @@ func (a *ServerController) stopXrayService(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -601,8 +657,8 @@ func (a *ServerController) stopXrayService(c *gin.Context) {
 func (a *ServerController) restartXrayService(c *gin.Context) {
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		err := a.serverService.RestartXrayService()
 		if err != nil {
 			jsonMsg(c, I18nWeb(c, "pages.xray.restartError"), err)
@@ -615,7 +671,7 @@ func (a *ServerController) restartXrayService(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -633,8 +689,8 @@ func (a *ServerController) getLogs(c *gin.Context) {
 	count := c.Param("count")
 	serverId := a.getServerIdFromRequest(c)
 
-	// For backward compatibility, use local service if server_id=1
-	if serverId == 1 {
+	// For backward compatibility, use local service for the local server
+	if a.serverMgmt.IsLocalServer(serverId) {
 		level := c.PostForm("level")
 		syslog := c.PostForm("syslog")
 		logs := a.serverService.GetLogs(count, level, syslog)
@@ -645,7 +701,7 @@ func (a *ServerController) getLogs(c *gin.Context) {
 	// Multi-server mode: use connector
 	connector, err := a.serverMgmt.GetConnector(serverId)
 	if err != nil {
-		jsonMsg(c, "Failed to connect to server", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -657,7 +713,7 @@ func (a *ServerController) getLogs(c *gin.Context) {
 
 	logs, err := connector.GetLogs(c.Request.Context(), countInt)
 	if err != nil {
-		jsonMsg(c, "Failed to get logs", err)
+		jsonMsg(c, I18nWeb(c, "pages.servers.toasts.getLogsFailed"), err)
 		return
 	}
 	jsonObj(c, logs, nil)
@@ -767,9 +823,26 @@ func (a *ServerController) importDB(c *gin.Context) {
 	jsonObj(c, I18nWeb(c, "pages.index.importDatabaseSuccess"), nil)
 }
 
+// generateKey generates key material on the server selected by the server_id
+// query parameter, so it comes from that server's Xray core. Without server_id
+// (or for the local server) the local Xray binary is used.
+func (a *ServerController) generateKey(c *gin.Context, kind string, sni string) (any, error) {
+	serverId, err := strconv.Atoi(c.Query("server_id"))
+	if err != nil || serverId < 1 || a.serverMgmt.IsLocalServer(serverId) {
+		return a.serverService.GenerateKey(kind, sni)
+	}
+
+	connector, err := a.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return nil, err
+	}
+	return connector.GenerateKey(c.Request.Context(), kind, sni)
+}
+
 // getNewX25519Cert generates a new X25519 certificate.
+// Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getNewX25519Cert(c *gin.Context) {
-	cert, err := a.serverService.GetNewX25519Cert()
+	cert, err := a.generateKey(c, "x25519", "")
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.getNewX25519CertError"), err)
 		return
@@ -778,8 +851,9 @@ func (a *ServerController) getNewX25519Cert(c *gin.Context) {
 }
 
 // getNewmldsa65 generates a new ML-DSA-65 key.
+// Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getNewmldsa65(c *gin.Context) {
-	cert, err := a.serverService.GetNewmldsa65()
+	cert, err := a.generateKey(c, "mldsa65", "")
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.getNewmldsa65Error"), err)
 		return
@@ -788,9 +862,10 @@ func (a *ServerController) getNewmldsa65(c *gin.Context) {
 }
 
 // getNewEchCert generates a new ECH certificate for the given SNI.
+// Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getNewEchCert(c *gin.Context) {
 	sni := c.PostForm("sni")
-	cert, err := a.serverService.GetNewEchCert(sni)
+	cert, err := a.generateKey(c, "ech", sni)
 	if err != nil {
 		jsonMsg(c, "get ech certificate", err)
 		return
@@ -798,9 +873,21 @@ func (a *ServerController) getNewEchCert(c *gin.Context) {
 	jsonObj(c, cert, nil)
 }
 
+// getNewWireguardKeys generates a new WireGuard key pair.
+// Supports optional server_id query parameter for multi-server mode.
+func (a *ServerController) getNewWireguardKeys(c *gin.Context) {
+	keys, err := a.generateKey(c, "wireguard", "")
+	if err != nil {
+		jsonMsg(c, "get wireguard keys", err)
+		return
+	}
+	jsonObj(c, keys, nil)
+}
+
 // getNewVlessEnc generates a new VLESS encryption key.
+// Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getNewVlessEnc(c *gin.Context) {
-	out, err := a.serverService.GetNewVlessEnc()
+	out, err := a.generateKey(c, "vlessenc", "")
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.getNewVlessEncError"), err)
 		return
@@ -809,8 +896,9 @@ func (a *ServerController) getNewVlessEnc(c *gin.Context) {
 }
 
 // getNewUUID generates a new UUID.
+// Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getNewUUID(c *gin.Context) {
-	uuidResp, err := a.serverService.GetNewUUID()
+	uuidResp, err := a.generateKey(c, "uuid", "")
 	if err != nil {
 		jsonMsg(c, "Failed to generate UUID", err)
 		return
@@ -820,8 +908,9 @@ func (a *ServerController) getNewUUID(c *gin.Context) {
 }
 
 // getNewmlkem768 generates a new ML-KEM-768 key.
+// Supports optional server_id query parameter for multi-server mode.
 func (a *ServerController) getNewmlkem768(c *gin.Context) {
-	out, err := a.serverService.GetNewmlkem768()
+	out, err := a.generateKey(c, "mlkem768", "")
 	if err != nil {
 		jsonMsg(c, "Failed to generate mlkem768 keys", err)
 		return
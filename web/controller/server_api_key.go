@@ -0,0 +1,185 @@
+// Package controller provides HTTP handlers for server-scoped API keys and the node API.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// nodeServerContextKey is the gin context key holding the server a node API key is scoped to.
+const nodeServerContextKey = "nodeServer"
+
+// ServerAPIKeyController handles the management of server-scoped API keys by panel admins.
+type ServerAPIKeyController struct {
+	keys *service.ServerAPIKeyService
+}
+
+// NewServerAPIKeyController creates a new controller instance.
+func NewServerAPIKeyController() *ServerAPIKeyController {
+	return &ServerAPIKeyController{
+		keys: &service.ServerAPIKeyService{},
+	}
+}
+
+// ListKeys returns the API keys of a server, without the keys themselves.
+// GET /panel/api/servers/:id/api-keys
+func (c *ServerAPIKeyController) ListKeys(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	keys, err := c.keys.GetKeys(serverId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getApiKeysFailed"), err)
+		return
+	}
+	jsonObj(ctx, keys, nil)
+}
+
+// AddKey creates an API key scoped to a server and returns the key once.
+// POST /panel/api/servers/:id/api-keys
+// Body: {"name": "customer portal", "expiresAt": 0, "enable": true}
+func (c *ServerAPIKeyController) AddKey(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	key := &model.ServerAPIKey{Enable: true}
+	if err := ctx.ShouldBind(key); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidApiKey"), err)
+		return
+	}
+	key.ServerId = serverId
+
+	token, err := c.keys.AddKey(key)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addApiKeyFailed"), err)
+		return
+	}
+	logger.Infof("API key %s created for server %d", key.Prefix, serverId)
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.apiKeyAdded"), gin.H{"apiKey": key, "key": token}, nil)
+}
+
+// SetKeyEnable enables or disables an API key of a server.
+// PUT /panel/api/servers/:id/api-keys/:keyId/enable
+// Body: {"enable": false}
+func (c *ServerAPIKeyController) SetKeyEnable(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	id, err := strconv.Atoi(ctx.Param("keyId"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidApiKey"), err)
+		return
+	}
+	var req struct {
+		Enable bool `json:"enable" form:"enable"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidApiKey"), err)
+		return
+	}
+
+	if err := c.keys.SetKeyEnable(serverId, id, req.Enable); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.updateApiKeyFailed"), err)
+		return
+	}
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.apiKeyUpdated"), nil)
+}
+
+// DeleteKey revokes an API key of a server.
+// DELETE /panel/api/servers/:id/api-keys/:keyId
+func (c *ServerAPIKeyController) DeleteKey(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	id, err := strconv.Atoi(ctx.Param("keyId"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidApiKey"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.apiKeyDeleted"), c.keys.DeleteKey(serverId, id))
+}
+
+// NodeAPIController serves the read-only API of a single server to holders of a key
+// scoped to it. Requests authenticate with "Authorization: Bearer <key>".
+type NodeAPIController struct {
+	keys *service.ServerAPIKeyService
+}
+
+// NewNodeAPIController creates a new NodeAPIController and sets up its routes.
+func NewNodeAPIController(g *gin.RouterGroup) *NodeAPIController {
+	a := &NodeAPIController{
+		keys: &service.ServerAPIKeyService{},
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the node API routes.
+func (a *NodeAPIController) initRouter(g *gin.RouterGroup) {
+	api := g.Group("/node/api")
+	api.Use(a.checkKey)
+
+	api.GET("/status", a.getStatus)
+	api.GET("/traffic", a.getTraffic)
+	api.GET("/clients", a.getClients)
+}
+
+// checkKey authenticates the API key and stores the server it is scoped to in the
+// request context.
+func (a *NodeAPIController) checkKey(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	_, server, err := a.keys.Authenticate(strings.TrimSpace(token))
+	if err != nil {
+		logger.Warningf("Node API authentication failed from %s: %v", getRemoteIp(c), err)
+		pureJsonMsg(c, http.StatusUnauthorized, false, "Unauthorized")
+		c.Abort()
+		return
+	}
+	c.Set(nodeServerContextKey, server)
+	c.Next()
+}
+
+// getServer returns the server authenticated by checkKey.
+func (a *NodeAPIController) getServer(c *gin.Context) *model.Server {
+	return c.MustGet(nodeServerContextKey).(*model.Server)
+}
+
+// getStatus returns the status and load of the server.
+func (a *NodeAPIController) getStatus(c *gin.Context) {
+	jsonObj(c, a.keys.GetStatus(c.Request.Context(), a.getServer(c)), nil)
+}
+
+// getTraffic returns the traffic of the server per inbound.
+func (a *NodeAPIController) getTraffic(c *gin.Context) {
+	traffic, err := a.keys.GetTraffic(c.Request.Context(), a.getServer(c))
+	if err != nil {
+		jsonMsg(c, "Failed to get traffic", err)
+		return
+	}
+	jsonObj(c, traffic, nil)
+}
+
+// getClients returns the clients of the server with their usage.
+func (a *NodeAPIController) getClients(c *gin.Context) {
+	clients, err := a.keys.GetClients(c.Request.Context(), a.getServer(c))
+	if err != nil {
+		jsonMsg(c, "Failed to get clients", err)
+		return
+	}
+	jsonObj(c, clients, nil)
+}
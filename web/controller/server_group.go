@@ -0,0 +1,133 @@
+// Package controller provides HTTP handlers for server groups.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ServerGroupController handles server groups and the bulk operations targeting them.
+type ServerGroupController struct {
+	groups *service.ServerGroupService
+}
+
+// NewServerGroupController creates a new controller instance.
+func NewServerGroupController() *ServerGroupController {
+	return &ServerGroupController{
+		groups: &service.ServerGroupService{},
+	}
+}
+
+// ListGroups returns all groups.
+// GET /panel/api/groups
+func (c *ServerGroupController) ListGroups(ctx *gin.Context) {
+	groups, err := c.groups.GetGroups()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getGroupsFailed"), err)
+		return
+	}
+	jsonObj(ctx, groups, nil)
+}
+
+// AddGroup creates a group.
+// POST /panel/api/groups
+// Body: {"name": "edge-eu", "description": "EU edge nodes", "serverIds": "[2, 3]"}
+func (c *ServerGroupController) AddGroup(ctx *gin.Context) {
+	group := &model.ServerGroup{}
+	if err := ctx.ShouldBind(group); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidGroupData"), err)
+		return
+	}
+	if err := c.groups.AddGroup(group); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addGroupFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.groupAdded"), group, nil)
+}
+
+// UpdateGroup updates a group's name, description and members.
+// PUT /panel/api/groups/:id
+func (c *ServerGroupController) UpdateGroup(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidGroupId"), err)
+		return
+	}
+
+	group := &model.ServerGroup{}
+	if err := ctx.ShouldBind(group); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidGroupData"), err)
+		return
+	}
+	group.Id = id
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.groupUpdated"), c.groups.UpdateGroup(group))
+}
+
+// DeleteGroup removes a group.
+// DELETE /panel/api/groups/:id
+func (c *ServerGroupController) DeleteGroup(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidGroupId"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.groupDeleted"), c.groups.DeleteGroup(id))
+}
+
+// GetGroupServers returns the servers of a group, including disabled ones.
+// GET /panel/api/groups/:id/servers
+func (c *ServerGroupController) GetGroupServers(ctx *gin.Context) {
+	group, err := c.groups.GetGroup(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getGroupFailed"), err)
+		return
+	}
+	servers, err := c.groups.GetMembers(group, false)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getGroupFailed"), err)
+		return
+	}
+	jsonObj(ctx, servers, nil)
+}
+
+// RestartXray restarts Xray on every enabled server of a group.
+// POST /panel/api/groups/:id/xray/restart
+func (c *ServerGroupController) RestartXray(ctx *gin.Context) {
+	results, err := c.groups.RestartXray(ctx.Param("id"))
+	c.respondResults(ctx, "Xray restart", results, err)
+}
+
+// UpdateGeoFiles updates the geo files of every enabled server of a group.
+// POST /panel/api/groups/:id/geofiles/update
+func (c *ServerGroupController) UpdateGeoFiles(ctx *gin.Context) {
+	results, err := c.groups.UpdateGeoFiles(ctx.Param("id"))
+	c.respondResults(ctx, "geo file update", results, err)
+}
+
+// respondResults answers a bulk operation with the result of each server.
+func (c *ServerGroupController) respondResults(ctx *gin.Context, operation string, results []*service.ServerGroupResult, err error) {
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.groupOperationFailed"), err)
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+	logger.Infof("Group %s of group %s finished: %d succeeded, %d failed", operation, ctx.Param("id"), len(results)-failed, failed)
+	if failed > 0 {
+		msg := I18nWeb(ctx, "pages.servers.toasts.groupOperationPartly", "Failed=="+strconv.Itoa(failed), "Total=="+strconv.Itoa(len(results)))
+		jsonMsgObj(ctx, msg, results, nil)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.groupOperationDone"), results, nil)
+}
@@ -2,24 +2,73 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
 	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/cofedish/3x-UI-agents/web/session"
 	"github.com/gin-gonic/gin"
 )
 
 // ServerManagementController handles server CRUD operations.
 type ServerManagementController struct {
-	serverMgmt *service.ServerManagementService
+	serverMgmt    *service.ServerManagementService
+	trafficReport *service.TrafficReportService
+	billing       *service.BillingService
+	fleetUpgrade  *service.FleetUpgradeService
+	geoUpdate     *service.GeoUpdateService
+	serverService *service.ServerService
+	fleetOverview *service.FleetOverviewService
+	metrics       *service.MetricsHistoryService
+	serverScope   *service.ServerScopeService
+	anomalies     *service.TrafficAnomalyService
+	connections   *service.InboundConnectionService
+	search        *service.FleetSearchService
+	timeline      *service.ServerTimelineService
+	syncQueue     *service.SyncQueueService
+	fleetSettings *service.FleetSettingsService
+	tasks         *service.ServerTaskService
+	taskQueue     *service.TaskQueueService
+	incidents     *service.IncidentService
+	healthRuns    *service.HealthRunService
+	inboundDeploy *service.InboundDeployService
+	outbounds     *service.OutboundService
+	panelCA       *service.PanelCAService
+	agentUpdate   *service.AgentUpdateService
 }
 
 // NewServerManagementController creates a new controller instance.
 func NewServerManagementController() *ServerManagementController {
 	return &ServerManagementController{
-		serverMgmt: &service.ServerManagementService{},
+		serverMgmt:    &service.ServerManagementService{},
+		trafficReport: &service.TrafficReportService{},
+		billing:       &service.BillingService{},
+		fleetUpgrade:  &service.FleetUpgradeService{},
+		agentUpdate:   &service.AgentUpdateService{},
+		geoUpdate:     &service.GeoUpdateService{},
+		serverService: &service.ServerService{},
+		fleetOverview: &service.FleetOverviewService{},
+		metrics:       &service.MetricsHistoryService{},
+		serverScope:   &service.ServerScopeService{},
+		anomalies:     &service.TrafficAnomalyService{},
+		connections:   &service.InboundConnectionService{},
+		search:        &service.FleetSearchService{},
+		timeline:      &service.ServerTimelineService{},
+		syncQueue:     &service.SyncQueueService{},
+		fleetSettings: &service.FleetSettingsService{},
+		tasks:         &service.ServerTaskService{},
+		taskQueue:     &service.TaskQueueService{},
+		incidents:     &service.IncidentService{},
+		healthRuns:    &service.HealthRunService{},
+		inboundDeploy: &service.InboundDeployService{},
+		outbounds:     &service.OutboundService{},
+		panelCA:       &service.PanelCAService{},
 	}
 }
 
@@ -48,7 +97,7 @@ func (c *ServerManagementController) ListServers(ctx *gin.Context) {
 	servers, err := c.serverMgmt.GetAllServers()
 	if err != nil {
 		logger.Error("Failed to get servers:", err)
-		jsonMsg(ctx, "Failed to get servers", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getServersFailed"), err)
 		return
 	}
 
@@ -115,14 +164,14 @@ func (c *ServerManagementController) ListServers(ctx *gin.Context) {
 func (c *ServerManagementController) GetServer(ctx *gin.Context) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		jsonMsg(ctx, "Invalid server ID", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
 		return
 	}
 
 	server, err := c.serverMgmt.GetServer(id)
 	if err != nil {
 		logger.Error("Failed to get server:", err)
-		jsonMsg(ctx, "Server not found", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.serverNotFound"), err)
 		return
 	}
 
@@ -134,24 +183,9 @@ func (c *ServerManagementController) GetServer(ctx *gin.Context) {
 func (c *ServerManagementController) AddServer(ctx *gin.Context) {
 	var server model.Server
 
+	// Binding checks the name, auth type and endpoint (see service.RegisterValidators)
 	if err := ctx.ShouldBindJSON(&server); err != nil {
-		jsonMsg(ctx, "Invalid server data", err)
-		return
-	}
-
-	// Validate required fields
-	if server.Name == "" {
-		jsonMsg(ctx, "Server name is required", nil)
-		return
-	}
-
-	if server.Endpoint == "" {
-		jsonMsg(ctx, "Server endpoint is required", nil)
-		return
-	}
-
-	if server.AuthType != "mtls" && server.AuthType != "jwt" && server.AuthType != "local" {
-		jsonMsg(ctx, "Invalid auth type (must be: mtls, jwt, or local)", nil)
+		jsonInvalid(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerData"), err)
 		return
 	}
 
@@ -160,13 +194,20 @@ func (c *ServerManagementController) AddServer(ctx *gin.Context) {
 		server.Status = "pending"
 	}
 
+	// mTLS servers added without auth data get certificates from the panel CA
+	if err := c.panelCA.PrefillAuthData(&server); err != nil {
+		logger.Error("Failed to issue server certificates:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.issueCertificatesFailed"), err)
+		return
+	}
+
 	if err := c.serverMgmt.AddServer(&server); err != nil {
 		logger.Error("Failed to add server:", err)
-		jsonMsg(ctx, "Failed to add server", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addServerFailed"), err)
 		return
 	}
 
-	jsonObj(ctx, gin.H{"id": server.Id, "message": "Server added successfully"}, nil)
+	jsonObj(ctx, gin.H{"id": server.Id, "message": I18nWeb(ctx, "pages.servers.addSuccess")}, nil)
 }
 
 // UpdateServer updates an existing server.
@@ -174,13 +215,13 @@ func (c *ServerManagementController) AddServer(ctx *gin.Context) {
 func (c *ServerManagementController) UpdateServer(ctx *gin.Context) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		jsonMsg(ctx, "Invalid server ID", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
 		return
 	}
 
 	var server model.Server
 	if err := ctx.ShouldBindJSON(&server); err != nil {
-		jsonMsg(ctx, "Invalid server data", err)
+		jsonInvalid(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerData"), err)
 		return
 	}
 
@@ -188,11 +229,11 @@ func (c *ServerManagementController) UpdateServer(ctx *gin.Context) {
 
 	if err := c.serverMgmt.UpdateServer(&server); err != nil {
 		logger.Error("Failed to update server:", err)
-		jsonMsg(ctx, "Failed to update server", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.updateServerFailed"), err)
 		return
 	}
 
-	jsonMsg(ctx, "Server updated successfully", nil)
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.serverUpdated"), nil)
 }
 
 // DeleteServer deletes a server.
@@ -200,17 +241,129 @@ func (c *ServerManagementController) UpdateServer(ctx *gin.Context) {
 func (c *ServerManagementController) DeleteServer(ctx *gin.Context) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		jsonMsg(ctx, "Invalid server ID", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
 		return
 	}
 
 	if err := c.serverMgmt.DeleteServer(id); err != nil {
 		logger.Error("Failed to delete server:", err)
-		jsonMsg(ctx, "Failed to delete server", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.deleteServerFailed"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.serverDeleted"), nil)
+}
+
+// RotateJWTSecret replaces the JWT secret shared with a server's agent.
+// POST /panel/api/servers/:id/jwt/rotate
+func (c *ServerManagementController) RotateJWTSecret(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	if err := c.serverMgmt.RotateJWTSecret(id); err != nil {
+		logger.Warning("Failed to rotate JWT secret:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.rotateJwtFailed"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.jwtRotated"), nil)
+}
+
+// GetCACert returns the certificate of the panel CA agents are issued certificates from.
+// GET /panel/api/servers/ca
+func (c *ServerManagementController) GetCACert(ctx *gin.Context) {
+	caPem, err := c.panelCA.GetCACert()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getCertificatesFailed"), err)
+		return
+	}
+	jsonObj(ctx, gin.H{"caPem": caPem}, nil)
+}
+
+// GetCRL returns the CRL of the panel CA, listing the panel certificates revoked by
+// renewals, for AGENT_CRL_FILE on the agents.
+// GET /panel/api/servers/ca/crl
+func (c *ServerManagementController) GetCRL(ctx *gin.Context) {
+	crlPem, err := c.panelCA.GetCRL()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getCertificatesFailed"), err)
+		return
+	}
+	jsonObj(ctx, gin.H{"crlPem": crlPem}, nil)
+}
+
+// GetServerCertificates returns the certificate, key and CA to install on a server's
+// agent, for servers whose certificates the panel issued.
+// GET /panel/api/servers/:id/certificates
+func (c *ServerManagementController) GetServerCertificates(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	certs, err := c.panelCA.GetServerCertificates(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getCertificatesFailed"), err)
+		return
+	}
+	jsonObj(ctx, certs, nil)
+}
+
+// RenewServerCertificates issues new certificates for a server and returns the agent's.
+// POST /panel/api/servers/:id/certificates/renew
+func (c *ServerManagementController) RenewServerCertificates(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	certs, err := c.panelCA.RenewServerCertificates(id)
+	if err != nil {
+		logger.Warning("Failed to renew server certificates:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.renewCertificatesFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.certificatesRenewed"), certs, nil)
+}
+
+// StreamLogs proxies a live tail of a server's agent or Xray logs to a WebSocket.
+// Server ID 0 ("All Servers" in the UI) streams the logs of the local server.
+// GET /panel/api/servers/:id/logs/stream?source=agent|xray|access&level=info&tail=100
+func (c *ServerManagementController) StreamLogs(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	if id == 0 {
+		id = c.serverMgmt.GetLocalServerId()
+	}
+
+	req, err := service.ParseLogStreamRequest(ctx.Query("source"), ctx.Query("level"), ctx.Query("tail"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.streamLogsFailed"), err)
 		return
 	}
 
-	jsonMsg(ctx, "Server deleted successfully", nil)
+	connector, err := c.serverMgmt.GetConnector(id)
+	if err != nil {
+		logger.Error("Failed to get connector:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
+		return
+	}
+
+	service.ServeLogStream(ctx.Writer, ctx.Request, func(streamCtx context.Context, send func(*logtail.Line) error) error {
+		err := connector.StreamLogs(streamCtx, req, send)
+		if err != nil {
+			logger.Warning("Log stream of server", id, "ended:", err)
+		}
+		return err
+	})
 }
 
 // GetServerHealth tests server connectivity and returns health status.
@@ -218,14 +371,14 @@ func (c *ServerManagementController) DeleteServer(ctx *gin.Context) {
 func (c *ServerManagementController) GetServerHealth(ctx *gin.Context) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		jsonMsg(ctx, "Invalid server ID", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
 		return
 	}
 
 	connector, err := c.serverMgmt.GetConnector(id)
 	if err != nil {
 		logger.Error("Failed to get connector:", err)
-		jsonMsg(ctx, "Failed to connect to server", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
@@ -247,46 +400,843 @@ func (c *ServerManagementController) GetServerHealth(ctx *gin.Context) {
 func (c *ServerManagementController) GetServerInfo(ctx *gin.Context) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		jsonMsg(ctx, "Invalid server ID", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
 		return
 	}
 
 	connector, err := c.serverMgmt.GetConnector(id)
 	if err != nil {
 		logger.Error("Failed to get connector:", err)
-		jsonMsg(ctx, "Failed to connect to server", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
 		return
 	}
 
 	info, err := connector.GetServerInfo(ctx.Request.Context())
 	if err != nil {
 		logger.Error("Failed to get server info:", err)
-		jsonMsg(ctx, "Failed to get server info", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getServerInfoFailed"), err)
 		return
 	}
 
 	jsonObj(ctx, info, nil)
 }
 
+// XrayAddUser adds a user to a running inbound on a server via its Xray API, without a restart.
+// The change is runtime-only and is not saved to the server's database.
+// POST /panel/api/servers/:id/xray/users
+func (c *ServerManagementController) XrayAddUser(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	var req service.XrayUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidUserData"), err)
+		return
+	}
+	if req.Protocol == "" || req.InboundTag == "" || req.User == nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.userFieldsRequired"), nil)
+		return
+	}
+
+	connector, err := c.serverMgmt.GetConnector(id)
+	if err != nil {
+		logger.Error("Failed to get connector:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
+		return
+	}
+
+	if err := connector.XrayAddUser(ctx.Request.Context(), &req); err != nil {
+		logger.Warning("Failed to add user via Xray API:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addUserFailed"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.userAdded"), nil)
+}
+
+// XrayRemoveUser removes a user from a running inbound on a server via its Xray API.
+// DELETE /panel/api/servers/:id/xray/inbounds/:tag/users/:email
+func (c *ServerManagementController) XrayRemoveUser(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	connector, err := c.serverMgmt.GetConnector(id)
+	if err != nil {
+		logger.Error("Failed to get connector:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
+		return
+	}
+
+	if err := connector.XrayRemoveUser(ctx.Request.Context(), ctx.Param("tag"), ctx.Param("email")); err != nil {
+		logger.Warning("Failed to remove user via Xray API:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.removeUserFailed"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.userRemoved"), nil)
+}
+
+// XrayQueryStats returns raw Xray counters of a server.
+// GET /panel/api/servers/:id/xray/stats
+// Query params: pattern (substring of the counter name)
+func (c *ServerManagementController) XrayQueryStats(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	connector, err := c.serverMgmt.GetConnector(id)
+	if err != nil {
+		logger.Error("Failed to get connector:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
+		return
+	}
+
+	stats, err := connector.XrayQueryStats(ctx.Request.Context(), ctx.Query("pattern"))
+	if err != nil {
+		logger.Warning("Failed to query Xray stats:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.queryStatsFailed"), err)
+		return
+	}
+
+	jsonObj(ctx, stats, nil)
+}
+
+// GetAllTimeTraffic returns all-time traffic per server and per client.
+// GET /panel/api/servers/traffic/alltime
+// Query params: server_id (optional, 0 or omitted = all enabled servers), tag, region, scope
+func (c *ServerManagementController) GetAllTimeTraffic(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	report, err := c.trafficReport.GetAllTimeReport(ctx.Request.Context(), serverId, serverFilterFromQuery(ctx))
+	if err != nil {
+		logger.Error("Failed to build all-time traffic report:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getAllTimeTrafficFailed"), err)
+		return
+	}
+
+	jsonObj(ctx, report, nil)
+}
+
+// ExportBilling returns per-client, per-server usage for a billing period.
+// GET /panel/api/servers/billing/export
+// Query params: from, to (Unix seconds or YYYY-MM-DD, default current month), server_id,
+// tag, region, scope, format (json|csv, default json), resets (include reset events, default true).
+// Dates and the current month are taken in the server's timezone if server_id is set.
+func (c *ServerManagementController) ExportBilling(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	loc := time.Local
+	if serverId != 0 {
+		loc = c.serverMgmt.GetServerLocation(serverId)
+	}
+	now := time.Now().In(loc)
+	from, err := parseBillingTime(ctx.Query("from"), time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc), loc)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodStart"), err)
+		return
+	}
+	to, err := parseBillingTime(ctx.Query("to"), now, loc)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodEnd"), err)
+		return
+	}
+	includeResets, err := strconv.ParseBool(ctx.DefaultQuery("resets", "true"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidResetsFlag"), err)
+		return
+	}
+
+	report, err := c.billing.GetBillingReport(from, to, serverId, serverFilterFromQuery(ctx), includeResets)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.billingReportFailed"), err)
+		return
+	}
+
+	switch ctx.DefaultQuery("format", "json") {
+	case "json":
+		jsonObj(ctx, report, nil)
+	case "csv":
+		filename := fmt.Sprintf("billing-%s-%s.csv", from.Format("20060102"), to.Format("20060102"))
+		ctx.Header("Content-Type", "text/csv")
+		ctx.Header("Content-Disposition", "attachment; filename="+filename)
+		if err := c.billing.WriteBillingCSV(ctx.Writer, report); err != nil {
+			logger.Warning("Failed to write billing CSV:", err)
+		}
+	default:
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidFormat"), nil)
+	}
+}
+
+// ExportMetrics returns the CPU, memory and network history of a server or scope.
+// GET /panel/api/servers/metrics/export
+// Query params: from, to (Unix seconds or YYYY-MM-DD, default last 7 days), server_id or
+// scope (ID or name; all servers if neither is set), format (json|csv, default csv)
+func (c *ServerManagementController) ExportMetrics(ctx *gin.Context) {
+	now := time.Now()
+	from, err := parseBillingTime(ctx.Query("from"), now.AddDate(0, 0, -7), time.Local)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodStart"), err)
+		return
+	}
+	to, err := parseBillingTime(ctx.Query("to"), now, time.Local)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodEnd"), err)
+		return
+	}
+
+	serverIds := make([]int, 0)
+	if ref := ctx.Query("scope"); ref != "" {
+		scope, err := c.serverScope.GetScope(ref)
+		if err != nil {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getScopeFailed"), err)
+			return
+		}
+		servers, err := c.serverScope.ResolveScope(scope)
+		if err != nil {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.resolveScopeFailed"), err)
+			return
+		}
+		if len(servers) == 0 {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.scopeEmpty"), nil)
+			return
+		}
+		for _, server := range servers {
+			serverIds = append(serverIds, server.Id)
+		}
+	} else if value := ctx.Query("server_id"); value != "" && value != "0" {
+		serverId, err := strconv.Atoi(value)
+		if err != nil {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+			return
+		}
+		serverIds = append(serverIds, serverId)
+	}
+
+	samples, err := c.metrics.GetHistory(serverIds, from, to)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getMetricsHistoryFailed"), err)
+		return
+	}
+
+	switch ctx.DefaultQuery("format", "csv") {
+	case "json":
+		jsonObj(ctx, samples, nil)
+	case "csv":
+		filename := fmt.Sprintf("metrics-%s-%s.csv", from.Format("20060102"), to.Format("20060102"))
+		ctx.Header("Content-Type", "text/csv")
+		ctx.Header("Content-Disposition", "attachment; filename="+filename)
+		if err := c.metrics.WriteMetricsCSV(ctx.Writer, samples); err != nil {
+			logger.Warning("Failed to write metrics CSV:", err)
+		}
+	default:
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidFormat"), nil)
+	}
+}
+
+// GetAnomalies returns the recently detected traffic spikes and drops, newest first.
+// GET /panel/api/servers/anomalies
+func (c *ServerManagementController) GetAnomalies(ctx *gin.Context) {
+	jsonObj(ctx, c.anomalies.GetRecentAnomalies(), nil)
+}
+
+// GetInboundConnections returns the current connections and online clients per inbound,
+// summed across servers for inbounds with the same tag.
+// GET /panel/api/servers/connections
+// Query params: server_id (default 0 = all enabled servers)
+func (c *ServerManagementController) GetInboundConnections(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	groups, err := c.connections.GetInboundConnections(ctx.Request.Context(), serverId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getConnectionsFailed"), err)
+		return
+	}
+	jsonObj(ctx, groups, nil)
+}
+
+// Search finds servers, inbounds, clients and tasks matching a query across the fleet.
+// GET /panel/api/search
+// Query params: q, limit (results per type, default 20, max 100)
+func (c *ServerManagementController) Search(ctx *gin.Context) {
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	results, err := c.search.Search(ctx.Request.Context(), ctx.Query("q"), limit)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.searchFailed"), err)
+		return
+	}
+	jsonObj(ctx, results, nil)
+}
+
+// GetServerTimeline returns what happened to a server: status changes, Xray restarts,
+// config pushes, certificates, tasks, traffic resets and geo file updates, newest first.
+// GET /panel/api/servers/:id/timeline
+// Query params: from, to (Unix seconds or YYYY-MM-DD in the server's timezone, default last
+// 24 hours), limit (default 200)
+func (c *ServerManagementController) GetServerTimeline(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	loc := c.serverMgmt.GetServerLocation(id)
+	now := time.Now()
+	from, err := parseBillingTime(ctx.Query("from"), now.Add(-24*time.Hour), loc)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodStart"), err)
+		return
+	}
+	to, err := parseBillingTime(ctx.Query("to"), now, loc)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodEnd"), err)
+		return
+	}
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "200"))
+	if limit < 1 || limit > 1000 {
+		limit = 200
+	}
+
+	timeline, err := c.timeline.GetTimeline(id, from, to, limit)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getTimelineFailed"), err)
+		return
+	}
+	jsonObj(ctx, timeline, nil)
+}
+
+// GetSyncQueue returns the inbound and client mutations queued while a server was
+// unreachable, in the order they will be applied.
+// GET /panel/api/servers/:id/sync-queue
+func (c *ServerManagementController) GetSyncQueue(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	tasks, err := c.syncQueue.GetPending(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getSyncQueueFailed"), err)
+		return
+	}
+	jsonObj(ctx, tasks, nil)
+}
+
+// GetConnectorStats returns the requests the panel sent to the agent of a server since
+// it started, per endpoint: counts, errors by code and latency.
+// GET /panel/api/servers/:id/connector-stats
+func (c *ServerManagementController) GetConnectorStats(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	stats, err := c.serverMgmt.GetConnectorStats(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getConnectorStatsFailed"), err)
+		return
+	}
+	jsonObj(ctx, stats, nil)
+}
+
+// ListTasks returns server tasks across the fleet, newest first, with the owning server's
+// name and tags.
+// GET /panel/api/tasks (also /panel/api/servers/tasks)
+// Query params: server_id, operation, status, tag, region, scope, limit (default 100, max 1000)
+func (c *ServerManagementController) ListTasks(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+
+	tasks, err := c.tasks.GetTasks(&service.TaskQuery{
+		ServerId:  serverId,
+		Operation: ctx.Query("operation"),
+		Status:    ctx.Query("status"),
+		Filter:    serverFilterFromQuery(ctx),
+		Limit:     limit,
+	})
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getTasksFailed"), err)
+		return
+	}
+	jsonObj(ctx, tasks, nil)
+}
+
+// GetTask returns one server task, including the progress and stage of long operations
+// such as Xray installs, for the UI to poll.
+// GET /panel/api/tasks/:id
+func (c *ServerManagementController) GetTask(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidTaskId"), err)
+		return
+	}
+
+	task, err := c.tasks.GetTask(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getTaskFailed"), err)
+		return
+	}
+	jsonObj(ctx, task, nil)
+}
+
+// GetOutboundTraffic returns the traffic totals of each outbound tag per server, e.g. to
+// compare WARP and direct traffic. Remote servers are collected every minute.
+// GET /panel/api/servers/outbounds/traffic
+// Query params: server_id (default 0 = all servers)
+func (c *ServerManagementController) GetOutboundTraffic(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	traffics, err := c.outbounds.GetFleetOutboundsTraffic(serverId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getOutboundTrafficFailed"), err)
+		return
+	}
+	jsonObj(ctx, traffics, nil)
+}
+
+// EnqueueTask queues an operation on a server; the task queue runs it in the background
+// and retries failed attempts with growing delays.
+// POST /panel/api/tasks
+// Body: {"serverId": 2, "operation": "restart_xray", "maxRetries": 3} (operations: restart_xray,
+// update_geofiles; maxRetries omitted = 3)
+func (c *ServerManagementController) EnqueueTask(ctx *gin.Context) {
+	var req struct {
+		ServerId   int    `json:"serverId"`
+		Operation  string `json:"operation"`
+		MaxRetries *int   `json:"maxRetries"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidTaskRequest"), err)
+		return
+	}
+	maxRetries := -1
+	if req.MaxRetries != nil {
+		maxRetries = max(*req.MaxRetries, 0)
+	}
+
+	userId := 0
+	if user := session.GetLoginUser(ctx); user != nil {
+		userId = user.Id
+	}
+
+	task, err := c.taskQueue.Enqueue(req.ServerId, req.Operation, nil, maxRetries, userId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.enqueueTaskFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.taskQueued"), task, nil)
+}
+
+// ListHealthRuns returns the latest runs of the health job, newest first, with their
+// average and maximum duration and whether the job stalled.
+// GET /panel/api/servers/health-runs
+// Query params: limit (default 100, max 1000)
+func (c *ServerManagementController) ListHealthRuns(ctx *gin.Context) {
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+	report, err := c.healthRuns.GetRuns(limit)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getHealthRunsFailed"), err)
+		return
+	}
+	jsonObj(ctx, report, nil)
+}
+
+// ListIncidents returns server outages, newest first: each incident opens when a server
+// goes offline or into error and closes when it is back online.
+// GET /panel/api/servers/incidents
+// Query params: server_id, open (true = only ongoing), from, to (Unix seconds or YYYY-MM-DD),
+// limit (default 100)
+func (c *ServerManagementController) ListIncidents(ctx *gin.Context) {
+	serverId, err := strconv.Atoi(ctx.DefaultQuery("server_id", "0"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	query := &service.IncidentQuery{
+		ServerId: serverId,
+		OpenOnly: ctx.Query("open") == "true",
+	}
+	query.Limit, _ = strconv.Atoi(ctx.Query("limit"))
+	if value := ctx.Query("from"); value != "" {
+		from, err := parseBillingTime(value, time.Time{}, time.Local)
+		if err != nil {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodStart"), err)
+			return
+		}
+		query.From = from.Unix()
+	}
+	if value := ctx.Query("to"); value != "" {
+		to, err := parseBillingTime(value, time.Time{}, time.Local)
+		if err != nil {
+			jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeriodEnd"), err)
+			return
+		}
+		query.To = to.Unix()
+	}
+
+	incidents, err := c.incidents.GetIncidents(query)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getIncidentsFailed"), err)
+		return
+	}
+	jsonObj(ctx, incidents, nil)
+}
+
+// GetIncident returns one incident; Telegram notifications link here.
+// GET /panel/api/servers/incidents/:id
+func (c *ServerManagementController) GetIncident(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidIncidentId"), err)
+		return
+	}
+	incident, err := c.incidents.GetIncident(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.incidentNotFound"), err)
+		return
+	}
+	jsonObj(ctx, incident, nil)
+}
+
+// parseBillingTime parses a period boundary given as Unix seconds or a YYYY-MM-DD date,
+// which starts at midnight in loc.
+func parseBillingTime(value string, fallback time.Time, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+// GetXrayVersions reports the Xray version installed on each server together with
+// the versions available for upgrade.
+// GET /panel/api/servers/xray/versions
+func (c *ServerManagementController) GetXrayVersions(ctx *gin.Context) {
+	installed, err := c.fleetUpgrade.GetVersionReport(ctx.Request.Context())
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getXrayVersionsFailed"), err)
+		return
+	}
+
+	available, err := c.serverService.GetXrayVersions()
+	if err != nil {
+		logger.Warning("Failed to get available Xray versions:", err)
+	}
+
+	jsonObj(ctx, gin.H{"servers": installed, "available": available}, nil)
+}
+
+// UpgradeXray starts a fleet-wide Xray upgrade.
+// POST /panel/api/servers/xray/upgrade
+// Body: {"version": "v25.10.15", "serverIds": [1, 2], "concurrency": 3} (serverIds empty = all enabled)
+func (c *ServerManagementController) UpgradeXray(ctx *gin.Context) {
+	var req struct {
+		Version     string `json:"version"`
+		ServerIds   []int  `json:"serverIds"`
+		Concurrency int    `json:"concurrency"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidUpgradeRequest"), err)
+		return
+	}
+
+	userId := 0
+	if user := session.GetLoginUser(ctx); user != nil {
+		userId = user.Id
+	}
+
+	tasks, err := c.fleetUpgrade.StartUpgrade(req.Version, req.ServerIds, req.Concurrency, userId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.startXrayUpgradeFailed"), err)
+		return
+	}
+
+	logger.Infof("Fleet Xray upgrade to %s started on %d servers", req.Version, len(tasks))
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.xrayUpgradeStarted"), tasks, nil)
+}
+
+// GetXrayUpgradeStatus returns the latest upgrade task of each server.
+// GET /panel/api/servers/xray/upgrade
+func (c *ServerManagementController) GetXrayUpgradeStatus(ctx *gin.Context) {
+	tasks, err := c.fleetUpgrade.GetLatestUpgradeTasks()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getUpgradeStatusFailed"), err)
+		return
+	}
+	jsonObj(ctx, tasks, nil)
+}
+
+// UpdateAgents updates the agent of remote servers to a signed release.
+// POST /panel/api/servers/agent/update
+// Body: {"version": "v2.8.5", "serverIds": [1, 2], "concurrency": 3} (version empty = the
+// panel's version, serverIds empty = all enabled remote servers)
+func (c *ServerManagementController) UpdateAgents(ctx *gin.Context) {
+	var req struct {
+		Version     string `json:"version"`
+		ServerIds   []int  `json:"serverIds"`
+		Concurrency int    `json:"concurrency"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidUpgradeRequest"), err)
+		return
+	}
+	c.startAgentUpdate(ctx, req.Version, req.ServerIds, req.Concurrency)
+}
+
+// UpdateAgent updates the agent of one server to a signed release.
+// POST /panel/api/servers/:id/agent/update
+// Body: {"version": "v2.8.5"} (optional, default the panel's version)
+func (c *ServerManagementController) UpdateAgent(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+	var req struct {
+		Version string `json:"version"`
+	}
+	// The body is optional
+	ctx.ShouldBindJSON(&req)
+	c.startAgentUpdate(ctx, req.Version, []int{id}, 1)
+}
+
+// startAgentUpdate starts an agent update and answers with its tasks.
+func (c *ServerManagementController) startAgentUpdate(ctx *gin.Context, version string, serverIds []int, concurrency int) {
+	userId := 0
+	if user := session.GetLoginUser(ctx); user != nil {
+		userId = user.Id
+	}
+
+	tasks, err := c.agentUpdate.StartUpdate(version, serverIds, concurrency, userId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.startAgentUpdateFailed"), err)
+		return
+	}
+
+	logger.Infof("Agent update started on %d servers", len(tasks))
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.agentUpdateStarted"), tasks, nil)
+}
+
+// GetAgentUpdateStatus returns the latest agent update task of each server.
+// GET /panel/api/servers/agent/update
+func (c *ServerManagementController) GetAgentUpdateStatus(ctx *gin.Context) {
+	tasks, err := c.agentUpdate.GetLatestUpdateTasks()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getUpgradeStatusFailed"), err)
+		return
+	}
+	jsonObj(ctx, tasks, nil)
+}
+
+// GetGeoFileStatuses returns when each server's geo files were last updated.
+// GET /panel/api/servers/geofiles
+func (c *ServerManagementController) GetGeoFileStatuses(ctx *gin.Context) {
+	statuses, err := c.geoUpdate.GetStatuses()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getGeoFileStatusFailed"), err)
+		return
+	}
+	jsonObj(ctx, statuses, nil)
+}
+
+// GetSettingsStatus returns the fleet settings version applied by each server.
+// GET /panel/api/servers/settings
+func (c *ServerManagementController) GetSettingsStatus(ctx *gin.Context) {
+	statuses, err := c.fleetSettings.GetStatuses()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getSettingsStatusFailed"), err)
+		return
+	}
+	jsonObj(ctx, statuses, nil)
+}
+
+// PushSettings pushes panel settings to a scope, selected servers, or all enabled servers.
+// POST /panel/api/servers/settings/push
+// Body: {"keys": ["xrayLogLevel"], "scope": "production", "serverIds": [1, 2]} (keys empty = all
+// fleet settings; scope and serverIds empty = all enabled servers)
+func (c *ServerManagementController) PushSettings(ctx *gin.Context) {
+	var req struct {
+		Keys      []string `json:"keys"`
+		Scope     string   `json:"scope"`
+		ServerIds []int    `json:"serverIds"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidSettingsPush"), err)
+		return
+	}
+
+	statuses, err := c.fleetSettings.PushSettings(ctx.Request.Context(), req.Keys, req.Scope, req.ServerIds)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.pushSettingsFailed"), err)
+		return
+	}
+
+	failed := 0
+	for _, status := range statuses {
+		if status.Status == "failed" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		msg := I18nWeb(ctx, "pages.servers.toasts.settingsPushedPartly", "Failed=="+strconv.Itoa(failed), "Total=="+strconv.Itoa(len(statuses)))
+		jsonMsgObj(ctx, msg, statuses, nil)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.settingsPushed"), statuses, nil)
+}
+
+// DeployInbound creates an inbound template on several servers at once and reports the
+// result of each server.
+// POST /panel/api/servers/deploy-inbound
+// Body: {"inbound": {...}, "serverIds": [1, 2], "concurrency": 5} or, instead of serverIds,
+// a group {"group": "edge-eu"} or a selector {"tags": "production,eu", "region": "", "scope": ""}
+func (c *ServerManagementController) DeployInbound(ctx *gin.Context) {
+	var req service.InboundDeployRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidDeployRequest"), err)
+		return
+	}
+
+	userId := 0
+	if user := session.GetLoginUser(ctx); user != nil {
+		userId = user.Id
+	}
+
+	results, err := c.inboundDeploy.Deploy(&req, userId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.deployInboundFailed"), err)
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+	if failed > 0 {
+		msg := I18nWeb(ctx, "pages.servers.toasts.inboundDeployedPartly", "Failed=="+strconv.Itoa(failed), "Total=="+strconv.Itoa(len(results)))
+		jsonMsgObj(ctx, msg, results, nil)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.inboundDeployed"), results, nil)
+}
+
+// GetServerGeoFiles returns the geo files installed on a server.
+// GET /panel/api/servers/:id/geofiles
+func (c *ServerManagementController) GetServerGeoFiles(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	connector, err := c.serverMgmt.GetConnector(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
+		return
+	}
+	files, err := connector.GetGeoFiles(ctx.Request.Context())
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getGeoFilesFailed"), err)
+		return
+	}
+	jsonObj(ctx, files, nil)
+}
+
+// GetServerCapabilities returns the optional Xray features supported by a server.
+// GET /panel/api/servers/:id/capabilities
+func (c *ServerManagementController) GetServerCapabilities(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return
+	}
+
+	connector, err := c.serverMgmt.GetConnector(id)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.connectFailed"), err)
+		return
+	}
+	caps, err := connector.GetCapabilities(ctx.Request.Context())
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getCapabilitiesFailed"), err)
+		return
+	}
+	jsonObj(ctx, caps, nil)
+}
+
+// GetOverview returns status, latency, system metrics, Xray state, online clients and a
+// short metrics history of every server in one response, for the servers list page.
+// GET /panel/api/servers/overview
+func (c *ServerManagementController) GetOverview(ctx *gin.Context) {
+	overview, err := c.fleetOverview.GetOverview()
+	if err != nil {
+		logger.Error("Failed to get fleet overview:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getFleetOverviewFailed"), err)
+		return
+	}
+	jsonObj(ctx, overview, nil)
+}
+
 // GetServerStats returns aggregated statistics.
 // GET /panel/api/servers/stats
 func (c *ServerManagementController) GetServerStats(ctx *gin.Context) {
 	servers, err := c.serverMgmt.GetAllServers()
 	if err != nil {
 		logger.Error("Failed to get servers:", err)
-		jsonMsg(ctx, "Failed to get server stats", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getServerStatsFailed"), err)
 		return
 	}
 
 	stats := gin.H{
-		"total":   len(servers),
-		"online":  0,
-		"offline": 0,
-		"error":   0,
-		"pending": 0,
+		"total":     len(servers),
+		"online":    0,
+		"offline":   0,
+		"error":     0,
+		"pending":   0,
+		"warning":   0, // Servers with a warning, e.g. clock drift, counted on top of their status
+		"outOfSync": 0, // Servers whose agent config epoch differs from the panel's, also on top
 	}
 
 	for _, server := range servers {
+		if server.Warning != "" {
+			stats["warning"] = stats["warning"].(int) + 1
+		}
+		if server.ConfigOutOfSync {
+			stats["outOfSync"] = stats["outOfSync"].(int) + 1
+		}
 		switch server.Status {
 		case "online":
 			stats["online"] = stats["online"].(int) + 1
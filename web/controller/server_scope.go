@@ -0,0 +1,96 @@
+// Package controller provides HTTP handlers for named server scopes.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ServerScopeController handles saved server scopes used by the aggregated dashboard.
+type ServerScopeController struct {
+	scopes *service.ServerScopeService
+}
+
+// NewServerScopeController creates a new controller instance.
+func NewServerScopeController() *ServerScopeController {
+	return &ServerScopeController{
+		scopes: &service.ServerScopeService{},
+	}
+}
+
+// ListScopes returns all scopes.
+// GET /panel/api/scopes
+func (c *ServerScopeController) ListScopes(ctx *gin.Context) {
+	scopes, err := c.scopes.GetScopes()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getScopesFailed"), err)
+		return
+	}
+	jsonObj(ctx, scopes, nil)
+}
+
+// AddScope creates a scope.
+// POST /panel/api/scopes
+// Body: {"name": "EU production", "tags": "[\"production\"]", "regions": "[\"EU\"]", "serverIds": "[]"}
+func (c *ServerScopeController) AddScope(ctx *gin.Context) {
+	scope := &model.ServerScope{}
+	if err := ctx.ShouldBind(scope); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidScopeData"), err)
+		return
+	}
+	if err := c.scopes.AddScope(scope); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addScopeFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.scopeAdded"), scope, nil)
+}
+
+// UpdateScope updates a scope's name and criteria.
+// PUT /panel/api/scopes/:id
+func (c *ServerScopeController) UpdateScope(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidScopeId"), err)
+		return
+	}
+
+	scope := &model.ServerScope{}
+	if err := ctx.ShouldBind(scope); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidScopeData"), err)
+		return
+	}
+	scope.Id = id
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.scopeUpdated"), c.scopes.UpdateScope(scope))
+}
+
+// DeleteScope removes a scope.
+// DELETE /panel/api/scopes/:id
+func (c *ServerScopeController) DeleteScope(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidScopeId"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.scopeDeleted"), c.scopes.DeleteScope(id))
+}
+
+// GetScopeServers returns the servers currently matching a scope.
+// GET /panel/api/scopes/:id/servers
+func (c *ServerScopeController) GetScopeServers(ctx *gin.Context) {
+	scope, err := c.scopes.GetScope(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getScopeFailed"), err)
+		return
+	}
+	servers, err := c.scopes.ResolveScope(scope)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.resolveScopeFailed"), err)
+		return
+	}
+	jsonObj(ctx, servers, nil)
+}
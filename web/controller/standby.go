@@ -0,0 +1,36 @@
+// Package controller provides HTTP handlers for standby exports of the panel.
+package controller
+
+import (
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// StandbyController handles snapshots of the panel database exported for disaster recovery.
+type StandbyController struct {
+	standbyExport *service.StandbyExportService
+}
+
+// NewStandbyController creates a new controller instance.
+func NewStandbyController() *StandbyController {
+	return &StandbyController{
+		standbyExport: &service.StandbyExportService{},
+	}
+}
+
+// GetStatus returns the target and the outcome of the last standby export.
+// GET /panel/api/standby
+func (c *StandbyController) GetStatus(ctx *gin.Context) {
+	jsonObj(ctx, c.standbyExport.GetStatus(), nil)
+}
+
+// Export writes a snapshot to the standby target now, even if the data did not change.
+// POST /panel/api/standby/export
+func (c *StandbyController) Export(ctx *gin.Context) {
+	status, err := c.standbyExport.Export(ctx.Request.Context(), true)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.standbyExportFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.standbyExported"), status, nil)
+}
@@ -0,0 +1,65 @@
+// Package controller provides HTTP handlers for trial clients.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// TrialController handles trial clients created in one call and removed once they lapse.
+type TrialController struct {
+	trials *service.TrialService
+}
+
+// NewTrialController creates a new controller instance.
+func NewTrialController() *TrialController {
+	return &TrialController{
+		trials: &service.TrialService{},
+	}
+}
+
+// ListTrials returns all trial clients, newest first.
+// GET /panel/api/trials
+func (c *TrialController) ListTrials(ctx *gin.Context) {
+	trials, err := c.trials.GetTrials()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getTrialsFailed"), err)
+		return
+	}
+	jsonObj(ctx, trials, nil)
+}
+
+// CreateTrial creates a trial client and returns its subscription link and QR code.
+// POST /panel/api/trials
+// Body: {"serverId": 2} or {"pool": "eu"}, optionally with "inboundId" (serverId only),
+// "inboundTag", "email", "hours" (default 24), "total" (bytes, default 1 GiB), "limitIp"
+func (c *TrialController) CreateTrial(ctx *gin.Context) {
+	req := &service.TrialRequest{}
+	if err := ctx.ShouldBind(req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidTrial"), err)
+		return
+	}
+	trial, err := c.trials.CreateTrial(ctx.Request.Context(), req)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.createTrialFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.trialCreated"), trial, nil)
+}
+
+// EndTrial removes a trial client from its server before it lapses.
+// DELETE /panel/api/trials/:id
+func (c *TrialController) EndTrial(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidTrialId"), err)
+		return
+	}
+	if err := c.trials.EndTrial(ctx.Request.Context(), id); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.endTrialFailed"), err)
+		return
+	}
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.trialEnded"), nil)
+}
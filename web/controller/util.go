@@ -8,26 +8,62 @@ import (
 	"github.com/cofedish/3x-UI-agents/config"
 	"github.com/cofedish/3x-UI-agents/logger"
 	"github.com/cofedish/3x-UI-agents/web/entity"
+	"github.com/cofedish/3x-UI-agents/web/service"
 
 	"github.com/gin-gonic/gin"
 )
 
-// getRemoteIp extracts the real IP address from the request headers or remote address.
+// getRemoteIp returns the client IP address of a request. X-Real-IP and X-Forwarded-For
+// are only honoured on connections from a trusted proxy (the trustedProxies setting,
+// loopback by default), since anyone else can set them to any address.
 func getRemoteIp(c *gin.Context) string {
-	value := c.GetHeader("X-Real-IP")
-	if value != "" {
+	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		ip = c.Request.RemoteAddr
+	}
+	settingService := service.SettingService{}
+	proxies, err := settingService.GetTrustedProxies()
+	if err != nil {
+		logger.Warning("Invalid trusted proxies:", err)
+		return ip
+	}
+	if !inNetworks(ip, proxies) {
+		return ip
+	}
+
+	if value := strings.TrimSpace(c.GetHeader("X-Real-IP")); net.ParseIP(value) != nil {
 		return value
 	}
-	value = c.GetHeader("X-Forwarded-For")
-	if value != "" {
-		ips := strings.Split(value, ",")
-		return ips[0]
+	// Proxies append the address they received the request from, so the client is the
+	// last address not added by a trusted proxy
+	forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		value := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(value) == nil {
+			break
+		}
+		ip = value
+		if !inNetworks(value, proxies) {
+			break
+		}
 	}
-	addr := c.Request.RemoteAddr
-	ip, _, _ := net.SplitHostPort(addr)
 	return ip
 }
 
+// inNetworks reports whether ip is in one of networks.
+func inNetworks(ip string, networks []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 // jsonMsg sends a JSON response with a message and error status.
 func jsonMsg(c *gin.Context, msg string, err error) {
 	jsonMsgObj(c, msg, nil, err)
@@ -56,6 +92,21 @@ func jsonMsgObj(c *gin.Context, msg string, obj any, err error) {
 	c.JSON(http.StatusOK, m)
 }
 
+// jsonInvalid sends a 400 response for a payload that failed binding or validation.
+// Obj lists the invalid fields so the UI can point at them.
+func jsonInvalid(c *gin.Context, msg string, err error) {
+	fields := service.ValidationFields(err)
+	if fields == nil {
+		fields = service.NewValidationError(err).Fields
+	}
+	logger.Warning(msg+" "+I18nWeb(c, "fail")+": ", err)
+	c.JSON(http.StatusBadRequest, entity.Msg{
+		Success: false,
+		Msg:     msg + " (" + err.Error() + ")",
+		Obj:     fields,
+	})
+}
+
 // pureJsonMsg sends a pure JSON message response with custom status code.
 func pureJsonMsg(c *gin.Context, statusCode int, success bool, msg string) {
 	c.JSON(statusCode, entity.Msg{
@@ -102,3 +153,9 @@ func getContext(h gin.H) gin.H {
 func isAjax(c *gin.Context) bool {
 	return c.GetHeader("X-Requested-With") == "XMLHttpRequest"
 }
+
+// serverFilterFromQuery reads the server label filter of listings that span servers:
+// ?tag= (comma-separated, any matches), ?region= and ?scope= (saved scope ID or name).
+func serverFilterFromQuery(c *gin.Context) service.ServerFilter {
+	return service.ParseServerFilter(c.Query("tag"), c.Query("region"), c.Query("scope"))
+}
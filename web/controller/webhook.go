@@ -0,0 +1,97 @@
+// Package controller provides HTTP handlers for outgoing webhooks.
+package controller
+
+import (
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookController handles the outgoing webhooks panel events are sent to.
+type WebhookController struct {
+	webhooks *service.WebhookService
+}
+
+// NewWebhookController creates a new controller instance.
+func NewWebhookController() *WebhookController {
+	return &WebhookController{
+		webhooks: &service.WebhookService{},
+	}
+}
+
+// ListWebhooks returns all webhooks with the outcome of their last delivery.
+// GET /panel/api/webhooks
+func (c *WebhookController) ListWebhooks(ctx *gin.Context) {
+	webhooks, err := c.webhooks.GetWebhooks()
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getWebhooksFailed"), err)
+		return
+	}
+	jsonObj(ctx, webhooks, nil)
+}
+
+// AddWebhook creates a webhook. Without a secret a random one is generated.
+// POST /panel/api/webhooks
+// Body: {"name": "ops", "url": "https://example.com/hook", "secret": "",
+// "events": "server.offline,server.online", "enable": true}
+func (c *WebhookController) AddWebhook(ctx *gin.Context) {
+	webhook := &model.Webhook{}
+	if err := ctx.ShouldBind(webhook); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidWebhook"), err)
+		return
+	}
+	if err := c.webhooks.AddWebhook(webhook); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addWebhookFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.webhookAdded"), webhook, nil)
+}
+
+// UpdateWebhook replaces a webhook. An empty secret keeps the current one.
+// PUT /panel/api/webhooks/:id
+func (c *WebhookController) UpdateWebhook(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidWebhookId"), err)
+		return
+	}
+
+	webhook := &model.Webhook{}
+	if err := ctx.ShouldBind(webhook); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidWebhook"), err)
+		return
+	}
+	webhook.Id = id
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.webhookUpdated"), c.webhooks.UpdateWebhook(webhook))
+}
+
+// DeleteWebhook removes a webhook.
+// DELETE /panel/api/webhooks/:id
+func (c *WebhookController) DeleteWebhook(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidWebhookId"), err)
+		return
+	}
+
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.webhookDeleted"), c.webhooks.DeleteWebhook(id))
+}
+
+// TestWebhook sends a signed test event to a webhook and reports whether it was accepted.
+// POST /panel/api/webhooks/:id/test
+func (c *WebhookController) TestWebhook(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidWebhookId"), err)
+		return
+	}
+
+	if err := c.webhooks.TestWebhook(ctx.Request.Context(), id); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.webhookTestFailed"), err)
+		return
+	}
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.webhookTestSent"), nil)
+}
@@ -0,0 +1,129 @@
+// Package controller provides HTTP handlers for WireGuard peer management.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// WireguardController handles the peers of WireGuard inbounds on any server.
+type WireguardController struct {
+	wireguard *service.WireguardService
+}
+
+// NewWireguardController creates a new controller instance.
+func NewWireguardController() *WireguardController {
+	return &WireguardController{
+		wireguard: &service.WireguardService{},
+	}
+}
+
+// parseIds reads the server and inbound IDs of a peer route.
+func (c *WireguardController) parseIds(ctx *gin.Context) (int, int, bool) {
+	serverId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidServerId"), err)
+		return 0, 0, false
+	}
+	inboundId, err := strconv.Atoi(ctx.Param("inboundId"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidInboundId"), err)
+		return 0, 0, false
+	}
+	return serverId, inboundId, true
+}
+
+// ListPeers returns the peers of a WireGuard inbound with their traffic.
+// GET /panel/api/servers/:id/wireguard/:inboundId/peers
+func (c *WireguardController) ListPeers(ctx *gin.Context) {
+	serverId, inboundId, ok := c.parseIds(ctx)
+	if !ok {
+		return
+	}
+	peers, err := c.wireguard.ListPeers(ctx.Request.Context(), serverId, inboundId)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getPeersFailed"), err)
+		return
+	}
+	jsonObj(ctx, peers, nil)
+}
+
+// AddPeer creates a peer with new keys and the next free tunnel address.
+// POST /panel/api/servers/:id/wireguard/:inboundId/peers
+// Body: {"email": "alice"}
+func (c *WireguardController) AddPeer(ctx *gin.Context) {
+	serverId, inboundId, ok := c.parseIds(ctx)
+	if !ok {
+		return
+	}
+	var req struct {
+		Email string `json:"email" form:"email"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.invalidPeerData"), err)
+		return
+	}
+
+	peer, err := c.wireguard.AddPeer(ctx.Request.Context(), serverId, inboundId, req.Email)
+	if err != nil {
+		logger.Warning("Failed to add WireGuard peer:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.addPeerFailed"), err)
+		return
+	}
+	jsonMsgObj(ctx, I18nWeb(ctx, "pages.servers.toasts.peerAdded"), peer, nil)
+}
+
+// DeletePeer removes a peer.
+// DELETE /panel/api/servers/:id/wireguard/:inboundId/peers/:email
+func (c *WireguardController) DeletePeer(ctx *gin.Context) {
+	serverId, inboundId, ok := c.parseIds(ctx)
+	if !ok {
+		return
+	}
+	if err := c.wireguard.DeletePeer(ctx.Request.Context(), serverId, inboundId, ctx.Param("email")); err != nil {
+		logger.Warning("Failed to delete WireGuard peer:", err)
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.deletePeerFailed"), err)
+		return
+	}
+	jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.peerDeleted"), nil)
+}
+
+// GetPeerConfig returns the wg-quick configuration of a peer.
+// GET /panel/api/servers/:id/wireguard/:inboundId/peers/:email/config
+func (c *WireguardController) GetPeerConfig(ctx *gin.Context) {
+	serverId, inboundId, ok := c.parseIds(ctx)
+	if !ok {
+		return
+	}
+	config, err := c.wireguard.GetPeerConfig(ctx.Request.Context(), serverId, inboundId, ctx.Param("email"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getPeerConfigFailed"), err)
+		return
+	}
+	jsonObj(ctx, config, nil)
+}
+
+// GetPeerQR returns the configuration of a peer as a PNG QR code for mobile clients.
+// GET /panel/api/servers/:id/wireguard/:inboundId/peers/:email/qr
+func (c *WireguardController) GetPeerQR(ctx *gin.Context) {
+	serverId, inboundId, ok := c.parseIds(ctx)
+	if !ok {
+		return
+	}
+	config, err := c.wireguard.GetPeerConfig(ctx.Request.Context(), serverId, inboundId, ctx.Param("email"))
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.getPeerConfigFailed"), err)
+		return
+	}
+	png, err := qrcode.Encode(config, qrcode.Medium, 320)
+	if err != nil {
+		jsonMsg(ctx, I18nWeb(ctx, "pages.servers.toasts.generateQrFailed"), err)
+		return
+	}
+	ctx.Data(http.StatusOK, "image/png", png)
+}
@@ -3,8 +3,12 @@ package entity
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"math"
 	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +32,8 @@ type AllSetting struct {
 	WebKeyFile    string `json:"webKeyFile" form:"webKeyFile"`       // Path to SSL private key file for web server
 	WebBasePath   string `json:"webBasePath" form:"webBasePath"`     // Base path for web panel URLs
 	SessionMaxAge int    `json:"sessionMaxAge" form:"sessionMaxAge"` // Session maximum age in minutes
+	// Reverse proxies whose X-Real-IP and X-Forwarded-For headers are trusted, comma-separated
+	TrustedProxies string `json:"trustedProxies" form:"trustedProxies"`
 
 	// UI settings
 	PageSize    int    `json:"pageSize" form:"pageSize"`       // Number of items per page in lists
@@ -37,16 +43,17 @@ type AllSetting struct {
 	Datepicker  string `json:"datepicker" form:"datepicker"`   // Date picker format
 
 	// Telegram bot settings
-	TgBotEnable      bool   `json:"tgBotEnable" form:"tgBotEnable"`           // Enable Telegram bot notifications
-	TgBotToken       string `json:"tgBotToken" form:"tgBotToken"`             // Telegram bot token
-	TgBotProxy       string `json:"tgBotProxy" form:"tgBotProxy"`             // Proxy URL for Telegram bot
-	TgBotAPIServer   string `json:"tgBotAPIServer" form:"tgBotAPIServer"`     // Custom API server for Telegram bot
-	TgBotChatId      string `json:"tgBotChatId" form:"tgBotChatId"`           // Telegram chat ID for notifications
-	TgRunTime        string `json:"tgRunTime" form:"tgRunTime"`               // Cron schedule for Telegram notifications
-	TgBotBackup      bool   `json:"tgBotBackup" form:"tgBotBackup"`           // Enable database backup via Telegram
-	TgBotLoginNotify bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"` // Send login notifications
-	TgCpu            int    `json:"tgCpu" form:"tgCpu"`                       // CPU usage threshold for alerts
-	TgLang           string `json:"tgLang" form:"tgLang"`                     // Telegram bot language
+	TgBotEnable         bool   `json:"tgBotEnable" form:"tgBotEnable"`                 // Enable Telegram bot notifications
+	TgBotToken          string `json:"tgBotToken" form:"tgBotToken"`                   // Telegram bot token
+	TgBotProxy          string `json:"tgBotProxy" form:"tgBotProxy"`                   // Proxy URL for Telegram bot
+	TgBotAPIServer      string `json:"tgBotAPIServer" form:"tgBotAPIServer"`           // Custom API server for Telegram bot
+	TgBotChatId         string `json:"tgBotChatId" form:"tgBotChatId"`                 // Telegram chat ID for notifications
+	TgRunTime           string `json:"tgRunTime" form:"tgRunTime"`                     // Cron schedule for Telegram notifications
+	TgBotBackup         bool   `json:"tgBotBackup" form:"tgBotBackup"`                 // Enable database backup via Telegram
+	TgBotLoginNotify    bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"`       // Send login notifications
+	TgBotIncidentNotify bool   `json:"tgBotIncidentNotify" form:"tgBotIncidentNotify"` // Send server incident notifications
+	TgCpu               int    `json:"tgCpu" form:"tgCpu"`                             // CPU usage threshold for alerts
+	TgLang              string `json:"tgLang" form:"tgLang"`                           // Telegram bot language
 
 	// Security settings
 	TimeLocation    string `json:"timeLocation" form:"timeLocation"`       // Time zone location
@@ -99,6 +106,94 @@ type AllSetting struct {
 	LdapDefaultExpiryDays int    `json:"ldapDefaultExpiryDays" form:"ldapDefaultExpiryDays"`
 	LdapDefaultLimitIP    int    `json:"ldapDefaultLimitIP" form:"ldapDefaultLimitIP"`
 	// JSON subscription routing rules
+
+	// Multi-server settings
+	IpLimitGlobalEnable bool   `json:"ipLimitGlobalEnable" form:"ipLimitGlobalEnable"` // Enforce client IP limits across all servers
+	IpLimitGlobalAction string `json:"ipLimitGlobalAction" form:"ipLimitGlobalAction"` // Action on violation: "alert" or "disable"
+
+	// Xray core releases
+	XrayReleaseChannel string `json:"xrayReleaseChannel" form:"xrayReleaseChannel"` // "stable" or "prerelease"
+	XrayMirror         string `json:"xrayMirror" form:"xrayMirror"`                 // Release download base URL, empty = GitHub
+
+	// Agent releases
+	AgentReleaseUrl string `json:"agentReleaseUrl" form:"agentReleaseUrl"` // Archive URL with {version} and {arch}, empty = GitHub
+
+	// Scheduled geo file updates
+	GeoUpdateCron    string `json:"geoUpdateCron" form:"geoUpdateCron"`       // Cron spec, empty = disabled
+	GeoUpdateStagger int    `json:"geoUpdateStagger" form:"geoUpdateStagger"` // Seconds between servers
+
+	// Self-service renewals
+	RenewalWebhookSecret string `json:"renewalWebhookSecret" form:"renewalWebhookSecret"` // HMAC secret for payment webhooks, empty = disabled
+
+	// Traffic anomaly alerts
+	TrafficAnomalyEnable    bool `json:"trafficAnomalyEnable" form:"trafficAnomalyEnable"`       // Alert admins on traffic spikes and drops
+	TrafficAnomalyThreshold int  `json:"trafficAnomalyThreshold" form:"trafficAnomalyThreshold"` // z-score that counts as an anomaly
+
+	// Stale client cleanup
+	StaleClientDays   int    `json:"staleClientDays" form:"staleClientDays"`     // Days a client is expired or depleted before cleanup, 0 = disabled
+	StaleClientAction string `json:"staleClientAction" form:"staleClientAction"` // "report" (dry run), "disable" or "delete"
+
+	// Standby export for disaster recovery
+	StandbyExportTarget   string `json:"standbyExportTarget" form:"standbyExportTarget"`     // Absolute directory path or https URL to PUT snapshots to, empty = disabled
+	StandbyExportToken    string `json:"standbyExportToken" form:"standbyExportToken"`       // Bearer token sent to an https target
+	StandbyExportInterval int    `json:"standbyExportInterval" form:"standbyExportInterval"` // Minutes between exports
+	StandbyExportKeep     int    `json:"standbyExportKeep" form:"standbyExportKeep"`         // Snapshots kept in a directory target
+
+	// Alert notification channels
+	AlertWebhookUrl   string `json:"alertWebhookUrl" form:"alertWebhookUrl"`     // http(s) URL alerts are POSTed to as JSON, empty = disabled
+	AlertSmtpHost     string `json:"alertSmtpHost" form:"alertSmtpHost"`         // SMTP server for alert emails, empty = disabled
+	AlertSmtpPort     int    `json:"alertSmtpPort" form:"alertSmtpPort"`         // 587 (STARTTLS) or 465 (TLS)
+	AlertSmtpUsername string `json:"alertSmtpUsername" form:"alertSmtpUsername"` // Empty = no SMTP authentication
+	AlertSmtpPassword string `json:"alertSmtpPassword" form:"alertSmtpPassword"`
+	AlertEmailFrom    string `json:"alertEmailFrom" form:"alertEmailFrom"`
+	AlertEmailTo      string `json:"alertEmailTo" form:"alertEmailTo"` // Comma-separated recipients
+
+	// Outgoing webhooks
+	WebhookCertExpiryDays int `json:"webhookCertExpiryDays" form:"webhookCertExpiryDays"` // Days before expiry the cert.expiring event is sent
+
+	// Server metric history
+	MetricHistoryRawDays int `json:"metricHistoryRawDays" form:"metricHistoryRawDays"` // Days raw samples are kept before hourly downsampling
+	MetricHistoryDays    int `json:"metricHistoryDays" form:"metricHistoryDays"`       // Days hourly averages are kept
+
+	// Panel status refresh, applied after a panel restart
+	StatusInterval  int `json:"statusInterval" form:"statusInterval"`   // Seconds between status refreshes, also the dashboard's polling interval
+	CpuHistoryHours int `json:"cpuHistoryHours" form:"cpuHistoryHours"` // Hours of CPU samples kept in memory for the real-time chart
+
+	// Warning flags in inbound and client listings
+	WarnQuotaPercent int  `json:"warnQuotaPercent" form:"warnQuotaPercent"` // Percent of the traffic quota used that flags nearQuota, negative = disabled
+	WarnExpiryDays   int  `json:"warnExpiryDays" form:"warnExpiryDays"`     // Days before expiry that flag expiringSoon, negative = disabled
+	PortProbeEnabled bool `json:"portProbeEnabled" form:"portProbeEnabled"` // Probe inbound ports from the panel to flag portUnreachable
+
+	// Prometheus metrics
+	MetricsToken string `json:"metricsToken" form:"metricsToken"` // Bearer token for scraping /panel/metrics, empty = panel session only
+
+	// CORS and security headers
+	CorsAllowedOrigins    string `json:"corsAllowedOrigins" form:"corsAllowedOrigins"`       // Comma-separated origins, "*" = any, empty = no CORS
+	HstsMaxAge            int    `json:"hstsMaxAge" form:"hstsMaxAge"`                       // Strict-Transport-Security max-age, negative = disabled
+	ContentSecurityPolicy string `json:"contentSecurityPolicy" form:"contentSecurityPolicy"` // Panel CSP, empty = default, "off" = disabled
+
+	// Login brute-force protection
+	LoginMaxFailuresPerIp   int `json:"loginMaxFailuresPerIp" form:"loginMaxFailuresPerIp"`     // Failed logins before an IP is locked out, negative = disabled
+	LoginMaxFailuresPerUser int `json:"loginMaxFailuresPerUser" form:"loginMaxFailuresPerUser"` // Failed logins before a username is locked out, negative = disabled
+	LoginLockoutMinutes     int `json:"loginLockoutMinutes" form:"loginLockoutMinutes"`         // Lockout duration and failure counting window
+	LoginCaptchaAfter       int `json:"loginCaptchaAfter" form:"loginCaptchaAfter"`             // Failed logins before a captcha is required, negative = never
+
+	// Agent clock drift
+	ClockSkewThreshold int `json:"clockSkewThreshold" form:"clockSkewThreshold"` // Seconds of drift that flag a server, negative = disabled
+
+	// Agent JWT secret rotation
+	JwtRotationDays int `json:"jwtRotationDays" form:"jwtRotationDays"` // Days between automatic rotations, negative = disabled
+
+	// Read-only mode
+	ReadOnly bool `json:"readOnly" form:"readOnly"` // Block all changes; XUI_READ_ONLY=true forces it on
+
+	// Settings that can be pushed to all servers
+	XrayLogLevel           string `json:"xrayLogLevel" form:"xrayLogLevel"`                     // Overrides the template log level, empty = template
+	XrayDnsConfig          string `json:"xrayDnsConfig" form:"xrayDnsConfig"`                   // JSON object replacing the template "dns" section, empty = template
+	XrayPolicyLevels       string `json:"xrayPolicyLevels" form:"xrayPolicyLevels"`             // JSON object of policy level to preset, merged into the template "policy" levels
+	GeoSources             string `json:"geoSources" form:"geoSources"`                         // JSON object of geo file name to download URL
+	TrafficCollectInterval int    `json:"trafficCollectInterval" form:"trafficCollectInterval"` // Seconds between agent traffic flushes, 0 = only on GET /api/v1/traffic
+	PublicIpResolver       string `json:"publicIpResolver" form:"publicIpResolver"`             // URL answering with the caller's address, empty = built-in, "off" = interfaces only
 }
 
 // CheckValid validates all settings in the AllSetting struct, checking IP addresses, ports, SSL certificates, and other configuration values.
@@ -143,6 +238,10 @@ func (s *AllSetting) CheckValid() error {
 		}
 	}
 
+	if _, err := ParseNetworks(s.TrustedProxies); err != nil {
+		return common.NewError("trusted proxies:", err)
+	}
+
 	if !strings.HasPrefix(s.WebBasePath, "/") {
 		s.WebBasePath = "/" + s.WebBasePath
 	}
@@ -168,5 +267,289 @@ func (s *AllSetting) CheckValid() error {
 		return common.NewError("time location not exist:", s.TimeLocation)
 	}
 
+	if s.IpLimitGlobalAction == "" {
+		s.IpLimitGlobalAction = "alert"
+	}
+	if s.IpLimitGlobalAction != "alert" && s.IpLimitGlobalAction != "disable" {
+		return common.NewError("global IP limit action must be alert or disable:", s.IpLimitGlobalAction)
+	}
+
+	if s.StaleClientDays < 0 {
+		return common.NewError("stale client days must not be negative:", s.StaleClientDays)
+	}
+	if s.StaleClientAction == "" {
+		s.StaleClientAction = "report"
+	}
+	if s.StaleClientAction != "report" && s.StaleClientAction != "disable" && s.StaleClientAction != "delete" {
+		return common.NewError("stale client action must be report, disable or delete:", s.StaleClientAction)
+	}
+
+	// Snapshots carry credentials, so they are never sent in the clear
+	if s.StandbyExportTarget != "" && !filepath.IsAbs(s.StandbyExportTarget) && !strings.HasPrefix(s.StandbyExportTarget, "https://") {
+		return common.NewError("standby export target must be an absolute path or an https URL:", s.StandbyExportTarget)
+	}
+	if s.StandbyExportInterval == 0 {
+		s.StandbyExportInterval = 15
+	}
+	if s.StandbyExportKeep == 0 {
+		s.StandbyExportKeep = 48
+	}
+	if s.StandbyExportInterval < 1 {
+		return common.NewError("standby export interval must be at least 1 minute:", s.StandbyExportInterval)
+	}
+	if s.StandbyExportKeep < 1 {
+		return common.NewError("standby export must keep at least 1 snapshot:", s.StandbyExportKeep)
+	}
+
+	if s.AlertWebhookUrl != "" && !strings.HasPrefix(s.AlertWebhookUrl, "https://") && !strings.HasPrefix(s.AlertWebhookUrl, "http://") {
+		return common.NewError("alert webhook must be an http(s) URL:", s.AlertWebhookUrl)
+	}
+	if s.AlertSmtpPort == 0 {
+		s.AlertSmtpPort = 587
+	}
+	if s.AlertSmtpPort < 1 || s.AlertSmtpPort > 65535 {
+		return common.NewError("alert SMTP port is not a valid port:", s.AlertSmtpPort)
+	}
+	if s.AlertSmtpHost != "" && (s.AlertEmailFrom == "" || s.AlertEmailTo == "") {
+		return common.NewError("alert emails need a sender and recipients")
+	}
+
+	if s.WebhookCertExpiryDays == 0 {
+		s.WebhookCertExpiryDays = 14
+	}
+	if s.WebhookCertExpiryDays < 1 || s.WebhookCertExpiryDays > 365 {
+		return common.NewError("webhook certificate expiry days must be between 1 and 365:", s.WebhookCertExpiryDays)
+	}
+
+	if s.MetricHistoryRawDays == 0 {
+		s.MetricHistoryRawDays = 7
+	}
+	if s.MetricHistoryDays == 0 {
+		s.MetricHistoryDays = 180
+	}
+	if s.MetricHistoryRawDays < 1 || s.MetricHistoryRawDays > 90 {
+		return common.NewError("raw metric history days must be between 1 and 90:", s.MetricHistoryRawDays)
+	}
+	if s.MetricHistoryDays < s.MetricHistoryRawDays || s.MetricHistoryDays > 3650 {
+		return common.NewError("metric history days must be between the raw history days and 3650:", s.MetricHistoryDays)
+	}
+
+	if s.StatusInterval == 0 {
+		s.StatusInterval = 2
+	}
+	if s.CpuHistoryHours == 0 {
+		s.CpuHistoryHours = 6
+	}
+	if s.StatusInterval < 1 || s.StatusInterval > 60 {
+		return common.NewError("status interval must be between 1 and 60 seconds:", s.StatusInterval)
+	}
+	if s.CpuHistoryHours < 1 || s.CpuHistoryHours > 48 {
+		return common.NewError("CPU history hours must be between 1 and 48:", s.CpuHistoryHours)
+	}
+
+	if s.WarnQuotaPercent == 0 {
+		s.WarnQuotaPercent = 90
+	}
+	if s.WarnQuotaPercent > 100 {
+		return common.NewError("quota warning percent must be at most 100:", s.WarnQuotaPercent)
+	}
+	if s.WarnExpiryDays > 365 {
+		return common.NewError("expiry warning days must be at most 365:", s.WarnExpiryDays)
+	}
+
+	if s.XrayReleaseChannel == "" {
+		s.XrayReleaseChannel = "stable"
+	}
+	if s.XrayReleaseChannel != "stable" && s.XrayReleaseChannel != "prerelease" {
+		return common.NewError("Xray release channel must be stable or prerelease:", s.XrayReleaseChannel)
+	}
+	if s.GeoUpdateStagger < 0 {
+		return common.NewError("geo update stagger must not be negative:", s.GeoUpdateStagger)
+	}
+	if s.TrafficAnomalyThreshold == 0 {
+		s.TrafficAnomalyThreshold = 4
+	}
+	if s.TrafficAnomalyThreshold < 2 {
+		return common.NewError("traffic anomaly threshold must be at least 2:", s.TrafficAnomalyThreshold)
+	}
+
+	if s.HstsMaxAge == 0 {
+		s.HstsMaxAge = 31536000
+	}
+	if s.ClockSkewThreshold == 0 {
+		s.ClockSkewThreshold = 30
+	}
+	if s.JwtRotationDays == 0 {
+		s.JwtRotationDays = 30
+	}
+	if s.LoginMaxFailuresPerIp == 0 {
+		s.LoginMaxFailuresPerIp = 5
+	}
+	if s.LoginMaxFailuresPerUser == 0 {
+		s.LoginMaxFailuresPerUser = 10
+	}
+	if s.LoginCaptchaAfter == 0 {
+		s.LoginCaptchaAfter = 3
+	}
+	if s.LoginLockoutMinutes == 0 {
+		s.LoginLockoutMinutes = 15
+	}
+	if s.LoginLockoutMinutes < 1 {
+		return common.NewError("login lockout must be at least 1 minute:", s.LoginLockoutMinutes)
+	}
+	for _, origin := range strings.Split(s.CorsAllowedOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" || origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return common.NewError("CORS origin must be scheme://host[:port]:", origin)
+		}
+	}
+
+	for key, value := range map[string]string{
+		"xrayLogLevel":           s.XrayLogLevel,
+		"xrayDnsConfig":          s.XrayDnsConfig,
+		"xrayPolicyLevels":       s.XrayPolicyLevels,
+		"geoSources":             s.GeoSources,
+		"trafficCollectInterval": strconv.Itoa(s.TrafficCollectInterval),
+		"publicIpResolver":       s.PublicIpResolver,
+	} {
+		if err := ValidateFleetSetting(key, value); err != nil {
+			return err
+		}
+	}
+
+	if s.XrayMirror != "" {
+		if u, err := url.Parse(s.XrayMirror); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return common.NewError("Xray mirror must be an http(s) URL:", s.XrayMirror)
+		}
+	}
+
+	if s.AgentReleaseUrl != "" {
+		// Agents only download releases over https
+		if u, err := url.Parse(s.AgentReleaseUrl); err != nil || u.Scheme != "https" || u.Host == "" {
+			return common.NewError("Agent release URL must be an https URL:", s.AgentReleaseUrl)
+		}
+	}
+
+	return nil
+}
+
+// validatePolicyLevel checks a policy preset: a name and the fields of an Xray policy level.
+func validatePolicyLevel(policy map[string]any) error {
+	for field, value := range policy {
+		switch field {
+		case "name":
+			if _, ok := value.(string); !ok {
+				return common.NewErrorf("name must be a string")
+			}
+		case "handshake", "connIdle", "uplinkOnly", "downlinkOnly", "bufferSize":
+			if number, ok := value.(float64); !ok || number < 0 || number != float64(int32(number)) {
+				return common.NewErrorf("%s must be a non-negative whole number", field)
+			}
+		case "statsUserUplink", "statsUserDownlink", "statsUserOnline":
+			if _, ok := value.(bool); !ok {
+				return common.NewErrorf("%s must be true or false", field)
+			}
+		default:
+			return common.NewErrorf("unknown policy field %q", field)
+		}
+	}
+	return nil
+}
+
+// ParseNetworks parses comma-separated networks such as "127.0.0.1,10.0.0.0/8". Bare
+// addresses are taken as single hosts.
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, network := range strings.Split(value, ",") {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, common.NewError("invalid network:", network)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, common.NewError("invalid network:", network)
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
+
+// ValidateFleetSetting checks the value of a setting that can be pushed to all servers,
+// so the panel and the agents reject the same values.
+func ValidateFleetSetting(key, value string) error {
+	switch key {
+	case "xrayLogLevel":
+		switch value {
+		case "", "debug", "info", "warning", "error", "none":
+			return nil
+		}
+		return common.NewError("Xray log level must be debug, info, warning, error or none:", value)
+	case "xrayDnsConfig":
+		if value == "" {
+			return nil
+		}
+		var dns map[string]any
+		if err := json.Unmarshal([]byte(value), &dns); err != nil {
+			return common.NewError("Xray DNS config must be a JSON object:", err)
+		}
+	case "xrayPolicyLevels":
+		if value == "" {
+			return nil
+		}
+		var levels map[string]map[string]any
+		if err := json.Unmarshal([]byte(value), &levels); err != nil {
+			return common.NewError("Xray policy levels must be a JSON object of level to policy:", err)
+		}
+		for level, policy := range levels {
+			if _, err := strconv.ParseUint(level, 10, 32); err != nil {
+				return common.NewError("Xray policy level must be a non-negative number:", level)
+			}
+			if err := validatePolicyLevel(policy); err != nil {
+				return common.NewErrorf("Xray policy level %s: %v", level, err)
+			}
+		}
+	case "geoSources":
+		if value == "" {
+			return nil
+		}
+		var sources map[string]string
+		if err := json.Unmarshal([]byte(value), &sources); err != nil {
+			return common.NewError("geo sources must be a JSON object of file name to URL:", err)
+		}
+		for file, source := range sources {
+			if u, err := url.Parse(source); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return common.NewError("geo source of "+file+" must be an http(s) URL:", source)
+			}
+		}
+	case "trafficCollectInterval":
+		interval, err := strconv.Atoi(value)
+		if err != nil || interval < 0 {
+			return common.NewError("traffic collection interval must be a non-negative number of seconds:", value)
+		}
+	case "publicIpResolver":
+		if value == "" || value == "off" {
+			return nil
+		}
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return common.NewError("public IP resolver must be an http(s) URL or off:", value)
+		}
+	default:
+		return common.NewError("setting cannot be pushed to servers:", key)
+	}
 	return nil
 }
@@ -0,0 +1,31 @@
+// Package job provides AlertJob for evaluating alert rules.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// AlertJob evaluates the enabled alert rules and notifies admins about alerts that
+// fired or resolved.
+type AlertJob struct {
+	alertService service.AlertService
+}
+
+// NewAlertJob creates a new alert evaluation job.
+func NewAlertJob() *AlertJob {
+	return new(AlertJob)
+}
+
+// Run evaluates all enabled alert rules once.
+func (j *AlertJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := j.alertService.Evaluate(ctx); err != nil {
+		logger.Warning("Alert evaluation failed:", err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package job provides BlockedIPJob for expiring fleet-wide blocklist entries.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// BlockedIPJob removes expired blocklist entries and pushes the updated list to all servers.
+type BlockedIPJob struct {
+	blockedIPs service.BlockedIPService
+}
+
+// NewBlockedIPJob creates a new blocklist expiry job.
+func NewBlockedIPJob() *BlockedIPJob {
+	return new(BlockedIPJob)
+}
+
+// Run deletes expired entries and re-syncs servers when anything expired.
+func (j *BlockedIPJob) Run() {
+	removed, err := j.blockedIPs.DeleteExpired()
+	if err != nil {
+		logger.Warning("Failed to delete expired blocklist entries:", err)
+		return
+	}
+	if removed == 0 {
+		return
+	}
+
+	logger.Infof("Removed %d expired blocklist entries", removed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := j.blockedIPs.PushBlockedIPs(ctx); err != nil {
+		logger.Warning("Failed to push blocklist to some servers:", err)
+	}
+}
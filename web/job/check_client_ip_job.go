@@ -15,6 +15,7 @@ import (
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
 	"github.com/cofedish/3x-UI-agents/xray"
 )
 
@@ -22,6 +23,7 @@ import (
 type CheckClientIpJob struct {
 	lastClear     int64
 	disAllowedIps []string
+	serverMgmt    service.ServerManagementService
 }
 
 var job *CheckClientIpJob
@@ -206,7 +208,7 @@ func (j *CheckClientIpJob) checkError(e error) {
 func (j *CheckClientIpJob) getInboundClientIps(clientEmail string) (*model.InboundClientIps, error) {
 	db := database.GetDB()
 	InboundClientIps := &model.InboundClientIps{}
-	err := db.Model(model.InboundClientIps{}).Where("client_email = ?", clientEmail).First(InboundClientIps).Error
+	err := db.Model(model.InboundClientIps{}).Where("client_email = ? AND server_id = ?", clientEmail, j.serverMgmt.GetLocalServerId()).First(InboundClientIps).Error
 	if err != nil {
 		return nil, err
 	}
@@ -218,6 +220,7 @@ func (j *CheckClientIpJob) addInboundClientIps(clientEmail string, ips []string)
 	jsonIps, err := json.Marshal(ips)
 	j.checkError(err)
 
+	inboundClientIps.ServerId = j.serverMgmt.GetLocalServerId()
 	inboundClientIps.ClientEmail = clientEmail
 	inboundClientIps.Ips = string(jsonIps)
 
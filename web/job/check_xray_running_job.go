@@ -9,8 +9,9 @@ import (
 
 // CheckXrayRunningJob monitors Xray process health and restarts it if it crashes.
 type CheckXrayRunningJob struct {
-	xrayService service.XrayService
-	checkTime   int
+	xrayService    service.XrayService
+	webhookService service.WebhookService
+	checkTime      int
 }
 
 // NewCheckXrayRunningJob creates a new Xray health check job instance.
@@ -26,11 +27,18 @@ func (j *CheckXrayRunningJob) Run() {
 		j.checkTime++
 		// only restart if it's down 2 times in a row
 		if j.checkTime > 1 {
+			crash := map[string]any{"result": j.xrayService.GetXrayResult()}
+			if xrayErr := j.xrayService.GetXrayErr(); xrayErr != nil {
+				crash["error"] = xrayErr.Error()
+			}
 			err := j.xrayService.RestartXray(false)
 			j.checkTime = 0
 			if err != nil {
 				logger.Error("Restart xray failed:", err)
+				crash["restartError"] = err.Error()
 			}
+			crash["restarted"] = err == nil
+			j.webhookService.Emit(service.WebhookXrayCrashed, crash)
 		}
 	}
 }
@@ -6,11 +6,14 @@ import (
 	"path/filepath"
 
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
 	"github.com/cofedish/3x-UI-agents/xray"
 )
 
-// ClearLogsJob clears old log files to prevent disk space issues.
-type ClearLogsJob struct{}
+// ClearLogsJob clears old log files and server timeline events to prevent disk space issues.
+type ClearLogsJob struct {
+	timelineService service.ServerTimelineService
+}
 
 // NewClearLogsJob creates a new log cleanup job instance.
 func NewClearLogsJob() *ClearLogsJob {
@@ -75,4 +78,8 @@ func (j *ClearLogsJob) Run() {
 			logger.Warning("Failed to truncate log file:", logFiles[i], "-", err)
 		}
 	}
+
+	if err := j.timelineService.PruneEvents(); err != nil {
+		logger.Warning("Failed to prune server events:", err)
+	}
 }
@@ -0,0 +1,81 @@
+// Package job provides GeoUpdateJob for scheduled geo file updates across all servers.
+package job
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// geoUpdateAlertThreshold is the number of consecutive failures that triggers an alert.
+const geoUpdateAlertThreshold = 3
+
+// GeoUpdateJob updates geo files on every enabled server, one server at a time with a
+// pause between them so the fleet does not hit the download mirrors at once.
+type GeoUpdateJob struct {
+	geoUpdate      service.GeoUpdateService
+	serverMgmt     service.ServerManagementService
+	settingService service.SettingService
+	tgbotService   service.Tgbot
+}
+
+// NewGeoUpdateJob creates a new scheduled geo update job.
+func NewGeoUpdateJob() *GeoUpdateJob {
+	return new(GeoUpdateJob)
+}
+
+// Run updates the geo files of all enabled servers.
+func (j *GeoUpdateJob) Run() {
+	servers, err := j.serverMgmt.GetEnabledServers()
+	if err != nil {
+		logger.Warning("Geo update: failed to get servers:", err)
+		return
+	}
+
+	stagger, err := j.settingService.GetGeoUpdateStagger()
+	if err != nil || stagger < 0 {
+		stagger = 0
+	}
+
+	updated := 0
+	for i, server := range servers {
+		if i > 0 && stagger > 0 {
+			time.Sleep(time.Duration(stagger) * time.Second)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		statuses, err := j.geoUpdate.UpdateServer(ctx, server.Id)
+		cancel()
+		if err == nil {
+			updated++
+			continue
+		}
+
+		logger.Warning("Geo update failed on server", server.Name, ":", err)
+		for _, status := range statuses {
+			// Alert once when the threshold is reached, not on every later failure
+			if status.Failures == geoUpdateAlertThreshold {
+				j.notify(server.Name, status.Failures, err)
+				break
+			}
+		}
+	}
+
+	logger.Infof("Geo update finished: %d of %d servers updated", updated, len(servers))
+}
+
+// notify sends a Telegram message to admins about repeated failures if the bot is running.
+func (j *GeoUpdateJob) notify(serverName string, failures int, err error) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+
+	msg := j.tgbotService.I18nBot("tgbot.messages.geoUpdateFailed",
+		"Server=="+serverName,
+		"Count=="+strconv.Itoa(failures),
+		"Error=="+err.Error())
+	j.tgbotService.SendMsgToTgbotAdmins(msg)
+}
@@ -0,0 +1,117 @@
+// Package job provides GlobalIpLimitJob for enforcing client IP limits across servers.
+package job
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// globalIpLimitAlertInterval is the minimum time between repeated alerts for the same client.
+const globalIpLimitAlertInterval = time.Hour
+
+// GlobalIpLimitJob aggregates client IPs from all servers and alerts on or disables
+// clients whose distinct IP count exceeds their limitIp across the whole fleet.
+type GlobalIpLimitJob struct {
+	ipLimitService service.GlobalIpLimitService
+	serverMgmt     service.ServerManagementService
+	settingService service.SettingService
+	tgbotService   service.Tgbot
+
+	alertedMu sync.Mutex
+	alerted   map[string]time.Time // email -> last alert time
+}
+
+// NewGlobalIpLimitJob creates a new global IP limit enforcement job.
+func NewGlobalIpLimitJob() *GlobalIpLimitJob {
+	return &GlobalIpLimitJob{
+		alerted: make(map[string]time.Time),
+	}
+}
+
+// Run syncs remote client IPs and handles clients exceeding their global IP limit.
+func (j *GlobalIpLimitJob) Run() {
+	enabled, err := j.settingService.GetIpLimitGlobalEnable()
+	if err != nil || !enabled {
+		return
+	}
+
+	servers, err := j.serverMgmt.GetEnabledServers()
+	if err != nil {
+		logger.Warning("Global IP limit: failed to get servers:", err)
+		return
+	}
+	// Per-node enforcement already covers single-server setups
+	if len(servers) == 1 && servers[0].IsLocal() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := j.ipLimitService.SyncRemoteClientIps(ctx); err != nil {
+		logger.Warning("Global IP limit: failed to sync client IPs:", err)
+	}
+
+	violations, err := j.ipLimitService.GetViolations(ctx)
+	if err != nil {
+		logger.Warning("Global IP limit: failed to check violations:", err)
+		return
+	}
+
+	action, _ := j.settingService.GetIpLimitGlobalAction()
+	for _, violation := range violations {
+		if action == "disable" {
+			if err := j.ipLimitService.DisableClient(ctx, violation.Email); err != nil {
+				logger.Warning("Global IP limit: failed to disable client", violation.Email, ":", err)
+				continue
+			}
+			logger.Infof("Global IP limit: disabled client %s (%d IPs, limit %d)", violation.Email, len(violation.IPs), violation.LimitIp)
+			j.notify(violation, action)
+			continue
+		}
+
+		if j.shouldAlert(violation.Email) {
+			logger.Warningf("Global IP limit: client %s uses %d IPs across servers (limit %d)", violation.Email, len(violation.IPs), violation.LimitIp)
+			j.notify(violation, action)
+		}
+	}
+}
+
+// shouldAlert reports whether an alert for email is due and records it.
+func (j *GlobalIpLimitJob) shouldAlert(email string) bool {
+	j.alertedMu.Lock()
+	defer j.alertedMu.Unlock()
+
+	now := time.Now()
+	if last, ok := j.alerted[email]; ok && now.Sub(last) < globalIpLimitAlertInterval {
+		return false
+	}
+	j.alerted[email] = now
+	return true
+}
+
+// notify sends a Telegram message to admins about a violation if the bot is running.
+func (j *GlobalIpLimitJob) notify(violation *service.ClientIpUsage, action string) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+
+	servers := make([]string, 0, len(violation.Servers))
+	for _, id := range violation.Servers {
+		servers = append(servers, strconv.Itoa(id))
+	}
+
+	msg := j.tgbotService.I18nBot("tgbot.messages.ipLimitExceeded",
+		"Email=="+violation.Email,
+		"Count=="+strconv.Itoa(len(violation.IPs)),
+		"Limit=="+strconv.Itoa(violation.LimitIp),
+		"Servers=="+strings.Join(servers, ", "),
+		"Action=="+action)
+	j.tgbotService.SendMsgToTgbotAdmins(msg)
+}
@@ -0,0 +1,41 @@
+// Package job provides JWTRotationJob for rotating the JWT secrets of agents.
+package job
+
+import (
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// JWTRotationJob replaces the JWT secrets of agents once they are older than the
+// configured number of days.
+type JWTRotationJob struct {
+	serverMgmt     service.ServerManagementService
+	settingService service.SettingService
+}
+
+// NewJWTRotationJob creates a new JWT secret rotation job.
+func NewJWTRotationJob() *JWTRotationJob {
+	return new(JWTRotationJob)
+}
+
+// Run rotates the secrets that are due. Nothing is rotated in read-only mode.
+func (j *JWTRotationJob) Run() {
+	days, err := j.settingService.GetJwtRotationDays()
+	if err != nil || days < 0 || j.settingService.IsReadOnly() {
+		return
+	}
+	if days == 0 {
+		days = 30
+	}
+
+	rotated, err := j.serverMgmt.RotateDueJWTSecrets(time.Duration(days) * 24 * time.Hour)
+	if err != nil {
+		logger.Warning("JWT rotation failed:", err)
+		return
+	}
+	if rotated > 0 {
+		logger.Infof("JWT rotation: rotated the secrets of %d servers", rotated)
+	}
+}
@@ -0,0 +1,29 @@
+// Package job provides LoginAttemptJob for pruning old panel login attempts.
+package job
+
+import (
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// LoginAttemptJob removes login attempts past the retention period.
+type LoginAttemptJob struct {
+	loginAttempts service.LoginAttemptService
+}
+
+// NewLoginAttemptJob creates a new login attempt pruning job.
+func NewLoginAttemptJob() *LoginAttemptJob {
+	return new(LoginAttemptJob)
+}
+
+// Run deletes expired login attempts.
+func (j *LoginAttemptJob) Run() {
+	removed, err := j.loginAttempts.DeleteExpired()
+	if err != nil {
+		logger.Warning("Failed to delete expired login attempts:", err)
+		return
+	}
+	if removed > 0 {
+		logger.Infof("Removed %d expired login attempts", removed)
+	}
+}
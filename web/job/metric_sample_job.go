@@ -0,0 +1,25 @@
+// Package job provides MetricSampleJob for persisting server metric history.
+package job
+
+import (
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// MetricSampleJob stores the latest metrics collected by the health check of every
+// server, so CPU, memory and network history can be exported later.
+type MetricSampleJob struct {
+	metricsHistory service.MetricsHistoryService
+}
+
+// NewMetricSampleJob creates a new metric sampling job.
+func NewMetricSampleJob() *MetricSampleJob {
+	return new(MetricSampleJob)
+}
+
+// Run records one sample per reporting server.
+func (j *MetricSampleJob) Run() {
+	if err := j.metricsHistory.RecordSamples(); err != nil {
+		logger.Warning("Failed to record server metric samples:", err)
+	}
+}
@@ -0,0 +1,25 @@
+// Package job provides OutboundTrafficJob for collecting the outbound traffic of remote servers.
+package job
+
+import (
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// OutboundTrafficJob stores the outbound traffic totals reported by the agents, so traffic
+// per outbound (e.g. WARP vs direct) can be compared across servers.
+type OutboundTrafficJob struct {
+	outboundService service.OutboundService
+}
+
+// NewOutboundTrafficJob creates a new outbound traffic collection job.
+func NewOutboundTrafficJob() *OutboundTrafficJob {
+	return new(OutboundTrafficJob)
+}
+
+// Run collects the outbound traffic of every online remote server.
+func (j *OutboundTrafficJob) Run() {
+	if err := j.outboundService.CollectRemoteTraffic(); err != nil {
+		logger.Warning("Failed to collect outbound traffic of remote servers:", err)
+	}
+}
@@ -1,6 +1,11 @@
 package job
 
 import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
 	"github.com/cofedish/3x-UI-agents/web/service"
 )
@@ -8,22 +13,55 @@ import (
 // Period represents the time period for traffic resets.
 type Period string
 
+// Reset periods an inbound's TrafficReset can be set to.
+const (
+	PeriodDaily   Period = "daily"
+	PeriodWeekly  Period = "weekly"
+	PeriodMonthly Period = "monthly"
+)
+
 // PeriodicTrafficResetJob resets traffic statistics for inbounds based on their configured reset period.
+// Local inbounds are reset directly; inbounds on remote servers are reset through their connectors.
+// It runs hourly, and a period starts at midnight in the timezone of the server owning the inbound.
 type PeriodicTrafficResetJob struct {
 	inboundService service.InboundService
-	period         Period
+	billingService service.BillingService
+	serverMgmt     service.ServerManagementService
 }
 
-// NewPeriodicTrafficResetJob creates a new periodic traffic reset job for the specified period.
-func NewPeriodicTrafficResetJob(period Period) *PeriodicTrafficResetJob {
-	return &PeriodicTrafficResetJob{
-		period: period,
-	}
+// NewPeriodicTrafficResetJob creates a new periodic traffic reset job.
+func NewPeriodicTrafficResetJob() *PeriodicTrafficResetJob {
+	return &PeriodicTrafficResetJob{}
 }
 
-// Run resets traffic statistics for all inbounds that match the configured reset period.
+// Run resets traffic statistics for all inbounds whose reset period starts in this hour.
 func (j *PeriodicTrafficResetJob) Run() {
-	inbounds, err := j.inboundService.GetInboundsByTrafficReset(string(j.period))
+	now := time.Now()
+	for _, period := range duePeriods(now.In(j.serverMgmt.GetServerLocation(j.serverMgmt.GetLocalServerId()))) {
+		j.resetLocal(period)
+	}
+	j.resetRemote(now)
+}
+
+// duePeriods returns the reset periods starting in the hour of t: a day starts at
+// midnight, a week at midnight between Saturday and Sunday, and a month on the 1st.
+func duePeriods(t time.Time) []Period {
+	if t.Hour() != 0 {
+		return nil
+	}
+	periods := []Period{PeriodDaily}
+	if t.Weekday() == time.Sunday {
+		periods = append(periods, PeriodWeekly)
+	}
+	if t.Day() == 1 {
+		periods = append(periods, PeriodMonthly)
+	}
+	return periods
+}
+
+// resetLocal resets matching inbounds stored in the local database.
+func (j *PeriodicTrafficResetJob) resetLocal(period Period) {
+	inbounds, err := j.inboundService.GetInboundsByTrafficReset(string(period))
 	if err != nil {
 		logger.Warning("Failed to get inbounds for traffic reset:", err)
 		return
@@ -32,12 +70,14 @@ func (j *PeriodicTrafficResetJob) Run() {
 	if len(inbounds) == 0 {
 		return
 	}
-	logger.Infof("Running periodic traffic reset job for period: %s (%d matching inbounds)", j.period, len(inbounds))
+	logger.Infof("Running periodic traffic reset job for period: %s (%d matching inbounds)", period, len(inbounds))
 
 	resetCount := 0
 
 	for _, inbound := range inbounds {
-		resetInboundErr := j.inboundService.ResetAllTraffics()
+		j.recordResetEvents(inbound.ServerId, inbound, period)
+
+		resetInboundErr := j.inboundService.ResetInboundTraffic(inbound.Id)
 		if resetInboundErr != nil {
 			logger.Warning("Failed to reset traffic for inbound", inbound.Id, ":", resetInboundErr)
 		}
@@ -56,3 +96,68 @@ func (j *PeriodicTrafficResetJob) Run() {
 		logger.Infof("Periodic traffic reset completed: %d inbounds reset", resetCount)
 	}
 }
+
+// resetRemote applies the reset schedule to inbounds owned by enabled remote servers,
+// each in its own timezone.
+func (j *PeriodicTrafficResetJob) resetRemote(now time.Time) {
+	servers, err := j.serverMgmt.GetEnabledServers()
+	if err != nil {
+		logger.Warning("Failed to get servers for traffic reset:", err)
+		return
+	}
+
+	for _, server := range servers {
+		if server.IsLocal() {
+			continue
+		}
+		periods := duePeriods(now.In(service.ServerLocation(server)))
+		if len(periods) == 0 {
+			continue
+		}
+
+		connector, err := j.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			logger.Warning("Traffic reset: failed to connect to server", server.Name, ":", err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		inbounds, err := connector.ListInbounds(ctx)
+		if err != nil {
+			cancel()
+			logger.Warning("Traffic reset: failed to list inbounds of server", server.Name, ":", err)
+			continue
+		}
+
+		resetCount := 0
+		for _, inbound := range inbounds {
+			if !slices.Contains(periods, Period(inbound.TrafficReset)) {
+				continue
+			}
+			if err := j.billingService.RecordResetEvents(server.Id, inbound, inbound.ClientStats, inbound.TrafficReset); err != nil {
+				logger.Warning("Traffic reset: failed to record reset event for inbound", inbound.Id, ":", err)
+			}
+			if err := connector.ResetInboundTraffic(ctx, inbound.Id); err != nil {
+				logger.Warning("Traffic reset: failed to reset inbound", inbound.Id, "on server", server.Name, ":", err)
+				continue
+			}
+			resetCount++
+		}
+		cancel()
+
+		if resetCount > 0 {
+			logger.Infof("Periodic traffic reset completed on server %s: %d inbounds reset", server.Name, resetCount)
+		}
+	}
+}
+
+// recordResetEvents keeps the counters of a local inbound right before it is reset.
+func (j *PeriodicTrafficResetJob) recordResetEvents(serverId int, inbound *model.Inbound, period Period) {
+	stats, err := j.billingService.GetLocalClientStats(inbound.Id)
+	if err == nil {
+		err = j.billingService.RecordResetEvents(serverId, inbound, stats, string(period))
+	}
+	if err != nil {
+		logger.Warning("Traffic reset: failed to record reset event for inbound", inbound.Id, ":", err)
+	}
+}
@@ -0,0 +1,35 @@
+// Package job provides PortProbeJob for checking that inbound ports are reachable.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// PortProbeJob connects to the inbound ports of all servers from the panel, so
+// unreachable inbounds are flagged in listings.
+type PortProbeJob struct {
+	portProbe service.PortProbeService
+}
+
+// NewPortProbeJob creates a new port probe job.
+func NewPortProbeJob() *PortProbeJob {
+	return new(PortProbeJob)
+}
+
+// Run probes the inbound ports.
+func (j *PortProbeJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Minute)
+	defer cancel()
+
+	unreachable, err := j.portProbe.ProbeAll(ctx)
+	if unreachable > 0 {
+		logger.Infof("%d inbound ports are unreachable from the panel", unreachable)
+	}
+	if err != nil {
+		logger.Warning("Failed to probe inbound ports on some servers:", err)
+	}
+}
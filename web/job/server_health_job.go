@@ -48,6 +48,11 @@ func loadHealthConfig() HealthConfig {
 // Uses bounded worker pool to prevent resource exhaustion with N servers.
 type ServerHealthJob struct {
 	serverManagement *service.ServerManagementService
+	fleetOverview    *service.FleetOverviewService
+	syncQueue        *service.SyncQueueService
+	healthRuns       *service.HealthRunService
+	xrayService      *service.XrayService
+	settingService   *service.SettingService
 	config           HealthConfig
 
 	// Backoff tracking per server (simple: consecutive failure count)
@@ -59,6 +64,11 @@ type ServerHealthJob struct {
 func NewServerHealthJob() *ServerHealthJob {
 	return &ServerHealthJob{
 		serverManagement: &service.ServerManagementService{},
+		fleetOverview:    &service.FleetOverviewService{},
+		syncQueue:        &service.SyncQueueService{},
+		healthRuns:       &service.HealthRunService{},
+		xrayService:      &service.XrayService{},
+		settingService:   &service.SettingService{},
 		config:           loadHealthConfig(),
 		failures:         make(map[int]int),
 	}
@@ -75,20 +85,23 @@ func (j *ServerHealthJob) Run() {
 		return
 	}
 
-	// Skip health check if only local server exists (ID=1)
-	if len(servers) == 1 && servers[0].Id == 1 {
-		return
-	}
+	// The local server needs no health check, but the fleet dashboard shows it too
+	j.recordLocalSample()
 
-	// Filter out local server
+	// Filter out local servers; nothing to check if there are no others
 	remoteServers := make([]*model.Server, 0, len(servers))
 	for _, server := range servers {
-		if server.Id != 1 {
+		if !server.IsLocal() {
 			remoteServers = append(remoteServers, server)
 		}
 	}
 
 	if len(remoteServers) == 0 {
+		// Recorded anyway, so the run history shows the job is alive
+		j.healthRuns.RecordRun(&model.HealthCheckRun{
+			StartedAt: startTime.Unix(),
+			Duration:  time.Since(startTime).Milliseconds(),
+		})
 		return
 	}
 
@@ -161,6 +174,14 @@ func (j *ServerHealthJob) Run() {
 	elapsed := time.Since(startTime)
 	logger.Info("Health check completed:", len(remoteServers), "servers,",
 		onlineCount, "online,", offlineCount, "offline,", errorCount, "errors, took", elapsed)
+	j.healthRuns.RecordRun(&model.HealthCheckRun{
+		StartedAt: startTime.Unix(),
+		Duration:  elapsed.Milliseconds(),
+		Servers:   len(remoteServers),
+		Online:    onlineCount,
+		Offline:   offlineCount,
+		Errors:    errorCount,
+	})
 }
 
 // checkServer performs health check for a single server and returns its status.
@@ -179,7 +200,9 @@ func (j *ServerHealthJob) checkServer(server *model.Server) string {
 	defer cancel()
 
 	// Get health status
+	checkStart := time.Now()
 	health, err := connector.GetHealth(ctx)
+	latency := time.Since(checkStart)
 	if err != nil {
 		logger.Warning("Health check failed for server", server.Name, ":", err)
 		j.updateServerStatus(server.Id, "offline", "Health check failed: "+err.Error())
@@ -193,11 +216,33 @@ func (j *ServerHealthJob) checkServer(server *model.Server) string {
 	// Update status to online
 	j.updateServerStatus(server.Id, health.Status, "")
 
+	// Compare the agent clock with ours
+	j.checkClockSkew(server, health, checkStart, latency)
+
+	// Compare the config epoch of the agent with ours
+	if health.ConfigEpoch != nil {
+		if err := j.serverManagement.UpdateConfigEpoch(server.Id, *health.ConfigEpoch); err != nil {
+			logger.Error("Failed to update config epoch:", err)
+		}
+	}
+
 	// Update metadata if needed
 	if health.Version != "" || health.XrayVersion != "" {
 		j.updateServerMetadata(server.Id, health.Version, health.XrayVersion)
 	}
 
+	// Sample metrics for the fleet dashboard
+	j.fleetOverview.RecordSample(ctx, server.Id, connector, latency, health.XrayRunning)
+
+	// Apply the mutations queued while the server was unreachable
+	if j.syncQueue.HasPending(server.Id) {
+		go func() {
+			if err := j.syncQueue.Replay(server.Id); err != nil {
+				logger.Warning("Failed to replay queued mutations on server", server.Name, ":", err)
+			}
+		}()
+	}
+
 	// Get detailed server info (less frequently)
 	// Check if server info needs refresh (e.g., if version is unknown)
 	if server.Version == "" || server.XrayVersion == "" {
@@ -207,6 +252,34 @@ func (j *ServerHealthJob) checkServer(server *model.Server) string {
 	return health.Status
 }
 
+// checkClockSkew measures how far the agent clock is from the panel clock, using the
+// midpoint of the health request as the panel time the agent answered at.
+func (j *ServerHealthJob) checkClockSkew(server *model.Server, health *service.HealthStatus, checkStart time.Time, latency time.Duration) {
+	if health.Timestamp == 0 {
+		return // Agent does not report its time
+	}
+	threshold, err := j.settingService.GetClockSkewThreshold()
+	if err != nil {
+		threshold = 30
+	}
+	skew := health.Timestamp - checkStart.Add(latency/2).Unix()
+	if err := j.serverManagement.UpdateClockSkew(server.Id, skew, threshold); err != nil {
+		logger.Error("Failed to update clock skew:", err)
+	}
+}
+
+// recordLocalSample samples the local server's metrics for the fleet dashboard.
+func (j *ServerHealthJob) recordLocalSample() {
+	localId := j.serverManagement.GetLocalServerId()
+	connector, err := j.serverManagement.GetConnector(localId)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), j.config.CheckTimeout)
+	defer cancel()
+	j.fleetOverview.RecordSample(ctx, localId, connector, 0, j.xrayService.IsXrayRunning())
+}
+
 // recordFailure increments failure count for a server
 func (j *ServerHealthJob) recordFailure(serverId int) {
 	j.failuresMu.Lock()
@@ -0,0 +1,67 @@
+// Package job provides StaleClientJob for cleaning up long expired or depleted clients.
+package job
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// StaleClientJob disables or deletes clients that have been expired or out of traffic
+// for more than the staleClientDays setting on all servers, or only reports them.
+type StaleClientJob struct {
+	staleClients   service.StaleClientService
+	settingService service.SettingService
+	tgbotService   service.Tgbot
+}
+
+// NewStaleClientJob creates a new stale client cleanup job.
+func NewStaleClientJob() *StaleClientJob {
+	return new(StaleClientJob)
+}
+
+// Run cleans up stale clients and notifies admins about them.
+func (j *StaleClientJob) Run() {
+	days, err := j.settingService.GetStaleClientDays()
+	if err != nil || days <= 0 {
+		return
+	}
+	action, err := j.settingService.GetStaleClientAction()
+	if err != nil || action == "" {
+		action = "report"
+	}
+	// Read-only mode still gets the dry-run report
+	if j.settingService.IsReadOnly() {
+		action = "report"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := j.staleClients.Cleanup(ctx, days, action)
+	if err != nil {
+		logger.Warning("Stale client cleanup failed:", err)
+		return
+	}
+	logger.Infof("Stale client cleanup (%s): %d stale clients, %d cleaned, %d server errors",
+		action, len(report.Clients), report.Cleaned, len(report.Errors))
+	if len(report.Clients) > 0 {
+		j.notify(report)
+	}
+}
+
+// notify sends a Telegram message to admins about the stale clients if the bot is running.
+func (j *StaleClientJob) notify(report *service.StaleClientReport) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+	msg := j.tgbotService.I18nBot("tgbot.messages.staleClients",
+		"Count=="+strconv.Itoa(len(report.Clients)),
+		"Days=="+strconv.Itoa(report.Days),
+		"Servers=="+service.FormatStaleClientSummary(report),
+		"Action=="+report.Action)
+	j.tgbotService.SendMsgToTgbotAdmins(msg)
+}
@@ -0,0 +1,27 @@
+// Package job provides StandbyExportJob for exporting panel snapshots for disaster recovery.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// StandbyExportJob exports a snapshot of the panel database to the standbyExportTarget
+// setting every standbyExportInterval minutes, if the configuration changed.
+type StandbyExportJob struct {
+	standbyExport service.StandbyExportService
+}
+
+// NewStandbyExportJob creates a new standby export job.
+func NewStandbyExportJob() *StandbyExportJob {
+	return new(StandbyExportJob)
+}
+
+// Run exports a snapshot if one is due.
+func (j *StandbyExportJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	j.standbyExport.ExportIfDue(ctx)
+}
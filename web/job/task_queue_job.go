@@ -0,0 +1,21 @@
+// Package job provides TaskQueueJob for running queued server tasks.
+package job
+
+import (
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TaskQueueJob runs the server tasks that are due, including retries of failed attempts.
+type TaskQueueJob struct {
+	taskQueue service.TaskQueueService
+}
+
+// NewTaskQueueJob creates a new task queue job.
+func NewTaskQueueJob() *TaskQueueJob {
+	return new(TaskQueueJob)
+}
+
+// Run executes the due tasks with bounded concurrency.
+func (j *TaskQueueJob) Run() {
+	j.taskQueue.RunDue()
+}
@@ -0,0 +1,95 @@
+// Package job provides TrafficAnomalyJob for alerting on sudden traffic changes.
+package job
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// trafficAnomalyAlertInterval is the minimum time between repeated alerts for the same series.
+const trafficAnomalyAlertInterval = time.Hour
+
+// TrafficAnomalyJob detects traffic spikes and drops per server and per client and
+// alerts admins about them.
+type TrafficAnomalyJob struct {
+	anomalyService service.TrafficAnomalyService
+	settingService service.SettingService
+	tgbotService   service.Tgbot
+
+	alertedMu sync.Mutex
+	alerted   map[string]time.Time // server/client key -> last alert time
+}
+
+// NewTrafficAnomalyJob creates a new traffic anomaly detection job.
+func NewTrafficAnomalyJob() *TrafficAnomalyJob {
+	return &TrafficAnomalyJob{
+		alerted: make(map[string]time.Time),
+	}
+}
+
+// Run observes the current traffic rates and notifies admins about anomalies.
+func (j *TrafficAnomalyJob) Run() {
+	enabled, err := j.settingService.GetTrafficAnomalyEnable()
+	if err != nil || !enabled {
+		return
+	}
+	threshold, err := j.settingService.GetTrafficAnomalyThreshold()
+	if err != nil || threshold < 2 {
+		threshold = 4
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	anomalies, err := j.anomalyService.Detect(ctx, float64(threshold))
+	if err != nil {
+		logger.Warning("Traffic anomaly: detection failed:", err)
+		return
+	}
+	for _, anomaly := range anomalies {
+		logger.Infof("Traffic %s on server %d %s: %.0f B/s (baseline %.0f B/s, z=%.1f)",
+			anomaly.Direction, anomaly.ServerId, anomaly.Email, anomaly.Rate, anomaly.Expected, anomaly.Score)
+		if j.shouldAlert(anomaly) {
+			j.notify(anomaly)
+		}
+	}
+}
+
+// shouldAlert reports whether enough time has passed since the last alert for the series.
+func (j *TrafficAnomalyJob) shouldAlert(anomaly *service.TrafficAnomaly) bool {
+	j.alertedMu.Lock()
+	defer j.alertedMu.Unlock()
+
+	key := strconv.Itoa(anomaly.ServerId) + "/" + anomaly.Email
+	now := time.Now()
+	if last, ok := j.alerted[key]; ok && now.Sub(last) < trafficAnomalyAlertInterval {
+		return false
+	}
+	j.alerted[key] = now
+	return true
+}
+
+// notify sends a Telegram message to admins about an anomaly if the bot is running.
+func (j *TrafficAnomalyJob) notify(anomaly *service.TrafficAnomaly) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+
+	client := ""
+	if anomaly.Email != "" {
+		client = " (" + anomaly.Email + ")"
+	}
+	msg := j.tgbotService.I18nBot("tgbot.messages.trafficAnomaly",
+		"Direction=="+anomaly.Direction,
+		"Server=="+anomaly.ServerName,
+		"Client=="+client,
+		"Rate=="+common.FormatTraffic(int64(anomaly.Rate)),
+		"Expected=="+common.FormatTraffic(int64(anomaly.Expected)))
+	j.tgbotService.SendMsgToTgbotAdmins(msg)
+}
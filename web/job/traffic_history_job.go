@@ -0,0 +1,31 @@
+// Package job provides TrafficHistoryJob for recording daily traffic history used by traffic reports.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TrafficHistoryJob adds the traffic of every inbound and client on all servers to the
+// daily history behind traffic reports.
+type TrafficHistoryJob struct {
+	trafficHistory service.TrafficHistoryService
+}
+
+// NewTrafficHistoryJob creates a new traffic history job.
+func NewTrafficHistoryJob() *TrafficHistoryJob {
+	return new(TrafficHistoryJob)
+}
+
+// Run records one snapshot of every server.
+func (j *TrafficHistoryJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := j.trafficHistory.RecordSnapshots(ctx); err != nil {
+		logger.Warning("Failed to record traffic history on some servers:", err)
+	}
+}
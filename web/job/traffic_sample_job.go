@@ -0,0 +1,31 @@
+// Package job provides TrafficSampleJob for recording client traffic samples used by billing exports.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TrafficSampleJob snapshots every client's counters on all servers so usage
+// can later be computed for arbitrary billing periods.
+type TrafficSampleJob struct {
+	billingService service.BillingService
+}
+
+// NewTrafficSampleJob creates a new traffic sampling job.
+func NewTrafficSampleJob() *TrafficSampleJob {
+	return new(TrafficSampleJob)
+}
+
+// Run records one sample per client and server.
+func (j *TrafficSampleJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := j.billingService.RecordSamples(ctx); err != nil {
+		logger.Warning("Failed to record traffic samples on some servers:", err)
+	}
+}
@@ -0,0 +1,35 @@
+// Package job provides TrialCleanupJob for removing lapsed trial clients.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TrialCleanupJob removes trial clients from their servers once they expire or use up
+// their traffic.
+type TrialCleanupJob struct {
+	trialService service.TrialService
+}
+
+// NewTrialCleanupJob creates a new trial cleanup job.
+func NewTrialCleanupJob() *TrialCleanupJob {
+	return new(TrialCleanupJob)
+}
+
+// Run removes the lapsed trial clients.
+func (j *TrialCleanupJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	removed, err := j.trialService.CleanupTrials(ctx)
+	if removed > 0 {
+		logger.Infof("Removed %d lapsed trial clients", removed)
+	}
+	if err != nil {
+		logger.Warning("Failed to remove lapsed trial clients on some servers:", err)
+	}
+}
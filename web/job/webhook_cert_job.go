@@ -0,0 +1,30 @@
+// Package job provides WebhookCertJob for reporting expiring certificates to webhooks.
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// WebhookCertJob sends the cert.expiring webhook event for certificates nearing expiry.
+type WebhookCertJob struct {
+	webhookService service.WebhookService
+}
+
+// NewWebhookCertJob creates a new certificate expiry webhook job.
+func NewWebhookCertJob() *WebhookCertJob {
+	return new(WebhookCertJob)
+}
+
+// Run checks the certificates of all enabled servers once.
+func (j *WebhookCertJob) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := j.webhookService.CheckCertExpiry(ctx); err != nil {
+		logger.Warning("Webhook certificate check failed:", err)
+	}
+}
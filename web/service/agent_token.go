@@ -0,0 +1,62 @@
+// Package service provides the issuance of signed bearer tokens for JWT agents.
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/util/jwt"
+)
+
+const (
+	// defaultAgentTokenTTL is how long tokens issued for agents are valid.
+	defaultAgentTokenTTL = 5 * time.Minute
+	// agentTokenIssuer is the "iss" and "sub" claim of tokens issued by the panel.
+	agentTokenIssuer = "x-ui-panel"
+)
+
+// IssueAgentToken returns a token signed with an agent's JWT secret that is valid for
+// ttl (defaultAgentTokenTTL if 0), e.g. for calling the agent API by hand.
+func IssueAgentToken(secret, audience string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = defaultAgentTokenTTL
+	}
+	if audience == "" {
+		audience = jwt.DefaultAudience
+	}
+	token, _, err := jwt.Issue(secret, agentTokenIssuer, agentTokenIssuer, audience, ttl)
+	return token, err
+}
+
+// agentTokenSource supplies the bearer token of a JWT connector. With a secret it issues
+// short-lived tokens and renews them before they expire; otherwise it returns the fixed
+// token from the auth data.
+type agentTokenSource struct {
+	static   string
+	secret   string
+	audience string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Token returns a token that stays valid for at least a fifth of its lifetime.
+func (s *agentTokenSource) Token() (string, error) {
+	if s.secret == "" {
+		return s.static, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > s.ttl/5 {
+		return s.token, nil
+	}
+	token, err := IssueAgentToken(s.secret, s.audience, s.ttl)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, time.Now().Add(s.ttl)
+	return token, nil
+}
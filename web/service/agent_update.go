@@ -0,0 +1,124 @@
+// Package service provides AgentUpdateService for updating the agent binary on remote servers.
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+const (
+	// AgentUpdateOperation is the ServerTask operation of agent updates.
+	AgentUpdateOperation = "update_agent"
+
+	// DefaultAgentReleaseURL is where agents download releases from when no release URL
+	// is set; the archive's signed checksum is published next to it.
+	DefaultAgentReleaseURL = "https://github.com/cofedish/3x-UI-agents/releases/download/{version}/x-ui-linux-{arch}.tar.gz"
+
+	// agentUpdateTimeout bounds a single agent's download and install.
+	agentUpdateTimeout = 10 * time.Minute
+	// agentRestartTimeout bounds waiting for the updated agent to come back.
+	agentRestartTimeout = 2 * time.Minute
+)
+
+// agentVersionPattern matches panel and agent release versions such as "v2.8.5".
+var agentVersionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+$`)
+
+// AgentUpdateService updates the agent binary of remote servers to a signed release,
+// one server or the whole fleet, through ServerTask records like FleetUpgradeService.
+type AgentUpdateService struct {
+	serverMgmt ServerManagementService
+}
+
+// agentUpdateRequest is stored as the RequestData of an agent update task.
+type agentUpdateRequest struct {
+	Version string `json:"version"`
+}
+
+// StartUpdate queues an agent update to version (the panel's own version if empty) on
+// the given remote servers (all enabled remote servers if empty) and runs it in the
+// background with at most concurrency servers at a time. It returns the created tasks.
+func (s *AgentUpdateService) StartUpdate(version string, serverIds []int, concurrency int, userId int) ([]*model.ServerTask, error) {
+	if version == "" {
+		version = config.GetVersion()
+	}
+	if !agentVersionPattern.MatchString(version) {
+		return nil, fmt.Errorf("invalid agent version %q", version)
+	}
+	version = "v" + strings.TrimPrefix(version, "v")
+	for _, id := range serverIds {
+		if s.serverMgmt.IsLocalServer(id) {
+			return nil, fmt.Errorf("the local server is updated together with the panel")
+		}
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+	remote := make([]*model.Server, 0, len(servers))
+	for _, server := range servers {
+		if !server.IsLocal() {
+			remote = append(remote, server)
+		}
+	}
+	tasks, err := createFleetTasks(remote, serverIds, AgentUpdateOperation, "agent update", agentUpdateRequest{Version: version}, userId)
+	if err != nil {
+		return nil, err
+	}
+	go runFleetTasks(tasks, "agent update to "+version, concurrency, func(serverId int, progress ProgressFunc) (string, error) {
+		return s.updateAndVerify(serverId, version, progress)
+	})
+	return tasks, nil
+}
+
+// GetLatestUpdateTasks returns the most recent agent update task of each server.
+func (s *AgentUpdateService) GetLatestUpdateTasks() ([]*model.ServerTask, error) {
+	return latestFleetTasks(AgentUpdateOperation)
+}
+
+// updateAndVerify runs UpdateAgent, reporting its progress to progress, and waits until
+// the restarted agent reports the target version.
+func (s *AgentUpdateService) updateAndVerify(serverId int, version string, progress ProgressFunc) (string, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentUpdateTimeout)
+	err = connector.UpdateAgent(ctx, version, progress)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+
+	// The agent restarts a few seconds after the install, so it is unreachable for a moment
+	progress(95, "restarting")
+	target := strings.TrimPrefix(version, "v")
+	deadline := time.Now().Add(agentRestartTimeout)
+	installed := ""
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		health, err := connector.GetHealth(ctx)
+		cancel()
+		if err == nil {
+			installed = health.Version
+			if strings.TrimPrefix(installed, "v") == target {
+				return installed, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	if installed == "" {
+		return "", fmt.Errorf("installed but the agent did not come back after the restart")
+	}
+	return installed, fmt.Errorf("installed but the agent reports version %s", installed)
+}
@@ -0,0 +1,708 @@
+// Package service provides AlertService for alert rules evaluated against server health and usage.
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+)
+
+const (
+	// alertQuotaRefresh and alertCertRefresh limit how often client traffic and
+	// certificates are fetched from the agents for alert rules.
+	alertQuotaRefresh = 10 * time.Minute
+	alertCertRefresh  = time.Hour
+	// alertPollConcurrency is the number of servers polled at once.
+	alertPollConcurrency = 10
+	// alertPollTimeout bounds polling one server.
+	alertPollTimeout = 30 * time.Second
+	// alertNotifyTimeout bounds sending one notification.
+	alertNotifyTimeout = 10 * time.Second
+)
+
+var (
+	alertMetrics    = []string{"server_down", "cpu", "memory", "swap", "disk", "traffic_quota", "cert_expiry"}
+	alertSeverities = []string{"info", "warning", "critical"}
+	alertChannels   = []string{"telegram", "webhook", "email"}
+)
+
+// alertKey identifies what an alert rule fires for: a server, or a client or
+// certificate on it.
+type alertKey struct {
+	ruleId   int
+	serverId int
+	subject  string
+}
+
+// alertObservation is the value of a metric for a server, client or certificate.
+type alertObservation struct {
+	serverId int
+	subject  string
+	value    float64
+}
+
+// alertSeries is the observations of one server cached between polls.
+type alertSeries struct {
+	fetchedAt    time.Time
+	observations []*alertObservation
+	err          error
+}
+
+var (
+	// alertMu also serializes evaluations, so pending breaches are tracked once.
+	alertMu      sync.Mutex
+	alertPending = make(map[alertKey]time.Time) // First evaluation the threshold was crossed
+	alertQuotas  = make(map[int]*alertSeries)
+	alertCerts   = make(map[int]*alertSeries)
+)
+
+// AlertQuery selects alerts for the alert list.
+type AlertQuery struct {
+	ActiveOnly bool // Only alerts that are still firing
+	RuleId     int  // 0 = all rules
+	ServerId   int  // 0 = all servers
+	Limit      int  // Default 100, at most 1000
+}
+
+// AlertService manages alert rules, evaluates them against the health, load, client
+// quotas and certificates of all servers, and notifies admins when alerts fire and
+// resolve through Telegram, a webhook or email.
+type AlertService struct {
+	settingService SettingService
+	tgbotService   Tgbot
+	serverMgmt     ServerManagementService
+}
+
+// GetRules returns all alert rules.
+func (s *AlertService) GetRules() ([]*model.AlertRule, error) {
+	var rules []*model.AlertRule
+	err := database.GetDB().Model(model.AlertRule{}).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// AddRule creates an alert rule.
+func (s *AlertService) AddRule(rule *model.AlertRule) error {
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+	rule.Id = 0
+	return database.GetDB().Create(rule).Error
+}
+
+// UpdateRule replaces an alert rule. Alerts it fired are resolved, as they may no longer
+// match the rule.
+func (s *AlertService) UpdateRule(rule *model.AlertRule) error {
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+	existing := &model.AlertRule{}
+	if err := database.GetDB().Model(model.AlertRule{}).Where("id = ?", rule.Id).First(existing).Error; err != nil {
+		return fmt.Errorf("alert rule %d not found", rule.Id)
+	}
+
+	rule.CreatedAt = existing.CreatedAt
+	if err := database.GetDB().Save(rule).Error; err != nil {
+		return err
+	}
+	return s.resolveRule(rule.Id)
+}
+
+// DeleteRule removes an alert rule and resolves the alerts it fired.
+func (s *AlertService) DeleteRule(id int) error {
+	result := database.GetDB().Delete(model.AlertRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("alert rule %d not found", id)
+	}
+	return s.resolveRule(id)
+}
+
+// GetAlerts returns the alerts matching query, newest first, with their rule and server names.
+func (s *AlertService) GetAlerts(query *AlertQuery) ([]*model.Alert, error) {
+	if query.Limit <= 0 || query.Limit > 1000 {
+		query.Limit = 100
+	}
+
+	db := database.GetDB().Model(model.Alert{})
+	if query.ActiveOnly {
+		db = db.Where("resolved_at = 0")
+	}
+	if query.RuleId != 0 {
+		db = db.Where("rule_id = ?", query.RuleId)
+	}
+	if query.ServerId != 0 {
+		db = db.Where("server_id = ?", query.ServerId)
+	}
+
+	var alerts []*model.Alert
+	if err := db.Order("started_at DESC, id DESC").Limit(query.Limit).Find(&alerts).Error; err != nil {
+		return nil, err
+	}
+	s.setNames(alerts...)
+	return alerts, nil
+}
+
+// Evaluate checks every enabled rule against the current state of the servers. An alert
+// fires once a rule's threshold stayed crossed for its duration and resolves as soon as
+// it is no longer crossed, notifying the rule's channels both times.
+func (s *AlertService) Evaluate(ctx context.Context) error {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+
+	var rules []*model.AlertRule
+	if err := database.GetDB().Model(model.AlertRule{}).Where("enable = ?", true).Find(&rules).Error; err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		clear(alertPending)
+		return nil
+	}
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	var active []*model.Alert
+	if err := database.GetDB().Model(model.Alert{}).Where("resolved_at = 0").Find(&active).Error; err != nil {
+		return err
+	}
+	firing := make(map[alertKey]*model.Alert, len(active))
+	for _, alert := range active {
+		firing[alertKey{ruleId: alert.RuleId, serverId: alert.ServerId, subject: alert.Subject}] = alert
+	}
+
+	observations := make(map[string][]*alertObservation)
+	unknown := make(map[string]map[int]bool)
+	for _, rule := range rules {
+		if _, ok := observations[rule.Metric]; !ok {
+			observations[rule.Metric], unknown[rule.Metric] = s.observe(ctx, rule.Metric, servers)
+		}
+	}
+
+	now := time.Now()
+	breached := make(map[alertKey]bool)
+	errs := make([]error, 0)
+	for _, rule := range rules {
+		observed := make(map[alertKey]bool)
+		for _, observation := range observations[rule.Metric] {
+			if rule.ServerId != 0 && rule.ServerId != observation.serverId {
+				continue
+			}
+			key := alertKey{ruleId: rule.Id, serverId: observation.serverId, subject: observation.subject}
+			observed[key] = true
+			if !alertBreached(rule, observation.value) {
+				if alert, ok := firing[key]; ok {
+					errs = append(errs, s.resolve(rule, alert))
+				}
+				continue
+			}
+
+			breached[key] = true
+			if _, ok := firing[key]; ok {
+				continue
+			}
+			since, ok := alertPending[key]
+			if !ok {
+				since = now
+				alertPending[key] = now
+			}
+			if now.Sub(since) >= time.Duration(rule.Duration)*time.Minute {
+				delete(alertPending, key)
+				errs = append(errs, s.fire(rule, observation))
+			}
+		}
+
+		// Alerts of clients, certificates or servers that are gone resolve, alerts of
+		// servers that could not be polled keep their state
+		for key, alert := range firing {
+			if key.ruleId == rule.Id && !observed[key] && !unknown[rule.Metric][key.serverId] {
+				errs = append(errs, s.resolve(rule, alert))
+			}
+		}
+	}
+	for key := range alertPending {
+		if !breached[key] {
+			delete(alertPending, key)
+		}
+	}
+	return common.Combine(errs...)
+}
+
+// TestChannel sends a test notification through one channel.
+func (s *AlertService) TestChannel(ctx context.Context, channel string) error {
+	const text = "This is a test alert from the 3x-ui panel."
+	switch channel {
+	case "telegram":
+		if !s.tgbotService.IsRunning() {
+			return fmt.Errorf("telegram bot is not running")
+		}
+		s.tgbotService.SendMsgToTgbotAdmins(text)
+		return nil
+	case "webhook":
+		return s.sendWebhook(ctx, map[string]any{"status": "test", "message": text})
+	case "email":
+		return s.sendEmail("[test] 3x-ui alert", text)
+	default:
+		return fmt.Errorf("unknown alert channel %q", channel)
+	}
+}
+
+// observe returns the values of a metric on servers, and the servers it is unknown for
+// because they could not be polled or are not reporting.
+func (s *AlertService) observe(ctx context.Context, metric string, servers []*model.Server) ([]*alertObservation, map[int]bool) {
+	observations := make([]*alertObservation, 0, len(servers))
+	unknown := make(map[int]bool)
+	switch metric {
+	case "server_down":
+		for _, server := range servers {
+			down := 0.0
+			if server.Status == "offline" || server.Status == "error" {
+				down = 1
+			}
+			observations = append(observations, &alertObservation{serverId: server.Id, value: down})
+		}
+
+	case "cpu", "memory", "swap", "disk":
+		now := time.Now()
+		fleetMu.RLock()
+		for _, server := range servers {
+			snapshot := fleetSnapshots[server.Id]
+			if server.Status != "online" || snapshot == nil || snapshot.stats == nil || len(snapshot.history) == 0 ||
+				now.Sub(time.Unix(snapshot.history[len(snapshot.history)-1].Time, 0)) > metricSampleMaxAge {
+				unknown[server.Id] = true
+				continue
+			}
+			value := snapshot.stats.CPUUsage
+			if metric == "memory" {
+				value = snapshot.stats.MemUsage
+			} else if metric == "swap" {
+				value = snapshot.stats.SwapUsage
+			} else if metric == "disk" {
+				value = snapshot.stats.DiskUsage
+			}
+			observations = append(observations, &alertObservation{serverId: server.Id, value: value})
+		}
+		fleetMu.RUnlock()
+
+	case "traffic_quota":
+		return s.observeSeries(ctx, alertQuotas, alertQuotaRefresh, servers, s.fetchQuotas)
+	case "cert_expiry":
+		return s.observeSeries(ctx, alertCerts, alertCertRefresh, servers, s.fetchCerts)
+	}
+	return observations, unknown
+}
+
+// observeSeries returns the cached observations of servers, polling the servers whose
+// cache is older than refresh, alertPollConcurrency at a time.
+func (s *AlertService) observeSeries(ctx context.Context, cache map[int]*alertSeries, refresh time.Duration, servers []*model.Server,
+	fetch func(ctx context.Context, serverId int) ([]*alertObservation, error)) ([]*alertObservation, map[int]bool) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, alertPollConcurrency)
+	for _, server := range servers {
+		if series, ok := cache[server.Id]; ok && time.Since(series.fetchedAt) < refresh {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(serverId int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			pollCtx, cancel := context.WithTimeout(ctx, alertPollTimeout)
+			defer cancel()
+			observations, err := fetch(pollCtx, serverId)
+
+			mu.Lock()
+			defer mu.Unlock()
+			cache[serverId] = &alertSeries{fetchedAt: time.Now(), observations: observations, err: err}
+		}(server.Id)
+	}
+	wg.Wait()
+
+	observations := make([]*alertObservation, 0)
+	unknown := make(map[int]bool)
+	for _, server := range servers {
+		series := cache[server.Id]
+		if series.err != nil {
+			unknown[server.Id] = true
+			continue
+		}
+		observations = append(observations, series.observations...)
+	}
+	return observations, unknown
+}
+
+// fetchQuotas returns the percentage of their traffic quota the clients of a server used.
+func (s *AlertService) fetchQuotas(ctx context.Context, serverId int) ([]*alertObservation, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return nil, err
+	}
+	traffics, _, err := connector.GetClientTrafficsSince(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	observations := make([]*alertObservation, 0, len(traffics))
+	for _, traffic := range traffics {
+		if traffic.Total <= 0 || !traffic.Enable {
+			continue
+		}
+		observations = append(observations, &alertObservation{
+			serverId: serverId,
+			subject:  traffic.Email,
+			value:    float64(traffic.Up+traffic.Down) * 100 / float64(traffic.Total),
+		})
+	}
+	return observations, nil
+}
+
+// fetchCerts returns the days until the certificates of a server expire.
+func (s *AlertService) fetchCerts(ctx context.Context, serverId int) ([]*alertObservation, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := connector.GetCerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	observations := make([]*alertObservation, 0, len(certs))
+	for _, cert := range certs {
+		observations = append(observations, &alertObservation{
+			serverId: serverId,
+			subject:  cert.Domain,
+			value:    time.Until(time.Unix(cert.NotAfter, 0)).Hours() / 24,
+		})
+	}
+	return observations, nil
+}
+
+// alertBreached reports whether value crosses the threshold of rule.
+func alertBreached(rule *model.AlertRule, value float64) bool {
+	switch rule.Metric {
+	case "server_down":
+		return value > 0
+	case "cert_expiry":
+		return value <= rule.Threshold
+	default:
+		return value >= rule.Threshold
+	}
+}
+
+// fire records a new alert of rule and notifies its channels.
+func (s *AlertService) fire(rule *model.AlertRule, observation *alertObservation) error {
+	alert := &model.Alert{
+		RuleId:    rule.Id,
+		ServerId:  observation.serverId,
+		Subject:   observation.subject,
+		Metric:    rule.Metric,
+		Severity:  rule.Severity,
+		Value:     observation.value,
+		Threshold: rule.Threshold,
+		StartedAt: time.Now().Unix(),
+	}
+	if err := database.GetDB().Create(alert).Error; err != nil {
+		return err
+	}
+	s.setNames(alert)
+	logger.Infof("Alert %s fired: %s", rule.Name, alertText(alert))
+	publishEvent(EventAlert, map[string]any{"status": "firing", "alert": alert})
+	s.notify(rule, alert)
+	return nil
+}
+
+// resolve ends a firing alert of rule and notifies its channels.
+func (s *AlertService) resolve(rule *model.AlertRule, alert *model.Alert) error {
+	alert.ResolvedAt = time.Now().Unix()
+	if err := database.GetDB().Model(alert).Update("resolved_at", alert.ResolvedAt).Error; err != nil {
+		return err
+	}
+	s.setNames(alert)
+	logger.Infof("Alert %s resolved: %s", rule.Name, alertText(alert))
+	publishEvent(EventAlert, map[string]any{"status": "resolved", "alert": alert})
+	s.notify(rule, alert)
+	return nil
+}
+
+// resolveRule ends the firing alerts of a changed or deleted rule without notifying.
+func (s *AlertService) resolveRule(ruleId int) error {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	for key := range alertPending {
+		if key.ruleId == ruleId {
+			delete(alertPending, key)
+		}
+	}
+	return database.GetDB().Model(model.Alert{}).
+		Where("rule_id = ? AND resolved_at = 0", ruleId).
+		Update("resolved_at", time.Now().Unix()).Error
+}
+
+// notify sends an alert that fired or resolved to the channels of rule. Failures are
+// only logged so one channel does not hold up the others.
+func (s *AlertService) notify(rule *model.AlertRule, alert *model.Alert) {
+	status := "firing"
+	if alert.ResolvedAt != 0 {
+		status = "resolved"
+	}
+	text := alertText(alert)
+
+	for _, channel := range strings.Split(rule.Channels, ",") {
+		var err error
+		switch channel {
+		case "telegram":
+			if !s.tgbotService.IsRunning() {
+				continue
+			}
+			key := "tgbot.messages.alertFiring"
+			if status == "resolved" {
+				key = "tgbot.messages.alertResolved"
+			}
+			s.tgbotService.SendMsgToTgbotAdmins(s.tgbotService.I18nBot(key,
+				"Rule=="+rule.Name,
+				"Severity=="+alert.Severity,
+				"Details=="+text))
+		case "webhook":
+			ctx, cancel := context.WithTimeout(context.Background(), alertNotifyTimeout)
+			err = s.sendWebhook(ctx, map[string]any{"status": status, "rule": rule.Name, "message": text, "alert": alert})
+			cancel()
+		case "email":
+			err = s.sendEmail(fmt.Sprintf("[%s] %s %s", alert.Severity, rule.Name, status), text)
+		}
+		if err != nil {
+			logger.Warningf("Failed to send alert %s through %s: %v", rule.Name, channel, err)
+		}
+	}
+}
+
+// sendWebhook POSTs payload as JSON to the alert webhook.
+func (s *AlertService) sendWebhook(ctx context.Context, payload any) error {
+	url, err := s.settingService.GetAlertWebhookUrl()
+	if err != nil {
+		return err
+	}
+	if url == "" {
+		return fmt.Errorf("no alert webhook configured")
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendEmail mails an alert to the alert recipients. Port 465 uses TLS from the start,
+// other ports upgrade with STARTTLS when the server offers it.
+func (s *AlertService) sendEmail(subject, body string) error {
+	host, err := s.settingService.GetAlertSmtpHost()
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("no alert SMTP server configured")
+	}
+	port, _ := s.settingService.GetAlertSmtpPort()
+	username, _ := s.settingService.GetAlertSmtpUsername()
+	password, _ := s.settingService.GetAlertSmtpPassword()
+	from, _ := s.settingService.GetAlertEmailFrom()
+	to, _ := s.settingService.GetAlertEmailTo()
+	recipients := make([]string, 0)
+	for _, recipient := range strings.Split(to, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	if from == "" || len(recipients) == 0 {
+		return fmt.Errorf("alert emails need a sender and recipients")
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{Timeout: alertNotifyTimeout}
+	var conn net.Conn
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(3 * alertNotifyTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if username != "" {
+		if err := client.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	msg := "From: " + from + "\r\n" +
+		"To: " + strings.Join(recipients, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		body + "\r\n"
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// alertText describes an alert, e.g. "cpu on server edge-1 is 93.5% (threshold 90%)".
+func alertText(alert *model.Alert) string {
+	target := "server " + alert.ServerName
+	if alert.Subject != "" {
+		target = alert.Subject + " on " + target
+	}
+	switch alert.Metric {
+	case "server_down":
+		if alert.ResolvedAt != 0 {
+			return target + " is back online"
+		}
+		return target + " is down"
+	case "cert_expiry":
+		return fmt.Sprintf("certificate %s expires in %.0f days (threshold %g days)", target, alert.Value, alert.Threshold)
+	case "traffic_quota":
+		return fmt.Sprintf("client %s used %.1f%% of its traffic quota (threshold %g%%)", target, alert.Value, alert.Threshold)
+	default:
+		return fmt.Sprintf("%s on %s is %.1f%% (threshold %g%%)", alert.Metric, target, alert.Value, alert.Threshold)
+	}
+}
+
+// validateRule checks and normalizes a rule's fields before it is saved.
+func (s *AlertService) validateRule(rule *model.AlertRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if !slices.Contains(alertMetrics, rule.Metric) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(alertMetrics, ", "))
+	}
+	switch rule.Metric {
+	case "cpu", "memory", "swap", "disk", "traffic_quota":
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return fmt.Errorf("threshold of %s must be a percentage between 0 and 100", rule.Metric)
+		}
+	case "cert_expiry":
+		if rule.Threshold < 0 {
+			return fmt.Errorf("threshold of cert_expiry must be days and not negative")
+		}
+	}
+	if rule.Duration < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+	if !slices.Contains(alertSeverities, rule.Severity) {
+		return fmt.Errorf("severity must be one of %s", strings.Join(alertSeverities, ", "))
+	}
+	if rule.ServerId != 0 {
+		if _, err := s.serverMgmt.GetServer(rule.ServerId); err != nil {
+			return fmt.Errorf("server %d not found", rule.ServerId)
+		}
+	}
+
+	channels := make([]string, 0, len(alertChannels))
+	for _, channel := range strings.Split(rule.Channels, ",") {
+		channel = strings.TrimSpace(channel)
+		if channel == "" || slices.Contains(channels, channel) {
+			continue
+		}
+		if !slices.Contains(alertChannels, channel) {
+			return fmt.Errorf("channels must be some of %s", strings.Join(alertChannels, ", "))
+		}
+		channels = append(channels, channel)
+	}
+	if len(channels) == 0 {
+		channels = append(channels, "telegram")
+	}
+	rule.Channels = strings.Join(channels, ",")
+	return nil
+}
+
+// setNames attaches the names of the rule and the server to alerts.
+func (s *AlertService) setNames(alerts ...*model.Alert) {
+	var servers []*model.Server
+	var rules []*model.AlertRule
+	db := database.GetDB()
+	if err := db.Model(model.Server{}).Select("id", "name").Find(&servers).Error; err != nil {
+		return
+	}
+	if err := db.Model(model.AlertRule{}).Select("id", "name").Find(&rules).Error; err != nil {
+		return
+	}
+	serverNames := make(map[int]string, len(servers))
+	for _, server := range servers {
+		serverNames[server.Id] = server.Name
+	}
+	ruleNames := make(map[int]string, len(rules))
+	for _, rule := range rules {
+		ruleNames[rule.Id] = rule.Name
+	}
+	for _, alert := range alerts {
+		alert.ServerName = serverNames[alert.ServerId]
+		alert.RuleName = ruleNames[alert.RuleId]
+	}
+}
@@ -0,0 +1,134 @@
+// Package service provides encryption at rest and secret resolution for server auth data.
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/secrets"
+)
+
+// authDataPrefix marks auth data encrypted with the XUI_AUTHDATA_KEY.
+const authDataPrefix = "enc:v1:"
+
+// secretResolveTimeout bounds fetching auth data secrets from an external store.
+const secretResolveTimeout = 10 * time.Second
+
+// authDataAEAD returns the cipher for auth data, or nil if no key is configured.
+// The key may itself be a secret reference; it is hashed into an AES-256 key.
+func authDataAEAD(ctx context.Context) (cipher.AEAD, error) {
+	return authDataKeyAEAD(ctx, "")
+}
+
+// authDataKeyAEAD returns an AES-256-GCM cipher keyed with the SHA-256 of purpose and
+// the XUI_AUTHDATA_KEY, or nil if no key is configured. Distinct purposes give
+// independent keys for other data encrypted with the same secret.
+func authDataKeyAEAD(ctx context.Context, purpose string) (cipher.AEAD, error) {
+	keyRef := config.GetAuthDataKey()
+	if keyRef == "" {
+		return nil, nil
+	}
+	key, err := secrets.Resolve(ctx, keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("auth data key: %w", err)
+	}
+	if key == "" {
+		return nil, errors.New("auth data key is empty")
+	}
+	sum := sha256.Sum256([]byte(purpose + key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAuthData encrypts auth data for storage. Empty values, secret references and
+// already encrypted values are stored as is, as is everything when no key is set.
+func sealAuthData(authData string) (string, error) {
+	if authData == "" || strings.HasPrefix(authData, authDataPrefix) || secrets.IsReference(authData) {
+		return authData, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	aead, err := authDataAEAD(ctx)
+	if err != nil || aead == nil {
+		return authData, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(authData), nil)
+	return authDataPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openAuthData returns the usable auth data of a server: decrypted if it was stored
+// encrypted, and fetched from the secret store if it is a secret reference.
+func openAuthData(ctx context.Context, authData string) (string, error) {
+	if encoded, ok := strings.CutPrefix(authData, authDataPrefix); ok {
+		aead, err := authDataAEAD(ctx)
+		if err != nil {
+			return "", err
+		}
+		if aead == nil {
+			return "", errors.New("auth data is encrypted but XUI_AUTHDATA_KEY is not set")
+		}
+		sealed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return "", errors.New("auth data is corrupted")
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return "", errors.New("failed to decrypt auth data: wrong XUI_AUTHDATA_KEY?")
+		}
+		authData = string(plain)
+	}
+	return secrets.Resolve(ctx, authData)
+}
+
+// resolveSecret resolves a single auth data field that may be a secret reference.
+func resolveSecret(value string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	return secrets.Resolve(ctx, value)
+}
+
+// SealStoredAuthData encrypts the auth data of servers saved before XUI_AUTHDATA_KEY
+// was set. It does nothing without a key.
+func (s *ServerManagementService) SealStoredAuthData() error {
+	if config.GetAuthDataKey() == "" {
+		return nil
+	}
+	db := database.GetDB()
+	var servers []*model.Server
+	if err := db.Model(model.Server{}).Where("auth_data <> '' AND auth_data NOT LIKE ?", authDataPrefix+"%").Find(&servers).Error; err != nil {
+		return err
+	}
+	for _, server := range servers {
+		sealed, err := sealAuthData(server.AuthData)
+		if err != nil {
+			return err
+		}
+		if sealed == server.AuthData {
+			continue
+		}
+		if err := db.Model(model.Server{}).Where("id = ?", server.Id).Update("auth_data", sealed).Error; err != nil {
+			return err
+		}
+		logger.Infof("Encrypted stored auth data of server %s", server.Name)
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+// Package service provides BillingService for per-period traffic billing exports.
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/common"
+	"github.com/cofedish/3x-UI-agents/xray"
+)
+
+const (
+	// trafficSampleRetention is how long client traffic samples are kept for billing.
+	trafficSampleRetention = 400 * 24 * time.Hour
+	// trafficSampleHourlyRetention is how long every hourly sample is kept. Older samples
+	// are thinned to the last one of each day, so old periods are billed by the day.
+	trafficSampleHourlyRetention = 35 * 24 * time.Hour
+)
+
+var (
+	// trafficCursorsMu guards trafficCursors.
+	trafficCursorsMu sync.Mutex
+	// trafficCursors holds the sync cursor of each server's client traffics. It starts
+	// empty, so the first sync after a restart fetches a full snapshot.
+	trafficCursors = make(map[int]int64)
+)
+
+// BillingService records client traffic samples and reset events and turns them
+// into per-client, per-server usage for a billing period.
+type BillingService struct {
+	serverMgmt     ServerManagementService
+	settingService SettingService
+}
+
+// BillingReport is the usage of every client on every server within a period.
+type BillingReport struct {
+	From   int64                      `json:"from"` // Unix timestamp (inclusive)
+	To     int64                      `json:"to"`   // Unix timestamp (inclusive)
+	Rows   []*BillingRow              `json:"rows"`
+	Resets []*model.TrafficResetEvent `json:"resets,omitempty"`
+}
+
+// BillingRow is the usage of one client on one server within the report period.
+type BillingRow struct {
+	ServerId   int      `json:"serverId"`
+	ServerName string   `json:"serverName"`
+	ServerTags []string `json:"serverTags"`
+	InboundId  int      `json:"inboundId"`
+	Email      string   `json:"email"`
+	Usage      int64    `json:"usage"`      // Bytes used within the period
+	StartTotal int64    `json:"startTotal"` // All-time counter at the start of the period
+	EndTotal   int64    `json:"endTotal"`   // All-time counter at the end of the period
+	Resets     int      `json:"resets"`     // Number of traffic resets within the period
+	Partial    bool     `json:"partial"`    // Sampling started after the period began
+}
+
+// RecordSamples stores a sample of the counters of every client that changed since the
+// previous sync on all enabled servers, and prunes samples past the retention period.
+// Unchanged clients are covered by their previous sample.
+func (s *BillingService) RecordSamples(ctx context.Context) error {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	db := database.GetDB()
+	errs := make([]error, 0)
+
+	for _, server := range servers {
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+		trafficCursorsMu.Lock()
+		since := trafficCursors[server.Id]
+		trafficCursorsMu.Unlock()
+
+		traffics, cursor, err := connector.GetClientTrafficsSince(ctx, since)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+
+		samples := make([]*model.ClientTrafficSample, 0, len(traffics))
+		for _, traffic := range traffics {
+			samples = append(samples, &model.ClientTrafficSample{
+				ServerId:  server.Id,
+				Email:     traffic.Email,
+				InboundId: traffic.InboundId,
+				Up:        traffic.Up,
+				Down:      traffic.Down,
+				AllTime:   max(traffic.AllTime, traffic.Up+traffic.Down),
+				CreatedAt: now,
+			})
+		}
+		if len(samples) > 0 {
+			if err := db.CreateInBatches(samples, 100).Error; err != nil {
+				errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+				continue
+			}
+		}
+
+		trafficCursorsMu.Lock()
+		trafficCursors[server.Id] = cursor
+		trafficCursorsMu.Unlock()
+	}
+
+	if err := s.pruneSamples(time.Now()); err != nil {
+		errs = append(errs, err)
+	}
+
+	return common.Combine(errs...)
+}
+
+// pruneSamples drops samples and reset events past the retention period and thins samples
+// past the hourly retention period to the last sample of each day in the panel timezone.
+// The latest sample of every client is always kept: it stays its current counter until
+// it changes.
+func (s *BillingService) pruneSamples(now time.Time) error {
+	db := database.GetDB()
+	errs := make([]error, 0)
+
+	cutoff := now.Add(-trafficSampleRetention).Unix()
+	latest := db.Model(model.ClientTrafficSample{}).Select("MAX(id)").Group("server_id, email")
+	if err := db.Where("created_at < ? AND id NOT IN (?)", cutoff, latest).Delete(model.ClientTrafficSample{}).Error; err != nil {
+		errs = append(errs, err)
+	}
+	if err := db.Where("created_at < ?", cutoff).Delete(model.TrafficResetEvent{}).Error; err != nil {
+		errs = append(errs, err)
+	}
+
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	_, offset := now.In(loc).Zone()
+	hourlyCutoff := now.Add(-trafficSampleHourlyRetention).Unix()
+	daily := db.Model(model.ClientTrafficSample{}).Select("MAX(id)").Where("created_at < ?", hourlyCutoff).
+		Group(fmt.Sprintf("server_id, email, (created_at + %d) / 86400", offset))
+	if err := db.Where("created_at < ? AND id NOT IN (?)", hourlyCutoff, daily).Delete(model.ClientTrafficSample{}).Error; err != nil {
+		errs = append(errs, err)
+	}
+
+	return common.Combine(errs...)
+}
+
+// RecordResetEvents stores a reset event for an inbound and one for each of its clients.
+// clientStats holds the counters right before the reset.
+func (s *BillingService) RecordResetEvents(serverId int, inbound *model.Inbound, clientStats []xray.ClientTraffic, reason string) error {
+	events := []*model.TrafficResetEvent{{
+		ServerId:  serverId,
+		InboundId: inbound.Id,
+		Up:        inbound.Up,
+		Down:      inbound.Down,
+		Reason:    reason,
+	}}
+	for _, stat := range clientStats {
+		events = append(events, &model.TrafficResetEvent{
+			ServerId:  serverId,
+			InboundId: inbound.Id,
+			Email:     stat.Email,
+			Up:        stat.Up,
+			Down:      stat.Down,
+			Reason:    reason,
+		})
+	}
+	return database.GetDB().Create(events).Error
+}
+
+// RecordClientResetEvent stores a reset event for a single client.
+// traffic holds the client's counters right before the reset.
+func (s *BillingService) RecordClientResetEvent(serverId int, traffic *xray.ClientTraffic, reason string) error {
+	return database.GetDB().Create(&model.TrafficResetEvent{
+		ServerId:  serverId,
+		InboundId: traffic.InboundId,
+		Email:     traffic.Email,
+		Up:        traffic.Up,
+		Down:      traffic.Down,
+		Reason:    reason,
+	}).Error
+}
+
+// GetLocalClientStats returns the client counters of a local inbound.
+func (s *BillingService) GetLocalClientStats(inboundId int) ([]xray.ClientTraffic, error) {
+	var stats []xray.ClientTraffic
+	err := database.GetDB().Model(xray.ClientTraffic{}).Where("inbound_id = ?", inboundId).Find(&stats).Error
+	return stats, err
+}
+
+// GetBillingReport computes the usage of every client within [from, to], on the servers
+// matching filter.
+// A serverId of 0 includes all servers.
+func (s *BillingService) GetBillingReport(from, to time.Time, serverId int, filter ServerFilter, includeResets bool) (*BillingReport, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("period end must be after its start")
+	}
+
+	report := &BillingReport{
+		From: from.Unix(),
+		To:   to.Unix(),
+		Rows: make([]*BillingRow, 0),
+	}
+
+	// all_time never decreases, so the usage in a period is the last sample at or before
+	// its end minus the last sample at or before its start.
+	endSamples, err := s.lastSamples(serverId, "created_at <= ?", report.To)
+	if err != nil {
+		return nil, err
+	}
+	startSamples, err := s.lastSamples(serverId, "created_at <= ?", report.From)
+	if err != nil {
+		return nil, err
+	}
+	firstSamples, err := s.firstSamples(serverId, report.From, report.To)
+	if err != nil {
+		return nil, err
+	}
+
+	resets, err := s.getResetEvents(serverId, report.From, report.To)
+	if err != nil {
+		return nil, err
+	}
+	resetCounts := make(map[string]int)
+	for _, event := range resets {
+		if event.Email != "" {
+			resetCounts[sampleKey(event.ServerId, event.Email)]++
+		}
+	}
+
+	servers, err := s.serverMgmt.GetServersByFilter(filter, false)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[int]*model.Server, len(servers))
+	for _, server := range servers {
+		selected[server.Id] = server
+	}
+
+	for key, end := range endSamples {
+		server, ok := selected[end.ServerId]
+		if !ok && !filter.IsEmpty() {
+			continue
+		}
+		row := &BillingRow{
+			ServerId:  end.ServerId,
+			InboundId: end.InboundId,
+			Email:     end.Email,
+			EndTotal:  end.AllTime,
+			Resets:    resetCounts[key],
+		}
+		if server != nil {
+			row.ServerName, row.ServerTags = server.Name, ServerTags(server)
+		}
+		if start, ok := startSamples[key]; ok {
+			row.StartTotal = start.AllTime
+		} else if first, ok := firstSamples[key]; ok {
+			// No sample before the period: count from the first one within it
+			row.StartTotal = first.AllTime
+			row.Partial = true
+		} else {
+			continue
+		}
+		row.Usage = max(row.EndTotal-row.StartTotal, 0)
+		report.Rows = append(report.Rows, row)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].ServerId != report.Rows[j].ServerId {
+			return report.Rows[i].ServerId < report.Rows[j].ServerId
+		}
+		return report.Rows[i].Email < report.Rows[j].Email
+	})
+
+	if includeResets {
+		report.Resets = resets
+		if !filter.IsEmpty() {
+			report.Resets = slices.DeleteFunc(resets, func(event *model.TrafficResetEvent) bool {
+				return selected[event.ServerId] == nil
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// WriteBillingCSV writes the report rows (and reset events if present) as CSV.
+func (s *BillingService) WriteBillingCSV(w io.Writer, report *BillingReport) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"server_id", "server_name", "inbound_id", "email", "usage_bytes", "start_total", "end_total", "resets", "partial", "server_tags"}); err != nil {
+		return err
+	}
+	for _, row := range report.Rows {
+		if err := writer.Write([]string{
+			strconv.Itoa(row.ServerId),
+			row.ServerName,
+			strconv.Itoa(row.InboundId),
+			row.Email,
+			strconv.FormatInt(row.Usage, 10),
+			strconv.FormatInt(row.StartTotal, 10),
+			strconv.FormatInt(row.EndTotal, 10),
+			strconv.Itoa(row.Resets),
+			strconv.FormatBool(row.Partial),
+			strings.Join(row.ServerTags, ";"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if len(report.Resets) > 0 {
+		// Separate section so the usage table stays machine-readable on its own
+		if err := writer.Write(nil); err != nil {
+			return err
+		}
+		if err := writer.Write([]string{"reset_time", "server_id", "inbound_id", "email", "up", "down", "reason"}); err != nil {
+			return err
+		}
+		for _, event := range report.Resets {
+			if err := writer.Write([]string{
+				time.Unix(event.CreatedAt, 0).UTC().Format(time.RFC3339),
+				strconv.Itoa(event.ServerId),
+				strconv.Itoa(event.InboundId),
+				event.Email,
+				strconv.FormatInt(event.Up, 10),
+				strconv.FormatInt(event.Down, 10),
+				event.Reason,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// lastSamples returns the most recent sample per server and client matching the condition.
+func (s *BillingService) lastSamples(serverId int, condition string, args ...any) (map[string]*model.ClientTrafficSample, error) {
+	db := database.GetDB()
+	ids := db.Model(model.ClientTrafficSample{}).Select("MAX(id)").Where(condition, args...).Group("server_id, email")
+	if serverId != 0 {
+		ids = ids.Where("server_id = ?", serverId)
+	}
+
+	var samples []*model.ClientTrafficSample
+	if err := db.Model(model.ClientTrafficSample{}).Where("id IN (?)", ids).Find(&samples).Error; err != nil {
+		return nil, err
+	}
+	return indexSamples(samples), nil
+}
+
+// firstSamples returns the earliest sample per server and client within (from, to].
+func (s *BillingService) firstSamples(serverId int, from, to int64) (map[string]*model.ClientTrafficSample, error) {
+	db := database.GetDB()
+	ids := db.Model(model.ClientTrafficSample{}).Select("MIN(id)").
+		Where("created_at > ? AND created_at <= ?", from, to).Group("server_id, email")
+	if serverId != 0 {
+		ids = ids.Where("server_id = ?", serverId)
+	}
+
+	var samples []*model.ClientTrafficSample
+	if err := db.Model(model.ClientTrafficSample{}).Where("id IN (?)", ids).Find(&samples).Error; err != nil {
+		return nil, err
+	}
+	return indexSamples(samples), nil
+}
+
+// getResetEvents returns reset events within [from, to].
+func (s *BillingService) getResetEvents(serverId int, from, to int64) ([]*model.TrafficResetEvent, error) {
+	db := database.GetDB()
+	query := db.Model(model.TrafficResetEvent{}).Where("created_at >= ? AND created_at <= ?", from, to)
+	if serverId != 0 {
+		query = query.Where("server_id = ?", serverId)
+	}
+
+	var events []*model.TrafficResetEvent
+	err := query.Order("created_at").Find(&events).Error
+	return events, err
+}
+
+// indexSamples maps samples by server and client.
+func indexSamples(samples []*model.ClientTrafficSample) map[string]*model.ClientTrafficSample {
+	indexed := make(map[string]*model.ClientTrafficSample, len(samples))
+	for _, sample := range samples {
+		indexed[sampleKey(sample.ServerId, sample.Email)] = sample
+	}
+	return indexed
+}
+
+// sampleKey identifies a client on a server.
+func sampleKey(serverId int, email string) string {
+	return strconv.Itoa(serverId) + ">>>" + email
+}
@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestPruneTrafficSamples checks that samples past the hourly retention period are thinned
+// to the last one of each day and still bill whole days exactly.
+func TestPruneTrafficSamples(t *testing.T) {
+	const serverId = 107
+	if err := service.SetSetting("timeLocation", "UTC"); err != nil {
+		t.Fatal(err)
+	}
+	defer service.SetSetting("timeLocation", "Local")
+
+	db := database.GetDB()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	samples := make([]*model.ClientTrafficSample, 0)
+	// Hourly samples for 40 days; the counter grows by 1000 bytes an hour
+	for k := 40*24 - 1; k >= 0; k-- {
+		samples = append(samples, &model.ClientTrafficSample{
+			ServerId:  serverId,
+			Email:     "sampled",
+			AllTime:   int64(40*24-k) * 1000,
+			CreatedAt: now.Add(-time.Duration(k) * time.Hour).Unix(),
+		})
+	}
+	if err := db.CreateInBatches(samples, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Where("server_id = ?", serverId).Delete(model.ClientTrafficSample{})
+
+	billingService := &service.BillingService{}
+	if err := service.PruneTrafficSamples(billingService, now); err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []*model.ClientTrafficSample
+	if err := db.Where("server_id = ?", serverId).Order("created_at").Find(&kept).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 6 days partly or fully past 35 days keep one sample each; the last 841 hours stay
+	if len(kept) != 6+841 {
+		t.Fatalf("expected %d samples, got %d", 6+841, len(kept))
+	}
+	for _, sample := range kept[1:5] {
+		if hour := time.Unix(sample.CreatedAt, 0).UTC().Hour(); hour != 23 {
+			t.Errorf("expected the last sample of the day to be kept, got %02d:00", hour)
+		}
+	}
+
+	from := time.Date(2026, 8, 23, 0, 0, 0, 0, time.UTC)
+	report, err := billingService.GetBillingReport(from, from.Add(48*time.Hour), serverId, service.ServerFilter{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].Usage != 48*1000 || report.Rows[0].Partial {
+		t.Fatalf("expected two days of usage from the daily samples, got %+v", report.Rows)
+	}
+}
@@ -0,0 +1,456 @@
+// Package service provides BlockedIPService for the fleet-wide IP blocklist.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// BlockedOutboundTag is the blackhole outbound that blocked sources are routed to.
+	BlockedOutboundTag = "blocked"
+
+	// authFailureThreshold failed panel logins within authFailureWindow trigger an automatic block.
+	authFailureThreshold = 10
+	authFailureWindow    = 10 * time.Minute
+	// authFailureBlockTTL is how long automatically blocked addresses stay blocked.
+	authFailureBlockTTL = time.Hour
+
+	// blocklistPushDelay gathers automatic blocks into one push, so a burst of blocks
+	// does not make every server reload its routing once per address.
+	blocklistPushDelay = 30 * time.Second
+	// blocklistPushTimeout bounds a scheduled push to all servers.
+	blocklistPushTimeout = 30 * time.Second
+
+	// blockMinPrefixV4 and blockMinPrefixV6 are the widest ranges that can be blocked.
+	blockMinPrefixV4 = 8
+	blockMinPrefixV6 = 32
+)
+
+var (
+	authFailuresMu sync.Mutex
+	authFailures   = make(map[string][]time.Time) // ip -> recent failure times
+
+	blocklistPushPending atomic.Bool
+)
+
+// BlockedIPService manages the blocklist kept by the panel and pushed to every server.
+// Servers apply the list as an Xray routing rule sending blocked sources to a blackhole.
+type BlockedIPService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetBlockedIPs returns all blocklist entries, including expired ones not yet cleaned up.
+func (s *BlockedIPService) GetBlockedIPs() ([]*model.BlockedIP, error) {
+	db := database.GetDB()
+	var entries []*model.BlockedIP
+	err := db.Model(model.BlockedIP{}).Order("id desc").Find(&entries).Error
+	return entries, err
+}
+
+// GetActiveBlockedIPs returns entries that have not expired.
+func (s *BlockedIPService) GetActiveBlockedIPs() ([]*model.BlockedIP, error) {
+	db := database.GetDB()
+	var entries []*model.BlockedIP
+	err := db.Model(model.BlockedIP{}).
+		Where("expires_at = 0 OR expires_at > ?", time.Now().Unix()).
+		Order("id").
+		Find(&entries).Error
+	return entries, err
+}
+
+// AddBlockedIP blocks an address or CIDR. A ttl of zero blocks it permanently.
+// Blocking an already listed address updates its reason and expiry.
+func (s *BlockedIPService) AddBlockedIP(ip, reason, source string, ttl time.Duration) (*model.BlockedIP, error) {
+	normalized, err := normalizeBlockedIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBlockableIP(normalized); err != nil {
+		return nil, err
+	}
+	if source == "" {
+		source = "manual"
+	}
+	if source != "manual" && source != "auto" {
+		return nil, fmt.Errorf("invalid source %q (must be manual or auto)", source)
+	}
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	db := database.GetDB()
+	entry := &model.BlockedIP{}
+	err = db.Model(model.BlockedIP{}).Where("ip = ?", normalized).First(entry).Error
+	if err != nil && !database.IsNotFound(err) {
+		return nil, err
+	}
+
+	entry.IP = normalized
+	entry.Reason = reason
+	entry.Source = source
+	entry.ExpiresAt = expiresAt
+	if err := db.Save(entry).Error; err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// DeleteBlockedIP removes a blocklist entry.
+func (s *BlockedIPService) DeleteBlockedIP(id int) error {
+	db := database.GetDB()
+	result := db.Delete(model.BlockedIP{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("blocked IP %d not found", id)
+	}
+	return nil
+}
+
+// DeleteExpired removes expired entries and returns how many were removed.
+func (s *BlockedIPService) DeleteExpired() (int64, error) {
+	db := database.GetDB()
+	result := db.Where("expires_at > 0 AND expires_at <= ?", time.Now().Unix()).Delete(model.BlockedIP{})
+	return result.RowsAffected, result.Error
+}
+
+// ReplaceBlockedIPs replaces the local blocklist with entries pushed by the panel.
+// It reports whether the active list changed, so callers know if Xray must be reloaded.
+func (s *BlockedIPService) ReplaceBlockedIPs(entries []*model.BlockedIP) (bool, error) {
+	before, err := s.GetActiveBlockedIPs()
+	if err != nil {
+		return false, err
+	}
+
+	db := database.GetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(model.BlockedIP{}).Error; err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			ip, err := normalizeBlockedIP(entry.IP)
+			if err != nil {
+				return err
+			}
+			if err := checkBlockableIP(ip); err != nil {
+				logger.Warning("Skipping blocklist entry:", err)
+				continue
+			}
+			record := &model.BlockedIP{
+				IP:        ip,
+				Reason:    entry.Reason,
+				Source:    entry.Source,
+				ExpiresAt: entry.ExpiresAt,
+			}
+			if err := tx.Create(record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	after, err := s.GetActiveBlockedIPs()
+	if err != nil {
+		return false, err
+	}
+	return !equalBlockedAddresses(before, after), nil
+}
+
+// PushBlockedIPs sends the active blocklist to every enabled server.
+func (s *BlockedIPService) PushBlockedIPs(ctx context.Context) error {
+	entries, err := s.GetActiveBlockedIPs()
+	if err != nil {
+		return err
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, server := range servers {
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+		if err := connector.SyncBlockedIPs(ctx, entries); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+		}
+	}
+
+	return common.Combine(errs...)
+}
+
+// SchedulePush pushes the blocklist to every enabled server after blocklistPushDelay.
+// Entries added until then go out with the same push.
+func (s *BlockedIPService) SchedulePush() {
+	if !blocklistPushPending.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(blocklistPushDelay, func() {
+		blocklistPushPending.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), blocklistPushTimeout)
+		defer cancel()
+		if err := s.PushBlockedIPs(ctx); err != nil {
+			logger.Warning("Failed to push blocklist to some servers:", err)
+		}
+	})
+}
+
+// RecordAuthFailure counts a failed panel login from ip and blocks the address
+// fleet-wide once it fails too often. It returns true if the address was blocked.
+func (s *BlockedIPService) RecordAuthFailure(ip string) bool {
+	// Logins through a local proxy or an SSH tunnel come from loopback, which must stay
+	// reachable for the panel's own Xray API connection
+	if net.ParseIP(ip) == nil || checkBlockableIP(ip) != nil {
+		return false
+	}
+
+	authFailuresMu.Lock()
+	now := time.Now()
+	recent := make([]time.Time, 0, authFailureThreshold)
+	for _, t := range authFailures[ip] {
+		if now.Sub(t) < authFailureWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	blocked := len(recent) >= authFailureThreshold
+	if blocked {
+		delete(authFailures, ip)
+	} else {
+		authFailures[ip] = recent
+	}
+	authFailuresMu.Unlock()
+
+	if !blocked {
+		return false
+	}
+
+	reason := fmt.Sprintf("%d failed panel logins within %s", authFailureThreshold, authFailureWindow)
+	if _, err := s.AddBlockedIP(ip, reason, "auto", authFailureBlockTTL); err != nil {
+		logger.Warning("Failed to block IP after repeated login failures:", err)
+		return false
+	}
+	logger.Warningf("Blocked %s across all servers: %s", ip, reason)
+	return true
+}
+
+// applyBlockedIPs adds a routing rule sending blocked sources to the blackhole outbound.
+// The outbound and the RoutingService API are always added, so later blocklist changes
+// only touch the routing rules and can be applied without restarting Xray.
+func (s *BlockedIPService) applyBlockedIPs(xrayConfig *xray.Config) error {
+	entries, err := s.GetActiveBlockedIPs()
+	if err != nil {
+		return err
+	}
+	if err := enableRoutingService(xrayConfig); err != nil {
+		return err
+	}
+
+	var outbounds []any
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+			return err
+		}
+	}
+	hasBlocked := false
+	for _, outbound := range outbounds {
+		if o, ok := outbound.(map[string]any); ok && o["tag"] == BlockedOutboundTag {
+			hasBlocked = true
+			break
+		}
+	}
+	if !hasBlocked {
+		outbounds = append(outbounds, map[string]any{
+			"protocol": "blackhole",
+			"tag":      BlockedOutboundTag,
+		})
+		outboundConfigs, err := json.Marshal(outbounds)
+		if err != nil {
+			return err
+		}
+		xrayConfig.OutboundConfigs = outboundConfigs
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	sources := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// Entries stored before the check was added must not cut off the API either
+		if checkBlockableIP(entry.IP) == nil {
+			sources = append(sources, entry.IP)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	rules, _ := routing["rules"].([]any)
+	rule := map[string]any{
+		"type":        "field",
+		"source":      sources,
+		"outboundTag": BlockedOutboundTag,
+	}
+	// The blocklist must win over every rule but the one of the API inbound, which the
+	// panel's own connection to Xray goes through
+	insertAt := apiRuleIndex(xrayConfig, rules) + 1
+	newRules := append([]any{}, rules[:insertAt]...)
+	newRules = append(newRules, rule)
+	routing["rules"] = append(newRules, rules[insertAt:]...)
+	routerConfig, err := json.Marshal(routing)
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = routerConfig
+	return nil
+}
+
+// apiRuleIndex returns the index of the routing rule of the API inbound, or -1.
+func apiRuleIndex(xrayConfig *xray.Config, rules []any) int {
+	apiTag := "api"
+	if len(xrayConfig.API) > 0 {
+		api := map[string]any{}
+		if json.Unmarshal(xrayConfig.API, &api) == nil {
+			if tag, ok := api["tag"].(string); ok && tag != "" {
+				apiTag = tag
+			}
+		}
+	}
+	for i, item := range rules {
+		rule, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		tags, _ := rule["inboundTag"].([]any)
+		for _, tag := range tags {
+			if tag == apiTag {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// blocklistRuleIndex returns the index of the routing rule of the blocklist, or -1.
+func blocklistRuleIndex(rules []any) int {
+	for i, item := range rules {
+		if rule, ok := item.(map[string]any); ok && rule["outboundTag"] == BlockedOutboundTag {
+			return i
+		}
+	}
+	return -1
+}
+
+// enableRoutingService adds RoutingService to the API services of a config that has an
+// API section, as templates saved before it was part of the default lack it.
+func enableRoutingService(xrayConfig *xray.Config) error {
+	if len(xrayConfig.API) == 0 {
+		return nil
+	}
+	api := map[string]any{}
+	if err := json.Unmarshal(xrayConfig.API, &api); err != nil {
+		return err
+	}
+	services, _ := api["services"].([]any)
+	for _, name := range services {
+		if name == "RoutingService" {
+			return nil
+		}
+	}
+	api["services"] = append(services, "RoutingService")
+	data, err := json.Marshal(api)
+	if err != nil {
+		return err
+	}
+	xrayConfig.API = data
+	return nil
+}
+
+// normalizeBlockedIP validates an address or CIDR and returns its canonical form.
+func normalizeBlockedIP(ip string) (string, error) {
+	ip = strings.TrimSpace(ip)
+	if strings.Contains(ip, "/") {
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR %q", ip)
+		}
+		return ipNet.String(), nil
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	return parsed.String(), nil
+}
+
+// checkBlockableIP rejects a normalized address or CIDR that would cut off the server
+// itself: loopback, the unspecified address and ranges wider than /8 (IPv4) or /32 (IPv6).
+func checkBlockableIP(ip string) error {
+	if !strings.Contains(ip, "/") {
+		parsed := net.ParseIP(ip)
+		if parsed != nil && (parsed.IsLoopback() || parsed.IsUnspecified()) {
+			return fmt.Errorf("%s is a loopback or unspecified address and cannot be blocked", ip)
+		}
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(ip)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q", ip)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if (bits == 32 && ones < blockMinPrefixV4) || (bits == 128 && ones < blockMinPrefixV6) {
+		return fmt.Errorf("%s is too broad to block (at most /%d for IPv4 and /%d for IPv6)", ip, blockMinPrefixV4, blockMinPrefixV6)
+	}
+	if ipNet.Contains(net.IPv4(127, 0, 0, 1)) || ipNet.Contains(net.IPv6loopback) || ipNet.IP.IsUnspecified() {
+		return fmt.Errorf("%s contains a loopback or unspecified address and cannot be blocked", ip)
+	}
+	return nil
+}
+
+// equalBlockedAddresses reports whether two entry lists block the same addresses.
+func equalBlockedAddresses(a, b []*model.BlockedIP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, entry := range a {
+		set[entry.IP] = struct{}{}
+	}
+	for _, entry := range b {
+		if _, ok := set[entry.IP]; !ok {
+			return false
+		}
+	}
+	return true
+}
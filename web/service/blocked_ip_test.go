@@ -0,0 +1,73 @@
+package service_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestBlockedIPsKeepAPIReachable checks that addresses the server needs itself cannot be
+// blocked and that the blocklist rule comes after the API inbound's rule.
+func TestBlockedIPsKeepAPIReachable(t *testing.T) {
+	blockedIPService := &service.BlockedIPService{}
+	defer database.GetDB().Where("1 = 1").Delete(model.BlockedIP{})
+
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"203.0.113.7", true},
+		{"198.51.100.0/24", true},
+		{"2001:db8::/32", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"127.0.0.0/8", false},
+		{"0.0.0.0/0", false},
+		{"96.0.0.0/4", false},
+		{"2001::/16", false},
+	}
+	for _, tt := range tests {
+		_, err := blockedIPService.AddBlockedIP(tt.ip, "test", "manual", 0)
+		if tt.blocked && err != nil {
+			t.Errorf("%s: expected the address to be blocked, got %v", tt.ip, err)
+		}
+		if !tt.blocked && err == nil {
+			t.Errorf("%s: expected the address to be refused", tt.ip)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if blockedIPService.RecordAuthFailure("127.0.0.1") {
+			t.Fatal("expected failed logins from loopback not to block it")
+		}
+	}
+
+	xrayConfig, err := (&service.XrayService{}).GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routing struct {
+		Rules []struct {
+			InboundTag  []string `json:"inboundTag"`
+			Source      []string `json:"source"`
+			OutboundTag string   `json:"outboundTag"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+		t.Fatal(err)
+	}
+	if len(routing.Rules) < 2 {
+		t.Fatalf("expected the API and blocklist rules, got %+v", routing.Rules)
+	}
+	api, blocklist := routing.Rules[0], routing.Rules[1]
+	if len(api.InboundTag) != 1 || api.InboundTag[0] != "api" {
+		t.Errorf("expected the API rule first, got %+v", api)
+	}
+	if blocklist.OutboundTag != service.BlockedOutboundTag || len(blocklist.Source) != 3 {
+		t.Errorf("expected the blocklist rule right after the API rule, got %+v", blocklist)
+	}
+}
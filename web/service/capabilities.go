@@ -0,0 +1,139 @@
+// Package service provides Xray feature capability detection for servers.
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// Xray features whose availability depends on the core version of a server.
+const (
+	FeatureVlessEncryption = "vlessEncryption" // VLESS "decryption" other than "none"
+	FeatureMLKEM768        = "mlkem768"        // ML-KEM-768 key exchange used by VLESS encryption
+	FeatureMLDSA65         = "mldsa65"         // REALITY ML-DSA-65 signatures (mldsa65Seed)
+	FeatureECH             = "ech"             // TLS Encrypted Client Hello (echServerKeys)
+)
+
+// xrayFeatureVersions lists the first Xray release supporting each feature.
+var xrayFeatureVersions = map[string][3]int{
+	FeatureVlessEncryption: {25, 8, 29},
+	FeatureMLKEM768:        {25, 8, 29},
+	FeatureMLDSA65:         {25, 5, 16},
+	FeatureECH:             {25, 7, 26},
+}
+
+// XrayCapabilities describes which optional Xray features a server supports.
+type XrayCapabilities struct {
+	XrayVersion string          `json:"xrayVersion"`
+	Known       bool            `json:"known"` // False if the version could not be parsed; nothing is rejected then
+	Features    map[string]bool `json:"features"`
+}
+
+// GetXrayCapabilities derives the supported features from an Xray version string
+// such as "25.10.15" or "v25.10.15".
+func GetXrayCapabilities(version string) *XrayCapabilities {
+	caps := &XrayCapabilities{
+		XrayVersion: version,
+		Features:    make(map[string]bool, len(xrayFeatureVersions)),
+	}
+	parsed, ok := parseXrayVersion(version)
+	caps.Known = ok
+	for feature, minVersion := range xrayFeatureVersions {
+		caps.Features[feature] = !ok || compareXrayVersions(parsed, minVersion) >= 0
+	}
+	return caps
+}
+
+// Supports reports whether the feature is available. Unknown features are assumed supported.
+func (c *XrayCapabilities) Supports(feature string) bool {
+	if c == nil || !c.Known {
+		return true
+	}
+	supported, ok := c.Features[feature]
+	return !ok || supported
+}
+
+// CheckInbound returns an error naming the first inbound option the server cannot support.
+func (c *XrayCapabilities) CheckInbound(inbound *model.Inbound) error {
+	for _, feature := range inboundFeatures(inbound) {
+		if !c.Supports(feature) {
+			minVersion := xrayFeatureVersions[feature]
+			return fmt.Errorf("server Xray %s does not support %s (requires %d.%d.%d or newer)",
+				c.XrayVersion, feature, minVersion[0], minVersion[1], minVersion[2])
+		}
+	}
+	return nil
+}
+
+// inboundFeatures returns the version-dependent features an inbound uses.
+func inboundFeatures(inbound *model.Inbound) []string {
+	var features []string
+
+	if inbound.Protocol == model.VLESS && inbound.Settings != "" {
+		var settings struct {
+			Decryption string `json:"decryption"`
+		}
+		if json.Unmarshal([]byte(inbound.Settings), &settings) == nil &&
+			settings.Decryption != "" && settings.Decryption != "none" {
+			features = append(features, FeatureVlessEncryption)
+			if strings.Contains(settings.Decryption, "mlkem768") {
+				features = append(features, FeatureMLKEM768)
+			}
+		}
+	}
+
+	if inbound.StreamSettings != "" {
+		var stream struct {
+			TlsSettings struct {
+				EchServerKeys string `json:"echServerKeys"`
+			} `json:"tlsSettings"`
+			RealitySettings struct {
+				Mldsa65Seed string `json:"mldsa65Seed"`
+			} `json:"realitySettings"`
+		}
+		if json.Unmarshal([]byte(inbound.StreamSettings), &stream) == nil {
+			if stream.TlsSettings.EchServerKeys != "" {
+				features = append(features, FeatureECH)
+			}
+			if stream.RealitySettings.Mldsa65Seed != "" {
+				features = append(features, FeatureMLDSA65)
+			}
+		}
+	}
+
+	return features
+}
+
+// parseXrayVersion parses "major.minor.patch" with an optional "v" prefix.
+func parseXrayVersion(version string) ([3]int, bool) {
+	var parsed [3]int
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+// compareXrayVersions returns -1, 0 or 1 if a is older than, equal to or newer than b.
+func compareXrayVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,472 @@
+// Package service provides ChaosConnector for injecting faults into connector calls.
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/xray"
+)
+
+// chaosDefaultHang is how long an injected timeout hangs when the call has no deadline,
+// matching the HTTP timeout of RemoteConnector.
+const chaosDefaultHang = 30 * time.Second
+
+// chaosConfig is the fault injection configured with XUI_CHAOS.
+type chaosConfig struct {
+	latency     time.Duration   // Added to every call
+	jitter      time.Duration   // Random extra latency, up to this much
+	errorRate   float64         // Share of calls failing at once, 0-1
+	timeoutRate float64         // Share of calls hanging until their deadline, 0-1
+	hang        time.Duration   // How long a timeout hangs without a deadline
+	servers     map[int]bool    // Affected servers, nil = all remote and demo servers
+	methods     map[string]bool // Affected connector methods, nil = all
+}
+
+var (
+	chaosOnce sync.Once
+	chaos     *chaosConfig
+)
+
+// loadChaos returns the fault injection settings, or nil if XUI_CHAOS is unset or invalid.
+func loadChaos() *chaosConfig {
+	chaosOnce.Do(func() {
+		spec := config.GetChaos()
+		if spec == "" {
+			return
+		}
+		cfg, err := parseChaos(spec)
+		if err != nil {
+			logger.Error("Ignoring XUI_CHAOS:", err)
+			return
+		}
+		logger.Warning("Chaos mode: injecting faults into connector calls:", spec)
+		chaos = cfg
+	})
+	return chaos
+}
+
+// parseChaos parses space-separated key=value pairs, e.g.
+// "latency=200ms jitter=300ms errors=0.05 timeouts=0.01 servers=2,5 methods=GetHealth".
+func parseChaos(spec string) (*chaosConfig, error) {
+	cfg := &chaosConfig{hang: chaosDefaultHang}
+	for _, field := range strings.Fields(spec) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", field)
+		}
+		var err error
+		switch key {
+		case "latency":
+			cfg.latency, err = time.ParseDuration(value)
+		case "jitter":
+			cfg.jitter, err = time.ParseDuration(value)
+		case "hang":
+			cfg.hang, err = time.ParseDuration(value)
+		case "errors":
+			cfg.errorRate, err = parseChaosRate(value)
+		case "timeouts":
+			cfg.timeoutRate, err = parseChaosRate(value)
+		case "servers":
+			cfg.servers = map[int]bool{}
+			for _, id := range strings.Split(value, ",") {
+				n, convErr := strconv.Atoi(id)
+				if convErr != nil {
+					return nil, fmt.Errorf("invalid server ID %q", id)
+				}
+				cfg.servers[n] = true
+			}
+		case "methods":
+			cfg.methods = map[string]bool{}
+			for _, method := range strings.Split(value, ",") {
+				cfg.methods[method] = true
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if cfg.latency < 0 || cfg.jitter < 0 || cfg.hang < 0 {
+		return nil, fmt.Errorf("durations must not be negative")
+	}
+	return cfg, nil
+}
+
+func parseChaosRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate must be between 0 and 1, got %q", value)
+	}
+	return rate, nil
+}
+
+// applies reports whether faults are injected into the connector of a server. The
+// local server is only affected when it is listed explicitly.
+func (cfg *chaosConfig) applies(server *model.Server) bool {
+	if cfg.servers != nil {
+		return cfg.servers[server.Id]
+	}
+	return !server.IsLocal()
+}
+
+// ChaosConnector wraps a connector and delays or fails its calls as configured with
+// XUI_CHAOS, so operators can check that dashboards, health checks and task retries
+// degrade gracefully before relying on them. Injected failures wrap ErrAgentUnreachable,
+// like real network errors.
+type ChaosConnector struct {
+	ServerConnector
+	serverId int
+	cfg      *chaosConfig
+}
+
+// NewChaosConnector wraps connector with fault injection if XUI_CHAOS applies to server,
+// and returns connector unchanged otherwise.
+func NewChaosConnector(connector ServerConnector, server *model.Server) ServerConnector {
+	cfg := loadChaos()
+	if cfg == nil || !cfg.applies(server) {
+		return connector
+	}
+	return &ChaosConnector{ServerConnector: connector, serverId: server.Id, cfg: cfg}
+}
+
+// inject delays the call and returns the fault chosen for it, if any.
+func (c *ChaosConnector) inject(ctx context.Context, method string) error {
+	if c.cfg.methods != nil && !c.cfg.methods[method] {
+		return nil
+	}
+
+	delay := c.cfg.latency
+	if c.cfg.jitter > 0 {
+		delay += rand.N(c.cfg.jitter)
+	}
+	roll := rand.Float64()
+	timeout := roll < c.cfg.timeoutRate
+	if timeout {
+		delay = c.cfg.hang
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("chaos: %s on server %d: %w: %w", method, c.serverId, ErrAgentUnreachable, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	switch {
+	case timeout:
+		return fmt.Errorf("chaos: %s on server %d timed out: %w: %w", method, c.serverId, ErrAgentUnreachable, context.DeadlineExceeded)
+	case roll < c.cfg.timeoutRate+c.cfg.errorRate:
+		return fmt.Errorf("chaos: %s on server %d failed: %w", method, c.serverId, ErrAgentUnreachable)
+	}
+	return nil
+}
+
+// The ServerConnector methods below inject faults before calling the wrapped connector.
+
+func (c *ChaosConnector) GetServerInfo(ctx context.Context) (*ServerInfo, error) {
+	if err := c.inject(ctx, "GetServerInfo"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetServerInfo(ctx)
+}
+
+func (c *ChaosConnector) GetHealth(ctx context.Context) (*HealthStatus, error) {
+	if err := c.inject(ctx, "GetHealth"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetHealth(ctx)
+}
+
+func (c *ChaosConnector) ListInbounds(ctx context.Context) ([]*model.Inbound, error) {
+	if err := c.inject(ctx, "ListInbounds"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.ListInbounds(ctx)
+}
+
+func (c *ChaosConnector) GetInbound(ctx context.Context, id int) (*model.Inbound, error) {
+	if err := c.inject(ctx, "GetInbound"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetInbound(ctx, id)
+}
+
+func (c *ChaosConnector) AddInbound(ctx context.Context, inbound *model.Inbound) error {
+	if err := c.inject(ctx, "AddInbound"); err != nil {
+		return err
+	}
+	return c.ServerConnector.AddInbound(ctx, inbound)
+}
+
+func (c *ChaosConnector) UpdateInbound(ctx context.Context, inbound *model.Inbound) error {
+	if err := c.inject(ctx, "UpdateInbound"); err != nil {
+		return err
+	}
+	return c.ServerConnector.UpdateInbound(ctx, inbound)
+}
+
+func (c *ChaosConnector) DeleteInbound(ctx context.Context, id int) error {
+	if err := c.inject(ctx, "DeleteInbound"); err != nil {
+		return err
+	}
+	return c.ServerConnector.DeleteInbound(ctx, id)
+}
+
+func (c *ChaosConnector) AddClient(ctx context.Context, inbound *model.Inbound) error {
+	if err := c.inject(ctx, "AddClient"); err != nil {
+		return err
+	}
+	return c.ServerConnector.AddClient(ctx, inbound)
+}
+
+func (c *ChaosConnector) UpdateClient(ctx context.Context, inbound *model.Inbound, clientIndex int) error {
+	if err := c.inject(ctx, "UpdateClient"); err != nil {
+		return err
+	}
+	return c.ServerConnector.UpdateClient(ctx, inbound, clientIndex)
+}
+
+func (c *ChaosConnector) DeleteClient(ctx context.Context, inboundId int, clientEmail string) error {
+	if err := c.inject(ctx, "DeleteClient"); err != nil {
+		return err
+	}
+	return c.ServerConnector.DeleteClient(ctx, inboundId, clientEmail)
+}
+
+func (c *ChaosConnector) ResetClientTraffic(ctx context.Context, inboundId int, email string) error {
+	if err := c.inject(ctx, "ResetClientTraffic"); err != nil {
+		return err
+	}
+	return c.ServerConnector.ResetClientTraffic(ctx, inboundId, email)
+}
+
+func (c *ChaosConnector) ResetInboundTraffic(ctx context.Context, inboundId int) error {
+	if err := c.inject(ctx, "ResetInboundTraffic"); err != nil {
+		return err
+	}
+	return c.ServerConnector.ResetInboundTraffic(ctx, inboundId)
+}
+
+func (c *ChaosConnector) GetOnlineClients(ctx context.Context) ([]string, error) {
+	if err := c.inject(ctx, "GetOnlineClients"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetOnlineClients(ctx)
+}
+
+func (c *ChaosConnector) GetOnlineClientDetails(ctx context.Context) ([]*OnlineClient, error) {
+	if err := c.inject(ctx, "GetOnlineClientDetails"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetOnlineClientDetails(ctx)
+}
+
+func (c *ChaosConnector) GetTraffic(ctx context.Context, reset bool) (*xray.Traffic, error) {
+	if err := c.inject(ctx, "GetTraffic"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetTraffic(ctx, reset)
+}
+
+func (c *ChaosConnector) GetClientTraffics(ctx context.Context) ([]*xray.ClientTraffic, error) {
+	if err := c.inject(ctx, "GetClientTraffics"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetClientTraffics(ctx)
+}
+
+func (c *ChaosConnector) GetClientTrafficsSince(ctx context.Context, since int64) ([]*xray.ClientTraffic, int64, error) {
+	if err := c.inject(ctx, "GetClientTrafficsSince"); err != nil {
+		return nil, 0, err
+	}
+	return c.ServerConnector.GetClientTrafficsSince(ctx, since)
+}
+
+func (c *ChaosConnector) GetOutboundTraffics(ctx context.Context) ([]*model.OutboundTraffics, error) {
+	if err := c.inject(ctx, "GetOutboundTraffics"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetOutboundTraffics(ctx)
+}
+
+func (c *ChaosConnector) GetConnectionCounts(ctx context.Context) (map[int]int, error) {
+	if err := c.inject(ctx, "GetConnectionCounts"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetConnectionCounts(ctx)
+}
+
+func (c *ChaosConnector) StartXray(ctx context.Context) error {
+	if err := c.inject(ctx, "StartXray"); err != nil {
+		return err
+	}
+	return c.ServerConnector.StartXray(ctx)
+}
+
+func (c *ChaosConnector) StopXray(ctx context.Context) error {
+	if err := c.inject(ctx, "StopXray"); err != nil {
+		return err
+	}
+	return c.ServerConnector.StopXray(ctx)
+}
+
+func (c *ChaosConnector) RestartXray(ctx context.Context) error {
+	if err := c.inject(ctx, "RestartXray"); err != nil {
+		return err
+	}
+	return c.ServerConnector.RestartXray(ctx)
+}
+
+func (c *ChaosConnector) GetXrayVersion(ctx context.Context) (string, error) {
+	if err := c.inject(ctx, "GetXrayVersion"); err != nil {
+		return "", err
+	}
+	return c.ServerConnector.GetXrayVersion(ctx)
+}
+
+func (c *ChaosConnector) GetXrayConfig(ctx context.Context) (string, error) {
+	if err := c.inject(ctx, "GetXrayConfig"); err != nil {
+		return "", err
+	}
+	return c.ServerConnector.GetXrayConfig(ctx)
+}
+
+func (c *ChaosConnector) GetCapabilities(ctx context.Context) (*XrayCapabilities, error) {
+	if err := c.inject(ctx, "GetCapabilities"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetCapabilities(ctx)
+}
+
+func (c *ChaosConnector) XrayAddUser(ctx context.Context, req *XrayUserRequest) error {
+	if err := c.inject(ctx, "XrayAddUser"); err != nil {
+		return err
+	}
+	return c.ServerConnector.XrayAddUser(ctx, req)
+}
+
+func (c *ChaosConnector) XrayRemoveUser(ctx context.Context, inboundTag, email string) error {
+	if err := c.inject(ctx, "XrayRemoveUser"); err != nil {
+		return err
+	}
+	return c.ServerConnector.XrayRemoveUser(ctx, inboundTag, email)
+}
+
+func (c *ChaosConnector) XrayQueryStats(ctx context.Context, pattern string) (map[string]int64, error) {
+	if err := c.inject(ctx, "XrayQueryStats"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.XrayQueryStats(ctx, pattern)
+}
+
+func (c *ChaosConnector) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	if err := c.inject(ctx, "GetSystemStats"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetSystemStats(ctx)
+}
+
+func (c *ChaosConnector) GetLogs(ctx context.Context, count int) ([]string, error) {
+	if err := c.inject(ctx, "GetLogs"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetLogs(ctx, count)
+}
+
+func (c *ChaosConnector) StreamLogs(ctx context.Context, req *LogStreamRequest, send func(*logtail.Line) error) error {
+	if err := c.inject(ctx, "StreamLogs"); err != nil {
+		return err
+	}
+	return c.ServerConnector.StreamLogs(ctx, req, send)
+}
+
+func (c *ChaosConnector) UpdateGeoFiles(ctx context.Context) error {
+	if err := c.inject(ctx, "UpdateGeoFiles"); err != nil {
+		return err
+	}
+	return c.ServerConnector.UpdateGeoFiles(ctx)
+}
+
+func (c *ChaosConnector) GetGeoFiles(ctx context.Context) ([]*GeoFileInfo, error) {
+	if err := c.inject(ctx, "GetGeoFiles"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetGeoFiles(ctx)
+}
+
+func (c *ChaosConnector) InstallXray(ctx context.Context, version string, progress ProgressFunc) error {
+	if err := c.inject(ctx, "InstallXray"); err != nil {
+		return err
+	}
+	return c.ServerConnector.InstallXray(ctx, version, progress)
+}
+
+func (c *ChaosConnector) UpdateAgent(ctx context.Context, version string, progress ProgressFunc) error {
+	if err := c.inject(ctx, "UpdateAgent"); err != nil {
+		return err
+	}
+	return c.ServerConnector.UpdateAgent(ctx, version, progress)
+}
+
+func (c *ChaosConnector) SyncBlockedIPs(ctx context.Context, entries []*model.BlockedIP) error {
+	if err := c.inject(ctx, "SyncBlockedIPs"); err != nil {
+		return err
+	}
+	return c.ServerConnector.SyncBlockedIPs(ctx, entries)
+}
+
+func (c *ChaosConnector) ApplySettings(ctx context.Context, settings *FleetSettings) error {
+	if err := c.inject(ctx, "ApplySettings"); err != nil {
+		return err
+	}
+	return c.ServerConnector.ApplySettings(ctx, settings)
+}
+
+func (c *ChaosConnector) GenerateKey(ctx context.Context, kind string, sni string) (any, error) {
+	if err := c.inject(ctx, "GenerateKey"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GenerateKey(ctx, kind, sni)
+}
+
+func (c *ChaosConnector) GenerateCert(ctx context.Context, domain string) (*CertInfo, error) {
+	if err := c.inject(ctx, "GenerateCert"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GenerateCert(ctx, domain)
+}
+
+func (c *ChaosConnector) GetCerts(ctx context.Context) ([]*CertInfo, error) {
+	if err := c.inject(ctx, "GetCerts"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.GetCerts(ctx)
+}
+
+func (c *ChaosConnector) BackupDatabase(ctx context.Context) ([]byte, error) {
+	if err := c.inject(ctx, "BackupDatabase"); err != nil {
+		return nil, err
+	}
+	return c.ServerConnector.BackupDatabase(ctx)
+}
+
+func (c *ChaosConnector) RestoreDatabase(ctx context.Context, data []byte) error {
+	if err := c.inject(ctx, "RestoreDatabase"); err != nil {
+		return err
+	}
+	return c.ServerConnector.RestoreDatabase(ctx, data)
+}
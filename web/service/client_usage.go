@@ -0,0 +1,174 @@
+// Package service provides ClientUsageService for end-user usage summaries across servers.
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// clientUsageCacheTTL limits how often one subscription can trigger a fan-out to all servers.
+const clientUsageCacheTTL = time.Minute
+
+var (
+	clientUsageMu    sync.Mutex
+	clientUsageCache = make(map[string]*cachedClientUsage) // subId -> usage
+)
+
+// cachedClientUsage is a usage summary together with the time it was built.
+type cachedClientUsage struct {
+	usage   *ClientUsage
+	builtAt time.Time
+}
+
+// ClientUsageService aggregates the usage of a subscription over every server.
+type ClientUsageService struct {
+	serverMgmt     ServerManagementService
+	inboundService InboundService
+}
+
+// ClientUsage is the usage summary of one subscription shown to its end user.
+type ClientUsage struct {
+	SubId      string               `json:"subId"`
+	Up         int64                `json:"up"`
+	Down       int64                `json:"down"`
+	Total      int64                `json:"total"`      // Quota in bytes summed over servers, 0 = unlimited
+	Remaining  int64                `json:"remaining"`  // Bytes left, -1 = unlimited
+	ExpiryTime int64                `json:"expiryTime"` // Earliest expiry in ms, 0 = never
+	LastOnline int64                `json:"lastOnline"`
+	Servers    []*ServerClientUsage `json:"servers"`
+	UpdatedAt  int64                `json:"updatedAt"` // Unix timestamp the summary was built
+}
+
+// ServerClientUsage is the usage of a subscription on a single server.
+type ServerClientUsage struct {
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Total      int64  `json:"total"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Enable     bool   `json:"enable"`
+}
+
+// GetUsageBySubId returns the usage of the clients with the given subId on all enabled servers.
+// Results are cached briefly because the endpoint is reachable without a panel login.
+func (s *ClientUsageService) GetUsageBySubId(ctx context.Context, subId string) (*ClientUsage, error) {
+	if subId == "" {
+		return nil, fmt.Errorf("subId is required")
+	}
+
+	clientUsageMu.Lock()
+	if cached, ok := clientUsageCache[subId]; ok && time.Since(cached.builtAt) < clientUsageCacheTTL {
+		clientUsageMu.Unlock()
+		return cached.usage, nil
+	}
+	clientUsageMu.Unlock()
+
+	usage, err := s.buildUsage(ctx, subId)
+	if err != nil {
+		return nil, err
+	}
+
+	clientUsageMu.Lock()
+	now := time.Now()
+	for id, cached := range clientUsageCache {
+		if now.Sub(cached.builtAt) >= clientUsageCacheTTL {
+			delete(clientUsageCache, id)
+		}
+	}
+	clientUsageCache[subId] = &cachedClientUsage{usage: usage, builtAt: now}
+	clientUsageMu.Unlock()
+
+	return usage, nil
+}
+
+// buildUsage collects the subscription's client stats from every enabled server.
+func (s *ClientUsageService) buildUsage(ctx context.Context, subId string) (*ClientUsage, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ClientUsage{
+		SubId:     subId,
+		Servers:   make([]*ServerClientUsage, 0),
+		UpdatedAt: time.Now().Unix(),
+	}
+	unlimited := false
+
+	for _, server := range servers {
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			continue
+		}
+		inbounds, err := connector.ListInbounds(ctx)
+		if err != nil {
+			// An unreachable server must not hide usage on the others
+			continue
+		}
+
+		var serverUsage *ServerClientUsage
+		for _, inbound := range inbounds {
+			if !inbound.Enable {
+				continue
+			}
+			clients, err := s.inboundService.GetClients(inbound)
+			if err != nil {
+				continue
+			}
+			for _, client := range clients {
+				if client.SubID != subId {
+					continue
+				}
+				for _, stat := range inbound.ClientStats {
+					if stat.Email != client.Email {
+						continue
+					}
+					if serverUsage == nil {
+						serverUsage = &ServerClientUsage{
+							ServerId:   server.Id,
+							ServerName: server.Name,
+						}
+						usage.Servers = append(usage.Servers, serverUsage)
+					}
+					serverUsage.Up += stat.Up
+					serverUsage.Down += stat.Down
+					serverUsage.Total += stat.Total
+					serverUsage.Enable = serverUsage.Enable || (stat.Enable && client.Enable)
+					// Negative expiry means "starts on first use" and is not a deadline yet
+					if stat.ExpiryTime > 0 && (serverUsage.ExpiryTime == 0 || stat.ExpiryTime < serverUsage.ExpiryTime) {
+						serverUsage.ExpiryTime = stat.ExpiryTime
+					}
+					if stat.Total == 0 {
+						unlimited = true
+					}
+					usage.LastOnline = max(usage.LastOnline, stat.LastOnline)
+				}
+			}
+		}
+	}
+
+	if len(usage.Servers) == 0 {
+		return nil, fmt.Errorf("no clients found with subId %s", subId)
+	}
+
+	for _, serverUsage := range usage.Servers {
+		usage.Up += serverUsage.Up
+		usage.Down += serverUsage.Down
+		usage.Total += serverUsage.Total
+		if serverUsage.ExpiryTime > 0 && (usage.ExpiryTime == 0 || serverUsage.ExpiryTime < usage.ExpiryTime) {
+			usage.ExpiryTime = serverUsage.ExpiryTime
+		}
+	}
+
+	usage.Remaining = -1
+	if unlimited {
+		usage.Total = 0
+	} else {
+		usage.Remaining = max(usage.Total-(usage.Up+usage.Down), 0)
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,123 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/agent/agenttest"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestConnectorAgainstAgent runs inbound and client changes and traffic reads through
+// the remote connector against a mock agent, authenticated by JWT and by mTLS with
+// certificates from the panel CA.
+func TestConnectorAgainstAgent(t *testing.T) {
+	t.Setenv("XUI_AUTHDATA_KEY", "connector-test-key")
+	certs, err := (&service.PanelCAService{}).IssueAgentCertificates("connector-agent", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwtAgent := agenttest.New()
+	defer jwtAgent.Close()
+	mtlsAgent, err := agenttest.NewTLS(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mtlsAgent.Close()
+
+	tests := []struct {
+		name   string
+		agent  *agenttest.Agent
+		server *model.Server
+	}{
+		{"jwt", jwtAgent, jwtAgent.Server(105, "connector-jwt")},
+		{"mtls", mtlsAgent, mtlsAgent.MTLSServer(106, "connector-mtls", certs)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := database.GetDB().Create(tt.server).Error; err != nil {
+				t.Fatal(err)
+			}
+			defer database.GetDB().Delete(&model.Server{}, tt.server.Id)
+			testConnectorAgainstAgent(t, tt.agent, tt.server)
+		})
+	}
+}
+
+// testConnectorAgainstAgent manages an inbound, its clients and traffic on agent through
+// the connector of server.
+func testConnectorAgainstAgent(t *testing.T, agent *agenttest.Agent, server *model.Server) {
+	ctx := context.Background()
+	connector, err := (&service.ServerManagementService{}).GetConnector(server.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connector.GetServerInfo(ctx); err != nil {
+		t.Fatalf("agent did not accept the panel: %v", err)
+	}
+
+	inbound := &model.Inbound{
+		Protocol: model.VLESS,
+		Port:     20000 + server.Id,
+		Remark:   "created",
+		Enable:   true,
+		Settings: `{"clients":[{"id":"0b7c2a4e-6f41-4c0e-9d27-3a5f8e1b6c90","email":"first","enable":true}]}`,
+	}
+	if err := connector.AddInbound(ctx, inbound); err != nil {
+		t.Fatal(err)
+	}
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil || len(inbounds) != 1 {
+		t.Fatalf("expected one inbound, got %d: %v", len(inbounds), err)
+	}
+	inbound = inbounds[0]
+
+	inbound.Remark = "updated"
+	if err := connector.UpdateInbound(ctx, inbound); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := connector.GetInbound(ctx, inbound.Id); err != nil || got.Remark != "updated" {
+		t.Fatalf("expected the updated remark, got %+v: %v", got, err)
+	}
+
+	inbound.Settings = `{"clients":[{"id":"5d1e9f3a-8b26-4c7d-a0e4-2f6b9c3d7e18","email":"second","enable":true}]}`
+	if err := connector.AddClient(ctx, inbound); err != nil {
+		t.Fatal(err)
+	}
+	if settings := agent.Inbounds()[0].Settings; !strings.Contains(settings, `"second"`) {
+		t.Fatalf("expected the added client on the agent, got %s", settings)
+	}
+
+	agent.AddTraffic("first", 1000, 3000)
+	traffics, err := connector.GetClientTraffics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, traffic := range traffics {
+		if traffic.Email == "first" {
+			found = traffic.Up == 1000 && traffic.Down == 3000
+		}
+	}
+	if !found {
+		t.Fatalf("expected the traffic of the first client, got %d traffics", len(traffics))
+	}
+	traffic, err := connector.GetTraffic(ctx, false)
+	if err != nil || traffic.Up != 1000 || traffic.Down != 3000 {
+		t.Fatalf("expected the server traffic, got %+v: %v", traffic, err)
+	}
+
+	if err := connector.DeleteClient(ctx, inbound.Id, "5d1e9f3a-8b26-4c7d-a0e4-2f6b9c3d7e18"); err != nil {
+		t.Fatal(err)
+	}
+	if err := connector.DeleteInbound(ctx, inbound.Id); err != nil {
+		t.Fatal(err)
+	}
+	if inbounds, err := connector.ListInbounds(ctx); err != nil || len(inbounds) != 0 {
+		t.Fatalf("expected no inbounds, got %d: %v", len(inbounds), err)
+	}
+}
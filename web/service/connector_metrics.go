@@ -0,0 +1,272 @@
+// Package service provides metrics of the requests the panel sends to remote agents.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connectorLatencyBuckets are the upper bounds, in seconds, of the latency histogram.
+var connectorLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// connectorPathParams name the path segments that follow them, so requests for different
+// objects are counted under one endpoint, e.g. /api/v1/inbounds/:inbound.
+var connectorPathParams = map[string]string{
+	"inbounds": ":inbound",
+	"clients":  ":client",
+	"users":    ":user",
+	"tasks":    ":id",
+}
+
+// connectorMetricKey identifies an endpoint of one server.
+type connectorMetricKey struct {
+	serverId int
+	method   string
+	endpoint string
+}
+
+// connectorMetric accumulates the requests sent to one endpoint.
+type connectorMetric struct {
+	count     int64
+	sum       float64  // Seconds
+	buckets   []uint64 // Cumulative counts per connectorLatencyBuckets bound
+	errors    map[string]int64
+	max       float64
+	last      float64
+	lastError string
+	lastAt    time.Time
+}
+
+// prometheusLabel escapes label values in the Prometheus text format.
+var prometheusLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var (
+	connectorMetricsMu sync.Mutex
+	connectorMetrics   = make(map[connectorMetricKey]*connectorMetric)
+)
+
+// ConnectorEndpointStats is the request statistics of one agent endpoint.
+type ConnectorEndpointStats struct {
+	Method      string           `json:"method"`
+	Endpoint    string           `json:"endpoint"`
+	Requests    int64            `json:"requests"`
+	Errors      int64            `json:"errors"`
+	ErrorCodes  map[string]int64 `json:"errorCodes"` // HTTP status, "timeout", "unreachable" or "agent_error"
+	AvgLatency  float64          `json:"avgLatency"` // Seconds
+	MaxLatency  float64          `json:"maxLatency"`
+	LastLatency float64          `json:"lastLatency"`
+	LastError   string           `json:"lastError,omitempty"`
+	LastAt      int64            `json:"lastAt"` // Unix timestamp of the last request
+}
+
+// ConnectorStats is the request statistics of the connector of one server.
+type ConnectorStats struct {
+	ServerId  int                       `json:"serverId"`
+	Requests  int64                     `json:"requests"`
+	Errors    int64                     `json:"errors"`
+	Failures  int32                     `json:"failures"` // Requests in a row that did not reach the agent
+	Endpoints []*ConnectorEndpointStats `json:"endpoints"`
+}
+
+// recordConnectorRequest counts a request sent to the agent of a server. status is the
+// HTTP status of the answer, 0 if there was none.
+func recordConnectorRequest(serverId int, method, path string, status int, err error, duration time.Duration) {
+	key := connectorMetricKey{serverId: serverId, method: method, endpoint: connectorEndpoint(path)}
+	seconds := duration.Seconds()
+
+	connectorMetricsMu.Lock()
+	defer connectorMetricsMu.Unlock()
+	metric, ok := connectorMetrics[key]
+	if !ok {
+		metric = &connectorMetric{
+			buckets: make([]uint64, len(connectorLatencyBuckets)),
+			errors:  make(map[string]int64),
+		}
+		connectorMetrics[key] = metric
+	}
+	metric.count++
+	metric.sum += seconds
+	for i, bound := range connectorLatencyBuckets {
+		if seconds <= bound {
+			metric.buckets[i]++
+		}
+	}
+	metric.max = max(metric.max, seconds)
+	metric.last = seconds
+	metric.lastAt = time.Now()
+	if err != nil {
+		metric.errors[connectorErrorCode(status, err)]++
+		metric.lastError = err.Error()
+	}
+}
+
+// forgetConnectorMetrics drops the metrics of a deleted server.
+func forgetConnectorMetrics(serverId int) {
+	connectorMetricsMu.Lock()
+	defer connectorMetricsMu.Unlock()
+	for key := range connectorMetrics {
+		if key.serverId == serverId {
+			delete(connectorMetrics, key)
+		}
+	}
+}
+
+// connectorEndpoint strips the query and object identifiers from path.
+func connectorEndpoint(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if param, ok := connectorPathParams[segments[i-1]]; ok && segments[i] != "" {
+			segments[i] = param
+		} else if _, err := strconv.Atoi(segments[i]); err == nil {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// connectorErrorCode classifies a failed request.
+func connectorErrorCode(status int, err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrAgentUnreachable):
+		return "unreachable"
+	case status != 0 && status != http.StatusOK:
+		return strconv.Itoa(status)
+	default:
+		return "agent_error"
+	}
+}
+
+// GetConnectorStats returns the request statistics of the connector of a server, the
+// endpoints that took the most time in total first.
+func (s *ServerManagementService) GetConnectorStats(serverId int) (*ConnectorStats, error) {
+	if _, err := s.GetServer(serverId); err != nil {
+		return nil, err
+	}
+	stats := &ConnectorStats{
+		ServerId:  serverId,
+		Failures:  pooledConnectorFailures(serverId),
+		Endpoints: make([]*ConnectorEndpointStats, 0),
+	}
+
+	sums := make(map[*ConnectorEndpointStats]float64)
+	connectorMetricsMu.Lock()
+	for key, metric := range connectorMetrics {
+		if key.serverId != serverId {
+			continue
+		}
+		endpoint := &ConnectorEndpointStats{
+			Method:      key.method,
+			Endpoint:    key.endpoint,
+			Requests:    metric.count,
+			ErrorCodes:  make(map[string]int64, len(metric.errors)),
+			AvgLatency:  metric.sum / float64(metric.count),
+			MaxLatency:  metric.max,
+			LastLatency: metric.last,
+			LastError:   metric.lastError,
+			LastAt:      metric.lastAt.Unix(),
+		}
+		for code, count := range metric.errors {
+			endpoint.ErrorCodes[code] = count
+			endpoint.Errors += count
+		}
+		stats.Requests += endpoint.Requests
+		stats.Errors += endpoint.Errors
+		stats.Endpoints = append(stats.Endpoints, endpoint)
+		sums[endpoint] = metric.sum
+	}
+	connectorMetricsMu.Unlock()
+
+	sort.Slice(stats.Endpoints, func(i, j int) bool {
+		return sums[stats.Endpoints[i]] > sums[stats.Endpoints[j]]
+	})
+	return stats, nil
+}
+
+// WriteConnectorMetrics writes the connector metrics of all servers in the Prometheus
+// text exposition format.
+func (s *ServerManagementService) WriteConnectorMetrics(w io.Writer) error {
+	servers, err := s.GetAllServers()
+	if err != nil {
+		return err
+	}
+	names := make(map[int]string, len(servers))
+	for _, server := range servers {
+		names[server.Id] = server.Name
+	}
+
+	connectorMetricsMu.Lock()
+	keys := make([]connectorMetricKey, 0, len(connectorMetrics))
+	metrics := make(map[connectorMetricKey]connectorMetric, len(connectorMetrics))
+	for key, metric := range connectorMetrics {
+		keys = append(keys, key)
+		copied := *metric
+		copied.buckets = append([]uint64(nil), metric.buckets...)
+		copied.errors = make(map[string]int64, len(metric.errors))
+		for code, count := range metric.errors {
+			copied.errors[code] = count
+		}
+		metrics[key] = copied
+	}
+	connectorMetricsMu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].serverId != keys[j].serverId {
+			return keys[i].serverId < keys[j].serverId
+		}
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].method < keys[j].method
+	})
+	labels := func(key connectorMetricKey) string {
+		return fmt.Sprintf(`server_id="%d",server="%s",method="%s",endpoint="%s"`,
+			key.serverId, prometheusLabel.Replace(names[key.serverId]), key.method, prometheusLabel.Replace(key.endpoint))
+	}
+
+	var b strings.Builder
+	b.WriteString("# HELP xui_connector_requests_total Requests sent to remote agents.\n")
+	b.WriteString("# TYPE xui_connector_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "xui_connector_requests_total{%s} %d\n", labels(key), metrics[key].count)
+	}
+
+	b.WriteString("# HELP xui_connector_errors_total Failed requests to remote agents by error code.\n")
+	b.WriteString("# TYPE xui_connector_errors_total counter\n")
+	for _, key := range keys {
+		codes := make([]string, 0, len(metrics[key].errors))
+		for code := range metrics[key].errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&b, "xui_connector_errors_total{%s,code=\"%s\"} %d\n", labels(key), code, metrics[key].errors[code])
+		}
+	}
+
+	b.WriteString("# HELP xui_connector_request_duration_seconds Latency of requests to remote agents.\n")
+	b.WriteString("# TYPE xui_connector_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		metric := metrics[key]
+		for i, bound := range connectorLatencyBuckets {
+			fmt.Fprintf(&b, "xui_connector_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels(key), strconv.FormatFloat(bound, 'g', -1, 64), metric.buckets[i])
+		}
+		fmt.Fprintf(&b, "xui_connector_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(key), metric.count)
+		fmt.Fprintf(&b, "xui_connector_request_duration_seconds_sum{%s} %g\n", labels(key), metric.sum)
+		fmt.Fprintf(&b, "xui_connector_request_duration_seconds_count{%s} %d\n", labels(key), metric.count)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
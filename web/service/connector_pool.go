@@ -0,0 +1,96 @@
+// Package service provides the pool of RemoteConnectors shared by all callers of GetConnector.
+package service
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"sync"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// connectorMaxFailures is the number of requests in a row that may fail to reach an agent
+// before its pooled connector is replaced, dropping connections that may have gone stale.
+const connectorMaxFailures = 3
+
+// pooledConnector is a cached RemoteConnector with the server fields it was built from.
+type pooledConnector struct {
+	connector *RemoteConnector
+	key       [sha256.Size]byte // Hash of endpoint, auth type and auth data
+}
+
+var (
+	connectorPoolMu sync.Mutex
+	connectorPool   = map[int]*pooledConnector{}
+)
+
+// connectorKey identifies the connection settings of a server: a connector built for one
+// key must not be used once the endpoint or credentials change.
+func connectorKey(server *model.Server) [sha256.Size]byte {
+	return sha256.Sum256([]byte(server.Endpoint + "\x00" + server.AuthType + "\x00" + server.AuthData))
+}
+
+// getPooledConnector returns the cached connector of a server, creating it if the server
+// has none yet, its endpoint or auth data changed, or its agent stopped answering.
+// Reusing connectors keeps agent connections alive and avoids re-parsing certificates.
+func getPooledConnector(server *model.Server) (*RemoteConnector, error) {
+	key := connectorKey(server)
+
+	connectorPoolMu.Lock()
+	defer connectorPoolMu.Unlock()
+
+	if pooled, ok := connectorPool[server.Id]; ok {
+		if pooled.key == key && pooled.connector.failures.Load() < connectorMaxFailures {
+			return pooled.connector, nil
+		}
+		if pooled.key == key {
+			logger.Debug("Replacing connector of server", server.Id, "after repeated failures")
+		}
+		delete(connectorPool, server.Id)
+		closeConnector(pooled.connector)
+		// A new endpoint may lead to another agent, and a restarted one may have lost changes
+		InvalidateInboundCache(server.Id)
+	}
+
+	connector, err := NewRemoteConnector(server)
+	if err != nil {
+		return nil, err
+	}
+	connectorPool[server.Id] = &pooledConnector{connector: connector, key: key}
+	return connector, nil
+}
+
+// evictConnector drops the cached connector and inbound list of a server, e.g. when the
+// server is deleted.
+func evictConnector(serverId int) {
+	connectorPoolMu.Lock()
+	defer connectorPoolMu.Unlock()
+
+	if pooled, ok := connectorPool[serverId]; ok {
+		delete(connectorPool, serverId)
+		closeConnector(pooled.connector)
+	}
+	InvalidateInboundCache(serverId)
+}
+
+// pooledConnectorFailures returns the requests in a row that failed to reach the agent of a
+// server through its cached connector, 0 if it has none.
+func pooledConnectorFailures(serverId int) int32 {
+	connectorPoolMu.Lock()
+	defer connectorPoolMu.Unlock()
+
+	if pooled, ok := connectorPool[serverId]; ok {
+		return pooled.connector.failures.Load()
+	}
+	return 0
+}
+
+// closeConnector closes the idle connections of a connector that left the pool. Requests
+// still in flight on it finish normally.
+func closeConnector(connector *RemoteConnector) {
+	// JWT connectors share http.DefaultTransport, which must stay open
+	if transport, ok := connector.httpClient.Transport.(*http.Transport); ok && transport != http.DefaultTransport {
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,142 @@
+// Package service provides DemoService for seeding the servers of demo mode.
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// demoHistory is how far back the metric and traffic history of new demo servers goes.
+const demoHistory = 7 * 24 * time.Hour
+
+// demoServers are the servers created in demo mode.
+var demoServers = []model.Server{
+	{Name: "demo-fra-1", Endpoint: "https://fra-1.demo.example.com:2054", Region: "eu-central", Timezone: "Europe/Berlin", Tags: `["demo","eu"]`},
+	{Name: "demo-ams-1", Endpoint: "https://ams-1.demo.example.com:2054", Region: "eu-west", Timezone: "Europe/Amsterdam", Tags: `["demo","eu"]`},
+	{Name: "demo-nyc-1", Endpoint: "https://nyc-1.demo.example.com:2054", Region: "us-east", Timezone: "America/New_York", Tags: `["demo","us"]`},
+	{Name: "demo-sgp-1", Endpoint: "https://sgp-1.demo.example.com:2054", Region: "ap-southeast", Timezone: "Asia/Singapore", Tags: `["demo","asia"]`},
+}
+
+// DemoService seeds simulated servers with a week of history when the panel runs in
+// demo mode (XUI_DEMO=true). Demo servers use the "demo" auth type and are served by
+// DemoConnector instead of an agent.
+type DemoService struct{}
+
+// Seed creates the demo servers that do not exist yet, with their metric and traffic
+// history, a past incident and a few timeline events. Existing servers are left as they are,
+// so restarting the panel keeps changes made to them.
+func (s *DemoService) Seed() error {
+	db := database.GetDB()
+	now := time.Now()
+
+	for _, template := range demoServers {
+		var count int64
+		if err := db.Model(model.Server{}).Where("name = ?", template.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		server := template
+		server.AuthType = "demo"
+		server.Status = "online"
+		server.LastSeen = now.Unix()
+		server.Version = "demo"
+		server.XrayVersion = demoXrayVersion
+		server.OsInfo = `{"os":"linux","arch":"amd64","kernel":"6.1.0-demo"}`
+		server.Enabled = true
+		server.Notes = "Simulated server of demo mode. Its inbounds, clients and metrics are generated by the panel."
+		if err := db.Create(&server).Error; err != nil {
+			return err
+		}
+		if err := s.seedHistory(&server, now); err != nil {
+			return err
+		}
+		logger.Info("Seeded demo server", server.Name)
+	}
+	return nil
+}
+
+// seedHistory stores the history of a new demo server: metric samples every 5 minutes
+// and hourly traffic samples ending at the current client counters.
+func (s *DemoService) seedHistory(server *model.Server, now time.Time) error {
+	db := database.GetDB()
+	start := now.Add(-demoHistory).Truncate(5 * time.Minute)
+
+	// Metric samples follow the same daily curve as the live stats of DemoConnector
+	metrics := make([]*model.ServerMetricSample, 0, int(demoHistory/(5*time.Minute)))
+	for t := start; t.Before(now); t = t.Add(5 * time.Minute) {
+		load := demoLoad(server, t)
+		online := int(6 * load)
+		metrics = append(metrics, &model.ServerMetricSample{
+			ServerId:  server.Id,
+			Cpu:       5 + 70*load,
+			Mem:       30 + 30*load,
+			MemUsed:   uint64(float64(4<<30) * (0.3 + 0.3*load)),
+			DiskUsed:  uint64(float64(80<<30) * (0.2 + 0.1*demoHash(server.Name))),
+			NetUp:     int64(float64(online)*200*1024*load) / 8,
+			NetDown:   int64(float64(online) * 200 * 1024 * load),
+			Online:    online,
+			CreatedAt: t.Unix(),
+		})
+	}
+	if err := db.CreateInBatches(metrics, 500).Error; err != nil {
+		return err
+	}
+
+	// Traffic samples are cumulative counters, so walk back from the current ones and
+	// subtract what each client used in every hour
+	connector := NewDemoConnector(server)
+	connector.state.mu.Lock()
+	inbounds := connector.state.inbounds
+	samples := make([]*model.ClientTrafficSample, 0)
+	for _, inbound := range inbounds {
+		for _, stat := range inbound.ClientStats {
+			up, down := stat.Up, stat.Down
+			for t := now.Truncate(time.Hour); t.After(start); t = t.Add(-time.Hour) {
+				samples = append(samples, &model.ClientTrafficSample{
+					ServerId:  server.Id,
+					Email:     stat.Email,
+					InboundId: inbound.Id,
+					Up:        up,
+					Down:      down,
+					AllTime:   up + down,
+					CreatedAt: t.Unix(),
+				})
+				used := int64(float64(demoClientRate(stat.Email)) * 0.3 * demoLoad(server, t) * 3600)
+				up = max(0, up-used/8)
+				down = max(0, down-(used-used/8))
+			}
+		}
+	}
+	connector.state.mu.Unlock()
+	if err := db.CreateInBatches(samples, 500).Error; err != nil {
+		return err
+	}
+
+	// One short outage two days ago gives the incident list and timeline something to show
+	startedAt := now.Add(-48*time.Hour - time.Duration(demoHash(server.Name)*12)*time.Hour).Unix()
+	incident := &model.Incident{
+		ServerId:  server.Id,
+		Status:    "offline",
+		Cause:     "agent unreachable: connection timed out",
+		StartedAt: startedAt,
+		EndedAt:   startedAt + 420,
+		Duration:  420,
+	}
+	if err := db.Create(incident).Error; err != nil {
+		return err
+	}
+	events := []*model.ServerEvent{
+		{ServerId: server.Id, Type: "status", Message: "Server went offline: agent unreachable: connection timed out", CreatedAt: startedAt},
+		{ServerId: server.Id, Type: "status", Message: "Server is back online", CreatedAt: startedAt + 420},
+		{ServerId: server.Id, Type: "xray", Message: fmt.Sprintf("Xray %s installed", demoXrayVersion), CreatedAt: start.Unix()},
+		{ServerId: server.Id, Type: "cert", Message: "Certificate for " + EndpointHost(server.Endpoint) + " renewed", CreatedAt: now.Add(-24 * time.Hour).Unix()},
+	}
+	return db.Create(events).Error
+}
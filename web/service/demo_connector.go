@@ -0,0 +1,932 @@
+// Package service provides DemoConnector, the simulated connector of demo servers.
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/google/uuid"
+)
+
+// demoXrayVersion is the Xray version reported by demo servers until another is installed.
+const demoXrayVersion = "25.10.15"
+
+// errDemoUnsupported is returned for operations a demo server cannot simulate.
+var errDemoUnsupported = errors.New("not available on demo servers")
+
+// DemoConnector implements ServerConnector for the servers seeded in demo mode (auth type
+// "demo"). Inbounds and clients live in memory, traffic grows with time and the system
+// metrics follow a daily curve, so the multi-server UI and API can be tried without agents.
+type DemoConnector struct {
+	server *model.Server
+	state  *demoState
+}
+
+// demoState is the simulated agent of one demo server.
+type demoState struct {
+	mu            sync.Mutex
+	inbounds      []*model.Inbound
+	nextId        int
+	xrayRunning   bool
+	xrayVersion   string
+	agentVersion  string
+	started       time.Time
+	lastTick      time.Time
+	inboundDeltas map[string][2]int64 // Traffic per inbound tag since the last GetTraffic reset
+	outbound      [2][2]int64         // Up and down of the "direct" and "blocked" outbounds
+}
+
+var (
+	demoStatesMu sync.Mutex
+	demoStates   = map[int]*demoState{}
+)
+
+// NewDemoConnector returns the connector of a demo server. Its state is kept for the
+// lifetime of the panel, so changes made through the UI stick until a restart.
+func NewDemoConnector(server *model.Server) *DemoConnector {
+	demoStatesMu.Lock()
+	defer demoStatesMu.Unlock()
+
+	state, ok := demoStates[server.Id]
+	if !ok {
+		now := time.Now()
+		state = &demoState{
+			inbounds:      demoInbounds(server),
+			xrayRunning:   true,
+			xrayVersion:   demoXrayVersion,
+			agentVersion:  "demo",
+			started:       now.Add(-time.Duration(3+server.Id%5) * 24 * time.Hour),
+			lastTick:      now,
+			inboundDeltas: map[string][2]int64{},
+		}
+		state.nextId = len(state.inbounds) + 1
+		demoStates[server.Id] = state
+	}
+	return &DemoConnector{server: server, state: state}
+}
+
+// demoHash returns a stable pseudo-random number in [0, 1) for a key.
+func demoHash(key string) float64 {
+	sum := sha256.Sum256([]byte(key))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / float64(1<<53)
+}
+
+// demoLoad returns the simulated load of a server at t in [0, 1]: low at night and
+// highest in the evening of the server's timezone, with a little jitter per server.
+func demoLoad(server *model.Server, t time.Time) float64 {
+	local := t.In(ServerLocation(server))
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	load := 0.45 + 0.35*math.Sin(2*math.Pi*(hour-14)/24)
+	load += 0.1 * (demoHash(fmt.Sprintf("%d/%d", server.Id, t.Unix()/300)) - 0.5)
+	return math.Min(1, math.Max(0, load))
+}
+
+// demoClientRate returns the bytes per second a demo client transfers at full load.
+func demoClientRate(email string) int64 {
+	return int64(20*1024 + demoHash(email)*400*1024)
+}
+
+// demoInbounds builds the initial inbounds of a demo server: a VLESS Reality inbound and
+// a Trojan WebSocket inbound with a few clients each.
+func demoInbounds(server *model.Server) []*model.Inbound {
+	prefix := strings.TrimPrefix(server.Name, "demo-")
+	vlessClients := []string{"alice", "bob", "carol", "dave"}
+	trojanClients := []string{"erin", "frank"}
+
+	return []*model.Inbound{
+		demoInbound(server, 1, "VLESS Reality", 443, model.VLESS, prefix, vlessClients,
+			`{"network":"tcp","security":"reality","realitySettings":{"show":false,"dest":"www.microsoft.com:443","serverNames":["www.microsoft.com"],"privateKey":"","shortIds":["6ba85179e30d4fc2"],"settings":{"publicKey":"Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw","fingerprint":"chrome"}},"tcpSettings":{"header":{"type":"none"}}}`),
+		demoInbound(server, 2, "Trojan WS", 8443, model.Trojan, prefix, trojanClients,
+			`{"network":"ws","security":"none","wsSettings":{"path":"/demo","headers":{}}}`),
+	}
+}
+
+// demoInbound builds one demo inbound with clients named "<name>@<prefix>".
+func demoInbound(server *model.Server, id int, remark string, port int, protocol model.Protocol, prefix string, names []string, stream string) *model.Inbound {
+	clients := make([]map[string]any, 0, len(names))
+	stats := make([]xray.ClientTraffic, 0, len(names))
+	now := time.Now()
+	for _, name := range names {
+		email := name + "@" + prefix
+		client := map[string]any{
+			"email":      email,
+			"limitIp":    0,
+			"totalGB":    0,
+			"expiryTime": 0,
+			"enable":     true,
+			"tgId":       "",
+			"subId":      strings.ReplaceAll(uuid.NewSHA1(uuid.NameSpaceURL, []byte("sub/"+email)).String(), "-", "")[:16],
+			"reset":      0,
+		}
+		if protocol == model.Trojan {
+			client["password"] = strings.ReplaceAll(uuid.NewSHA1(uuid.NameSpaceURL, []byte(email)).String(), "-", "")
+		} else {
+			client["id"] = uuid.NewSHA1(uuid.NameSpaceURL, []byte(email)).String()
+			client["flow"] = "xtls-rprx-vision"
+		}
+		clients = append(clients, client)
+
+		// Start with a few days worth of traffic
+		days := 1 + demoHash("days/"+email)*10
+		used := int64(float64(demoClientRate(email)) * 0.3 * days * 86400)
+		stats = append(stats, xray.ClientTraffic{
+			InboundId: id,
+			ServerId:  server.Id,
+			Enable:    true,
+			Email:     email,
+			Up:        used / 8,
+			Down:      used - used/8,
+			AllTime:   used,
+			UpdatedAt: now.Unix(),
+		})
+	}
+
+	settings := map[string]any{"clients": clients, "decryption": "none", "fallbacks": []any{}}
+	if protocol == model.Trojan {
+		delete(settings, "decryption")
+	}
+	settingsJson, _ := json.Marshal(settings)
+
+	inbound := &model.Inbound{
+		Id:             id,
+		ServerId:       server.Id,
+		Remark:         remark,
+		Enable:         true,
+		Port:           port,
+		Protocol:       protocol,
+		Settings:       string(settingsJson),
+		StreamSettings: stream,
+		Tag:            fmt.Sprintf("inbound-%d", port),
+		Sniffing:       `{"enabled":true,"destOverride":["http","tls","quic"],"metadataOnly":false,"routeOnly":false}`,
+		TrafficReset:   "never",
+		Version:        1,
+		ClientStats:    stats,
+	}
+	for _, stat := range stats {
+		inbound.Up += stat.Up
+		inbound.Down += stat.Down
+		inbound.AllTime += stat.AllTime
+	}
+	return inbound
+}
+
+// demoSettingsClients returns the clients in the settings of an inbound.
+func demoSettingsClients(settings string) []map[string]any {
+	var parsed struct {
+		Clients []map[string]any `json:"clients"`
+	}
+	_ = json.Unmarshal([]byte(settings), &parsed)
+	return parsed.Clients
+}
+
+// setDemoSettingsClients replaces the clients in the settings of an inbound.
+func setDemoSettingsClients(inbound *model.Inbound, clients []map[string]any) {
+	settings := map[string]any{}
+	_ = json.Unmarshal([]byte(inbound.Settings), &settings)
+	settings["clients"] = clients
+	if data, err := json.Marshal(settings); err == nil {
+		inbound.Settings = string(data)
+	}
+}
+
+// tick advances the simulated traffic to now. Callers hold s.mu.
+func (s *demoState) tick(server *model.Server) {
+	now := time.Now()
+	elapsed := now.Sub(s.lastTick).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	s.lastTick = now
+	if !s.xrayRunning {
+		return
+	}
+
+	load := demoLoad(server, now)
+	for _, inbound := range s.inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		for i := range inbound.ClientStats {
+			stat := &inbound.ClientStats[i]
+			if !stat.Enable || !demoOnline(stat.Email, now) {
+				continue
+			}
+			bytes := int64(float64(demoClientRate(stat.Email)) * load * elapsed)
+			up := bytes / 8
+			stat.Up += up
+			stat.Down += bytes - up
+			stat.AllTime += bytes
+			stat.LastOnline = now.UnixMilli()
+			stat.UpdatedAt = now.Unix()
+
+			inbound.Up += up
+			inbound.Down += bytes - up
+			inbound.AllTime += bytes
+			delta := s.inboundDeltas[inbound.Tag]
+			s.inboundDeltas[inbound.Tag] = [2]int64{delta[0] + up, delta[1] + bytes - up}
+			s.outbound[0][0] += up
+			s.outbound[0][1] += bytes - up
+		}
+	}
+	s.outbound[1][1] += int64(512 * load * elapsed)
+}
+
+// demoOnline reports whether a demo client is connected at t. Clients come and go in
+// sessions of about 10 minutes.
+func demoOnline(email string, t time.Time) bool {
+	return demoHash(fmt.Sprintf("%s/%d", email, t.Unix()/600)) < 0.7
+}
+
+// findInbound returns the inbound with id. Callers hold s.mu.
+func (s *demoState) findInbound(id int) (*model.Inbound, error) {
+	for _, inbound := range s.inbounds {
+		if inbound.Id == id {
+			return inbound, nil
+		}
+	}
+	return nil, fmt.Errorf("inbound %d not found", id)
+}
+
+// copyInbound returns a copy of an inbound, so callers cannot change the state.
+func (c *DemoConnector) copyInbound(inbound *model.Inbound) *model.Inbound {
+	copied := *inbound
+	copied.ServerId = c.server.Id
+	copied.ClientStats = append([]xray.ClientTraffic(nil), inbound.ClientStats...)
+	return &copied
+}
+
+// GetServerInfo returns the simulated server metadata.
+func (c *DemoConnector) GetServerInfo(ctx context.Context) (*ServerInfo, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return &ServerInfo{
+		ServerId:    c.server.Id,
+		ServerName:  c.server.Name,
+		Version:     c.state.agentVersion,
+		XrayVersion: c.state.xrayVersion,
+		OS:          "linux",
+		Arch:        "amd64",
+		Kernel:      "6.1.0-demo",
+		Uptime:      int64(time.Since(c.state.started).Seconds()),
+	}, nil
+}
+
+// GetHealth reports the demo server as online.
+func (c *DemoConnector) GetHealth(ctx context.Context) (*HealthStatus, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return &HealthStatus{
+		Status:      "online",
+		XrayRunning: c.state.xrayRunning,
+		Version:     c.state.agentVersion,
+		XrayVersion: c.state.xrayVersion,
+		Timestamp:   time.Now().Unix(),
+	}, nil
+}
+
+// ListInbounds returns the inbounds of the demo server with their current traffic.
+func (c *DemoConnector) ListInbounds(ctx context.Context) ([]*model.Inbound, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.tick(c.server)
+
+	inbounds := make([]*model.Inbound, 0, len(c.state.inbounds))
+	for _, inbound := range c.state.inbounds {
+		inbounds = append(inbounds, c.copyInbound(inbound))
+	}
+	return inbounds, nil
+}
+
+// GetInbound returns one inbound of the demo server.
+func (c *DemoConnector) GetInbound(ctx context.Context, id int) (*model.Inbound, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.tick(c.server)
+
+	inbound, err := c.state.findInbound(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.copyInbound(inbound), nil
+}
+
+// AddInbound adds an inbound to the demo server and sets its ID.
+func (c *DemoConnector) AddInbound(ctx context.Context, inbound *model.Inbound) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	for _, existing := range c.state.inbounds {
+		if existing.Port == inbound.Port {
+			return fmt.Errorf("port %d is already in use", inbound.Port)
+		}
+	}
+	added := *inbound
+	added.Id = c.state.nextId
+	added.ServerId = c.server.Id
+	added.Version = 1
+	if added.Tag == "" {
+		added.Tag = fmt.Sprintf("inbound-%d", added.Port)
+	}
+	added.ClientStats = nil
+	for _, client := range demoSettingsClients(added.Settings) {
+		email, _ := client["email"].(string)
+		enable, _ := client["enable"].(bool)
+		added.ClientStats = append(added.ClientStats, xray.ClientTraffic{InboundId: added.Id, ServerId: c.server.Id, Email: email, Enable: enable, UpdatedAt: time.Now().Unix()})
+	}
+	c.state.nextId++
+	c.state.inbounds = append(c.state.inbounds, &added)
+	inbound.Id = added.Id
+
+	recordServerEvent(c.server.Id, "config", fmt.Sprintf("Inbound %s (port %d) added", inbound.Remark, inbound.Port))
+	return nil
+}
+
+// UpdateInbound replaces the configuration of an inbound, keeping its traffic.
+func (c *DemoConnector) UpdateInbound(ctx context.Context, inbound *model.Inbound) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	existing, err := c.state.findInbound(inbound.Id)
+	if err != nil {
+		return err
+	}
+	existing.Remark = inbound.Remark
+	existing.Enable = inbound.Enable
+	existing.Total = inbound.Total
+	existing.ExpiryTime = inbound.ExpiryTime
+	existing.TrafficReset = inbound.TrafficReset
+	existing.Listen = inbound.Listen
+	existing.Port = inbound.Port
+	existing.Settings = inbound.Settings
+	existing.StreamSettings = inbound.StreamSettings
+	existing.Sniffing = inbound.Sniffing
+	existing.AddressOverride = inbound.AddressOverride
+	existing.Version++
+
+	recordServerEvent(c.server.Id, "config", fmt.Sprintf("Inbound %d (%s) updated", inbound.Id, inbound.Remark))
+	return nil
+}
+
+// DeleteInbound removes an inbound from the demo server.
+func (c *DemoConnector) DeleteInbound(ctx context.Context, id int) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	for i, inbound := range c.state.inbounds {
+		if inbound.Id == id {
+			c.state.inbounds = append(c.state.inbounds[:i], c.state.inbounds[i+1:]...)
+			recordServerEvent(c.server.Id, "config", fmt.Sprintf("Inbound %d deleted", id))
+			return nil
+		}
+	}
+	return fmt.Errorf("inbound %d not found", id)
+}
+
+// AddClient adds the clients in the settings of inbound to the stored inbound.
+func (c *DemoConnector) AddClient(ctx context.Context, inbound *model.Inbound) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	existing, err := c.state.findInbound(inbound.Id)
+	if err != nil {
+		return err
+	}
+	clients := demoSettingsClients(existing.Settings)
+	for _, client := range demoSettingsClients(inbound.Settings) {
+		email, _ := client["email"].(string)
+		for _, stat := range existing.ClientStats {
+			if strings.EqualFold(stat.Email, email) {
+				return fmt.Errorf("duplicate email: %s", email)
+			}
+		}
+		enable, _ := client["enable"].(bool)
+		clients = append(clients, client)
+		existing.ClientStats = append(existing.ClientStats, xray.ClientTraffic{InboundId: existing.Id, ServerId: c.server.Id, Email: email, Enable: enable, UpdatedAt: time.Now().Unix()})
+	}
+	setDemoSettingsClients(existing, clients)
+	existing.Version++
+	return nil
+}
+
+// UpdateClient replaces the client at clientIndex with the client in the settings of inbound.
+func (c *DemoConnector) UpdateClient(ctx context.Context, inbound *model.Inbound, clientIndex int) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	existing, err := c.state.findInbound(inbound.Id)
+	if err != nil {
+		return err
+	}
+	updates := demoSettingsClients(inbound.Settings)
+	clients := demoSettingsClients(existing.Settings)
+	if len(updates) == 0 || clientIndex < 0 || clientIndex >= len(clients) {
+		return fmt.Errorf("client %d not found", clientIndex)
+	}
+	oldEmail, _ := clients[clientIndex]["email"].(string)
+	newEmail, _ := updates[0]["email"].(string)
+	enable, _ := updates[0]["enable"].(bool)
+	clients[clientIndex] = updates[0]
+	setDemoSettingsClients(existing, clients)
+	for i := range existing.ClientStats {
+		if existing.ClientStats[i].Email == oldEmail {
+			existing.ClientStats[i].Email = newEmail
+			existing.ClientStats[i].Enable = enable
+			existing.ClientStats[i].UpdatedAt = time.Now().Unix()
+		}
+	}
+	existing.Version++
+	return nil
+}
+
+// DeleteClient removes a client from an inbound of the demo server.
+func (c *DemoConnector) DeleteClient(ctx context.Context, inboundId int, clientEmail string) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	existing, err := c.state.findInbound(inboundId)
+	if err != nil {
+		return err
+	}
+	clients := make([]map[string]any, 0)
+	for _, client := range demoSettingsClients(existing.Settings) {
+		if email, _ := client["email"].(string); email != clientEmail {
+			clients = append(clients, client)
+		}
+	}
+	setDemoSettingsClients(existing, clients)
+	stats := existing.ClientStats[:0]
+	for _, stat := range existing.ClientStats {
+		if stat.Email != clientEmail {
+			stats = append(stats, stat)
+		}
+	}
+	existing.ClientStats = stats
+	existing.Version++
+	return nil
+}
+
+// ResetClientTraffic zeroes the traffic of a client.
+func (c *DemoConnector) ResetClientTraffic(ctx context.Context, inboundId int, email string) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	existing, err := c.state.findInbound(inboundId)
+	if err != nil {
+		return err
+	}
+	for i := range existing.ClientStats {
+		if existing.ClientStats[i].Email == email {
+			existing.ClientStats[i].Up = 0
+			existing.ClientStats[i].Down = 0
+			existing.ClientStats[i].UpdatedAt = time.Now().Unix()
+		}
+	}
+	return nil
+}
+
+// ResetInboundTraffic zeroes the traffic of an inbound and its clients.
+func (c *DemoConnector) ResetInboundTraffic(ctx context.Context, inboundId int) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	existing, err := c.state.findInbound(inboundId)
+	if err != nil {
+		return err
+	}
+	existing.Up, existing.Down = 0, 0
+	for i := range existing.ClientStats {
+		existing.ClientStats[i].Up = 0
+		existing.ClientStats[i].Down = 0
+		existing.ClientStats[i].UpdatedAt = time.Now().Unix()
+	}
+	return nil
+}
+
+// onlineEmails returns the enabled clients that are connected now. Callers hold s.mu.
+func (s *demoState) onlineEmails() []string {
+	emails := make([]string, 0)
+	if !s.xrayRunning {
+		return emails
+	}
+	now := time.Now()
+	for _, inbound := range s.inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		for _, stat := range inbound.ClientStats {
+			if stat.Enable && demoOnline(stat.Email, now) {
+				emails = append(emails, stat.Email)
+			}
+		}
+	}
+	return emails
+}
+
+// GetOnlineClients returns the simulated online clients.
+func (c *DemoConnector) GetOnlineClients(ctx context.Context) ([]string, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.onlineEmails(), nil
+}
+
+// GetOnlineClientDetails returns the online clients with addresses from the documentation ranges.
+func (c *DemoConnector) GetOnlineClientDetails(ctx context.Context) ([]*OnlineClient, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	countries := []string{"DE", "NL", "US", "FR", "GB", "SG"}
+	now := time.Now().Unix()
+	clients := make([]*OnlineClient, 0)
+	for _, email := range c.state.onlineEmails() {
+		h := demoHash("ip/" + email)
+		ip := &OnlineClientIP{
+			IP:       fmt.Sprintf("203.0.113.%d", 1+int(h*250)),
+			LastSeen: now,
+			Country:  countries[int(h*float64(len(countries)))],
+			ASN:      "AS64500",
+		}
+		clients = append(clients, &OnlineClient{Email: email, ServerId: c.server.Id, IPCount: 1, IPs: []*OnlineClientIP{ip}})
+	}
+	return clients, nil
+}
+
+// GetTraffic returns the inbound and outbound traffic since the last reset.
+func (c *DemoConnector) GetTraffic(ctx context.Context, reset bool) (*xray.Traffic, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.tick(c.server)
+
+	traffic := &xray.Traffic{IsInbound: true}
+	for _, delta := range c.state.inboundDeltas {
+		traffic.Up += delta[0]
+		traffic.Down += delta[1]
+	}
+	if reset {
+		c.state.inboundDeltas = map[string][2]int64{}
+	}
+	return traffic, nil
+}
+
+// GetClientTraffics returns the traffic of every client.
+func (c *DemoConnector) GetClientTraffics(ctx context.Context) ([]*xray.ClientTraffic, error) {
+	traffics, _, err := c.GetClientTrafficsSince(ctx, 0)
+	return traffics, err
+}
+
+// GetClientTrafficsSince returns the traffic of the clients updated since the cursor.
+func (c *DemoConnector) GetClientTrafficsSince(ctx context.Context, since int64) ([]*xray.ClientTraffic, int64, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.tick(c.server)
+
+	cursor := time.Now().Unix()
+	traffics := make([]*xray.ClientTraffic, 0)
+	for _, inbound := range c.state.inbounds {
+		for _, stat := range inbound.ClientStats {
+			if since > 0 && stat.UpdatedAt < since {
+				continue
+			}
+			stat := stat
+			traffics = append(traffics, &stat)
+		}
+	}
+	return traffics, cursor, nil
+}
+
+// GetOutboundTraffics returns the traffic of the simulated "direct" and "blocked" outbounds.
+func (c *DemoConnector) GetOutboundTraffics(ctx context.Context) ([]*model.OutboundTraffics, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.tick(c.server)
+
+	outbound := c.state.outbound
+	return []*model.OutboundTraffics{
+		{Tag: "direct", ServerId: c.server.Id, Up: outbound[0][0], Down: outbound[0][1], Total: outbound[0][0] + outbound[0][1]},
+		{Tag: "blocked", ServerId: c.server.Id, Up: outbound[1][0], Down: outbound[1][1], Total: outbound[1][0] + outbound[1][1]},
+	}, nil
+}
+
+// GetConnectionCounts returns one connection per online client on the port of its inbound.
+func (c *DemoConnector) GetConnectionCounts(ctx context.Context) (map[int]int, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	counts := make(map[int]int)
+	if !c.state.xrayRunning {
+		return counts, nil
+	}
+	now := time.Now()
+	for _, inbound := range c.state.inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		for _, stat := range inbound.ClientStats {
+			if stat.Enable && demoOnline(stat.Email, now) {
+				counts[inbound.Port]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// StartXray marks the simulated Xray as running.
+func (c *DemoConnector) StartXray(ctx context.Context) error {
+	return c.setXrayRunning(true, "Xray started")
+}
+
+// StopXray marks the simulated Xray as stopped.
+func (c *DemoConnector) StopXray(ctx context.Context) error {
+	return c.setXrayRunning(false, "Xray stopped")
+}
+
+// RestartXray marks the simulated Xray as running.
+func (c *DemoConnector) RestartXray(ctx context.Context) error {
+	return c.setXrayRunning(true, "Xray restarted")
+}
+
+func (c *DemoConnector) setXrayRunning(running bool, event string) error {
+	c.state.mu.Lock()
+	c.state.tick(c.server)
+	c.state.xrayRunning = running
+	c.state.mu.Unlock()
+	recordServerEvent(c.server.Id, "xray", event)
+	return nil
+}
+
+// GetXrayVersion returns the simulated Xray version.
+func (c *DemoConnector) GetXrayVersion(ctx context.Context) (string, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.xrayVersion, nil
+}
+
+// GetXrayConfig returns an Xray config built from the inbounds of the demo server.
+func (c *DemoConnector) GetXrayConfig(ctx context.Context) (string, error) {
+	c.state.mu.Lock()
+	inbounds := make([]*xray.InboundConfig, 0, len(c.state.inbounds))
+	for _, inbound := range c.state.inbounds {
+		if inbound.Enable {
+			inbounds = append(inbounds, inbound.GenXrayInboundConfig())
+		}
+	}
+	c.state.mu.Unlock()
+
+	config := map[string]any{
+		"log":       map[string]any{"loglevel": "warning"},
+		"inbounds":  inbounds,
+		"outbounds": []map[string]any{{"tag": "direct", "protocol": "freedom"}, {"tag": "blocked", "protocol": "blackhole"}},
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetCapabilities reports the features of the simulated Xray version.
+func (c *DemoConnector) GetCapabilities(ctx context.Context) (*XrayCapabilities, error) {
+	version, _ := c.GetXrayVersion(ctx)
+	return GetXrayCapabilities(version), nil
+}
+
+// XrayAddUser accepts the user; demo inbounds are changed through AddClient.
+func (c *DemoConnector) XrayAddUser(ctx context.Context, req *XrayUserRequest) error {
+	return nil
+}
+
+// XrayRemoveUser accepts the removal; demo inbounds are changed through DeleteClient.
+func (c *DemoConnector) XrayRemoveUser(ctx context.Context, inboundTag, email string) error {
+	return nil
+}
+
+// XrayQueryStats returns the client traffic counters matching pattern in Xray stats format.
+func (c *DemoConnector) XrayQueryStats(ctx context.Context, pattern string) (map[string]int64, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.tick(c.server)
+
+	stats := make(map[string]int64)
+	for _, inbound := range c.state.inbounds {
+		for _, stat := range inbound.ClientStats {
+			for name, value := range map[string]int64{
+				"user>>>" + stat.Email + ">>>traffic>>>uplink":   stat.Up,
+				"user>>>" + stat.Email + ">>>traffic>>>downlink": stat.Down,
+			} {
+				if strings.Contains(name, pattern) {
+					stats[name] = value
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+// GetSystemStats returns system metrics following the daily load curve of the server.
+func (c *DemoConnector) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	now := time.Now()
+	load := demoLoad(c.server, now)
+	online := len(c.state.onlineEmails())
+	const memTotal = 4 << 30
+	const diskTotal = 80 << 30
+	const swapTotal = 1 << 30
+	memUsed := uint64(float64(memTotal) * (0.3 + 0.3*load))
+	swapUsed := uint64(float64(swapTotal) * 0.1 * load)
+	diskUsed := uint64(float64(diskTotal) * (0.2 + 0.1*demoHash(c.server.Name)))
+	netSpeed := int64(float64(online) * 200 * 1024 * load)
+
+	return &SystemStats{
+		CPUUsage:        math.Round((5+70*load)*10) / 10,
+		CPUCores:        2,
+		MemTotal:        memTotal,
+		MemUsed:         memUsed,
+		MemUsage:        math.Round(float64(memUsed)/memTotal*1000) / 10,
+		SwapTotal:       swapTotal,
+		SwapUsed:        swapUsed,
+		SwapUsage:       math.Round(float64(swapUsed)/swapTotal*1000) / 10,
+		DiskTotal:       diskTotal,
+		DiskUsed:        diskUsed,
+		DiskUsage:       math.Round(float64(diskUsed)/diskTotal*1000) / 10,
+		NetInSpeed:      netSpeed,
+		NetOutSpeed:     netSpeed / 8,
+		Uptime:          int64(now.Sub(c.state.started).Seconds()),
+		LoadAverage:     fmt.Sprintf("%.2f, %.2f, %.2f", 2*load, 1.8*load, 1.5*load),
+		TCPConnections:  20 + online*3,
+		UDPConnections:  4 + online,
+		XrayConnections: online,
+		PublicIPv4:      fmt.Sprintf("198.51.100.%d", c.server.Id%250+1),
+	}, nil
+}
+
+// GetLogs returns simulated Xray access log lines.
+func (c *DemoConnector) GetLogs(ctx context.Context, count int) ([]string, error) {
+	c.state.mu.Lock()
+	emails := c.state.onlineEmails()
+	c.state.mu.Unlock()
+
+	logs := make([]string, 0, count)
+	now := time.Now()
+	destinations := []string{"www.google.com:443", "api.github.com:443", "cdn.example.com:443", "1.1.1.1:53"}
+	for i := 0; i < count && len(emails) > 0; i++ {
+		t := now.Add(-time.Duration(count-i) * 7 * time.Second)
+		email := emails[i%len(emails)]
+		logs = append(logs, fmt.Sprintf("%s from 203.0.113.%d:%d accepted tcp:%s [inbound-443 >> direct] email: %s",
+			t.Format("2006/01/02 15:04:05"), 1+int(demoHash("ip/"+email)*250), 40000+i, destinations[i%len(destinations)], email))
+	}
+	return logs, nil
+}
+
+// StreamLogs sends simulated log lines: the access log lines of GetLogs, or a status
+// message of the agent or Xray every few seconds.
+func (c *DemoConnector) StreamLogs(ctx context.Context, req *LogStreamRequest, send func(*logtail.Line) error) error {
+	next := func(i int) (string, logtail.Level) {
+		now := time.Now().Format("2006/01/02 15:04:05")
+		switch req.Source {
+		case LogSourceXray:
+			if i%5 == 4 {
+				return now + " [Warning] [" + strconv.Itoa(1000+i) + "] app/dispatcher: default route for tcp:cdn.example.com:443", logtail.Warning
+			}
+			return now + " [Info] [" + strconv.Itoa(1000+i) + "] proxy/vless/inbound: firstLen = 1420", logtail.Info
+		case LogSourceAgent:
+			if i%10 == 9 {
+				return now + " WARNING - traffic flush took longer than expected", logtail.Warning
+			}
+			return now + " INFO - health check ok", logtail.Info
+		}
+		logs, _ := c.GetLogs(ctx, 1)
+		if len(logs) == 0 {
+			return "", logtail.Info
+		}
+		return logs[0], logtail.Info
+	}
+
+	emit := func(i int) error {
+		text, level := next(i)
+		if text == "" || level < req.Level {
+			return nil
+		}
+		return send(&logtail.Line{Source: req.Source, Level: level.String(), Text: text})
+	}
+
+	for i := 0; i < min(req.Tail, 20); i++ {
+		if err := emit(i); err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := emit(i); err != nil {
+			return err
+		}
+	}
+}
+
+// UpdateGeoFiles pretends to update the geo files.
+func (c *DemoConnector) UpdateGeoFiles(ctx context.Context) error {
+	return nil
+}
+
+// GetGeoFiles returns the simulated geo files.
+func (c *DemoConnector) GetGeoFiles(ctx context.Context) ([]*GeoFileInfo, error) {
+	modified := time.Now().Truncate(24 * time.Hour).Unix()
+	files := make([]*GeoFileInfo, 0, 2)
+	for _, name := range []string{"geoip.dat", "geosite.dat"} {
+		sum := sha256.Sum256([]byte(name + time.Unix(modified, 0).Format("2006-01-02")))
+		files = append(files, &GeoFileInfo{Name: name, Size: 10<<20 + int64(sum[0])<<12, SHA256: fmt.Sprintf("%x", sum), ModifiedAt: modified})
+	}
+	return files, nil
+}
+
+// InstallXray switches the simulated Xray version.
+func (c *DemoConnector) InstallXray(ctx context.Context, version string, progress ProgressFunc) error {
+	c.state.mu.Lock()
+	c.state.xrayVersion = strings.TrimPrefix(version, "v")
+	c.state.mu.Unlock()
+	recordServerEvent(c.server.Id, "xray", "Xray "+version+" installed")
+	return nil
+}
+
+// UpdateAgent switches the simulated agent version.
+func (c *DemoConnector) UpdateAgent(ctx context.Context, version string, progress ProgressFunc) error {
+	c.state.mu.Lock()
+	c.state.agentVersion = strings.TrimPrefix(version, "v")
+	c.state.mu.Unlock()
+	recordServerEvent(c.server.Id, "agent", "Agent "+version+" installed")
+	return nil
+}
+
+// SyncBlockedIPs accepts the blocklist.
+func (c *DemoConnector) SyncBlockedIPs(ctx context.Context, entries []*model.BlockedIP) error {
+	return nil
+}
+
+// ApplySettings accepts the fleet settings.
+func (c *DemoConnector) ApplySettings(ctx context.Context, settings *FleetSettings) error {
+	return nil
+}
+
+// GenerateKey generates key material with the local Xray binary.
+func (c *DemoConnector) GenerateKey(ctx context.Context, kind string, sni string) (any, error) {
+	serverService := ServerService{}
+	return serverService.GenerateKey(kind, sni)
+}
+
+// GenerateCert returns a simulated certificate for domain.
+func (c *DemoConnector) GenerateCert(ctx context.Context, domain string) (*CertInfo, error) {
+	now := time.Now()
+	return &CertInfo{
+		Domain:    domain,
+		CertPath:  "/root/cert/" + domain + "/fullchain.pem",
+		KeyPath:   "/root/cert/" + domain + "/privkey.pem",
+		IssuedBy:  "Demo CA",
+		NotBefore: now.Unix(),
+		NotAfter:  now.Add(90 * 24 * time.Hour).Unix(),
+		ValidDays: 90,
+		IsValid:   true,
+		AutoRenew: true,
+	}, nil
+}
+
+// GetCerts returns the simulated certificate of the server's endpoint host.
+func (c *DemoConnector) GetCerts(ctx context.Context) ([]*CertInfo, error) {
+	cert, err := c.GenerateCert(ctx, EndpointHost(c.server.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+	issued := c.state.started
+	cert.NotBefore = issued.Unix()
+	cert.NotAfter = issued.Add(90 * 24 * time.Hour).Unix()
+	cert.ValidDays = int(time.Until(issued.Add(90*24*time.Hour)).Hours() / 24)
+	return []*CertInfo{cert}, nil
+}
+
+// BackupDatabase is not available on demo servers.
+func (c *DemoConnector) BackupDatabase(ctx context.Context) ([]byte, error) {
+	return nil, errDemoUnsupported
+}
+
+// RestoreDatabase is not available on demo servers.
+func (c *DemoConnector) RestoreDatabase(ctx context.Context, data []byte) error {
+	return errDemoUnsupported
+}
@@ -0,0 +1,91 @@
+// Package service provides endpoint parsing and normalization for agent-managed servers.
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LocalEndpoint is the placeholder endpoint of the built-in local server.
+	LocalEndpoint = "local://"
+
+	// DefaultAgentPort is used when an endpoint does not specify a port.
+	DefaultAgentPort = 2054
+)
+
+// NormalizeEndpoint validates an agent endpoint and returns its canonical form.
+// Accepted inputs include bare hosts ("vpn1.example.com"), host:port pairs,
+// bare or bracketed IPv6 addresses ("2001:db8::1", "[2001:db8::1]:2054") and
+// full URLs. The scheme defaults to https and the port to DefaultAgentPort.
+// The result always has the form "scheme://host:port[/path]" with IPv6 hosts bracketed.
+func NormalizeEndpoint(raw string) (string, error) {
+	endpoint := strings.TrimSpace(raw)
+	if endpoint == "" {
+		return "", fmt.Errorf("endpoint is required")
+	}
+	if endpoint == LocalEndpoint {
+		return endpoint, nil
+	}
+
+	if !strings.Contains(endpoint, "://") {
+		// A bare IPv6 address must be bracketed before it can be parsed as a URL host
+		if ip := net.ParseIP(endpoint); ip != nil && ip.To4() == nil {
+			endpoint = "[" + endpoint + "]"
+		}
+		endpoint = "https://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", raw, err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "https" && scheme != "http" {
+		return "", fmt.Errorf("invalid endpoint %q: unsupported scheme %q (must be https or http)", raw, u.Scheme)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("invalid endpoint %q: credentials must not be embedded in the endpoint", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid endpoint %q: query and fragment are not allowed", raw)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", fmt.Errorf("invalid endpoint %q: host is missing", raw)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid endpoint %q: malformed IPv6 address %q", raw, host)
+	}
+
+	port := DefaultAgentPort
+	if p := u.Port(); p != "" {
+		port, err = strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("invalid endpoint %q: port must be between 1 and 65535", raw)
+		}
+	} else if strings.HasSuffix(u.Host, ":") {
+		return "", fmt.Errorf("invalid endpoint %q: port is empty", raw)
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + strings.TrimRight(u.Path, "/"), nil
+}
+
+// EndpointHost returns the bare host (without brackets or port) of an endpoint.
+// It returns an empty string for the local server or unparsable endpoints.
+func EndpointHost(endpoint string) string {
+	normalized, err := NormalizeEndpoint(endpoint)
+	if err != nil || normalized == LocalEndpoint {
+		return ""
+	}
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,235 @@
+// Package service provides EnrollmentService for agents that register themselves with one-time tokens.
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+const (
+	// enrollmentTokenPrefix starts every enrollment token, so leaked tokens are easy to recognize.
+	enrollmentTokenPrefix = "xet_"
+	// defaultEnrollmentTokenTTL is how long tokens created without an expiry are valid.
+	defaultEnrollmentTokenTTL = 24 * time.Hour
+)
+
+// ErrInvalidEnrollmentToken is returned for unknown, used and expired tokens alike, so
+// callers cannot probe which tokens exist.
+var ErrInvalidEnrollmentToken = errors.New("invalid or expired enrollment token")
+
+// AgentRegistration is sent by an agent started with AGENT_ENROLL_TOKEN.
+type AgentRegistration struct {
+	Token    string   `json:"token"`
+	Name     string   `json:"name"`     // Used if the token names no server
+	Endpoint string   `json:"endpoint"` // Public endpoint, empty = address the request came from
+	Port     int      `json:"port"`     // Agent port, used with the request address
+	Tags     []string `json:"tags"`     // Added to the tags of the token
+}
+
+// AgentEnrollment is returned to a registered agent: the server created for it and the
+// credentials the panel will call it with.
+type AgentEnrollment struct {
+	ServerId     int                `json:"serverId"`
+	Name         string             `json:"name"`
+	AuthType     string             `json:"authType"`
+	JWTSecret    string             `json:"jwtSecret,omitempty"`    // Set for "jwt"
+	Certificates *AgentCertificates `json:"certificates,omitempty"` // Set for "mtls"
+}
+
+// EnrollmentService manages one-time tokens that let new agents register themselves:
+// the panel creates the server, issues its credentials and hands them to the agent, so
+// no auth data has to be copied by hand. Tokens are only stored hashed.
+type EnrollmentService struct {
+	serverMgmt ServerManagementService
+	panelCA    PanelCAService
+}
+
+// GetTokens returns all enrollment tokens, newest first.
+func (s *EnrollmentService) GetTokens() ([]*model.EnrollmentToken, error) {
+	var tokens []*model.EnrollmentToken
+	err := database.GetDB().Model(model.EnrollmentToken{}).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// CreateToken creates an enrollment token and returns the token. Tokens without an
+// expiry are valid for defaultEnrollmentTokenTTL.
+func (s *EnrollmentService) CreateToken(token *model.EnrollmentToken) (string, error) {
+	token.Name = strings.TrimSpace(token.Name)
+	if token.AuthType != "mtls" && token.AuthType != "jwt" {
+		return "", fmt.Errorf("invalid auth type: %s (must be 'mtls' or 'jwt')", token.AuthType)
+	}
+	now := time.Now().Unix()
+	if token.ExpiresAt == 0 {
+		token.ExpiresAt = now + int64(defaultEnrollmentTokenTTL/time.Second)
+	} else if token.ExpiresAt <= now {
+		return "", fmt.Errorf("expiry must be in the future")
+	}
+	if token.Tags != "" {
+		var tags []string
+		if err := json.Unmarshal([]byte(token.Tags), &tags); err != nil {
+			return "", fmt.Errorf("tags must be a JSON array of strings")
+		}
+	}
+	if token.Name != "" {
+		var count int64
+		database.GetDB().Model(model.Server{}).Where("name = ?", token.Name).Count(&count)
+		if count > 0 {
+			return "", fmt.Errorf("server %s already exists", token.Name)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	plain := enrollmentTokenPrefix + hex.EncodeToString(secret)
+	token.Id = 0
+	token.TokenHash = hashServerAPIKey(plain)
+	token.Prefix = plain[:len(enrollmentTokenPrefix)+6]
+	token.UsedAt = 0
+	token.UsedBy = ""
+	token.ServerId = 0
+	if err := database.GetDB().Create(token).Error; err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// DeleteToken revokes an enrollment token. Servers registered with it are kept.
+func (s *EnrollmentService) DeleteToken(id int) error {
+	result := database.GetDB().Delete(&model.EnrollmentToken{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("enrollment token %d not found", id)
+	}
+	return nil
+}
+
+// Register consumes the token of a registration sent from remoteIp, creates the server
+// in the "pending" state and issues its credentials: a JWT secret, or an agent and a
+// panel certificate from the panel CA. The next health check that reaches the agent
+// marks the server online. The token is released again if the server cannot be created.
+func (s *EnrollmentService) Register(req *AgentRegistration, remoteIp string) (*AgentEnrollment, error) {
+	db := database.GetDB()
+	token := &model.EnrollmentToken{}
+	err := db.Model(model.EnrollmentToken{}).Where("token_hash = ?", hashServerAPIKey(strings.TrimSpace(req.Token))).First(token).Error
+	if err != nil || token.UsedAt != 0 || token.ExpiresAt <= time.Now().Unix() {
+		return nil, ErrInvalidEnrollmentToken
+	}
+
+	// Compare-and-swap, so a token can only be used once even by concurrent registrations
+	result := db.Model(model.EnrollmentToken{}).Where("id = ? AND used_at = 0", token.Id).
+		Updates(map[string]any{"used_at": time.Now().Unix(), "used_by": remoteIp})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidEnrollmentToken
+	}
+
+	server, enrollment, err := s.createServer(token, req, remoteIp)
+	if err != nil {
+		db.Model(token).Updates(map[string]any{"used_at": 0, "used_by": ""})
+		return nil, err
+	}
+	db.Model(token).Update("server_id", server.Id)
+
+	logger.Infof("Agent at %s registered as server %s with enrollment token %s", remoteIp, server.Name, token.Prefix)
+	recordServerEvent(server.Id, "config", fmt.Sprintf("Registered by the agent at %s with enrollment token %s", remoteIp, token.Prefix))
+	return enrollment, nil
+}
+
+// createServer creates the server of a registration along with its credentials.
+func (s *EnrollmentService) createServer(token *model.EnrollmentToken, req *AgentRegistration, remoteIp string) (*model.Server, *AgentEnrollment, error) {
+	name := token.Name
+	if name == "" {
+		name = strings.TrimSpace(req.Name)
+	}
+	if name == "" {
+		name = "agent-" + remoteIp
+	}
+
+	endpoint := strings.TrimSpace(req.Endpoint)
+	if endpoint == "" {
+		port := req.Port
+		if port <= 0 || port > 65535 {
+			port = DefaultAgentPort
+		}
+		endpoint = net.JoinHostPort(remoteIp, strconv.Itoa(port))
+	}
+	endpoint, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tags := make([]string, 0)
+	if token.Tags != "" {
+		json.Unmarshal([]byte(token.Tags), &tags)
+	}
+	for _, tag := range req.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !containsFold(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	encodedTags, err := json.Marshal(tags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enrollment := &AgentEnrollment{Name: name, AuthType: token.AuthType}
+	var authData []byte
+	switch token.AuthType {
+	case "jwt":
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, err
+		}
+		enrollment.JWTSecret = hex.EncodeToString(secret)
+		authData, err = json.Marshal(jwtAuthData{Secret: enrollment.JWTSecret, RotatedAt: time.Now().Unix()})
+	case "mtls":
+		enrollment.Certificates, err = s.panelCA.IssueAgentCertificates(name, endpointURL.Hostname())
+		if err != nil {
+			return nil, nil, err
+		}
+		var encoded string
+		encoded, err = enrollment.Certificates.authData()
+		authData = []byte(encoded)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := &model.Server{
+		Name:     name,
+		Endpoint: endpoint,
+		Region:   token.Region,
+		Tags:     string(encodedTags),
+		AuthType: token.AuthType,
+		AuthData: string(authData),
+		Status:   "pending",
+		Enabled:  true,
+	}
+	if err := s.serverMgmt.AddServer(server); err != nil {
+		return nil, nil, err
+	}
+	enrollment.ServerId = server.Id
+	return server, enrollment, nil
+}
@@ -0,0 +1,212 @@
+// Package service provides the panel event stream served as server-sent events.
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+const (
+	// eventBacklogSize is the number of recent events kept for clients that reconnect
+	// with Last-Event-ID.
+	eventBacklogSize = 256
+	// eventSubscriberBuffer is the number of events queued for a slow client before it
+	// is disconnected; it reconnects and catches up from the backlog.
+	eventSubscriberBuffer = 64
+	// eventStreamPingInterval is how often an idle stream sends a comment, so proxies do
+	// not close it.
+	eventStreamPingInterval = 25 * time.Second
+	// eventStreamRetry is the reconnect delay suggested to clients, in milliseconds.
+	eventStreamRetry = 5000
+)
+
+// Types of panel events. Streams can be filtered by type or by the part before the dot.
+const (
+	EventServerStatus = "server.status"
+	EventTask         = "task.update"
+	EventAlert        = "alert.update"
+	// EventResync tells a reconnecting client that events were missed, e.g. because the
+	// panel restarted, so it should reload the state it shows.
+	EventResync = "resync"
+)
+
+// PanelEvent is an event sent on the panel event stream.
+type PanelEvent struct {
+	Id   int64  `json:"id"`
+	Type string `json:"type"`
+	Time int64  `json:"time"` // Unix timestamp
+	Data any    `json:"data"`
+}
+
+// eventSubscriber is an open event stream.
+type eventSubscriber struct {
+	events chan *PanelEvent
+	types  []string // Empty = all types
+}
+
+var (
+	eventMu sync.Mutex
+	// eventLastId starts at the panel start time in milliseconds, so IDs from before a
+	// restart are always lower than new ones.
+	eventLastId      = time.Now().UnixMilli()
+	eventFirstId     = eventLastId + 1
+	eventBacklog     = make([]*PanelEvent, 0, eventBacklogSize)
+	eventSubscribers = make(map[*eventSubscriber]bool)
+)
+
+// publishEvent sends an event to all open streams that want its type.
+func publishEvent(eventType string, data any) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+
+	eventLastId++
+	event := &PanelEvent{Id: eventLastId, Type: eventType, Time: time.Now().Unix(), Data: data}
+	if len(eventBacklog) == eventBacklogSize {
+		eventBacklog = append(eventBacklog[:0], eventBacklog[1:]...)
+	}
+	eventBacklog = append(eventBacklog, event)
+
+	for subscriber := range eventSubscribers {
+		if !subscriber.wants(eventType) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			close(subscriber.events)
+			delete(eventSubscribers, subscriber)
+		}
+	}
+}
+
+// publishTaskEvent sends the state of a server task, with status and errorMessage as
+// they were just stored.
+func publishTaskEvent(task *model.ServerTask, status, errorMessage string) {
+	publishEvent(EventTask, map[string]any{
+		"id":         task.Id,
+		"serverId":   task.ServerId,
+		"operation":  task.Operation,
+		"status":     status,
+		"progress":   task.Progress,
+		"stage":      task.Stage,
+		"retryCount": task.RetryCount,
+		"error":      errorMessage,
+	})
+}
+
+// wants reports whether the subscriber receives events of eventType.
+func (s *eventSubscriber) wants(eventType string) bool {
+	if len(s.types) == 0 || eventType == EventResync {
+		return true
+	}
+	for _, t := range s.types {
+		if eventType == t || strings.HasPrefix(eventType, t+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribeEvents opens a subscription and returns the events after lastId it wants
+// from the backlog. lastId 0 replays nothing. If events after lastId are no longer in
+// the backlog, only a resync event is returned.
+func subscribeEvents(types []string, lastId int64) (*eventSubscriber, []*PanelEvent) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+
+	subscriber := &eventSubscriber{events: make(chan *PanelEvent, eventSubscriberBuffer), types: types}
+	eventSubscribers[subscriber] = true
+	if lastId <= 0 || lastId >= eventLastId {
+		return subscriber, nil
+	}
+
+	oldest := eventFirstId
+	if len(eventBacklog) > 0 {
+		oldest = eventBacklog[0].Id
+	}
+	if lastId < oldest-1 {
+		return subscriber, []*PanelEvent{{Id: eventLastId, Type: EventResync, Time: time.Now().Unix(), Data: map[string]any{}}}
+	}
+	replay := make([]*PanelEvent, 0)
+	for _, event := range eventBacklog {
+		if event.Id > lastId && subscriber.wants(event.Type) {
+			replay = append(replay, event)
+		}
+	}
+	return subscriber, replay
+}
+
+// unsubscribeEvents closes a subscription, unless publishEvent already dropped it.
+func unsubscribeEvents(subscriber *eventSubscriber) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if eventSubscribers[subscriber] {
+		close(subscriber.events)
+		delete(eventSubscribers, subscriber)
+	}
+}
+
+// ServeEventStream streams panel events of types (all if empty) to a client as
+// server-sent events until it disconnects. Events after lastId are replayed first. A
+// client that falls too far behind is disconnected and catches up when it reconnects.
+func ServeEventStream(w http.ResponseWriter, r *http.Request, types []string, lastId int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	subscriber, replay := subscribeEvents(types, lastId)
+	defer unsubscribeEvents(subscriber)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Keeps nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry)
+	for _, event := range replay {
+		if writeEvent(w, event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventStreamPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-subscriber.events:
+			if !ok {
+				return
+			}
+			if writeEvent(w, event) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, event *PanelEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
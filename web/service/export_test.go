@@ -0,0 +1,38 @@
+package service
+
+import "encoding/json"
+
+// DetectAnomaliesAt exposes TrafficAnomalyService.detect to the external tests.
+var DetectAnomaliesAt = (*TrafficAnomalyService).detect
+
+// PruneTrafficSamples exposes BillingService.pruneSamples to the external tests.
+var PruneTrafficSamples = (*BillingService).pruneSamples
+
+// ClaimInboundVersion exposes claimInboundVersion to the external tests.
+var ClaimInboundVersion = claimInboundVersion
+
+// RequestNotSent exposes requestNotSent to the external tests.
+var RequestNotSent = requestNotSent
+
+// IssuedClientCert returns the panel client certificate of unsealed issued auth data.
+func IssuedClientCert(authData string) string {
+	issued := &issuedAuthData{}
+	json.Unmarshal([]byte(authData), issued)
+	return issued.CertPem
+}
+
+// OpenStandbySnapshot exposes openStandbySnapshot to the external tests.
+var OpenStandbySnapshot = openStandbySnapshot
+
+// SetSetting stores a setting by key.
+func SetSetting(key, value string) error {
+	return (&SettingService{}).setString(key, value)
+}
+
+// SetXrayDigestSource replaces where Xray release digests are fetched from.
+func SetXrayDigestSource(source string) {
+	xrayDigestSource = source
+}
+
+// DownloadXray exposes ServerService.downloadXRay to the external tests.
+var DownloadXray = (*ServerService).downloadXRay
@@ -0,0 +1,90 @@
+// Package service provides FanOut for calling many servers at once.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/common"
+)
+
+const (
+	// fanOutConcurrency is the default number of servers called at once.
+	fanOutConcurrency = 10
+	// fanOutTimeout is the default time one server may take.
+	fanOutTimeout = 10 * time.Second
+)
+
+// FanOutOptions tunes a fan-out. Zero values use the defaults.
+type FanOutOptions struct {
+	Concurrency int           // Servers called at once, default 10
+	Timeout     time.Duration // Time one server may take, default 10s
+}
+
+// FanOutResult is the outcome of calling one server.
+type FanOutResult[T any] struct {
+	Server *model.Server
+	Value  T
+	Err    error // Prefixed with the server name
+}
+
+// FanOut calls fn for every server, at most opts.Concurrency at a time, and returns the
+// results in the order of servers once all are done. Each call gets its own timeout,
+// which does not depend on how much time ctx has left, so one slow server cannot use up
+// the budget of the others; ctx only stops calls that did not start yet. Failed calls
+// leave a zero Value and an error naming the server, so callers can use partial results.
+func FanOut[T any](ctx context.Context, servers []*model.Server, opts FanOutOptions, fn func(ctx context.Context, server *model.Server) (T, error)) []*FanOutResult[T] {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = fanOutConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = fanOutTimeout
+	}
+	detached := context.WithoutCancel(ctx)
+
+	results := make([]*FanOutResult[T], len(servers))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		result := &FanOutResult[T]{Server: server}
+		results[i] = result
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := ctx.Err(); err != nil {
+				result.Err = fmt.Errorf("server %s: not called: %w", server.Name, err)
+				return
+			}
+			callCtx, cancel := context.WithTimeout(detached, opts.Timeout)
+			defer cancel()
+			value, err := fn(callCtx, server)
+			switch {
+			case err != nil && errors.Is(callCtx.Err(), context.DeadlineExceeded):
+				result.Err = fmt.Errorf("server %s: no answer within %s: %w", server.Name, opts.Timeout, err)
+			case err != nil:
+				result.Err = fmt.Errorf("server %s: %w", server.Name, err)
+			default:
+				result.Value = value
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// FanOutErrors combines the errors of the failed calls of a fan-out, nil if all succeeded.
+func FanOutErrors[T any](results []*FanOutResult[T]) error {
+	errs := make([]error, 0)
+	for _, result := range results {
+		errs = append(errs, result.Err)
+	}
+	return common.Combine(errs...)
+}
@@ -0,0 +1,170 @@
+// Package service provides FleetOverviewService for the per-server fleet dashboard.
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// fleetHistorySize is the number of samples kept per server; with the 30s health check
+// interval this covers the last 30 minutes.
+const fleetHistorySize = 60
+
+// FleetSample is one point of a server's sparkline.
+type FleetSample struct {
+	Time      int64   `json:"time"`      // Unix seconds
+	Cpu       float64 `json:"cpu"`       // Percentage (0-100)
+	Mem       float64 `json:"mem"`       // Percentage (0-100)
+	Online    int     `json:"online"`    // Online clients
+	LatencyMs int64   `json:"latencyMs"` // Health check round trip
+}
+
+// fleetSnapshot is the latest state collected for a server by the health job.
+type fleetSnapshot struct {
+	latencyMs   int64
+	xrayRunning bool
+	stats       *SystemStats
+	online      int
+	history     []FleetSample
+}
+
+var (
+	fleetMu        sync.RWMutex
+	fleetSnapshots = make(map[int]*fleetSnapshot)
+)
+
+// ServerOverview is one row of the fleet dashboard.
+type ServerOverview struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Region      string `json:"region"`
+	Enabled     bool   `json:"enabled"`
+	Status      string `json:"status"`
+	LastSeen    int64  `json:"lastSeen"`
+	LastError   string `json:"lastError"`
+	XrayVersion string `json:"xrayVersion"`
+
+	LatencyMs     int64   `json:"latencyMs"`
+	Cpu           float64 `json:"cpu"`
+	CpuCores      int     `json:"cpuCores"`
+	MemTotal      uint64  `json:"memTotal"`
+	MemUsed       uint64  `json:"memUsed"`
+	DiskTotal     uint64  `json:"diskTotal"`
+	DiskUsed      uint64  `json:"diskUsed"`
+	XrayState     string  `json:"xrayState"` // "running", "stop", or "unknown" before the first sample
+	OnlineClients int     `json:"onlineClients"`
+	AppMem        uint64  `json:"appMem"`        // Memory of the agent process, 0 if not reported
+	AppGoroutines int     `json:"appGoroutines"` // Goroutines of the agent process
+	AppUptime     int64   `json:"appUptime"`     // Seconds since the agent started
+
+	History []FleetSample `json:"history"`
+}
+
+// FleetOverviewService keeps recent metrics of every server for the fleet dashboard.
+type FleetOverviewService struct {
+	serverMgmt ServerManagementService
+}
+
+// RecordSample collects system stats and online clients of a server after a successful
+// health check and appends them to its history.
+func (s *FleetOverviewService) RecordSample(ctx context.Context, serverId int, connector ServerConnector, latency time.Duration, xrayRunning bool) {
+	stats, err := connector.GetSystemStats(ctx)
+	if err != nil {
+		stats = nil // Keep the sample; only the system metrics are missing
+	}
+	online := 0
+	if clients, err := connector.GetOnlineClients(ctx); err == nil {
+		online = len(clients)
+	}
+
+	sample := FleetSample{
+		Time:      time.Now().Unix(),
+		Online:    online,
+		LatencyMs: latency.Milliseconds(),
+	}
+	if stats != nil {
+		sample.Cpu = stats.CPUUsage
+		sample.Mem = stats.MemUsage
+	}
+
+	fleetMu.Lock()
+	defer fleetMu.Unlock()
+	snapshot, ok := fleetSnapshots[serverId]
+	if !ok {
+		snapshot = &fleetSnapshot{}
+		fleetSnapshots[serverId] = snapshot
+	}
+	snapshot.latencyMs = sample.LatencyMs
+	snapshot.xrayRunning = xrayRunning
+	snapshot.stats = stats
+	snapshot.online = online
+	snapshot.history = append(snapshot.history, sample)
+	if len(snapshot.history) > fleetHistorySize {
+		snapshot.history = snapshot.history[len(snapshot.history)-fleetHistorySize:]
+	}
+}
+
+// GetOverview returns every server with its latest metrics and sparkline history.
+// It only reads what the health job collected, so it never waits on the servers.
+func (s *FleetOverviewService) GetOverview() ([]*ServerOverview, error) {
+	servers, err := s.serverMgmt.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+
+	fleetMu.RLock()
+	defer fleetMu.RUnlock()
+	overview := make([]*ServerOverview, 0, len(servers))
+	for _, server := range servers {
+		overview = append(overview, newServerOverview(server, fleetSnapshots[server.Id]))
+	}
+	return overview, nil
+}
+
+// newServerOverview combines a server record with its latest snapshot, if any.
+func newServerOverview(server *model.Server, snapshot *fleetSnapshot) *ServerOverview {
+	row := &ServerOverview{
+		Id:          server.Id,
+		Name:        server.Name,
+		Region:      server.Region,
+		Enabled:     server.Enabled,
+		Status:      server.Status,
+		LastSeen:    server.LastSeen,
+		LastError:   server.LastError,
+		XrayVersion: server.XrayVersion,
+		XrayState:   "unknown",
+		History:     []FleetSample{},
+	}
+	if snapshot == nil {
+		return row
+	}
+	if server.Status != "online" {
+		// Keep the history, but the last sample no longer describes the server
+		row.History = append(row.History, snapshot.history...)
+		return row
+	}
+
+	row.LatencyMs = snapshot.latencyMs
+	row.OnlineClients = snapshot.online
+	if snapshot.xrayRunning {
+		row.XrayState = "running"
+	} else {
+		row.XrayState = "stop"
+	}
+	if stats := snapshot.stats; stats != nil {
+		row.Cpu = stats.CPUUsage
+		row.CpuCores = stats.CPUCores
+		row.MemTotal = stats.MemTotal
+		row.MemUsed = stats.MemUsed
+		row.DiskTotal = stats.DiskTotal
+		row.DiskUsed = stats.DiskUsed
+		row.AppMem = stats.AppMem
+		row.AppGoroutines = stats.AppGoroutines
+		row.AppUptime = stats.AppUptime
+	}
+	row.History = append(row.History, snapshot.history...)
+	return row
+}
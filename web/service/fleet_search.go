@@ -0,0 +1,348 @@
+// Package service provides FleetSearchService for searching all object types across servers.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// fleetSearchConcurrency is the number of servers whose inbounds are searched at once.
+const fleetSearchConcurrency = 10
+
+// SearchResult is one object matching a fleet search query.
+type SearchResult struct {
+	Type       string `json:"type"` // "server", "inbound", "client" or "task"
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	Id         int    `json:"id,omitempty"`        // Server, inbound or task ID
+	InboundId  int    `json:"inboundId,omitempty"` // Inbound of a client
+	Title      string `json:"title"`
+	Field      string `json:"field"` // Field that matched the query
+	Value      string `json:"value"` // Value of the matched field
+}
+
+// FleetSearchService finds servers, inbounds, clients and tasks matching a query.
+type FleetSearchService struct {
+	serverMgmt ServerManagementService
+	warnings   InboundWarningService
+}
+
+// Search returns up to limit results per type matching the query (case-insensitive
+// substring; ports match exactly). Inbounds and clients are searched live on all
+// enabled servers; servers that cannot be reached are skipped.
+func (s *FleetSearchService) Search(ctx context.Context, query string, limit int) ([]*SearchResult, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return []*SearchResult{}, nil
+	}
+
+	servers, err := s.serverMgmt.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(servers))
+	for _, server := range servers {
+		names[server.Id] = server.Name
+	}
+
+	results := make([]*SearchResult, 0)
+	results = append(results, truncateResults(s.searchServers(servers, query), limit)...)
+
+	inbounds, clients := s.searchInbounds(ctx, servers, query)
+	results = append(results, truncateResults(inbounds, limit)...)
+	results = append(results, truncateResults(clients, limit)...)
+
+	tasks, err := s.searchTasks(query, limit, names)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, tasks...), nil
+}
+
+// searchServers matches server name, endpoint, region, tags and notes.
+func (s *FleetSearchService) searchServers(servers []*model.Server, query string) []*SearchResult {
+	results := make([]*SearchResult, 0)
+	for _, server := range servers {
+		fields := [][2]string{
+			{"name", server.Name},
+			{"endpoint", server.Endpoint},
+			{"region", server.Region},
+			{"tags", server.Tags},
+			{"notes", server.Notes},
+		}
+		if field, value, ok := matchFields(fields, query); ok {
+			results = append(results, &SearchResult{
+				Type:       "server",
+				ServerId:   server.Id,
+				ServerName: server.Name,
+				Id:         server.Id,
+				Title:      server.Name,
+				Field:      field,
+				Value:      value,
+			})
+		}
+	}
+	return results
+}
+
+// searchInbounds lists the inbounds of every enabled server concurrently and matches
+// inbounds by remark, tag and port, and their clients by email, subId and ID.
+func (s *FleetSearchService) searchInbounds(ctx context.Context, servers []*model.Server, query string) ([]*SearchResult, []*SearchResult) {
+	enabled := make([]*model.Server, 0, len(servers))
+	for _, server := range servers {
+		if server.Enabled {
+			enabled = append(enabled, server)
+		}
+	}
+
+	inbounds := make([]*SearchResult, 0)
+	clients := make([]*SearchResult, 0)
+	for _, result := range s.listInbounds(ctx, enabled) {
+		if result.Err != nil {
+			logger.Debug("Fleet search: failed to list inbounds:", result.Err)
+			continue
+		}
+		serverInbounds, serverClients := matchInbounds(result.Server, result.Value, query)
+		inbounds = append(inbounds, serverInbounds...)
+		clients = append(clients, serverClients...)
+	}
+
+	// Goroutines finish in any order; keep the results stable
+	for _, results := range [][]*SearchResult{inbounds, clients} {
+		sort.SliceStable(results, func(i, j int) bool {
+			if results[i].ServerId != results[j].ServerId {
+				return results[i].ServerId < results[j].ServerId
+			}
+			return results[i].Title < results[j].Title
+		})
+	}
+	return inbounds, clients
+}
+
+// matchInbounds matches the inbounds of one server and their clients.
+func matchInbounds(server *model.Server, inbounds []*model.Inbound, query string) ([]*SearchResult, []*SearchResult) {
+	inboundResults := make([]*SearchResult, 0)
+	clientResults := make([]*SearchResult, 0)
+	for _, inbound := range inbounds {
+		field, value, ok := matchFields([][2]string{{"remark", inbound.Remark}, {"tag", inbound.Tag}}, query)
+		if !ok && strconv.Itoa(inbound.Port) == query {
+			field, value, ok = "port", query, true
+		}
+		if ok {
+			inboundResults = append(inboundResults, &SearchResult{
+				Type:       "inbound",
+				ServerId:   server.Id,
+				ServerName: server.Name,
+				Id:         inbound.Id,
+				Title:      inbound.Remark,
+				Field:      field,
+				Value:      value,
+			})
+		}
+
+		var settings struct {
+			Clients []model.Client `json:"clients"`
+		}
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			continue
+		}
+		for _, client := range settings.Clients {
+			fields := [][2]string{
+				{"email", client.Email},
+				{"subId", client.SubID},
+				{"id", client.ID},
+				{"password", client.Password},
+			}
+			if field, value, ok := matchFields(fields, query); ok {
+				clientResults = append(clientResults, &SearchResult{
+					Type:       "client",
+					ServerId:   server.Id,
+					ServerName: server.Name,
+					InboundId:  inbound.Id,
+					Title:      client.Email,
+					Field:      field,
+					Value:      value,
+				})
+			}
+		}
+	}
+	return inboundResults, clientResults
+}
+
+// ClientMatch is a client found by a fleet client search, with where it lives.
+type ClientMatch struct {
+	ServerId      int      `json:"serverId"`
+	ServerName    string   `json:"serverName"`
+	InboundId     int      `json:"inboundId"`
+	InboundRemark string   `json:"inboundRemark"`
+	Protocol      string   `json:"protocol"`
+	Email         string   `json:"email"`
+	SubId         string   `json:"subId"`
+	Enable        bool     `json:"enable"`
+	ExpiryTime    int64    `json:"expiryTime"`
+	TotalGB       int64    `json:"totalGB"`
+	Up            int64    `json:"up"`
+	Down          int64    `json:"down"`
+	Warnings      []string `json:"warnings,omitempty"` // Soft limit flags, see InboundWarningService
+}
+
+// SearchClients finds the clients on all enabled servers whose email contains email
+// (case-insensitive) and whose subId equals subId; empty parameters match any client.
+// Servers are queried concurrently; the names of servers that could not be searched are
+// returned alongside the matches.
+func (s *FleetSearchService) SearchClients(ctx context.Context, email, subId string) ([]*ClientMatch, []string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	subId = strings.TrimSpace(subId)
+	if email == "" && subId == "" {
+		return nil, nil, fmt.Errorf("email or subId is required")
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matches := make([]*ClientMatch, 0)
+	failed := make([]string, 0)
+	for _, result := range s.listInbounds(ctx, servers) {
+		if result.Err != nil {
+			logger.Debug("Client search: failed to list inbounds:", result.Err)
+			failed = append(failed, result.Server.Name)
+			continue
+		}
+		matches = append(matches, matchClients(result.Server, result.Value, email, subId)...)
+	}
+
+	s.warnings.AnnotateClients(matches)
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Email != matches[j].Email {
+			return matches[i].Email < matches[j].Email
+		}
+		if matches[i].ServerId != matches[j].ServerId {
+			return matches[i].ServerId < matches[j].ServerId
+		}
+		return matches[i].InboundId < matches[j].InboundId
+	})
+	sort.Strings(failed)
+	return matches, failed, nil
+}
+
+// matchClients returns the clients of one server's inbounds matching email and subId.
+func matchClients(server *model.Server, inbounds []*model.Inbound, email, subId string) []*ClientMatch {
+	matches := make([]*ClientMatch, 0)
+	for _, inbound := range inbounds {
+		var settings struct {
+			Clients []model.Client `json:"clients"`
+		}
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			continue
+		}
+		for _, client := range settings.Clients {
+			if email != "" && !strings.Contains(strings.ToLower(client.Email), email) {
+				continue
+			}
+			if subId != "" && client.SubID != subId {
+				continue
+			}
+			match := &ClientMatch{
+				ServerId:      server.Id,
+				ServerName:    server.Name,
+				InboundId:     inbound.Id,
+				InboundRemark: inbound.Remark,
+				Protocol:      string(inbound.Protocol),
+				Email:         client.Email,
+				SubId:         client.SubID,
+				Enable:        client.Enable,
+				ExpiryTime:    client.ExpiryTime,
+				TotalGB:       client.TotalGB,
+			}
+			for _, stat := range inbound.ClientStats {
+				if stat.Email == client.Email {
+					match.Up = stat.Up
+					match.Down = stat.Down
+					break
+				}
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// searchTasks matches tasks by ID, operation, status and error message, newest first.
+func (s *FleetSearchService) searchTasks(query string, limit int, names map[int]string) ([]*SearchResult, error) {
+	db := database.GetDB().Model(model.ServerTask{})
+	pattern := "%" + query + "%"
+	condition := "LOWER(operation) LIKE ? OR LOWER(status) LIKE ? OR LOWER(error_message) LIKE ?"
+	if id, err := strconv.Atoi(query); err == nil {
+		db = db.Where("id = ? OR "+condition, id, pattern, pattern, pattern)
+	} else {
+		db = db.Where(condition, pattern, pattern, pattern)
+	}
+
+	var tasks []*model.ServerTask
+	if err := db.Order("id DESC").Limit(limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0, len(tasks))
+	for _, task := range tasks {
+		field, value, ok := matchFields([][2]string{
+			{"operation", task.Operation},
+			{"status", task.Status},
+			{"errorMessage", task.ErrorMessage},
+		}, query)
+		if !ok {
+			field, value = "id", strconv.Itoa(task.Id)
+		}
+		results = append(results, &SearchResult{
+			Type:       "task",
+			ServerId:   task.ServerId,
+			ServerName: names[task.ServerId],
+			Id:         task.Id,
+			Title:      task.Operation,
+			Field:      field,
+			Value:      value,
+		})
+	}
+	return results, nil
+}
+
+// matchFields returns the first field whose value contains the lowercase query.
+func matchFields(fields [][2]string, query string) (string, string, bool) {
+	for _, field := range fields {
+		if field[1] != "" && strings.Contains(strings.ToLower(field[1]), query) {
+			return field[0], field[1], true
+		}
+	}
+	return "", "", false
+}
+
+// truncateResults returns at most limit results.
+func truncateResults(results []*SearchResult, limit int) []*SearchResult {
+	if len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
+
+// listInbounds lists the inbounds of servers, fleetSearchConcurrency at a time.
+func (s *FleetSearchService) listInbounds(ctx context.Context, servers []*model.Server) []*FanOutResult[[]*model.Inbound] {
+	return FanOut(ctx, servers, FanOutOptions{Concurrency: fleetSearchConcurrency},
+		func(ctx context.Context, server *model.Server) ([]*model.Inbound, error) {
+			connector, err := s.serverMgmt.GetConnector(server.Id)
+			if err != nil {
+				return nil, err
+			}
+			return connector.ListInbounds(ctx)
+		})
+}
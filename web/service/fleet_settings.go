@@ -0,0 +1,367 @@
+// Package service provides FleetSettingsService for pushing panel settings to all servers.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+	"github.com/cofedish/3x-UI-agents/web/entity"
+	"github.com/cofedish/3x-UI-agents/xray"
+)
+
+// FleetSettingKeys are the settings that can be pushed from the panel to servers.
+var FleetSettingKeys = []string{"xrayLogLevel", "xrayDnsConfig", "xrayPolicyLevels", "geoSources", "trafficCollectInterval", "publicIpResolver"}
+
+// xrayFleetSettings are the fleet settings that change the generated Xray config.
+var xrayFleetSettings = []string{"xrayLogLevel", "xrayDnsConfig", "xrayPolicyLevels"}
+
+// settingsPushTimeout bounds pushing settings to a single server.
+const settingsPushTimeout = 30 * time.Second
+
+// FleetSettings is a versioned set of settings pushed to servers.
+type FleetSettings struct {
+	Version  int               `json:"version"`
+	Settings map[string]string `json:"settings"`
+}
+
+// ServerSettingsStatus is the fleet settings state of one server.
+type ServerSettingsStatus struct {
+	ServerId         int      `json:"serverId"`
+	ServerName       string   `json:"serverName"`
+	AppliedVersion   int      `json:"appliedVersion"`
+	AttemptedVersion int      `json:"attemptedVersion"`
+	Keys             []string `json:"keys"`
+	Status           string   `json:"status"` // "applied", "failed" or "never" if nothing was pushed yet
+	AppliedAt        int64    `json:"appliedAt"`
+	AttemptedAt      int64    `json:"attemptedAt"`
+	LastError        string   `json:"lastError,omitempty"`
+}
+
+// FleetSettingsService pushes selected panel settings to servers in one action and
+// tracks which settings version each server has applied.
+type FleetSettingsService struct {
+	settingService SettingService
+	serverMgmt     ServerManagementService
+	serverScope    ServerScopeService
+}
+
+// ValidateFleetSettings checks that settings only contains valid fleet settings.
+func ValidateFleetSettings(settings map[string]string) error {
+	for key, value := range settings {
+		if err := entity.ValidateFleetSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFleetSettings returns the current values of keys (all fleet settings if empty)
+// together with the version of the last push.
+func (s *FleetSettingsService) GetFleetSettings(keys []string) (*FleetSettings, error) {
+	if len(keys) == 0 {
+		keys = FleetSettingKeys
+	}
+	version, err := s.settingService.GetFleetSettingsVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if !slices.Contains(FleetSettingKeys, key) {
+			return nil, fmt.Errorf("setting %q cannot be pushed to servers", key)
+		}
+		value, err := s.settingService.getString(key)
+		if err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return &FleetSettings{Version: version, Settings: settings}, nil
+}
+
+// ApplyFleetSettings stores settings pushed by the panel and records their version.
+// It reports whether a setting affecting the Xray config changed.
+func (s *FleetSettingsService) ApplyFleetSettings(pushed *FleetSettings) (bool, error) {
+	if err := ValidateFleetSettings(pushed.Settings); err != nil {
+		return false, err
+	}
+
+	xrayChanged := false
+	errs := make([]error, 0)
+	for key, value := range pushed.Settings {
+		current, err := s.settingService.getString(key)
+		if err == nil && current == value {
+			continue
+		}
+		if err := s.settingService.saveSetting(key, value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if slices.Contains(xrayFleetSettings, key) {
+			xrayChanged = true
+		}
+	}
+	if err := common.Combine(errs...); err != nil {
+		return xrayChanged, err
+	}
+	return xrayChanged, s.settingService.SetFleetSettingsVersion(pushed.Version)
+}
+
+// PushSettings sends the current values of keys (all fleet settings if empty) as a new
+// settings version to the enabled servers of scope, the given servers, or all enabled
+// servers, and records the outcome per server. Failures are reported in the returned
+// statuses rather than as an error.
+func (s *FleetSettingsService) PushSettings(ctx context.Context, keys []string, scope string, serverIds []int) ([]*ServerSettingsStatus, error) {
+	servers, err := s.targetServers(scope, serverIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers to push settings to")
+	}
+
+	settings, err := s.GetFleetSettings(keys)
+	if err != nil {
+		return nil, err
+	}
+	settings.Version++
+	if err := s.settingService.SetFleetSettingsVersion(settings.Version); err != nil {
+		return nil, err
+	}
+
+	pushed := make([]string, 0, len(settings.Settings))
+	for key := range settings.Settings {
+		pushed = append(pushed, key)
+	}
+	slices.Sort(pushed)
+
+	statuses := make([]*ServerSettingsStatus, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *model.Server) {
+			defer wg.Done()
+			pushErr := s.pushServer(ctx, server.Id, settings)
+			status, err := s.recordResult(server.Id, settings.Version, pushed, pushErr)
+			if err != nil {
+				logger.Warning("Failed to record fleet settings status:", err)
+			}
+			status.ServerName = server.Name
+			statuses[i] = status
+		}(i, server)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, status := range statuses {
+		if status.Status == "failed" {
+			failed++
+		}
+	}
+	logger.Infof("Fleet settings version %d pushed to %d servers, %d failed", settings.Version, len(servers), failed)
+	return statuses, nil
+}
+
+// GetStatuses returns the fleet settings state of every server.
+func (s *FleetSettingsService) GetStatuses() ([]*ServerSettingsStatus, error) {
+	servers, err := s.serverMgmt.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+	var records []*model.ServerSettingsSync
+	if err := database.GetDB().Model(model.ServerSettingsSync{}).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	byServer := make(map[int]*model.ServerSettingsSync, len(records))
+	for _, record := range records {
+		byServer[record.ServerId] = record
+	}
+
+	statuses := make([]*ServerSettingsStatus, 0, len(servers))
+	for _, server := range servers {
+		status := &ServerSettingsStatus{ServerId: server.Id, Status: "never"}
+		if record, ok := byServer[server.Id]; ok {
+			status = newServerSettingsStatus(record)
+		}
+		status.ServerName = server.Name
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// targetServers resolves the servers a push goes to. Disabled servers are skipped.
+func (s *FleetSettingsService) targetServers(scope string, serverIds []int) ([]*model.Server, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case scope != "":
+		serverScope, err := s.serverScope.GetScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		members, err := s.serverScope.ResolveScope(serverScope)
+		if err != nil {
+			return nil, err
+		}
+		selected := make([]*model.Server, 0, len(members))
+		for _, member := range members {
+			if member.Enabled {
+				selected = append(selected, member)
+			}
+		}
+		return selected, nil
+	case len(serverIds) > 0:
+		selected := make([]*model.Server, 0, len(serverIds))
+		for _, id := range serverIds {
+			index := slices.IndexFunc(servers, func(server *model.Server) bool { return server.Id == id })
+			if index < 0 {
+				return nil, fmt.Errorf("server %d not found or disabled", id)
+			}
+			selected = append(selected, servers[index])
+		}
+		return selected, nil
+	}
+	return servers, nil
+}
+
+// pushServer sends settings to one server.
+func (s *FleetSettingsService) pushServer(ctx context.Context, serverId int, settings *FleetSettings) error {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, settingsPushTimeout)
+	defer cancel()
+	return connector.ApplySettings(ctx, settings)
+}
+
+// recordResult stores the outcome of pushing a settings version to a server.
+func (s *FleetSettingsService) recordResult(serverId int, version int, keys []string, pushErr error) (*ServerSettingsStatus, error) {
+	db := database.GetDB()
+	record := &model.ServerSettingsSync{}
+	err := db.Model(model.ServerSettingsSync{}).Where("server_id = ?", serverId).First(record).Error
+	if err != nil && !database.IsNotFound(err) {
+		return &ServerSettingsStatus{ServerId: serverId, Status: "failed", LastError: err.Error()}, err
+	}
+
+	keysJSON, _ := json.Marshal(keys)
+	now := time.Now().Unix()
+	record.ServerId = serverId
+	record.AttemptedVersion = version
+	record.Keys = string(keysJSON)
+	record.AttemptedAt = now
+	if pushErr == nil {
+		record.Status = "applied"
+		record.AppliedVersion = version
+		record.AppliedAt = now
+		record.LastError = ""
+		recordServerEvent(serverId, "config", "Fleet settings version "+strconv.Itoa(version)+" applied")
+	} else {
+		record.Status = "failed"
+		record.LastError = pushErr.Error()
+		recordServerEvent(serverId, "config", "Fleet settings version "+strconv.Itoa(version)+" failed: "+pushErr.Error())
+	}
+
+	status := newServerSettingsStatus(record)
+	return status, db.Save(record).Error
+}
+
+// newServerSettingsStatus converts a stored sync record for the API.
+func newServerSettingsStatus(record *model.ServerSettingsSync) *ServerSettingsStatus {
+	status := &ServerSettingsStatus{
+		ServerId:         record.ServerId,
+		AppliedVersion:   record.AppliedVersion,
+		AttemptedVersion: record.AttemptedVersion,
+		Status:           record.Status,
+		AppliedAt:        record.AppliedAt,
+		AttemptedAt:      record.AttemptedAt,
+		LastError:        record.LastError,
+	}
+	json.Unmarshal([]byte(record.Keys), &status.Keys)
+	return status
+}
+
+// applyFleetXraySettings overrides the template log level and DNS section with the
+// xrayLogLevel and xrayDnsConfig settings and merges the xrayPolicyLevels presets into
+// the policy levels, if set.
+func applyFleetXraySettings(settings *SettingService, xrayConfig *xray.Config) error {
+	level, err := settings.GetXrayLogLevel()
+	if err != nil {
+		return err
+	}
+	if level != "" {
+		logConfig := map[string]any{}
+		if len(xrayConfig.LogConfig) > 0 {
+			if err := json.Unmarshal(xrayConfig.LogConfig, &logConfig); err != nil {
+				return err
+			}
+		}
+		logConfig["loglevel"] = level
+		data, err := json.Marshal(logConfig)
+		if err != nil {
+			return err
+		}
+		xrayConfig.LogConfig = data
+	}
+
+	dns, err := settings.GetXrayDnsConfig()
+	if err != nil {
+		return err
+	}
+	if dns != "" {
+		xrayConfig.DNSConfig = []byte(dns)
+	}
+
+	levels, err := settings.GetXrayPolicyLevels()
+	if err != nil {
+		return err
+	}
+	if levels != "" {
+		return applyPolicyLevels(xrayConfig, levels)
+	}
+	return nil
+}
+
+// applyPolicyLevels replaces the template policy levels with the presets of the same
+// level. Preset names are panel metadata and not passed to Xray.
+func applyPolicyLevels(xrayConfig *xray.Config, value string) error {
+	presets := map[string]map[string]any{}
+	if err := json.Unmarshal([]byte(value), &presets); err != nil {
+		return err
+	}
+	policy := map[string]any{}
+	if len(xrayConfig.Policy) > 0 {
+		if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+			return err
+		}
+	}
+	levels, _ := policy["levels"].(map[string]any)
+	if levels == nil {
+		levels = map[string]any{}
+	}
+	for level, preset := range presets {
+		delete(preset, "name")
+		levels[level] = preset
+	}
+	policy["levels"] = levels
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	xrayConfig.Policy = data
+	return nil
+}
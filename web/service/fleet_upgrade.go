@@ -0,0 +1,300 @@
+// Package service provides FleetUpgradeService for upgrading Xray across all servers.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/redact"
+)
+
+const (
+	// XrayUpgradeOperation is the ServerTask operation of fleet Xray upgrades.
+	XrayUpgradeOperation = "install_xray"
+
+	// DefaultUpgradeConcurrency is how many servers are upgraded at once by default.
+	DefaultUpgradeConcurrency = 3
+
+	// xrayInstallTimeout bounds a single server's download and install.
+	xrayInstallTimeout = 10 * time.Minute
+	// xrayVerifyTimeout bounds waiting for the upgraded version to be reported.
+	xrayVerifyTimeout = time.Minute
+	// staleUpgradeAge is when unfinished tasks (e.g. interrupted by a panel restart)
+	// stop blocking new upgrades.
+	staleUpgradeAge = 30 * time.Minute
+)
+
+// FleetUpgradeService reports Xray versions across servers and upgrades them through ServerTask records.
+type FleetUpgradeService struct {
+	serverMgmt ServerManagementService
+}
+
+// ServerXrayVersion is the Xray version installed on one server.
+type ServerXrayVersion struct {
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	Version    string `json:"version"`
+	Error      string `json:"error,omitempty"`
+}
+
+// xrayUpgradeRequest is stored as the RequestData of an upgrade task.
+type xrayUpgradeRequest struct {
+	Version string `json:"version"`
+}
+
+// fleetTaskResult is stored as the ResponseData of Xray upgrade and agent update tasks.
+type fleetTaskResult struct {
+	InstalledVersion string `json:"installedVersion"`
+}
+
+// GetVersionReport returns the installed Xray version of every enabled server.
+func (s *FleetUpgradeService) GetVersionReport(ctx context.Context) ([]*ServerXrayVersion, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	report := make([]*ServerXrayVersion, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		report[i] = &ServerXrayVersion{ServerId: server.Id, ServerName: server.Name}
+		wg.Add(1)
+		go func(entry *ServerXrayVersion) {
+			defer wg.Done()
+			version, err := s.getVersion(ctx, entry.ServerId)
+			entry.Version = version
+			if err != nil {
+				entry.Error = err.Error()
+			}
+		}(report[i])
+	}
+	wg.Wait()
+
+	return report, nil
+}
+
+// StartUpgrade queues an Xray upgrade to version on the given servers (all enabled
+// servers if empty) and runs it in the background with at most concurrency servers
+// at a time. It returns the created tasks.
+func (s *FleetUpgradeService) StartUpgrade(version string, serverIds []int, concurrency int, userId int) ([]*model.ServerTask, error) {
+	if !xrayVersionPattern.MatchString(version) {
+		return nil, fmt.Errorf("invalid Xray version %q", version)
+	}
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := createFleetTasks(servers, serverIds, XrayUpgradeOperation, "Xray upgrade", xrayUpgradeRequest{Version: version}, userId)
+	if err != nil {
+		return nil, err
+	}
+	go runFleetTasks(tasks, "Xray upgrade to "+version, concurrency, func(serverId int, progress ProgressFunc) (string, error) {
+		return s.installAndVerify(serverId, version, progress)
+	})
+	return tasks, nil
+}
+
+// GetLatestUpgradeTasks returns the most recent upgrade task of each server.
+func (s *FleetUpgradeService) GetLatestUpgradeTasks() ([]*model.ServerTask, error) {
+	return latestFleetTasks(XrayUpgradeOperation)
+}
+
+// createFleetTasks creates a pending task of operation with request as its RequestData
+// for each of the enabled servers with the given ids (all if empty). It fails while
+// tasks of operation from the last staleUpgradeAge are unfinished; name describes the
+// operation in that error.
+func createFleetTasks(servers []*model.Server, serverIds []int, operation, name string, request any, userId int) ([]*model.ServerTask, error) {
+	if len(serverIds) > 0 {
+		selected := make([]*model.Server, 0, len(serverIds))
+		for _, id := range serverIds {
+			found := false
+			for _, server := range servers {
+				if server.Id == id {
+					selected = append(selected, server)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("server %d not found or disabled", id)
+			}
+		}
+		servers = selected
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers to upgrade")
+	}
+
+	requestData, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	db := database.GetDB()
+	var running int64
+	err = db.Model(model.ServerTask{}).
+		Where("operation = ? AND status IN ?", operation, []string{"pending", "running"}).
+		Where("created_at > ?", time.Now().Add(-staleUpgradeAge).Unix()).
+		Count(&running).Error
+	if err != nil {
+		return nil, err
+	}
+	if running > 0 {
+		return nil, fmt.Errorf("an %s is already in progress", name)
+	}
+
+	tasks := make([]*model.ServerTask, 0, len(servers))
+	for _, server := range servers {
+		task := &model.ServerTask{
+			ServerId:    server.Id,
+			Operation:   operation,
+			Status:      "pending",
+			RequestData: string(redact.JSON(requestData)),
+			UserId:      userId,
+		}
+		if err := db.Omit("Server").Create(task).Error; err != nil {
+			return nil, err
+		}
+		publishTaskEvent(task, task.Status, "")
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// latestFleetTasks returns the most recent task of operation of each server.
+func latestFleetTasks(operation string) ([]*model.ServerTask, error) {
+	db := database.GetDB()
+	ids := db.Model(model.ServerTask{}).Select("MAX(id)").
+		Where("operation = ?", operation).Group("server_id")
+
+	var tasks []*model.ServerTask
+	err := db.Model(model.ServerTask{}).Where("id IN (?)", ids).Order("server_id").Find(&tasks).Error
+	return tasks, err
+}
+
+// runFleetTasks executes tasks with at most concurrency (DefaultUpgradeConcurrency if
+// not positive) at a time. run installs on one server and returns the version it
+// reports afterwards; name describes the operation in the log.
+func runFleetTasks(tasks []*model.ServerTask, name string, concurrency int, run func(serverId int, progress ProgressFunc) (string, error)) {
+	if concurrency <= 0 {
+		concurrency = DefaultUpgradeConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(task *model.ServerTask) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			runFleetTask(task, name, run)
+		}(task)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, task := range tasks {
+		if task.Status != "completed" {
+			failed++
+		}
+	}
+	logger.Infof("Fleet %s finished: %d succeeded, %d failed", name, len(tasks)-failed, failed)
+}
+
+// runFleetTask runs one task, storing its progress and the installed version.
+func runFleetTask(task *model.ServerTask, name string, run func(serverId int, progress ProgressFunc) (string, error)) {
+	db := database.GetDB()
+	task.Status = "running"
+	task.StartedAt = time.Now().Unix()
+	db.Model(task).Updates(map[string]any{"status": task.Status, "started_at": task.StartedAt})
+	publishTaskEvent(task, task.Status, "")
+
+	installed, err := run(task.ServerId, func(percent int, stage string) {
+		if percent == task.Progress && stage == task.Stage {
+			return
+		}
+		task.Progress = percent
+		task.Stage = stage
+		db.Model(task).Updates(map[string]any{"progress": percent, "stage": stage})
+		publishTaskEvent(task, task.Status, "")
+	})
+
+	task.CompletedAt = time.Now().Unix()
+	task.Status = "completed"
+	if err != nil {
+		task.Status = "failed"
+		task.ErrorMessage = err.Error()
+		logger.Warningf("%s failed on server %d: %v", name, task.ServerId, err)
+	} else {
+		task.Progress = 100
+		task.Stage = "done"
+	}
+	result, _ := json.Marshal(fleetTaskResult{InstalledVersion: installed})
+	task.ResponseData = string(result)
+
+	db.Model(task).Updates(map[string]any{
+		"status":        task.Status,
+		"completed_at":  task.CompletedAt,
+		"error_message": task.ErrorMessage,
+		"response_data": task.ResponseData,
+		"progress":      task.Progress,
+		"stage":         task.Stage,
+	})
+	publishTaskEvent(task, task.Status, task.ErrorMessage)
+}
+
+// installAndVerify runs InstallXray, reporting its progress to progress, and waits until
+// the server reports the target version.
+func (s *FleetUpgradeService) installAndVerify(serverId int, version string, progress ProgressFunc) (string, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xrayInstallTimeout)
+	err = connector.InstallXray(ctx, version, progress)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+
+	// Xray restarts after the install, so the new version may take a moment to show up
+	progress(95, "verifying")
+	target := strings.TrimPrefix(version, "v")
+	deadline := time.Now().Add(xrayVerifyTimeout)
+	installed := ""
+	for {
+		installed, err = s.getVersion(context.Background(), serverId)
+		if err == nil && strings.TrimPrefix(installed, "v") == target {
+			return installed, nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	if err != nil {
+		return installed, fmt.Errorf("installed but version check failed: %w", err)
+	}
+	return installed, fmt.Errorf("installed but server reports version %s", installed)
+}
+
+// getVersion returns the Xray version reported by a server.
+func (s *FleetUpgradeService) getVersion(ctx context.Context, serverId int) (string, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	return connector.GetXrayVersion(ctx)
+}
@@ -0,0 +1,75 @@
+// Package service provides GeoUpdateService for scheduled geo file updates on all servers.
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// geoUpdateFiles are the files refreshed by ServerConnector.UpdateGeoFiles.
+var geoUpdateFiles = []string{"geoip.dat", "geosite.dat"}
+
+// GeoUpdateService updates geo files on servers and tracks the outcome per server and file.
+type GeoUpdateService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetStatuses returns the update status of every server's geo files.
+func (s *GeoUpdateService) GetStatuses() ([]*model.GeoFileStatus, error) {
+	db := database.GetDB()
+	var statuses []*model.GeoFileStatus
+	err := db.Model(model.GeoFileStatus{}).Order("server_id, file").Find(&statuses).Error
+	return statuses, err
+}
+
+// UpdateServer updates the geo files of one server and records the result.
+// It returns the recorded statuses along with the update error, if any.
+func (s *GeoUpdateService) UpdateServer(ctx context.Context, serverId int) ([]*model.GeoFileStatus, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err == nil {
+		err = connector.UpdateGeoFiles(ctx)
+	}
+
+	statuses, recordErr := s.recordResult(serverId, err)
+	if recordErr != nil {
+		return statuses, recordErr
+	}
+	return statuses, err
+}
+
+// recordResult stores the outcome of an update attempt for each geo file of a server.
+// Connectors update the files together, so they share the outcome.
+func (s *GeoUpdateService) recordResult(serverId int, updateErr error) ([]*model.GeoFileStatus, error) {
+	db := database.GetDB()
+	now := time.Now().Unix()
+
+	statuses := make([]*model.GeoFileStatus, 0, len(geoUpdateFiles))
+	for _, file := range geoUpdateFiles {
+		status := &model.GeoFileStatus{}
+		err := db.Model(model.GeoFileStatus{}).Where("server_id = ? AND file = ?", serverId, file).First(status).Error
+		if err != nil && !database.IsNotFound(err) {
+			return statuses, err
+		}
+
+		status.ServerId = serverId
+		status.File = file
+		status.LastAttemptAt = now
+		if updateErr == nil {
+			status.LastUpdatedAt = now
+			status.Failures = 0
+			status.LastError = ""
+		} else {
+			status.Failures++
+			status.LastError = updateErr.Error()
+		}
+
+		if err := db.Save(status).Error; err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
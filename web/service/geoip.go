@@ -0,0 +1,213 @@
+// Package service provides GeoIP lookups backed by the Xray geo data files.
+package service
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// geoRange is a single address range from a geo data file, stored as 16-byte IPs.
+type geoRange struct {
+	start net.IP
+	end   net.IP
+	code  string
+}
+
+// geoDB is an in-memory index of a geo data file, reloaded when the file changes.
+type geoDB struct {
+	modTime time.Time
+	ranges  []geoRange
+}
+
+var (
+	geoMu  sync.Mutex
+	geoDBs = make(map[string]*geoDB)
+)
+
+// GeoIPService resolves IP addresses to country codes and ASNs.
+// Countries are read from geoip.dat; ASNs from the optional geoasn.dat.
+type GeoIPService struct{}
+
+// Lookup returns the country code and ASN for an IP address.
+// Unknown values are returned as empty strings.
+func (s *GeoIPService) Lookup(ip string) (country string, asn string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", ""
+	}
+	parsed = parsed.To16()
+
+	// geoip.dat also carries non-country lists (private, cloudflare, ...); keep ISO codes only
+	country = lookupGeoFile(xray.GetGeoipPath(), parsed, func(code string) bool {
+		return len(code) == 2
+	})
+	asn = lookupGeoFile(xray.GetGeoasnPath(), parsed, nil)
+	return country, asn
+}
+
+// lookupGeoFile finds the code of the range containing ip in the given geo data file.
+func lookupGeoFile(path string, ip net.IP, filter func(code string) bool) string {
+	db := loadGeoFile(path, filter)
+	if db == nil || len(db.ranges) == 0 {
+		return ""
+	}
+
+	// Ranges are disjoint, so only the last one starting at or before ip can contain it
+	idx := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if idx >= 0 && bytes.Compare(ip, db.ranges[idx].end) <= 0 {
+		return db.ranges[idx].code
+	}
+	return ""
+}
+
+// loadGeoFile returns the cached index for path, rebuilding it if the file was modified.
+func loadGeoFile(path string, filter func(code string) bool) *geoDB {
+	geoMu.Lock()
+	defer geoMu.Unlock()
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		delete(geoDBs, path)
+		return nil
+	}
+
+	if db, ok := geoDBs[path]; ok && db.modTime.Equal(stat.ModTime()) {
+		return db
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Warning("Failed to read geo data file", path, ":", err)
+		return nil
+	}
+
+	var list router.GeoIPList
+	if err := proto.Unmarshal(data, &list); err != nil {
+		logger.Warning("Failed to parse geo data file", path, ":", err)
+		return nil
+	}
+
+	db := &geoDB{modTime: stat.ModTime()}
+	for _, entry := range list.GetEntry() {
+		if entry.GetReverseMatch() {
+			continue
+		}
+		code := entry.GetCountryCode()
+		if filter != nil && !filter(code) {
+			continue
+		}
+		for _, cidr := range entry.GetCidr() {
+			if r, ok := cidrToRange(cidr.GetIp(), cidr.GetPrefix(), code); ok {
+				db.ranges = append(db.ranges, r)
+			}
+		}
+	}
+
+	// Outer ranges sort before the ranges nested in them
+	sort.Slice(db.ranges, func(i, j int) bool {
+		if c := bytes.Compare(db.ranges[i].start, db.ranges[j].start); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(db.ranges[i].end, db.ranges[j].end) > 0
+	})
+	db.ranges = flattenGeoRanges(db.ranges)
+
+	geoDBs[path] = db
+	return db
+}
+
+// flattenGeoRanges splits ranges sorted by start, outer ranges first, into disjoint ranges
+// sorted by start. CIDR ranges either nest or do not overlap at all; where they nest, the
+// addresses of the inner range keep its code.
+func flattenGeoRanges(ranges []geoRange) []geoRange {
+	var flat []geoRange
+	var open []geoRange // ranges containing cursor, innermost last
+	var cursor net.IP   // first address not covered by flat yet, nil past the last address
+	emit := func(end net.IP, code string) {
+		if cursor != nil && bytes.Compare(cursor, end) <= 0 {
+			flat = append(flat, geoRange{start: cursor, end: end, code: code})
+		}
+		cursor = nextIP(end)
+	}
+	for _, r := range ranges {
+		for len(open) > 0 && bytes.Compare(open[len(open)-1].end, r.start) < 0 {
+			top := open[len(open)-1]
+			emit(top.end, top.code)
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 && cursor != nil && bytes.Compare(cursor, r.start) < 0 {
+			emit(prevIP(r.start), open[len(open)-1].code)
+		}
+		cursor = r.start
+		open = append(open, r)
+	}
+	for len(open) > 0 {
+		top := open[len(open)-1]
+		emit(top.end, top.code)
+		open = open[:len(open)-1]
+	}
+	return flat
+}
+
+// nextIP returns the address after ip, or nil if ip is the last address.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// prevIP returns the address before ip, which must not be the first address.
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		if prev[i]--; prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+// cidrToRange converts a raw CIDR from a geo data file into a 16-byte address range.
+func cidrToRange(ip []byte, prefix uint32, code string) (geoRange, bool) {
+	var bits int
+	switch len(ip) {
+	case net.IPv4len:
+		bits = int(prefix) + 96
+	case net.IPv6len:
+		bits = int(prefix)
+	default:
+		return geoRange{}, false
+	}
+	if bits > 128 {
+		return geoRange{}, false
+	}
+
+	start := net.IP(ip).To16()
+	mask := net.CIDRMask(bits, 128)
+	first := make(net.IP, net.IPv6len)
+	last := make(net.IP, net.IPv6len)
+	for i := range start {
+		first[i] = start[i] & mask[i]
+		last[i] = start[i] | ^mask[i]
+	}
+
+	return geoRange{start: first, end: last, code: code}, true
+}
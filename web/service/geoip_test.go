@@ -0,0 +1,71 @@
+package service_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestGeoIPLookupNestedRanges checks that nested ranges resolve to the innermost one and
+// that an outer range is still found behind many ranges nested in it.
+func TestGeoIPLookupNestedRanges(t *testing.T) {
+	t.Setenv("XUI_BIN_FOLDER", t.TempDir())
+
+	cidr := func(s string) *router.CIDR {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix, _ := network.Mask.Size()
+		return &router.CIDR{Ip: network.IP, Prefix: uint32(prefix)}
+	}
+	var small []*router.CIDR
+	for i := 0; i < 32; i++ {
+		small = append(small, cidr(fmt.Sprintf("10.2.%d.0/24", i)))
+	}
+	list := &router.GeoIPList{Entry: []*router.GeoIP{
+		{CountryCode: "US", Cidr: []*router.CIDR{cidr("10.0.0.0/8"), cidr("2001:db8::/32")}},
+		{CountryCode: "DE", Cidr: []*router.CIDR{cidr("10.1.0.0/16")}},
+		{CountryCode: "FR", Cidr: []*router.CIDR{cidr("10.1.2.0/24"), cidr("10.1.255.0/24")}},
+		{CountryCode: "NL", Cidr: small},
+		{CountryCode: "PRIVATE", Cidr: []*router.CIDR{cidr("192.168.0.0/16")}},
+	}}
+	data, err := proto.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(xray.GetGeoipPath(), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.0.0.1", "US"},
+		{"10.1.0.1", "DE"},
+		{"10.1.2.3", "FR"},
+		{"10.1.3.0", "DE"},
+		{"10.1.255.255", "FR"},
+		{"10.2.17.1", "NL"},
+		{"10.200.0.1", "US"},
+		{"10.255.255.255", "US"},
+		{"11.0.0.0", ""},
+		{"192.168.1.1", ""},
+		{"2001:db8::1", "US"},
+		{"2001:db9::1", ""},
+	}
+	geoIPService := &service.GeoIPService{}
+	for _, tt := range tests {
+		if country, _ := geoIPService.Lookup(tt.ip); country != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.ip, tt.want, country)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package service provides GlobalClientService for creating one client on many servers at once.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/random"
+	"github.com/google/uuid"
+)
+
+const (
+	// globalClientConcurrency is the number of servers a client is created on at once.
+	globalClientConcurrency = 5
+	// globalClientTimeout bounds creating or rolling back the client on one server.
+	globalClientTimeout = time.Minute
+)
+
+// GlobalClientTarget is an inbound, by tag, of a server to create a global client in.
+type GlobalClientTarget struct {
+	ServerId   int    `json:"serverId"`
+	InboundTag string `json:"inboundTag"`
+}
+
+// GlobalClientRequest is a client to create with the same credentials, subscription and
+// limits in inbounds of several servers. Empty credentials and subId are generated.
+type GlobalClientRequest struct {
+	Email      string                `json:"email"`
+	Id         string                `json:"id"`       // VMess/VLESS UUID
+	Password   string                `json:"password"` // Trojan/Shadowsocks password
+	Flow       string                `json:"flow"`     // VLESS flow
+	SubId      string                `json:"subId"`
+	TotalGB    int64                 `json:"totalGB"`    // Traffic quota in bytes, 0 = unlimited
+	ExpiryTime int64                 `json:"expiryTime"` // Unix milliseconds, 0 = never
+	LimitIP    int                   `json:"limitIp"`
+	TgId       int64                 `json:"tgId"`
+	Comment    string                `json:"comment"`
+	Targets    []*GlobalClientTarget `json:"targets"`
+	Rollback   bool                  `json:"rollback"` // Remove created copies if any target fails
+}
+
+// GlobalClientResult is the outcome of creating a global client in one inbound.
+type GlobalClientResult struct {
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	InboundTag string `json:"inboundTag"`
+	InboundId  int    `json:"inboundId,omitempty"`
+	Success    bool   `json:"success"`
+	RolledBack bool   `json:"rolledBack,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	clientId string // Protocol client ID that client deletion is keyed by
+}
+
+// GlobalClientReport is the outcome of creating a global client on all its targets.
+type GlobalClientReport struct {
+	Email   string                `json:"email"`
+	Id      string                `json:"id"`
+	SubId   string                `json:"subId"`
+	Results []*GlobalClientResult `json:"results"`
+	Failed  int                   `json:"failed"`
+}
+
+// GlobalClientService creates a client with the same UUID, subscription and limits in
+// inbounds across servers through their connectors, and can roll back the created
+// copies if some of them fail.
+type GlobalClientService struct {
+	serverMgmt     ServerManagementService
+	inboundService InboundService
+	xrayService    XrayService
+}
+
+// Create creates the client of req in every target inbound, at most
+// globalClientConcurrency servers at a time. With req.Rollback, the copies already
+// created are deleted again if any target failed.
+func (s *GlobalClientService) Create(ctx context.Context, req *GlobalClientRequest) (*GlobalClientReport, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		return nil, fmt.Errorf("email is required")
+	}
+	if req.TotalGB < 0 || req.ExpiryTime < 0 || req.LimitIP < 0 {
+		return nil, fmt.Errorf("totalGB, expiryTime and limitIp must not be negative")
+	}
+	if req.Id == "" {
+		req.Id = uuid.NewString()
+	} else if _, err := uuid.Parse(req.Id); err != nil {
+		return nil, fmt.Errorf("id must be a UUID: %w", err)
+	}
+	if req.SubId == "" {
+		req.SubId = random.Seq(16)
+	}
+	if len(req.Targets) == 0 {
+		return nil, fmt.Errorf("no targets selected")
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[int]*model.Server, len(servers))
+	for _, server := range servers {
+		byId[server.Id] = server
+	}
+	results := make([]*GlobalClientResult, len(req.Targets))
+	seen := make(map[int]bool, len(req.Targets))
+	for i, target := range req.Targets {
+		if target == nil || target.InboundTag == "" {
+			return nil, fmt.Errorf("target %d needs a serverId and an inboundTag", i)
+		}
+		server, ok := byId[target.ServerId]
+		if !ok {
+			return nil, fmt.Errorf("server %d not found or disabled", target.ServerId)
+		}
+		// Xray identifies users by email, so a server can hold the client only once
+		if seen[target.ServerId] {
+			return nil, fmt.Errorf("server %s is targeted twice, client emails must be unique on a server", server.Name)
+		}
+		seen[target.ServerId] = true
+		results[i] = &GlobalClientResult{ServerId: server.Id, ServerName: server.Name, InboundTag: target.InboundTag}
+	}
+
+	// Shadowsocks 2022 keys depend on the cipher, so one key per size keeps copies alike
+	ssKeys := make(map[int]string, 2)
+	for _, size := range []int{16, 32} {
+		key := make([]byte, size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		ssKeys[size] = base64.StdEncoding.EncodeToString(key)
+	}
+	if req.Password == "" {
+		req.Password = random.Seq(10)
+	}
+
+	sem := make(chan struct{}, globalClientConcurrency)
+	var wg sync.WaitGroup
+	for _, result := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *GlobalClientResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			targetCtx, cancel := context.WithTimeout(ctx, globalClientTimeout)
+			defer cancel()
+			if err := s.createOn(targetCtx, req, ssKeys, result); err != nil {
+				result.Error = err.Error()
+				logger.Warningf("Creating client %s on server %s failed: %v", req.Email, result.ServerName, err)
+				return
+			}
+			result.Success = true
+		}(result)
+	}
+	wg.Wait()
+
+	report := &GlobalClientReport{Email: req.Email, Id: req.Id, SubId: req.SubId, Results: results}
+	for _, result := range results {
+		if !result.Success {
+			report.Failed++
+		}
+	}
+	if report.Failed > 0 && req.Rollback {
+		s.rollback(ctx, req.Email, results)
+	}
+	logger.Infof("Client %s created on %d servers: %d succeeded, %d failed", req.Email, len(results), len(results)-report.Failed, report.Failed)
+	return report, nil
+}
+
+// createOn creates the client of req in the target inbound of result.
+func (s *GlobalClientService) createOn(ctx context.Context, req *GlobalClientRequest, ssKeys map[int]string, result *GlobalClientResult) error {
+	connector, err := s.serverMgmt.GetConnector(result.ServerId)
+	if err != nil {
+		return err
+	}
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil {
+		return err
+	}
+	var inbound *model.Inbound
+	for _, candidate := range inbounds {
+		if candidate.Tag == result.InboundTag {
+			inbound = candidate
+			break
+		}
+	}
+	if inbound == nil {
+		return fmt.Errorf("inbound %s not found", result.InboundTag)
+	}
+	result.InboundId = inbound.Id
+
+	client, err := globalClientSettings(inbound, req, ssKeys)
+	if err != nil {
+		return err
+	}
+	settings, err := json.Marshal(map[string]any{"clients": []any{client}})
+	if err != nil {
+		return err
+	}
+	err = connector.AddClient(ctx, &model.Inbound{
+		Id:       inbound.Id,
+		ServerId: result.ServerId,
+		Settings: string(settings),
+	})
+	if err != nil {
+		return err
+	}
+
+	id, _ := client["id"].(string)
+	password, _ := client["password"].(string)
+	result.clientId = s.inboundService.GetClientId(inbound.Protocol, model.Client{ID: id, Password: password, Email: req.Email})
+	if s.serverMgmt.IsLocalServer(result.ServerId) {
+		s.xrayService.SetToNeedRestart()
+	}
+	return nil
+}
+
+// rollback deletes the client again from the inbounds it was created in.
+func (s *GlobalClientService) rollback(ctx context.Context, email string, results []*GlobalClientResult) {
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		err := func() error {
+			rollbackCtx, cancel := context.WithTimeout(ctx, globalClientTimeout)
+			defer cancel()
+			connector, err := s.serverMgmt.GetConnector(result.ServerId)
+			if err != nil {
+				return err
+			}
+			return connector.DeleteClient(rollbackCtx, result.InboundId, result.clientId)
+		}()
+		if err != nil {
+			result.Error = fmt.Sprintf("rollback failed: %v", err)
+			logger.Warningf("Rolling back client %s on server %s failed: %v", email, result.ServerName, err)
+			continue
+		}
+		result.RolledBack = true
+	}
+}
+
+// globalClientSettings returns the settings entry of the client of req for inbound.
+func globalClientSettings(inbound *model.Inbound, req *GlobalClientRequest, ssKeys map[int]string) (map[string]any, error) {
+	now := time.Now().UnixMilli()
+	client := map[string]any{
+		"email":      req.Email,
+		"limitIp":    req.LimitIP,
+		"totalGB":    req.TotalGB,
+		"expiryTime": req.ExpiryTime,
+		"enable":     true,
+		"tgId":       req.TgId,
+		"subId":      req.SubId,
+		"comment":    req.Comment,
+		"reset":      0,
+		"created_at": now,
+		"updated_at": now,
+	}
+
+	switch inbound.Protocol {
+	case model.VMESS:
+		client["id"] = req.Id
+		client["security"] = "auto"
+	case model.VLESS:
+		client["id"] = req.Id
+		client["flow"] = req.Flow
+	case model.Trojan:
+		client["password"] = req.Password
+	case model.Shadowsocks:
+		var settings map[string]any
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			return nil, err
+		}
+		method, _ := settings["method"].(string)
+		password := req.Password
+		// 2022 ciphers need a key matching their size
+		if strings.HasPrefix(method, "2022-") {
+			size := 32
+			if strings.Contains(method, "aes-128") {
+				size = 16
+			}
+			password = ssKeys[size]
+		}
+		client["method"] = ""
+		client["password"] = password
+	default:
+		return nil, fmt.Errorf("protocol %s does not support clients", inbound.Protocol)
+	}
+	return client, nil
+}
@@ -0,0 +1,326 @@
+// Package service provides GlobalIpLimitService for enforcing client IP limits across servers.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+
+	"gorm.io/gorm"
+)
+
+// GlobalIpLimitService aggregates client IPs observed on every server and
+// enforces the client limitIp setting fleet-wide instead of per node.
+type GlobalIpLimitService struct {
+	serverMgmt     ServerManagementService
+	inboundService InboundService
+	xrayService    XrayService
+}
+
+// ClientIpUsage describes the IPs a client uses across all servers.
+type ClientIpUsage struct {
+	Email   string   `json:"email"`
+	LimitIp int      `json:"limitIp"`
+	IPs     []string `json:"ips"`
+	Servers []int    `json:"servers"` // Servers the client was seen on
+}
+
+// clientLocation identifies an inbound that contains a client.
+type clientLocation struct {
+	serverId  int
+	local     bool // Inbound of the local server, disabled in-process
+	inboundId int
+}
+
+// clientLimit holds the effective IP limit and locations of a client.
+type clientLimit struct {
+	limitIp   int
+	locations []clientLocation
+}
+
+// SyncRemoteClientIps stores the currently online IPs of every enabled remote server
+// in InboundClientIps under that server's ID. Local IPs are maintained by the client IP job.
+func (s *GlobalIpLimitService) SyncRemoteClientIps(ctx context.Context) error {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, server := range servers {
+		if server.IsLocal() {
+			continue
+		}
+
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+
+		clients, err := connector.GetOnlineClientDetails(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+
+		if err := s.saveServerClientIps(server.Id, clients); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+		}
+	}
+
+	return common.Combine(errs...)
+}
+
+// saveServerClientIps replaces the IP records of a server with the given online clients.
+func (s *GlobalIpLimitService) saveServerClientIps(serverId int, clients []*OnlineClient) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("server_id = ?", serverId).Delete(model.InboundClientIps{}).Error; err != nil {
+			return err
+		}
+		for _, client := range clients {
+			if len(client.IPs) == 0 {
+				continue
+			}
+			ips := make([]string, 0, len(client.IPs))
+			for _, ip := range client.IPs {
+				ips = append(ips, ip.IP)
+			}
+			jsonIps, err := json.Marshal(ips)
+			if err != nil {
+				return err
+			}
+			record := &model.InboundClientIps{
+				ServerId:    serverId,
+				ClientEmail: client.Email,
+				Ips:         string(jsonIps),
+			}
+			if err := tx.Create(record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetViolations returns clients whose distinct IPs across all servers exceed their limitIp.
+func (s *GlobalIpLimitService) GetViolations(ctx context.Context) ([]*ClientIpUsage, error) {
+	limits, err := s.getClientLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usages, err := s.GetClientIpUsages()
+	if err != nil {
+		return nil, err
+	}
+
+	violations := make([]*ClientIpUsage, 0)
+	for _, usage := range usages {
+		limit, ok := limits[usage.Email]
+		if !ok || limit.limitIp <= 0 {
+			continue
+		}
+		usage.LimitIp = limit.limitIp
+		if len(usage.IPs) > limit.limitIp {
+			violations = append(violations, usage)
+		}
+	}
+
+	return violations, nil
+}
+
+// GetClientIpUsages aggregates the recorded IPs of every client across all servers.
+func (s *GlobalIpLimitService) GetClientIpUsages() ([]*ClientIpUsage, error) {
+	db := database.GetDB()
+	var records []*model.InboundClientIps
+	if err := db.Model(model.InboundClientIps{}).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	type aggregate struct {
+		ips     map[string]struct{}
+		servers map[int]struct{}
+	}
+	byEmail := make(map[string]*aggregate)
+
+	for _, record := range records {
+		if record.Ips == "" {
+			continue
+		}
+		var ips []string
+		if err := json.Unmarshal([]byte(record.Ips), &ips); err != nil || len(ips) == 0 {
+			continue
+		}
+		agg, ok := byEmail[record.ClientEmail]
+		if !ok {
+			agg = &aggregate{ips: make(map[string]struct{}), servers: make(map[int]struct{})}
+			byEmail[record.ClientEmail] = agg
+		}
+		for _, ip := range ips {
+			agg.ips[ip] = struct{}{}
+		}
+		agg.servers[record.ServerId] = struct{}{}
+	}
+
+	usages := make([]*ClientIpUsage, 0, len(byEmail))
+	for email, agg := range byEmail {
+		usage := &ClientIpUsage{
+			Email:   email,
+			IPs:     make([]string, 0, len(agg.ips)),
+			Servers: make([]int, 0, len(agg.servers)),
+		}
+		for ip := range agg.ips {
+			usage.IPs = append(usage.IPs, ip)
+		}
+		for serverId := range agg.servers {
+			usage.Servers = append(usage.Servers, serverId)
+		}
+		sort.Strings(usage.IPs)
+		sort.Ints(usage.Servers)
+		usages = append(usages, usage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Email < usages[j].Email
+	})
+
+	return usages, nil
+}
+
+// getClientLimits collects the limitIp and locations of every enabled client on all servers.
+// When a client exists on several servers, the strictest positive limit applies.
+func (s *GlobalIpLimitService) getClientLimits(ctx context.Context) (map[string]*clientLimit, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]*clientLimit)
+	for _, server := range servers {
+		var inbounds []*model.Inbound
+		if server.IsLocal() {
+			inbounds, err = s.inboundService.GetAllInbounds()
+		} else {
+			var connector ServerConnector
+			connector, err = s.serverMgmt.GetConnector(server.Id)
+			if err == nil {
+				inbounds, err = connector.ListInbounds(ctx)
+			}
+		}
+		if err != nil {
+			logger.Warning("Global IP limit: failed to list inbounds of server", server.Name, ":", err)
+			continue
+		}
+
+		for _, inbound := range inbounds {
+			if !inbound.Enable {
+				continue
+			}
+			clients, err := s.inboundService.GetClients(inbound)
+			if err != nil {
+				continue
+			}
+			for _, client := range clients {
+				if !client.Enable || client.Email == "" {
+					continue
+				}
+				limit, ok := limits[client.Email]
+				if !ok {
+					limit = &clientLimit{}
+					limits[client.Email] = limit
+				}
+				if client.LimitIP > 0 && (limit.limitIp == 0 || client.LimitIP < limit.limitIp) {
+					limit.limitIp = client.LimitIP
+				}
+				limit.locations = append(limit.locations, clientLocation{serverId: server.Id, local: server.IsLocal(), inboundId: inbound.Id})
+			}
+		}
+	}
+
+	return limits, nil
+}
+
+// DisableClient disables a client on every server where it exists.
+func (s *GlobalIpLimitService) DisableClient(ctx context.Context, email string) error {
+	limits, err := s.getClientLimits(ctx)
+	if err != nil {
+		return err
+	}
+	limit, ok := limits[email]
+	if !ok {
+		return common.NewError("Client Not Found For Email:", email)
+	}
+
+	errs := make([]error, 0)
+	localDone := false
+	for _, location := range limit.locations {
+		if location.local {
+			if localDone {
+				continue
+			}
+			localDone = true
+			_, needRestart, err := s.inboundService.SetClientEnableByEmail(email, false)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if needRestart {
+				s.xrayService.SetToNeedRestart()
+			}
+			continue
+		}
+
+		if err := s.disableRemoteClient(ctx, location, email); err != nil {
+			errs = append(errs, fmt.Errorf("server %d: %w", location.serverId, err))
+		}
+	}
+
+	return common.Combine(errs...)
+}
+
+// disableRemoteClient disables a client of a remote inbound through the agent's client
+// API, so the other clients of the inbound stay connected.
+func (s *GlobalIpLimitService) disableRemoteClient(ctx context.Context, location clientLocation, email string) error {
+	connector, err := s.serverMgmt.GetConnector(location.serverId)
+	if err != nil {
+		return err
+	}
+
+	inbound, err := connector.GetInbound(ctx, location.inboundId)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return err
+	}
+	clients, _ := settings["clients"].([]any)
+	for index, item := range clients {
+		client, ok := item.(map[string]any)
+		if !ok || client["email"] != email {
+			continue
+		}
+		client["enable"] = false
+		client["updated_at"] = time.Now().Unix() * 1000
+
+		update, err := json.Marshal(map[string]any{"clients": []any{client}})
+		if err != nil {
+			return err
+		}
+		return connector.UpdateClient(ctx, &model.Inbound{
+			Id:       inbound.Id,
+			ServerId: inbound.ServerId,
+			Settings: string(update),
+		}, index)
+	}
+	return common.NewError("Client Not Found For Email:", email)
+}
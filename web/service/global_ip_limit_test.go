@@ -0,0 +1,56 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/agent/agenttest"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestDisableRemoteClient checks that a client over its IP limit is disabled on an agent
+// through the client API, without replacing the inbound or restarting Xray.
+func TestDisableRemoteClient(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	server := agent.Server(108, "ip-limit")
+	if err := database.GetDB().Create(server).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Server{}, server.Id)
+
+	agent.AddInbound(&model.Inbound{
+		Protocol: model.VLESS,
+		Port:     20108,
+		Enable:   true,
+		Settings: `{"clients":[
+			{"id":"1f0e9d8c-7b6a-4594-8372-615049382716","email":"staying","enable":true},
+			{"id":"2a1b0c9d-8e7f-4a6b-9c5d-4e3f2a1b0c9d","email":"blocked","enable":true,"limitIp":1}
+		]}`,
+	})
+
+	if err := (&service.GlobalIpLimitService{}).DisableClient(context.Background(), "blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := agent.Count("PUT /api/v1/inbounds/:id/clients/:index"); got != 1 {
+		t.Errorf("expected one client update, got %d", got)
+	}
+	if got := agent.Count("PUT /api/v1/inbounds/:id") + agent.Count("POST /api/v1/xray/restart"); got != 0 {
+		t.Errorf("expected no inbound update or Xray restart, got %d", got)
+	}
+	var settings struct {
+		Clients []model.Client `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(agent.Inbounds()[0].Settings), &settings); err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range settings.Clients {
+		if client.Enable != (client.Email == "staying") {
+			t.Errorf("%s: expected only the blocked client to be disabled, got enable=%v", client.Email, client.Enable)
+		}
+	}
+}
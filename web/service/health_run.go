@@ -0,0 +1,71 @@
+// Package service provides HealthRunService for the history of server health job runs.
+package service
+
+import (
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+const (
+	// healthRunRetention is how long health job runs are kept.
+	healthRunRetention = 7 * 24 * time.Hour
+	// healthRunStaleAfter is how long after the last run the health job counts as stalled;
+	// it normally runs every 30 seconds.
+	healthRunStaleAfter = 2 * time.Minute
+)
+
+// HealthRunReport is the recent runs of the health job with a summary of them.
+type HealthRunReport struct {
+	LastRunAt   int64                   `json:"lastRunAt"`   // Unix timestamp, 0 if the job never ran
+	Stalled     bool                    `json:"stalled"`     // No run for healthRunStaleAfter
+	AvgDuration int64                   `json:"avgDuration"` // Milliseconds, over the runs returned
+	MaxDuration int64                   `json:"maxDuration"`
+	Runs        []*model.HealthCheckRun `json:"runs"` // Newest first
+}
+
+// HealthRunService records every run of the server health job, so operators can tell
+// whether monitoring itself works and how long fleet sweeps take.
+type HealthRunService struct{}
+
+// RecordRun stores a health job run and prunes runs past the retention period. Failures
+// are only logged so the health job is not affected.
+func (s *HealthRunService) RecordRun(run *model.HealthCheckRun) {
+	db := database.GetDB()
+	if err := db.Create(run).Error; err != nil {
+		logger.Warning("Failed to record health check run:", err)
+		return
+	}
+	cutoff := time.Now().Add(-healthRunRetention).Unix()
+	if err := db.Where("started_at < ?", cutoff).Delete(model.HealthCheckRun{}).Error; err != nil {
+		logger.Warning("Failed to prune health check runs:", err)
+	}
+}
+
+// GetRuns returns the latest runs, at most limit (default 100, at most 1000), with a
+// summary of their durations.
+func (s *HealthRunService) GetRuns(limit int) (*HealthRunReport, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var runs []*model.HealthCheckRun
+	if err := database.GetDB().Model(model.HealthCheckRun{}).Order("started_at DESC, id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	report := &HealthRunReport{Runs: runs, Stalled: true}
+	if len(runs) == 0 {
+		return report, nil
+	}
+	var total int64
+	for _, run := range runs {
+		total += run.Duration
+		report.MaxDuration = max(report.MaxDuration, run.Duration)
+	}
+	report.AvgDuration = total / int64(len(runs))
+	report.LastRunAt = runs[0].StartedAt
+	report.Stalled = time.Since(time.Unix(runs[0].StartedAt, 0)) > healthRunStaleAfter
+	return report, nil
+}
@@ -5,6 +5,7 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,7 +24,9 @@ import (
 // It handles CRUD operations for inbounds, client management, traffic monitoring,
 // and integration with the Xray API for real-time updates.
 type InboundService struct {
-	xrayApi xray.XrayAPI
+	xrayApi        xray.XrayAPI
+	serverMgmt     ServerManagementService
+	webhookService WebhookService
 }
 
 // GetInbounds retrieves all inbounds for a specific user.
@@ -141,6 +144,19 @@ func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, err
 	return clients, nil
 }
 
+// GetClientId returns the identifier used to address a client of the given protocol:
+// the password for trojan, the email for shadowsocks and the UUID otherwise.
+func (s *InboundService) GetClientId(protocol model.Protocol, client model.Client) string {
+	switch protocol {
+	case "trojan":
+		return client.Password
+	case "shadowsocks":
+		return client.Email
+	default:
+		return client.ID
+	}
+}
+
 func (s *InboundService) getAllEmails() ([]string, error) {
 	db := database.GetDB()
 	var emails []string
@@ -211,9 +227,10 @@ func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (stri
 
 // AddInbound creates a new inbound configuration.
 // It validates port uniqueness, client email uniqueness, and required fields,
-// then saves the inbound to the database and optionally adds it to the running Xray instance.
+// then saves the inbound and its client stats to the database and optionally adds it to the
+// running Xray instance. Nothing is saved if Xray rejects the inbound config.
 // Returns the created inbound, whether Xray needs restart, and any error.
-func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+func (s *InboundService) AddInbound(inbound *model.Inbound) (_ *model.Inbound, needRestart bool, err error) {
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, 0)
 	if err != nil {
 		return inbound, false, err
@@ -279,95 +296,123 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 
 	db := database.GetDB()
 	tx := db.Begin()
+	undo := &xrayUndo{}
 	defer func() {
-		if err == nil {
-			tx.Commit()
-		} else {
-			tx.Rollback()
-		}
+		err = s.finishInboundTx(tx, err, undo)
 	}()
 
 	err = tx.Save(inbound).Error
-	if err == nil {
-		if len(inbound.ClientStats) == 0 {
-			for _, client := range clients {
-				s.AddClientStat(tx, inbound.Id, &client)
+	if err != nil {
+		return inbound, false, err
+	}
+	if len(inbound.ClientStats) == 0 {
+		for _, client := range clients {
+			if err = s.AddClientStat(tx, inbound.Id, &client); err != nil {
+				return inbound, false, err
 			}
 		}
-	} else {
-		return inbound, false, err
 	}
 
-	needRestart := false
 	if inbound.Enable {
-		s.xrayApi.Init(p.GetAPIPort())
 		inboundJson, err1 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
+		if err1 == nil {
+			err1 = xray.ValidateInbound(inboundJson)
+		}
 		if err1 != nil {
-			logger.Debug("Unable to marshal inbound config:", err1)
+			// Saving a config Xray rejects would break its next restart
+			err = common.NewError("invalid inbound config:", err1)
+			return inbound, false, err
 		}
 
+		s.xrayApi.Init(p.GetAPIPort())
 		err1 = s.xrayApi.AddInbound(inboundJson)
 		if err1 == nil {
 			logger.Debug("New inbound added by api:", inbound.Tag)
+			tag := inbound.Tag
+			undo.add(func(s *InboundService) error { return s.xrayApi.DelInbound(tag) })
 		} else {
 			logger.Debug("Unable to add inbound by api:", err1)
 			needRestart = true
 		}
 		s.xrayApi.Close()
+		// Rate limits need outbounds and routing rules that only a restart adds
+		if len(inboundRateLimits(inbound)) > 0 {
+			needRestart = true
+		}
 	}
 
-	return inbound, needRestart, err
+	return inbound, needRestart, nil
 }
 
 // DelInbound deletes an inbound configuration by ID.
-// It removes the inbound from the database and the running Xray instance if active.
+// It removes the inbound, its client stats and client IPs from the database in one
+// transaction and the inbound from the running Xray instance if active.
 // Returns whether Xray needs restart and any error.
-func (s *InboundService) DelInbound(id int) (bool, error) {
-	db := database.GetDB()
-
-	var tag string
-	needRestart := false
-	result := db.Model(model.Inbound{}).Select("tag").Where("id = ? and enable = ?", id, true).First(&tag)
-	if result.Error == nil {
-		if p != nil && p.IsRunning() {
-			s.xrayApi.Init(p.GetAPIPort())
-			err1 := s.xrayApi.DelInbound(tag)
-			if err1 == nil {
-				logger.Debug("Inbound deleted by api:", tag)
-			} else {
-				logger.Debug("Unable to delete inbound by api:", err1)
-				needRestart = true
-			}
-			s.xrayApi.Close()
-		} else {
-			// Xray not running; nothing to delete in running process
-			logger.Debug("Xray process not running; skipping API delete for inbound:", tag)
-		}
-	} else {
-		logger.Debug("No enabled inbound founded to removing by api", tag)
+func (s *InboundService) DelInbound(id int) (needRestart bool, err error) {
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return false, err
 	}
-
-	// Delete client traffics of inbounds
-	err := db.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error
+	clients, err := s.GetClients(inbound)
 	if err != nil {
 		return false, err
 	}
-	inbound, err := s.GetInbound(id)
+
+	db := database.GetDB()
+	tx := db.Begin()
+	undo := &xrayUndo{}
+	defer func() {
+		err = s.finishInboundTx(tx, err, undo)
+	}()
+
+	// Keep the full inbound with its clients' traffic, so the deletion can be undone
+	err = tx.Where("inbound_id = ?", id).Find(&inbound.ClientStats).Error
 	if err != nil {
 		return false, err
 	}
-	clients, err := s.GetClients(inbound)
+	if _, err = archiveInbound(tx, inbound.ServerId, inbound); err != nil {
+		return false, err
+	}
+
+	// Delete client traffics of inbounds
+	err = tx.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error
 	if err != nil {
 		return false, err
 	}
 	for _, client := range clients {
-		err := s.DelClientIPs(db, client.Email)
+		err = s.DelClientIPs(tx, client.Email)
 		if err != nil {
 			return false, err
 		}
 	}
+	err = tx.Delete(model.Inbound{}, id).Error
+	if err != nil {
+		return false, err
+	}
+
+	if inbound.Enable {
+		if p != nil && p.IsRunning() {
+			s.xrayApi.Init(p.GetAPIPort())
+			err1 := s.xrayApi.DelInbound(inbound.Tag)
+			if err1 == nil {
+				logger.Debug("Inbound deleted by api:", inbound.Tag)
+				if inboundJson, err2 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  "); err2 == nil {
+					undo.add(func(s *InboundService) error { return s.xrayApi.AddInbound(inboundJson) })
+				}
+			} else {
+				logger.Debug("Unable to delete inbound by api:", err1)
+				needRestart = true
+			}
+			s.xrayApi.Close()
+		} else {
+			// Xray not running; nothing to delete in running process
+			logger.Debug("Xray process not running; skipping API delete for inbound:", inbound.Tag)
+		}
+	} else {
+		logger.Debug("No enabled inbound founded to removing by api", inbound.Tag)
+	}
 
-	return needRestart, db.Delete(model.Inbound{}, id).Error
+	return needRestart, nil
 }
 
 func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
@@ -380,10 +425,40 @@ func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
 	return inbound, nil
 }
 
+// InboundConflictError is returned when an inbound update is based on an outdated version
+// of the inbound, e.g. because another admin saved it in the meantime.
+type InboundConflictError struct {
+	Current *model.Inbound // The inbound as it is now
+}
+
+func (e *InboundConflictError) Error() string {
+	return fmt.Sprintf("inbound %d was changed meanwhile (now version %d), reload and retry", e.Current.Id, e.Current.Version)
+}
+
+// claimInboundVersion bumps the version of inbound id inside tx, but only if it is
+// still at version. It returns an *InboundConflictError when another write got there first.
+func claimInboundVersion(tx *gorm.DB, id int, version int64) error {
+	result := tx.Model(model.Inbound{}).Where("id = ? AND version = ?", id, version).
+		Update("version", gorm.Expr("version + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		current := &model.Inbound{}
+		if err := tx.Model(model.Inbound{}).Preload("ClientStats").First(current, id).Error; err != nil {
+			return err
+		}
+		return &InboundConflictError{Current: current}
+	}
+	return nil
+}
+
 // UpdateInbound modifies an existing inbound configuration.
 // It validates changes, updates the database, and syncs with the running Xray instance.
+// If inbound.Version is set, the update only applies to that version of the inbound and
+// fails with an *InboundConflictError otherwise.
 // Returns the updated inbound, whether Xray needs restart, and any error.
-func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+func (s *InboundService) UpdateInbound(inbound *model.Inbound) (_ *model.Inbound, needRestart bool, err error) {
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, inbound.Id)
 	if err != nil {
 		return inbound, false, err
@@ -398,18 +473,28 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	}
 
 	tag := oldInbound.Tag
+	oldConfig, _ := json.MarshalIndent(oldInbound.GenXrayInboundConfig(), "", "  ")
+	oldLimits := inboundRateLimits(oldInbound)
 
 	db := database.GetDB()
 	tx := db.Begin()
-
+	undo := &xrayUndo{}
 	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
+		err = s.finishInboundTx(tx, err, undo)
 	}()
 
+	// Claim the next version; this fails if the inbound changed since the client read it
+	// or since oldInbound was loaded. Version 0 means the client did not send one.
+	version := oldInbound.Version
+	if inbound.Version > 0 {
+		version = inbound.Version
+	}
+	if err = claimInboundVersion(tx, inbound.Id, version); err != nil {
+		return inbound, false, err
+	}
+	oldInbound.Version = version + 1
+	inbound.Version = oldInbound.Version
+
 	err = s.updateClientTraffics(tx, oldInbound, inbound)
 	if err != nil {
 		return inbound, false, err
@@ -478,9 +563,12 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	oldInbound.Down = inbound.Down
 	oldInbound.Total = inbound.Total
 	oldInbound.Remark = inbound.Remark
+	oldInbound.AddressOverride = inbound.AddressOverride
 	oldInbound.Enable = inbound.Enable
 	oldInbound.ExpiryTime = inbound.ExpiryTime
 	oldInbound.TrafficReset = inbound.TrafficReset
+	oldInbound.SpeedLimitUp = inbound.SpeedLimitUp
+	oldInbound.SpeedLimitDown = inbound.SpeedLimitDown
 	oldInbound.Listen = inbound.Listen
 	oldInbound.Port = inbound.Port
 	oldInbound.Protocol = inbound.Protocol
@@ -493,29 +581,47 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		oldInbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
 	}
 
-	needRestart := false
+	var inboundJson []byte
+	if inbound.Enable {
+		var err2 error
+		inboundJson, err2 = json.MarshalIndent(oldInbound.GenXrayInboundConfig(), "", "  ")
+		if err2 == nil {
+			err2 = xray.ValidateInbound(inboundJson)
+		}
+		if err2 != nil {
+			// Saving a config Xray rejects would break its next restart
+			err = common.NewError("invalid inbound config:", err2)
+			return inbound, false, err
+		}
+	}
+
+	err = tx.Save(oldInbound).Error
+	if err != nil {
+		return inbound, false, err
+	}
+
 	s.xrayApi.Init(p.GetAPIPort())
 	if s.xrayApi.DelInbound(tag) == nil {
 		logger.Debug("Old inbound deleted by api:", tag)
+		undo.add(func(s *InboundService) error { return s.xrayApi.AddInbound(oldConfig) })
 	}
 	if inbound.Enable {
-		inboundJson, err2 := json.MarshalIndent(oldInbound.GenXrayInboundConfig(), "", "  ")
-		if err2 != nil {
-			logger.Debug("Unable to marshal updated inbound config:", err2)
-			needRestart = true
+		err2 := s.xrayApi.AddInbound(inboundJson)
+		if err2 == nil {
+			logger.Debug("Updated inbound added by api:", oldInbound.Tag)
+			newTag := oldInbound.Tag
+			undo.add(func(s *InboundService) error { return s.xrayApi.DelInbound(newTag) })
 		} else {
-			err2 = s.xrayApi.AddInbound(inboundJson)
-			if err2 == nil {
-				logger.Debug("Updated inbound added by api:", oldInbound.Tag)
-			} else {
-				logger.Debug("Unable to update inbound by api:", err2)
-				needRestart = true
-			}
+			logger.Debug("Unable to update inbound by api:", err2)
+			needRestart = true
 		}
 	}
 	s.xrayApi.Close()
+	if !slices.Equal(oldLimits, inboundRateLimits(oldInbound)) {
+		needRestart = true
+	}
 
-	return inbound, needRestart, tx.Save(oldInbound).Error
+	return inbound, needRestart, nil
 }
 
 func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inbound, newInbound *model.Inbound) error {
@@ -563,7 +669,9 @@ func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inb
 	return nil
 }
 
-func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
+// AddInboundClient appends the clients of data to an inbound, creates their stats and
+// adds them to the running Xray, all or nothing.
+func (s *InboundService) AddInboundClient(data *model.Inbound) (needRestart bool, err error) {
 	clients, err := s.GetClients(data)
 	if err != nil {
 		return false, err
@@ -638,20 +746,31 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 
 	db := database.GetDB()
 	tx := db.Begin()
-
+	undo := &xrayUndo{}
 	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
+		err = s.finishInboundTx(tx, err, undo)
 	}()
 
-	needRestart := false
+	if err = claimInboundVersion(tx, oldInbound.Id, oldInbound.Version); err != nil {
+		return false, err
+	}
+	oldInbound.Version++
+
+	for _, client := range clients {
+		if len(client.Email) > 0 {
+			if err = s.AddClientStat(tx, data.Id, &client); err != nil {
+				return false, err
+			}
+		}
+	}
+	err = tx.Save(oldInbound).Error
+	if err != nil {
+		return false, err
+	}
+
 	s.xrayApi.Init(p.GetAPIPort())
 	for _, client := range clients {
 		if len(client.Email) > 0 {
-			s.AddClientStat(tx, data.Id, &client)
 			if client.Enable {
 				cipher := ""
 				if oldInbound.Protocol == "shadowsocks" {
@@ -667,6 +786,8 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 				})
 				if err1 == nil {
 					logger.Debug("Client added by api:", client.Email)
+					tag, email := oldInbound.Tag, client.Email
+					undo.add(func(s *InboundService) error { return s.xrayApi.RemoveUser(tag, email) })
 				} else {
 					logger.Debug("Error in adding client by api:", err1)
 					needRestart = true
@@ -675,13 +796,18 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		} else {
 			needRestart = true
 		}
+		if modelClientRateLimit(client) != (rateLimit{}) {
+			needRestart = true
+		}
 	}
 	s.xrayApi.Close()
 
-	return needRestart, tx.Save(oldInbound).Error
+	return needRestart, nil
 }
 
-func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
+// DelInboundClient removes a client from an inbound together with its stats and IPs, and
+// from the running Xray, all or nothing.
+func (s *InboundService) DelInboundClient(inboundId int, clientId string) (needRestart bool, err error) {
 	oldInbound, err := s.GetInbound(inboundId)
 	if err != nil {
 		logger.Error("Load Old Data Error")
@@ -704,6 +830,7 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 
 	interfaceClients := settings["clients"].([]any)
 	var newClients []any
+	var removed map[string]any
 	needApiDel := false
 	for _, client := range interfaceClients {
 		c := client.(map[string]any)
@@ -711,6 +838,7 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 		if c_id == clientId {
 			email, _ = c["email"].(string)
 			needApiDel, _ = c["enable"].(bool)
+			removed = c
 		} else {
 			newClients = append(newClients, client)
 		}
@@ -729,47 +857,63 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 	oldInbound.Settings = string(newSettings)
 
 	db := database.GetDB()
+	tx := db.Begin()
+	undo := &xrayUndo{}
+	defer func() {
+		err = s.finishInboundTx(tx, err, undo)
+	}()
 
-	err = s.DelClientIPs(db, email)
+	if err = claimInboundVersion(tx, oldInbound.Id, oldInbound.Version); err != nil {
+		return false, err
+	}
+	oldInbound.Version++
+
+	err = s.DelClientIPs(tx, email)
 	if err != nil {
 		logger.Error("Error in delete client IPs")
 		return false, err
 	}
-	needRestart := false
 
+	notDepleted := true
 	if len(email) > 0 {
-		notDepleted := true
-		err = db.Model(xray.ClientTraffic{}).Select("enable").Where("email = ?", email).First(&notDepleted).Error
+		err = tx.Model(xray.ClientTraffic{}).Select("enable").Where("email = ?", email).First(&notDepleted).Error
 		if err != nil {
 			logger.Error("Get stats error")
 			return false, err
 		}
-		err = s.DelClientStat(db, email)
+		err = s.DelClientStat(tx, email)
 		if err != nil {
 			logger.Error("Delete stats Data Error")
 			return false, err
 		}
-		if needApiDel && notDepleted {
-			s.xrayApi.Init(p.GetAPIPort())
-			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
-			if err1 == nil {
-				logger.Debug("Client deleted by api:", email)
-				needRestart = false
+	}
+	err = tx.Save(oldInbound).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(email) > 0 && needApiDel && notDepleted {
+		s.xrayApi.Init(p.GetAPIPort())
+		err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
+		if err1 == nil {
+			logger.Debug("Client deleted by api:", email)
+			needRestart = false
+			cipher, _ := settings["method"].(string)
+			undo.add(readdUserStep(oldInbound, removed, cipher))
+		} else {
+			if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
+				logger.Debug("User is already deleted. Nothing to do more...")
 			} else {
-				if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
-					logger.Debug("User is already deleted. Nothing to do more...")
-				} else {
-					logger.Debug("Error in deleting client by api:", err1)
-					needRestart = true
-				}
+				logger.Debug("Error in deleting client by api:", err1)
+				needRestart = true
 			}
-			s.xrayApi.Close()
 		}
+		s.xrayApi.Close()
 	}
-	return needRestart, db.Save(oldInbound).Error
+	return needRestart, nil
 }
 
-func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (bool, error) {
+func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (needRestart bool, err error) {
 	// This function updates a specific client within an inbound.
 	// Client-level Reset field is updated as part of the client JSON.
 	// Inbound-level TrafficReset is NOT updated here (use UpdateInbound for that).
@@ -870,15 +1014,16 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	oldInbound.Settings = string(newSettings)
 	db := database.GetDB()
 	tx := db.Begin()
-
+	undo := &xrayUndo{}
 	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
+		err = s.finishInboundTx(tx, err, undo)
 	}()
 
+	if err = claimInboundVersion(tx, oldInbound.Id, oldInbound.Version); err != nil {
+		return false, err
+	}
+	oldInbound.Version++
+
 	if len(clients[0].Email) > 0 {
 		if len(oldEmail) > 0 {
 			err = s.UpdateClientStat(tx, oldEmail, &clients[0])
@@ -890,7 +1035,10 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 				return false, err
 			}
 		} else {
-			s.AddClientStat(tx, data.Id, &clients[0])
+			err = s.AddClientStat(tx, data.Id, &clients[0])
+			if err != nil {
+				return false, err
+			}
 		}
 	} else {
 		err = s.DelClientStat(tx, oldEmail)
@@ -902,13 +1050,30 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			return false, err
 		}
 	}
-	needRestart := false
+	err = tx.Save(oldInbound).Error
+	if err != nil {
+		return false, err
+	}
+
 	if len(oldEmail) > 0 {
+		cipher, _ := oldSettings["method"].(string)
 		s.xrayApi.Init(p.GetAPIPort())
 		if oldClients[clientIndex].Enable {
 			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, oldEmail)
 			if err1 == nil {
 				logger.Debug("Old client deleted by api:", oldEmail)
+				old := oldClients[clientIndex]
+				protocol, tag := string(oldInbound.Protocol), oldInbound.Tag
+				undo.add(func(s *InboundService) error {
+					return s.xrayApi.AddUser(protocol, tag, map[string]any{
+						"email":    old.Email,
+						"id":       old.ID,
+						"security": old.Security,
+						"flow":     old.Flow,
+						"password": old.Password,
+						"cipher":   cipher,
+					})
+				})
 			} else {
 				if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", oldEmail)) {
 					logger.Debug("User is already deleted. Nothing to do more...")
@@ -919,10 +1084,6 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			}
 		}
 		if clients[0].Enable {
-			cipher := ""
-			if oldInbound.Protocol == "shadowsocks" {
-				cipher = oldSettings["method"].(string)
-			}
 			err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, map[string]any{
 				"email":    clients[0].Email,
 				"id":       clients[0].ID,
@@ -933,6 +1094,8 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			})
 			if err1 == nil {
 				logger.Debug("Client edited by api:", clients[0].Email)
+				tag, email := oldInbound.Tag, clients[0].Email
+				undo.add(func(s *InboundService) error { return s.xrayApi.RemoveUser(tag, email) })
 			} else {
 				logger.Debug("Error in adding client by api:", err1)
 				needRestart = true
@@ -943,7 +1106,10 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		logger.Debug("Client old email not found")
 		needRestart = true
 	}
-	return needRestart, tx.Save(oldInbound).Error
+	if modelClientRateLimit(oldClients[clientIndex]) != modelClientRateLimit(clients[0]) {
+		needRestart = true
+	}
+	return needRestart, nil
 }
 
 func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
@@ -1039,6 +1205,14 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 		return nil
 	}
 
+	// Clients whose expiry starts on first use are adjusted below and must be saved
+	changed := make(map[string]bool)
+	for _, dbTraffic := range dbClientTraffics {
+		if dbTraffic.ExpiryTime < 0 {
+			changed[dbTraffic.Email] = true
+		}
+	}
+
 	dbClientTraffics, err = s.adjustTraffics(tx, dbClientTraffics)
 	if err != nil {
 		return err
@@ -1055,6 +1229,7 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 				if traffics[traffic_index].Up+traffics[traffic_index].Down > 0 {
 					onlineClients = append(onlineClients, traffics[traffic_index].Email)
 					dbClientTraffics[dbTraffic_index].LastOnline = time.Now().UnixMilli()
+					changed[traffics[traffic_index].Email] = true
 				}
 				break
 			}
@@ -1064,7 +1239,18 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 	// Set onlineUsers
 	p.SetOnlineClients(onlineClients)
 
-	err = tx.Save(dbClientTraffics).Error
+	// Only save rows that changed, so updated_at tells incremental syncs what to fetch
+	changedTraffics := make([]*xray.ClientTraffic, 0, len(changed))
+	for _, dbTraffic := range dbClientTraffics {
+		if changed[dbTraffic.Email] {
+			changedTraffics = append(changedTraffics, dbTraffic)
+		}
+	}
+	if len(changedTraffics) == 0 {
+		return nil
+	}
+
+	err = tx.Save(changedTraffics).Error
 	if err != nil {
 		logger.Warning("AddClientTraffic update data ", err)
 	}
@@ -1175,6 +1361,9 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 					}
 					c["expiryTime"] = newExpiryTime
 					traffics[traffic_index].ExpiryTime = newExpiryTime
+					if traffic.AllTime < traffic.Up+traffic.Down {
+						traffics[traffic_index].AllTime = traffic.Up + traffic.Down
+					}
 					traffics[traffic_index].Down = 0
 					traffics[traffic_index].Up = 0
 					if !traffic.Enable {
@@ -1220,6 +1409,9 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 			if err1 != nil {
 				needRestart = true
 			}
+			if _, limited := clientRateLimit(clientToAdd.tag, clientToAdd.client); limited {
+				needRestart = true
+			}
 		}
 		s.xrayApi.Close()
 	}
@@ -1264,20 +1456,24 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 	now := time.Now().Unix() * 1000
 	needRestart := false
 
-	if p != nil {
-		var results []struct {
-			Tag   string
-			Email string
-		}
+	var results []struct {
+		Tag        string
+		Email      string
+		Up         int64
+		Down       int64
+		Total      int64
+		ExpiryTime int64
+	}
+	err := tx.Table("inbounds").
+		Select("inbounds.tag, client_traffics.email, client_traffics.up, client_traffics.down, client_traffics.total, client_traffics.expiry_time").
+		Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
+		Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
+		Scan(&results).Error
+	if err != nil {
+		return false, 0, err
+	}
 
-		err := tx.Table("inbounds").
-			Select("inbounds.tag, client_traffics.email").
-			Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
-			Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
-			Scan(&results).Error
-		if err != nil {
-			return false, 0, err
-		}
+	if p != nil {
 		s.xrayApi.Init(p.GetAPIPort())
 		for _, result := range results {
 			err1 := s.xrayApi.RemoveUser(result.Tag, result.Email)
@@ -1301,8 +1497,25 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 	result := tx.Model(xray.ClientTraffic{}).
 		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
 		Update("enable", false)
-	err := result.Error
+	err = result.Error
 	count := result.RowsAffected
+	if err == nil {
+		for _, client := range results {
+			reason := "expired"
+			if client.Total > 0 && client.Up+client.Down >= client.Total {
+				reason = "traffic"
+			}
+			s.webhookService.Emit(WebhookClientDepleted, map[string]any{
+				"email":      client.Email,
+				"inbound":    client.Tag,
+				"reason":     reason,
+				"up":         client.Up,
+				"down":       client.Down,
+				"total":      client.Total,
+				"expiryTime": client.ExpiryTime,
+			})
+		}
+	}
 	return needRestart, count, err
 }
 
@@ -1778,7 +1991,7 @@ func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
 	// Reset traffic stats in ClientTraffic table
 	result := db.Model(xray.ClientTraffic{}).
 		Where("email = ?", clientEmail).
-		Updates(map[string]any{"enable": true, "up": 0, "down": 0})
+		Updates(map[string]any{"enable": true, "up": 0, "down": 0, "all_time": allTimeRollup})
 
 	err := result.Error
 	if err != nil {
@@ -1837,6 +2050,10 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 		}
 	}
 
+	// all_time normally grows with every traffic update; this covers rows that predate it
+	if traffic.AllTime < traffic.Up+traffic.Down {
+		traffic.AllTime = traffic.Up + traffic.Down
+	}
 	traffic.Up = 0
 	traffic.Down = 0
 	traffic.Enable = true
@@ -1862,10 +2079,10 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 			whereText += " = ?"
 		}
 
-		// Reset client traffics
+		// Reset client traffics, keeping their usage in all_time
 		result := tx.Model(xray.ClientTraffic{}).
 			Where(whereText, id).
-			Updates(map[string]any{"enable": true, "up": 0, "down": 0})
+			Updates(map[string]any{"enable": true, "up": 0, "down": 0, "all_time": allTimeRollup})
 
 		if result.Error != nil {
 			return result.Error
@@ -1887,12 +2104,25 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 	})
 }
 
+// allTimeRollup keeps all_time at least up+down when counters are reset.
+// all_time normally grows with every traffic update; this covers rows that predate it.
+var allTimeRollup = gorm.Expr("MAX(IFNULL(all_time, 0), IFNULL(up, 0) + IFNULL(down, 0))")
+
+// ResetInboundTraffic resets the traffic counters of a single inbound and records the
+// time of the reset, as ResetAllClientTraffics does.
+func (s *InboundService) ResetInboundTraffic(id int) error {
+	db := database.GetDB()
+	return db.Model(model.Inbound{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"up": 0, "down": 0, "all_time": allTimeRollup, "last_traffic_reset_time": time.Now().Unix() * 1000}).Error
+}
+
 func (s *InboundService) ResetAllTraffics() error {
 	db := database.GetDB()
 
 	result := db.Model(model.Inbound{}).
 		Where("user_id > ?", 0).
-		Updates(map[string]any{"up": 0, "down": 0})
+		Updates(map[string]any{"up": 0, "down": 0, "all_time": allTimeRollup})
 
 	err := result.Error
 	return err
@@ -1964,6 +2194,10 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 			}
 
 			oldInbound.Settings = string(newSettings)
+			if err = claimInboundVersion(tx, oldInbound.Id, oldInbound.Version); err != nil {
+				return err
+			}
+			oldInbound.Version++
 			err = tx.Save(oldInbound).Error
 			if err != nil {
 				return err
@@ -2144,7 +2378,7 @@ func (s *InboundService) SearchClientTraffic(query string) (traffic *xray.Client
 func (s *InboundService) GetInboundClientIps(clientEmail string) (string, error) {
 	db := database.GetDB()
 	InboundClientIps := &model.InboundClientIps{}
-	err := db.Model(model.InboundClientIps{}).Where("client_email = ?", clientEmail).First(InboundClientIps).Error
+	err := db.Model(model.InboundClientIps{}).Where("client_email = ? AND server_id = ?", clientEmail, s.serverMgmt.GetLocalServerId()).First(InboundClientIps).Error
 	if err != nil {
 		return "", err
 	}
@@ -2339,6 +2573,73 @@ func (s *InboundService) GetOnlineClients() []string {
 	return p.GetOnlineClients()
 }
 
+// GetOnlineClientDetails returns the online clients with their source IPs and GeoIP data.
+// Source IPs come from the Xray online IP list when the statsUserOnline policy is
+// enabled, otherwise from the addresses recorded by the client IP job.
+func (s *InboundService) GetOnlineClientDetails() []*OnlineClient {
+	if p == nil {
+		return []*OnlineClient{}
+	}
+	emails := p.GetOnlineClients()
+	result := make([]*OnlineClient, 0, len(emails))
+	if len(emails) == 0 {
+		return result
+	}
+
+	// Each call has its own client, as handlers share the service concurrently
+	var xrayAPI xray.XrayAPI
+	apiReady := p.IsRunning() && xrayAPI.Init(p.GetAPIPort()) == nil
+	if apiReady {
+		defer xrayAPI.Close()
+	}
+
+	geoIP := GeoIPService{}
+	for _, email := range emails {
+		var lastSeen map[string]int64
+		if apiReady {
+			ips, err := xrayAPI.GetOnlineIPs(email)
+			if err != nil {
+				logger.Debug("Failed to get online IPs for", email, ":", err)
+			} else {
+				lastSeen = ips
+			}
+		}
+
+		if len(lastSeen) == 0 {
+			lastSeen = make(map[string]int64)
+			if ipsJson, err := s.GetInboundClientIps(email); err == nil && ipsJson != "" {
+				var ips []string
+				if json.Unmarshal([]byte(ipsJson), &ips) == nil {
+					for _, ip := range ips {
+						lastSeen[ip] = 0
+					}
+				}
+			}
+		}
+
+		client := &OnlineClient{
+			Email:   email,
+			IPCount: len(lastSeen),
+			IPs:     make([]*OnlineClientIP, 0, len(lastSeen)),
+		}
+		for ip, seen := range lastSeen {
+			country, asn := geoIP.Lookup(ip)
+			client.IPs = append(client.IPs, &OnlineClientIP{
+				IP:       ip,
+				LastSeen: seen,
+				Country:  country,
+				ASN:      asn,
+			})
+		}
+		sort.Slice(client.IPs, func(i, j int) bool {
+			return client.IPs[i].IP < client.IPs[j].IP
+		})
+		result = append(result, client)
+	}
+
+	return result
+}
+
 func (s *InboundService) GetClientsLastOnline() (map[string]int64, error) {
 	db := database.GetDB()
 	var rows []xray.ClientTraffic
@@ -2386,7 +2687,7 @@ func (s *InboundService) FilterAndSortClientEmails(emails []string) ([]string, [
 
 	return validEmails, extraEmails, nil
 }
-func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (bool, error) {
+func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (needRestart bool, err error) {
 	oldInbound, err := s.GetInbound(inboundId)
 	if err != nil {
 		logger.Error("Load Old Data Error")
@@ -2404,6 +2705,7 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 	}
 
 	var newClients []any
+	var removed map[string]any
 	needApiDel := false
 	found := false
 
@@ -2416,6 +2718,7 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 			// matched client, drop it
 			found = true
 			needApiDel, _ = c["enable"].(bool)
+			removed = c
 		} else {
 			newClients = append(newClients, client)
 		}
@@ -2437,44 +2740,51 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 	oldInbound.Settings = string(newSettings)
 
 	db := database.GetDB()
+	tx := db.Begin()
+	undo := &xrayUndo{}
+	defer func() {
+		err = s.finishInboundTx(tx, err, undo)
+	}()
+
+	if err = claimInboundVersion(tx, oldInbound.Id, oldInbound.Version); err != nil {
+		return false, err
+	}
+	oldInbound.Version++
 
 	// remove IP bindings
-	if err := s.DelClientIPs(db, email); err != nil {
+	if err = s.DelClientIPs(tx, email); err != nil {
 		logger.Error("Error in delete client IPs")
 		return false, err
 	}
 
-	needRestart := false
-
 	// remove stats too
 	if len(email) > 0 {
-		traffic, err := s.GetClientTrafficByEmail(email)
-		if err != nil {
+		if err = s.DelClientStat(tx, email); err != nil {
+			logger.Error("Delete stats Data Error")
 			return false, err
 		}
-		if traffic != nil {
-			if err := s.DelClientStat(db, email); err != nil {
-				logger.Error("Delete stats Data Error")
-				return false, err
-			}
-		}
+	}
+	if err = tx.Save(oldInbound).Error; err != nil {
+		return false, err
+	}
 
-		if needApiDel {
-			s.xrayApi.Init(p.GetAPIPort())
-			if err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email); err1 == nil {
-				logger.Debug("Client deleted by api:", email)
-				needRestart = false
+	if len(email) > 0 && needApiDel {
+		s.xrayApi.Init(p.GetAPIPort())
+		if err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email); err1 == nil {
+			logger.Debug("Client deleted by api:", email)
+			needRestart = false
+			cipher, _ := settings["method"].(string)
+			undo.add(readdUserStep(oldInbound, removed, cipher))
+		} else {
+			if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
+				logger.Debug("User is already deleted. Nothing to do more...")
 			} else {
-				if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
-					logger.Debug("User is already deleted. Nothing to do more...")
-				} else {
-					logger.Debug("Error in deleting client by api:", err1)
-					needRestart = true
-				}
+				logger.Debug("Error in deleting client by api:", err1)
+				needRestart = true
 			}
-			s.xrayApi.Close()
 		}
+		s.xrayApi.Close()
 	}
 
-	return needRestart, db.Save(oldInbound).Error
+	return needRestart, nil
 }
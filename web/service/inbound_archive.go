@@ -0,0 +1,150 @@
+// Package service provides InboundArchiveService for archiving deleted inbounds and restoring them.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"gorm.io/gorm"
+)
+
+// inboundArchiveRetention is how long archived inbounds are kept.
+const inboundArchiveRetention = 90 * 24 * time.Hour
+
+// InboundArchiveService saves the full definition of inbounds before they are deleted on
+// any server, so accidental deletions can be undone.
+type InboundArchiveService struct {
+	serverMgmt ServerManagementService
+}
+
+// Archive saves an inbound, including its settings, clients and client traffic, as
+// deleted from serverId.
+func (s *InboundArchiveService) Archive(serverId int, inbound *model.Inbound) (*model.InboundArchive, error) {
+	return archiveInbound(database.GetDB(), serverId, inbound)
+}
+
+// archiveInbound saves an inbound as deleted from serverId within tx and drops archives
+// older than inboundArchiveRetention.
+func archiveInbound(tx *gorm.DB, serverId int, inbound *model.Inbound) (*model.InboundArchive, error) {
+	definition, err := json.Marshal(inbound)
+	if err != nil {
+		return nil, err
+	}
+	clients, _ := (&InboundService{}).GetClients(inbound)
+
+	archive := &model.InboundArchive{
+		ServerId:    serverId,
+		InboundId:   inbound.Id,
+		Tag:         inbound.Tag,
+		Remark:      inbound.Remark,
+		Protocol:    string(inbound.Protocol),
+		Port:        inbound.Port,
+		ClientCount: len(clients),
+		Definition:  string(definition),
+	}
+	if err := tx.Create(archive).Error; err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-inboundArchiveRetention).Unix()
+	if err := tx.Where("archived_at < ?", cutoff).Delete(model.InboundArchive{}).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("Inbound %d (%s) of server %d archived as %d", inbound.Id, inbound.Remark, serverId, archive.Id)
+	return archive, nil
+}
+
+// GetArchives returns the archived inbounds of a server, or of all servers if serverId
+// is 0, newest first and without their definitions.
+func (s *InboundArchiveService) GetArchives(serverId int) ([]*model.InboundArchive, error) {
+	db := database.GetDB().Model(model.InboundArchive{}).Omit("definition")
+	if serverId != 0 {
+		db = db.Where("server_id = ?", serverId)
+	}
+	var archives []*model.InboundArchive
+	if err := db.Order("id DESC").Find(&archives).Error; err != nil {
+		return nil, err
+	}
+
+	servers, err := s.serverMgmt.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(servers))
+	for _, server := range servers {
+		names[server.Id] = server.Name
+	}
+	for _, archive := range archives {
+		archive.ServerName = names[archive.ServerId]
+	}
+	return archives, nil
+}
+
+// GetArchive returns an archived inbound with its definition.
+func (s *InboundArchiveService) GetArchive(id int) (*model.InboundArchive, error) {
+	archive := &model.InboundArchive{}
+	if err := database.GetDB().Model(model.InboundArchive{}).Where("id = ?", id).First(archive).Error; err != nil {
+		return nil, fmt.Errorf("archive %d not found", id)
+	}
+	if server, err := s.serverMgmt.GetServer(archive.ServerId); err == nil {
+		archive.ServerName = server.Name
+	}
+	return archive, nil
+}
+
+// Restore adds an archived inbound back to its server, or to serverId if it is not 0,
+// with its clients. Client traffic starts from zero and the inbound gets a new ID.
+func (s *InboundArchiveService) Restore(ctx context.Context, id int, serverId int) (*model.Inbound, error) {
+	archive, err := s.GetArchive(id)
+	if err != nil {
+		return nil, err
+	}
+	if serverId == 0 {
+		serverId = archive.ServerId
+	}
+
+	inbound := &model.Inbound{}
+	if err := json.Unmarshal([]byte(archive.Definition), inbound); err != nil {
+		return nil, fmt.Errorf("archive %d is corrupt: %w", id, err)
+	}
+	inbound.Id = 0
+	inbound.ServerId = serverId
+	inbound.Up = 0
+	inbound.Down = 0
+	inbound.ClientStats = nil
+	if err := ValidateInbound(inbound); err != nil {
+		return nil, err
+	}
+
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return nil, err
+	}
+	if err := connector.AddInbound(ctx, inbound); err != nil {
+		return nil, err
+	}
+	if err := connector.RestartXray(ctx); err != nil {
+		logger.Warning("Failed to restart Xray after restoring inbound:", err)
+	}
+
+	archive.RestoredAt = time.Now().Unix()
+	database.GetDB().Model(archive).Update("restored_at", archive.RestoredAt)
+	recordServerEvent(serverId, "config", fmt.Sprintf("Inbound %s restored from archive %d", inbound.Remark, archive.Id))
+	return inbound, nil
+}
+
+// DeleteArchive removes an archived inbound for good.
+func (s *InboundArchiveService) DeleteArchive(id int) error {
+	result := database.GetDB().Delete(model.InboundArchive{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("archive %d not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package service provides the panel-side cache of the inbound lists of remote servers.
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// inboundCacheTTL is how long the inbound list of a remote server is served from the
+// cache. It is short, as traffic counters change all the time; the cache only keeps
+// panel browsing and concurrent jobs from fetching the same list over and over.
+const inboundCacheTTL = 5 * time.Second
+
+// inboundCacheEntry is the cached inbound list of one server.
+type inboundCacheEntry struct {
+	inbounds  []*model.Inbound
+	fetchedAt time.Time
+}
+
+var (
+	inboundCacheMu sync.Mutex
+	inboundCache   = map[int]*inboundCacheEntry{}
+	// inboundCacheGen counts the invalidations of each server, so a list fetched while
+	// the server was being changed is not cached.
+	inboundCacheGen = map[int]uint64{}
+)
+
+// cachedInbounds returns a copy of the cached inbound list of a server and whether it
+// is fresh, along with the generation to pass to storeInbounds after fetching it.
+func cachedInbounds(serverId int) ([]*model.Inbound, bool, uint64) {
+	inboundCacheMu.Lock()
+	defer inboundCacheMu.Unlock()
+
+	gen := inboundCacheGen[serverId]
+	entry, ok := inboundCache[serverId]
+	if !ok || time.Since(entry.fetchedAt) >= inboundCacheTTL {
+		return nil, false, gen
+	}
+	return copyInbounds(entry.inbounds), true, gen
+}
+
+// storeInbounds caches the inbound list of a server, unless it was invalidated since
+// gen was read.
+func storeInbounds(serverId int, gen uint64, inbounds []*model.Inbound) {
+	inboundCacheMu.Lock()
+	defer inboundCacheMu.Unlock()
+
+	if inboundCacheGen[serverId] != gen {
+		return
+	}
+	inboundCache[serverId] = &inboundCacheEntry{inbounds: copyInbounds(inbounds), fetchedAt: time.Now()}
+}
+
+// InvalidateInboundCache drops the cached inbound list of a server, so the next
+// ListInbounds asks its agent again. It is called whenever inbounds or clients of the
+// server are changed through the panel.
+func InvalidateInboundCache(serverId int) {
+	inboundCacheMu.Lock()
+	defer inboundCacheMu.Unlock()
+
+	delete(inboundCache, serverId)
+	inboundCacheGen[serverId]++
+}
+
+// copyInbounds returns copies of inbounds, so callers may modify what they get without
+// changing the cache.
+func copyInbounds(inbounds []*model.Inbound) []*model.Inbound {
+	copies := make([]*model.Inbound, len(inbounds))
+	for i, inbound := range inbounds {
+		c := *inbound
+		c.ServerTags = slices.Clone(inbound.ServerTags)
+		c.ClientStats = slices.Clone(inbound.ClientStats)
+		copies[i] = &c
+	}
+	return copies
+}
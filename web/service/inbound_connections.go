@@ -0,0 +1,137 @@
+// Package service provides InboundConnectionService for per-inbound connection statistics.
+package service
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// InboundConnections is the current load of one inbound on one server.
+type InboundConnections struct {
+	ServerId      int    `json:"serverId"`
+	ServerName    string `json:"serverName"`
+	InboundId     int    `json:"inboundId"`
+	Remark        string `json:"remark"`
+	Port          int    `json:"port"`
+	Connections   int    `json:"connections"`   // Established TCP connections to the port
+	OnlineClients int    `json:"onlineClients"` // Clients of the inbound seen online by Xray
+}
+
+// InboundConnectionGroup sums the load of inbounds sharing a tag, i.e. the same inbound
+// replicated on several servers.
+type InboundConnectionGroup struct {
+	Tag           string                `json:"tag"`
+	Protocol      string                `json:"protocol"`
+	Connections   int                   `json:"connections"`
+	OnlineClients int                   `json:"onlineClients"`
+	Servers       []*InboundConnections `json:"servers"`
+}
+
+// InboundConnectionService reports which listeners carry load across the fleet.
+type InboundConnectionService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetInboundConnections returns the connection counts of every inbound on one server,
+// or on all enabled servers if serverId is 0, grouped by inbound tag and sorted by
+// connections. Servers that cannot be reached are skipped in the all-servers case.
+func (s *InboundConnectionService) GetInboundConnections(ctx context.Context, serverId int) ([]*InboundConnectionGroup, error) {
+	var servers []*model.Server
+	if serverId == 0 {
+		enabled, err := s.serverMgmt.GetEnabledServers()
+		if err != nil {
+			return nil, err
+		}
+		servers = enabled
+	} else {
+		server, err := s.serverMgmt.GetServer(serverId)
+		if err != nil {
+			return nil, err
+		}
+		servers = []*model.Server{server}
+	}
+
+	groups := make(map[string]*InboundConnectionGroup)
+	for _, server := range servers {
+		rows, protocols, err := s.getServerConnections(ctx, server)
+		if err != nil {
+			if serverId != 0 {
+				return nil, err
+			}
+			logger.Warning("Failed to get inbound connections of server", server.Id, ":", err)
+			continue
+		}
+		for tag, row := range rows {
+			group, ok := groups[tag]
+			if !ok {
+				group = &InboundConnectionGroup{Tag: tag, Protocol: protocols[tag]}
+				groups[tag] = group
+			}
+			group.Connections += row.Connections
+			group.OnlineClients += row.OnlineClients
+			group.Servers = append(group.Servers, row)
+		}
+	}
+
+	result := make([]*InboundConnectionGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Connections != result[j].Connections {
+			return result[i].Connections > result[j].Connections
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result, nil
+}
+
+// getServerConnections returns the load of each inbound of a server by tag, together
+// with the inbound protocols.
+func (s *InboundConnectionService) getServerConnections(ctx context.Context, server *model.Server) (map[string]*InboundConnections, map[string]string, error) {
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts, err := connector.GetConnectionCounts(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	online, err := connector.GetOnlineClients(ctx)
+	if err != nil {
+		online = nil // Connection counts are still useful without online clients
+	}
+
+	rows := make(map[string]*InboundConnections, len(inbounds))
+	protocols := make(map[string]string, len(inbounds))
+	for _, inbound := range inbounds {
+		row := &InboundConnections{
+			ServerId:    server.Id,
+			ServerName:  server.Name,
+			InboundId:   inbound.Id,
+			Remark:      inbound.Remark,
+			Port:        inbound.Port,
+			Connections: counts[inbound.Port],
+		}
+		for _, stat := range inbound.ClientStats {
+			if slices.Contains(online, stat.Email) {
+				row.OnlineClients++
+			}
+		}
+		rows[inbound.Tag] = row
+		protocols[inbound.Tag] = string(inbound.Protocol)
+	}
+	return rows, protocols, nil
+}
@@ -0,0 +1,246 @@
+// Package service provides InboundDeployService for creating one inbound on many servers at once.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/redact"
+)
+
+const (
+	// InboundDeployOperation is the ServerTask operation of batch inbound deployments.
+	// It differs from SyncAddInbound so the sync queue never replays a deployment.
+	InboundDeployOperation = "deploy_inbound"
+
+	// DefaultDeployConcurrency is how many servers an inbound is deployed to at once by default.
+	DefaultDeployConcurrency = 5
+
+	// inboundDeployTimeout bounds creating the inbound and restarting Xray on one server.
+	inboundDeployTimeout = time.Minute
+)
+
+// InboundDeployService creates an inbound template on several servers concurrently and
+// records a ServerTask per server.
+type InboundDeployService struct {
+	serverMgmt ServerManagementService
+	groups     ServerGroupService
+	tunnel     TunnelService
+}
+
+// InboundDeployRequest is an inbound template and the servers to create it on: explicit
+// server IDs, a server group, or a label selector (comma-separated tags, region or scope).
+type InboundDeployRequest struct {
+	Inbound     *model.Inbound `json:"inbound"`
+	ServerIds   []int          `json:"serverIds"`
+	Group       string         `json:"group"` // ID or name of a server group
+	Tags        string         `json:"tags"`
+	Region      string         `json:"region"`
+	Scope       string         `json:"scope"`
+	Concurrency int            `json:"concurrency"`
+}
+
+// InboundDeployResult is the outcome of a deployment on one server.
+type InboundDeployResult struct {
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	TaskId     int    `json:"taskId"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Deploy creates the inbound template on every target server, at most req.Concurrency
+// servers at a time, and returns the result of each server once all are done.
+func (s *InboundDeployService) Deploy(req *InboundDeployRequest, userId int) ([]*InboundDeployResult, error) {
+	if req.Inbound == nil {
+		return nil, fmt.Errorf("inbound template is required")
+	}
+	servers, err := s.resolveTargets(req)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultDeployConcurrency
+	}
+
+	template := *req.Inbound
+	template.Id = 0
+	template.UserId = userId
+	template.ClientStats = nil
+	if template.Listen == "" || template.Listen == "0.0.0.0" || template.Listen == "::" || template.Listen == "::0" {
+		template.Tag = fmt.Sprintf("inbound-%v", template.Port)
+	} else {
+		template.Tag = fmt.Sprintf("inbound-%v:%v", template.Listen, template.Port)
+	}
+	if err := ValidateInbound(&template); err != nil {
+		return nil, err
+	}
+
+	requestData, err := json.Marshal(&template)
+	if err != nil {
+		return nil, err
+	}
+
+	db := database.GetDB()
+	results := make([]*InboundDeployResult, len(servers))
+	tasks := make([]*model.ServerTask, len(servers))
+	for i, server := range servers {
+		task := &model.ServerTask{
+			ServerId:    server.Id,
+			Operation:   InboundDeployOperation,
+			Status:      "pending",
+			RequestData: string(redact.JSON(requestData)),
+			UserId:      userId,
+		}
+		if err := db.Omit("Server").Create(task).Error; err != nil {
+			return nil, err
+		}
+		publishTaskEvent(task, task.Status, "")
+		tasks[i] = task
+		results[i] = &InboundDeployResult{ServerId: server.Id, ServerName: server.Name, TaskId: task.Id}
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(task *model.ServerTask, result *InboundDeployResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			inbound := template
+			s.runTask(task, &inbound, result)
+		}(tasks[i], results[i])
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+	logger.Infof("Inbound %s deployed to %d servers: %d succeeded, %d failed", template.Tag, len(results), len(results)-failed, failed)
+	return results, nil
+}
+
+// resolveTargets returns the enabled servers selected by req.
+func (s *InboundDeployService) resolveTargets(req *InboundDeployRequest) ([]*model.Server, error) {
+	filter := ParseServerFilter(req.Tags, req.Region, req.Scope)
+	selectors := 0
+	for _, set := range []bool{len(req.ServerIds) > 0, req.Group != "", !filter.IsEmpty()} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return nil, fmt.Errorf("select servers by ID, by group or by labels, not several")
+	}
+	if selectors == 0 {
+		return nil, fmt.Errorf("no target servers selected")
+	}
+	if req.Group != "" {
+		return s.groups.ResolveGroup(req.Group)
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+	if len(req.ServerIds) == 0 {
+		servers, err = s.serverMgmt.FilterServers(servers, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("no enabled servers match the selector")
+		}
+		return servers, nil
+	}
+
+	selected := make([]*model.Server, 0, len(req.ServerIds))
+	seen := make(map[int]bool, len(req.ServerIds))
+	for _, id := range req.ServerIds {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		found := false
+		for _, server := range servers {
+			if server.Id == id {
+				selected = append(selected, server)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("server %d not found or disabled", id)
+		}
+	}
+	return selected, nil
+}
+
+// runTask creates inbound on the server of task and restarts Xray there.
+func (s *InboundDeployService) runTask(task *model.ServerTask, inbound *model.Inbound, result *InboundDeployResult) {
+	db := database.GetDB()
+	task.Status = "running"
+	task.StartedAt = time.Now().Unix()
+	db.Model(task).Updates(map[string]any{"status": task.Status, "started_at": task.StartedAt})
+	publishTaskEvent(task, task.Status, "")
+
+	err := s.deployTo(task.ServerId, inbound)
+
+	task.CompletedAt = time.Now().Unix()
+	task.Status = "completed"
+	if err != nil {
+		task.Status = "failed"
+		task.ErrorMessage = err.Error()
+		result.Error = task.ErrorMessage
+		logger.Warningf("Deploying inbound %s to server %d failed: %v", inbound.Tag, task.ServerId, err)
+	} else {
+		result.Success = true
+	}
+
+	db.Model(task).Updates(map[string]any{
+		"status":        task.Status,
+		"completed_at":  task.CompletedAt,
+		"error_message": task.ErrorMessage,
+	})
+	publishTaskEvent(task, task.Status, task.ErrorMessage)
+}
+
+// deployTo validates inbound against a server's capabilities and creates it there.
+func (s *InboundDeployService) deployTo(serverId int, inbound *model.Inbound) error {
+	ctx, cancel := context.WithTimeout(context.Background(), inboundDeployTimeout)
+	defer cancel()
+
+	inbound.ServerId = serverId
+	if err := s.tunnel.ResolveTarget(ctx, inbound); err != nil {
+		return err
+	}
+	if err := s.serverMgmt.CheckInboundCapabilities(ctx, serverId, inbound); err != nil {
+		return err
+	}
+
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return err
+	}
+	if err := connector.AddInbound(ctx, inbound); err != nil {
+		return err
+	}
+	if err := connector.RestartXray(ctx); err != nil {
+		// The inbound was created and is picked up by the next restart
+		logger.Warning("Failed to restart Xray on server", serverId, "after deploying inbound:", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Package service provides inbound ownership for resellers.
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+
+	"gorm.io/gorm"
+)
+
+// ResellerInbound is an inbound owned by a reseller, as shown through the reseller API.
+type ResellerInbound struct {
+	ServerId   int      `json:"serverId"`
+	ServerName string   `json:"serverName"`
+	Id         int      `json:"id"`
+	Remark     string   `json:"remark"`
+	Protocol   string   `json:"protocol"`
+	Port       int      `json:"port"`
+	Enable     bool     `json:"enable"`
+	Clients    []string `json:"clients"` // Client emails
+	Error      string   `json:"error,omitempty"`
+}
+
+// GetInboundOwners returns the inbound owners of a server (all servers if serverId is 0).
+func (s *ResellerService) GetInboundOwners(serverId int) ([]*model.InboundOwner, error) {
+	db := database.GetDB().Model(model.InboundOwner{})
+	if serverId != 0 {
+		db = db.Where("server_id = ?", serverId)
+	}
+	var owners []*model.InboundOwner
+	err := db.Order("server_id, inbound_id").Find(&owners).Error
+	return owners, err
+}
+
+// GetInboundOwner returns the reseller owning an inbound, or 0 if the admin owns it.
+func (s *ResellerService) GetInboundOwner(serverId, inboundId int) (int, error) {
+	owner := &model.InboundOwner{}
+	err := database.GetDB().Model(model.InboundOwner{}).
+		Where("server_id = ? AND inbound_id = ?", serverId, inboundId).
+		First(owner).Error
+	if database.IsNotFound(err) {
+		return 0, nil
+	}
+	return owner.ResellerId, err
+}
+
+// MarkInboundOwners sets ResellerId on the inbounds of a server that are owned by a reseller.
+func (s *ResellerService) MarkInboundOwners(serverId int, inbounds []*model.Inbound) error {
+	owners, err := s.GetInboundOwners(serverId)
+	if err != nil || len(owners) == 0 {
+		return err
+	}
+	byInbound := make(map[int]int, len(owners))
+	for _, owner := range owners {
+		byInbound[owner.InboundId] = owner.ResellerId
+	}
+	for _, inbound := range inbounds {
+		inbound.ResellerId = byInbound[inbound.Id]
+	}
+	return nil
+}
+
+// TransferInbound assigns an inbound and all its clients to a reseller, or back to the
+// admin if resellerId is 0. Clients move with the inbound and count against the new
+// owner's pool; the admin is not bound by pool limits when transferring.
+func (s *ResellerService) TransferInbound(ctx context.Context, serverId, inboundId, resellerId int) error {
+	if resellerId != 0 {
+		reseller, err := s.GetReseller(resellerId)
+		if err != nil {
+			return err
+		}
+		if !s.isServerPermitted(reseller, serverId) {
+			return fmt.Errorf("server %d is not permitted for reseller %s", serverId, reseller.Username)
+		}
+	}
+
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return err
+	}
+	inbound, err := connector.GetInbound(ctx, inboundId)
+	if err != nil {
+		return err
+	}
+	clients, err := s.inboundService.GetClients(inbound)
+	if err != nil {
+		return err
+	}
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if resellerId == 0 {
+			if err := tx.Where("server_id = ? AND inbound_id = ?", serverId, inboundId).Delete(model.InboundOwner{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("server_id = ? AND inbound_id = ?", serverId, inboundId).Delete(model.ResellerClient{}).Error
+		}
+
+		owner := &model.InboundOwner{}
+		err := tx.Model(model.InboundOwner{}).Where("server_id = ? AND inbound_id = ?", serverId, inboundId).First(owner).Error
+		if err != nil && !database.IsNotFound(err) {
+			return err
+		}
+		owner.ServerId = serverId
+		owner.InboundId = inboundId
+		owner.ResellerId = resellerId
+		owner.AssignedAt = time.Now().Unix()
+		if err := tx.Save(owner).Error; err != nil {
+			return err
+		}
+
+		// Clients created by the previous owner move with the inbound
+		err = tx.Model(model.ResellerClient{}).
+			Where("server_id = ? AND inbound_id = ?", serverId, inboundId).
+			Update("reseller_id", resellerId).Error
+		if err != nil {
+			return err
+		}
+
+		// Clients the admin created become the reseller's too
+		for _, client := range clients {
+			var count int64
+			err := tx.Model(model.ResellerClient{}).
+				Where("server_id = ? AND email = ?", serverId, client.Email).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			record := &model.ResellerClient{
+				ResellerId: resellerId,
+				ServerId:   serverId,
+				InboundId:  inboundId,
+				Email:      client.Email,
+				SubId:      client.SubID,
+				Total:      client.TotalGB,
+			}
+			if err := tx.Create(record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseInbound forgets the owner and reseller clients of a deleted inbound.
+func (s *ResellerService) ReleaseInbound(serverId, inboundId int) error {
+	db := database.GetDB()
+	if err := db.Where("server_id = ? AND inbound_id = ?", serverId, inboundId).Delete(model.InboundOwner{}).Error; err != nil {
+		return err
+	}
+	return db.Where("server_id = ? AND inbound_id = ?", serverId, inboundId).Delete(model.ResellerClient{}).Error
+}
+
+// GetOwnedInbounds returns the inbounds owned by the reseller with their client emails.
+// Servers that cannot be reached are reported with their error.
+func (s *ResellerService) GetOwnedInbounds(ctx context.Context, reseller *model.Reseller) ([]*ResellerInbound, error) {
+	var owned []*model.InboundOwner
+	err := database.GetDB().Model(model.InboundOwner{}).
+		Where("reseller_id = ?", reseller.Id).
+		Order("server_id, inbound_id").
+		Find(&owned).Error
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := s.GetPermittedServers(reseller)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(servers))
+	for _, server := range servers {
+		names[server.Id] = server.Name
+	}
+
+	result := make([]*ResellerInbound, 0, len(owned))
+	inboundsByServer := make(map[int][]*model.Inbound)
+	errorsByServer := make(map[int]error)
+	for _, owner := range owned {
+		name, permitted := names[owner.ServerId]
+		if !permitted {
+			// Servers the reseller lost access to are hidden along with their inbounds
+			continue
+		}
+		entry := &ResellerInbound{ServerId: owner.ServerId, ServerName: name, Id: owner.InboundId, Clients: make([]string, 0)}
+		result = append(result, entry)
+
+		inbounds, fetched := inboundsByServer[owner.ServerId]
+		if !fetched && errorsByServer[owner.ServerId] == nil {
+			connector, err := s.serverMgmt.GetConnector(owner.ServerId)
+			if err == nil {
+				inbounds, err = connector.ListInbounds(ctx)
+			}
+			if err != nil {
+				errorsByServer[owner.ServerId] = err
+			} else {
+				inboundsByServer[owner.ServerId] = inbounds
+			}
+		}
+		if err := errorsByServer[owner.ServerId]; err != nil {
+			entry.Error = err.Error()
+			continue
+		}
+
+		for _, inbound := range inbounds {
+			if inbound.Id != owner.InboundId {
+				continue
+			}
+			entry.Remark = inbound.Remark
+			entry.Protocol = string(inbound.Protocol)
+			entry.Port = inbound.Port
+			entry.Enable = inbound.Enable
+			if clients, err := s.inboundService.GetClients(inbound); err == nil {
+				for _, client := range clients {
+					entry.Clients = append(entry.Clients, client.Email)
+				}
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestClaimInboundVersion checks that only one write can claim a given inbound version.
+func TestClaimInboundVersion(t *testing.T) {
+	db := database.GetDB()
+	inbound := &model.Inbound{
+		Protocol: model.VLESS,
+		Port:     20201,
+		Tag:      "inbound-version-test",
+		Settings: `{"clients":[]}`,
+	}
+	if err := db.Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Delete(&model.Inbound{}, inbound.Id)
+
+	if err := service.ClaimInboundVersion(db, inbound.Id, inbound.Version); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	err := service.ClaimInboundVersion(db, inbound.Id, inbound.Version)
+	var conflict *service.InboundConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("second claim: expected a conflict, got %v", err)
+	}
+	if conflict.Current.Version != inbound.Version+1 {
+		t.Fatalf("expected current version %d, got %d", inbound.Version+1, conflict.Current.Version)
+	}
+}
+
+// TestResetInboundTraffic checks that the inbound reset used by the agents keeps the usage
+// in all_time and records when it happened.
+func TestResetInboundTraffic(t *testing.T) {
+	db := database.GetDB()
+	inbound := &model.Inbound{
+		Protocol: model.VLESS,
+		Port:     20202,
+		Tag:      "inbound-reset-test",
+		Up:       100,
+		Down:     200,
+		Settings: `{"clients":[]}`,
+	}
+	if err := db.Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Delete(&model.Inbound{}, inbound.Id)
+
+	before := time.Now().Unix() * 1000
+	if err := (&service.InboundService{}).ResetInboundTraffic(inbound.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	reset := &model.Inbound{}
+	if err := db.First(reset, inbound.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reset.Up != 0 || reset.Down != 0 || reset.AllTime != 300 {
+		t.Errorf("expected the counters to move to all_time, got up=%d down=%d allTime=%d", reset.Up, reset.Down, reset.AllTime)
+	}
+	if reset.LastTrafficResetTime < before {
+		t.Errorf("expected the reset time to be recorded, got %d", reset.LastTrafficResetTime)
+	}
+}
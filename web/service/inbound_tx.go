@@ -0,0 +1,76 @@
+// Package service provides helpers keeping inbound database transactions and the running Xray in step.
+package service
+
+import (
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+
+	"gorm.io/gorm"
+)
+
+// xrayUndo records the inverse of every change applied to the running Xray while a
+// database transaction is open, so the changes can be reverted if the transaction
+// does not commit.
+type xrayUndo struct {
+	steps []func(s *InboundService) error
+}
+
+// add records a step that reverts one applied change.
+func (u *xrayUndo) add(step func(s *InboundService) error) {
+	u.steps = append(u.steps, step)
+}
+
+// run reverts the recorded changes, newest first, and reports whether all of them were
+// reverted. There is nothing to revert if Xray is not running.
+func (u *xrayUndo) run(s *InboundService) bool {
+	if len(u.steps) == 0 || p == nil || !p.IsRunning() {
+		return true
+	}
+	if err := s.xrayApi.Init(p.GetAPIPort()); err != nil {
+		logger.Warning("Unable to revert Xray changes:", err)
+		return false
+	}
+	defer s.xrayApi.Close()
+
+	reverted := true
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		if err := u.steps[i](s); err != nil {
+			logger.Warning("Unable to revert Xray change:", err)
+			reverted = false
+		}
+	}
+	return reverted
+}
+
+// readdUserStep returns an undo step adding a client, given as its settings JSON object,
+// back to an inbound in the running Xray.
+func readdUserStep(inbound *model.Inbound, client map[string]any, cipher string) func(s *InboundService) error {
+	user := map[string]any{"cipher": cipher}
+	for _, key := range []string{"email", "id", "security", "flow", "password"} {
+		value, _ := client[key].(string)
+		user[key] = value
+	}
+	protocol, tag := string(inbound.Protocol), inbound.Tag
+	return func(s *InboundService) error {
+		return s.xrayApi.AddUser(protocol, tag, user)
+	}
+}
+
+// finishInboundTx ends a transaction that went along with changes to the running Xray.
+// It commits if err is nil; otherwise, or if the commit fails, it rolls back and reverts
+// the Xray changes in undo so the database and Xray stay consistent. It returns the
+// resulting error.
+func (s *InboundService) finishInboundTx(tx *gorm.DB, err error, undo *xrayUndo) error {
+	if err == nil {
+		if err = tx.Commit().Error; err == nil {
+			return nil
+		}
+	} else {
+		tx.Rollback()
+	}
+	if !undo.run(s) {
+		// A restart rebuilds the Xray config from the database
+		(&XrayService{}).SetToNeedRestart()
+	}
+	return err
+}
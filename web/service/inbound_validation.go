@@ -0,0 +1,350 @@
+// Package service provides structural validation of inbound settings before they reach Xray.
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/google/uuid"
+)
+
+// FieldError describes one invalid field of a request.
+// Field is a dotted path such as "settings.clients[0].id"; Code is one of the Field*
+// constants, so clients can react to an error without parsing its message.
+type FieldError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// InboundValidationError collects all field errors found in an inbound.
+type InboundValidationError struct {
+	Fields []*FieldError `json:"fields"`
+}
+
+func (e *InboundValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid inbound: " + strings.Join(msgs, "; ")
+}
+
+// inboundValidator accumulates field errors while walking an inbound.
+type inboundValidator struct {
+	errs []*FieldError
+}
+
+func (v *inboundValidator) add(field string, format string, args ...any) {
+	code := FieldInvalid
+	switch {
+	case strings.HasPrefix(format, "is required"), strings.HasPrefix(format, "at least one"):
+		code = FieldRequired
+	case strings.HasPrefix(format, "must be between"):
+		code = FieldOutOfRange
+	case strings.HasPrefix(format, "duplicate"):
+		code = FieldDuplicate
+	case strings.HasPrefix(format, "must be a JSON"), strings.HasPrefix(format, "must be an array"),
+		strings.HasPrefix(format, "must be an object"):
+		code = FieldType
+	}
+	v.errs = append(v.errs, &FieldError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// streamNetworks lists the transports Xray accepts in streamSettings.network.
+var streamNetworks = map[string]bool{
+	"tcp": true, "raw": true, "kcp": true, "ws": true, "grpc": true, "httpupgrade": true, "xhttp": true,
+}
+
+// realityNetworks lists the transports REALITY can be used with.
+var realityNetworks = map[string]bool{"tcp": true, "raw": true, "grpc": true, "xhttp": true}
+
+// ValidateInbound checks the Settings, StreamSettings and Sniffing JSON of an inbound
+// against the schema of its protocol. It returns an *InboundValidationError listing
+// every invalid field, or nil if the inbound can be written to the Xray config.
+func ValidateInbound(inbound *model.Inbound) error {
+	v := &inboundValidator{}
+
+	if inbound.Port < 1 || inbound.Port > 65535 {
+		v.add("port", "must be between 1 and 65535")
+	}
+	if inbound.Listen != "" && net.ParseIP(inbound.Listen) == nil &&
+		!strings.HasPrefix(inbound.Listen, "/") && !strings.HasPrefix(inbound.Listen, "@") {
+		v.add("listen", "must be an IP address or a unix socket path")
+	}
+	if inbound.AddressOverride != "" && !validAddressOverride(inbound.AddressOverride) {
+		v.add("addressOverride", "must be a hostname or an IP address")
+	}
+	if inbound.SpeedLimitUp < 0 {
+		v.add("speedLimitUp", "must not be negative")
+	}
+	if inbound.SpeedLimitDown < 0 {
+		v.add("speedLimitDown", "must not be negative")
+	}
+
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil || settings == nil {
+		v.add("settings", "must be a JSON object")
+	} else {
+		v.validateSettings(inbound.Protocol, settings)
+	}
+
+	network, security := "tcp", "none"
+	if inbound.StreamSettings != "" {
+		var stream map[string]any
+		if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil || stream == nil {
+			v.add("streamSettings", "must be a JSON object")
+		} else {
+			network, security = v.validateStream(stream)
+		}
+	}
+
+	if inbound.Sniffing != "" {
+		var sniffing map[string]any
+		if err := json.Unmarshal([]byte(inbound.Sniffing), &sniffing); err != nil {
+			v.add("sniffing", "must be a JSON object")
+		}
+	}
+
+	if inbound.Protocol == model.VLESS && settings != nil {
+		clients, _ := settings["clients"].([]any)
+		for i, c := range clients {
+			client, _ := c.(map[string]any)
+			flow, _ := client["flow"].(string)
+			if flow == "" {
+				continue
+			}
+			if (network != "tcp" && network != "raw") || (security != "tls" && security != "reality") {
+				v.add(fmt.Sprintf("settings.clients[%d].flow", i), "%s requires tcp with tls or reality", flow)
+			}
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return &InboundValidationError{Fields: v.errs}
+	}
+	return nil
+}
+
+// validateSettings checks the protocol-specific settings object.
+func (v *inboundValidator) validateSettings(protocol model.Protocol, settings map[string]any) {
+	switch protocol {
+	case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+		v.validateClients(protocol, settings)
+	case model.Tunnel:
+		if port, ok := settings["port"].(float64); ok && (port < 0 || port > 65535) {
+			v.add("settings.port", "must be between 0 and 65535")
+		}
+		address, _ := settings["address"].(string)
+		if followRedirect, _ := settings["followRedirect"].(bool); address == "" && !followRedirect {
+			v.add("settings.address", "is required unless followRedirect is set")
+		}
+	case model.HTTP, model.Mixed:
+		if protocol == model.Mixed {
+			if auth, _ := settings["auth"].(string); auth != "password" {
+				return
+			}
+		}
+		accounts, _ := settings["accounts"].([]any)
+		for i, a := range accounts {
+			account, _ := a.(map[string]any)
+			if user, _ := account["user"].(string); user == "" {
+				v.add(fmt.Sprintf("settings.accounts[%d].user", i), "is required")
+			}
+			if pass, _ := account["pass"].(string); pass == "" {
+				v.add(fmt.Sprintf("settings.accounts[%d].pass", i), "is required")
+			}
+		}
+	case model.WireGuard:
+		if key, _ := settings["secretKey"].(string); key == "" {
+			v.add("settings.secretKey", "is required")
+		}
+		if mtu, ok := settings["mtu"].(float64); ok && (mtu < 576 || mtu > 65535) {
+			v.add("settings.mtu", "must be between 576 and 65535")
+		}
+		peers, _ := settings["peers"].([]any)
+		for i, p := range peers {
+			peer, _ := p.(map[string]any)
+			if key, _ := peer["publicKey"].(string); key == "" {
+				v.add(fmt.Sprintf("settings.peers[%d].publicKey", i), "is required")
+			}
+			allowedIPs, _ := peer["allowedIPs"].([]any)
+			for j, a := range allowedIPs {
+				if cidr, _ := a.(string); cidr != "" {
+					if _, _, err := net.ParseCIDR(cidr); err != nil {
+						v.add(fmt.Sprintf("settings.peers[%d].allowedIPs[%d]", i, j), "must be a CIDR")
+					}
+				}
+			}
+		}
+	}
+}
+
+// validateClients checks the client list of multi-user protocols.
+func (v *inboundValidator) validateClients(protocol model.Protocol, settings map[string]any) {
+	if protocol == model.Shadowsocks {
+		if method, _ := settings["method"].(string); method == "" {
+			v.add("settings.method", "is required")
+		}
+	}
+
+	if level, ok := settings["policyLevel"]; ok && !validPolicyLevel(level) {
+		v.add("settings.policyLevel", "must be a non-negative whole number")
+	}
+
+	raw, ok := settings["clients"]
+	if !ok {
+		if protocol != model.Shadowsocks {
+			v.add("settings.clients", "is required")
+		}
+		return
+	}
+	clients, ok := raw.([]any)
+	if !ok {
+		v.add("settings.clients", "must be an array")
+		return
+	}
+
+	emails := make(map[string]bool, len(clients))
+	for i, c := range clients {
+		field := fmt.Sprintf("settings.clients[%d]", i)
+		client, ok := c.(map[string]any)
+		if !ok {
+			v.add(field, "must be an object")
+			continue
+		}
+
+		email, _ := client["email"].(string)
+		if email == "" {
+			v.add(field+".email", "is required")
+		} else if emails[strings.ToLower(email)] {
+			v.add(field+".email", "duplicate email %q", email)
+		}
+		emails[strings.ToLower(email)] = true
+
+		switch protocol {
+		case model.VMESS, model.VLESS:
+			// Xray maps short non-UUID strings (1-30 bytes) to a UUID
+			id, _ := client["id"].(string)
+			if _, err := uuid.Parse(id); err != nil && (id == "" || len(id) > 30) {
+				v.add(field+".id", "must be a UUID or a string of 1-30 bytes")
+			}
+		case model.Trojan:
+			if password, _ := client["password"].(string); password == "" {
+				v.add(field+".password", "is required")
+			}
+		case model.Shadowsocks:
+			method, _ := settings["method"].(string)
+			if password, _ := client["password"].(string); password == "" && strings.HasPrefix(method, "2022-") {
+				v.add(field+".password", "is required for %s", method)
+			}
+		}
+
+		if limit, ok := client["limitIp"].(float64); ok && limit < 0 {
+			v.add(field+".limitIp", "must not be negative")
+		}
+		if total, ok := client["totalGB"].(float64); ok && total < 0 {
+			v.add(field+".totalGB", "must not be negative")
+		}
+		if level, ok := client["level"]; ok && !validPolicyLevel(level) {
+			v.add(field+".level", "must be a non-negative whole number")
+		}
+		if limit, ok := client["speedLimitUp"].(float64); ok && limit < 0 {
+			v.add(field+".speedLimitUp", "must not be negative")
+		}
+		if limit, ok := client["speedLimitDown"].(float64); ok && limit < 0 {
+			v.add(field+".speedLimitDown", "must not be negative")
+		}
+	}
+}
+
+// validPolicyLevel reports whether value can be used as an Xray policy level.
+func validPolicyLevel(value any) bool {
+	level, ok := value.(float64)
+	return ok && level >= 0 && level == float64(uint32(level))
+}
+
+// validateStream checks streamSettings and returns the effective network and security.
+func (v *inboundValidator) validateStream(stream map[string]any) (string, string) {
+	network, _ := stream["network"].(string)
+	if network == "" {
+		network = "tcp"
+	}
+	if !streamNetworks[network] {
+		v.add("streamSettings.network", "unsupported network %q", network)
+	}
+
+	security, _ := stream["security"].(string)
+	if security == "" {
+		security = "none"
+	}
+	switch security {
+	case "none":
+	case "tls":
+		if network == "kcp" {
+			v.add("streamSettings.security", "tls cannot be used with kcp")
+		}
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		certs, _ := tlsSettings["certificates"].([]any)
+		if len(certs) == 0 {
+			v.add("streamSettings.tlsSettings.certificates", "at least one certificate is required")
+		}
+		for i, c := range certs {
+			cert, _ := c.(map[string]any)
+			field := fmt.Sprintf("streamSettings.tlsSettings.certificates[%d]", i)
+			certFile, _ := cert["certificateFile"].(string)
+			keyFile, _ := cert["keyFile"].(string)
+			inline := cert["certificate"] != nil && cert["key"] != nil
+			if !inline && (certFile == "" || keyFile == "") {
+				v.add(field, "needs certificateFile and keyFile, or inline certificate and key")
+			}
+		}
+	case "reality":
+		if !realityNetworks[network] {
+			v.add("streamSettings.security", "reality cannot be used with %s", network)
+		}
+		reality, _ := stream["realitySettings"].(map[string]any)
+		if key, _ := reality["privateKey"].(string); key == "" {
+			v.add("streamSettings.realitySettings.privateKey", "is required")
+		}
+		if names, _ := reality["serverNames"].([]any); len(names) == 0 {
+			v.add("streamSettings.realitySettings.serverNames", "at least one server name is required")
+		}
+		target, _ := reality["target"].(string)
+		if target == "" {
+			target, _ = reality["dest"].(string)
+		}
+		if target == "" {
+			v.add("streamSettings.realitySettings.target", "is required")
+		}
+	default:
+		v.add("streamSettings.security", "unsupported security %q", security)
+	}
+
+	return network, security
+}
+
+// validAddressOverride reports whether address is an IP address or a DNS hostname,
+// without scheme or port, as published in share links.
+func validAddressOverride(address string) bool {
+	if net.ParseIP(address) != nil {
+		return true
+	}
+	if len(address) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(address, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
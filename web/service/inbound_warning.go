@@ -0,0 +1,95 @@
+// Package service provides InboundWarningService for flagging inbounds and clients close to their limits.
+package service
+
+import (
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// Warnings attached to inbounds, clients and client search results.
+const (
+	WarningNearQuota       = "nearQuota"       // Traffic used is at or above warnQuotaPercent of the limit
+	WarningExpiringSoon    = "expiringSoon"    // Expires within warnExpiryDays
+	WarningConfigDrift     = "configDrift"     // The server's config epoch differed at the last health check
+	WarningPortUnreachable = "portUnreachable" // The port could not be connected to by the port probe
+)
+
+// InboundWarningService flags inbounds and clients that are about to hit their traffic
+// limit or expire, and inbounds whose server or port has a problem, so listings can
+// show them before users notice. Warnings are computed on each listing and not stored.
+type InboundWarningService struct {
+	settingService SettingService
+}
+
+// warningThresholds are the soft limits warnings are computed against.
+type warningThresholds struct {
+	quotaPercent int   // Negative disables nearQuota
+	expiresBy    int64 // Unix milliseconds, 0 disables expiringSoon
+	now          int64 // Unix milliseconds
+}
+
+// thresholds loads the soft limits from the settings.
+func (s *InboundWarningService) thresholds() warningThresholds {
+	quotaPercent, err := s.settingService.GetWarnQuotaPercent()
+	if err != nil {
+		logger.Warning("Failed to get warnQuotaPercent:", err)
+		quotaPercent = -1
+	}
+	days, err := s.settingService.GetWarnExpiryDays()
+	if err != nil {
+		logger.Warning("Failed to get warnExpiryDays:", err)
+	}
+	now := time.Now().UnixMilli()
+	t := warningThresholds{quotaPercent: quotaPercent, now: now}
+	if days > 0 {
+		t.expiresBy = now + int64(days)*int64(24*time.Hour/time.Millisecond)
+	}
+	return t
+}
+
+// limitWarnings returns the soft limit warnings for a traffic limit and expiry time.
+func (t warningThresholds) limitWarnings(up, down, total, expiryTime int64) []string {
+	var warnings []string
+	if t.quotaPercent >= 0 && total > 0 {
+		used := up + down
+		if used < total && used*100 >= total*int64(t.quotaPercent) {
+			warnings = append(warnings, WarningNearQuota)
+		}
+	}
+	// Negative expiry times count from the first connection and have not started yet
+	if t.expiresBy > 0 && expiryTime > t.now && expiryTime <= t.expiresBy {
+		warnings = append(warnings, WarningExpiringSoon)
+	}
+	return warnings
+}
+
+// Annotate sets the Warnings of inbounds of a server and of their client stats. server
+// may be nil if it could not be loaded, which skips the server warnings.
+func (s *InboundWarningService) Annotate(server *model.Server, inbounds []*model.Inbound) {
+	t := s.thresholds()
+	for _, inbound := range inbounds {
+		inbound.Warnings = t.limitWarnings(inbound.Up, inbound.Down, inbound.Total, inbound.ExpiryTime)
+		if server != nil {
+			if server.ConfigOutOfSync {
+				inbound.Warnings = append(inbound.Warnings, WarningConfigDrift)
+			}
+			if inbound.Enable && portUnreachable(server.Id, inbound.Port) {
+				inbound.Warnings = append(inbound.Warnings, WarningPortUnreachable)
+			}
+		}
+		for i := range inbound.ClientStats {
+			stat := &inbound.ClientStats[i]
+			stat.Warnings = t.limitWarnings(stat.Up, stat.Down, stat.Total, stat.ExpiryTime)
+		}
+	}
+}
+
+// AnnotateClients sets the Warnings of client search results.
+func (s *InboundWarningService) AnnotateClients(matches []*ClientMatch) {
+	t := s.thresholds()
+	for _, match := range matches {
+		match.Warnings = t.limitWarnings(match.Up, match.Down, match.TotalGB, match.ExpiryTime)
+	}
+}
@@ -0,0 +1,203 @@
+// Package service provides IncidentService for recording server outages.
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// IncidentQuery selects incidents for the incident list.
+type IncidentQuery struct {
+	ServerId int   // 0 = all servers
+	OpenOnly bool  // Only incidents that have not closed yet
+	From     int64 // Unix seconds, incidents still open at or ending after this time
+	To       int64 // Unix seconds, incidents started up to this time, 0 = now
+	Limit    int   // Default 100, at most 1000
+}
+
+// IncidentService opens an incident when a server goes offline or into error, closes it
+// when the server recovers and notifies admins and webhooks about both.
+type IncidentService struct {
+	settingService SettingService
+	tgbotService   Tgbot
+	webhookService WebhookService
+}
+
+// GetIncidents returns the incidents matching query, newest first, with their server names.
+func (s *IncidentService) GetIncidents(query *IncidentQuery) ([]*model.Incident, error) {
+	if query.Limit <= 0 || query.Limit > 1000 {
+		query.Limit = 100
+	}
+
+	db := database.GetDB().Model(model.Incident{})
+	if query.ServerId != 0 {
+		db = db.Where("server_id = ?", query.ServerId)
+	}
+	if query.OpenOnly {
+		db = db.Where("ended_at = 0")
+	}
+	if query.From > 0 {
+		db = db.Where("ended_at = 0 OR ended_at >= ?", query.From)
+	}
+	if query.To > 0 {
+		db = db.Where("started_at <= ?", query.To)
+	}
+
+	var incidents []*model.Incident
+	if err := db.Order("started_at DESC, id DESC").Limit(query.Limit).Find(&incidents).Error; err != nil {
+		return nil, err
+	}
+	s.setServerNames(incidents...)
+	return incidents, nil
+}
+
+// GetIncident returns one incident with its server name.
+func (s *IncidentService) GetIncident(id int) (*model.Incident, error) {
+	incident := &model.Incident{}
+	if err := database.GetDB().Model(model.Incident{}).Where("id = ?", id).First(incident).Error; err != nil {
+		return nil, err
+	}
+	s.setServerNames(incident)
+	return incident, nil
+}
+
+// trackStatus opens or closes the incident of a server after its status changed from
+// previous to status. Failures are only logged so the status update is not affected.
+func (s *IncidentService) trackStatus(serverId int, previous, status, cause string) {
+	var err error
+	switch {
+	case status == "offline" || status == "error":
+		err = s.open(serverId, status, cause)
+	case status == "online" && (previous == "offline" || previous == "error"):
+		err = s.close(serverId)
+	}
+	if err != nil {
+		logger.Warning("Failed to track incident of server", serverId, ":", err)
+	}
+}
+
+// open starts an incident unless the server already has an open one.
+func (s *IncidentService) open(serverId int, status, cause string) error {
+	db := database.GetDB()
+	var open int64
+	if err := db.Model(model.Incident{}).Where("server_id = ? AND ended_at = 0", serverId).Count(&open).Error; err != nil {
+		return err
+	}
+	if open > 0 {
+		return nil
+	}
+
+	incident := &model.Incident{
+		ServerId:  serverId,
+		Status:    status,
+		Cause:     cause,
+		StartedAt: time.Now().Unix(),
+	}
+	if err := db.Create(incident).Error; err != nil {
+		return err
+	}
+	s.setServerNames(incident)
+	s.notify(incident)
+	s.webhookService.Emit(WebhookServerOffline, map[string]any{
+		"serverId":   incident.ServerId,
+		"serverName": incident.ServerName,
+		"status":     incident.Status,
+		"cause":      incident.Cause,
+		"incidentId": incident.Id,
+	})
+	return nil
+}
+
+// close ends the open incident of a server, if any.
+func (s *IncidentService) close(serverId int) error {
+	db := database.GetDB()
+	incident := &model.Incident{}
+	err := db.Model(model.Incident{}).Where("server_id = ? AND ended_at = 0", serverId).Order("id DESC").First(incident).Error
+	if database.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	incident.EndedAt = time.Now().Unix()
+	incident.Duration = incident.EndedAt - incident.StartedAt
+	if err := db.Model(incident).Updates(map[string]any{"ended_at": incident.EndedAt, "duration": incident.Duration}).Error; err != nil {
+		return err
+	}
+	s.setServerNames(incident)
+	s.notify(incident)
+	s.webhookService.Emit(WebhookServerOnline, map[string]any{
+		"serverId":   incident.ServerId,
+		"serverName": incident.ServerName,
+		"incidentId": incident.Id,
+		"downtime":   incident.Duration,
+	})
+	return nil
+}
+
+// notify tells admins on Telegram that an incident opened or closed, with a link to it.
+func (s *IncidentService) notify(incident *model.Incident) {
+	enabled, err := s.settingService.GetTgBotIncidentNotify()
+	if err != nil || !enabled || !s.tgbotService.IsRunning() {
+		return
+	}
+
+	var msg string
+	if incident.EndedAt == 0 {
+		msg = s.tgbotService.I18nBot("tgbot.messages.incidentOpened",
+			"Server=="+incident.ServerName,
+			"Id=="+strconv.Itoa(incident.Id),
+			"Cause=="+incident.Cause)
+	} else {
+		msg = s.tgbotService.I18nBot("tgbot.messages.incidentClosed",
+			"Server=="+incident.ServerName,
+			"Id=="+strconv.Itoa(incident.Id),
+			"Duration=="+(time.Duration(incident.Duration)*time.Second).String())
+	}
+	if link := s.incidentURL(incident.Id); link != "" {
+		msg += "\r\n" + link
+	}
+	s.tgbotService.SendMsgToTgbotAdmins(msg)
+}
+
+// incidentURL returns the panel API URL of an incident, or "" if the panel domain is not set.
+func (s *IncidentService) incidentURL(id int) string {
+	domain, err := s.settingService.GetWebDomain()
+	if err != nil || domain == "" {
+		return ""
+	}
+	port, _ := s.settingService.GetPort()
+	basePath, _ := s.settingService.GetBasePath()
+	certFile, _ := s.settingService.GetCertFile()
+
+	scheme := "http"
+	if certFile != "" {
+		scheme = "https"
+	}
+	host := domain
+	if !(scheme == "https" && port == 443) && !(scheme == "http" && port == 80) {
+		host = fmt.Sprintf("%s:%d", domain, port)
+	}
+	return fmt.Sprintf("%s://%s%spanel/api/servers/incidents/%d", scheme, host, basePath, id)
+}
+
+// setServerNames attaches the name of the owning server to incidents.
+func (s *IncidentService) setServerNames(incidents ...*model.Incident) {
+	var servers []*model.Server
+	if err := database.GetDB().Model(model.Server{}).Select("id", "name").Find(&servers).Error; err != nil {
+		return
+	}
+	names := make(map[int]string, len(servers))
+	for _, server := range servers {
+		names[server.Id] = server.Name
+	}
+	for _, incident := range incidents {
+		incident.ServerName = names[incident.ServerId]
+	}
+}
@@ -0,0 +1,170 @@
+// Package service provides rotation of the JWT secrets shared with agents.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/secrets"
+)
+
+const (
+	// jwtRotationGrace is how long an agent keeps accepting the replaced secret.
+	jwtRotationGrace = 5 * time.Minute
+	// jwtRotationTimeout bounds the agent call and the auth data update of one rotation.
+	jwtRotationTimeout = 30 * time.Second
+)
+
+// ErrJWTNotRotatable is returned for servers whose auth data the panel cannot rotate:
+// other auth types, fixed tokens and secrets kept in an external secret store.
+var ErrJWTNotRotatable = errors.New("server auth data has no JWT secret the panel can rotate")
+
+// jwtAuthData is the JSON auth data of a JWT server after its first rotation.
+type jwtAuthData struct {
+	Secret    string `json:"secret"`
+	Audience  string `json:"audience,omitempty"`
+	TTL       int    `json:"ttl,omitempty"`       // Token lifetime in seconds
+	RotatedAt int64  `json:"rotatedAt,omitempty"` // Unix seconds of the last rotation
+}
+
+// parseRotatableJWT returns the JWT settings of decrypted auth data if it holds a secret
+// the panel owns. It accepts the same formats as createJWTClient.
+func parseRotatableJWT(raw string) (*jwtAuthData, error) {
+	var authData struct {
+		jwtAuthData
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(raw), &authData); err == nil && (authData.Token != "" || authData.Secret != "") {
+		if authData.Secret == "" || secrets.IsReference(authData.Secret) {
+			return nil, ErrJWTNotRotatable
+		}
+		return &authData.jwtAuthData, nil
+	}
+	if raw == "" || strings.Count(raw, ".") == 2 {
+		return nil, ErrJWTNotRotatable
+	}
+	return &jwtAuthData{Secret: raw}, nil
+}
+
+// RotateJWTSecret replaces the JWT secret of a server with a new random one. The agent
+// switches first and keeps the old secret for jwtRotationGrace; the new auth data is then
+// stored only if the server was not edited meanwhile, otherwise the agent is switched back.
+func (s *ServerManagementService) RotateJWTSecret(serverId int) error {
+	server, err := s.GetServer(serverId)
+	if err != nil {
+		return err
+	}
+	if server.AuthType != "jwt" || secrets.IsReference(server.AuthData) {
+		return ErrJWTNotRotatable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwtRotationTimeout)
+	defer cancel()
+	raw, err := openAuthData(ctx, server.AuthData)
+	if err != nil {
+		return fmt.Errorf("failed to load auth data: %w", err)
+	}
+	current, err := parseRotatableJWT(raw)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	next := *current
+	next.Secret = hex.EncodeToString(buf)
+	next.RotatedAt = time.Now().Unix()
+	encoded, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealAuthData(string(encoded))
+	if err != nil {
+		return err
+	}
+
+	connector, err := getPooledConnector(server)
+	if err != nil {
+		return fmt.Errorf("failed to create remote connector: %w", err)
+	}
+	if err := connector.RotateJWTSecret(ctx, next.Secret, jwtRotationGrace); err != nil {
+		return fmt.Errorf("agent rejected the new secret: %w", err)
+	}
+
+	// Compare-and-swap, so an edit saved while the agent was called is not overwritten
+	result := database.GetDB().Model(model.Server{}).
+		Where("id = ? AND auth_data = ?", serverId, server.AuthData).
+		Updates(map[string]any{"auth_data": sealed, "updated_at": time.Now().Unix()})
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = errors.New("server auth data changed during rotation")
+	}
+	if result.Error != nil {
+		// The connector still signs with the old secret, which the agent accepts during the grace period
+		if err := connector.RotateJWTSecret(ctx, current.Secret, jwtRotationGrace); err != nil {
+			logger.Errorf("JWT rotation of server %s: failed to restore the previous secret on the agent: %v", server.Name, err)
+		}
+		return fmt.Errorf("failed to store rotated secret: %w", result.Error)
+	}
+
+	evictConnector(serverId)
+	recordServerEvent(serverId, "config", "JWT secret rotated")
+	return nil
+}
+
+// RotateDueJWTSecrets rotates the JWT secrets of enabled online servers that were last
+// rotated (or added) more than maxAge ago, and returns how many were rotated.
+func (s *ServerManagementService) RotateDueJWTSecrets(maxAge time.Duration) (int, error) {
+	var servers []*model.Server
+	err := database.GetDB().Model(model.Server{}).
+		Where("auth_type = ? AND enabled = ? AND status = ?", "jwt", true, "online").
+		Find(&servers).Error
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	cutoff := time.Now().Add(-maxAge).Unix()
+	for _, server := range servers {
+		if secrets.IsReference(server.AuthData) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+		raw, err := openAuthData(ctx, server.AuthData)
+		cancel()
+		if err != nil {
+			logger.Warningf("JWT rotation of server %s: %v", server.Name, err)
+			continue
+		}
+		current, err := parseRotatableJWT(raw)
+		if err != nil {
+			continue
+		}
+		lastRotated := current.RotatedAt
+		if lastRotated == 0 {
+			lastRotated = server.CreatedAt
+		}
+		if lastRotated > cutoff {
+			continue
+		}
+
+		if err := s.RotateJWTSecret(server.Id); err != nil {
+			logger.Warningf("JWT rotation of server %s failed: %v", server.Name, err)
+			recordServerEvent(server.Id, "config", "JWT secret rotation failed: "+err.Error())
+			continue
+		}
+		logger.Infof("Rotated JWT secret of server %s", server.Name)
+		rotated++
+	}
+	return rotated, nil
+}
@@ -8,16 +8,35 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cofedish/3x-UI-agents/config"
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/util/sys"
 	"github.com/cofedish/3x-UI-agents/xray"
 	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/disk"
 	"github.com/shirou/gopsutil/v4/host"
 	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+// localNetSample is a reading of the interface counters of the local server.
+type localNetSample struct {
+	time time.Time
+	sent uint64
+	recv uint64
+}
+
+var (
+	// localNetMu guards localNetLast.
+	localNetMu sync.Mutex
+	// localNetLast is the reading taken by the previous GetSystemStats call.
+	localNetLast *localNetSample
 )
 
 // LocalConnector implements ServerConnector for the local Xray instance.
@@ -27,6 +46,7 @@ type LocalConnector struct {
 	inboundService *InboundService
 	xrayService    *XrayService
 	serverService  *ServerService
+	publicIP       PublicIPService
 }
 
 // NewLocalConnector creates a new LocalConnector instance.
@@ -79,6 +99,7 @@ func (c *LocalConnector) GetHealth(ctx context.Context) (*HealthStatus, error) {
 		XrayRunning: isRunning,
 		Version:     config.GetVersion(),
 		XrayVersion: xrayVersion,
+		XrayError:   c.xrayService.GetXrayErrorMsg(),
 		Timestamp:   time.Now().Unix(),
 	}, nil
 }
@@ -180,16 +201,7 @@ func (c *LocalConnector) UpdateClient(ctx context.Context, inbound *model.Inboun
 		return fmt.Errorf("client index %d out of range", clientIndex)
 	}
 
-	// Determine client ID based on protocol
-	var clientId string
-	switch existingInbound.Protocol {
-	case "trojan":
-		clientId = clients[clientIndex].Password
-	case "shadowsocks":
-		clientId = clients[clientIndex].Email
-	default:
-		clientId = clients[clientIndex].ID
-	}
+	clientId := c.inboundService.GetClientId(existingInbound.Protocol, clients[clientIndex])
 
 	// Delegate to inbound service (ignore needRestart return value)
 	_, err = c.inboundService.UpdateInboundClient(inbound, clientId)
@@ -222,6 +234,24 @@ func (c *LocalConnector) ResetClientTraffic(ctx context.Context, inboundId int,
 	return err
 }
 
+// ResetInboundTraffic resets the traffic of an inbound and all of its clients.
+func (c *LocalConnector) ResetInboundTraffic(ctx context.Context, inboundId int) error {
+	if _, err := c.GetInbound(ctx, inboundId); err != nil {
+		return err
+	}
+
+	if err := c.inboundService.ResetInboundTraffic(inboundId); err != nil {
+		return err
+	}
+	if err := c.inboundService.ResetAllClientTraffics(inboundId); err != nil {
+		return err
+	}
+
+	// Clients disabled by depletion are enabled again and must be re-added to Xray
+	c.xrayService.SetToNeedRestart()
+	return nil
+}
+
 // GetOnlineClients returns list of currently online client emails.
 func (c *LocalConnector) GetOnlineClients(ctx context.Context) ([]string, error) {
 	// Delegate to inbound service (returns []string directly)
@@ -229,6 +259,15 @@ func (c *LocalConnector) GetOnlineClients(ctx context.Context) ([]string, error)
 	return emails, nil
 }
 
+// GetOnlineClientDetails returns online clients with source IPs and GeoIP data.
+func (c *LocalConnector) GetOnlineClientDetails(ctx context.Context) ([]*OnlineClient, error) {
+	clients := c.inboundService.GetOnlineClientDetails()
+	for _, client := range clients {
+		client.ServerId = c.serverId
+	}
+	return clients, nil
+}
+
 // GetTraffic retrieves current traffic statistics.
 func (c *LocalConnector) GetTraffic(ctx context.Context, reset bool) (*xray.Traffic, error) {
 	// Delegate to xray service
@@ -258,19 +297,58 @@ func (c *LocalConnector) GetClientTraffics(ctx context.Context) ([]*xray.ClientT
 	return traffics, nil
 }
 
+// GetClientTrafficsSince retrieves local client traffics updated since the cursor.
+func (c *LocalConnector) GetClientTrafficsSince(ctx context.Context, since int64) ([]*xray.ClientTraffic, int64, error) {
+	// Take the cursor before querying so rows changed meanwhile are fetched again next time
+	cursor := time.Now().Unix()
+	query := database.GetDB().Where("server_id = ?", c.serverId)
+	if since > 0 {
+		query = query.Where("updated_at >= ?", since)
+	}
+
+	var traffics []*xray.ClientTraffic
+	if err := query.Find(&traffics).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get client traffics: %w", err)
+	}
+	return traffics, cursor, nil
+}
+
+// GetOutboundTraffics retrieves traffic stats for all local outbounds.
+func (c *LocalConnector) GetOutboundTraffics(ctx context.Context) ([]*model.OutboundTraffics, error) {
+	outboundService := OutboundService{}
+	return outboundService.GetOutboundsTraffic()
+}
+
+// GetConnectionCounts returns the established TCP connections per local port.
+func (c *LocalConnector) GetConnectionCounts(ctx context.Context) (map[int]int, error) {
+	return sys.GetConnectionsByPort()
+}
+
 // StartXray starts the local Xray process.
 func (c *LocalConnector) StartXray(ctx context.Context) error {
-	return c.xrayService.RestartXray(true)
+	err := c.xrayService.RestartXray(true)
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray started")
+	}
+	return err
 }
 
 // StopXray stops the local Xray process.
 func (c *LocalConnector) StopXray(ctx context.Context) error {
-	return c.xrayService.StopXray()
+	err := c.xrayService.StopXray()
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray stopped")
+	}
+	return err
 }
 
 // RestartXray restarts the local Xray process.
 func (c *LocalConnector) RestartXray(ctx context.Context) error {
-	return c.xrayService.RestartXray(false)
+	err := c.xrayService.RestartXray(false)
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray restarted")
+	}
+	return err
 }
 
 // GetXrayVersion returns the installed Xray version.
@@ -295,6 +373,26 @@ func (c *LocalConnector) GetXrayConfig(ctx context.Context) (string, error) {
 	return string(configBytes), nil
 }
 
+// GetCapabilities reports the optional Xray features supported by the local core.
+func (c *LocalConnector) GetCapabilities(ctx context.Context) (*XrayCapabilities, error) {
+	return GetXrayCapabilities(c.xrayService.GetXrayVersion()), nil
+}
+
+// XrayAddUser adds a user to a running local inbound via the Xray API.
+func (c *LocalConnector) XrayAddUser(ctx context.Context, req *XrayUserRequest) error {
+	return c.xrayService.AddUserByApi(req.Protocol, req.InboundTag, req.User)
+}
+
+// XrayRemoveUser removes a user from a running local inbound via the Xray API.
+func (c *LocalConnector) XrayRemoveUser(ctx context.Context, inboundTag, email string) error {
+	return c.xrayService.RemoveUserByApi(inboundTag, email)
+}
+
+// XrayQueryStats queries local Xray counters via the Xray API.
+func (c *LocalConnector) XrayQueryStats(ctx context.Context, pattern string) (map[string]int64, error) {
+	return c.xrayService.QueryStatsByApi(pattern)
+}
+
 // GetSystemStats retrieves system resource usage statistics.
 func (c *LocalConnector) GetSystemStats(ctx context.Context) (*SystemStats, error) {
 	stats := &SystemStats{}
@@ -346,19 +444,75 @@ func (c *LocalConnector) GetSystemStats(ctx context.Context) (*SystemStats, erro
 		}
 	}
 
-	// Network connections
-	// Note: This would require additional implementation
-	stats.TCPConnections = 0
-	stats.UDPConnections = 0
-	stats.XrayConnections = 0
+	// Network speed, averaged since the previous call like the panel status
+	stats.NetInSpeed, stats.NetOutSpeed = localNetSpeed()
 
-	// Public IPs - TODO: implement GetPublicIP in ServerService
-	stats.PublicIPv4 = ""
-	stats.PublicIPv6 = ""
+	// Network connections, counted like the panel status
+	if stats.TCPConnections, err = sys.GetTCPCount(); err != nil {
+		logger.Warning("get tcp connections failed:", err)
+	}
+	if stats.UDPConnections, err = sys.GetUDPCount(); err != nil {
+		logger.Warning("get udp connections failed:", err)
+	}
+	stats.XrayConnections = c.countXrayConnections()
+
+	stats.AppMem, stats.AppGoroutines, stats.AppUptime = ProcessStats()
+	stats.XrayUptime = int64(c.xrayService.GetXrayUptime())
+
+	// Public IPs, cached by the detector
+	stats.PublicIPv4, stats.PublicIPv6 = c.publicIP.Detect()
 
 	return stats, nil
 }
 
+// countXrayConnections returns the established TCP connections on the ports of the
+// enabled inbounds of the local server.
+func (c *LocalConnector) countXrayConnections() int {
+	var ports []int
+	err := database.GetDB().Model(model.Inbound{}).
+		Where("server_id = ? AND enable = ?", c.serverId, true).
+		Distinct().Pluck("port", &ports).Error
+	if err != nil || len(ports) == 0 {
+		return 0
+	}
+	counts, err := sys.GetConnectionsByPort()
+	if err != nil {
+		logger.Warning("get xray connections failed:", err)
+		return 0
+	}
+	total := 0
+	for _, port := range ports {
+		total += counts[port]
+	}
+	return total
+}
+
+// localNetSpeed returns the receive and send speed of all interfaces in bytes/sec since
+// the previous call, or zeros on the first call.
+func localNetSpeed() (int64, int64) {
+	ioStats, err := net.IOCounters(false)
+	if err != nil || len(ioStats) == 0 {
+		return 0, 0
+	}
+	now := time.Now()
+
+	localNetMu.Lock()
+	defer localNetMu.Unlock()
+	last := localNetLast
+	localNetLast = &localNetSample{time: now, sent: ioStats[0].BytesSent, recv: ioStats[0].BytesRecv}
+	if last == nil {
+		return 0, 0
+	}
+	seconds := now.Sub(last.time).Seconds()
+	// Counters that went down were reset, e.g. by an interface restart
+	if seconds <= 0 || ioStats[0].BytesSent < last.sent || ioStats[0].BytesRecv < last.recv {
+		return 0, 0
+	}
+	in := int64(float64(ioStats[0].BytesRecv-last.recv) / seconds)
+	out := int64(float64(ioStats[0].BytesSent-last.sent) / seconds)
+	return in, out
+}
+
 // GetLogs retrieves the last N lines of Xray logs.
 func (c *LocalConnector) GetLogs(ctx context.Context, count int) ([]string, error) {
 	logPath, err := xray.GetAccessLogPath()
@@ -384,6 +538,21 @@ func (c *LocalConnector) GetLogs(ctx context.Context, count int) ([]string, erro
 	return lines[start:], nil
 }
 
+// StreamLogs live-tails the panel log or the local Xray logs.
+func (c *LocalConnector) StreamLogs(ctx context.Context, req *LogStreamRequest, send func(*logtail.Line) error) error {
+	return FollowLogs(ctx, logger.GetLogFilePath(), req, send)
+}
+
+// GenerateKey generates key material with the local Xray binary.
+func (c *LocalConnector) GenerateKey(ctx context.Context, kind string, sni string) (any, error) {
+	return c.serverService.GenerateKey(kind, sni)
+}
+
+// GetGeoFiles returns metadata of the installed geo files.
+func (c *LocalConnector) GetGeoFiles(ctx context.Context) ([]*GeoFileInfo, error) {
+	return c.serverService.GetGeofileInfo()
+}
+
 // UpdateGeoFiles updates Xray geo files (geoip.dat, geosite.dat).
 func (c *LocalConnector) UpdateGeoFiles(ctx context.Context) error {
 	// Note: ServerService has UpdateGeofile (singular) method
@@ -399,9 +568,34 @@ func (c *LocalConnector) UpdateGeoFiles(ctx context.Context) error {
 	return nil
 }
 
+// SyncBlockedIPs applies the blocklist locally. The panel database already holds
+// the entries, so only the routing of the running Xray needs to be updated.
+func (c *LocalConnector) SyncBlockedIPs(ctx context.Context, entries []*model.BlockedIP) error {
+	if !c.xrayService.IsXrayRunning() {
+		return nil
+	}
+	return c.xrayService.ApplyRoutingChange()
+}
+
+// ApplySettings applies fleet settings locally. They are the panel's own settings,
+// so only the Xray config needs to be regenerated.
+func (c *LocalConnector) ApplySettings(ctx context.Context, settings *FleetSettings) error {
+	c.xrayService.SetToNeedRestart()
+	return nil
+}
+
 // InstallXray installs a specific version of Xray.
-func (c *LocalConnector) InstallXray(ctx context.Context, version string) error {
-	return c.serverService.UpdateXray(version)
+func (c *LocalConnector) InstallXray(ctx context.Context, version string, progress ProgressFunc) error {
+	err := c.serverService.UpdateXrayWithProgress(version, progress)
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray "+version+" installed")
+	}
+	return err
+}
+
+// UpdateAgent fails: the local server runs the panel itself, which is updated with x-ui.sh.
+func (c *LocalConnector) UpdateAgent(ctx context.Context, version string, progress ProgressFunc) error {
+	return fmt.Errorf("the local server is updated together with the panel")
 }
 
 // GenerateCert generates an X25519 certificate (not TLS cert).
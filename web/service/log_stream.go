@@ -0,0 +1,210 @@
+// Package service provides live log streams of servers over WebSocket.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/gorilla/websocket"
+)
+
+// Log stream sources.
+const (
+	LogSourceAgent  = "agent"  // Log of the process managing the server: the agent, or the panel for the local server
+	LogSourceXray   = "xray"   // Messages of Xray itself, from its error log or the process log
+	LogSourceAccess = "access" // Xray access log
+)
+
+const (
+	// defaultLogStreamTail is the number of existing lines a stream starts with.
+	defaultLogStreamTail = 100
+	// maxLogStreamTail caps the existing lines a stream may ask for.
+	maxLogStreamTail = 1000
+	// logStreamPingInterval keeps idle streams alive through proxies.
+	logStreamPingInterval = 30 * time.Second
+	// logStreamWriteTimeout bounds writing one message to a stream.
+	logStreamWriteTimeout = 10 * time.Second
+)
+
+// ErrLogUnavailable is returned for log sources that are not written to a file.
+var ErrLogUnavailable = errors.New("log is not written to a file")
+
+// LogStreamRequest selects the lines of a live log stream.
+type LogStreamRequest struct {
+	Source string
+	Level  logtail.Level // Lines below this level are skipped
+	Tail   int           // Existing lines to send first, before the filters apply
+}
+
+// ParseLogStreamRequest validates the query parameters of a log stream request.
+// Empty values select the agent log, level info and the last 100 lines.
+func ParseLogStreamRequest(source, level, tail string) (*LogStreamRequest, error) {
+	req := &LogStreamRequest{Source: source, Tail: defaultLogStreamTail}
+	switch source {
+	case "":
+		req.Source = LogSourceAgent
+	case LogSourceAgent, LogSourceXray, LogSourceAccess:
+	default:
+		return nil, fmt.Errorf("unknown log source %q", source)
+	}
+
+	var ok bool
+	if req.Level, ok = logtail.ParseLevel(level); !ok {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	if tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid tail %q", tail)
+		}
+		req.Tail = min(n, maxLogStreamTail)
+	}
+	return req, nil
+}
+
+// Query returns req as the query string of the agent stream endpoint.
+func (req *LogStreamRequest) Query() string {
+	return "source=" + req.Source + "&level=" + req.Level.String() + "&tail=" + strconv.Itoa(req.Tail)
+}
+
+// FollowLogs follows a log of this machine and calls send with its lines of at least
+// req.Level. processLog is the log file of the running panel or agent; Xray messages
+// are read from it when Xray has no error log file of its own.
+func FollowLogs(ctx context.Context, processLog string, req *LogStreamRequest, send func(*logtail.Line) error) error {
+	path := processLog
+	match := func(string) bool { return true }
+	switch req.Source {
+	case LogSourceAgent:
+	case LogSourceXray:
+		if errorLog, err := xray.GetErrorLogPath(); err == nil && errorLog != "" && errorLog != "none" {
+			path = errorLog
+		} else {
+			// Xray logs to stdout, which the process log prefixes with "XRAY:"
+			match = func(line string) bool { return strings.Contains(line, " - XRAY: ") }
+		}
+	case LogSourceAccess:
+		accessLog, err := xray.GetAccessLogPath()
+		if err != nil || accessLog == "" || accessLog == "none" {
+			return ErrLogUnavailable
+		}
+		path = accessLog
+	default:
+		return fmt.Errorf("unknown log source %q", req.Source)
+	}
+	if path == "" {
+		return ErrLogUnavailable
+	}
+
+	return logtail.Follow(ctx, path, req.Tail, func(text string) error {
+		if strings.TrimSpace(text) == "" || !match(text) {
+			return nil
+		}
+		level := logtail.LineLevel(text)
+		if level < req.Level {
+			return nil
+		}
+		return send(&logtail.Line{Source: req.Source, Level: level.String(), Text: text})
+	})
+}
+
+var logStreamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// ServeLogStream upgrades the request to a WebSocket and sends the lines passed to send
+// as JSON messages until follow returns or the client disconnects. An error of follow
+// closes the stream with its message. Used by the agent API and the panel proxy.
+func ServeLogStream(w http.ResponseWriter, r *http.Request, follow func(ctx context.Context, send func(*logtail.Line) error) error) {
+	conn, err := logStreamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered with an HTTP error
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Clients send nothing; reading notices when they disconnect and answers pings
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(logStreamPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWriteTimeout)); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = follow(ctx, func(line *logtail.Line) error {
+		conn.SetWriteDeadline(time.Now().Add(logStreamWriteTimeout))
+		return conn.WriteJSON(line)
+	})
+
+	code, reason := websocket.CloseNormalClosure, ""
+	if err != nil && ctx.Err() == nil {
+		code, reason = websocket.CloseInternalServerErr, err.Error()
+		// Close reasons are limited to 123 bytes
+		if len(reason) > 120 {
+			reason = reason[:120]
+		}
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// receiveLogStream reads the JSON lines of a log stream and passes them to send until
+// the stream ends or ctx is done. A stream closed with an error returns it.
+func receiveLogStream(ctx context.Context, conn *websocket.Conn, send func(*logtail.Line) error) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// The sender pings regularly, so a silent stream is a dead one
+	readTimeout := 3 * logStreamPingInterval
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(logStreamWriteTimeout))
+	})
+
+	for {
+		line := &logtail.Line{}
+		if err := conn.ReadJSON(line); err != nil {
+			if ctx.Err() != nil || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Text != "" {
+				return errors.New(closeErr.Text)
+			}
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if err := send(line); err != nil {
+			return err
+		}
+	}
+}
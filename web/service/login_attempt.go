@@ -0,0 +1,219 @@
+// Package service provides LoginAttemptService for panel brute-force protection.
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// Login attempt reasons stored in model.LoginAttempt.Reason.
+const (
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginLocked             = "locked"
+	LoginCaptchaFailed      = "captcha"
+	LoginUnlocked           = "unlocked"
+)
+
+// loginAttemptRetention is how long login attempts are kept.
+const loginAttemptRetention = 30 * 24 * time.Hour
+
+// CaptchaVerifier checks the captcha response sent with a login from ip. It is a hook
+// for deployments that put a captcha provider in front of the login form.
+type CaptchaVerifier func(ctx context.Context, response, ip string) (bool, error)
+
+var (
+	captchaVerifierMu sync.RWMutex
+	captchaVerifier   CaptchaVerifier
+)
+
+// SetCaptchaVerifier installs the captcha hook. Until one is installed, logins never
+// require a captcha and only lockouts apply.
+func SetCaptchaVerifier(v CaptchaVerifier) {
+	captchaVerifierMu.Lock()
+	defer captchaVerifierMu.Unlock()
+	captchaVerifier = v
+}
+
+// LoginCheck tells whether a login from an IP for a username may proceed.
+type LoginCheck struct {
+	Locked          bool  `json:"locked"`
+	LockedUntil     int64 `json:"lockedUntil"` // Unix timestamp
+	CaptchaRequired bool  `json:"captchaRequired"`
+}
+
+// LoginAttemptService tracks failed panel logins per IP and per username, locks out
+// sources that fail too often and asks for a captcha after repeated failures.
+// Counters are derived from the persisted attempts, so restarts do not reset them.
+type LoginAttemptService struct {
+	settingService SettingService
+}
+
+// loginLimits are the brute-force settings; a limit of zero or less is disabled.
+type loginLimits struct {
+	perIp        int
+	perUser      int
+	captchaAfter int
+	lockout      time.Duration
+}
+
+func (s *LoginAttemptService) limits() (*loginLimits, error) {
+	perIp, err := s.settingService.GetLoginMaxFailuresPerIp()
+	if err != nil {
+		return nil, err
+	}
+	perUser, err := s.settingService.GetLoginMaxFailuresPerUser()
+	if err != nil {
+		return nil, err
+	}
+	captchaAfter, err := s.settingService.GetLoginCaptchaAfter()
+	if err != nil {
+		return nil, err
+	}
+	lockout, err := s.settingService.GetLoginLockoutMinutes()
+	if err != nil {
+		return nil, err
+	}
+	if lockout <= 0 {
+		lockout = 15
+	}
+	return &loginLimits{
+		perIp:        perIp,
+		perUser:      perUser,
+		captchaAfter: captchaAfter,
+		lockout:      time.Duration(lockout) * time.Minute,
+	}, nil
+}
+
+// CheckLogin reports whether ip or username is locked out and whether the login must
+// carry a captcha response.
+func (s *LoginAttemptService) CheckLogin(ip, username string) (*LoginCheck, error) {
+	limits, err := s.limits()
+	if err != nil {
+		return nil, err
+	}
+	ipFailures, err := s.recentFailures("ip", ip, limits.lockout)
+	if err != nil {
+		return nil, err
+	}
+	userFailures, err := s.recentFailures("username", username, limits.lockout)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &LoginCheck{}
+	for _, check := range []struct {
+		failures []int64
+		max      int
+	}{{ipFailures, limits.perIp}, {userFailures, limits.perUser}} {
+		if check.max <= 0 || len(check.failures) < check.max {
+			continue
+		}
+		// The lockout ends once the oldest failure that reached the limit leaves the window
+		until := check.failures[check.max-1] + int64(limits.lockout/time.Second)
+		if until > status.LockedUntil {
+			status.Locked, status.LockedUntil = true, until
+		}
+	}
+
+	captchaVerifierMu.RLock()
+	hasCaptcha := captchaVerifier != nil
+	captchaVerifierMu.RUnlock()
+	if hasCaptcha && limits.captchaAfter > 0 {
+		status.CaptchaRequired = len(ipFailures) >= limits.captchaAfter || len(userFailures) >= limits.captchaAfter
+	}
+	return status, nil
+}
+
+// VerifyCaptcha checks a captcha response with the installed hook.
+func (s *LoginAttemptService) VerifyCaptcha(ctx context.Context, response, ip string) (bool, error) {
+	captchaVerifierMu.RLock()
+	verify := captchaVerifier
+	captchaVerifierMu.RUnlock()
+	if verify == nil {
+		return true, nil
+	}
+	if response == "" {
+		return false, nil
+	}
+	return verify(ctx, response, ip)
+}
+
+// RecordAttempt stores a login attempt. A successful login resets the failure
+// counters of its IP and username.
+func (s *LoginAttemptService) RecordAttempt(ip, username string, success bool, reason string) error {
+	db := database.GetDB()
+	return db.Create(&model.LoginAttempt{
+		Username: username,
+		IP:       ip,
+		Success:  success,
+		Reason:   reason,
+	}).Error
+}
+
+// recentFailures returns the times of failed logins with invalid credentials for
+// column ("ip" or "username") within window and since the last successful login,
+// newest first. Attempts rejected by a lockout are not counted, so they do not
+// extend it.
+func (s *LoginAttemptService) recentFailures(column, value string, window time.Duration) ([]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	db := database.GetDB()
+	since := time.Now().Add(-window).Unix()
+
+	var lastSuccess int64
+	err := db.Model(model.LoginAttempt{}).
+		Where(column+" = ? AND success = ?", value, true).
+		Select("COALESCE(MAX(created_at), 0)").
+		Scan(&lastSuccess).Error
+	if err != nil {
+		return nil, err
+	}
+	if lastSuccess >= since {
+		since = lastSuccess + 1
+	}
+
+	var times []int64
+	err = db.Model(model.LoginAttempt{}).
+		Where(column+" = ? AND success = ? AND reason = ? AND created_at >= ?", value, false, LoginInvalidCredentials, since).
+		Order("created_at desc").
+		Pluck("created_at", &times).Error
+	return times, err
+}
+
+// GetRecentFailures returns the latest failed login attempts, newest first.
+func (s *LoginAttemptService) GetRecentFailures(limit int) ([]*model.LoginAttempt, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	db := database.GetDB()
+	var attempts []*model.LoginAttempt
+	err := db.Model(model.LoginAttempt{}).
+		Where("success = ?", false).
+		Order("id desc").
+		Limit(limit).
+		Find(&attempts).Error
+	return attempts, err
+}
+
+// Unlock lifts the lockout of an IP and/or username by recording an admin reset,
+// which counts as a successful login for the failure counters.
+func (s *LoginAttemptService) Unlock(ip, username string) error {
+	if ip == "" && username == "" {
+		return errors.New("ip or username is required")
+	}
+	return s.RecordAttempt(ip, username, true, LoginUnlocked)
+}
+
+// DeleteExpired removes login attempts past the retention period.
+func (s *LoginAttemptService) DeleteExpired() (int64, error) {
+	db := database.GetDB()
+	cutoff := time.Now().Add(-loginAttemptRetention).Unix()
+	result := db.Where("created_at < ?", cutoff).Delete(model.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
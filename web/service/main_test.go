@@ -0,0 +1,33 @@
+package service_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/logger"
+
+	"github.com/op/go-logging"
+)
+
+// TestMain runs the tests against a fresh panel database in a temporary folder.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "x-ui-service-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("XUI_DB_FOLDER", dir)
+	os.Setenv("XUI_LOG_FOLDER", dir)
+	logger.InitLogger(logging.ERROR)
+	if err := database.InitDB(dir + "/x-ui.db"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	database.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
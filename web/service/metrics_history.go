@@ -0,0 +1,194 @@
+// Package service provides MetricsHistoryService for persisted server metric history.
+package service
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// metricSampleMaxAge skips servers whose latest fleet sample is older than this,
+	// i.e. servers that failed their recent health checks.
+	metricSampleMaxAge = 2 * time.Minute
+	// metricDownsampleResolution is the period raw samples are averaged into once they
+	// are older than the raw retention.
+	metricDownsampleResolution = 3600
+)
+
+// MetricPoint is one point of a server metric chart, averaged over a bucket.
+type MetricPoint struct {
+	T       int64   `json:"t"`       // Unix timestamp of the bucket start
+	Cpu     float64 `json:"cpu"`     // Percentage (0-100)
+	Mem     float64 `json:"mem"`     // Percentage (0-100)
+	Disk    float64 `json:"disk"`    // Percentage (0-100)
+	NetUp   int64   `json:"netUp"`   // Bytes/sec
+	NetDown int64   `json:"netDown"` // Bytes/sec
+}
+
+// MetricsHistoryService persists the fleet dashboard samples of every server, charts
+// and exports them for a period. Raw samples are averaged per hour after
+// metricHistoryRawDays and deleted after metricHistoryDays.
+type MetricsHistoryService struct {
+	serverMgmt     ServerManagementService
+	settingService SettingService
+}
+
+// RecordSamples stores the latest fleet sample of every server that is currently
+// reporting, downsamples raw samples past the raw retention and prunes samples past
+// the history retention.
+func (s *MetricsHistoryService) RecordSamples() error {
+	now := time.Now()
+	samples := make([]*model.ServerMetricSample, 0)
+
+	fleetMu.RLock()
+	for serverId, snapshot := range fleetSnapshots {
+		if len(snapshot.history) == 0 {
+			continue
+		}
+		last := snapshot.history[len(snapshot.history)-1]
+		if now.Sub(time.Unix(last.Time, 0)) > metricSampleMaxAge {
+			continue
+		}
+		sample := &model.ServerMetricSample{
+			ServerId:  serverId,
+			Cpu:       last.Cpu,
+			Mem:       last.Mem,
+			Online:    last.Online,
+			CreatedAt: now.Unix(),
+		}
+		if stats := snapshot.stats; stats != nil {
+			sample.MemUsed = stats.MemUsed
+			sample.Disk = stats.DiskUsage
+			sample.DiskUsed = stats.DiskUsed
+			sample.NetUp = stats.NetOutSpeed
+			sample.NetDown = stats.NetInSpeed
+		}
+		samples = append(samples, sample)
+	}
+	fleetMu.RUnlock()
+
+	db := database.GetDB()
+	if len(samples) > 0 {
+		if err := db.CreateInBatches(samples, 100).Error; err != nil {
+			return err
+		}
+	}
+
+	rawDays, err := s.settingService.GetMetricHistoryRawDays()
+	if err != nil || rawDays < 1 {
+		rawDays = 7
+	}
+	days, err := s.settingService.GetMetricHistoryDays()
+	if err != nil || days < rawDays {
+		days = max(rawDays, 180)
+	}
+	if err := s.downsample(now.AddDate(0, 0, -rawDays)); err != nil {
+		return err
+	}
+	cutoff := now.AddDate(0, 0, -days).Unix()
+	return db.Where("created_at < ?", cutoff).Delete(model.ServerMetricSample{}).Error
+}
+
+// downsample replaces the raw samples older than before with one average per server
+// and hour. The cutoff is rounded down to a full hour, so every hour is averaged once
+// with all its samples.
+func (s *MetricsHistoryService) downsample(before time.Time) error {
+	cutoff := before.Unix() / metricDownsampleResolution * metricDownsampleResolution
+	var averages []*model.ServerMetricSample
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(model.ServerMetricSample{}).
+			Select("server_id, AVG(cpu) AS cpu, AVG(mem) AS mem, AVG(disk) AS disk, "+
+				"CAST(AVG(mem_used) AS INTEGER) AS mem_used, CAST(AVG(disk_used) AS INTEGER) AS disk_used, "+
+				"CAST(AVG(net_up) AS INTEGER) AS net_up, CAST(AVG(net_down) AS INTEGER) AS net_down, "+
+				"CAST(ROUND(AVG(online)) AS INTEGER) AS online, "+
+				"created_at / ? * ? AS created_at", metricDownsampleResolution, metricDownsampleResolution).
+			Where("resolution = 0 AND created_at < ?", cutoff).
+			Group("server_id, created_at / " + strconv.Itoa(metricDownsampleResolution)).
+			Scan(&averages).Error
+		if err != nil || len(averages) == 0 {
+			return err
+		}
+		for _, average := range averages {
+			average.Resolution = metricDownsampleResolution
+		}
+		if err := tx.CreateInBatches(averages, 100).Error; err != nil {
+			return err
+		}
+		return tx.Where("resolution = 0 AND created_at < ?", cutoff).Delete(model.ServerMetricSample{}).Error
+	})
+}
+
+// GetChart returns the metrics of a server from the persisted samples, averaged into
+// buckets of bucketSeconds, for the last bucketSeconds*maxPoints seconds.
+func (s *MetricsHistoryService) GetChart(serverId int, bucketSeconds int, maxPoints int) ([]*MetricPoint, error) {
+	points := make([]*MetricPoint, 0)
+	if bucketSeconds <= 0 || maxPoints <= 0 {
+		return points, nil
+	}
+	from := time.Now().Unix() - int64(bucketSeconds*maxPoints)
+	err := database.GetDB().Model(model.ServerMetricSample{}).
+		Select("created_at / ? * ? AS t, AVG(cpu) AS cpu, AVG(mem) AS mem, AVG(disk) AS disk, "+
+			"CAST(AVG(net_up) AS INTEGER) AS net_up, CAST(AVG(net_down) AS INTEGER) AS net_down", bucketSeconds, bucketSeconds).
+		Where("server_id = ? AND created_at >= ?", serverId, from).
+		Group("t").
+		Order("t").
+		Scan(&points).Error
+	return points, err
+}
+
+// GetHistory returns the samples of the given servers within a period, ordered by
+// server and time. An empty serverIds returns the samples of all servers.
+func (s *MetricsHistoryService) GetHistory(serverIds []int, from, to time.Time) ([]*model.ServerMetricSample, error) {
+	db := database.GetDB().Model(model.ServerMetricSample{}).
+		Where("created_at BETWEEN ? AND ?", from.Unix(), to.Unix())
+	if len(serverIds) > 0 {
+		db = db.Where("server_id IN ?", serverIds)
+	}
+
+	var samples []*model.ServerMetricSample
+	err := db.Order("server_id, created_at").Find(&samples).Error
+	return samples, err
+}
+
+// WriteMetricsCSV writes samples as CSV, one row per sample.
+func (s *MetricsHistoryService) WriteMetricsCSV(w io.Writer, samples []*model.ServerMetricSample) error {
+	names := make(map[int]string)
+	if servers, err := s.serverMgmt.GetAllServers(); err == nil {
+		for _, server := range servers {
+			names[server.Id] = server.Name
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"time", "server_id", "server_name", "cpu_percent", "mem_percent", "mem_used", "disk_used", "net_up_bps", "net_down_bps", "online_clients", "disk_percent", "resolution_seconds"}); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if err := writer.Write([]string{
+			time.Unix(sample.CreatedAt, 0).UTC().Format(time.RFC3339),
+			strconv.Itoa(sample.ServerId),
+			names[sample.ServerId],
+			strconv.FormatFloat(sample.Cpu, 'f', 2, 64),
+			strconv.FormatFloat(sample.Mem, 'f', 2, 64),
+			strconv.FormatUint(sample.MemUsed, 10),
+			strconv.FormatUint(sample.DiskUsed, 10),
+			strconv.FormatInt(sample.NetUp, 10),
+			strconv.FormatInt(sample.NetDown, 10),
+			strconv.Itoa(sample.Online),
+			strconv.FormatFloat(sample.Disk, 'f', 2, 64),
+			strconv.Itoa(sample.Resolution),
+		}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
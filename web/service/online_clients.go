@@ -0,0 +1,111 @@
+// Package service provides FleetOnlineService for the online clients of all servers.
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+const (
+	// fleetOnlineCacheTTL limits how often the online clients view polls every agent.
+	fleetOnlineCacheTTL = 10 * time.Second
+	// fleetOnlineConcurrency is the number of servers polled at once.
+	fleetOnlineConcurrency = 10
+	// fleetOnlineTimeout bounds polling one server.
+	fleetOnlineTimeout = 10 * time.Second
+)
+
+var (
+	// fleetOnlineMu also serializes fan-outs, so concurrent callers share one poll.
+	fleetOnlineMu     sync.Mutex
+	fleetOnlineCached *FleetOnlineClients
+)
+
+// FleetOnlineClient is a client online on one server.
+type FleetOnlineClient struct {
+	Email      string `json:"email"`
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+}
+
+// FleetOnlineClients is the merged list of online clients of all enabled servers.
+type FleetOnlineClients struct {
+	Clients       []*FleetOnlineClient `json:"clients"`
+	Total         int                  `json:"total"`
+	FailedServers []string             `json:"failedServers"` // Servers that could not be polled
+	UpdatedAt     int64                `json:"updatedAt"`     // Unix timestamp the list was built
+}
+
+// FleetOnlineService merges the online clients reported by every enabled server.
+type FleetOnlineService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetOnlineClients returns the online clients of all enabled servers, sorted by email
+// and server. The list is cached for fleetOnlineCacheTTL.
+func (s *FleetOnlineService) GetOnlineClients(ctx context.Context) (*FleetOnlineClients, error) {
+	fleetOnlineMu.Lock()
+	defer fleetOnlineMu.Unlock()
+	if fleetOnlineCached != nil && time.Since(time.Unix(fleetOnlineCached.UpdatedAt, 0)) < fleetOnlineCacheTTL {
+		return fleetOnlineCached, nil
+	}
+
+	online, err := s.collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// A list cut short by the caller going away is not worth sharing
+	if ctx.Err() == nil {
+		fleetOnlineCached = online
+	}
+	return online, nil
+}
+
+// collect polls every enabled server for its online clients.
+func (s *FleetOnlineService) collect(ctx context.Context) (*FleetOnlineClients, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	online := &FleetOnlineClients{
+		Clients:       make([]*FleetOnlineClient, 0),
+		FailedServers: make([]string, 0),
+		UpdatedAt:     time.Now().Unix(),
+	}
+	results := FanOut(ctx, servers, FanOutOptions{Concurrency: fleetOnlineConcurrency, Timeout: fleetOnlineTimeout},
+		func(ctx context.Context, server *model.Server) ([]string, error) {
+			connector, err := s.serverMgmt.GetConnector(server.Id)
+			if err != nil {
+				return nil, err
+			}
+			return connector.GetOnlineClients(ctx)
+		})
+	for _, result := range results {
+		if result.Err != nil {
+			online.FailedServers = append(online.FailedServers, result.Err.Error())
+			continue
+		}
+		for _, email := range result.Value {
+			online.Clients = append(online.Clients, &FleetOnlineClient{
+				Email:      email,
+				ServerId:   result.Server.Id,
+				ServerName: result.Server.Name,
+			})
+		}
+	}
+
+	sort.Slice(online.Clients, func(i, j int) bool {
+		if online.Clients[i].Email != online.Clients[j].Email {
+			return online.Clients[i].Email < online.Clients[j].Email
+		}
+		return online.Clients[i].ServerId < online.Clients[j].ServerId
+	})
+	sort.Strings(online.FailedServers)
+	online.Total = len(online.Clients)
+	return online, nil
+}
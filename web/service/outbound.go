@@ -1,6 +1,10 @@
 package service
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
@@ -9,9 +13,14 @@ import (
 	"gorm.io/gorm"
 )
 
+// outboundCollectTimeout bounds fetching the outbound traffic of one remote server.
+const outboundCollectTimeout = 15 * time.Second
+
 // OutboundService provides business logic for managing Xray outbound configurations.
 // It handles outbound traffic monitoring and statistics.
-type OutboundService struct{}
+type OutboundService struct {
+	serverMgmt ServerManagementService
+}
 
 func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
 	var err error
@@ -40,19 +49,19 @@ func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traff
 	}
 
 	var err error
+	serverId := s.serverMgmt.GetLocalServerId()
 
 	for _, traffic := range traffics {
 		if traffic.IsOutbound {
 
 			var outbound model.OutboundTraffics
 
-			err = tx.Model(&model.OutboundTraffics{}).Where("tag = ?", traffic.Tag).
+			err = tx.Where(&model.OutboundTraffics{ServerId: serverId, Tag: traffic.Tag}).
 				FirstOrCreate(&outbound).Error
 			if err != nil {
 				return err
 			}
 
-			outbound.Tag = traffic.Tag
 			outbound.Up = outbound.Up + traffic.Up
 			outbound.Down = outbound.Down + traffic.Down
 			outbound.Total = outbound.Up + outbound.Down
@@ -66,11 +75,21 @@ func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traff
 	return nil
 }
 
+// GetOutboundsTraffic returns the outbound traffic of the local Xray.
 func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, error) {
-	db := database.GetDB()
-	var traffics []*model.OutboundTraffics
+	return s.GetServerOutboundsTraffic(s.serverMgmt.GetLocalServerId())
+}
 
-	err := db.Model(model.OutboundTraffics{}).Find(&traffics).Error
+// GetServerOutboundsTraffic returns the outbound traffic recorded for a server, or for
+// all servers if serverId is 0.
+func (s *OutboundService) GetServerOutboundsTraffic(serverId int) ([]*model.OutboundTraffics, error) {
+	db := database.GetDB().Model(model.OutboundTraffics{})
+	if serverId != 0 {
+		db = db.Where("server_id = ?", serverId)
+	}
+
+	var traffics []*model.OutboundTraffics
+	err := db.Order("server_id, tag").Find(&traffics).Error
 	if err != nil {
 		logger.Warning("Error retrieving OutboundTraffics: ", err)
 		return nil, err
@@ -79,6 +98,84 @@ func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, erro
 	return traffics, nil
 }
 
+// GetFleetOutboundsTraffic returns the outbound traffic of a server, or of all servers if
+// serverId is 0, labeled with the server names.
+func (s *OutboundService) GetFleetOutboundsTraffic(serverId int) ([]*model.OutboundTraffics, error) {
+	traffics, err := s.GetServerOutboundsTraffic(serverId)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := s.serverMgmt.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(servers))
+	for _, server := range servers {
+		names[server.Id] = server.Name
+	}
+	for _, traffic := range traffics {
+		traffic.ServerName = names[traffic.ServerId]
+	}
+	return traffics, nil
+}
+
+// CollectRemoteTraffic stores the outbound traffic reported by every enabled remote server.
+// Agents keep the totals themselves, so the stored records are replaced rather than added to.
+func (s *OutboundService) CollectRemoteTraffic() error {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		if server.IsLocal() || server.Status != "online" {
+			continue
+		}
+		wg.Add(1)
+		go func(serverId int, name string) {
+			defer wg.Done()
+			if err := s.collectServerTraffic(serverId); err != nil {
+				logger.Debug("Failed to collect outbound traffic of server", name, ":", err)
+			}
+		}(server.Id, server.Name)
+	}
+	wg.Wait()
+	return nil
+}
+
+// collectServerTraffic fetches and stores the outbound traffic of one remote server.
+func (s *OutboundService) collectServerTraffic(serverId int) error {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), outboundCollectTimeout)
+	defer cancel()
+	traffics, err := connector.GetOutboundTraffics(ctx)
+	if err != nil {
+		return err
+	}
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, traffic := range traffics {
+			var outbound model.OutboundTraffics
+			err := tx.Where(&model.OutboundTraffics{ServerId: serverId, Tag: traffic.Tag}).
+				FirstOrCreate(&outbound).Error
+			if err != nil {
+				return err
+			}
+			outbound.Up = traffic.Up
+			outbound.Down = traffic.Down
+			outbound.Total = traffic.Up + traffic.Down
+			if err := tx.Save(&outbound).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *OutboundService) ResetOutboundTraffic(tag string) error {
 	db := database.GetDB()
 
@@ -90,6 +187,7 @@ func (s *OutboundService) ResetOutboundTraffic(tag string) error {
 	}
 
 	result := db.Model(model.OutboundTraffics{}).
+		Where("server_id = ?", s.serverMgmt.GetLocalServerId()).
 		Where(whereText, tag).
 		Updates(map[string]any{"up": 0, "down": 0, "total": 0})
 
@@ -0,0 +1,460 @@
+// Package service provides the certificate authority the panel issues agent certificates from.
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/secrets"
+)
+
+const (
+	// panelCAValidity is how long the generated panel CA is valid.
+	panelCAValidity = 10 * 365 * 24 * time.Hour
+	// agentCertValidity is how long certificates issued to agents and the panel are valid.
+	agentCertValidity = 2 * 365 * 24 * time.Hour
+	// controllerCertName is the common name of the client certificates the panel
+	// presents to agents, e.g. for AGENT_ALLOWED_CLIENT_NAMES.
+	controllerCertName = "3x-ui-controller"
+	// panelCRLValidity is how long a CRL of the panel CA is valid; agents warn once it is
+	// past its next update, so it should be downloaded again before then.
+	panelCRLValidity = 30 * 24 * time.Hour
+)
+
+// panelCAMu serializes generating the CA, so concurrent registrations share one.
+var panelCAMu sync.Mutex
+
+// ErrPanelCAKeyRequired is returned when the panel CA would have to be generated or used
+// without XUI_AUTHDATA_KEY, which would leave its key readable in the settings and in
+// every database backup.
+var ErrPanelCAKeyRequired = errors.New("the panel CA needs XUI_AUTHDATA_KEY to encrypt its key")
+
+// ErrNotPanelIssued is returned for servers whose certificates did not come from the
+// panel CA, e.g. pasted PEM bundles, file paths or secret references.
+var ErrNotPanelIssued = errors.New("server certificates were not issued by the panel CA")
+
+// AgentCertificates is the PEM material issued for an mTLS agent: the server
+// certificate of the agent, the client certificate the panel presents to it, and the CA
+// both are signed by.
+type AgentCertificates struct {
+	CertPem       string `json:"certPem"`
+	KeyPem        string `json:"keyPem"`
+	CAPem         string `json:"caPem"`
+	CRLPem        string `json:"crlPem,omitempty"` // Revoked panel certificates, for AGENT_CRL_FILE
+	ClientCertPem string `json:"-"`
+	ClientKeyPem  string `json:"-"`
+}
+
+// issuedAuthData is the auth data of an mTLS server with panel-issued certificates: the
+// panel's client certificate the connector uses, and the agent's certificate and key,
+// kept so they can be downloaded again. It is sealed like any other auth data.
+type issuedAuthData struct {
+	CertPem      string `json:"certPem"`
+	KeyPem       string `json:"keyPem"`
+	CAPem        string `json:"caPem"`
+	AgentCertPem string `json:"agentCertPem"`
+	AgentKeyPem  string `json:"agentKeyPem"`
+	IssuedAt     int64  `json:"issuedAt"`
+}
+
+// authData returns the auth data to store for the certificates.
+func (c *AgentCertificates) authData() (string, error) {
+	encoded, err := json.Marshal(issuedAuthData{
+		CertPem:      c.ClientCertPem,
+		KeyPem:       c.ClientKeyPem,
+		CAPem:        c.CAPem,
+		AgentCertPem: c.CertPem,
+		AgentKeyPem:  c.KeyPem,
+		IssuedAt:     time.Now().Unix(),
+	})
+	return string(encoded), err
+}
+
+// PanelCAService issues mTLS certificates for agents from a CA the panel generates on
+// first use. The CA key is stored in the settings encrypted with XUI_AUTHDATA_KEY, without
+// which the CA is not generated.
+type PanelCAService struct {
+	settingService SettingService
+	serverMgmt     ServerManagementService
+}
+
+// GetCACert returns the PEM certificate of the panel CA, generating the CA if needed.
+func (s *PanelCAService) GetCACert() (string, error) {
+	_, _, caPem, err := s.loadCA()
+	return caPem, err
+}
+
+// PrefillAuthData issues certificates for a new mTLS server saved without auth data and
+// sets them as its auth data, so no PEM bundle has to be pasted. The agent's half can
+// be downloaded with GetServerCertificates once the server is saved.
+func (s *PanelCAService) PrefillAuthData(server *model.Server) error {
+	if server.AuthType != "mtls" || server.AuthData != "" {
+		return nil
+	}
+	endpoint, err := NormalizeEndpoint(server.Endpoint)
+	if err != nil {
+		return err
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	certs, err := s.IssueAgentCertificates(server.Name, endpointURL.Hostname())
+	if err != nil {
+		return err
+	}
+	server.AuthData, err = certs.authData()
+	return err
+}
+
+// GetServerCertificates returns the agent certificate, key and CA issued for a server.
+func (s *PanelCAService) GetServerCertificates(serverId int) (*AgentCertificates, error) {
+	server, err := s.serverMgmt.GetServer(serverId)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := loadIssuedAuthData(server)
+	if err != nil {
+		return nil, err
+	}
+	crlPem, err := s.GetCRL()
+	if err != nil {
+		return nil, err
+	}
+	return &AgentCertificates{CertPem: issued.AgentCertPem, KeyPem: issued.AgentKeyPem, CAPem: issued.CAPem, CRLPem: crlPem}, nil
+}
+
+// RenewServerCertificates issues new certificates for a server with panel-issued
+// certificates and returns the agent's half. The panel switches to its new client
+// certificate right away, which the agent accepts since both are signed by the same CA,
+// and revokes the old one; the returned CRL lists it for the agent's AGENT_CRL_FILE.
+// The old agent certificate keeps working until the new one is installed on the agent.
+func (s *PanelCAService) RenewServerCertificates(serverId int) (*AgentCertificates, error) {
+	server, err := s.serverMgmt.GetServer(serverId)
+	if err != nil {
+		return nil, err
+	}
+	old, err := loadIssuedAuthData(server)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := NormalizeEndpoint(server.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := s.IssueAgentCertificates(server.Name, endpointURL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	authData, err := certs.authData()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare-and-swap, so an edit saved meanwhile is not overwritten
+	result := database.GetDB().Model(model.Server{}).
+		Where("id = ? AND auth_data = ?", serverId, server.AuthData).
+		Updates(map[string]any{"auth_data": sealed, "updated_at": time.Now().Unix()})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("server auth data changed during renewal")
+	}
+	if err := s.revoke(old.CertPem); err != nil {
+		return nil, fmt.Errorf("failed to revoke the previous panel certificate: %w", err)
+	}
+	if certs.CRLPem, err = s.GetCRL(); err != nil {
+		return nil, err
+	}
+	recordServerEvent(serverId, "config", "Certificates renewed by the panel CA")
+	return certs, nil
+}
+
+// revoke adds the serial of a PEM certificate to the revoked certificates of the panel CA.
+func (s *PanelCAService) revoke(certPem string) error {
+	block, _ := pem.Decode([]byte(certPem))
+	if block == nil {
+		return errors.New("invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	panelCAMu.Lock()
+	defer panelCAMu.Unlock()
+	serials, err := s.revokedSerials()
+	if err != nil {
+		return err
+	}
+	serial := cert.SerialNumber.Text(16)
+	if slices.Contains(serials, serial) {
+		return nil
+	}
+	return s.settingService.SetPanelCARevoked(strings.Join(append(serials, serial), ","))
+}
+
+// revokedSerials returns the hex serials of the revoked panel CA certificates.
+func (s *PanelCAService) revokedSerials() ([]string, error) {
+	value, err := s.settingService.GetPanelCARevoked()
+	if err != nil || value == "" {
+		return nil, err
+	}
+	return strings.Split(value, ","), nil
+}
+
+// GetCRL returns a PEM CRL of the panel CA listing every revoked certificate, signed now
+// and valid for panelCRLValidity.
+func (s *PanelCAService) GetCRL() (string, error) {
+	caCert, caKey, _, err := s.loadCA()
+	if err != nil {
+		return "", err
+	}
+	panelCAMu.Lock()
+	serials, err := s.revokedSerials()
+	panelCAMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	entries := make([]x509.RevocationListEntry, 0, len(serials))
+	for _, serial := range serials {
+		number, ok := new(big.Int).SetString(serial, 16)
+		if !ok {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: number, RevocationTime: now})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(now.Unix()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(panelCRLValidity),
+	}, caCert, caKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})), nil
+}
+
+// loadIssuedAuthData returns the auth data of a server if the panel CA issued its
+// certificates, otherwise ErrNotPanelIssued.
+func loadIssuedAuthData(server *model.Server) (*issuedAuthData, error) {
+	if server.AuthType != "mtls" || secrets.IsReference(server.AuthData) {
+		return nil, ErrNotPanelIssued
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	raw, err := openAuthData(ctx, server.AuthData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auth data: %w", err)
+	}
+	issued := &issuedAuthData{}
+	if json.Unmarshal([]byte(raw), issued) != nil || issued.AgentCertPem == "" || issued.AgentKeyPem == "" {
+		return nil, ErrNotPanelIssued
+	}
+	return issued, nil
+}
+
+// IssueAgentCertificates issues a server certificate for an agent reachable at host (an
+// IP address or DNS name) and a client certificate for the panel to call it with.
+func (s *PanelCAService) IssueAgentCertificates(serverName, host string) (*AgentCertificates, error) {
+	caCert, caKey, caPem, err := s.loadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: serverName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		server.IPAddresses = []net.IP{ip}
+	} else {
+		server.DNSNames = []string{host}
+	}
+	certPem, keyPem, err := signCertificate(server, caCert, caKey, agentCertValidity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue agent certificate: %w", err)
+	}
+
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: controllerCertName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientCertPem, clientKeyPem, err := signCertificate(client, caCert, caKey, agentCertValidity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue panel certificate: %w", err)
+	}
+
+	return &AgentCertificates{
+		CertPem:       certPem,
+		KeyPem:        keyPem,
+		CAPem:         caPem,
+		ClientCertPem: clientCertPem,
+		ClientKeyPem:  clientKeyPem,
+	}, nil
+}
+
+// loadCA returns the panel CA, generating and storing it if there is none yet.
+func (s *PanelCAService) loadCA() (*x509.Certificate, *ecdsa.PrivateKey, string, error) {
+	panelCAMu.Lock()
+	defer panelCAMu.Unlock()
+
+	certPem, sealedKey, err := s.settingService.GetPanelCA()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if certPem == "" || sealedKey == "" {
+		return s.generateCA()
+	}
+
+	// A key stored before XUI_AUTHDATA_KEY was required is encrypted once the key is set
+	plain := !strings.HasPrefix(sealedKey, authDataPrefix) && !secrets.IsReference(sealedKey)
+	if plain && config.GetAuthDataKey() == "" {
+		return nil, nil, "", ErrPanelCAKeyRequired
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	keyPem, err := openAuthData(ctx, sealedKey)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to load panel CA key: %w", err)
+	}
+	if plain {
+		if sealedKey, err = sealAuthData(keyPem); err != nil {
+			return nil, nil, "", err
+		}
+		if err := s.settingService.SetPanelCA(certPem, sealedKey); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to store panel CA: %w", err)
+		}
+	}
+	certBlock, _ := pem.Decode([]byte(certPem))
+	keyBlock, _ := pem.Decode([]byte(keyPem))
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, "", errors.New("panel CA is corrupted")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to parse panel CA certificate: %w", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to parse panel CA key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, "", errors.New("panel CA key is not an ECDSA key")
+	}
+	return cert, ecKey, certPem, nil
+}
+
+// generateCA creates a self-signed CA and stores it. panelCAMu must be held.
+func (s *PanelCAService) generateCA() (*x509.Certificate, *ecdsa.PrivateKey, string, error) {
+	if config.GetAuthDataKey() == "" {
+		return nil, nil, "", ErrPanelCAKeyRequired
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "3x-ui panel CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(panelCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	certPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	sealedKey, err := sealAuthData(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := s.settingService.SetPanelCA(certPem, sealedKey); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to store panel CA: %w", err)
+	}
+	return cert, key, certPem, nil
+}
+
+// signCertificate fills in the key, serial and validity of template, signs it with the
+// CA and returns the PEM certificate and key.
+func signCertificate(template, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, validity time.Duration) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	if template.SerialNumber, err = randomSerial(); err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.Add(validity)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	return string(certPem), string(keyPem), nil
+}
+
+// randomSerial returns a random 128-bit certificate serial number.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
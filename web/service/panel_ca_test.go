@@ -0,0 +1,79 @@
+package service_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestRenewRevokesPanelCertificate checks that the panel CA is not generated without
+// XUI_AUTHDATA_KEY, and that renewing a server's certificates revokes the panel's old
+// client certificate in the CRL.
+func TestRenewRevokesPanelCertificate(t *testing.T) {
+	panelCA := &service.PanelCAService{}
+	// Start without a CA, other tests may have generated one
+	for _, key := range []string{"panelCACert", "panelCAKey", "panelCARevoked"} {
+		if err := service.SetSetting(key, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("XUI_AUTHDATA_KEY", "")
+	if _, err := panelCA.GetCACert(); !errors.Is(err, service.ErrPanelCAKeyRequired) {
+		t.Fatalf("expected the CA to need XUI_AUTHDATA_KEY, got %v", err)
+	}
+
+	t.Setenv("XUI_AUTHDATA_KEY", "panel-ca-test-key")
+	server := &model.Server{Id: 103, Name: "ca-agent", Endpoint: "https://10.0.0.3:2054", AuthType: "mtls", Enabled: true}
+	if err := panelCA.PrefillAuthData(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.GetDB().Create(server).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Server{}, server.Id)
+	oldSerial := panelCertSerial(t, server.AuthData)
+
+	certs, err := panelCA.RenewServerCertificates(server.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(certs.CRLPem))
+	if block == nil {
+		t.Fatal("renewal returned no CRL")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBlock, _ := pem.Decode([]byte(certs.CAPem))
+	caCert, err := x509.ParseCertificate(caBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("CRL is not signed by the panel CA: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Text(16) != oldSerial {
+		t.Fatalf("expected the CRL to revoke %s, got %d entries", oldSerial, len(crl.RevokedCertificateEntries))
+	}
+}
+
+// panelCertSerial returns the hex serial of the panel client certificate in auth data.
+func panelCertSerial(t *testing.T, authData string) string {
+	t.Helper()
+	block, _ := pem.Decode([]byte(service.IssuedClientCert(authData)))
+	if block == nil {
+		t.Fatal("auth data has no panel certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.SerialNumber.Text(16)
+}
@@ -0,0 +1,150 @@
+// Package service provides PortProbeService for checking that inbound ports are reachable from the panel.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+const (
+	// portProbeTimeout bounds connecting to one inbound port.
+	portProbeTimeout = 3 * time.Second
+	// portProbeServerTimeout bounds probing all inbounds of one server.
+	portProbeServerTimeout = 2 * time.Minute
+	// portProbeMaxAge is how long a probe result flags an inbound; the probe job runs
+	// every 5 minutes, so results of servers that stopped answering run out.
+	portProbeMaxAge = 15 * time.Minute
+)
+
+// portProbeKey identifies a probed port of a server.
+type portProbeKey struct {
+	serverId int
+	port     int
+}
+
+// portProbeResult is the outcome of the last probe of a port.
+type portProbeResult struct {
+	reachable bool
+	checkedAt time.Time
+}
+
+var (
+	portProbeMu      sync.Mutex
+	portProbeResults = map[portProbeKey]*portProbeResult{}
+)
+
+// PortProbeService connects to the TCP ports of enabled inbounds from the panel, at the
+// address their links use, so inbounds blocked by a firewall or not listening can be
+// flagged in listings. Results are only kept in memory.
+type PortProbeService struct {
+	serverMgmt     ServerManagementService
+	settingService SettingService
+}
+
+// ProbeAll probes the inbound ports of all enabled servers and returns how many were
+// unreachable. Nothing is probed, and earlier results are dropped, while probing is
+// disabled in the settings.
+func (s *PortProbeService) ProbeAll(ctx context.Context) (int, error) {
+	if enabled, err := s.settingService.GetPortProbeEnabled(); err != nil || !enabled {
+		portProbeMu.Lock()
+		clear(portProbeResults)
+		portProbeMu.Unlock()
+		return 0, err
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return 0, err
+	}
+	results := FanOut(ctx, servers, FanOutOptions{Timeout: portProbeServerTimeout}, s.probeServer)
+	unreachable := 0
+	for _, result := range results {
+		unreachable += result.Value
+	}
+	return unreachable, FanOutErrors(results)
+}
+
+// probeServer probes the inbound ports of one server and returns how many were unreachable.
+func (s *PortProbeService) probeServer(ctx context.Context, server *model.Server) (int, error) {
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return 0, err
+	}
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.serverMgmt.ResolveServerAddresses(server.Id, inbounds...)
+
+	probed := make(map[portProbeKey]*portProbeResult)
+	unreachable := 0
+	dialer := &net.Dialer{Timeout: portProbeTimeout}
+	for _, inbound := range inbounds {
+		key := portProbeKey{serverId: server.Id, port: inbound.Port}
+		if !isProbeable(inbound) || probed[key] != nil {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(inbound.ServerAddress, strconv.Itoa(inbound.Port)))
+		if err == nil {
+			conn.Close()
+		} else if ctx.Err() != nil {
+			// Running out of time says nothing about the port
+			break
+		} else {
+			unreachable++
+		}
+		probed[key] = &portProbeResult{reachable: err == nil, checkedAt: time.Now()}
+	}
+
+	portProbeMu.Lock()
+	defer portProbeMu.Unlock()
+	for key := range portProbeResults {
+		if key.serverId == server.Id && probed[key] == nil {
+			delete(portProbeResults, key)
+		}
+	}
+	for key, result := range probed {
+		portProbeResults[key] = result
+	}
+	return unreachable, nil
+}
+
+// isProbeable reports whether an inbound can be checked with a TCP connection from the
+// panel: it is enabled, has a known public address, listens on a public TCP port and
+// does not use a UDP transport.
+func isProbeable(inbound *model.Inbound) bool {
+	if !inbound.Enable || inbound.Port <= 0 || inbound.ServerAddress == "" {
+		return false
+	}
+	if inbound.Listen != "" {
+		if strings.HasPrefix(inbound.Listen, "/") || strings.HasPrefix(inbound.Listen, "@") {
+			return false // Unix socket
+		}
+		if ip := net.ParseIP(inbound.Listen); ip != nil && ip.IsLoopback() {
+			return false
+		}
+	}
+	if inbound.Protocol == model.WireGuard || strings.HasPrefix(string(inbound.Protocol), "hysteria") {
+		return false
+	}
+	var stream struct {
+		Network string `json:"network"`
+	}
+	json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	return stream.Network != "kcp" && stream.Network != "quic"
+}
+
+// portUnreachable reports whether the last recent probe of a port of a server failed.
+func portUnreachable(serverId, port int) bool {
+	portProbeMu.Lock()
+	defer portProbeMu.Unlock()
+	result, ok := portProbeResults[portProbeKey{serverId: serverId, port: port}]
+	return ok && !result.reachable && time.Since(result.checkedAt) < portProbeMaxAge
+}
@@ -0,0 +1,156 @@
+// Package service provides PublicIPService for detecting the public addresses of the panel and agents.
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+const (
+	// publicIPCacheTTL is how long detected addresses are reused.
+	publicIPCacheTTL = time.Hour
+	// publicIPRetryInterval is how long a detection that found no address is reused.
+	publicIPRetryInterval = 5 * time.Minute
+	// publicIPLookupTimeout bounds one request to a resolver.
+	publicIPLookupTimeout = 3 * time.Second
+)
+
+// defaultPublicIPResolvers answer with the plain address of the caller over IPv4 and IPv6.
+var defaultPublicIPResolvers = []string{
+	"https://api64.ipify.org",
+	"https://icanhazip.com",
+	"https://ident.me",
+}
+
+// cgnatNet is the shared address space carrier-grade NAT hands out (RFC 6598).
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIPCache is the last detection and the resolver setting it was made with.
+type publicIPCache struct {
+	resolver  string
+	ipv4      string
+	ipv6      string
+	expiresAt time.Time
+}
+
+var (
+	publicIPMu sync.Mutex
+	publicIPs  publicIPCache
+)
+
+// PublicIPService detects the public IPv4 and IPv6 address of the host it runs on, the
+// panel or an agent.
+type PublicIPService struct {
+	settingService SettingService
+}
+
+// Detect returns the public IPv4 and IPv6 address of this host, empty if unknown. Public
+// addresses assigned to a network interface are used as they are; otherwise, e.g. behind
+// NAT, the resolvers of the publicIpResolver setting are asked over IPv4 and IPv6.
+// Results are cached for an hour, or 5 minutes if no address was found.
+func (s *PublicIPService) Detect() (string, string) {
+	resolver, err := s.settingService.GetPublicIPResolver()
+	if err != nil {
+		logger.Warning("Failed to get public IP resolver:", err)
+	}
+
+	publicIPMu.Lock()
+	defer publicIPMu.Unlock()
+	if publicIPs.resolver == resolver && time.Now().Before(publicIPs.expiresAt) {
+		return publicIPs.ipv4, publicIPs.ipv6
+	}
+
+	ipv4, ipv6 := interfacePublicIPs()
+	if resolver != "off" {
+		resolvers := defaultPublicIPResolvers
+		if resolver != "" {
+			resolvers = []string{resolver}
+		}
+		if ipv4 == "" {
+			ipv4 = lookupPublicIP(resolvers, "tcp4")
+		}
+		if ipv6 == "" {
+			ipv6 = lookupPublicIP(resolvers, "tcp6")
+		}
+	}
+
+	ttl := publicIPCacheTTL
+	if ipv4 == "" && ipv6 == "" {
+		ttl = publicIPRetryInterval
+	}
+	publicIPs = publicIPCache{resolver: resolver, ipv4: ipv4, ipv6: ipv6, expiresAt: time.Now().Add(ttl)}
+	return ipv4, ipv6
+}
+
+// interfacePublicIPs returns the first public IPv4 and IPv6 address assigned to an
+// interface that is up. Private, shared (CGNAT), link-local and loopback addresses are skipped.
+func interfacePublicIPs() (string, string) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", ""
+	}
+	var ipv4, ipv6 string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+				continue
+			}
+			if v4 := ipNet.IP.To4(); v4 != nil {
+				if ipv4 == "" && !cgnatNet.Contains(v4) {
+					ipv4 = v4.String()
+				}
+			} else if ipv6 == "" {
+				ipv6 = ipNet.IP.String()
+			}
+		}
+	}
+	return ipv4, ipv6
+}
+
+// lookupPublicIP asks the resolvers in turn for the address this host connects from over
+// network ("tcp4" or "tcp6") and returns the first valid answer of that family.
+func lookupPublicIP(resolvers []string, network string) string {
+	dialer := &net.Dialer{Timeout: publicIPLookupTimeout}
+	client := &http.Client{
+		Timeout: publicIPLookupTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	for _, resolver := range resolvers {
+		resp, err := client.Get(resolver)
+		if err != nil {
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		ip := net.ParseIP(strings.TrimSpace(string(body)))
+		if ip == nil || (ip.To4() != nil) != (network == "tcp4") {
+			continue
+		}
+		return ip.String()
+	}
+	return ""
+}
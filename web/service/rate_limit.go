@@ -0,0 +1,313 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/xray"
+)
+
+// RateLimitOutboundPrefix prefixes the tags of the marked outbounds that carry the
+// traffic of rate-limited inbounds and clients.
+const RateLimitOutboundPrefix = "ratelimit-"
+
+const (
+	// rateLimitMarkBase is the first socket mark given to a rate limit; the marks of all
+	// limits share the bits of rateLimitMarkMask.
+	rateLimitMarkBase = 0x58550000
+	rateLimitMarkMask = 0xffff0000
+
+	rateLimitQdisc = "7855"          // tc handle of the HTB qdiscs the limits are shaped by
+	rateLimitIfb   = "xui-ifb"       // device incoming traffic is redirected to for shaping
+	rateLimitChain = "XUI-RATELIMIT" // mangle chain that saves marks to their connections
+	rateLimitCeil  = "100gbit"       // rate of the class unlimited traffic goes to
+)
+
+// rateShaping is the last set of limits applied to the host and rateShapingDevice the
+// network device they were applied to. Both are guarded by lock.
+var (
+	rateShaping       string
+	rateShapingDevice string
+)
+
+// rateLimit is an upload and download limit in Mbit/s for the traffic of an inbound or
+// of one of its clients. 0 means unlimited.
+type rateLimit struct {
+	InboundTag string
+	Email      string // Client the limit applies to, "" = all clients of the inbound
+	Up         int
+	Down       int
+}
+
+// inboundRateLimit returns the limit shared by all clients of inbound, if it has one.
+func inboundRateLimit(inbound *model.Inbound) (rateLimit, bool) {
+	limit := rateLimit{InboundTag: inbound.Tag, Up: inbound.SpeedLimitUp, Down: inbound.SpeedLimitDown}
+	return limit, limit.Up > 0 || limit.Down > 0
+}
+
+// clientRateLimit returns the limit of a client from inbound settings, if it has one.
+func clientRateLimit(inboundTag string, client map[string]any) (rateLimit, bool) {
+	email, _ := client["email"].(string)
+	up, _ := client["speedLimitUp"].(float64)
+	down, _ := client["speedLimitDown"].(float64)
+	limit := rateLimit{InboundTag: inboundTag, Email: email, Up: int(up), Down: int(down)}
+	return limit, email != "" && (limit.Up > 0 || limit.Down > 0)
+}
+
+// inboundRateLimits lists the limits of inbound and its enabled clients, to tell whether
+// a change needs Xray to be restarted.
+func inboundRateLimits(inbound *model.Inbound) []rateLimit {
+	var limits []rateLimit
+	if limit, ok := inboundRateLimit(inbound); ok {
+		limits = append(limits, limit)
+	}
+	settings := map[string]any{}
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	clients, _ := settings["clients"].([]any)
+	for _, client := range clients {
+		c, ok := client.(map[string]any)
+		if !ok {
+			continue
+		}
+		if enable, ok := c["enable"].(bool); ok && !enable {
+			continue
+		}
+		if limit, ok := clientRateLimit(inbound.Tag, c); ok {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+// modelClientRateLimit returns the limit in effect for client, or a zero limit if it has
+// none or is disabled. The inbound tag is left empty.
+func modelClientRateLimit(client model.Client) rateLimit {
+	if !client.Enable || client.Email == "" || (client.SpeedUp <= 0 && client.SpeedDown <= 0) {
+		return rateLimit{}
+	}
+	return rateLimit{Email: client.Email, Up: client.SpeedUp, Down: client.SpeedDown}
+}
+
+// rateLimitMark returns the socket mark of the i-th limit.
+func rateLimitMark(i int) int {
+	return rateLimitMarkBase + i + 1
+}
+
+// applyRateLimitRoutes gives each limit a copy of the default (first) outbound that marks
+// its sockets, and routes the limited traffic to it. The rules go last, so only traffic
+// that would leave through the default outbound is limited; client rules come before
+// inbound rules, so a client with its own limit is not also held to the inbound's.
+func applyRateLimitRoutes(xrayConfig *xray.Config, limits []rateLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	var outbounds []any
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+			return err
+		}
+	}
+	base := []byte(`{"protocol": "freedom"}`)
+	if len(outbounds) > 0 {
+		data, err := json.Marshal(outbounds[0])
+		if err != nil {
+			return err
+		}
+		base = data
+	}
+
+	var clientRules, inboundRules []any
+	for i, limit := range limits {
+		tag := fmt.Sprintf("%s%d", RateLimitOutboundPrefix, i+1)
+		outbound := map[string]any{}
+		if err := json.Unmarshal(base, &outbound); err != nil {
+			return err
+		}
+		stream, _ := outbound["streamSettings"].(map[string]any)
+		if stream == nil {
+			stream = map[string]any{}
+		}
+		sockopt, _ := stream["sockopt"].(map[string]any)
+		if sockopt == nil {
+			sockopt = map[string]any{}
+		}
+		sockopt["mark"] = rateLimitMark(i)
+		stream["sockopt"] = sockopt
+		outbound["streamSettings"] = stream
+		outbound["tag"] = tag
+		outbounds = append(outbounds, outbound)
+
+		rule := map[string]any{
+			"type":        "field",
+			"inboundTag":  []string{limit.InboundTag},
+			"outboundTag": tag,
+		}
+		if limit.Email != "" {
+			rule["user"] = []string{limit.Email}
+			clientRules = append(clientRules, rule)
+		} else {
+			inboundRules = append(inboundRules, rule)
+		}
+	}
+	rules, _ := routing["rules"].([]any)
+	rules = append(rules, clientRules...)
+	routing["rules"] = append(rules, inboundRules...)
+
+	routerConfig, err := json.Marshal(routing)
+	if err != nil {
+		return err
+	}
+	outboundConfigs, err := json.Marshal(outbounds)
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = routerConfig
+	xrayConfig.OutboundConfigs = outboundConfigs
+	return nil
+}
+
+// shapeRateLimits shapes the marked traffic of each limit with tc on the device of the
+// default route: uploads as they leave it, downloads by redirecting incoming traffic to
+// an ifb device after restoring the mark of its connection. It does nothing if the
+// limits are the ones applied last. Must be called with lock held.
+func shapeRateLimits(limits []rateLimit) error {
+	plan := fmt.Sprint(limits)
+	if plan == rateShaping {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		if len(limits) > 0 {
+			return errors.New("rate limits are only enforced on Linux")
+		}
+		rateShaping = plan
+		return nil
+	}
+
+	dev, err := defaultRouteDevice()
+	if err != nil {
+		if len(limits) == 0 {
+			rateShaping = plan
+			return nil
+		}
+		return err
+	}
+	if rateShapingDevice != "" {
+		clearRateShaping(rateShapingDevice)
+	}
+	clearRateShaping(dev)
+	rateShapingDevice = ""
+	if len(limits) == 0 {
+		rateShaping = plan
+		return nil
+	}
+
+	for _, args := range rateShapingCommands(dev, limits) {
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			clearRateShaping(dev)
+			return fmt.Errorf("%s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	// IPv6 may be disabled or lack ip6tables; IPv4 limits still work then
+	if out, err := exec.Command("ip6tables", "-t", "mangle", "-N", rateLimitChain).CombinedOutput(); err == nil {
+		exec.Command("ip6tables", rateLimitSaveMark()...).Run()
+		exec.Command("ip6tables", "-t", "mangle", "-I", "OUTPUT", "-j", rateLimitChain).Run()
+	} else {
+		logger.Debug("Rate limits not applied to IPv6:", strings.TrimSpace(string(out)))
+	}
+	rateShaping, rateShapingDevice = plan, dev
+	logger.Infof("Shaping %d rate limits on %s", len(limits), dev)
+	return nil
+}
+
+// rateShapingCommands returns the ip, tc and iptables commands that shape limits on dev.
+func rateShapingCommands(dev string, limits []rateLimit) [][]string {
+	root := rateLimitQdisc + ":"
+	cmds := [][]string{
+		{"ip", "link", "add", rateLimitIfb, "type", "ifb"},
+		{"ip", "link", "set", rateLimitIfb, "up"},
+		{"iptables", "-t", "mangle", "-N", rateLimitChain},
+		append([]string{"iptables"}, rateLimitSaveMark()...),
+		{"iptables", "-t", "mangle", "-I", "OUTPUT", "-j", rateLimitChain},
+		{"tc", "qdisc", "add", "dev", dev, "handle", "ffff:", "ingress"},
+		{"tc", "filter", "add", "dev", dev, "parent", "ffff:", "protocol", "all", "prio", "1", "matchall",
+			"action", "connmark", "action", "mirred", "egress", "redirect", "dev", rateLimitIfb},
+	}
+	for _, target := range []string{dev, rateLimitIfb} {
+		cmds = append(cmds,
+			[]string{"tc", "qdisc", "add", "dev", target, "root", "handle", root, "htb", "default", "1"},
+			[]string{"tc", "class", "add", "dev", target, "parent", root, "classid", root + "1", "htb", "rate", rateLimitCeil},
+		)
+		for i, limit := range limits {
+			rate := limit.Up
+			if target == rateLimitIfb {
+				rate = limit.Down
+			}
+			if rate <= 0 {
+				continue
+			}
+			class := fmt.Sprintf("%s%x", root, i+2)
+			cmds = append(cmds,
+				[]string{"tc", "class", "add", "dev", target, "parent", root, "classid", class, "htb", "rate", fmt.Sprintf("%dmbit", rate)},
+				[]string{"tc", "filter", "add", "dev", target, "parent", root, "protocol", "all", "prio", "1",
+					"handle", fmt.Sprintf("%#x", rateLimitMark(i)), "fw", "flowid", class},
+			)
+		}
+	}
+	return cmds
+}
+
+// rateLimitSaveMark returns the iptables arguments that copy the mark of limited
+// sockets to their connections, so replies can be matched on the way in.
+func rateLimitSaveMark() []string {
+	return []string{"-t", "mangle", "-A", rateLimitChain, "-m", "mark", "--mark",
+		fmt.Sprintf("%#x/%#x", rateLimitMarkBase, rateLimitMarkMask), "-j", "CONNMARK", "--save-mark"}
+}
+
+// clearRateShaping removes what shapeRateLimits set up on dev. Only the qdiscs created
+// for the limits are removed, so a missing setup is not an error.
+func clearRateShaping(dev string) {
+	if exec.Command("ip", "link", "show", rateLimitIfb).Run() == nil {
+		exec.Command("tc", "qdisc", "del", "dev", dev, "ingress").Run()
+		exec.Command("ip", "link", "del", rateLimitIfb).Run()
+	}
+	if out, err := exec.Command("tc", "qdisc", "show", "dev", dev, "root").Output(); err == nil &&
+		strings.Contains(string(out), "htb "+rateLimitQdisc+":") {
+		exec.Command("tc", "qdisc", "del", "dev", dev, "root").Run()
+	}
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		exec.Command(iptables, "-t", "mangle", "-D", "OUTPUT", "-j", rateLimitChain).Run()
+		exec.Command(iptables, "-t", "mangle", "-F", rateLimitChain).Run()
+		exec.Command(iptables, "-t", "mangle", "-X", rateLimitChain).Run()
+	}
+}
+
+// defaultRouteDevice returns the network device of the IPv4 default route, or of the
+// IPv6 one on IPv6-only hosts.
+func defaultRouteDevice() (string, error) {
+	for _, family := range []string{"-4", "-6"} {
+		out, err := exec.Command("ip", family, "route", "show", "default").Output()
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(out))
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "dev" {
+				return fields[i+1], nil
+			}
+		}
+	}
+	return "", errors.New("no default route to shape rate limits on")
+}
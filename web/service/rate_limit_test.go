@@ -0,0 +1,95 @@
+package service_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestRateLimitRoutes checks that limited inbounds and clients are routed to marked
+// copies of the default outbound, client rules first, and that the limits stay out of
+// the inbound config Xray sees.
+func TestRateLimitRoutes(t *testing.T) {
+	db := database.GetDB()
+	inbound := &model.Inbound{
+		Protocol:       model.VLESS,
+		Port:           20401,
+		Tag:            "inbound-20401",
+		Enable:         true,
+		SpeedLimitDown: 20,
+		Settings: `{"clients": [
+			{"id": "5b8a3c61-1f2e-4c3d-9a7b-2d6e8f0a1b2c", "email": "limited", "enable": true, "speedLimitUp": 5},
+			{"id": "6c9b4d72-2a3f-4d4e-8b8c-3e7f9a1b2c3d", "email": "shared", "enable": true}
+		], "decryption": "none"}`,
+	}
+	if err := db.Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Delete(&model.Inbound{}, inbound.Id)
+
+	xrayService := &service.XrayService{}
+	xrayConfig, err := xrayService.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outbounds []map[string]any
+	if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+		t.Fatal(err)
+	}
+	marks := map[string]float64{}
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		if !strings.HasPrefix(tag, service.RateLimitOutboundPrefix) {
+			continue
+		}
+		if outbound["protocol"] != outbounds[0]["protocol"] {
+			t.Errorf("%s: expected a copy of the default outbound, got protocol %v", tag, outbound["protocol"])
+		}
+		stream, _ := outbound["streamSettings"].(map[string]any)
+		sockopt, _ := stream["sockopt"].(map[string]any)
+		mark, _ := sockopt["mark"].(float64)
+		if mark == 0 {
+			t.Errorf("%s: expected a socket mark", tag)
+		}
+		marks[tag] = mark
+	}
+	if len(marks) != 2 {
+		t.Fatalf("expected 2 rate limit outbounds, got %v", marks)
+	}
+
+	var routing struct {
+		Rules []struct {
+			InboundTag  []string `json:"inboundTag"`
+			User        []string `json:"user"`
+			OutboundTag string   `json:"outboundTag"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+		t.Fatal(err)
+	}
+	rules := routing.Rules
+	if len(rules) < 2 {
+		t.Fatalf("expected the rate limit rules, got %+v", rules)
+	}
+	client, shared := rules[len(rules)-2], rules[len(rules)-1]
+	if len(client.User) != 1 || client.User[0] != "limited" || marks[client.OutboundTag] == 0 {
+		t.Errorf("expected the client rule before the last one, got %+v", client)
+	}
+	if len(shared.User) != 0 || shared.InboundTag[0] != inbound.Tag || marks[shared.OutboundTag] == 0 {
+		t.Errorf("expected the inbound rule last, got %+v", shared)
+	}
+	if client.OutboundTag == shared.OutboundTag {
+		t.Errorf("expected the client and the inbound to have their own outbounds")
+	}
+
+	for _, config := range xrayConfig.InboundConfigs {
+		if config.Tag == inbound.Tag && strings.Contains(string(config.Settings), "speedLimit") {
+			t.Errorf("expected the limits to be removed from the inbound settings, got %s", config.Settings)
+		}
+	}
+}
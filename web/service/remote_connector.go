@@ -3,21 +3,33 @@ package service
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
+	"github.com/cofedish/3x-UI-agents/util/redact"
 	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/gorilla/websocket"
 )
 
 // RemoteConnector implements ServerConnector for remote agent-managed servers.
@@ -25,40 +37,94 @@ type RemoteConnector struct {
 	serverId   int
 	endpoint   string
 	authType   string
-	jwtToken   string // JWT bearer token (empty for mTLS)
+	jwtTokens  *agentTokenSource // JWT bearer tokens (nil for mTLS)
 	httpClient *http.Client
+	failures   atomic.Int32 // Requests in a row that did not reach the agent
+}
+
+// ErrAgentUnreachable wraps errors of requests that never reached the agent, as opposed
+// to requests the agent rejected or that failed after they were sent.
+var ErrAgentUnreachable = errors.New("agent unreachable")
+
+// ConfigEpochHeader carries the config epoch of a change sent to an agent. Agents record
+// it once the change is applied and report it in /health.
+const ConfigEpochHeader = "X-Config-Epoch"
+
+// ErrReadOnly is returned for changes sent to an agent while the panel is in read-only mode.
+var ErrReadOnly = errors.New("panel is in read-only mode")
+
+// AgentConflictError is returned when the agent rejects an update based on an outdated
+// version of an object (HTTP 409). Current holds the object as it is now.
+type AgentConflictError struct {
+	Message string
+	Current json.RawMessage
+}
+
+func (e *AgentConflictError) Error() string {
+	return "agent conflict: " + e.Message
 }
 
 // AgentResponse is the standard response format from agent API.
 type AgentResponse struct {
 	Success bool            `json:"success"`
 	Data    json.RawMessage `json:"data,omitempty"`
+	Page    *AgentPage      `json:"page,omitempty"`   // Set for paginated listings
+	Cursor  int64           `json:"cursor,omitempty"` // Set for incremental listings
 	Error   *AgentError     `json:"error,omitempty"`
 	TraceId string          `json:"trace_id,omitempty"`
 }
 
+// AgentPage describes the page of a paginated agent listing.
+type AgentPage struct {
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	Total  int64 `json:"total"`
+}
+
+const (
+	// inboundPageSize is the number of inbounds fetched per request when listing all inbounds.
+	inboundPageSize = 100
+	// clientTrafficPageSize is the number of client traffics fetched per request.
+	clientTrafficPageSize = 1000
+	// taskPollInterval is how often the progress of a background operation is polled.
+	taskPollInterval = 2 * time.Second
+)
+
 // AgentError represents an error from the agent API.
 type AgentError struct {
-	Code    string                 `json:"code"`
-	Message string                 `json:"message"`
-	Details map[string]interface{} `json:"details,omitempty"`
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details,omitempty"`
 }
 
 // NewRemoteConnector creates a new RemoteConnector for a remote server.
 func NewRemoteConnector(server *model.Server) (*RemoteConnector, error) {
+	// Endpoints saved before normalization existed may lack a scheme or port
+	endpoint, err := NormalizeEndpoint(server.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
 	connector := &RemoteConnector{
 		serverId: server.Id,
-		endpoint: server.Endpoint,
+		endpoint: endpoint,
 		authType: server.AuthType,
 	}
 
+	// Auth data may be encrypted at rest or live in an external secret store
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	authData, err := openAuthData(ctx, server.AuthData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auth data: %w", err)
+	}
+
 	// Initialize HTTP client and auth based on auth type
-	var err error
 	switch server.AuthType {
 	case "mtls":
-		connector.httpClient, err = createMTLSClient(server)
+		connector.httpClient, err = createMTLSClient(authData)
 	case "jwt":
-		connector.httpClient, connector.jwtToken, err = createJWTClient(server)
+		connector.httpClient, connector.jwtTokens, err = createJWTClient(authData)
 	default:
 		return nil, fmt.Errorf("unsupported auth type: %s", server.AuthType)
 	}
@@ -71,11 +137,12 @@ func NewRemoteConnector(server *model.Server) (*RemoteConnector, error) {
 }
 
 // createMTLSClient creates an HTTP client with mTLS authentication.
-func createMTLSClient(server *model.Server) (*http.Client, error) {
+func createMTLSClient(raw string) (*http.Client, error) {
 	// Parse auth data. We support:
 	// 1) JSON with file paths: { "certFile": "...", "keyFile": "...", "caFile": "..." }
 	// 2) JSON with PEM contents: { "certPem": "...", "keyPem": "...", "caPem": "..." }
 	// 3) Raw PEM bundle (cert + key + ca) pasted as a single string.
+	// PEM fields may also be secret references, e.g. "vault:secret/data/agent#keyPem".
 	var authData struct {
 		CertFile string `json:"certFile"`
 		KeyFile  string `json:"keyFile"`
@@ -85,8 +152,14 @@ func createMTLSClient(server *model.Server) (*http.Client, error) {
 		CAPem    string `json:"caPem"`
 	}
 
-	raw := server.AuthData
 	_ = json.Unmarshal([]byte(raw), &authData) // best effort
+	for _, field := range []*string{&authData.CertPem, &authData.KeyPem, &authData.CAPem} {
+		value, err := resolveSecret(*field)
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
 
 	// Try PEM contents first (inlined)
 	var cert tls.Certificate
@@ -162,11 +235,14 @@ func createMTLSClient(server *model.Server) (*http.Client, error) {
 		MinVersion:   tls.VersionTLS13,
 	}
 
-	// Create HTTP client
+	// Create HTTP client; the transport keeps connections to the agent alive between
+	// requests of the pooled connector
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: 8,
+			IdleConnTimeout:     90 * time.Second,
 		},
 	}
 
@@ -199,24 +275,50 @@ func splitPEMBundle(data []byte) (certPEM, keyPEM, caPEM []byte) {
 }
 
 // createJWTClient creates an HTTP client with JWT authentication.
-// Returns the HTTP client and the JWT token to be used in Authorization header.
-func createJWTClient(server *model.Server) (*http.Client, string, error) {
-	// Parse auth data (JSON with JWT token or raw token string)
-	var token string
+// Returns the HTTP client and the source of the tokens for the Authorization header:
+// auth data with a "secret", or a raw agent secret, issues signed short-lived tokens; a
+// "token", or raw auth data that already is a JWT, is sent as it is.
+func createJWTClient(raw string) (*http.Client, *agentTokenSource, error) {
+	tokens := &agentTokenSource{}
 	var authData struct {
-		Token string `json:"token"`
+		Token    string `json:"token"`
+		Secret   string `json:"secret"`
+		Audience string `json:"audience"`
+		TTL      int    `json:"ttl"` // seconds
 	}
 
 	// Try parsing as JSON first
-	if err := json.Unmarshal([]byte(server.AuthData), &authData); err == nil && authData.Token != "" {
-		token = authData.Token
+	if err := json.Unmarshal([]byte(raw), &authData); err == nil && (authData.Token != "" || authData.Secret != "") {
+		// Both may be secret references, e.g. "env:AGENT01_TOKEN"
+		if authData.Secret != "" {
+			secret, err := resolveSecret(authData.Secret)
+			if err != nil {
+				return nil, nil, err
+			}
+			tokens.secret = secret
+			tokens.audience = authData.Audience
+			tokens.ttl = time.Duration(authData.TTL) * time.Second
+			if tokens.ttl <= 0 {
+				tokens.ttl = defaultAgentTokenTTL
+			}
+		} else {
+			resolved, err := resolveSecret(authData.Token)
+			if err != nil {
+				return nil, nil, err
+			}
+			tokens.static = resolved
+		}
+	} else if strings.Count(raw, ".") == 2 {
+		// A pre-issued JWT
+		tokens.static = raw
 	} else {
-		// If not JSON, treat the whole AuthData as the token
-		token = server.AuthData
+		// Otherwise the whole AuthData is the agent's AGENT_JWT_SECRET
+		tokens.secret = raw
+		tokens.ttl = defaultAgentTokenTTL
 	}
 
-	if token == "" {
-		return nil, "", fmt.Errorf("JWT token is required in auth data")
+	if tokens.static == "" && tokens.secret == "" {
+		return nil, nil, fmt.Errorf("JWT secret or token is required in auth data")
 	}
 
 	// Create standard HTTPS client
@@ -224,11 +326,63 @@ func createJWTClient(server *model.Server) (*http.Client, string, error) {
 		Timeout: 30 * time.Second,
 	}
 
-	return client, token, nil
+	return client, tokens, nil
 }
 
 // doRequest performs an HTTP request to the agent API.
 func (c *RemoteConnector) doRequest(ctx context.Context, method, path string, body interface{}) (*AgentResponse, error) {
+	return c.send(ctx, method, path, body, 0)
+}
+
+// requestNotSent reports whether a transport error happened before the request reached
+// the agent, so the agent cannot have applied it. A timeout or a dropped connection after
+// the request was written leaves that open, and such requests must not be queued for replay.
+func requestNotSent(err error) bool {
+	// "remote error" is a TLS alert: the agent aborted the handshake, e.g. on a bad client cert
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect" || opErr.Op == "remote error") {
+		return true
+	}
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	return errors.As(err, &dnsErr) || errors.As(err, &certErr)
+}
+
+// doConfigRequest sends a config change to the agent with the next config epoch of the
+// server and records that epoch once the agent applied the change, so the health check
+// can tell whether the agent has every change the panel made.
+func (c *RemoteConnector) doConfigRequest(ctx context.Context, method, path string, body interface{}) (*AgentResponse, error) {
+	db := database.GetDB()
+	var epoch int
+	db.Model(model.Server{}).Where("id = ?", c.serverId).Pluck("config_epoch", &epoch)
+	epoch++
+
+	resp, err := c.send(ctx, method, path, body, epoch)
+	if err != nil {
+		return nil, err
+	}
+	// Concurrent changes may carry the same epoch, so it only moves forward
+	err = db.Model(model.Server{}).Where("id = ? AND config_epoch < ?", c.serverId, epoch).Update("config_epoch", epoch).Error
+	if err != nil {
+		logger.Warning("Failed to record config epoch of server", c.serverId, ":", err)
+	}
+	return resp, nil
+}
+
+// send performs an HTTP request to the agent API, with the config epoch header if epoch > 0.
+func (c *RemoteConnector) send(ctx context.Context, method, path string, body interface{}, epoch int) (result *AgentResponse, err error) {
+	// Read-only mode stops every change at the fan-out to the agents; backups only read
+	if method != http.MethodGet && path != "/api/v1/backup" && (&SettingService{}).IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+
+	// Count and time every request for the connector metrics
+	start := time.Now()
+	status := 0
+	defer func() {
+		recordConnectorRequest(c.serverId, method, path, status, err, time.Since(start))
+	}()
+
 	url := c.endpoint + path
 
 	var reqBody io.Reader
@@ -246,10 +400,17 @@ func (c *RemoteConnector) doRequest(ctx context.Context, method, path string, bo
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if epoch > 0 {
+		req.Header.Set(ConfigEpochHeader, strconv.Itoa(epoch))
+	}
 
 	// For JWT auth, add Authorization header
-	if c.authType == "jwt" && c.jwtToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.jwtToken)
+	if c.authType == "jwt" && c.jwtTokens != nil {
+		token, err := c.jwtTokens.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue agent token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
 	// Log request before sending
@@ -258,9 +419,15 @@ func (c *RemoteConnector) doRequest(ctx context.Context, method, path string, bo
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		logger.Error("HTTP CLIENT ERROR:", method, url, "error:", err)
+		c.failures.Add(1)
+		if requestNotSent(err) {
+			return nil, fmt.Errorf("request failed: %w: %w", ErrAgentUnreachable, err)
+		}
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	c.failures.Store(0)
+	status = resp.StatusCode
 
 	logger.Error("RECEIVED RESPONSE:", method, url, "status:", resp.StatusCode)
 
@@ -270,11 +437,30 @@ func (c *RemoteConnector) doRequest(ctx context.Context, method, path string, bo
 	}
 
 	// Log response for debugging
-	logger.Error("Agent response:", method, path, "status:", resp.StatusCode, "bodyLen:", len(respData), "body:", string(respData))
+	logger.Error("Agent response:", method, path, "status:", resp.StatusCode, "bodyLen:", len(respData), "body:", redact.String(string(respData)))
+
+	// A conflict carries the current object, so pass it on as an *AgentConflictError
+	if resp.StatusCode == http.StatusConflict {
+		var agentResp AgentResponse
+		if err := json.Unmarshal(respData, &agentResp); err == nil && agentResp.Error != nil {
+			return nil, &AgentConflictError{Message: agentResp.Error.Message, Current: agentResp.Data}
+		}
+	}
+
+	// Field errors of a rejected payload are passed on as a *ValidationError
+	if resp.StatusCode == http.StatusBadRequest {
+		var agentResp AgentResponse
+		if err := json.Unmarshal(respData, &agentResp); err == nil && agentResp.Error != nil && agentResp.Error.Code == "VALIDATION_FAILED" {
+			var fields []*FieldError
+			if json.Unmarshal(agentResp.Error.Details, &fields) == nil && len(fields) > 0 {
+				return nil, &ValidationError{Fields: fields}
+			}
+		}
+	}
 
 	// Check for non-200 status codes
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", resp.StatusCode, string(respData))
+		return nil, fmt.Errorf("agent returned status %d: %s", resp.StatusCode, redact.String(string(respData)))
 	}
 
 	// Check for empty response
@@ -284,7 +470,7 @@ func (c *RemoteConnector) doRequest(ctx context.Context, method, path string, bo
 
 	var agentResp AgentResponse
 	if err := json.Unmarshal(respData, &agentResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response (body: %s): %w", string(respData), err)
+		return nil, fmt.Errorf("failed to parse response (body: %s): %w", redact.String(string(respData)), err)
 	}
 
 	if !agentResp.Success {
@@ -333,16 +519,32 @@ func (c *RemoteConnector) GetHealth(ctx context.Context) (*HealthStatus, error)
 	return &health, nil
 }
 
-// ListInbounds retrieves inbounds from the agent.
+// ListInbounds retrieves inbounds from the agent. The list is cached for inboundCacheTTL
+// and dropped whenever the inbounds of the server are changed through the panel.
 func (c *RemoteConnector) ListInbounds(ctx context.Context) ([]*model.Inbound, error) {
-	resp, err := c.doRequest(ctx, "GET", "/api/v1/inbounds", nil)
+	inbounds, ok, gen := cachedInbounds(c.serverId)
+	if ok {
+		return inbounds, nil
+	}
+	inbounds, err := fetchAllPages(ctx, inboundPageSize, c.ListInboundsPage)
 	if err != nil {
 		return nil, err
 	}
+	storeInbounds(c.serverId, gen, inbounds)
+	return inbounds, nil
+}
+
+// ListInboundsPage retrieves one page of inbounds from the agent. The returned page is
+// nil if the agent does not support pagination, in which case all inbounds are returned.
+func (c *RemoteConnector) ListInboundsPage(ctx context.Context, limit, offset int) ([]*model.Inbound, *AgentPage, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/api/v1/inbounds?limit=%d&offset=%d", limit, offset), nil)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var inbounds []*model.Inbound
 	if err := json.Unmarshal(resp.Data, &inbounds); err != nil {
-		return nil, fmt.Errorf("failed to parse inbounds: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse inbounds: %w", err)
 	}
 
 	// Set server_id for all inbounds
@@ -350,7 +552,7 @@ func (c *RemoteConnector) ListInbounds(ctx context.Context) ([]*model.Inbound, e
 		inbound.ServerId = c.serverId
 	}
 
-	return inbounds, nil
+	return inbounds, resp.Page, nil
 }
 
 // GetInbound retrieves a specific inbound from the agent.
@@ -371,51 +573,92 @@ func (c *RemoteConnector) GetInbound(ctx context.Context, id int) (*model.Inboun
 
 // AddInbound adds a new inbound via the agent.
 func (c *RemoteConnector) AddInbound(ctx context.Context, inbound *model.Inbound) error {
-	_, err := c.doRequest(ctx, "POST", "/api/v1/inbounds", inbound)
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doConfigRequest(ctx, "POST", "/api/v1/inbounds", inbound)
+	if err == nil {
+		recordServerEvent(c.serverId, "config", fmt.Sprintf("Inbound %s (port %d) added", inbound.Remark, inbound.Port))
+	}
 	return err
 }
 
 // UpdateInbound updates an existing inbound via the agent.
 func (c *RemoteConnector) UpdateInbound(ctx context.Context, inbound *model.Inbound) error {
-	_, err := c.doRequest(ctx, "PUT", fmt.Sprintf("/api/v1/inbounds/%d", inbound.Id), inbound)
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doConfigRequest(ctx, "PUT", fmt.Sprintf("/api/v1/inbounds/%d", inbound.Id), inbound)
+	var conflictErr *AgentConflictError
+	if errors.As(err, &conflictErr) {
+		current := &model.Inbound{}
+		if json.Unmarshal(conflictErr.Current, current) == nil && current.Id != 0 {
+			current.ServerId = c.serverId
+			return &InboundConflictError{Current: current}
+		}
+	}
+	if err == nil {
+		recordServerEvent(c.serverId, "config", fmt.Sprintf("Inbound %d (%s) updated", inbound.Id, inbound.Remark))
+	}
 	return err
 }
 
-// DeleteInbound deletes an inbound via the agent.
+// DeleteInbound deletes an inbound via the agent. The inbound is archived on the panel
+// first, so it can be restored; the archive is dropped again if the deletion fails.
 func (c *RemoteConnector) DeleteInbound(ctx context.Context, id int) error {
+	defer InvalidateInboundCache(c.serverId)
+	inbound, err := c.GetInbound(ctx, id)
+	if err != nil {
+		return err
+	}
+	archives := InboundArchiveService{}
+	archive, err := archives.Archive(c.serverId, inbound)
+	if err != nil {
+		return fmt.Errorf("failed to archive inbound %d: %w", id, err)
+	}
+
 	path := fmt.Sprintf("/api/v1/inbounds/%d", id)
 	fullURL := c.endpoint + path
 	logger.Error("RemoteConnector.DeleteInbound CALLED: serverId=", c.serverId, "id=", id, "fullURL=", fullURL)
-	_, err := c.doRequest(ctx, "DELETE", path, nil)
+	_, err = c.doConfigRequest(ctx, "DELETE", path, nil)
 	if err != nil {
 		logger.Error("RemoteConnector.DeleteInbound FAILED:", err)
+		archives.DeleteArchive(archive.Id)
 	} else {
 		logger.Error("RemoteConnector.DeleteInbound SUCCESS")
+		recordServerEvent(c.serverId, "config", fmt.Sprintf("Inbound %d deleted", id))
 	}
 	return err
 }
 
 // AddClient adds a client to an inbound via the agent.
 func (c *RemoteConnector) AddClient(ctx context.Context, inbound *model.Inbound) error {
-	_, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/inbounds/%d/clients", inbound.Id), inbound)
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doConfigRequest(ctx, "POST", fmt.Sprintf("/api/v1/inbounds/%d/clients", inbound.Id), inbound)
 	return err
 }
 
 // UpdateClient updates a client via the agent.
 func (c *RemoteConnector) UpdateClient(ctx context.Context, inbound *model.Inbound, clientIndex int) error {
-	_, err := c.doRequest(ctx, "PUT", fmt.Sprintf("/api/v1/inbounds/%d/clients/%d", inbound.Id, clientIndex), inbound)
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doConfigRequest(ctx, "PUT", fmt.Sprintf("/api/v1/inbounds/%d/clients/%d", inbound.Id, clientIndex), inbound)
 	return err
 }
 
 // DeleteClient deletes a client from an inbound via the agent.
 func (c *RemoteConnector) DeleteClient(ctx context.Context, inboundId int, clientEmail string) error {
-	_, err := c.doRequest(ctx, "DELETE", fmt.Sprintf("/api/v1/inbounds/%d/clients/%s", inboundId, clientEmail), nil)
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doConfigRequest(ctx, "DELETE", fmt.Sprintf("/api/v1/inbounds/%d/clients/%s", inboundId, url.PathEscape(clientEmail)), nil)
 	return err
 }
 
 // ResetClientTraffic resets client traffic via the agent.
 func (c *RemoteConnector) ResetClientTraffic(ctx context.Context, inboundId int, email string) error {
-	_, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/inbounds/%d/clients/%s/reset-traffic", inboundId, email), nil)
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/inbounds/%d/clients/%s/reset-traffic", inboundId, url.PathEscape(email)), nil)
+	return err
+}
+
+// ResetInboundTraffic resets the traffic of an inbound and its clients on the agent.
+func (c *RemoteConnector) ResetInboundTraffic(ctx context.Context, inboundId int) error {
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doRequest(ctx, "POST", fmt.Sprintf("/api/v1/inbounds/%d/reset-traffic", inboundId), nil)
 	return err
 }
 
@@ -434,6 +677,25 @@ func (c *RemoteConnector) GetOnlineClients(ctx context.Context) ([]string, error
 	return emails, nil
 }
 
+// GetOnlineClientDetails retrieves online clients with source IPs and GeoIP data from the agent.
+func (c *RemoteConnector) GetOnlineClientDetails(ctx context.Context) ([]*OnlineClient, error) {
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/clients/online/details", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []*OnlineClient
+	if err := json.Unmarshal(resp.Data, &clients); err != nil {
+		return nil, fmt.Errorf("failed to parse online client details: %w", err)
+	}
+
+	for _, client := range clients {
+		client.ServerId = c.serverId
+	}
+
+	return clients, nil
+}
+
 // GetTraffic retrieves traffic statistics from the agent.
 func (c *RemoteConnector) GetTraffic(ctx context.Context, reset bool) (*xray.Traffic, error) {
 	path := "/api/v1/traffic"
@@ -456,14 +718,54 @@ func (c *RemoteConnector) GetTraffic(ctx context.Context, reset bool) (*xray.Tra
 
 // GetClientTraffics retrieves client traffic statistics from the agent.
 func (c *RemoteConnector) GetClientTraffics(ctx context.Context) ([]*xray.ClientTraffic, error) {
-	resp, err := c.doRequest(ctx, "GET", "/api/v1/traffic/clients", nil)
+	return fetchAllPages(ctx, clientTrafficPageSize, c.GetClientTrafficsPage)
+}
+
+// GetClientTrafficsPage retrieves one page of client traffics from the agent. The returned
+// page is nil if the agent does not support pagination, in which case all rows are returned.
+func (c *RemoteConnector) GetClientTrafficsPage(ctx context.Context, limit, offset int) ([]*xray.ClientTraffic, *AgentPage, error) {
+	traffics, resp, err := c.getClientTrafficsPage(ctx, 0, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return traffics, resp.Page, nil
+}
+
+// GetClientTrafficsSince retrieves the client traffics the agent updated since the cursor.
+// Agents without cursor support return all rows and a cursor of 0.
+func (c *RemoteConnector) GetClientTrafficsSince(ctx context.Context, since int64) ([]*xray.ClientTraffic, int64, error) {
+	var cursor int64
+	traffics, err := fetchAllPages(ctx, clientTrafficPageSize, func(ctx context.Context, limit, offset int) ([]*xray.ClientTraffic, *AgentPage, error) {
+		traffics, resp, err := c.getClientTrafficsPage(ctx, since, limit, offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The first page's cursor predates every later page, so nothing is skipped
+		if offset == 0 {
+			cursor = resp.Cursor
+		}
+		return traffics, resp.Page, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return traffics, cursor, nil
+}
+
+// getClientTrafficsPage retrieves one page of the client traffics updated since the cursor.
+func (c *RemoteConnector) getClientTrafficsPage(ctx context.Context, since int64, limit, offset int) ([]*xray.ClientTraffic, *AgentResponse, error) {
+	path := fmt.Sprintf("/api/v1/traffic/clients?limit=%d&offset=%d", limit, offset)
+	if since > 0 {
+		path += fmt.Sprintf("&since=%d", since)
+	}
+	resp, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var traffics []*xray.ClientTraffic
 	if err := json.Unmarshal(resp.Data, &traffics); err != nil {
-		return nil, fmt.Errorf("failed to parse client traffics: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse client traffics: %w", err)
 	}
 
 	// Set server_id
@@ -471,24 +773,83 @@ func (c *RemoteConnector) GetClientTraffics(ctx context.Context) ([]*xray.Client
 		traffic.ServerId = c.serverId
 	}
 
+	return traffics, resp, nil
+}
+
+// fetchAllPages walks a paginated agent listing until all rows are fetched. Agents
+// without pagination answer the first request with the full listing and no page.
+func fetchAllPages[T any](ctx context.Context, pageSize int, fetch func(ctx context.Context, limit, offset int) ([]T, *AgentPage, error)) ([]T, error) {
+	all := make([]T, 0)
+	for offset := 0; ; {
+		items, page, err := fetch(ctx, pageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		offset += len(items)
+		if page == nil || len(items) == 0 || int64(offset) >= page.Total {
+			return all, nil
+		}
+	}
+}
+
+// GetOutboundTraffics retrieves outbound traffic statistics from the agent.
+func (c *RemoteConnector) GetOutboundTraffics(ctx context.Context) ([]*model.OutboundTraffics, error) {
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/traffic/outbounds", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var traffics []*model.OutboundTraffics
+	if err := json.Unmarshal(resp.Data, &traffics); err != nil {
+		return nil, fmt.Errorf("failed to parse outbound traffics: %w", err)
+	}
+
+	for _, traffic := range traffics {
+		traffic.ServerId = c.serverId
+	}
+
 	return traffics, nil
 }
 
+// GetConnectionCounts retrieves the established TCP connections per local port from the agent.
+func (c *RemoteConnector) GetConnectionCounts(ctx context.Context) (map[int]int, error) {
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/system/connections", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts map[int]int
+	if err := json.Unmarshal(resp.Data, &counts); err != nil {
+		return nil, fmt.Errorf("failed to parse connection counts: %w", err)
+	}
+	return counts, nil
+}
+
 // StartXray starts Xray on the agent.
 func (c *RemoteConnector) StartXray(ctx context.Context) error {
 	_, err := c.doRequest(ctx, "POST", "/api/v1/xray/start", nil)
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray started")
+	}
 	return err
 }
 
 // StopXray stops Xray on the agent.
 func (c *RemoteConnector) StopXray(ctx context.Context) error {
 	_, err := c.doRequest(ctx, "POST", "/api/v1/xray/stop", nil)
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray stopped")
+	}
 	return err
 }
 
 // RestartXray restarts Xray on the agent.
 func (c *RemoteConnector) RestartXray(ctx context.Context) error {
 	_, err := c.doRequest(ctx, "POST", "/api/v1/xray/restart", nil)
+	if err == nil {
+		recordServerEvent(c.serverId, "xray", "Xray restarted")
+	}
 	return err
 }
 
@@ -526,6 +887,51 @@ func (c *RemoteConnector) GetXrayConfig(ctx context.Context) (string, error) {
 	return configResp.Config, nil
 }
 
+// GetCapabilities retrieves the optional Xray features supported by the agent's core.
+func (c *RemoteConnector) GetCapabilities(ctx context.Context) (*XrayCapabilities, error) {
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/xray/capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var caps XrayCapabilities
+	if err := json.Unmarshal(resp.Data, &caps); err != nil {
+		return nil, fmt.Errorf("failed to parse capabilities: %w", err)
+	}
+
+	return &caps, nil
+}
+
+// XrayAddUser adds a user to a running inbound on the agent via its Xray API.
+func (c *RemoteConnector) XrayAddUser(ctx context.Context, req *XrayUserRequest) error {
+	_, err := c.doRequest(ctx, "POST", "/api/v1/xray/api/users", req)
+	return err
+}
+
+// XrayRemoveUser removes a user from a running inbound on the agent via its Xray API.
+func (c *RemoteConnector) XrayRemoveUser(ctx context.Context, inboundTag, email string) error {
+	path := fmt.Sprintf("/api/v1/xray/api/inbounds/%s/users/%s", url.PathEscape(inboundTag), url.PathEscape(email))
+	_, err := c.doRequest(ctx, "DELETE", path, nil)
+	return err
+}
+
+// XrayQueryStats queries Xray counters on the agent via its Xray API.
+func (c *RemoteConnector) XrayQueryStats(ctx context.Context, pattern string) (map[string]int64, error) {
+	query := url.Values{}
+	query.Set("pattern", pattern)
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/xray/api/stats?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats map[string]int64
+	if err := json.Unmarshal(resp.Data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse stats: %w", err)
+	}
+
+	return stats, nil
+}
+
 // GetSystemStats retrieves system statistics from the agent.
 func (c *RemoteConnector) GetSystemStats(ctx context.Context) (*SystemStats, error) {
 	resp, err := c.doRequest(ctx, "GET", "/api/v1/system/stats", nil)
@@ -556,19 +962,184 @@ func (c *RemoteConnector) GetLogs(ctx context.Context, count int) ([]string, err
 	return logs, nil
 }
 
+// StreamLogs live-tails a log of the agent over the WebSocket endpoint of the agent API.
+func (c *RemoteConnector) StreamLogs(ctx context.Context, req *LogStreamRequest, send func(*logtail.Line) error) error {
+	streamURL := "ws" + strings.TrimPrefix(c.endpoint, "http") + "/api/v1/logs/stream?" + req.Query()
+
+	header := http.Header{}
+	if c.authType == "jwt" && c.jwtTokens != nil {
+		token, err := c.jwtTokens.Token()
+		if err != nil {
+			return fmt.Errorf("failed to issue agent token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 10 * time.Second}
+	if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+	conn, resp, err := dialer.DialContext(ctx, streamURL, header)
+	if err != nil {
+		// A rejected upgrade carries the usual agent error
+		if resp != nil {
+			defer resp.Body.Close()
+			var agentResp AgentResponse
+			if body, _ := io.ReadAll(resp.Body); json.Unmarshal(body, &agentResp) == nil && agentResp.Error != nil {
+				return fmt.Errorf("agent error: %s - %s", agentResp.Error.Code, agentResp.Error.Message)
+			}
+			return fmt.Errorf("agent returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("request failed: %w: %w", ErrAgentUnreachable, err)
+	}
+	defer conn.Close()
+
+	return receiveLogStream(ctx, conn, send)
+}
+
 // UpdateGeoFiles triggers geo file update on the agent.
 func (c *RemoteConnector) UpdateGeoFiles(ctx context.Context) error {
 	_, err := c.doRequest(ctx, "POST", "/api/v1/geofiles/update", nil)
 	return err
 }
 
-// InstallXray installs Xray on the agent.
-func (c *RemoteConnector) InstallXray(ctx context.Context, version string) error {
-	body := map[string]string{"version": version}
-	_, err := c.doRequest(ctx, "POST", "/api/v1/xray/install", body)
+// RotateJWTSecret replaces the JWT secret of the agent. The agent keeps accepting tokens
+// signed with the current secret for grace, so requests issued meanwhile still pass.
+func (c *RemoteConnector) RotateJWTSecret(ctx context.Context, secret string, grace time.Duration) error {
+	body := map[string]any{"secret": secret, "grace": int(grace.Seconds())}
+	_, err := c.doRequest(ctx, "POST", "/api/v1/auth/rotate", body)
+	return err
+}
+
+// SyncBlockedIPs replaces the agent's blocklist with the given entries.
+func (c *RemoteConnector) SyncBlockedIPs(ctx context.Context, entries []*model.BlockedIP) error {
+	_, err := c.doConfigRequest(ctx, "PUT", "/api/v1/blocklist", entries)
+	return err
+}
+
+// ApplySettings pushes fleet settings to the agent, which restarts Xray if they change its config.
+func (c *RemoteConnector) ApplySettings(ctx context.Context, settings *FleetSettings) error {
+	defer InvalidateInboundCache(c.serverId)
+	_, err := c.doConfigRequest(ctx, "PUT", "/api/v1/settings", settings)
 	return err
 }
 
+// GenerateKey generates key material with the agent's Xray binary.
+func (c *RemoteConnector) GenerateKey(ctx context.Context, kind string, sni string) (any, error) {
+	path := "/api/v1/xray/keys/" + url.PathEscape(kind)
+	if sni != "" {
+		path += "?sni=" + url.QueryEscape(sni)
+	}
+	resp, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// GetGeoFiles returns metadata of the geo files installed on the agent.
+func (c *RemoteConnector) GetGeoFiles(ctx context.Context) ([]*GeoFileInfo, error) {
+	resp, err := c.doRequest(ctx, "GET", "/api/v1/geofiles", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*GeoFileInfo
+	if err := json.Unmarshal(resp.Data, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse geo files: %w", err)
+	}
+	return files, nil
+}
+
+// InstallXray installs Xray on the agent from the panel's configured mirror.
+// The agent verifies the archive checksum before installing it. The install runs in
+// the background on the agent, which is polled for its progress until it finishes;
+// agents without background installs answer once the install is done.
+func (c *RemoteConnector) InstallXray(ctx context.Context, version string, progress ProgressFunc) error {
+	settingService := SettingService{}
+	mirror, err := settingService.GetXrayMirror()
+	if err != nil {
+		return err
+	}
+	body := map[string]string{"version": version, "mirror": mirror}
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/xray/install?async=true", body)
+	if err != nil {
+		return err
+	}
+
+	var started struct {
+		TaskId string `json:"taskId"`
+	}
+	json.Unmarshal(resp.Data, &started)
+	if started.TaskId != "" {
+		if err := c.waitTask(ctx, started.TaskId, progress); err != nil {
+			return err
+		}
+	}
+	recordServerEvent(c.serverId, "xray", "Xray "+version+" installed")
+	return nil
+}
+
+// UpdateAgent installs an agent release from the panel's release URL on the agent, which
+// verifies its signature and restarts once it is installed. The install is polled for
+// its progress like InstallXray.
+func (c *RemoteConnector) UpdateAgent(ctx context.Context, version string, progress ProgressFunc) error {
+	settingService := SettingService{}
+	releaseURL, err := settingService.GetAgentReleaseURL()
+	if err != nil {
+		return err
+	}
+	body := map[string]string{"version": version, "url": cmp.Or(releaseURL, DefaultAgentReleaseURL)}
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/agent/update?async=true", body)
+	if err != nil {
+		return err
+	}
+
+	var started struct {
+		TaskId string `json:"taskId"`
+	}
+	json.Unmarshal(resp.Data, &started)
+	if started.TaskId != "" {
+		if err := c.waitTask(ctx, started.TaskId, progress); err != nil {
+			return err
+		}
+	}
+	recordServerEvent(c.serverId, "agent", "Agent "+version+" installed")
+	return nil
+}
+
+// waitTask polls the progress of a background operation on the agent until it finishes
+// or ctx is done, passing each update to progress, if set.
+func (c *RemoteConnector) waitTask(ctx context.Context, taskId string, progress ProgressFunc) error {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		resp, err := c.doRequest(ctx, "GET", "/api/v1/tasks/"+taskId, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get progress of task %s: %w", taskId, err)
+		}
+		var task TaskProgress
+		if err := json.Unmarshal(resp.Data, &task); err != nil {
+			return fmt.Errorf("failed to parse task progress: %w", err)
+		}
+		if progress != nil {
+			progress(task.Percent, task.Stage)
+		}
+		switch task.Status {
+		case "completed":
+			return nil
+		case "failed":
+			return errors.New(task.Error)
+		}
+	}
+}
+
 // GenerateCert generates a certificate on the agent.
 func (c *RemoteConnector) GenerateCert(ctx context.Context, domain string) (*CertInfo, error) {
 	body := map[string]string{"domain": domain}
@@ -576,6 +1147,7 @@ func (c *RemoteConnector) GenerateCert(ctx context.Context, domain string) (*Cer
 	if err != nil {
 		return nil, err
 	}
+	recordServerEvent(c.serverId, "cert", "Certificate issued for "+domain)
 
 	var cert CertInfo
 	if err := json.Unmarshal(resp.Data, &cert); err != nil {
@@ -625,6 +1197,7 @@ func (c *RemoteConnector) BackupDatabase(ctx context.Context) ([]byte, error) {
 
 // RestoreDatabase restores database on the agent.
 func (c *RemoteConnector) RestoreDatabase(ctx context.Context, data []byte) error {
+	defer InvalidateInboundCache(c.serverId)
 	// Encode database data to base64
 	encodedData := base64.StdEncoding.EncodeToString(data)
 
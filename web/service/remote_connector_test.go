@@ -0,0 +1,90 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/agenttest"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestRequestNotSent checks that only failures before the request reached the agent
+// count as unreachable, so timed out changes are not queued and replayed.
+func TestRequestNotSent(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + closed.Addr().String()
+	closed.Close()
+
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer hanging.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	tests := []struct {
+		name    string
+		url     string
+		notSent bool
+	}{
+		{"connection refused", closedURL, true},
+		{"unknown certificate authority", tlsServer.URL, true},
+		{"timeout after sending", hanging.URL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Post(tt.url, "application/json", strings.NewReader("{}"))
+			if err == nil {
+				resp.Body.Close()
+				t.Fatal("expected the request to fail")
+			}
+			if got := service.RequestNotSent(err); got != tt.notSent {
+				t.Fatalf("RequestNotSent(%v) = %v, want %v", err, got, tt.notSent)
+			}
+		})
+	}
+}
+
+// TestDeleteClientEscapesId deletes a trojan client whose password holds characters
+// that are special in URL paths.
+func TestDeleteClientEscapesId(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	server := agent.Server(104, "escape-agent")
+	if err := database.GetDB().Create(server).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Server{}, server.Id)
+
+	password := "a/b?c#d%e"
+	inboundId := agent.AddInbound(&model.Inbound{
+		Protocol: model.Trojan,
+		Port:     20104,
+		Enable:   true,
+		Settings: `{"clients":[{"password":"` + password + `","email":"escaped","enable":true},{"password":"a","email":"kept","enable":true}]}`,
+	})
+
+	connector, err := (&service.ServerManagementService{}).GetConnector(server.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := connector.DeleteClient(context.Background(), inboundId, password); err != nil {
+		t.Fatal(err)
+	}
+	settings := agent.Inbounds()[0].Settings
+	if strings.Contains(settings, `"escaped"`) || !strings.Contains(settings, `"kept"`) {
+		t.Fatalf("expected only the escaped client to be deleted, got %s", settings)
+	}
+}
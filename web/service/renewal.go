@@ -0,0 +1,347 @@
+// Package service provides RenewalService for client self-service renewal links.
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+)
+
+// RenewalService issues signed renewal links and applies renewals on every server
+// a subscription's clients exist on.
+type RenewalService struct {
+	serverMgmt     ServerManagementService
+	settingService SettingService
+}
+
+// GetRenewals returns all renewal tokens, newest first.
+func (s *RenewalService) GetRenewals() ([]*model.RenewalToken, error) {
+	db := database.GetDB()
+	var renewals []*model.RenewalToken
+	err := db.Model(model.RenewalToken{}).Order("id desc").Find(&renewals).Error
+	return renewals, err
+}
+
+// GetRenewal returns a renewal by ID.
+func (s *RenewalService) GetRenewal(id int) (*model.RenewalToken, error) {
+	db := database.GetDB()
+	renewal := &model.RenewalToken{}
+	err := db.Model(model.RenewalToken{}).Where("id = ?", id).First(renewal).Error
+	if err != nil {
+		return nil, err
+	}
+	return renewal, nil
+}
+
+// CreateRenewal issues a renewal token for a subscription valid for ttl.
+func (s *RenewalService) CreateRenewal(subId string, days int, resetTraffic bool, ttl time.Duration, paymentUrl string) (*model.RenewalToken, error) {
+	subId = strings.TrimSpace(subId)
+	if subId == "" {
+		return nil, fmt.Errorf("subId is required")
+	}
+	if days < 0 {
+		return nil, fmt.Errorf("days must not be negative")
+	}
+	if days == 0 && !resetTraffic {
+		return nil, fmt.Errorf("renewal must extend the expiry or reset traffic")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("link lifetime must be positive")
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	renewal := &model.RenewalToken{
+		Token:        hex.EncodeToString(token),
+		SubId:        subId,
+		Days:         days,
+		ResetTraffic: resetTraffic,
+		PaymentUrl:   paymentUrl,
+		Status:       "pending",
+		ExpiresAt:    time.Now().Add(ttl).Unix(),
+	}
+	if err := database.GetDB().Create(renewal).Error; err != nil {
+		return nil, err
+	}
+	return renewal, nil
+}
+
+// CancelRenewal invalidates a pending renewal.
+func (s *RenewalService) CancelRenewal(id int) error {
+	db := database.GetDB()
+	result := db.Model(model.RenewalToken{}).Where("id = ? AND status = ?", id, "pending").Update("status", "cancelled")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no pending renewal %d", id)
+	}
+	return nil
+}
+
+// GetRenewalLink returns the link a client opens to see its renewal. The link lives
+// on the subscription server and is signed with the panel secret.
+func (s *RenewalService) GetRenewalLink(renewal *model.RenewalToken) (string, error) {
+	sig, err := s.sign(renewal)
+	if err != nil {
+		return "", err
+	}
+
+	base, _ := s.settingService.GetSubURI()
+	if base == "" {
+		base, _ = s.settingService.GetSubPath()
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return fmt.Sprintf("%s%s/renew/%s?sig=%s", base, url.PathEscape(renewal.SubId), renewal.Token, sig), nil
+}
+
+// VerifyRenewalLink returns the renewal behind a signed link.
+func (s *RenewalService) VerifyRenewalLink(subId, token, sig string) (*model.RenewalToken, error) {
+	renewal, err := s.getByToken(token)
+	if err != nil || renewal.SubId != subId {
+		return nil, fmt.Errorf("renewal not found")
+	}
+
+	expected, err := s.sign(renewal)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return nil, fmt.Errorf("invalid renewal signature")
+	}
+	return renewal, nil
+}
+
+// VerifyWebhookSignature checks the hex HMAC-SHA256 of a webhook body against the
+// configured webhook secret.
+func (s *RenewalService) VerifyWebhookSignature(body []byte, sig string) error {
+	secret, err := s.settingService.GetRenewalWebhookSecret()
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("renewal webhook is disabled")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig))) {
+		return fmt.Errorf("invalid webhook signature")
+	}
+	return nil
+}
+
+// errRenewalNotPending is returned by Apply for a renewal that was already claimed.
+var errRenewalNotPending = errors.New("renewal is not pending")
+
+// ConfirmPayment applies the renewal behind token after its payment was confirmed.
+// Confirming a renewal that is applied or being applied is a no-op, so duplicate and
+// concurrent webhooks extend the expiry only once. Failed renewals are not re-applied
+// because some servers may already have been renewed.
+func (s *RenewalService) ConfirmPayment(ctx context.Context, token, paymentId string) (*model.RenewalToken, error) {
+	renewal, err := s.getByToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("renewal not found")
+	}
+	if renewal.Status == "pending" && time.Now().Unix() > renewal.ExpiresAt {
+		return nil, fmt.Errorf("renewal link expired")
+	}
+	if renewal.Status != "pending" {
+		return renewalOutcome(renewal)
+	}
+
+	renewal.PaymentId = paymentId
+	err = s.Apply(ctx, renewal)
+	if errors.Is(err, errRenewalNotPending) {
+		// Another confirmation claimed it first
+		if renewal, err = s.GetRenewal(renewal.Id); err != nil {
+			return nil, err
+		}
+		return renewalOutcome(renewal)
+	}
+	return renewal, err
+}
+
+// renewalOutcome returns the result of confirming a renewal that is no longer pending.
+func renewalOutcome(renewal *model.RenewalToken) (*model.RenewalToken, error) {
+	switch renewal.Status {
+	case "cancelled":
+		return nil, fmt.Errorf("renewal was cancelled")
+	case "failed":
+		return renewal, fmt.Errorf("renewal failed earlier: %s", renewal.Error)
+	}
+	return renewal, nil
+}
+
+// Apply extends the expiry and/or resets traffic of every client with the renewal's
+// subId on all enabled servers, then records the outcome. The renewal must be pending;
+// it is claimed atomically first, so it is never applied twice.
+func (s *RenewalService) Apply(ctx context.Context, renewal *model.RenewalToken) error {
+	result := database.GetDB().Model(model.RenewalToken{}).
+		Where("id = ? AND status = ?", renewal.Id, "pending").
+		Update("status", "applying")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errRenewalNotPending
+	}
+	renewal.Status = "applying"
+
+	applied, err := s.applyToServers(ctx, renewal)
+	if err == nil && applied == 0 {
+		err = fmt.Errorf("no clients found with subId %s", renewal.SubId)
+	}
+
+	renewal.Status = "applied"
+	renewal.Error = ""
+	renewal.AppliedAt = time.Now().Unix()
+	if err != nil {
+		renewal.Status = "failed"
+		renewal.Error = err.Error()
+		logger.Warningf("Renewal %d for subscription %s failed: %v", renewal.Id, renewal.SubId, err)
+	} else {
+		logger.Infof("Renewal %d applied to %d clients of subscription %s", renewal.Id, applied, renewal.SubId)
+	}
+
+	if saveErr := database.GetDB().Save(renewal).Error; saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// applyToServers applies the renewal on each enabled server and returns how many
+// clients were updated.
+func (s *RenewalService) applyToServers(ctx context.Context, renewal *model.RenewalToken) (int, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	errs := make([]error, 0)
+	for _, server := range servers {
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+		inbounds, err := connector.ListInbounds(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", server.Name, err))
+			continue
+		}
+
+		for _, inbound := range inbounds {
+			count, err := s.applyToInbound(ctx, connector, inbound, renewal)
+			applied += count
+			if err != nil {
+				errs = append(errs, fmt.Errorf("server %s inbound %d: %w", server.Name, inbound.Id, err))
+			}
+		}
+	}
+
+	return applied, common.Combine(errs...)
+}
+
+// applyToInbound renews the matching clients of one inbound.
+func (s *RenewalService) applyToInbound(ctx context.Context, connector ServerConnector, inbound *model.Inbound, renewal *model.RenewalToken) (int, error) {
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return 0, err
+	}
+	clients, _ := settings["clients"].([]any)
+
+	applied := 0
+	for index, item := range clients {
+		client, ok := item.(map[string]any)
+		if !ok || client["subId"] != renewal.SubId {
+			continue
+		}
+		email, _ := client["email"].(string)
+
+		if renewal.Days > 0 {
+			expiry, _ := client["expiryTime"].(float64)
+			client["expiryTime"] = extendExpiry(int64(expiry), renewal.Days)
+			client["enable"] = true
+
+			update, err := json.Marshal(map[string]any{"clients": []any{client}})
+			if err != nil {
+				return applied, err
+			}
+			err = connector.UpdateClient(ctx, &model.Inbound{
+				Id:       inbound.Id,
+				ServerId: inbound.ServerId,
+				Settings: string(update),
+			}, index)
+			if err != nil {
+				return applied, err
+			}
+		}
+
+		if renewal.ResetTraffic {
+			if err := connector.ResetClientTraffic(ctx, inbound.Id, email); err != nil {
+				return applied, err
+			}
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// getByToken returns the renewal with the given token.
+func (s *RenewalService) getByToken(token string) (*model.RenewalToken, error) {
+	db := database.GetDB()
+	renewal := &model.RenewalToken{}
+	err := db.Model(model.RenewalToken{}).Where("token = ?", token).First(renewal).Error
+	if err != nil {
+		return nil, err
+	}
+	return renewal, nil
+}
+
+// sign returns the link signature of a renewal.
+func (s *RenewalService) sign(renewal *model.RenewalToken) (string, error) {
+	secret, err := s.settingService.GetSecret()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(renewal.SubId + "|" + renewal.Token + "|" + strconv.FormatInt(renewal.ExpiresAt, 10)))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// extendExpiry adds days to an expiry time in milliseconds. Expired deadlines
+// restart from now, clients without a deadline keep none, and negative values
+// ("days after first use") are extended by the same amount.
+func extendExpiry(expiry int64, days int) int64 {
+	extension := int64(days) * 24 * int64(time.Hour/time.Millisecond)
+	if expiry == 0 {
+		return 0
+	}
+	if expiry < 0 {
+		return expiry - extension
+	}
+	now := time.Now().UnixMilli()
+	return max(expiry, now) + extension
+}
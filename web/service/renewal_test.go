@@ -0,0 +1,75 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/agenttest"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestConfirmPaymentAppliesOnce sends the same payment confirmation concurrently and
+// expects the client's expiry to be extended exactly once.
+func TestConfirmPaymentAppliesOnce(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	server := agent.Server(102, "renewal-agent")
+	if err := database.GetDB().Create(server).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Server{}, server.Id)
+
+	expiry := time.Now().Add(10 * 24 * time.Hour).UnixMilli()
+	agent.AddInbound(&model.Inbound{
+		Protocol: model.VLESS,
+		Port:     20102,
+		Enable:   true,
+		Settings: fmt.Sprintf(`{"clients":[{"id":"5f0c1d8e-2b7a-4e59-9a53-0c6f2d1b7e44","email":"renewing","subId":"renewal-sub","enable":true,"expiryTime":%d}]}`, expiry),
+	})
+
+	renewals := &service.RenewalService{}
+	renewal, err := renewals.CreateRenewal("renewal-sub", 30, false, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.InvalidateInboundCache(server.Id)
+			if _, err := renewals.ConfirmPayment(context.Background(), renewal.Token, "payment-1"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error("confirmation failed:", err)
+	}
+
+	if updates := agent.Count("PUT /api/v1/inbounds/:id/clients/:index"); updates != 1 {
+		t.Fatalf("expected one client update, got %d", updates)
+	}
+	stored, err := renewals.GetRenewal(renewal.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "applied" || stored.PaymentId != "payment-1" {
+		t.Fatalf("unexpected renewal state %q, payment %q", stored.Status, stored.PaymentId)
+	}
+	if _, err := renewals.ConfirmPayment(context.Background(), renewal.Token, "payment-1"); err != nil {
+		t.Fatal("repeated confirmation failed:", err)
+	}
+	if err := renewals.Apply(context.Background(), stored); err == nil {
+		t.Fatal("applied renewal was applied again")
+	}
+}
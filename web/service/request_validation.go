@@ -0,0 +1,227 @@
+// Package service provides the request validation shared by the panel and agent handlers.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Field error codes reported in FieldError.Code.
+const (
+	FieldRequired   = "required"
+	FieldInvalid    = "invalid"
+	FieldType       = "type"
+	FieldSyntax     = "syntax"
+	FieldOutOfRange = "out_of_range"
+	FieldOneOf      = "oneof"
+	FieldDuplicate  = "duplicate"
+)
+
+// ValidationError collects the field errors of a request payload. It is returned for
+// payloads that could not be bound or failed a binding validator.
+type ValidationError struct {
+	Fields []*FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// ValidationFields returns the field errors carried by err, or nil if err is not a
+// validation or binding error.
+func ValidationFields(err error) []*FieldError {
+	var inboundErr *InboundValidationError
+	if errors.As(err, &inboundErr) {
+		return inboundErr.Fields
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Fields
+	}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var numErr *strconv.NumError
+	var validatorErrs validator.ValidationErrors
+	if errors.As(err, &typeErr) || errors.As(err, &syntaxErr) || errors.As(err, &numErr) || errors.As(err, &validatorErrs) {
+		return NewValidationError(err).Fields
+	}
+	return nil
+}
+
+// NewValidationError converts an error returned by gin binding into a ValidationError.
+// Errors it does not recognise are reported against the whole body.
+func NewValidationError(err error) *ValidationError {
+	var validatorErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var numErr *strconv.NumError
+
+	switch {
+	case errors.As(err, &validatorErrs):
+		fields := make([]*FieldError, len(validatorErrs))
+		for i, fe := range validatorErrs {
+			fields[i] = fieldErrorOf(fe)
+		}
+		return &ValidationError{Fields: fields}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		return &ValidationError{Fields: []*FieldError{{
+			Code: FieldType, Field: field, Message: "must be " + jsonTypeName(typeErr.Type),
+		}}}
+	case errors.As(err, &syntaxErr):
+		return &ValidationError{Fields: []*FieldError{{
+			Code: FieldSyntax, Field: "body", Message: fmt.Sprintf("invalid JSON at offset %d", syntaxErr.Offset),
+		}}}
+	case errors.As(err, &numErr):
+		return &ValidationError{Fields: []*FieldError{{
+			Code: FieldType, Field: "body", Message: fmt.Sprintf("%q is not a number", numErr.Num),
+		}}}
+	default:
+		return &ValidationError{Fields: []*FieldError{{Code: FieldInvalid, Field: "body", Message: err.Error()}}}
+	}
+}
+
+// fieldErrorOf describes one failed validator tag.
+func fieldErrorOf(fe validator.FieldError) *FieldError {
+	// The namespace starts with the struct name, which means nothing to API clients
+	field := fe.Namespace()
+	if i := strings.Index(field, "."); i >= 0 {
+		field = field[i+1:]
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return &FieldError{Code: FieldRequired, Field: field, Message: "is required"}
+	case "oneof":
+		return &FieldError{Code: FieldOneOf, Field: field, Message: "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")}
+	case "min", "gte", "gt":
+		return &FieldError{Code: FieldOutOfRange, Field: field, Message: "must be at least " + fe.Param()}
+	case "max", "lte", "lt":
+		return &FieldError{Code: FieldOutOfRange, Field: field, Message: "must be at most " + fe.Param()}
+	case "endpoint":
+		return &FieldError{Code: FieldInvalid, Field: field, Message: "must be an http(s) URL or host[:port]"}
+	case "timezone":
+		return &FieldError{Code: FieldInvalid, Field: field, Message: "must be an IANA timezone such as Europe/Berlin"}
+	default:
+		return &FieldError{Code: FieldInvalid, Field: field, Message: "failed " + fe.Tag() + " validation"}
+	}
+}
+
+// jsonTypeName names a Go type the way it appears in JSON.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+var registerValidatorsOnce sync.Once
+
+// RegisterValidators installs the custom validators into the gin binding engine. Field
+// errors are reported by their JSON names, and model.Server payloads are checked as a
+// whole since which fields are required depends on the auth type.
+func RegisterValidators() {
+	registerValidatorsOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return f.Name
+			}
+			return name
+		})
+		v.RegisterStructValidation(validateServer, model.Server{})
+	})
+}
+
+// validateServer is the struct level validator of model.Server.
+func validateServer(sl validator.StructLevel) {
+	server := sl.Current().Interface().(model.Server)
+
+	if strings.TrimSpace(server.Name) == "" {
+		sl.ReportError(server.Name, "name", "Name", "required", "")
+	}
+	switch server.AuthType {
+	case "":
+		sl.ReportError(server.AuthType, "authType", "AuthType", "required", "")
+	case "mtls", "jwt", "local", "demo":
+	default:
+		sl.ReportError(server.AuthType, "authType", "AuthType", "oneof", "mtls jwt local demo")
+	}
+	if server.AuthType != "local" {
+		if strings.TrimSpace(server.Endpoint) == "" {
+			sl.ReportError(server.Endpoint, "endpoint", "Endpoint", "required", "")
+		} else if _, err := NormalizeEndpoint(server.Endpoint); err != nil {
+			sl.ReportError(server.Endpoint, "endpoint", "Endpoint", "endpoint", "")
+		}
+	}
+	if server.Timezone != "" {
+		if _, err := time.LoadLocation(server.Timezone); err != nil {
+			sl.ReportError(server.Timezone, "timezone", "Timezone", "timezone", "")
+		}
+	}
+}
+
+// ValidateClientPayload checks the client payload of an add or update client request,
+// which carries the target inbound ID and the new clients in settings. protocol is the
+// protocol of the stored inbound, since the payload does not repeat it.
+func ValidateClientPayload(data *model.Inbound, protocol model.Protocol) error {
+	v := &inboundValidator{}
+	if data.Id <= 0 {
+		v.add("id", "is required")
+	}
+
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(data.Settings), &settings); err != nil || settings == nil {
+		v.add("settings", "must be a JSON object")
+	} else {
+		// Shadowsocks client payloads do not repeat the method, so only the common
+		// client fields can be checked
+		if protocol == model.Shadowsocks {
+			protocol = ""
+		}
+		v.validateClients(protocol, settings)
+		if clients, ok := settings["clients"].([]any); ok && len(clients) == 0 {
+			v.add("settings.clients", "is required")
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return &ValidationError{Fields: v.errs}
+	}
+	return nil
+}
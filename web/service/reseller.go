@@ -0,0 +1,458 @@
+// Package service provides ResellerService for reseller accounts with traffic and client pools.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/random"
+
+	"github.com/google/uuid"
+)
+
+// resellerPoolMu serializes pool checks so concurrent requests cannot overdraw a pool.
+var resellerPoolMu sync.Mutex
+
+// ResellerService manages reseller accounts and the clients they provision.
+type ResellerService struct {
+	serverMgmt     ServerManagementService
+	inboundService InboundService
+}
+
+// ResellerPoolUsage describes how much of a reseller's pool is consumed.
+type ResellerPoolUsage struct {
+	ClientPool       int   `json:"clientPool"`       // 0 = unlimited
+	ClientsUsed      int   `json:"clientsUsed"`      // Clients created and not deleted
+	TrafficPool      int64 `json:"trafficPool"`      // Bytes, 0 = unlimited
+	TrafficAllocated int64 `json:"trafficAllocated"` // Bytes allotted to clients
+	TrafficUsed      int64 `json:"trafficUsed"`      // Bytes consumed by clients across servers
+	Incomplete       bool  `json:"incomplete"`       // Some servers could not be reached
+}
+
+// ResellerClientRequest describes a client a reseller wants to create.
+type ResellerClientRequest struct {
+	ServerId   int    `json:"serverId" form:"serverId"`
+	InboundId  int    `json:"inboundId" form:"inboundId"`
+	Email      string `json:"email" form:"email"`
+	Total      int64  `json:"total" form:"total"`           // Traffic quota in bytes
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Unix milliseconds, 0 = never
+	LimitIP    int    `json:"limitIp" form:"limitIp"`
+	Comment    string `json:"comment" form:"comment"`
+}
+
+// GetResellers returns all reseller accounts.
+func (s *ResellerService) GetResellers() ([]*model.Reseller, error) {
+	db := database.GetDB()
+	var resellers []*model.Reseller
+	err := db.Model(model.Reseller{}).Order("id").Find(&resellers).Error
+	return resellers, err
+}
+
+// GetReseller returns a reseller by ID.
+func (s *ResellerService) GetReseller(id int) (*model.Reseller, error) {
+	db := database.GetDB()
+	reseller := &model.Reseller{}
+	err := db.Model(model.Reseller{}).Where("id = ?", id).First(reseller).Error
+	if err != nil {
+		return nil, err
+	}
+	return reseller, nil
+}
+
+// AddReseller creates a reseller and returns its API token. The token is only
+// stored hashed, so this is the only time it can be shown.
+func (s *ResellerService) AddReseller(reseller *model.Reseller) (string, error) {
+	if err := s.validateReseller(reseller); err != nil {
+		return "", err
+	}
+
+	token, err := newResellerToken()
+	if err != nil {
+		return "", err
+	}
+	reseller.Id = 0
+	reseller.TokenHash = hashResellerToken(token)
+
+	if err := database.GetDB().Create(reseller).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UpdateReseller updates a reseller's permissions and pool limits.
+func (s *ResellerService) UpdateReseller(reseller *model.Reseller) error {
+	if err := s.validateReseller(reseller); err != nil {
+		return err
+	}
+
+	existing, err := s.GetReseller(reseller.Id)
+	if err != nil {
+		return err
+	}
+
+	existing.Username = reseller.Username
+	existing.Enable = reseller.Enable
+	existing.ServerIds = reseller.ServerIds
+	existing.TrafficPool = reseller.TrafficPool
+	existing.ClientPool = reseller.ClientPool
+	existing.Remark = reseller.Remark
+	return database.GetDB().Save(existing).Error
+}
+
+// DeleteReseller removes a reseller account. Clients it created keep working
+// but are no longer tracked against any pool, and its inbounds return to the admin.
+func (s *ResellerService) DeleteReseller(id int) error {
+	db := database.GetDB()
+	result := db.Delete(model.Reseller{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("reseller %d not found", id)
+	}
+	if err := db.Where("reseller_id = ?", id).Delete(model.InboundOwner{}).Error; err != nil {
+		return err
+	}
+	return db.Where("reseller_id = ?", id).Delete(model.ResellerClient{}).Error
+}
+
+// RegenerateToken replaces a reseller's API token and returns the new one.
+func (s *ResellerService) RegenerateToken(id int) (string, error) {
+	reseller, err := s.GetReseller(id)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := newResellerToken()
+	if err != nil {
+		return "", err
+	}
+	reseller.TokenHash = hashResellerToken(token)
+	if err := database.GetDB().Save(reseller).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Authenticate returns the enabled reseller owning the API token.
+func (s *ResellerService) Authenticate(token string) (*model.Reseller, error) {
+	if token == "" {
+		return nil, fmt.Errorf("missing reseller token")
+	}
+
+	db := database.GetDB()
+	reseller := &model.Reseller{}
+	err := db.Model(model.Reseller{}).Where("token_hash = ?", hashResellerToken(token)).First(reseller).Error
+	if err != nil {
+		return nil, fmt.Errorf("invalid reseller token")
+	}
+	if !reseller.Enable {
+		return nil, fmt.Errorf("reseller %s is disabled", reseller.Username)
+	}
+	return reseller, nil
+}
+
+// GetPermittedServers returns the enabled servers the reseller may provision clients on.
+func (s *ResellerService) GetPermittedServers(reseller *model.Reseller) ([]*model.Server, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	permitted := make([]*model.Server, 0)
+	for _, server := range servers {
+		if s.isServerPermitted(reseller, server.Id) {
+			permitted = append(permitted, server)
+		}
+	}
+	return permitted, nil
+}
+
+// GetClients returns the clients created by a reseller.
+func (s *ResellerService) GetClients(resellerId int) ([]*model.ResellerClient, error) {
+	db := database.GetDB()
+	var clients []*model.ResellerClient
+	err := db.Model(model.ResellerClient{}).Where("reseller_id = ?", resellerId).Order("id").Find(&clients).Error
+	return clients, err
+}
+
+// GetPoolUsage reports the reseller's pool consumption. Traffic used is collected
+// from every server the reseller has clients on.
+func (s *ResellerService) GetPoolUsage(ctx context.Context, reseller *model.Reseller) (*ResellerPoolUsage, error) {
+	clients, err := s.GetClients(reseller.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ResellerPoolUsage{
+		ClientPool:  reseller.ClientPool,
+		ClientsUsed: len(clients),
+		TrafficPool: reseller.TrafficPool,
+	}
+
+	emailsByServer := make(map[int]map[string]struct{})
+	for _, client := range clients {
+		usage.TrafficAllocated += client.Total
+		if emailsByServer[client.ServerId] == nil {
+			emailsByServer[client.ServerId] = make(map[string]struct{})
+		}
+		emailsByServer[client.ServerId][client.Email] = struct{}{}
+	}
+
+	for serverId, emails := range emailsByServer {
+		connector, err := s.serverMgmt.GetConnector(serverId)
+		if err != nil {
+			usage.Incomplete = true
+			continue
+		}
+		inbounds, err := connector.ListInbounds(ctx)
+		if err != nil {
+			usage.Incomplete = true
+			continue
+		}
+		for _, inbound := range inbounds {
+			for _, stat := range inbound.ClientStats {
+				if _, ok := emails[stat.Email]; ok {
+					usage.TrafficUsed += stat.Up + stat.Down
+				}
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// AddClient creates a client for the reseller if the server is permitted and the
+// pool has room for it.
+func (s *ResellerService) AddClient(ctx context.Context, reseller *model.Reseller, req *ResellerClientRequest) (*model.ResellerClient, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		return nil, fmt.Errorf("email is required")
+	}
+	if req.Total < 0 || req.ExpiryTime < 0 || req.LimitIP < 0 {
+		return nil, fmt.Errorf("total, expiryTime and limitIp must not be negative")
+	}
+	if !s.isServerPermitted(reseller, req.ServerId) {
+		return nil, fmt.Errorf("server %d is not permitted for this reseller", req.ServerId)
+	}
+
+	resellerPoolMu.Lock()
+	defer resellerPoolMu.Unlock()
+
+	if err := s.checkPool(reseller, req.Total); err != nil {
+		return nil, err
+	}
+
+	connector, err := s.serverMgmt.GetConnector(req.ServerId)
+	if err != nil {
+		return nil, err
+	}
+	inbound, err := connector.GetInbound(ctx, req.InboundId)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.GetInboundOwner(req.ServerId, inbound.Id)
+	if err != nil {
+		return nil, err
+	}
+	if owner != reseller.Id {
+		return nil, fmt.Errorf("inbound %d is not assigned to this reseller", inbound.Id)
+	}
+
+	subId := random.Seq(16)
+	client, err := newResellerClientSettings(inbound, req, subId)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := json.Marshal(map[string]any{"clients": []any{client}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = connector.AddClient(ctx, &model.Inbound{
+		Id:       inbound.Id,
+		ServerId: req.ServerId,
+		Settings: string(settings),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record := &model.ResellerClient{
+		ResellerId: reseller.Id,
+		ServerId:   req.ServerId,
+		InboundId:  inbound.Id,
+		Email:      req.Email,
+		SubId:      subId,
+		Total:      req.Total,
+	}
+	if err := database.GetDB().Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// DeleteClient removes one of the reseller's clients and returns its slot and traffic to the pool.
+func (s *ResellerService) DeleteClient(ctx context.Context, reseller *model.Reseller, id int) error {
+	db := database.GetDB()
+	record := &model.ResellerClient{}
+	err := db.Model(model.ResellerClient{}).Where("id = ? AND reseller_id = ?", id, reseller.Id).First(record).Error
+	if err != nil {
+		return fmt.Errorf("client %d not found", id)
+	}
+
+	connector, err := s.serverMgmt.GetConnector(record.ServerId)
+	if err != nil {
+		return err
+	}
+	inbound, err := connector.GetInbound(ctx, record.InboundId)
+	if err != nil {
+		return err
+	}
+	clients, err := s.inboundService.GetClients(inbound)
+	if err != nil {
+		return err
+	}
+
+	// Client deletion is keyed by the protocol's client ID, not the email
+	clientId := ""
+	for _, client := range clients {
+		if client.Email == record.Email {
+			clientId = s.inboundService.GetClientId(inbound.Protocol, client)
+			break
+		}
+	}
+	if clientId != "" {
+		if err := connector.DeleteClient(ctx, record.InboundId, clientId); err != nil {
+			return err
+		}
+	}
+
+	return db.Delete(record).Error
+}
+
+// checkPool verifies the reseller can create one more client with the given quota.
+func (s *ResellerService) checkPool(reseller *model.Reseller, total int64) error {
+	clients, err := s.GetClients(reseller.Id)
+	if err != nil {
+		return err
+	}
+
+	if reseller.ClientPool > 0 && len(clients) >= reseller.ClientPool {
+		return fmt.Errorf("client pool exhausted (%d/%d)", len(clients), reseller.ClientPool)
+	}
+
+	if reseller.TrafficPool > 0 {
+		if total == 0 {
+			return fmt.Errorf("clients must have a traffic quota when the traffic pool is limited")
+		}
+		var allocated int64
+		for _, client := range clients {
+			allocated += client.Total
+		}
+		if allocated+total > reseller.TrafficPool {
+			return fmt.Errorf("traffic pool exhausted (%d of %d bytes allocated)", allocated, reseller.TrafficPool)
+		}
+	}
+	return nil
+}
+
+// isServerPermitted reports whether serverId is in the reseller's permitted servers.
+func (s *ResellerService) isServerPermitted(reseller *model.Reseller, serverId int) bool {
+	var serverIds []int
+	if err := json.Unmarshal([]byte(reseller.ServerIds), &serverIds); err != nil {
+		return false
+	}
+	return slices.Contains(serverIds, serverId)
+}
+
+// validateReseller checks a reseller's fields before it is saved.
+func (s *ResellerService) validateReseller(reseller *model.Reseller) error {
+	reseller.Username = strings.TrimSpace(reseller.Username)
+	if reseller.Username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if reseller.TrafficPool < 0 || reseller.ClientPool < 0 {
+		return fmt.Errorf("pool limits must not be negative")
+	}
+	if reseller.ServerIds == "" {
+		reseller.ServerIds = "[]"
+	}
+	var serverIds []int
+	if err := json.Unmarshal([]byte(reseller.ServerIds), &serverIds); err != nil {
+		return fmt.Errorf("serverIds must be a JSON array of server IDs: %w", err)
+	}
+	return nil
+}
+
+// newResellerClientSettings builds the client entry for the inbound's protocol.
+func newResellerClientSettings(inbound *model.Inbound, req *ResellerClientRequest, subId string) (map[string]any, error) {
+	client := map[string]any{
+		"email":      req.Email,
+		"limitIp":    req.LimitIP,
+		"totalGB":    req.Total,
+		"expiryTime": req.ExpiryTime,
+		"enable":     true,
+		"tgId":       "",
+		"subId":      subId,
+		"comment":    req.Comment,
+		"reset":      0,
+	}
+
+	switch inbound.Protocol {
+	case model.VMESS:
+		client["id"] = uuid.NewString()
+		client["security"] = "auto"
+	case model.VLESS:
+		client["id"] = uuid.NewString()
+		client["flow"] = ""
+	case model.Trojan:
+		client["password"] = random.Seq(10)
+	case model.Shadowsocks:
+		var settings map[string]any
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			return nil, err
+		}
+		method, _ := settings["method"].(string)
+		// 2022 ciphers need a key matching their size
+		keyLen := 32
+		if strings.Contains(method, "aes-128") {
+			keyLen = 16
+		}
+		key := make([]byte, keyLen)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		client["method"] = ""
+		client["password"] = base64.StdEncoding.EncodeToString(key)
+	default:
+		return nil, fmt.Errorf("protocol %s does not support clients", inbound.Protocol)
+	}
+
+	return client, nil
+}
+
+// newResellerToken generates a random API token.
+func newResellerToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// hashResellerToken returns the stored form of an API token.
+func hashResellerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
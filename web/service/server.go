@@ -4,6 +4,9 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -96,23 +99,35 @@ type Status struct {
 
 // Release represents information about a software release from GitHub.
 type Release struct {
-	TagName string `json:"tag_name"` // The tag name of the release
+	TagName    string `json:"tag_name"`   // The tag name of the release
+	Prerelease bool   `json:"prerelease"` // Whether the release is marked as a pre-release
 }
 
+// DefaultXrayMirror is where Xray release archives are downloaded from when no mirror is set.
+const DefaultXrayMirror = "https://github.com/XTLS/Xray-core/releases/download"
+
+// xrayDigestSource is where the SHA256 digests of Xray releases are fetched from. It is
+// the upstream release page whatever the mirror, so a mirror cannot serve a tampered
+// archive together with a matching digest.
+var xrayDigestSource = DefaultXrayMirror
+
+// xrayVersionPattern restricts versions to release tags so they are safe to use in URLs.
+var xrayVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
 // ServerService provides business logic for server monitoring and management.
 // It handles system status collection, IP detection, and application statistics.
 type ServerService struct {
 	xrayService        XrayService
 	inboundService     InboundService
-	cachedIPv4         string
-	cachedIPv6         string
-	noIPv6             bool
+	settingService     SettingService
+	publicIP           PublicIPService
 	mu                 sync.Mutex
 	lastCPUTimes       cpu.TimesStat
 	hasLastCPUSample   bool
 	hasNativeCPUSample bool
 	emaCPU             float64
 	cpuHistory         []CPUSample
+	cpuHistoryCapacity int
 	cachedCpuSpeedMhz  float64
 	lastCpuInfoAttempt time.Time
 }
@@ -193,38 +208,6 @@ type LogEntry struct {
 	Event       int
 }
 
-func getPublicIP(url string) string {
-	client := &http.Client{
-		Timeout: 3 * time.Second,
-	}
-
-	resp, err := client.Get(url)
-	if err != nil {
-		return "N/A"
-	}
-	defer resp.Body.Close()
-
-	// Don't retry if access is blocked or region-restricted
-	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnavailableForLegalReasons {
-		return "N/A"
-	}
-	if resp.StatusCode != http.StatusOK {
-		return "N/A"
-	}
-
-	ip, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "N/A"
-	}
-
-	ipString := strings.TrimSpace(string(ip))
-	if ipString == "" {
-		return "N/A"
-	}
-
-	return ipString
-}
-
 func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	now := time.Now()
 	status := &Status{
@@ -346,47 +329,10 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 		logger.Warning("get udp connections failed:", err)
 	}
 
-	// IP fetching with caching
-	showIp4ServiceLists := []string{
-		"https://api4.ipify.org",
-		"https://ipv4.icanhazip.com",
-		"https://v4.api.ipinfo.io/ip",
-		"https://ipv4.myexternalip.com/raw",
-		"https://4.ident.me",
-		"https://check-host.net/ip",
-	}
-	showIp6ServiceLists := []string{
-		"https://api6.ipify.org",
-		"https://ipv6.icanhazip.com",
-		"https://v6.api.ipinfo.io/ip",
-		"https://ipv6.myexternalip.com/raw",
-		"https://6.ident.me",
-	}
-
-	if s.cachedIPv4 == "" {
-		for _, ip4Service := range showIp4ServiceLists {
-			s.cachedIPv4 = getPublicIP(ip4Service)
-			if s.cachedIPv4 != "N/A" {
-				break
-			}
-		}
-	}
-
-	if s.cachedIPv6 == "" && !s.noIPv6 {
-		for _, ip6Service := range showIp6ServiceLists {
-			s.cachedIPv6 = getPublicIP(ip6Service)
-			if s.cachedIPv6 != "N/A" {
-				break
-			}
-		}
-	}
-
-	if s.cachedIPv6 == "N/A" {
-		s.noIPv6 = true
-	}
-
-	status.PublicIP.IPv4 = s.cachedIPv4
-	status.PublicIP.IPv6 = s.cachedIPv6
+	// Public addresses, cached by the detector
+	status.PublicIP.IPv4, status.PublicIP.IPv6 = s.publicIP.Detect()
+	status.PublicIP.IPv4 = cmp.Or(status.PublicIP.IPv4, "N/A")
+	status.PublicIP.IPv6 = cmp.Or(status.PublicIP.IPv6, "N/A")
 
 	// Xray status
 	if s.xrayService.IsXrayRunning() {
@@ -417,10 +363,35 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	return status
 }
 
+// SetCpuHistoryDepth sizes the in-memory CPU history to keep depth worth of samples
+// taken every interval.
+func (s *ServerService) SetCpuHistoryDepth(depth, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cpuHistoryCapacity = max(int(depth/interval), 1)
+	if len(s.cpuHistory) > s.cpuHistoryCapacity {
+		s.cpuHistory = s.cpuHistory[len(s.cpuHistory)-s.cpuHistoryCapacity:]
+	}
+}
+
+// processStart is when this process, the panel or an agent, started.
+var processStart = time.Now()
+
+// ProcessStats returns the memory obtained from the OS by the Go runtime, the number of
+// goroutines and the uptime in seconds of this process.
+func ProcessStats() (uint64, int, int64) {
+	var rtm runtime.MemStats
+	runtime.ReadMemStats(&rtm)
+	return rtm.Sys, runtime.NumGoroutine(), int64(time.Since(processStart).Seconds())
+}
+
 func (s *ServerService) AppendCpuSample(t time.Time, v float64) {
-	const capacity = 9000 // ~5 hours @ 2s interval
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	capacity := s.cpuHistoryCapacity
+	if capacity == 0 {
+		capacity = 10800 // 6 hours @ 2s interval
+	}
 	p := CPUSample{T: t.Unix(), Cpu: v}
 	if n := len(s.cpuHistory); n > 0 && s.cpuHistory[n-1].T == p.T {
 		s.cpuHistory[n-1] = p
@@ -517,12 +488,19 @@ func (s *ServerService) sampleCPUUtilization() (float64, error) {
 	return s.emaCPU, nil
 }
 
+// GetXrayVersions lists Xray releases of the configured channel. The "stable"
+// channel skips pre-releases, "prerelease" includes them.
 func (s *ServerService) GetXrayVersions() ([]string, error) {
 	const (
 		XrayURL    = "https://api.github.com/repos/XTLS/Xray-core/releases"
 		bufferSize = 8192
 	)
 
+	channel, err := s.settingService.GetXrayReleaseChannel()
+	if err != nil {
+		return nil, err
+	}
+
 	resp, err := http.Get(XrayURL)
 	if err != nil {
 		return nil, err
@@ -542,6 +520,9 @@ func (s *ServerService) GetXrayVersions() ([]string, error) {
 
 	var versions []string
 	for _, release := range releases {
+		if release.Prerelease && channel != "prerelease" {
+			continue
+		}
 		tagVersion := strings.TrimPrefix(release.TagName, "v")
 		tagParts := strings.Split(tagVersion, ".")
 		if len(tagParts) != 3 {
@@ -580,7 +561,18 @@ func (s *ServerService) RestartXrayService() error {
 	return nil
 }
 
-func (s *ServerService) downloadXRay(version string) (string, error) {
+// downloadXRay downloads the release archive for this platform from mirror and
+// verifies it against the SHA256 digest of the upstream release. Download progress is
+// reported to progress, if set, as 5-80 percent.
+func (s *ServerService) downloadXRay(version string, mirror string, progress ProgressFunc) (string, error) {
+	if !xrayVersionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid Xray version %q", version)
+	}
+	if mirror == "" {
+		mirror = DefaultXrayMirror
+	}
+	mirror = strings.TrimSuffix(mirror, "/")
+
 	osName := runtime.GOOS
 	arch := runtime.GOARCH
 
@@ -609,12 +601,21 @@ func (s *ServerService) downloadXRay(version string) (string, error) {
 	}
 
 	fileName := fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
-	url := fmt.Sprintf("https://github.com/XTLS/Xray-core/releases/download/%s/%s", version, fileName)
+	url := fmt.Sprintf("%s/%s/%s", mirror, version, fileName)
+
+	expected, err := fetchXrayDigest(fmt.Sprintf("%s/%s/%s.dgst", xrayDigestSource, version, fileName))
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksum of %s: %w", fileName, err)
+	}
+
 	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
 
 	os.Remove(fileName)
 	file, err := os.Create(fileName)
@@ -623,27 +624,114 @@ func (s *ServerService) downloadXRay(version string) (string, error) {
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
+	hash := sha256.New()
+	var body io.Reader = resp.Body
+	if progress != nil {
+		body = io.TeeReader(resp.Body, &downloadProgress{total: resp.ContentLength, report: progress})
+	}
+	_, err = io.Copy(io.MultiWriter(file, hash), body)
 	if err != nil {
+		os.Remove(fileName)
 		return "", err
 	}
 
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		os.Remove(fileName)
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", fileName, expected, actual)
+	}
+
 	return fileName, nil
 }
 
+// downloadProgress reports the share of a download received so far as 5-80 percent.
+type downloadProgress struct {
+	total   int64
+	read    int64
+	percent int
+	report  ProgressFunc
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.read += int64(len(b))
+	if p.total > 0 {
+		if percent := 5 + int(p.read*75/p.total); percent != p.percent {
+			p.percent = percent
+			p.report(percent, "downloading")
+		}
+	}
+	return len(b), nil
+}
+
+// fetchXrayDigest downloads a release .dgst file and returns its SHA256 value.
+func fetchXrayDigest(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	// Lines look like "SHA2-256= <hex>" (older releases use "SHA256= <hex>")
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 64<<10))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "SHA2-256" || name == "SHA256" {
+			digest := strings.ToLower(strings.TrimSpace(value))
+			if len(digest) != sha256.Size*2 {
+				return "", fmt.Errorf("malformed SHA256 digest in %s", url)
+			}
+			return digest, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no SHA256 digest in %s", url)
+}
+
+// UpdateXray installs an Xray release from the configured mirror.
 func (s *ServerService) UpdateXray(version string) error {
-	// 1. Stop xray before doing anything
-	if err := s.StopXrayService(); err != nil {
-		logger.Warning("failed to stop xray before update:", err)
+	return s.UpdateXrayWithProgress(version, nil)
+}
+
+// UpdateXrayWithProgress installs an Xray release from the configured mirror and
+// reports its progress to progress, if set.
+func (s *ServerService) UpdateXrayWithProgress(version string, progress ProgressFunc) error {
+	mirror, err := s.settingService.GetXrayMirror()
+	if err != nil {
+		return err
+	}
+	return s.UpdateXrayFromMirror(version, mirror, progress)
+}
+
+// UpdateXrayFromMirror installs an Xray release downloaded from mirror
+// (DefaultXrayMirror if empty). The archive is verified before Xray is stopped.
+// progress, if set, is told the percentage done and the current stage.
+func (s *ServerService) UpdateXrayFromMirror(version string, mirror string, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(int, string) {}
 	}
 
-	// 2. Download the zip
-	zipFileName, err := s.downloadXRay(version)
+	// 1. Download and verify the zip
+	progress(0, "downloading")
+	zipFileName, err := s.downloadXRay(version, mirror, progress)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(zipFileName)
 
+	// 2. Stop xray only once the archive is known to be good
+	progress(80, "installing")
+	if err := s.StopXrayService(); err != nil {
+		logger.Warning("failed to stop xray before update:", err)
+	}
+
 	zipFile, err := os.Open(zipFileName)
 	if err != nil {
 		return err
@@ -689,6 +777,7 @@ func (s *ServerService) UpdateXray(version string) error {
 	}
 
 	// 5. Restart xray
+	progress(90, "restarting")
 	if err := s.xrayService.RestartXray(true); err != nil {
 		logger.Error("start xray failed:", err)
 		return err
@@ -1056,6 +1145,17 @@ func (s *ServerService) UpdateGeofile(fileName string) error {
 		{"https://github.com/runetfreedom/russia-v2ray-rules-dat/releases/latest/download/geosite.dat", "geosite_RU.dat"},
 	}
 
+	// Configured geo sources replace the download URLs of the built-in files
+	sources, err := s.settingService.GetGeoSources()
+	if err != nil {
+		logger.Warning("Invalid geo sources setting, using the built-in URLs:", err)
+	}
+	for i := range files {
+		if source, ok := sources[files[i].FileName]; ok {
+			files[i].URL = source
+		}
+	}
+
 	// Strict allowlist check to avoid writing uncontrolled files
 	if fileName != "" {
 		// Use the centralized validation function
@@ -1129,7 +1229,7 @@ func (s *ServerService) UpdateGeofile(fileName string) error {
 		}
 	}
 
-	err := s.RestartXrayService()
+	err = s.RestartXrayService()
 	if err != nil {
 		errorMessages = append(errorMessages, fmt.Sprintf("Updated Geofile '%s' but Failed to start Xray: %v", fileName, err))
 	}
@@ -1141,6 +1241,72 @@ func (s *ServerService) UpdateGeofile(fileName string) error {
 	return nil
 }
 
+// GetGeofileInfo returns the size, checksum and modification time of each
+// geo file present in the Xray bin folder.
+func (s *ServerService) GetGeofileInfo() ([]*GeoFileInfo, error) {
+	names := []string{
+		"geoip.dat", "geosite.dat",
+		"geoip_IR.dat", "geosite_IR.dat",
+		"geoip_RU.dat", "geosite_RU.dat",
+		"geoasn.dat",
+	}
+
+	infos := make([]*GeoFileInfo, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(config.GetBinFolderPath(), name)
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, &GeoFileInfo{
+			Name:       name,
+			Size:       stat.Size(),
+			SHA256:     hex.EncodeToString(hash.Sum(nil)),
+			ModifiedAt: stat.ModTime().Unix(),
+		})
+	}
+	return infos, nil
+}
+
+// GenerateKey generates key material of the given kind with this server's Xray:
+// "uuid", "x25519", "mldsa65", "mlkem768", "vlessenc" or "ech" (which needs sni).
+func (s *ServerService) GenerateKey(kind string, sni string) (any, error) {
+	switch kind {
+	case "uuid":
+		return s.GetNewUUID()
+	case "x25519":
+		return s.GetNewX25519Cert()
+	case "mldsa65":
+		return s.GetNewmldsa65()
+	case "mlkem768":
+		return s.GetNewmlkem768()
+	case "vlessenc":
+		return s.GetNewVlessEnc()
+	case "ech":
+		return s.GetNewEchCert(sni)
+	case "wireguard":
+		return GenerateWireguardKeys()
+	default:
+		return nil, common.NewErrorf("unknown key kind: %s", kind)
+	}
+}
+
 func (s *ServerService) GetNewX25519Cert() (any, error) {
 	// Run the command
 	cmd := exec.Command(xray.GetBinaryPath(), "x25519")
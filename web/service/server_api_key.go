@@ -0,0 +1,274 @@
+// Package service provides ServerAPIKeyService for API keys scoped to a single server.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+const (
+	// serverAPIKeyPrefix starts every server API key, so leaked keys are easy to recognize.
+	serverAPIKeyPrefix = "xsk_"
+	// serverAPITimeout bounds each call to the server made for a scoped request.
+	serverAPITimeout = 10 * time.Second
+)
+
+// ServerAPIStatus is the status of a server as seen through a scoped API key.
+type ServerAPIStatus struct {
+	Id          int          `json:"id"`
+	Name        string       `json:"name"`
+	Region      string       `json:"region"`
+	Status      string       `json:"status"`   // "pending", "online", "offline" or "error"
+	LastSeen    int64        `json:"lastSeen"` // Unix timestamp of the last successful health check
+	Version     string       `json:"version"`
+	XrayVersion string       `json:"xrayVersion"`
+	Stats       *SystemStats `json:"stats,omitempty"` // Omitted if the server could not be reached
+}
+
+// ServerAPIInbound is the traffic of one inbound as seen through a scoped API key.
+type ServerAPIInbound struct {
+	Id         int    `json:"id"`
+	Remark     string `json:"remark"`
+	Protocol   string `json:"protocol"`
+	Port       int    `json:"port"`
+	Enable     bool   `json:"enable"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Total      int64  `json:"total"` // Quota in bytes, 0 = unlimited
+	AllTime    int64  `json:"allTime"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// ServerAPITraffic is the traffic of a server as seen through a scoped API key.
+type ServerAPITraffic struct {
+	Up       int64               `json:"up"`
+	Down     int64               `json:"down"`
+	AllTime  int64               `json:"allTime"`
+	Inbounds []*ServerAPIInbound `json:"inbounds"`
+}
+
+// ServerAPIClient is a client of a server as seen through a scoped API key.
+type ServerAPIClient struct {
+	Email      string `json:"email"`
+	InboundId  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Online     bool   `json:"online"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Total      int64  `json:"total"`      // Quota in bytes, 0 = unlimited
+	ExpiryTime int64  `json:"expiryTime"` // Unix milliseconds, 0 = never
+	LastOnline int64  `json:"lastOnline"`
+}
+
+// ServerAPIKeyService manages API keys that give read access to the status, traffic and
+// clients of one server without exposing the rest of the fleet. Keys are only stored
+// hashed, so they are shown once when created.
+type ServerAPIKeyService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetKeys returns the API keys of a server.
+func (s *ServerAPIKeyService) GetKeys(serverId int) ([]*model.ServerAPIKey, error) {
+	var keys []*model.ServerAPIKey
+	err := database.GetDB().Model(model.ServerAPIKey{}).Where("server_id = ?", serverId).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// AddKey creates an API key for key.ServerId and returns the key.
+func (s *ServerAPIKeyService) AddKey(key *model.ServerAPIKey) (string, error) {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return "", fmt.Errorf("key name is required")
+	}
+	if key.ExpiresAt < 0 || (key.ExpiresAt > 0 && key.ExpiresAt <= time.Now().Unix()) {
+		return "", fmt.Errorf("expiry must be in the future, or 0 for keys that never expire")
+	}
+	if _, err := s.serverMgmt.GetServer(key.ServerId); err != nil {
+		return "", fmt.Errorf("server %d not found", key.ServerId)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := serverAPIKeyPrefix + hex.EncodeToString(secret)
+	key.Id = 0
+	key.KeyHash = hashServerAPIKey(token)
+	key.Prefix = token[:len(serverAPIKeyPrefix)+6]
+	key.LastUsedAt = 0
+	enable := key.Enable
+	if err := database.GetDB().Create(key).Error; err != nil {
+		return "", err
+	}
+	// Create leaves out a false Enable, so the column default would turn it into true
+	if !enable {
+		if err := s.SetKeyEnable(key.ServerId, key.Id, false); err != nil {
+			return "", err
+		}
+		key.Enable = false
+	}
+	return token, nil
+}
+
+// SetKeyEnable enables or disables an API key of a server.
+func (s *ServerAPIKeyService) SetKeyEnable(serverId, id int, enable bool) error {
+	result := database.GetDB().Model(&model.ServerAPIKey{}).
+		Where("id = ? AND server_id = ?", id, serverId).
+		Select("enable").Updates(&model.ServerAPIKey{Enable: enable})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("API key %d not found on server %d", id, serverId)
+	}
+	return nil
+}
+
+// DeleteKey revokes an API key of a server.
+func (s *ServerAPIKeyService) DeleteKey(serverId, id int) error {
+	result := database.GetDB().Where("id = ? AND server_id = ?", id, serverId).Delete(&model.ServerAPIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("API key %d not found on server %d", id, serverId)
+	}
+	return nil
+}
+
+// Authenticate returns the enabled, unexpired key matching token and the server it is
+// scoped to, and records its use.
+func (s *ServerAPIKeyService) Authenticate(token string) (*model.ServerAPIKey, *model.Server, error) {
+	if token == "" {
+		return nil, nil, fmt.Errorf("missing API key")
+	}
+
+	db := database.GetDB()
+	key := &model.ServerAPIKey{}
+	if err := db.Model(model.ServerAPIKey{}).Where("key_hash = ?", hashServerAPIKey(token)).First(key).Error; err != nil {
+		return nil, nil, fmt.Errorf("invalid API key")
+	}
+	if !key.Enable {
+		return nil, nil, fmt.Errorf("API key %s is disabled", key.Prefix)
+	}
+	now := time.Now().Unix()
+	if key.ExpiresAt > 0 && key.ExpiresAt <= now {
+		return nil, nil, fmt.Errorf("API key %s expired", key.Prefix)
+	}
+	server, err := s.serverMgmt.GetServer(key.ServerId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("server of API key %s not found", key.Prefix)
+	}
+
+	db.Model(key).UpdateColumn("last_used_at", now)
+	return key, server, nil
+}
+
+// GetStatus returns the status of a server, with its system stats if it can be reached.
+func (s *ServerAPIKeyService) GetStatus(ctx context.Context, server *model.Server) *ServerAPIStatus {
+	status := &ServerAPIStatus{
+		Id:          server.Id,
+		Name:        server.Name,
+		Region:      server.Region,
+		Status:      server.Status,
+		LastSeen:    server.LastSeen,
+		Version:     server.Version,
+		XrayVersion: server.XrayVersion,
+	}
+	if !server.Enabled {
+		return status
+	}
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return status
+	}
+	ctx, cancel := context.WithTimeout(ctx, serverAPITimeout)
+	defer cancel()
+	status.Stats, _ = connector.GetSystemStats(ctx)
+	return status
+}
+
+// GetTraffic returns the traffic of a server per inbound, without inbound settings.
+func (s *ServerAPIKeyService) GetTraffic(ctx context.Context, server *model.Server) (*ServerAPITraffic, error) {
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, serverAPITimeout)
+	defer cancel()
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := &ServerAPITraffic{Inbounds: make([]*ServerAPIInbound, 0, len(inbounds))}
+	for _, inbound := range inbounds {
+		traffic.Up += inbound.Up
+		traffic.Down += inbound.Down
+		traffic.AllTime += inbound.AllTime
+		traffic.Inbounds = append(traffic.Inbounds, &ServerAPIInbound{
+			Id:         inbound.Id,
+			Remark:     inbound.Remark,
+			Protocol:   string(inbound.Protocol),
+			Port:       inbound.Port,
+			Enable:     inbound.Enable,
+			Up:         inbound.Up,
+			Down:       inbound.Down,
+			Total:      inbound.Total,
+			AllTime:    inbound.AllTime,
+			ExpiryTime: inbound.ExpiryTime,
+		})
+	}
+	return traffic, nil
+}
+
+// GetClients returns the clients of a server with their usage, sorted by email, without
+// their credentials or subscription IDs.
+func (s *ServerAPIKeyService) GetClients(ctx context.Context, server *model.Server) ([]*ServerAPIClient, error) {
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, serverAPITimeout)
+	defer cancel()
+	traffics, err := connector.GetClientTraffics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Online state is optional, older agents may not report it
+	online, _ := connector.GetOnlineClients(ctx)
+
+	clients := make([]*ServerAPIClient, 0, len(traffics))
+	for _, traffic := range traffics {
+		clients = append(clients, &ServerAPIClient{
+			Email:      traffic.Email,
+			InboundId:  traffic.InboundId,
+			Enable:     traffic.Enable,
+			Online:     slices.Contains(online, traffic.Email),
+			Up:         traffic.Up,
+			Down:       traffic.Down,
+			Total:      traffic.Total,
+			ExpiryTime: traffic.ExpiryTime,
+			LastOnline: traffic.LastOnline,
+		})
+	}
+	slices.SortFunc(clients, func(a, b *ServerAPIClient) int {
+		return strings.Compare(a.Email, b.Email)
+	})
+	return clients, nil
+}
+
+// hashServerAPIKey returns the stored form of an API key.
+func hashServerAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/agent/agenttest"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestServerAPIKeyEnable checks that a key created disabled stays disabled despite the
+// column default, and that it can be turned on and off afterwards.
+func TestServerAPIKeyEnable(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	server := agent.Server(109, "api-keys")
+	if err := database.GetDB().Create(server).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Server{}, server.Id)
+	defer database.GetDB().Where("server_id = ?", server.Id).Delete(model.ServerAPIKey{})
+
+	keyService := &service.ServerAPIKeyService{}
+	key := &model.ServerAPIKey{ServerId: server.Id, Name: "portal", Enable: false}
+	token, err := keyService.AddKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyService.Authenticate(token); err == nil {
+		t.Error("expected a key created disabled not to authenticate")
+	}
+
+	if err := keyService.SetKeyEnable(server.Id, key.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyService.Authenticate(token); err != nil {
+		t.Errorf("expected the enabled key to authenticate, got %v", err)
+	}
+	if err := keyService.SetKeyEnable(server.Id, key.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyService.Authenticate(token); err == nil {
+		t.Error("expected the disabled key not to authenticate")
+	}
+	if err := keyService.SetKeyEnable(server.Id+1, key.Id, true); err == nil {
+		t.Error("expected a key of another server not to be found")
+	}
+}
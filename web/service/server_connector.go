@@ -5,6 +5,7 @@ import (
 	"context"
 
 	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/logtail"
 	"github.com/cofedish/3x-UI-agents/xray"
 )
 
@@ -28,11 +29,18 @@ type ServerConnector interface {
 	UpdateClient(ctx context.Context, inbound *model.Inbound, clientIndex int) error
 	DeleteClient(ctx context.Context, inboundId int, clientEmail string) error
 	ResetClientTraffic(ctx context.Context, inboundId int, email string) error
+	ResetInboundTraffic(ctx context.Context, inboundId int) error
 	GetOnlineClients(ctx context.Context) ([]string, error)
+	GetOnlineClientDetails(ctx context.Context) ([]*OnlineClient, error)
 
 	// Traffic & Stats
 	GetTraffic(ctx context.Context, reset bool) (*xray.Traffic, error)
 	GetClientTraffics(ctx context.Context) ([]*xray.ClientTraffic, error)
+	// GetClientTrafficsSince returns the client traffics changed since a cursor (0 for all)
+	// and the cursor for the next call; a returned cursor of 0 means a full snapshot.
+	GetClientTrafficsSince(ctx context.Context, since int64) ([]*xray.ClientTraffic, int64, error)
+	GetOutboundTraffics(ctx context.Context) ([]*model.OutboundTraffics, error)
+	GetConnectionCounts(ctx context.Context) (map[int]int, error) // Established TCP connections per local port
 
 	// Xray Control
 	StartXray(ctx context.Context) error
@@ -40,12 +48,26 @@ type ServerConnector interface {
 	RestartXray(ctx context.Context) error
 	GetXrayVersion(ctx context.Context) (string, error)
 	GetXrayConfig(ctx context.Context) (string, error)
+	GetCapabilities(ctx context.Context) (*XrayCapabilities, error)
+
+	// Xray API passthrough (runtime only, not persisted)
+	XrayAddUser(ctx context.Context, req *XrayUserRequest) error
+	XrayRemoveUser(ctx context.Context, inboundTag, email string) error
+	XrayQueryStats(ctx context.Context, pattern string) (map[string]int64, error)
 
 	// System Operations
 	GetSystemStats(ctx context.Context) (*SystemStats, error)
 	GetLogs(ctx context.Context, count int) ([]string, error)
+	StreamLogs(ctx context.Context, req *LogStreamRequest, send func(*logtail.Line) error) error // Until ctx is done
 	UpdateGeoFiles(ctx context.Context) error
-	InstallXray(ctx context.Context, version string) error
+	GetGeoFiles(ctx context.Context) ([]*GeoFileInfo, error)
+	InstallXray(ctx context.Context, version string, progress ProgressFunc) error // progress may be nil
+	UpdateAgent(ctx context.Context, version string, progress ProgressFunc) error // Agents only; progress may be nil
+	SyncBlockedIPs(ctx context.Context, entries []*model.BlockedIP) error
+	ApplySettings(ctx context.Context, settings *FleetSettings) error
+
+	// Key material generated by the server's own Xray (see ServerService.GenerateKey)
+	GenerateKey(ctx context.Context, kind string, sni string) (any, error)
 
 	// Certificates
 	GenerateCert(ctx context.Context, domain string) (*CertInfo, error)
@@ -70,12 +92,14 @@ type ServerInfo struct {
 
 // HealthStatus represents the current health status of a server.
 type HealthStatus struct {
-	Status      string `json:"status"`        // "online", "offline", "error"
-	XrayRunning bool   `json:"xray_running"`  // Is Xray process running (agent reports snake_case)
+	Status      string `json:"status"`       // "online", "offline", "error"
+	XrayRunning bool   `json:"xray_running"` // Is Xray process running (agent reports snake_case)
 	Version     string `json:"version"`
 	XrayVersion string `json:"xray_version"`
+	XrayError   string `json:"xray_error,omitempty"` // Why Xray is not running: the last start error or the output of the exited process
 	LastError   string `json:"lastError,omitempty"`
-	Timestamp   int64  `json:"timestamp"` // Unix timestamp of health check
+	Timestamp   int64  `json:"timestamp"`    // Unix timestamp of health check
+	ConfigEpoch *int   `json:"config_epoch"` // Epoch of the last config change applied, nil for agents that do not report it
 }
 
 // SystemStats contains system resource usage information.
@@ -111,6 +135,46 @@ type SystemStats struct {
 	XrayConnections int    `json:"xrayConnections"` // Active Xray client connections
 	PublicIPv4      string `json:"publicIPv4"`
 	PublicIPv6      string `json:"publicIPv6"`
+
+	// Agent process (the panel's for the local server), 0 for agents that do not report it
+	AppMem        uint64 `json:"appMem"`        // Bytes obtained from the OS by the Go runtime
+	AppGoroutines int    `json:"appGoroutines"` // Running goroutines
+	AppUptime     int64  `json:"appUptime"`     // Seconds since the process started
+	XrayUptime    int64  `json:"xrayUptime"`    // Seconds since Xray started, 0 = not running
+}
+
+// OnlineClient describes an online client and the addresses it connects from.
+type OnlineClient struct {
+	Email      string            `json:"email"`
+	ServerId   int               `json:"serverId"`
+	ServerName string            `json:"serverName,omitempty"` // Set in listings across servers
+	ServerTags []string          `json:"serverTags,omitempty"`
+	IPCount    int               `json:"ipCount"` // Number of concurrently online source IPs, not connections
+	IPs        []*OnlineClientIP `json:"ips"`
+}
+
+// OnlineClientIP is a client source address enriched with GeoIP data.
+type OnlineClientIP struct {
+	IP       string `json:"ip"`
+	LastSeen int64  `json:"lastSeen,omitempty"` // Unix timestamp (0 if unknown)
+	Country  string `json:"country,omitempty"`  // ISO 3166-1 alpha-2 code
+	ASN      string `json:"asn,omitempty"`      // e.g. "AS13335"
+}
+
+// XrayUserRequest describes a user to add to a running inbound via the Xray API.
+// User holds the protocol-specific account fields ("email", "id", "password", "flow", ...).
+type XrayUserRequest struct {
+	Protocol   string         `json:"protocol"`
+	InboundTag string         `json:"inboundTag"`
+	User       map[string]any `json:"user"`
+}
+
+// GeoFileInfo describes a geo data file installed next to the Xray binary.
+type GeoFileInfo struct {
+	Name       string `json:"name"`       // e.g. "geoip.dat"
+	Size       int64  `json:"size"`       // Bytes
+	SHA256     string `json:"sha256"`     // Hex digest of the file contents
+	ModifiedAt int64  `json:"modifiedAt"` // Unix timestamp of the last modification
 }
 
 // CertInfo contains SSL/TLS certificate information.
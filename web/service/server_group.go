@@ -0,0 +1,210 @@
+// Package service provides ServerGroupService for named server groups targeted by bulk operations.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+const (
+	// groupOperationConcurrency is how many members of a group a bulk operation runs on at once.
+	groupOperationConcurrency = 5
+	// groupOperationTimeout bounds a bulk operation on one member.
+	groupOperationTimeout = 2 * time.Minute
+)
+
+// ServerGroupService manages server groups and runs bulk operations on their members.
+type ServerGroupService struct {
+	serverMgmt ServerManagementService
+	geoUpdate  GeoUpdateService
+}
+
+// ServerGroupResult is the outcome of a bulk operation on one member of a group.
+type ServerGroupResult struct {
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// GetGroups returns all groups.
+func (s *ServerGroupService) GetGroups() ([]*model.ServerGroup, error) {
+	db := database.GetDB()
+	var groups []*model.ServerGroup
+	err := db.Model(model.ServerGroup{}).Order("name").Find(&groups).Error
+	return groups, err
+}
+
+// GetGroup returns a group by ID, or by name if ref is not a number.
+func (s *ServerGroupService) GetGroup(ref string) (*model.ServerGroup, error) {
+	db := database.GetDB().Model(model.ServerGroup{})
+	if id, err := strconv.Atoi(ref); err == nil {
+		db = db.Where("id = ?", id)
+	} else {
+		db = db.Where("name = ?", ref)
+	}
+
+	group := &model.ServerGroup{}
+	if err := db.First(group).Error; err != nil {
+		return nil, fmt.Errorf("group %s not found", ref)
+	}
+	return group, nil
+}
+
+// AddGroup creates a group.
+func (s *ServerGroupService) AddGroup(group *model.ServerGroup) error {
+	if err := s.validateGroup(group); err != nil {
+		return err
+	}
+	group.Id = 0
+	return database.GetDB().Create(group).Error
+}
+
+// UpdateGroup replaces the name, description and members of a group.
+func (s *ServerGroupService) UpdateGroup(group *model.ServerGroup) error {
+	if err := s.validateGroup(group); err != nil {
+		return err
+	}
+	existing, err := s.GetGroup(strconv.Itoa(group.Id))
+	if err != nil {
+		return err
+	}
+
+	existing.Name = group.Name
+	existing.Description = group.Description
+	existing.ServerIds = group.ServerIds
+	return database.GetDB().Save(existing).Error
+}
+
+// DeleteGroup removes a group. Its servers are not affected.
+func (s *ServerGroupService) DeleteGroup(id int) error {
+	result := database.GetDB().Delete(model.ServerGroup{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("group %d not found", id)
+	}
+	return nil
+}
+
+// GetMembers returns the servers of a group, only enabled ones if enabledOnly is set.
+// Members that were deleted since the group was saved are skipped.
+func (s *ServerGroupService) GetMembers(group *model.ServerGroup, enabledOnly bool) ([]*model.Server, error) {
+	var serverIds []int
+	json.Unmarshal([]byte(group.ServerIds), &serverIds)
+
+	var servers []*model.Server
+	var err error
+	if enabledOnly {
+		servers, err = s.serverMgmt.GetEnabledServers()
+	} else {
+		servers, err = s.serverMgmt.GetAllServers()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*model.Server, 0, len(serverIds))
+	for _, server := range servers {
+		if slices.Contains(serverIds, server.Id) {
+			members = append(members, server)
+		}
+	}
+	return members, nil
+}
+
+// ResolveGroup returns the enabled members of the group with the given ID or name, the
+// targets of bulk operations.
+func (s *ServerGroupService) ResolveGroup(ref string) ([]*model.Server, error) {
+	group, err := s.GetGroup(ref)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := s.GetMembers(group, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("group %s has no enabled servers", group.Name)
+	}
+	return servers, nil
+}
+
+// RestartXray restarts Xray on every enabled member of a group.
+func (s *ServerGroupService) RestartXray(ref string) ([]*ServerGroupResult, error) {
+	return s.runOnGroup(ref, "Xray restart", func(ctx context.Context, serverId int) error {
+		connector, err := s.serverMgmt.GetConnector(serverId)
+		if err != nil {
+			return err
+		}
+		return connector.RestartXray(ctx)
+	})
+}
+
+// UpdateGeoFiles updates the geo files of every enabled member of a group and records
+// the outcome like scheduled updates do.
+func (s *ServerGroupService) UpdateGeoFiles(ref string) ([]*ServerGroupResult, error) {
+	return s.runOnGroup(ref, "geo file update", func(ctx context.Context, serverId int) error {
+		_, err := s.geoUpdate.UpdateServer(ctx, serverId)
+		return err
+	})
+}
+
+// runOnGroup calls fn for every enabled member of a group, a few at a time, and returns
+// the result of each member once all are done.
+func (s *ServerGroupService) runOnGroup(ref, operation string, fn func(ctx context.Context, serverId int) error) ([]*ServerGroupResult, error) {
+	servers, err := s.ResolveGroup(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := FanOut(context.Background(), servers, FanOutOptions{Concurrency: groupOperationConcurrency, Timeout: groupOperationTimeout},
+		func(ctx context.Context, server *model.Server) (struct{}, error) {
+			return struct{}{}, fn(ctx, server.Id)
+		})
+	results := make([]*ServerGroupResult, len(outcomes))
+	for i, outcome := range outcomes {
+		results[i] = &ServerGroupResult{ServerId: outcome.Server.Id, ServerName: outcome.Server.Name, Success: outcome.Err == nil}
+		if outcome.Err != nil {
+			results[i].Error = outcome.Err.Error()
+			logger.Warningf("Group %s failed: %v", operation, outcome.Err)
+		}
+	}
+	return results, nil
+}
+
+// validateGroup checks a group's fields before it is saved.
+func (s *ServerGroupService) validateGroup(group *model.ServerGroup) error {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return fmt.Errorf("group name is required")
+	}
+	if _, err := strconv.Atoi(group.Name); err == nil {
+		return fmt.Errorf("group name must not be a number")
+	}
+	group.Description = strings.TrimSpace(group.Description)
+
+	if group.ServerIds == "" {
+		group.ServerIds = "[]"
+	}
+	var serverIds []int
+	if err := json.Unmarshal([]byte(group.ServerIds), &serverIds); err != nil {
+		return fmt.Errorf("serverIds must be a JSON array of server IDs: %w", err)
+	}
+	for _, id := range serverIds {
+		if _, err := s.serverMgmt.GetServer(id); err != nil {
+			return fmt.Errorf("server %d not found", id)
+		}
+	}
+	return nil
+}
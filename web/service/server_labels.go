@@ -0,0 +1,103 @@
+// Package service provides server label filters for cross-server listings.
+package service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// ServerFilter selects servers by their labels in listings that span servers. Empty
+// fields match every server; all set fields must match.
+type ServerFilter struct {
+	Tags   []string // At least one server tag must match (case-insensitive)
+	Region string   // Server region (case-insensitive)
+	Scope  string   // ID or name of a saved server scope
+}
+
+// ParseServerFilter builds a filter from comma-separated tags, a region and a scope.
+func ParseServerFilter(tags, region, scope string) ServerFilter {
+	filter := ServerFilter{Region: strings.TrimSpace(region), Scope: strings.TrimSpace(scope)}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+	return filter
+}
+
+// IsEmpty reports whether the filter matches every server.
+func (f ServerFilter) IsEmpty() bool {
+	return len(f.Tags) == 0 && f.Region == "" && f.Scope == ""
+}
+
+// ServerTags returns the tags of a server, which are stored as a JSON array.
+func ServerTags(server *model.Server) []string {
+	tags := make([]string, 0)
+	json.Unmarshal([]byte(server.Tags), &tags)
+	return tags
+}
+
+// FilterServers returns the servers matching filter, keeping their order.
+func (s *ServerManagementService) FilterServers(servers []*model.Server, filter ServerFilter) ([]*model.Server, error) {
+	if filter.IsEmpty() {
+		return servers, nil
+	}
+
+	var inScope map[int]bool
+	if filter.Scope != "" {
+		scopeService := ServerScopeService{}
+		scope, err := scopeService.GetScope(filter.Scope)
+		if err != nil {
+			return nil, err
+		}
+		members, err := scopeService.ResolveScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		inScope = make(map[int]bool, len(members))
+		for _, member := range members {
+			inScope[member.Id] = true
+		}
+	}
+
+	matched := make([]*model.Server, 0, len(servers))
+	for _, server := range servers {
+		if inScope != nil && !inScope[server.Id] {
+			continue
+		}
+		if filter.Region != "" && !strings.EqualFold(server.Region, filter.Region) {
+			continue
+		}
+		if len(filter.Tags) > 0 {
+			found := false
+			for _, tag := range ServerTags(server) {
+				if containsFold(filter.Tags, tag) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		matched = append(matched, server)
+	}
+	return matched, nil
+}
+
+// GetServersByFilter returns the servers matching filter, only enabled ones if enabledOnly is set.
+func (s *ServerManagementService) GetServersByFilter(filter ServerFilter, enabledOnly bool) ([]*model.Server, error) {
+	var servers []*model.Server
+	var err error
+	if enabledOnly {
+		servers, err = s.GetEnabledServers()
+	} else {
+		servers, err = s.GetAllServers()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.FilterServers(servers, filter)
+}
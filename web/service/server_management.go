@@ -2,11 +2,13 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
 )
 
 // ServerManagementService manages the list of servers (local and remote).
@@ -51,13 +53,53 @@ func (s *ServerManagementService) GetServer(id int) (*model.Server, error) {
 	return &server, nil
 }
 
-// GetLocalServer returns the default local server (ID=1).
+// defaultLocalServerId is the ID the multi-server migration gives the local server. It is
+// only used when no server has auth type "local".
+const defaultLocalServerId = 1
+
+// GetLocalServer returns the local server (the lowest ID if several are marked local).
 func (s *ServerManagementService) GetLocalServer() (*model.Server, error) {
-	return s.GetServer(1)
+	return s.GetServer(s.GetLocalServerId())
+}
+
+// GetLocalServerId returns the ID of the local server, which owns the inbounds, traffic and
+// client IPs of the panel's own Xray instance.
+func (s *ServerManagementService) GetLocalServerId() int {
+	var ids []int
+	err := database.GetDB().Model(&model.Server{}).Where("auth_type = ?", "local").Order("id").Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return defaultLocalServerId
+	}
+	return ids[0]
+}
+
+// IsLocalServer reports whether a server is managed in-process rather than through an agent.
+// Unknown servers are not local, so a stale ID cannot be routed to the local instance.
+func (s *ServerManagementService) IsLocalServer(serverId int) bool {
+	server, err := s.GetServer(serverId)
+	if err != nil {
+		return false
+	}
+	return server.IsLocal()
 }
 
 // AddServer creates a new server.
+// The endpoint of remote servers is validated and normalized before saving.
 func (s *ServerManagementService) AddServer(server *model.Server) error {
+	if server.AuthType != "local" {
+		endpoint, err := NormalizeEndpoint(server.Endpoint)
+		if err != nil {
+			return err
+		}
+		server.Endpoint = endpoint
+	}
+
+	authData, err := sealAuthData(server.AuthData)
+	if err != nil {
+		return err
+	}
+	server.AuthData = authData
+
 	db := database.GetDB()
 
 	// Set timestamps
@@ -70,7 +112,7 @@ func (s *ServerManagementService) AddServer(server *model.Server) error {
 		server.Status = "pending"
 	}
 
-	err := db.Create(server).Error
+	err = db.Create(server).Error
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
@@ -80,12 +122,27 @@ func (s *ServerManagementService) AddServer(server *model.Server) error {
 
 // UpdateServer updates an existing server.
 func (s *ServerManagementService) UpdateServer(server *model.Server) error {
+	if !server.IsLocal() {
+		endpoint, err := NormalizeEndpoint(server.Endpoint)
+		if err != nil {
+			return err
+		}
+		server.Endpoint = endpoint
+	}
+
+	authData, err := sealAuthData(server.AuthData)
+	if err != nil {
+		return err
+	}
+	server.AuthData = authData
+
 	db := database.GetDB()
 
 	// Update timestamp
 	server.UpdatedAt = time.Now().Unix()
 
-	err := db.Save(server).Error
+	// The config epoch is only moved by changes sent to the agent and health checks
+	err = db.Omit("config_epoch", "agent_epoch", "config_out_of_sync").Save(server).Error
 	if err != nil {
 		return fmt.Errorf("failed to update server: %w", err)
 	}
@@ -94,9 +151,9 @@ func (s *ServerManagementService) UpdateServer(server *model.Server) error {
 }
 
 // DeleteServer deletes a server by ID.
-// Note: Cannot delete local server (ID=1).
+// Note: Cannot delete the local server.
 func (s *ServerManagementService) DeleteServer(id int) error {
-	if id == 1 {
+	if s.IsLocalServer(id) {
 		return fmt.Errorf("cannot delete local server")
 	}
 
@@ -114,6 +171,11 @@ func (s *ServerManagementService) DeleteServer(id int) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete server: %w", err)
 	}
+	evictConnector(id)
+	forgetConnectorMetrics(id)
+	if err := db.Where("server_id = ?", id).Delete(&model.ServerAPIKey{}).Error; err != nil {
+		logger.Warning("Failed to delete API keys of server", id, ":", err)
+	}
 
 	return nil
 }
@@ -122,6 +184,9 @@ func (s *ServerManagementService) DeleteServer(id int) error {
 func (s *ServerManagementService) UpdateServerStatus(id int, status string, lastError string) error {
 	db := database.GetDB()
 
+	var previous string
+	db.Model(&model.Server{}).Where("id = ?", id).Pluck("status", &previous)
+
 	updates := map[string]interface{}{
 		"status":     status,
 		"last_seen":  time.Now().Unix(),
@@ -139,6 +204,99 @@ func (s *ServerManagementService) UpdateServerStatus(id int, status string, last
 		return fmt.Errorf("failed to update server status: %w", err)
 	}
 
+	if previous != status {
+		message := fmt.Sprintf("Status changed from %s to %s", previous, status)
+		if lastError != "" {
+			message += ": " + lastError
+		}
+		recordServerEvent(id, "status", message)
+
+		var name string
+		db.Model(&model.Server{}).Where("id = ?", id).Pluck("name", &name)
+		publishEvent(EventServerStatus, map[string]any{
+			"serverId":   id,
+			"serverName": name,
+			"previous":   previous,
+			"status":     status,
+			"error":      lastError,
+		})
+
+		var incidents IncidentService
+		incidents.trackStatus(id, previous, status, lastError)
+	}
+
+	return nil
+}
+
+// UpdateClockSkew stores the measured clock skew of a server and flags it with a warning
+// if the drift exceeds threshold seconds (a negative threshold disables the warning).
+// Drift breaks JWT expiry checks and traffic timestamps, so changes are recorded as events.
+func (s *ServerManagementService) UpdateClockSkew(id int, skew int64, threshold int) error {
+	db := database.GetDB()
+
+	var previous string
+	db.Model(&model.Server{}).Where("id = ?", id).Pluck("warning", &previous)
+
+	warning := ""
+	if threshold >= 0 && (skew > int64(threshold) || skew < -int64(threshold)) {
+		direction := "ahead of"
+		if skew < 0 {
+			direction = "behind"
+		}
+		warning = fmt.Sprintf("Clock is %ds %s the panel", max(skew, -skew), direction)
+	}
+
+	updates := map[string]interface{}{
+		"clock_skew": skew,
+		"warning":    warning,
+	}
+	if err := db.Model(&model.Server{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update clock skew: %w", err)
+	}
+
+	switch {
+	case warning != "" && previous == "":
+		logger.Warningf("Server %d: %s", id, warning)
+		recordServerEvent(id, "status", warning)
+	case warning == "" && previous != "":
+		recordServerEvent(id, "status", "Clock is back in sync with the panel")
+	}
+	return nil
+}
+
+// UpdateConfigEpoch stores the config epoch reported by a server's agent and flags the
+// server as out of sync if it differs from the epoch of the last change the panel made,
+// e.g. because a change was lost or the agent database was restored. Changes of the flag
+// are recorded as events. A new agent epoch drops the cached inbound list of the server.
+func (s *ServerManagementService) UpdateConfigEpoch(id int, agentEpoch int) error {
+	db := database.GetDB()
+
+	server := &model.Server{}
+	if err := db.Model(&model.Server{}).Select("config_epoch", "agent_epoch", "config_out_of_sync").Where("id = ?", id).First(server).Error; err != nil {
+		return fmt.Errorf("failed to load config epoch: %w", err)
+	}
+	outOfSync := agentEpoch != server.ConfigEpoch
+
+	updates := map[string]interface{}{
+		"agent_epoch":        agentEpoch,
+		"config_out_of_sync": outOfSync,
+	}
+	if err := db.Model(&model.Server{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update config epoch: %w", err)
+	}
+	// The agent config changed since the last check, possibly without going through the panel
+	if agentEpoch != server.AgentEpoch {
+		InvalidateInboundCache(id)
+	}
+
+	switch {
+	case outOfSync && !server.ConfigOutOfSync:
+		message := fmt.Sprintf("Config out of sync: agent is at epoch %d, panel at %d", agentEpoch, server.ConfigEpoch)
+		logger.Warningf("Server %d: %s", id, message)
+		recordServerEvent(id, "config", message)
+	case !outOfSync && server.ConfigOutOfSync:
+		recordServerEvent(id, "config", fmt.Sprintf("Config back in sync at epoch %d", agentEpoch))
+	}
 	return nil
 }
 
@@ -175,29 +333,33 @@ func (s *ServerManagementService) IsSingleServerMode() (bool, error) {
 }
 
 // GetConnector returns the appropriate ServerConnector for a given server ID.
+// Remote connectors are pooled per server and rebuilt when its endpoint or auth data changes.
 func (s *ServerManagementService) GetConnector(serverId int) (ServerConnector, error) {
 	server, err := s.GetServer(serverId)
 	if err != nil {
 		return nil, err
 	}
 
-	// Server ID 1 is always local (backward compatibility)
-	if serverId == 1 {
-		return NewLocalConnector(serverId), nil
+	// The local server is found by its auth type, not its ID, so restored databases
+	// with different IDs still route to the right instance
+	// XUI_CHAOS may wrap the connector with fault injection
+	if server.IsLocal() {
+		return NewChaosConnector(NewLocalConnector(serverId), server), nil
+	}
+	if server.AuthType == "demo" {
+		return NewChaosConnector(NewDemoConnector(server), server), nil
 	}
 
-	// All other servers (ID > 1) are remote, regardless of authType
-	// This handles cases where authType might be incorrectly set
-	connector, err := NewRemoteConnector(server)
+	connector, err := getPooledConnector(server)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create remote connector: %w", err)
 	}
 
-	return connector, nil
+	return NewChaosConnector(connector, server), nil
 }
 
 // GetDefaultServerId returns the server ID to use when none is specified.
-// In single-server mode, always returns 1.
+// In single-server mode, always returns the local server.
 // In multi-server mode, returns the first enabled server.
 func (s *ServerManagementService) GetDefaultServerId() (int, error) {
 	isSingle, err := s.IsSingleServerMode()
@@ -206,7 +368,7 @@ func (s *ServerManagementService) GetDefaultServerId() (int, error) {
 	}
 
 	if isSingle {
-		return 1, nil
+		return s.GetLocalServerId(), nil
 	}
 
 	// Return first enabled server
@@ -221,3 +383,103 @@ func (s *ServerManagementService) GetDefaultServerId() (int, error) {
 
 	return servers[0].Id, nil
 }
+
+// CheckInboundCapabilities rejects inbound options that the Xray core of the target
+// server does not support. If the server cannot be asked, the check is skipped and
+// the server itself reports the problem when the inbound is pushed.
+func (s *ServerManagementService) CheckInboundCapabilities(ctx context.Context, serverId int, inbound *model.Inbound) error {
+	connector, err := s.GetConnector(serverId)
+	if err != nil {
+		return nil
+	}
+	caps, err := connector.GetCapabilities(ctx)
+	if err != nil {
+		logger.Warning("Failed to get capabilities of server", serverId, ":", err)
+		return nil
+	}
+	return caps.CheckInbound(inbound)
+}
+
+// GetPublicHost returns the address clients use to reach a server: the endpoint host
+// of remote servers, or the subscription domain (else the web domain) for the local server.
+func (s *ServerManagementService) GetPublicHost(serverId int) (string, error) {
+	server, err := s.GetServer(serverId)
+	if err != nil {
+		return "", err
+	}
+	if !server.IsLocal() {
+		if host := EndpointHost(server.Endpoint); host != "" {
+			return host, nil
+		}
+		return "", fmt.Errorf("server %d has no endpoint host", serverId)
+	}
+
+	// The local server has no agent endpoint
+	settingService := SettingService{}
+	if domain, err := settingService.GetSubDomain(); err == nil && domain != "" {
+		return domain, nil
+	}
+	if domain, err := settingService.GetWebDomain(); err == nil && domain != "" {
+		return domain, nil
+	}
+	return "", fmt.Errorf("set the panel domain to publish the local server's address")
+}
+
+// GetServerLocation returns the timezone schedules and reports of a server use: its own
+// timezone if set, otherwise the panel timezone.
+func (s *ServerManagementService) GetServerLocation(serverId int) *time.Location {
+	server, err := s.GetServer(serverId)
+	if err != nil {
+		return panelLocation()
+	}
+	return ServerLocation(server)
+}
+
+// ServerLocation returns the timezone of a server, or the panel timezone if it has none.
+func ServerLocation(server *model.Server) *time.Location {
+	if server.Timezone != "" {
+		if loc, err := time.LoadLocation(server.Timezone); err == nil {
+			return loc
+		}
+		logger.Warningf("Server %s has an unknown timezone %q, using the panel timezone", server.Name, server.Timezone)
+	}
+	return panelLocation()
+}
+
+// panelLocation returns the timezone configured for the panel.
+func panelLocation() *time.Location {
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ResolveInboundAddress returns the address clients use to reach an inbound of a server:
+// its AddressOverride if set, otherwise the server's public host.
+func (s *ServerManagementService) ResolveInboundAddress(serverId int, inbound *model.Inbound) (string, error) {
+	if inbound.AddressOverride != "" {
+		return inbound.AddressOverride, nil
+	}
+	return s.GetPublicHost(serverId)
+}
+
+// ResolveServerAddresses sets ServerAddress on inbounds of a server as resolved by
+// ResolveInboundAddress. It is left empty if no address is known, so the panel falls
+// back to the address it is opened on.
+func (s *ServerManagementService) ResolveServerAddresses(serverId int, inbounds ...*model.Inbound) {
+	var host string
+	resolved := false
+	for _, inbound := range inbounds {
+		if inbound.AddressOverride != "" {
+			inbound.ServerAddress = inbound.AddressOverride
+			continue
+		}
+		if !resolved {
+			host, _ = s.GetPublicHost(serverId)
+			resolved = true
+		}
+		inbound.ServerAddress = host
+	}
+}
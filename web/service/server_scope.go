@@ -0,0 +1,153 @@
+// Package service provides ServerScopeService for named server selections used by dashboards.
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// ServerScopeService manages named server scopes and resolves them to servers.
+type ServerScopeService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetScopes returns all scopes.
+func (s *ServerScopeService) GetScopes() ([]*model.ServerScope, error) {
+	db := database.GetDB()
+	var scopes []*model.ServerScope
+	err := db.Model(model.ServerScope{}).Order("name").Find(&scopes).Error
+	return scopes, err
+}
+
+// GetScope returns a scope by ID, or by name if ref is not a number.
+func (s *ServerScopeService) GetScope(ref string) (*model.ServerScope, error) {
+	db := database.GetDB().Model(model.ServerScope{})
+	if id, err := strconv.Atoi(ref); err == nil {
+		db = db.Where("id = ?", id)
+	} else {
+		db = db.Where("name = ?", ref)
+	}
+
+	scope := &model.ServerScope{}
+	if err := db.First(scope).Error; err != nil {
+		return nil, fmt.Errorf("scope %s not found", ref)
+	}
+	return scope, nil
+}
+
+// AddScope creates a scope.
+func (s *ServerScopeService) AddScope(scope *model.ServerScope) error {
+	if err := s.validateScope(scope); err != nil {
+		return err
+	}
+	scope.Id = 0
+	return database.GetDB().Create(scope).Error
+}
+
+// UpdateScope replaces the name and criteria of a scope.
+func (s *ServerScopeService) UpdateScope(scope *model.ServerScope) error {
+	if err := s.validateScope(scope); err != nil {
+		return err
+	}
+	existing, err := s.GetScope(strconv.Itoa(scope.Id))
+	if err != nil {
+		return err
+	}
+
+	existing.Name = scope.Name
+	existing.Tags = scope.Tags
+	existing.Regions = scope.Regions
+	existing.ServerIds = scope.ServerIds
+	return database.GetDB().Save(existing).Error
+}
+
+// DeleteScope removes a scope.
+func (s *ServerScopeService) DeleteScope(id int) error {
+	result := database.GetDB().Delete(model.ServerScope{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("scope %d not found", id)
+	}
+	return nil
+}
+
+// ResolveScope returns the servers matching any criterion of the scope.
+func (s *ServerScopeService) ResolveScope(scope *model.ServerScope) ([]*model.Server, error) {
+	servers, err := s.serverMgmt.GetAllServers()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags, regions []string
+	var serverIds []int
+	json.Unmarshal([]byte(scope.Tags), &tags)
+	json.Unmarshal([]byte(scope.Regions), &regions)
+	json.Unmarshal([]byte(scope.ServerIds), &serverIds)
+
+	matched := make([]*model.Server, 0)
+	for _, server := range servers {
+		if slices.Contains(serverIds, server.Id) || containsFold(regions, server.Region) {
+			matched = append(matched, server)
+			continue
+		}
+		var serverTags []string
+		json.Unmarshal([]byte(server.Tags), &serverTags)
+		for _, tag := range serverTags {
+			if containsFold(tags, tag) {
+				matched = append(matched, server)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+// validateScope checks a scope's fields before it is saved.
+func (s *ServerScopeService) validateScope(scope *model.ServerScope) error {
+	scope.Name = strings.TrimSpace(scope.Name)
+	if scope.Name == "" {
+		return fmt.Errorf("scope name is required")
+	}
+	if _, err := strconv.Atoi(scope.Name); err == nil {
+		return fmt.Errorf("scope name must not be a number")
+	}
+
+	for field, value := range map[string]*string{"tags": &scope.Tags, "regions": &scope.Regions} {
+		if *value == "" {
+			*value = "[]"
+		}
+		var list []string
+		if err := json.Unmarshal([]byte(*value), &list); err != nil {
+			return fmt.Errorf("%s must be a JSON array of strings: %w", field, err)
+		}
+	}
+	if scope.ServerIds == "" {
+		scope.ServerIds = "[]"
+	}
+	var serverIds []int
+	if err := json.Unmarshal([]byte(scope.ServerIds), &serverIds); err != nil {
+		return fmt.Errorf("serverIds must be a JSON array of server IDs: %w", err)
+	}
+	return nil
+}
+
+// containsFold reports whether list contains value, ignoring case.
+func containsFold(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
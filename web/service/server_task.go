@@ -0,0 +1,81 @@
+// Package service provides ServerTaskService for listing server tasks across the fleet.
+package service
+
+import (
+	"fmt"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+)
+
+// TaskQuery selects tasks for the fleet task list.
+type TaskQuery struct {
+	ServerId  int    // 0 = all servers
+	Operation string // e.g. "install_xray", empty = all
+	Status    string // "pending", "running", "completed" or "failed", empty = all
+	Filter    ServerFilter
+	Limit     int // Default 100, at most 1000
+}
+
+// ServerTaskService lists server tasks with the owning server's labels.
+type ServerTaskService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetTasks returns the tasks matching query, newest first, each with the name and tags
+// of its server.
+func (s *ServerTaskService) GetTasks(query *TaskQuery) ([]*model.ServerTask, error) {
+	if query.Limit <= 0 || query.Limit > 1000 {
+		query.Limit = 100
+	}
+
+	servers, err := s.serverMgmt.GetServersByFilter(query.Filter, false)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[int]*model.Server, len(servers))
+	ids := make([]int, 0, len(servers))
+	for _, server := range servers {
+		byId[server.Id] = server
+		ids = append(ids, server.Id)
+	}
+
+	db := database.GetDB().Model(model.ServerTask{})
+	if !query.Filter.IsEmpty() {
+		db = db.Where("server_id IN ?", ids)
+	}
+	if query.ServerId != 0 {
+		db = db.Where("server_id = ?", query.ServerId)
+	}
+	if query.Operation != "" {
+		db = db.Where("operation = ?", query.Operation)
+	}
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+
+	var tasks []*model.ServerTask
+	if err := db.Order("id DESC").Limit(query.Limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		if server, ok := byId[task.ServerId]; ok {
+			task.ServerName = server.Name
+			task.ServerTags = ServerTags(server)
+		}
+	}
+	return tasks, nil
+}
+
+// GetTask returns a task with the name and tags of its server.
+func (s *ServerTaskService) GetTask(id int) (*model.ServerTask, error) {
+	task := &model.ServerTask{}
+	if err := database.GetDB().Model(model.ServerTask{}).Where("id = ?", id).First(task).Error; err != nil {
+		return nil, fmt.Errorf("task %d not found", id)
+	}
+	if server, err := s.serverMgmt.GetServer(task.ServerId); err == nil {
+		task.ServerName = server.Name
+		task.ServerTags = ServerTags(server)
+	}
+	return task, nil
+}
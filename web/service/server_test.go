@@ -0,0 +1,53 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestDownloadXrayUsesUpstreamDigest serves a tampered archive with a matching digest
+// from a mirror and expects the download to be rejected against the upstream digest.
+func TestDownloadXrayUsesUpstreamDigest(t *testing.T) {
+	digest := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return "SHA2-256= " + hex.EncodeToString(sum[:]) + "\n"
+	}
+	release := func(archive string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, ".dgst") {
+				w.Write([]byte(digest(archive)))
+				return
+			}
+			w.Write([]byte(archive))
+		}))
+	}
+	upstream := release("genuine archive")
+	defer upstream.Close()
+	mirror := release("tampered archive")
+	defer mirror.Close()
+
+	service.SetXrayDigestSource(upstream.URL)
+	defer service.SetXrayDigestSource(service.DefaultXrayMirror)
+
+	fileName, err := service.DownloadXray(&service.ServerService{}, "v25.10.15", mirror.URL, nil)
+	if err == nil {
+		os.Remove(fileName)
+		t.Fatal("expected the tampered archive to be rejected")
+	}
+	if !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	fileName, err = service.DownloadXray(&service.ServerService{}, "v25.10.15", upstream.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(fileName)
+}
@@ -0,0 +1,119 @@
+// Package service provides ServerTimelineService for the per-server event feed.
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// serverEventRetention is how long server timeline events are kept.
+const serverEventRetention = 90 * 24 * time.Hour
+
+// TimelineEvent is one entry of a server's timeline.
+type TimelineEvent struct {
+	Time    int64  `json:"time"`   // Unix seconds
+	Type    string `json:"type"`   // "status", "xray", "config", "cert", "task", "traffic_reset" or "geo_update"
+	Source  string `json:"source"` // Table the event comes from
+	Message string `json:"message"`
+	Status  string `json:"status,omitempty"` // Task status, for task events
+}
+
+// recordServerEvent adds an event to a server's timeline. Failures are only logged so
+// the operation that caused the event is not affected.
+func recordServerEvent(serverId int, kind string, message string) {
+	event := &model.ServerEvent{ServerId: serverId, Type: kind, Message: message}
+	if err := database.GetDB().Create(event).Error; err != nil {
+		logger.Warning("Failed to record server event:", err)
+	}
+}
+
+// ServerTimelineService assembles a chronological feed of what happened to a server from
+// its events, tasks, traffic resets and geo file updates.
+type ServerTimelineService struct{}
+
+// GetTimeline returns the events of a server within a period, newest first, at most limit.
+func (s *ServerTimelineService) GetTimeline(serverId int, from, to time.Time, limit int) ([]*TimelineEvent, error) {
+	db := database.GetDB()
+	start, end := from.Unix(), to.Unix()
+	timeline := make([]*TimelineEvent, 0)
+
+	var events []*model.ServerEvent
+	if err := db.Where("server_id = ? AND created_at BETWEEN ? AND ?", serverId, start, end).
+		Order("created_at DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		timeline = append(timeline, &TimelineEvent{
+			Time:    event.CreatedAt,
+			Type:    event.Type,
+			Source:  "events",
+			Message: event.Message,
+		})
+	}
+
+	var tasks []*model.ServerTask
+	if err := db.Where("server_id = ? AND created_at BETWEEN ? AND ?", serverId, start, end).
+		Order("created_at DESC").Limit(limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		message := task.Operation
+		if task.ErrorMessage != "" {
+			message += ": " + task.ErrorMessage
+		}
+		timeline = append(timeline, &TimelineEvent{
+			Time:    task.CreatedAt,
+			Type:    "task",
+			Source:  "tasks",
+			Message: message,
+			Status:  task.Status,
+		})
+	}
+
+	var resets []*model.TrafficResetEvent
+	if err := db.Where("server_id = ? AND email = '' AND created_at BETWEEN ? AND ?", serverId, start, end).
+		Order("created_at DESC").Limit(limit).Find(&resets).Error; err != nil {
+		return nil, err
+	}
+	for _, reset := range resets {
+		timeline = append(timeline, &TimelineEvent{
+			Time:    reset.CreatedAt,
+			Type:    "traffic_reset",
+			Source:  "traffic_resets",
+			Message: fmt.Sprintf("Inbound %d traffic reset (%s)", reset.InboundId, reset.Reason),
+		})
+	}
+
+	var geoFiles []*model.GeoFileStatus
+	if err := db.Where("server_id = ? AND last_updated_at BETWEEN ? AND ?", serverId, start, end).
+		Find(&geoFiles).Error; err != nil {
+		return nil, err
+	}
+	for _, geoFile := range geoFiles {
+		timeline = append(timeline, &TimelineEvent{
+			Time:    geoFile.LastUpdatedAt,
+			Type:    "geo_update",
+			Source:  "geo_files",
+			Message: geoFile.File + " updated",
+		})
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Time > timeline[j].Time
+	})
+	if len(timeline) > limit {
+		timeline = timeline[:limit]
+	}
+	return timeline, nil
+}
+
+// PruneEvents deletes timeline events past the retention period.
+func (s *ServerTimelineService) PruneEvents() error {
+	cutoff := time.Now().Add(-serverEventRetention).Unix()
+	return database.GetDB().Where("created_at < ?", cutoff).Delete(model.ServerEvent{}).Error
+}
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/cofedish/3x-UI-agents/config"
 	"github.com/cofedish/3x-UI-agents/database"
 	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
@@ -33,6 +36,7 @@ var defaultValueMap = map[string]string{
 	"secret":                      random.Seq(32),
 	"webBasePath":                 "/",
 	"sessionMaxAge":               "360",
+	"trustedProxies":              "127.0.0.1,::1",
 	"pageSize":                    "25",
 	"expireDiff":                  "0",
 	"trafficDiff":                 "0",
@@ -46,6 +50,7 @@ var defaultValueMap = map[string]string{
 	"tgRunTime":                   "@daily",
 	"tgBotBackup":                 "false",
 	"tgBotLoginNotify":            "true",
+	"tgBotIncidentNotify":         "true",
 	"tgCpu":                       "80",
 	"tgLang":                      "en-US",
 	"twoFactorEnable":             "false",
@@ -94,7 +99,94 @@ var defaultValueMap = map[string]string{
 	"ldapDefaultTotalGB":    "0",
 	"ldapDefaultExpiryDays": "0",
 	"ldapDefaultLimitIP":    "0",
-}
+	// Multi-server defaults
+	"ipLimitGlobalEnable": "false",
+	"ipLimitGlobalAction": "alert",
+	// Xray core releases
+	"xrayReleaseChannel": "stable",
+	"xrayMirror":         "",
+	// Agent releases, "" = DefaultAgentReleaseURL
+	"agentReleaseUrl": "",
+	// Scheduled geo file updates on all servers ("" = disabled)
+	"geoUpdateCron":    "",
+	"geoUpdateStagger": "30",
+	// Self-service renewals
+	"renewalWebhookSecret": "",
+	// Traffic anomaly alerts
+	"trafficAnomalyEnable":    "false",
+	"trafficAnomalyThreshold": "4",
+	// Daily cleanup of clients expired or depleted for more than staleClientDays (0 = disabled)
+	"staleClientDays":   "0",
+	"staleClientAction": "report",
+	// Standby export of the panel database for disaster recovery ("" target = disabled)
+	"standbyExportTarget":   "",
+	"standbyExportToken":    "",
+	"standbyExportInterval": "15",
+	"standbyExportKeep":     "48",
+	// Alert notification channels
+	"alertWebhookUrl":   "",
+	"alertSmtpHost":     "",
+	"alertSmtpPort":     "587",
+	"alertSmtpUsername": "",
+	"alertSmtpPassword": "",
+	"alertEmailFrom":    "",
+	"alertEmailTo":      "",
+	// Days before expiry a certificate triggers the cert.expiring webhook event
+	"webhookCertExpiryDays": "14",
+	// Server metric history: days raw samples are kept before they are averaged per hour,
+	// and days hourly averages are kept
+	"metricHistoryRawDays": "7",
+	"metricHistoryDays":    "180",
+	// Seconds between refreshes of the panel's own status, and hours of CPU samples kept
+	// in memory for the real-time CPU chart
+	"statusInterval":  "2",
+	"cpuHistoryHours": "6",
+	// Warning flags in inbound and client listings: percent of the traffic quota used and
+	// days left before expiry that flag a row (negative = disabled), and probing inbound
+	// ports from the panel to flag unreachable ones
+	"warnQuotaPercent": "90",
+	"warnExpiryDays":   "3",
+	"portProbeEnabled": "true",
+	// Bearer token for scraping /panel/metrics without a panel session ("" = session only)
+	"metricsToken": "",
+	// CORS and security headers
+	"corsAllowedOrigins":    "",
+	"hstsMaxAge":            "31536000",
+	"contentSecurityPolicy": "",
+	// Login brute-force protection (negative = disabled)
+	"loginMaxFailuresPerIp":   "5",
+	"loginMaxFailuresPerUser": "10",
+	"loginLockoutMinutes":     "15",
+	"loginCaptchaAfter":       "3",
+	// Agent clock drift in seconds that flags a server (negative = disabled)
+	"clockSkewThreshold": "30",
+	// Days between automatic JWT secret rotations of agents (negative = disabled)
+	"jwtRotationDays": "30",
+	// Read-only mode blocks all changes (XUI_READ_ONLY=true forces it on)
+	"readOnly": "false",
+	// Settings that can be pushed to all servers ("" = Xray template / built-in defaults)
+	"xrayLogLevel":           "",
+	"xrayDnsConfig":          "",
+	"xrayPolicyLevels":       "",
+	"geoSources":             "",
+	"trafficCollectInterval": "10",
+	"publicIpResolver":       "",
+	"fleetSettingsVersion":   "0",
+	// Epoch of the last config change applied on an agent, set by the panel
+	"configEpoch": "0",
+	// Certificate authority agent certificates are issued from, generated on first use.
+	// The key is encrypted like server auth data.
+	"panelCACert": "",
+	"panelCAKey":  "",
+	// Comma-separated hex serials of panel CA certificates revoked by renewals
+	"panelCARevoked": "",
+}
+
+// DefaultContentSecurityPolicy is sent by the panel when contentSecurityPolicy is empty.
+// The Vue templates are compiled in the browser, so scripts need 'unsafe-eval'.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data: blob:; font-src 'self' data:; " +
+	"connect-src 'self'; frame-ancestors 'self'; form-action 'self'; base-uri 'self'"
 
 // SettingService provides business logic for application settings management.
 // It handles configuration storage, retrieval, and validation for all system settings.
@@ -334,6 +426,10 @@ func (s *SettingService) GetTgBotLoginNotify() (bool, error) {
 	return s.getBool("tgBotLoginNotify")
 }
 
+func (s *SettingService) GetTgBotIncidentNotify() (bool, error) {
+	return s.getBool("tgBotIncidentNotify")
+}
+
 func (s *SettingService) GetTgCpu() (int, error) {
 	return s.getInt("tgCpu")
 }
@@ -394,6 +490,15 @@ func (s *SettingService) GetSessionMaxAge() (int, error) {
 	return s.getInt("sessionMaxAge")
 }
 
+// GetTrustedProxies returns the reverse proxies whose client IP headers are trusted.
+func (s *SettingService) GetTrustedProxies() ([]*net.IPNet, error) {
+	value, err := s.getString("trustedProxies")
+	if err != nil {
+		return nil, err
+	}
+	return entity.ParseNetworks(value)
+}
+
 func (s *SettingService) GetRemarkModel() (string, error) {
 	return s.getString("remarkModel")
 }
@@ -519,6 +624,71 @@ func (s *SettingService) GetSubJsonURI() (string, error) {
 	return s.getString("subJsonURI")
 }
 
+// GetSubscriptionURLs returns the subscription URL and the JSON subscription URL of a
+// subscription ID. subURI and subJsonURI are used if set; otherwise the URLs are built
+// from the subscription domain (falling back to the panel domain, then the hostname),
+// port and paths. The JSON URL is empty if JSON subscriptions are disabled.
+func (s *SettingService) GetSubscriptionURLs(subId string) (string, string) {
+	subDomain, _ := s.GetSubDomain()
+	subPort, _ := s.GetSubPort()
+	subPath, _ := s.GetSubPath()
+	subJsonPath, _ := s.GetSubJsonPath()
+	subJsonEnable, _ := s.GetSubJsonEnable()
+	subKeyFile, _ := s.GetSubKeyFile()
+	subCertFile, _ := s.GetSubCertFile()
+
+	tls := (subKeyFile != "" && subCertFile != "")
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+
+	// Fallbacks
+	if subDomain == "" {
+		if d, err := s.GetWebDomain(); err == nil && d != "" {
+			subDomain = d
+		} else if h, err := os.Hostname(); err == nil && h != "" {
+			subDomain = h
+		} else {
+			subDomain = "localhost"
+		}
+	}
+
+	host := subDomain
+	if (subPort == 443 && tls) || (subPort == 80 && !tls) {
+		// standard ports: no port in host
+	} else {
+		host = fmt.Sprintf("%s:%d", subDomain, subPort)
+	}
+
+	// Ensure paths
+	if !strings.HasPrefix(subPath, "/") {
+		subPath = "/" + subPath
+	}
+	if !strings.HasSuffix(subPath, "/") {
+		subPath = subPath + "/"
+	}
+	if !strings.HasPrefix(subJsonPath, "/") {
+		subJsonPath = "/" + subJsonPath
+	}
+	if !strings.HasSuffix(subJsonPath, "/") {
+		subJsonPath = subJsonPath + "/"
+	}
+
+	subURL := fmt.Sprintf("%s://%s%s%s", scheme, host, subPath, subId)
+	subJsonURL := fmt.Sprintf("%s://%s%s%s", scheme, host, subJsonPath, subId)
+	if uri, _ := s.GetSubURI(); uri != "" {
+		subURL = strings.TrimSuffix(uri, "/") + "/" + subId
+	}
+	if uri, _ := s.GetSubJsonURI(); uri != "" {
+		subJsonURL = strings.TrimSuffix(uri, "/") + "/" + subId
+	}
+	if !subJsonEnable {
+		subJsonURL = ""
+	}
+	return subURL, subJsonURL
+}
+
 func (s *SettingService) GetSubJsonFragment() (string, error) {
 	return s.getString("subJsonFragment")
 }
@@ -571,6 +741,284 @@ func (s *SettingService) GetIpLimitEnable() (bool, error) {
 	return (accessLogPath != "none" && accessLogPath != ""), nil
 }
 
+func (s *SettingService) GetIpLimitGlobalEnable() (bool, error) {
+	return s.getBool("ipLimitGlobalEnable")
+}
+
+func (s *SettingService) GetIpLimitGlobalAction() (string, error) {
+	return s.getString("ipLimitGlobalAction")
+}
+
+func (s *SettingService) GetXrayReleaseChannel() (string, error) {
+	return s.getString("xrayReleaseChannel")
+}
+
+func (s *SettingService) GetXrayMirror() (string, error) {
+	return s.getString("xrayMirror")
+}
+
+// GetAgentReleaseURL returns the URL template agents download releases from, with
+// {version} and {arch} placeholders. Empty means DefaultAgentReleaseURL.
+func (s *SettingService) GetAgentReleaseURL() (string, error) {
+	return s.getString("agentReleaseUrl")
+}
+
+func (s *SettingService) GetGeoUpdateCron() (string, error) {
+	return s.getString("geoUpdateCron")
+}
+
+func (s *SettingService) GetGeoUpdateStagger() (int, error) {
+	return s.getInt("geoUpdateStagger")
+}
+
+func (s *SettingService) GetRenewalWebhookSecret() (string, error) {
+	return s.getString("renewalWebhookSecret")
+}
+
+func (s *SettingService) GetTrafficAnomalyEnable() (bool, error) {
+	return s.getBool("trafficAnomalyEnable")
+}
+
+func (s *SettingService) GetTrafficAnomalyThreshold() (int, error) {
+	return s.getInt("trafficAnomalyThreshold")
+}
+
+func (s *SettingService) GetStaleClientDays() (int, error) {
+	return s.getInt("staleClientDays")
+}
+
+func (s *SettingService) GetStaleClientAction() (string, error) {
+	return s.getString("staleClientAction")
+}
+
+func (s *SettingService) GetStandbyExportTarget() (string, error) {
+	return s.getString("standbyExportTarget")
+}
+
+func (s *SettingService) GetStandbyExportToken() (string, error) {
+	return s.getString("standbyExportToken")
+}
+
+func (s *SettingService) GetStandbyExportInterval() (int, error) {
+	return s.getInt("standbyExportInterval")
+}
+
+func (s *SettingService) GetStandbyExportKeep() (int, error) {
+	return s.getInt("standbyExportKeep")
+}
+
+func (s *SettingService) GetAlertWebhookUrl() (string, error) {
+	return s.getString("alertWebhookUrl")
+}
+
+func (s *SettingService) GetAlertSmtpHost() (string, error) {
+	return s.getString("alertSmtpHost")
+}
+
+func (s *SettingService) GetAlertSmtpPort() (int, error) {
+	return s.getInt("alertSmtpPort")
+}
+
+func (s *SettingService) GetWebhookCertExpiryDays() (int, error) {
+	return s.getInt("webhookCertExpiryDays")
+}
+
+func (s *SettingService) GetMetricHistoryRawDays() (int, error) {
+	return s.getInt("metricHistoryRawDays")
+}
+
+func (s *SettingService) GetMetricHistoryDays() (int, error) {
+	return s.getInt("metricHistoryDays")
+}
+
+func (s *SettingService) GetStatusInterval() (int, error) {
+	return s.getInt("statusInterval")
+}
+
+func (s *SettingService) GetCpuHistoryHours() (int, error) {
+	return s.getInt("cpuHistoryHours")
+}
+
+func (s *SettingService) GetWarnQuotaPercent() (int, error) {
+	return s.getInt("warnQuotaPercent")
+}
+
+func (s *SettingService) GetWarnExpiryDays() (int, error) {
+	return s.getInt("warnExpiryDays")
+}
+
+func (s *SettingService) GetPortProbeEnabled() (bool, error) {
+	return s.getBool("portProbeEnabled")
+}
+
+func (s *SettingService) GetAlertSmtpUsername() (string, error) {
+	return s.getString("alertSmtpUsername")
+}
+
+func (s *SettingService) GetAlertSmtpPassword() (string, error) {
+	return s.getString("alertSmtpPassword")
+}
+
+func (s *SettingService) GetAlertEmailFrom() (string, error) {
+	return s.getString("alertEmailFrom")
+}
+
+func (s *SettingService) GetAlertEmailTo() (string, error) {
+	return s.getString("alertEmailTo")
+}
+
+func (s *SettingService) GetMetricsToken() (string, error) {
+	return s.getString("metricsToken")
+}
+
+func (s *SettingService) GetClockSkewThreshold() (int, error) {
+	return s.getInt("clockSkewThreshold")
+}
+
+func (s *SettingService) GetJwtRotationDays() (int, error) {
+	return s.getInt("jwtRotationDays")
+}
+
+func (s *SettingService) GetReadOnly() (bool, error) {
+	return s.getBool("readOnly")
+}
+
+func (s *SettingService) SetReadOnly(value bool) error {
+	return s.setBool("readOnly", value)
+}
+
+// IsReadOnly reports whether the panel is in read-only mode, enabled by the readOnly
+// setting or forced by XUI_READ_ONLY=true.
+func (s *SettingService) IsReadOnly() bool {
+	if config.IsReadOnly() {
+		return true
+	}
+	readOnly, err := s.GetReadOnly()
+	return err == nil && readOnly
+}
+
+// GetCorsAllowedOrigins returns the origins allowed to call the panel cross-origin.
+func (s *SettingService) GetCorsAllowedOrigins() ([]string, error) {
+	value, err := s.getString("corsAllowedOrigins")
+	if err != nil || value == "" {
+		return nil, err
+	}
+	return strings.Split(value, ","), nil
+}
+
+func (s *SettingService) GetHstsMaxAge() (int, error) {
+	return s.getInt("hstsMaxAge")
+}
+
+// GetContentSecurityPolicy returns the panel policy: DefaultContentSecurityPolicy if
+// unset, or "" if it is set to "off".
+func (s *SettingService) GetContentSecurityPolicy() (string, error) {
+	policy, err := s.getString("contentSecurityPolicy")
+	if err != nil {
+		return "", err
+	}
+	switch strings.TrimSpace(policy) {
+	case "":
+		return DefaultContentSecurityPolicy, nil
+	case "off":
+		return "", nil
+	}
+	return policy, nil
+}
+
+func (s *SettingService) GetLoginMaxFailuresPerIp() (int, error) {
+	return s.getInt("loginMaxFailuresPerIp")
+}
+
+func (s *SettingService) GetLoginMaxFailuresPerUser() (int, error) {
+	return s.getInt("loginMaxFailuresPerUser")
+}
+
+func (s *SettingService) GetLoginLockoutMinutes() (int, error) {
+	return s.getInt("loginLockoutMinutes")
+}
+
+func (s *SettingService) GetLoginCaptchaAfter() (int, error) {
+	return s.getInt("loginCaptchaAfter")
+}
+
+func (s *SettingService) GetXrayLogLevel() (string, error) {
+	return s.getString("xrayLogLevel")
+}
+
+func (s *SettingService) GetXrayDnsConfig() (string, error) {
+	return s.getString("xrayDnsConfig")
+}
+
+func (s *SettingService) GetXrayPolicyLevels() (string, error) {
+	return s.getString("xrayPolicyLevels")
+}
+
+// GetGeoSources returns download URLs overriding the built-in geo file sources, by file name.
+func (s *SettingService) GetGeoSources() (map[string]string, error) {
+	value, err := s.getString("geoSources")
+	if err != nil || value == "" {
+		return nil, err
+	}
+	sources := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &sources); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// GetPublicIPResolver returns the URL public addresses are looked up from ("" = built-in
+// resolvers, "off" = network interfaces only).
+func (s *SettingService) GetPublicIPResolver() (string, error) {
+	return s.getString("publicIpResolver")
+}
+
+func (s *SettingService) GetTrafficCollectInterval() (int, error) {
+	return s.getInt("trafficCollectInterval")
+}
+
+func (s *SettingService) GetFleetSettingsVersion() (int, error) {
+	return s.getInt("fleetSettingsVersion")
+}
+
+func (s *SettingService) SetFleetSettingsVersion(version int) error {
+	return s.setInt("fleetSettingsVersion", version)
+}
+
+func (s *SettingService) GetConfigEpoch() (int, error) {
+	return s.getInt("configEpoch")
+}
+
+func (s *SettingService) SetConfigEpoch(epoch int) error {
+	return s.setInt("configEpoch", epoch)
+}
+
+// GetPanelCA returns the PEM certificate and stored key of the panel CA, empty if it was
+// not generated yet.
+func (s *SettingService) GetPanelCA() (string, string, error) {
+	cert, err := s.getString("panelCACert")
+	if err != nil {
+		return "", "", err
+	}
+	key, err := s.getString("panelCAKey")
+	return cert, key, err
+}
+
+func (s *SettingService) SetPanelCA(cert, key string) error {
+	if err := s.setString("panelCAKey", key); err != nil {
+		return err
+	}
+	return s.setString("panelCACert", cert)
+}
+
+func (s *SettingService) GetPanelCARevoked() (string, error) {
+	return s.getString("panelCARevoked")
+}
+
+func (s *SettingService) SetPanelCARevoked(serials string) error {
+	return s.setString("panelCARevoked", serials)
+}
+
 // LDAP exported getters
 func (s *SettingService) GetLdapEnable() (bool, error) {
 	return s.getBool("ldapEnable")
@@ -0,0 +1,106 @@
+// Package service provides ShareLinkService for locating a client's inbounds across servers.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// ClientShare is an inbound of a server that a client can connect to. The inbound carries
+// its resolved address in ServerAddress, so share links and QR codes generated from it
+// point at the owning server instead of the panel host.
+type ClientShare struct {
+	ServerId   int            `json:"serverId"`
+	ServerName string         `json:"serverName"`
+	Inbound    *model.Inbound `json:"inbound"`
+}
+
+// ShareLinkService finds the inbounds a client exists on, per server.
+type ShareLinkService struct {
+	serverMgmt ServerManagementService
+}
+
+// GetClientShares returns every inbound containing a client (or WireGuard peer) with the
+// given email on one server, or on all enabled servers if serverId is 0. Servers that
+// cannot be reached are skipped in the all-servers case.
+func (s *ShareLinkService) GetClientShares(ctx context.Context, email string, serverId int) ([]*ClientShare, error) {
+	var servers []*model.Server
+	if serverId == 0 {
+		enabled, err := s.serverMgmt.GetEnabledServers()
+		if err != nil {
+			return nil, err
+		}
+		servers = enabled
+	} else {
+		server, err := s.serverMgmt.GetServer(serverId)
+		if err != nil {
+			return nil, err
+		}
+		servers = []*model.Server{server}
+	}
+
+	shares := make([]*ClientShare, 0)
+	for _, server := range servers {
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			if serverId != 0 {
+				return nil, err
+			}
+			continue
+		}
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		inbounds, err := connector.ListInbounds(listCtx)
+		cancel()
+		if err != nil {
+			if serverId != 0 {
+				return nil, err
+			}
+			logger.Warning("Failed to list inbounds of server", server.Id, "for share links:", err)
+			continue
+		}
+
+		for _, inbound := range inbounds {
+			if !inboundHasClient(inbound, email) {
+				continue
+			}
+			s.serverMgmt.ResolveServerAddresses(server.Id, inbound)
+			shares = append(shares, &ClientShare{
+				ServerId:   server.Id,
+				ServerName: server.Name,
+				Inbound:    inbound,
+			})
+		}
+	}
+	return shares, nil
+}
+
+// inboundHasClient reports whether an inbound has a client or peer with the email.
+func inboundHasClient(inbound *model.Inbound, email string) bool {
+	var settings struct {
+		Clients []struct {
+			Email string `json:"email"`
+		} `json:"clients"`
+		Peers []struct {
+			Email string `json:"email"`
+		} `json:"peers"`
+	}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return false
+	}
+	for _, client := range settings.Clients {
+		if strings.EqualFold(client.Email, email) {
+			return true
+		}
+	}
+	for _, peer := range settings.Peers {
+		if strings.EqualFold(peer.Email, email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+// Package service provides StaleClientService for cleaning up long expired or depleted clients.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/common"
+)
+
+const (
+	// staleClientConcurrency is the number of servers scanned or cleaned up at once.
+	staleClientConcurrency = 5
+	// staleClientTimeout bounds scanning or cleaning up one server.
+	staleClientTimeout = 2 * time.Minute
+)
+
+// StaleClient is a client that has been expired or out of traffic for longer than the
+// cleanup threshold.
+type StaleClient struct {
+	ServerId   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
+	InboundId  int    `json:"inboundId"`
+	Email      string `json:"email"`
+	SubId      string `json:"subId,omitempty"`
+	Reason     string `json:"reason"` // "expired" or "depleted"
+	Since      int64  `json:"since"`  // Unix milliseconds of the expiry or the last traffic
+	Enabled    bool   `json:"enabled"`
+
+	clientId string // Protocol client ID that client deletion is keyed by
+}
+
+// StaleClientReport is the outcome of a stale client scan or cleanup.
+type StaleClientReport struct {
+	Days    int            `json:"days"`
+	Action  string         `json:"action"` // "report", "disable" or "delete"
+	Clients []*StaleClient `json:"clients"`
+	Cleaned int            `json:"cleaned"`          // Clients disabled or deleted
+	Errors  []string       `json:"errors,omitempty"` // Servers that could not be scanned or cleaned up
+}
+
+// StaleClientService finds clients that expired or used up their traffic more than a
+// number of days ago on every enabled server, and disables or deletes them so inbound
+// settings do not grow without bound.
+type StaleClientService struct {
+	serverMgmt     ServerManagementService
+	inboundService InboundService
+	xrayService    XrayService
+}
+
+// Cleanup finds the clients stale for more than days and applies action to them:
+// "report" only lists them, "disable" disables the enabled ones and "delete" removes
+// them from their inbounds.
+func (s *StaleClientService) Cleanup(ctx context.Context, days int, action string) (*StaleClientReport, error) {
+	if days <= 0 {
+		return nil, fmt.Errorf("days must be positive")
+	}
+	switch action {
+	case "report", "disable", "delete":
+	default:
+		return nil, fmt.Errorf("action must be report, disable or delete: %s", action)
+	}
+
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StaleClientReport{Days: days, Action: action, Clients: make([]*StaleClient, 0)}
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, staleClientConcurrency)
+	for _, server := range servers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(server *model.Server) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			serverCtx, cancel := context.WithTimeout(ctx, staleClientTimeout)
+			defer cancel()
+
+			stale, cleaned, err := s.cleanupServer(serverCtx, server, cutoff, action)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Clients = append(report.Clients, stale...)
+			report.Cleaned += cleaned
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("server %s: %v", server.Name, err))
+				logger.Warningf("Stale client cleanup on server %s failed: %v", server.Name, err)
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Clients, func(i, j int) bool {
+		if report.Clients[i].ServerId != report.Clients[j].ServerId {
+			return report.Clients[i].ServerId < report.Clients[j].ServerId
+		}
+		return report.Clients[i].Email < report.Clients[j].Email
+	})
+	return report, nil
+}
+
+// cleanupServer finds the stale clients of one server and applies action to them. It
+// returns the stale clients and how many of them were changed.
+func (s *StaleClientService) cleanupServer(ctx context.Context, server *model.Server, cutoff int64, action string) ([]*StaleClient, int, error) {
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return nil, 0, err
+	}
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stale := make([]*StaleClient, 0)
+	for _, inbound := range inbounds {
+		stale = append(stale, s.findStale(server, inbound, cutoff)...)
+	}
+	if action == "report" || len(stale) == 0 {
+		return stale, 0, nil
+	}
+
+	byInbound := make(map[int][]*StaleClient)
+	for _, client := range stale {
+		if action == "disable" && !client.Enabled {
+			continue
+		}
+		byInbound[client.InboundId] = append(byInbound[client.InboundId], client)
+	}
+
+	cleaned := 0
+	errs := make([]error, 0)
+	for inboundId, clients := range byInbound {
+		var err error
+		if action == "delete" {
+			for _, client := range clients {
+				if err = connector.DeleteClient(ctx, inboundId, client.clientId); err != nil {
+					break
+				}
+				cleaned++
+			}
+		} else {
+			if err = s.disableClients(ctx, connector, inboundId, clients); err == nil {
+				cleaned += len(clients)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inbound %d: %w", inboundId, err))
+		}
+	}
+
+	if cleaned > 0 {
+		// Local changes are applied by the restart job, remote ones right away like the
+		// inbound controller does
+		if server.IsLocal() {
+			s.xrayService.SetToNeedRestart()
+		} else if action == "disable" {
+			if err := connector.RestartXray(ctx); err != nil {
+				logger.Warning("Failed to restart Xray on remote server after disabling stale clients:", err)
+			}
+		}
+		logger.Infof("Stale client cleanup on server %s: %d clients (%s)", server.Name, cleaned, action)
+	}
+	return stale, cleaned, common.Combine(errs...)
+}
+
+// findStale returns the clients of an inbound that expired, or used up their traffic
+// and were last online, before cutoff (Unix milliseconds).
+func (s *StaleClientService) findStale(server *model.Server, inbound *model.Inbound, cutoff int64) []*StaleClient {
+	clients, err := s.inboundService.GetClients(inbound)
+	if err != nil {
+		return nil
+	}
+	stats := make(map[string]int, len(inbound.ClientStats))
+	for i, stat := range inbound.ClientStats {
+		stats[stat.Email] = i
+	}
+
+	stale := make([]*StaleClient, 0)
+	for _, client := range clients {
+		if client.Email == "" {
+			continue
+		}
+		reason, since := "", int64(0)
+		switch {
+		case client.ExpiryTime > 0 && client.ExpiryTime < cutoff:
+			reason, since = "expired", client.ExpiryTime
+		case client.TotalGB > 0:
+			i, ok := stats[client.Email]
+			if !ok {
+				continue
+			}
+			stat := inbound.ClientStats[i]
+			if stat.Up+stat.Down >= client.TotalGB && stat.LastOnline > 0 && stat.LastOnline < cutoff {
+				reason, since = "depleted", stat.LastOnline
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		stale = append(stale, &StaleClient{
+			ServerId:   server.Id,
+			ServerName: server.Name,
+			InboundId:  inbound.Id,
+			Email:      client.Email,
+			SubId:      client.SubID,
+			Reason:     reason,
+			Since:      since,
+			Enabled:    client.Enable,
+			clientId:   s.inboundService.GetClientId(inbound.Protocol, client),
+		})
+	}
+	return stale
+}
+
+// disableClients disables clients of one inbound in a single update.
+func (s *StaleClientService) disableClients(ctx context.Context, connector ServerConnector, inboundId int, clients []*StaleClient) error {
+	inbound, err := connector.GetInbound(ctx, inboundId)
+	if err != nil {
+		return err
+	}
+
+	emails := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		emails[client.Email] = true
+	}
+
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return err
+	}
+	items, _ := settings["clients"].([]any)
+	for _, item := range items {
+		c, ok := item.(map[string]any)
+		if email, _ := c["email"].(string); ok && emails[email] {
+			c["enable"] = false
+			c["updated_at"] = time.Now().Unix() * 1000
+		}
+	}
+	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = string(modifiedSettings)
+	return connector.UpdateInbound(ctx, inbound)
+}
+
+// FormatStaleClientSummary returns a short per-server summary of a report for notifications.
+func FormatStaleClientSummary(report *StaleClientReport) string {
+	counts := make(map[string]int)
+	names := make([]string, 0)
+	for _, client := range report.Clients {
+		if counts[client.ServerName] == 0 {
+			names = append(names, client.ServerName)
+		}
+		counts[client.ServerName]++
+	}
+	summary := ""
+	for _, name := range names {
+		if summary != "" {
+			summary += ", "
+		}
+		summary += name + ": " + strconv.Itoa(counts[name])
+	}
+	return summary
+}
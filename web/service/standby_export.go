@@ -0,0 +1,445 @@
+// Package service provides StandbyExportService for exporting panel snapshots for disaster recovery.
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+const (
+	// standbySnapshotPrefix and standbySnapshotSuffix frame the names of exported snapshots,
+	// e.g. x-ui-standby-20260101-120000.db.gz.enc.
+	standbySnapshotPrefix = "x-ui-standby-"
+	standbySnapshotSuffix = ".db.gz.enc"
+
+	// standbySnapshotMagic starts encrypted snapshots, followed by the nonce and the
+	// AES-GCM sealed gzipped database.
+	standbySnapshotMagic = "XUISTBY1"
+	// standbyKeyPurpose separates the snapshot key from the auth data key, both derived
+	// from XUI_AUTHDATA_KEY.
+	standbyKeyPurpose = "x-ui standby snapshot:"
+
+	// standbyUploadTimeout bounds uploading or downloading one snapshot.
+	standbyUploadTimeout = 5 * time.Minute
+	// standbyMaxSnapshotSize bounds a downloaded or decompressed snapshot, so a wrong URL
+	// or a gzip bomb cannot exhaust memory while restoring.
+	standbyMaxSnapshotSize = 1 << 30
+)
+
+// StandbyExportStatus is the outcome of the last standby export.
+type StandbyExportStatus struct {
+	Target       string `json:"target"`
+	LastRunAt    int64  `json:"lastRunAt"`    // Unix timestamp of the last attempt
+	LastExportAt int64  `json:"lastExportAt"` // Unix timestamp of the last snapshot written
+	LastFile     string `json:"lastFile"`
+	LastSize     int64  `json:"lastSize"` // Compressed bytes
+	LastHash     string `json:"lastHash"` // Fingerprint of the configuration in the snapshot
+	Unchanged    bool   `json:"unchanged"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+var (
+	// standbyMu also serializes exports, so the job and the API never write at once.
+	standbyMu     sync.Mutex
+	standbyStatus StandbyExportStatus
+)
+
+// standbyVolatileTables and standbyVolatileColumns hold traffic counters, monitoring
+// data and health check results. They change all the time without the configuration
+// changing, so they are left out of the fingerprint that decides whether to export.
+var (
+	standbyVolatileTables = map[string]bool{
+		"outbound_traffics": true, "inbound_client_ips": true, "client_traffic_samples": true,
+		"traffic_reset_events": true, "server_metric_samples": true, "traffic_histories": true,
+		"server_events": true, "incidents": true, "health_check_runs": true, "alerts": true,
+		"login_attempts": true, "server_tasks": true, "geo_file_statuses": true,
+		"server_settings_syncs": true,
+	}
+	standbyVolatileColumns = map[string]bool{
+		"up": true, "down": true, "all_time": true, "last_online": true, "status": true,
+		"last_seen": true, "last_error": true, "clock_skew": true, "warning": true,
+		"agent_epoch": true, "config_out_of_sync": true, "updated_at": true,
+	}
+)
+
+// StandbyExportService periodically exports a full, encrypted snapshot of the panel
+// database, which holds servers, inbounds, clients and settings, to a directory or an
+// HTTPS location, so the panel itself is not a single point of data loss. A new full
+// snapshot is written whenever the configuration changed since the last one, while
+// traffic counters and monitoring data alone do not trigger one. Snapshots are
+// deliberately not deltas against the last one: each must restore on its own, as
+// retention, locally or on the remote side, may remove any earlier snapshot.
+type StandbyExportService struct {
+	settingService SettingService
+	webhookService WebhookService
+}
+
+// GetStatus returns the outcome of the last export.
+func (s *StandbyExportService) GetStatus() *StandbyExportStatus {
+	standbyMu.Lock()
+	defer standbyMu.Unlock()
+	status := standbyStatus
+	status.Target, _ = s.settingService.GetStandbyExportTarget()
+	return &status
+}
+
+// ExportIfDue exports a snapshot if a target is configured and standbyExportInterval
+// minutes passed since the last attempt.
+func (s *StandbyExportService) ExportIfDue(ctx context.Context) {
+	target, err := s.settingService.GetStandbyExportTarget()
+	if err != nil || target == "" {
+		return
+	}
+	interval, err := s.settingService.GetStandbyExportInterval()
+	if err != nil || interval < 1 {
+		interval = 15
+	}
+	standbyMu.Lock()
+	lastRun := standbyStatus.LastRunAt
+	standbyMu.Unlock()
+	if time.Since(time.Unix(lastRun, 0)) < time.Duration(interval)*time.Minute {
+		return
+	}
+	if _, err := s.Export(ctx, false); err != nil {
+		logger.Warning("Standby export failed:", err)
+	}
+}
+
+// Export writes a snapshot of the panel database to the configured target. Unless force
+// is set, nothing is written if the data did not change since the last snapshot.
+func (s *StandbyExportService) Export(ctx context.Context, force bool) (*StandbyExportStatus, error) {
+	target, err := s.settingService.GetStandbyExportTarget()
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		return nil, fmt.Errorf("no standby export target configured")
+	}
+
+	standbyMu.Lock()
+	defer standbyMu.Unlock()
+	standbyStatus.Target = target
+	standbyStatus.LastRunAt = time.Now().Unix()
+
+	name, size, hash, err := s.export(ctx, target, force)
+	if err != nil {
+		standbyStatus.LastError = err.Error()
+		status := standbyStatus
+		return &status, err
+	}
+	standbyStatus.LastError = ""
+	standbyStatus.Unchanged = name == ""
+	if name != "" {
+		standbyStatus.LastExportAt = standbyStatus.LastRunAt
+		standbyStatus.LastFile = name
+		standbyStatus.LastSize = size
+		standbyStatus.LastHash = hash
+		logger.Infof("Standby snapshot %s exported (%d bytes)", name, size)
+		s.webhookService.Emit(WebhookBackupCompleted, map[string]any{
+			"target": target,
+			"file":   name,
+			"size":   size,
+			"sha256": hash,
+		})
+	}
+	status := standbyStatus
+	return &status, nil
+}
+
+// export snapshots the database and writes it to target. It returns an empty name if the
+// snapshot equals the last one and force is not set. standbyMu must be held.
+func (s *StandbyExportService) export(ctx context.Context, target string, force bool) (string, int64, string, error) {
+	dir, err := os.MkdirTemp("", "x-ui-standby")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	// Snapshots hold admin credentials and the agents' auth data, so they are only
+	// written encrypted and only sent over TLS
+	if strings.HasPrefix(target, "http://") {
+		return "", 0, "", errors.New("standby export target must use https")
+	}
+	aead, err := authDataKeyAEAD(ctx, standbyKeyPurpose)
+	if err != nil {
+		return "", 0, "", err
+	}
+	if aead == nil {
+		return "", 0, "", errors.New("standby export needs XUI_AUTHDATA_KEY to encrypt snapshots")
+	}
+
+	snapshotPath := filepath.Join(dir, "x-ui.db")
+	if err := database.Snapshot(snapshotPath); err != nil {
+		return "", 0, "", fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	hash, err := database.Fingerprint(snapshotPath, standbyVolatileTables, standbyVolatileColumns)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to fingerprint snapshot: %w", err)
+	}
+	if !force && hash == standbyStatus.LastHash {
+		return "", 0, hash, nil
+	}
+	data, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return "", 0, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", 0, "", err
+	}
+	sealed := append([]byte(standbySnapshotMagic), nonce...)
+	sealed = aead.Seal(sealed, nonce, compressed.Bytes(), nil)
+
+	name := standbySnapshotPrefix + time.Now().UTC().Format("20060102-150405") + standbySnapshotSuffix
+	if isHTTPTarget(target) {
+		err = s.upload(ctx, target, name, sealed)
+	} else {
+		err = s.writeFile(target, name, sealed)
+	}
+	if err != nil {
+		return "", 0, "", err
+	}
+	return name, int64(len(sealed)), hash, nil
+}
+
+// upload PUTs a snapshot to target/name with the configured bearer token.
+func (s *StandbyExportService) upload(ctx context.Context, target, name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, standbyUploadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(target, "/")+"/"+name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token, _ := s.settingService.GetStandbyExportToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upload of snapshot returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeFile writes a snapshot into the target directory and keeps only the newest
+// standbyExportKeep snapshots there.
+func (s *StandbyExportService) writeFile(target, name string, data []byte) error {
+	if err := os.MkdirAll(target, 0o700); err != nil {
+		return err
+	}
+	// Written under a temporary name first, so a crash never leaves a truncated snapshot
+	tmpPath := filepath.Join(target, "."+name+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(target, name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	keep, err := s.settingService.GetStandbyExportKeep()
+	if err != nil || keep < 1 {
+		keep = 48
+	}
+	snapshots, err := listStandbySnapshots(target)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(snapshots)-keep; i++ {
+		if err := os.Remove(filepath.Join(target, snapshots[i])); err != nil {
+			logger.Warning("Failed to remove old standby snapshot:", err)
+		}
+	}
+	return nil
+}
+
+// listStandbySnapshots returns the snapshot names in dir, oldest first.
+func listStandbySnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		// Unencrypted .db.gz snapshots of older versions can still be restored
+		if !entry.IsDir() && strings.HasPrefix(name, standbySnapshotPrefix) &&
+			(strings.HasSuffix(name, standbySnapshotSuffix) || strings.HasSuffix(name, ".db.gz")) {
+			names = append(names, name)
+		}
+	}
+	// Names embed a sortable UTC timestamp
+	sort.Strings(names)
+	return names, nil
+}
+
+// isHTTPTarget reports whether target is an HTTP(S) URL rather than a directory.
+func isHTTPTarget(target string) bool {
+	return strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://")
+}
+
+// RestoreStandbySnapshot replaces the panel database with a standby snapshot. source is a
+// snapshot file, a directory holding snapshots (the newest is used) or an HTTPS URL of
+// a snapshot, fetched with token as bearer token if set. Encrypted snapshots need the
+// XUI_AUTHDATA_KEY of the panel that exported them. The current database is kept as
+// a .pre-restore backup. It returns the snapshot restored and the path of the backup.
+// The panel must be stopped while restoring.
+func RestoreStandbySnapshot(source, token string) (string, string, error) {
+	data, source, err := readStandbySnapshot(source, token)
+	if err != nil {
+		return "", "", err
+	}
+	if sealed, ok := bytes.CutPrefix(data, []byte(standbySnapshotMagic)); ok {
+		if data, err = openStandbySnapshot(sealed); err != nil {
+			return "", "", err
+		}
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", "", err
+		}
+		if data, err = readStandbyLimited(zr); err != nil {
+			return "", "", fmt.Errorf("failed to decompress snapshot: %w", err)
+		}
+	}
+
+	dbPath := config.GetDBPath()
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+		return "", "", err
+	}
+	tmpPath := dbPath + ".restore"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := database.ValidateSQLiteDB(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", "", fmt.Errorf("snapshot is not a valid database: %w", err)
+	}
+
+	// The WAL files belong to the old database and must not be replayed into the new one
+	backupPath := dbPath + ".pre-restore"
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + suffix); err == nil {
+			if err := os.Rename(dbPath+suffix, backupPath+suffix); err != nil {
+				os.Remove(tmpPath)
+				return "", "", fmt.Errorf("failed to back up current database: %w", err)
+			}
+		}
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return "", "", err
+	}
+	return source, backupPath, nil
+}
+
+// openStandbySnapshot decrypts an encrypted snapshot without its magic prefix.
+func openStandbySnapshot(sealed []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	aead, err := authDataKeyAEAD(ctx, standbyKeyPurpose)
+	if err != nil {
+		return nil, err
+	}
+	if aead == nil {
+		return nil, errors.New("snapshot is encrypted but XUI_AUTHDATA_KEY is not set")
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("snapshot is corrupted")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt snapshot: wrong XUI_AUTHDATA_KEY?")
+	}
+	return data, nil
+}
+
+// readStandbySnapshot returns the contents and the location of the snapshot at source.
+func readStandbySnapshot(source, token string) ([]byte, string, error) {
+	if strings.HasPrefix(source, "http://") {
+		return nil, "", errors.New("snapshots can only be downloaded over https")
+	}
+	if isHTTPTarget(source) {
+		ctx, cancel := context.WithTimeout(context.Background(), standbyUploadTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to download snapshot: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("download of snapshot returned status %d", resp.StatusCode)
+		}
+		data, err := readStandbyLimited(resp.Body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to download snapshot: %w", err)
+		}
+		return data, source, nil
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		snapshots, err := listStandbySnapshots(source)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(snapshots) == 0 {
+			return nil, "", fmt.Errorf("no standby snapshots in %s", source)
+		}
+		source = filepath.Join(source, snapshots[len(snapshots)-1])
+	}
+	data, err := os.ReadFile(source)
+	return data, source, err
+}
+
+// readStandbyLimited reads r to the end, failing if it holds more than
+// standbyMaxSnapshotSize bytes.
+func readStandbyLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, standbyMaxSnapshotSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > standbyMaxSnapshotSize {
+		return nil, fmt.Errorf("snapshot is larger than %d MB", standbyMaxSnapshotSize>>20)
+	}
+	return data, nil
+}
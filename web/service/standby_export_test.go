@@ -0,0 +1,80 @@
+package service_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestStandbyExport checks that snapshots are encrypted, refused without a key or over
+// plain http, and only written again when the configuration changed, not the traffic.
+func TestStandbyExport(t *testing.T) {
+	ctx := context.Background()
+	standby := &service.StandbyExportService{}
+	dir := t.TempDir()
+	defer service.SetSetting("standbyExportTarget", "")
+	defer service.SetSetting("standbyExportKeep", "48")
+
+	inbound := &model.Inbound{Protocol: model.VLESS, Port: 20301, Tag: "standby-test", Settings: `{"clients":[]}`}
+	if err := database.GetDB().Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Inbound{}, inbound.Id)
+
+	service.SetSetting("standbyExportTarget", "http://backup.example.com/x-ui")
+	t.Setenv("XUI_AUTHDATA_KEY", "standby-test-key")
+	if _, err := standby.Export(ctx, true); err == nil {
+		t.Fatal("expected a plain http target to be refused")
+	}
+
+	service.SetSetting("standbyExportTarget", dir)
+	t.Setenv("XUI_AUTHDATA_KEY", "")
+	if _, err := standby.Export(ctx, true); err == nil {
+		t.Fatal("expected the export to need XUI_AUTHDATA_KEY")
+	}
+
+	t.Setenv("XUI_AUTHDATA_KEY", "standby-test-key")
+	status, err := standby.Export(ctx, false)
+	if err != nil || status.Unchanged {
+		t.Fatalf("first export: %+v, %v", status, err)
+	}
+
+	database.GetDB().Model(inbound).Updates(map[string]any{"up": 1000, "down": 2000})
+	if status, err = standby.Export(ctx, false); err != nil || !status.Unchanged {
+		t.Fatalf("export after traffic only: %+v, %v", status, err)
+	}
+
+	service.SetSetting("standbyExportKeep", "47")
+	if status, err = standby.Export(ctx, false); err != nil || status.Unchanged {
+		t.Fatalf("export after a setting change: %+v, %v", status, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, status.LastFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, ok := bytes.CutPrefix(data, []byte("XUISTBY1"))
+	if !ok {
+		t.Fatal("snapshot is not encrypted")
+	}
+	compressed, err := service.OpenStandbySnapshot(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := io.ReadAll(zr)
+	if err != nil || !bytes.HasPrefix(db, []byte("SQLite format 3")) {
+		t.Fatalf("snapshot is not a SQLite database: %v", err)
+	}
+}
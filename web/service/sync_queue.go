@@ -0,0 +1,277 @@
+// Package service provides SyncQueueService for replaying mutations on servers that were unreachable.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/redact"
+
+	"gorm.io/gorm"
+)
+
+// ServerTask operations of queued inbound and client mutations.
+const (
+	SyncAddInbound    = "add_inbound"
+	SyncUpdateInbound = "update_inbound"
+	SyncDeleteInbound = "delete_inbound"
+	SyncAddClient     = "add_client"
+	SyncUpdateClient  = "update_client"
+	SyncDeleteClient  = "delete_client"
+)
+
+// syncOperations lists the operations replayed by SyncQueueService.
+var syncOperations = []string{
+	SyncAddInbound, SyncUpdateInbound, SyncDeleteInbound,
+	SyncAddClient, SyncUpdateClient, SyncDeleteClient,
+}
+
+// syncTaskTimeout bounds replaying a single queued mutation.
+const syncTaskTimeout = 30 * time.Second
+
+// syncRequest is stored as the RequestData of a queued mutation.
+type syncRequest struct {
+	InboundId int            `json:"inboundId,omitempty"`
+	ClientId  string         `json:"clientId,omitempty"`
+	Inbound   *model.Inbound `json:"inbound,omitempty"`
+}
+
+// syncReplaying holds the servers whose queue is being replayed, so replays of the same
+// server never overlap.
+var syncReplaying sync.Map
+
+// SyncQueueService persists inbound and client mutations that could not reach an agent
+// as ServerTasks and replays them in order once the server is reachable again.
+type SyncQueueService struct {
+	serverMgmt     ServerManagementService
+	settingService SettingService
+}
+
+// Enqueue queues a mutation for a server. inbound is the inbound sent to the agent, if any.
+func (s *SyncQueueService) Enqueue(serverId int, operation string, inboundId int, clientId string, inbound *model.Inbound, userId int) (*model.ServerTask, error) {
+	requestData, err := json.Marshal(syncRequest{InboundId: inboundId, ClientId: clientId, Inbound: inbound})
+	if err != nil {
+		return nil, err
+	}
+	task := &model.ServerTask{
+		ServerId:    serverId,
+		Operation:   operation,
+		Status:      "pending",
+		RequestData: string(redact.JSON(requestData)),
+		Payload:     string(requestData),
+		UserId:      userId,
+	}
+	if err := database.GetDB().Omit("Server").Create(task).Error; err != nil {
+		return nil, err
+	}
+	publishTaskEvent(task, task.Status, "")
+	logger.Infof("Queued %s on server %d until it is reachable", operation, serverId)
+	return task, nil
+}
+
+// HasPending reports whether a server has queued mutations. New mutations of such a server
+// must be queued behind them to keep their order.
+func (s *SyncQueueService) HasPending(serverId int) bool {
+	var count int64
+	err := s.pendingQuery(serverId).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// GetPending returns the queued mutations of a server in the order they will be replayed.
+func (s *SyncQueueService) GetPending(serverId int) ([]*model.ServerTask, error) {
+	var tasks []*model.ServerTask
+	err := s.pendingQuery(serverId).Order("id").Find(&tasks).Error
+	return tasks, err
+}
+
+// MarkPending flags the inbounds of a server with queued mutations as pending sync and
+// returns them together with the inbounds queued for creation, which have no ID yet.
+func (s *SyncQueueService) MarkPending(serverId int, inbounds []*model.Inbound) []*model.Inbound {
+	tasks, err := s.GetPending(serverId)
+	if err != nil || len(tasks) == 0 {
+		return inbounds
+	}
+
+	pending := make(map[int]bool, len(tasks))
+	for _, task := range tasks {
+		var request syncRequest
+		if err := json.Unmarshal([]byte(taskPayload(task)), &request); err != nil {
+			continue
+		}
+		if task.Operation == SyncAddInbound && request.Inbound != nil {
+			request.Inbound.ServerId = serverId
+			request.Inbound.PendingSync = true
+			inbounds = append(inbounds, request.Inbound)
+			continue
+		}
+		pending[request.InboundId] = true
+	}
+	for _, inbound := range inbounds {
+		if pending[inbound.Id] {
+			inbound.PendingSync = true
+		}
+	}
+	return inbounds
+}
+
+// Replay runs the queued mutations of a server in order. It stops at the first mutation
+// that cannot reach the agent, leaving it and the rest queued; mutations the agent rejects
+// are marked failed. Xray is restarted once if any inbound changed. In read-only mode the
+// queue is kept until the mode is turned off.
+func (s *SyncQueueService) Replay(serverId int) error {
+	if s.settingService.IsReadOnly() {
+		return nil
+	}
+	if _, running := syncReplaying.LoadOrStore(serverId, true); running {
+		return nil
+	}
+	defer syncReplaying.Delete(serverId)
+
+	tasks, err := s.GetPending(serverId)
+	if err != nil || len(tasks) == 0 {
+		return err
+	}
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	restart := false
+	replayed, failed := 0, 0
+	for _, task := range tasks {
+		task.Status = "running"
+		task.StartedAt = time.Now().Unix()
+		db.Model(task).Updates(map[string]any{"status": task.Status, "started_at": task.StartedAt})
+		publishTaskEvent(task, task.Status, "")
+
+		err := s.runTask(connector, task)
+		if errors.Is(err, ErrAgentUnreachable) {
+			task.RetryCount++
+			db.Model(task).Updates(map[string]any{
+				"status":        "pending",
+				"retry_count":   task.RetryCount,
+				"error_message": err.Error(),
+			})
+			publishTaskEvent(task, "pending", err.Error())
+			logger.Infof("Server %d is unreachable again; %d queued mutations left", serverId, len(tasks)-replayed-failed)
+			break
+		}
+
+		updates := map[string]any{"status": "completed", "completed_at": time.Now().Unix(), "error_message": ""}
+		if err != nil {
+			updates["status"] = "failed"
+			updates["error_message"] = err.Error()
+			failed++
+			logger.Warningf("Queued %s on server %d failed: %v", task.Operation, serverId, err)
+		} else {
+			replayed++
+			restart = restart || task.Operation == SyncAddInbound || task.Operation == SyncUpdateInbound || task.Operation == SyncDeleteInbound
+		}
+		db.Model(task).Updates(updates)
+		publishTaskEvent(task, updates["status"].(string), updates["error_message"].(string))
+	}
+
+	if restart {
+		ctx, cancel := context.WithTimeout(context.Background(), syncTaskTimeout)
+		defer cancel()
+		if err := connector.RestartXray(ctx); err != nil {
+			logger.Warning("Failed to restart Xray on server", serverId, "after replaying queued mutations:", err)
+		}
+	}
+	if replayed > 0 || failed > 0 {
+		logger.Infof("Replayed queued mutations on server %d: %d succeeded, %d failed", serverId, replayed, failed)
+		recordServerEvent(serverId, "config", fmt.Sprintf("Replayed %d queued mutations (%d failed)", replayed, failed))
+	}
+	return nil
+}
+
+// runTask sends one queued mutation to the agent.
+func (s *SyncQueueService) runTask(connector ServerConnector, task *model.ServerTask) error {
+	var request syncRequest
+	if err := json.Unmarshal([]byte(taskPayload(task)), &request); err != nil {
+		return fmt.Errorf("invalid queued request: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), syncTaskTimeout)
+	defer cancel()
+
+	switch task.Operation {
+	case SyncAddInbound:
+		if request.Inbound == nil {
+			return fmt.Errorf("queued request has no inbound")
+		}
+		return connector.AddInbound(ctx, request.Inbound)
+	case SyncUpdateInbound:
+		if request.Inbound == nil {
+			return fmt.Errorf("queued request has no inbound")
+		}
+		return connector.UpdateInbound(ctx, request.Inbound)
+	case SyncDeleteInbound:
+		if err := connector.DeleteInbound(ctx, request.InboundId); err != nil {
+			return err
+		}
+		resellers := ResellerService{}
+		return resellers.ReleaseInbound(task.ServerId, request.InboundId)
+	case SyncAddClient:
+		if request.Inbound == nil {
+			return fmt.Errorf("queued request has no inbound")
+		}
+		return connector.AddClient(ctx, request.Inbound)
+	case SyncUpdateClient:
+		if request.Inbound == nil {
+			return fmt.Errorf("queued request has no inbound")
+		}
+		// The connector addresses clients by index, which is only known once the agent answers
+		index, err := s.findClientIndex(ctx, connector, request.InboundId, request.ClientId)
+		if err != nil {
+			return err
+		}
+		return connector.UpdateClient(ctx, request.Inbound, index)
+	case SyncDeleteClient:
+		return connector.DeleteClient(ctx, request.InboundId, request.ClientId)
+	default:
+		return fmt.Errorf("unknown queued operation %q", task.Operation)
+	}
+}
+
+// findClientIndex returns the position of a client in an inbound on the agent.
+func (s *SyncQueueService) findClientIndex(ctx context.Context, connector ServerConnector, inboundId int, clientId string) (int, error) {
+	inbound, err := connector.GetInbound(ctx, inboundId)
+	if err != nil {
+		return -1, err
+	}
+	inboundService := InboundService{}
+	clients, err := inboundService.GetClients(inbound)
+	if err != nil {
+		return -1, err
+	}
+	for i, client := range clients {
+		if inboundService.GetClientId(inbound.Protocol, client) == clientId {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("client %s not found", clientId)
+}
+
+// taskPayload returns the unmasked request of a queued mutation. Tasks queued before
+// RequestData was masked only have RequestData.
+func taskPayload(task *model.ServerTask) string {
+	if task.Payload != "" {
+		return task.Payload
+	}
+	return task.RequestData
+}
+
+// pendingQuery selects the queued mutations of a server. Running tasks are included so
+// replays interrupted by a panel restart are picked up again.
+func (s *SyncQueueService) pendingQuery(serverId int) *gorm.DB {
+	return database.GetDB().Model(model.ServerTask{}).
+		Where("server_id = ? AND operation IN ? AND status IN ?", serverId, syncOperations, []string{"pending", "running"})
+}
@@ -0,0 +1,110 @@
+// Package service provides in-memory progress tracking of long-running operations.
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// progressRetention is how long finished operations can still be polled.
+const progressRetention = time.Hour
+
+// ProgressFunc reports the progress of an operation in percent and its current stage.
+type ProgressFunc func(percent int, stage string)
+
+// TaskProgress is the state of a long-running operation started in the background,
+// e.g. an Xray install on an agent, polled by the panel.
+type TaskProgress struct {
+	Id        string `json:"id"`
+	Operation string `json:"operation"`
+	Status    string `json:"status"` // "running", "completed" or "failed"
+	Percent   int    `json:"percent"`
+	Stage     string `json:"stage"`
+	Error     string `json:"error,omitempty"`
+	StartedAt int64  `json:"startedAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// Done reports whether the operation has finished.
+func (p *TaskProgress) Done() bool {
+	return p.Status == "completed" || p.Status == "failed"
+}
+
+var (
+	progressMu    sync.Mutex
+	progressTasks = map[string]*TaskProgress{}
+)
+
+// StartProgressTask runs fn in the background and returns its progress, which can be
+// polled with GetProgressTask until an hour after it finished.
+func StartProgressTask(operation string, fn func(progress ProgressFunc) error) *TaskProgress {
+	id := make([]byte, 8)
+	rand.Read(id)
+	now := time.Now().Unix()
+	task := &TaskProgress{
+		Id:        hex.EncodeToString(id),
+		Operation: operation,
+		Status:    "running",
+		Stage:     "starting",
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+
+	progressMu.Lock()
+	pruneProgressTasks()
+	progressTasks[task.Id] = task
+	snapshot := *task
+	progressMu.Unlock()
+
+	go func() {
+		err := fn(func(percent int, stage string) {
+			progressMu.Lock()
+			defer progressMu.Unlock()
+			task.Percent = min(max(percent, task.Percent), 100)
+			task.Stage = stage
+			task.UpdatedAt = time.Now().Unix()
+		})
+
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		task.UpdatedAt = time.Now().Unix()
+		if err != nil {
+			task.Status = "failed"
+			task.Error = err.Error()
+			logger.Warningf("Task %s (%s) failed: %v", task.Id, task.Operation, err)
+			return
+		}
+		task.Status = "completed"
+		task.Percent = 100
+		task.Stage = "done"
+	}()
+	return &snapshot
+}
+
+// GetProgressTask returns the current progress of an operation started with
+// StartProgressTask, or false if it is unknown or expired.
+func GetProgressTask(id string) (*TaskProgress, bool) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	task, ok := progressTasks[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *task
+	return &snapshot, true
+}
+
+// pruneProgressTasks forgets operations that finished more than progressRetention ago.
+// progressMu must be held.
+func pruneProgressTasks() {
+	cutoff := time.Now().Add(-progressRetention).Unix()
+	for id, task := range progressTasks {
+		if task.Done() && task.UpdatedAt < cutoff {
+			delete(progressTasks, id)
+		}
+	}
+}
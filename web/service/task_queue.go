@@ -0,0 +1,225 @@
+// Package service provides TaskQueueService for running queued server tasks in the background.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/redact"
+)
+
+// ServerTask operations executed by the task queue.
+const (
+	TaskRestartXray    = "restart_xray"
+	TaskUpdateGeoFiles = "update_geofiles"
+)
+
+const (
+	// DefaultTaskMaxRetries is how often a queued task is retried by default before it fails.
+	DefaultTaskMaxRetries = 3
+	// maxTaskRetries caps the retries a task may ask for.
+	maxTaskRetries = 10
+
+	// taskQueueWorkers is how many queued tasks run at once.
+	taskQueueWorkers = 4
+	// taskQueueBatch is how many due tasks are claimed per run.
+	taskQueueBatch = 50
+	// taskRunTimeout bounds a single attempt of a queued task.
+	taskRunTimeout = 5 * time.Minute
+	// taskRetryBase is the delay before the first retry; it doubles with every retry.
+	taskRetryBase = 30 * time.Second
+	// taskRetryMax caps the delay between retries.
+	taskRetryMax = 30 * time.Minute
+)
+
+// taskHandler executes one queued task on its server and returns the result stored as
+// the task's ResponseData, if any.
+type taskHandler func(ctx context.Context, task *model.ServerTask) (any, error)
+
+// taskHandlers are the operations executed by the task queue. Other operations, such as
+// fleet upgrades and queued inbound changes, are run by their own services.
+var taskHandlers = map[string]taskHandler{
+	TaskRestartXray:    runRestartXrayTask,
+	TaskUpdateGeoFiles: runUpdateGeoFilesTask,
+}
+
+// taskQueueRunning keeps runs of the queue from overlapping.
+var taskQueueRunning atomic.Bool
+
+// QueuedOperations returns the operations the task queue executes.
+func QueuedOperations() []string {
+	operations := make([]string, 0, len(taskHandlers))
+	for operation := range taskHandlers {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// runRestartXrayTask restarts Xray on the task's server.
+func runRestartXrayTask(ctx context.Context, task *model.ServerTask) (any, error) {
+	serverMgmt := ServerManagementService{}
+	connector, err := serverMgmt.GetConnector(task.ServerId)
+	if err != nil {
+		return nil, err
+	}
+	return nil, connector.RestartXray(ctx)
+}
+
+// runUpdateGeoFilesTask updates the geo files of the task's server and records the outcome.
+func runUpdateGeoFilesTask(ctx context.Context, task *model.ServerTask) (any, error) {
+	geoUpdate := GeoUpdateService{}
+	return geoUpdate.UpdateServer(ctx, task.ServerId)
+}
+
+// TaskQueueService persists operations as pending ServerTasks and runs them with a
+// bounded number of workers, retrying failed attempts with exponential backoff.
+type TaskQueueService struct {
+	serverMgmt     ServerManagementService
+	settingService SettingService
+}
+
+// Enqueue queues an operation on a server. request is stored as the task's input and
+// maxRetries (DefaultTaskMaxRetries if negative) bounds the retries after failed attempts.
+func (s *TaskQueueService) Enqueue(serverId int, operation string, request any, maxRetries int, userId int) (*model.ServerTask, error) {
+	if _, ok := taskHandlers[operation]; !ok {
+		return nil, fmt.Errorf("unknown task operation %q", operation)
+	}
+	if _, err := s.serverMgmt.GetServer(serverId); err != nil {
+		return nil, fmt.Errorf("server %d not found", serverId)
+	}
+	if maxRetries < 0 {
+		maxRetries = DefaultTaskMaxRetries
+	}
+	maxRetries = min(maxRetries, maxTaskRetries)
+
+	payload := []byte("{}")
+	if request != nil {
+		var err error
+		if payload, err = json.Marshal(request); err != nil {
+			return nil, err
+		}
+	}
+	task := &model.ServerTask{
+		ServerId:    serverId,
+		Operation:   operation,
+		Status:      "pending",
+		RequestData: string(redact.JSON(payload)),
+		Payload:     string(payload),
+		MaxRetries:  maxRetries,
+		NextRunAt:   time.Now().Unix(),
+		UserId:      userId,
+	}
+	if err := database.GetDB().Omit("Server").Create(task).Error; err != nil {
+		return nil, err
+	}
+	publishTaskEvent(task, task.Status, "")
+	return task, nil
+}
+
+// RunDue runs the queued tasks that are due, at most taskQueueWorkers at a time, and
+// returns once they are done. Calls while a run is in progress return immediately, and
+// nothing runs in read-only mode.
+func (s *TaskQueueService) RunDue() {
+	if s.settingService.IsReadOnly() {
+		return
+	}
+	if !taskQueueRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer taskQueueRunning.Store(false)
+
+	db := database.GetDB()
+	operations := QueuedOperations()
+	now := time.Now().Unix()
+
+	// Attempts interrupted by a panel restart are due again
+	db.Model(model.ServerTask{}).
+		Where("operation IN ? AND status = ? AND started_at < ?", operations, "running", now-int64((2*taskRunTimeout).Seconds())).
+		Updates(map[string]any{"status": "pending", "next_run_at": now})
+
+	var tasks []*model.ServerTask
+	err := db.Model(model.ServerTask{}).
+		Where("operation IN ? AND status = ? AND next_run_at <= ?", operations, "pending", now).
+		Order("next_run_at, id").Limit(taskQueueBatch).Find(&tasks).Error
+	if err != nil {
+		logger.Warning("Failed to load queued tasks:", err)
+		return
+	}
+
+	sem := make(chan struct{}, taskQueueWorkers)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		// Claim the task, so it never runs twice
+		task.Status = "running"
+		task.StartedAt = time.Now().Unix()
+		result := db.Model(model.ServerTask{}).Where("id = ? AND status = ?", task.Id, "pending").
+			Updates(map[string]any{"status": task.Status, "started_at": task.StartedAt})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		publishTaskEvent(task, task.Status, "")
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(task *model.ServerTask) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.runTask(task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+// runTask makes one attempt at a claimed task and records the outcome. A failed attempt
+// is scheduled again after an exponentially growing delay until MaxRetries is used up.
+func (s *TaskQueueService) runTask(task *model.ServerTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), taskRunTimeout)
+	response, err := taskHandlers[task.Operation](ctx, task)
+	cancel()
+
+	now := time.Now()
+	updates := map[string]any{"completed_at": now.Unix()}
+	switch {
+	case err == nil:
+		updates["status"] = "completed"
+		updates["error_message"] = ""
+		if response != nil {
+			if data, err := json.Marshal(response); err == nil {
+				updates["response_data"] = string(data)
+			}
+		}
+	case task.RetryCount < task.MaxRetries:
+		delay := taskRetryDelay(task.RetryCount)
+		updates["status"] = "pending"
+		updates["error_message"] = err.Error()
+		updates["retry_count"] = task.RetryCount + 1
+		updates["next_run_at"] = now.Add(delay).Unix()
+		logger.Infof("Task %d (%s) on server %d failed, retrying in %s: %v", task.Id, task.Operation, task.ServerId, delay, err)
+	default:
+		updates["status"] = "failed"
+		updates["error_message"] = err.Error()
+		logger.Warningf("Task %d (%s) on server %d failed: %v", task.Id, task.Operation, task.ServerId, err)
+	}
+	database.GetDB().Model(task).Updates(updates)
+	publishTaskEvent(task, updates["status"].(string), updates["error_message"].(string))
+}
+
+// taskRetryDelay returns the delay before the retry following the given number of retries.
+func taskRetryDelay(retries int) time.Duration {
+	delay := taskRetryBase
+	for i := 0; i < retries && delay < taskRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, taskRetryMax)
+}
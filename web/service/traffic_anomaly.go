@@ -0,0 +1,220 @@
+// Package service provides TrafficAnomalyService for detecting sudden traffic changes.
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+const (
+	// anomalyAlpha is the EWMA smoothing factor; each new rate moves the baseline by 10%.
+	anomalyAlpha = 0.1
+	// anomalyWarmup is the number of rates observed before a series can be flagged.
+	anomalyWarmup = 12
+	// anomalyMinRate ignores series whose rate and baseline are both below 10 KB/s,
+	// so idle clients going quiet are not reported as drops.
+	anomalyMinRate = 10 * 1024
+	// anomalyHistorySize is the number of recent anomalies kept for the API.
+	anomalyHistorySize = 100
+)
+
+// TrafficAnomaly is a traffic rate that deviates from its baseline by more than the
+// configured z-score.
+type TrafficAnomaly struct {
+	Time       int64   `json:"time"` // Unix seconds
+	ServerId   int     `json:"serverId"`
+	ServerName string  `json:"serverName"`
+	Email      string  `json:"email,omitempty"` // Empty for server-wide rates
+	Direction  string  `json:"direction"`       // "spike" or "drop"
+	Rate       float64 `json:"rate"`            // Bytes/sec
+	Expected   float64 `json:"expected"`        // Baseline bytes/sec
+	Score      float64 `json:"score"`           // z-score against the baseline
+}
+
+// ewma tracks the exponentially weighted mean and variance of a rate series.
+type ewma struct {
+	mean     float64
+	variance float64
+	count    int
+}
+
+// observe returns the z-score of value against the baseline, then adds it to the baseline.
+func (e *ewma) observe(value float64) float64 {
+	e.count++
+	if e.count == 1 {
+		e.mean = value
+		return 0
+	}
+	diff := value - e.mean
+	score := 0.0
+	if std := math.Sqrt(e.variance); std > 0 {
+		score = diff / std
+	}
+	e.mean += anomalyAlpha * diff
+	e.variance = (1 - anomalyAlpha) * (e.variance + anomalyAlpha*diff*diff)
+	return score
+}
+
+// clientCounter is the last seen traffic counter of a client on a server.
+type clientCounter struct {
+	total int64
+	time  time.Time
+}
+
+var (
+	anomalyMu        sync.Mutex
+	anomalyBaselines = make(map[string]*ewma)
+	anomalyCounters  = make(map[string]*clientCounter)
+	anomalyHistory   []*TrafficAnomaly
+)
+
+// TrafficAnomalyService compares per-server and per-client traffic rates against a
+// rolling EWMA baseline and reports sudden spikes or drops, e.g. abuse or a broken node.
+type TrafficAnomalyService struct {
+	serverMgmt ServerManagementService
+}
+
+// Detect observes the current rates of all enabled servers and their clients and returns
+// those deviating by at least threshold standard deviations. Servers that cannot be
+// reached are skipped; the health check reports them separately.
+func (s *TrafficAnomalyService) Detect(ctx context.Context, threshold float64) ([]*TrafficAnomaly, error) {
+	return s.detect(ctx, threshold, time.Now())
+}
+
+// detect is Detect with the time rates are computed at.
+func (s *TrafficAnomalyService) detect(ctx context.Context, threshold float64, now time.Time) ([]*TrafficAnomaly, error) {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return nil, err
+	}
+
+	anomalies := make([]*TrafficAnomaly, 0)
+	for _, server := range servers {
+		if rate, ok := serverRate(server.Id, now); ok {
+			if anomaly := observeRate(fmt.Sprintf("server:%d", server.Id), rate, threshold); anomaly != nil {
+				anomaly.ServerId = server.Id
+				anomaly.ServerName = server.Name
+				anomalies = append(anomalies, anomaly)
+			}
+		}
+
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			continue
+		}
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		inbounds, err := connector.ListInbounds(listCtx)
+		cancel()
+		if err != nil {
+			logger.Debug("Traffic anomaly: failed to list inbounds of server", server.Id, ":", err)
+			continue
+		}
+
+		for _, inbound := range inbounds {
+			for _, stat := range inbound.ClientStats {
+				key := fmt.Sprintf("client:%d:%s", server.Id, stat.Email)
+				rate, ok := clientRate(key, stat.Up+stat.Down, now)
+				if !ok {
+					continue
+				}
+				if anomaly := observeRate(key, rate, threshold); anomaly != nil {
+					anomaly.ServerId = server.Id
+					anomaly.ServerName = server.Name
+					anomaly.Email = stat.Email
+					anomalies = append(anomalies, anomaly)
+				}
+			}
+		}
+	}
+
+	anomalyMu.Lock()
+	anomalyHistory = append(anomalyHistory, anomalies...)
+	if len(anomalyHistory) > anomalyHistorySize {
+		anomalyHistory = anomalyHistory[len(anomalyHistory)-anomalyHistorySize:]
+	}
+	anomalyMu.Unlock()
+
+	return anomalies, nil
+}
+
+// GetRecentAnomalies returns the latest detected anomalies, newest first.
+func (s *TrafficAnomalyService) GetRecentAnomalies() []*TrafficAnomaly {
+	anomalyMu.Lock()
+	defer anomalyMu.Unlock()
+	recent := make([]*TrafficAnomaly, 0, len(anomalyHistory))
+	for i := len(anomalyHistory) - 1; i >= 0; i-- {
+		recent = append(recent, anomalyHistory[i])
+	}
+	return recent
+}
+
+// serverRate returns the network throughput of a server from its latest fleet sample,
+// if that sample is recent.
+func serverRate(serverId int, now time.Time) (float64, bool) {
+	fleetMu.RLock()
+	defer fleetMu.RUnlock()
+	snapshot, ok := fleetSnapshots[serverId]
+	if !ok || snapshot.stats == nil || len(snapshot.history) == 0 {
+		return 0, false
+	}
+	last := snapshot.history[len(snapshot.history)-1]
+	if now.Sub(time.Unix(last.Time, 0)) > metricSampleMaxAge {
+		return 0, false
+	}
+	return float64(snapshot.stats.NetInSpeed + snapshot.stats.NetOutSpeed), true
+}
+
+// clientRate returns the traffic rate of a client since its previous counter. The first
+// counter and counters that went backwards after a reset only set the new reference.
+func clientRate(key string, total int64, now time.Time) (float64, bool) {
+	anomalyMu.Lock()
+	defer anomalyMu.Unlock()
+	previous, ok := anomalyCounters[key]
+	anomalyCounters[key] = &clientCounter{total: total, time: now}
+	if !ok || total < previous.total {
+		return 0, false
+	}
+	elapsed := now.Sub(previous.time).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return float64(total-previous.total) / elapsed, true
+}
+
+// observeRate adds a rate to the baseline of its series and returns an anomaly if it
+// deviates from the baseline by at least threshold standard deviations.
+func observeRate(key string, rate float64, threshold float64) *TrafficAnomaly {
+	anomalyMu.Lock()
+	defer anomalyMu.Unlock()
+	baseline, ok := anomalyBaselines[key]
+	if !ok {
+		baseline = &ewma{}
+		anomalyBaselines[key] = baseline
+	}
+
+	expected := baseline.mean
+	score := baseline.observe(rate)
+	if baseline.count <= anomalyWarmup || math.Abs(score) < threshold {
+		return nil
+	}
+	if rate < anomalyMinRate && expected < anomalyMinRate {
+		return nil
+	}
+
+	direction := "spike"
+	if score < 0 {
+		direction = "drop"
+	}
+	return &TrafficAnomaly{
+		Time:      time.Now().Unix(),
+		Direction: direction,
+		Rate:      rate,
+		Expected:  expected,
+		Score:     score,
+	}
+}
@@ -0,0 +1,69 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/agent/agenttest"
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/web/service"
+)
+
+// TestDetectRemoteClientSpike feeds a remote agent's client counters through the panel's
+// anomaly detection and expects the sudden jump to be reported.
+func TestDetectRemoteClientSpike(t *testing.T) {
+	agent := agenttest.New()
+	defer agent.Close()
+	server := agent.Server(101, "anomaly-agent")
+	if err := database.GetDB().Create(server).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.GetDB().Delete(&model.Server{}, server.Id)
+	agent.AddInbound(&model.Inbound{
+		Protocol: model.VLESS,
+		Port:     20101,
+		Enable:   true,
+		Settings: `{"clients":[{"id":"0b6c0b2e-4c4c-4e0a-9e4e-3d1f5a8f0e11","email":"spiky","enable":true}]}`,
+	})
+
+	detector := &service.TrafficAnomalyService{}
+	ctx := context.Background()
+	now := time.Now()
+	detect := func() []*service.TrafficAnomaly {
+		t.Helper()
+		// Real samples are minutes apart, well past the inbound cache
+		service.InvalidateInboundCache(server.Id)
+		anomalies, err := service.DetectAnomaliesAt(detector, ctx, 4, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return anomalies
+	}
+
+	// A steady ~100 KB/s baseline with some jitter, one sample a minute
+	detect()
+	for i := range 20 {
+		now = now.Add(time.Minute)
+		agent.AddTraffic("spiky", 0, int64(100<<10+(i%3)*(5<<10))*60)
+		if anomalies := detect(); len(anomalies) != 0 {
+			t.Fatalf("baseline sample %d reported %+v", i, anomalies[0])
+		}
+	}
+
+	// 10 MB/s for a minute
+	now = now.Add(time.Minute)
+	agent.AddTraffic("spiky", 0, 10<<20*60)
+	anomalies := detect()
+	if len(anomalies) != 1 {
+		t.Fatalf("expected one anomaly, got %d", len(anomalies))
+	}
+	anomaly := anomalies[0]
+	if anomaly.ServerId != server.Id || anomaly.Email != "spiky" || anomaly.Direction != "spike" {
+		t.Fatalf("unexpected anomaly %+v", anomaly)
+	}
+	if anomaly.Rate < 10<<20 {
+		t.Fatalf("rate %.0f B/s is below the simulated 10 MB/s", anomaly.Rate)
+	}
+}
@@ -0,0 +1,367 @@
+// Package service provides TrafficHistoryService for daily traffic history and reports.
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// trafficHistoryRetention is how long daily traffic history is kept.
+	trafficHistoryRetention = 400 * 24 * time.Hour
+	// trafficHistoryTimeout bounds fetching the counters of one server.
+	trafficHistoryTimeout = 30 * time.Second
+	// trafficHistoryDateLayout is the format of TrafficHistory.Date and report periods.
+	trafficHistoryDateLayout = "2006-01-02"
+)
+
+// Granularities of traffic reports.
+const (
+	TrafficReportDay   = "day"
+	TrafficReportWeek  = "week"
+	TrafficReportMonth = "month"
+)
+
+// TrafficHistoryService snapshots the traffic counters of every inbound and client on all
+// servers into daily history and builds traffic reports from it.
+type TrafficHistoryService struct {
+	serverMgmt ServerManagementService
+}
+
+// TrafficReportQuery selects what a traffic report covers.
+type TrafficReportQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string // "day", "week" or "month"
+	ServerId    int    // 0 = all servers matching Filter
+	Filter      ServerFilter
+	Email       string // Only this client, "" = all clients
+	ByInbound   bool   // Report whole inbounds instead of clients
+}
+
+// TrafficReport is the traffic of inbounds or clients per period.
+type TrafficReport struct {
+	From        string              `json:"from"` // First day covered, YYYY-MM-DD
+	To          string              `json:"to"`   // Last day covered, YYYY-MM-DD
+	Granularity string              `json:"granularity"`
+	Up          int64               `json:"up"`   // Total of all rows (bytes)
+	Down        int64               `json:"down"` // Total of all rows (bytes)
+	Rows        []*TrafficReportRow `json:"rows"`
+}
+
+// TrafficReportRow is the traffic of one inbound or client on one server in one period.
+type TrafficReportRow struct {
+	Period     string   `json:"period"` // First day of the period: the day, the Monday of the week or the 1st of the month
+	ServerId   int      `json:"serverId"`
+	ServerName string   `json:"serverName"`
+	ServerTags []string `json:"serverTags"`
+	InboundId  int      `json:"inboundId"`
+	Email      string   `json:"email"` // Empty for inbound rows
+	Up         int64    `json:"up"`
+	Down       int64    `json:"down"`
+	Total      int64    `json:"total"`
+}
+
+// trafficCounters are the current counters of an inbound or client on a server.
+type trafficCounters struct {
+	inboundId int
+	email     string
+	up        int64
+	down      int64
+}
+
+// RecordSnapshots adds the traffic used since the previous snapshot by every inbound and
+// client of all enabled servers to today's history, and prunes history past the retention
+// period. Servers that cannot be reached are skipped; their traffic is counted on the next
+// snapshot that reaches them.
+func (s *TrafficHistoryService) RecordSnapshots(ctx context.Context) error {
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	results := FanOut(ctx, servers, FanOutOptions{Timeout: trafficHistoryTimeout},
+		func(ctx context.Context, server *model.Server) ([]trafficCounters, error) {
+			connector, err := s.serverMgmt.GetConnector(server.Id)
+			if err != nil {
+				return nil, err
+			}
+			inbounds, err := connector.ListInbounds(ctx)
+			if err != nil {
+				return nil, err
+			}
+			traffics, err := connector.GetClientTraffics(ctx)
+			if err != nil {
+				return nil, err
+			}
+			counters := make([]trafficCounters, 0, len(inbounds)+len(traffics))
+			for _, inbound := range inbounds {
+				counters = append(counters, trafficCounters{inboundId: inbound.Id, up: inbound.Up, down: inbound.Down})
+			}
+			for _, traffic := range traffics {
+				counters = append(counters, trafficCounters{inboundId: traffic.InboundId, email: traffic.Email, up: traffic.Up, down: traffic.Down})
+			}
+			return counters, nil
+		})
+
+	now := time.Now()
+	errs := make([]error, 0)
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+			continue
+		}
+		if err := s.record(result.Server.Id, result.Value, now); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", result.Server.Name, err))
+		}
+	}
+
+	// Keep the latest row of every inbound and client: it holds the counters to continue from
+	db := database.GetDB()
+	cutoff := now.Add(-trafficHistoryRetention).Format(trafficHistoryDateLayout)
+	latest := db.Model(model.TrafficHistory{}).Select("MAX(id)").Group("server_id, inbound_id, email")
+	if err := db.Where("date < ? AND id NOT IN (?)", cutoff, latest).Delete(model.TrafficHistory{}).Error; err != nil {
+		errs = append(errs, err)
+	}
+
+	return common.Combine(errs...)
+}
+
+// record adds the difference between counters and the previous snapshot of a server to
+// the history of the day of now.
+func (s *TrafficHistoryService) record(serverId int, counters []trafficCounters, now time.Time) error {
+	date := now.Format(trafficHistoryDateLayout)
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		latestIds := tx.Model(model.TrafficHistory{}).Select("MAX(id)").Where("server_id = ?", serverId).Group("inbound_id, email")
+		var latest []*model.TrafficHistory
+		if err := tx.Model(model.TrafficHistory{}).Where("id IN (?)", latestIds).Find(&latest).Error; err != nil {
+			return err
+		}
+		previous := make(map[string]*model.TrafficHistory, len(latest))
+		for _, row := range latest {
+			previous[trafficHistoryKey(row.InboundId, row.Email)] = row
+		}
+
+		created := make([]*model.TrafficHistory, 0)
+		for _, counter := range counters {
+			last, ok := previous[trafficHistoryKey(counter.inboundId, counter.email)]
+			if !ok {
+				// First snapshot: earlier traffic cannot be dated, so it only sets the baseline
+				created = append(created, &model.TrafficHistory{
+					ServerId:    serverId,
+					InboundId:   counter.inboundId,
+					Email:       counter.email,
+					Date:        date,
+					UpCounter:   counter.up,
+					DownCounter: counter.down,
+					UpdatedAt:   now.Unix(),
+				})
+				continue
+			}
+			if last.UpCounter == counter.up && last.DownCounter == counter.down {
+				continue
+			}
+
+			up := trafficDelta(last.UpCounter, counter.up)
+			down := trafficDelta(last.DownCounter, counter.down)
+			if last.Date != date && up+down > 0 {
+				created = append(created, &model.TrafficHistory{
+					ServerId:    serverId,
+					InboundId:   counter.inboundId,
+					Email:       counter.email,
+					Date:        date,
+					Up:          up,
+					Down:        down,
+					UpCounter:   counter.up,
+					DownCounter: counter.down,
+					UpdatedAt:   now.Unix(),
+				})
+				continue
+			}
+			// Same day, or counters reset to zero without new traffic: continue the last row
+			if last.Date != date {
+				up, down = 0, 0
+			}
+			err := tx.Model(last).Updates(map[string]any{
+				"up":           last.Up + up,
+				"down":         last.Down + down,
+				"up_counter":   counter.up,
+				"down_counter": counter.down,
+				"updated_at":   now.Unix(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(created) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(created, 100).Error
+	})
+}
+
+// GetReport returns the traffic of the inbounds or clients selected by query, summed per
+// day, week or month and sorted by period, server, inbound and client.
+func (s *TrafficHistoryService) GetReport(query *TrafficReportQuery) (*TrafficReport, error) {
+	switch query.Granularity {
+	case TrafficReportDay, TrafficReportWeek, TrafficReportMonth:
+	default:
+		return nil, fmt.Errorf("unknown granularity %q, expected day, week or month", query.Granularity)
+	}
+	if query.To.Before(query.From) {
+		return nil, fmt.Errorf("period end must not be before its start")
+	}
+
+	report := &TrafficReport{
+		From:        query.From.In(time.Local).Format(trafficHistoryDateLayout),
+		To:          query.To.In(time.Local).Format(trafficHistoryDateLayout),
+		Granularity: query.Granularity,
+		Rows:        make([]*TrafficReportRow, 0),
+	}
+
+	servers, err := s.serverMgmt.GetServersByFilter(query.Filter, false)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[int]*model.Server, len(servers))
+	serverIds := make([]int, 0, len(servers))
+	for _, server := range servers {
+		selected[server.Id] = server
+		serverIds = append(serverIds, server.Id)
+	}
+
+	db := database.GetDB().Model(model.TrafficHistory{}).
+		Where("date BETWEEN ? AND ?", report.From, report.To).
+		Where("up + down > 0")
+	if query.ByInbound {
+		db = db.Where("email = ''")
+	} else {
+		db = db.Where("email <> ''")
+	}
+	if query.Email != "" {
+		db = db.Where("email = ?", query.Email)
+	}
+	if query.ServerId != 0 {
+		db = db.Where("server_id = ?", query.ServerId)
+	}
+	if !query.Filter.IsEmpty() {
+		db = db.Where("server_id IN ?", serverIds)
+	}
+	var history []*model.TrafficHistory
+	if err := db.Find(&history).Error; err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]*TrafficReportRow)
+	for _, day := range history {
+		period, err := trafficReportPeriod(day.Date, query.Granularity)
+		if err != nil {
+			continue
+		}
+		key := period + "|" + strconv.Itoa(day.ServerId) + "|" + trafficHistoryKey(day.InboundId, day.Email)
+		row, ok := rows[key]
+		if !ok {
+			row = &TrafficReportRow{
+				Period:     period,
+				ServerId:   day.ServerId,
+				ServerTags: make([]string, 0),
+				InboundId:  day.InboundId,
+				Email:      day.Email,
+			}
+			if server := selected[day.ServerId]; server != nil {
+				row.ServerName, row.ServerTags = server.Name, ServerTags(server)
+			}
+			rows[key] = row
+			report.Rows = append(report.Rows, row)
+		}
+		row.Up += day.Up
+		row.Down += day.Down
+		row.Total += day.Up + day.Down
+		report.Up += day.Up
+		report.Down += day.Down
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.ServerId != b.ServerId {
+			return a.ServerId < b.ServerId
+		}
+		if a.InboundId != b.InboundId {
+			return a.InboundId < b.InboundId
+		}
+		return a.Email < b.Email
+	})
+	return report, nil
+}
+
+// WriteTrafficReportCSV writes the report rows as CSV.
+func (s *TrafficHistoryService) WriteTrafficReportCSV(w io.Writer, report *TrafficReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"period", "server_id", "server_name", "inbound_id", "email", "up_bytes", "down_bytes", "total_bytes", "server_tags"}); err != nil {
+		return err
+	}
+	for _, row := range report.Rows {
+		if err := writer.Write([]string{
+			row.Period,
+			strconv.Itoa(row.ServerId),
+			row.ServerName,
+			strconv.Itoa(row.InboundId),
+			row.Email,
+			strconv.FormatInt(row.Up, 10),
+			strconv.FormatInt(row.Down, 10),
+			strconv.FormatInt(row.Total, 10),
+			strings.Join(row.ServerTags, ";"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// trafficDelta returns the traffic counted between two readings of a counter. A counter
+// lower than before was reset, so all of its current value is new traffic.
+func trafficDelta(previous, current int64) int64 {
+	if current >= previous {
+		return current - previous
+	}
+	return current
+}
+
+// trafficReportPeriod returns the first day of the day, week (starting Monday) or month
+// that date belongs to.
+func trafficReportPeriod(date string, granularity string) (string, error) {
+	day, err := time.ParseInLocation(trafficHistoryDateLayout, date, time.Local)
+	if err != nil {
+		return "", err
+	}
+	switch granularity {
+	case TrafficReportWeek:
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case TrafficReportMonth:
+		day = day.AddDate(0, 0, 1-day.Day())
+	}
+	return day.Format(trafficHistoryDateLayout), nil
+}
+
+// trafficHistoryKey identifies an inbound or client within the history of a server.
+func trafficHistoryKey(inboundId int, email string) string {
+	return strconv.Itoa(inboundId) + "|" + email
+}
@@ -0,0 +1,97 @@
+// Package service provides TrafficReportService for fleet-wide traffic reports.
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// TrafficReportService builds traffic reports from the inbounds of every server.
+type TrafficReportService struct {
+	serverMgmt ServerManagementService
+}
+
+// ServerAllTimeTraffic is the all-time and current-period traffic of one server.
+type ServerAllTimeTraffic struct {
+	ServerId   int                     `json:"serverId"`
+	ServerName string                  `json:"serverName"`
+	ServerTags []string                `json:"serverTags"`
+	Up         int64                   `json:"up"`      // Current period upload (bytes)
+	Down       int64                   `json:"down"`    // Current period download (bytes)
+	AllTime    int64                   `json:"allTime"` // Usage preserved across resets (bytes)
+	Clients    []*ClientAllTimeTraffic `json:"clients"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+// ClientAllTimeTraffic is the all-time and current-period traffic of one client on a server.
+type ClientAllTimeTraffic struct {
+	Email     string `json:"email"`
+	InboundId int    `json:"inboundId"`
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+	AllTime   int64  `json:"allTime"`
+}
+
+// GetAllTimeReport returns the all-time traffic of each enabled server and its clients.
+// A serverId of 0 reports on all enabled servers matching filter. Servers that cannot be
+// reached are included with their error so the rest of the report stays usable.
+func (s *TrafficReportService) GetAllTimeReport(ctx context.Context, serverId int, filter ServerFilter) ([]*ServerAllTimeTraffic, error) {
+	servers, err := s.serverMgmt.GetServersByFilter(filter, true)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*ServerAllTimeTraffic, 0, len(servers))
+	for _, server := range servers {
+		if serverId != 0 && server.Id != serverId {
+			continue
+		}
+
+		report := &ServerAllTimeTraffic{
+			ServerId:   server.Id,
+			ServerName: server.Name,
+			ServerTags: ServerTags(server),
+			Clients:    make([]*ClientAllTimeTraffic, 0),
+		}
+		reports = append(reports, report)
+
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			report.Error = err.Error()
+			continue
+		}
+		inbounds, err := connector.ListInbounds(ctx)
+		if err != nil {
+			report.Error = err.Error()
+			continue
+		}
+
+		for _, inbound := range inbounds {
+			report.Up += inbound.Up
+			report.Down += inbound.Down
+			// Rows written before all_time tracking may still lag behind the counters
+			report.AllTime += max(inbound.AllTime, inbound.Up+inbound.Down)
+
+			for _, stat := range inbound.ClientStats {
+				report.Clients = append(report.Clients, &ClientAllTimeTraffic{
+					Email:     stat.Email,
+					InboundId: inbound.Id,
+					Up:        stat.Up,
+					Down:      stat.Down,
+					AllTime:   max(stat.AllTime, stat.Up+stat.Down),
+				})
+			}
+		}
+
+		sort.Slice(report.Clients, func(i, j int) bool {
+			return report.Clients[i].AllTime > report.Clients[j].AllTime
+		})
+	}
+
+	if serverId != 0 && len(reports) == 0 {
+		return nil, fmt.Errorf("server %d not found or disabled", serverId)
+	}
+
+	return reports, nil
+}
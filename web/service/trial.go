@@ -0,0 +1,363 @@
+// Package service provides TrialService for time- and traffic-limited trial clients.
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/util/common"
+	"github.com/cofedish/3x-UI-agents/util/random"
+	"github.com/cofedish/3x-UI-agents/xray"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// trialDefaultHours is the lifetime of a trial that does not set one.
+	trialDefaultHours = 24
+	// trialMaxHours is the longest lifetime a trial may have.
+	trialMaxHours = 30 * 24
+	// trialDefaultTotal is the traffic quota of a trial that does not set one.
+	trialDefaultTotal = 1 << 30
+	// trialTimeout bounds creating or removing a trial on its server.
+	trialTimeout = 30 * time.Second
+)
+
+// States of a trial client.
+const (
+	TrialActive  = "active"
+	TrialRemoved = "removed"
+)
+
+// errNoTrialInbound is returned by createOn if the server has no inbound for the trial.
+var errNoTrialInbound = errors.New("no matching inbound for the trial")
+
+// TrialService creates trial clients in one call and removes them once they lapse.
+type TrialService struct {
+	serverMgmt     ServerManagementService
+	serverGroups   ServerGroupService
+	settingService SettingService
+	inboundService InboundService
+}
+
+// TrialRequest describes a trial client to create, on a server or on a server of a pool.
+type TrialRequest struct {
+	ServerId   int    `json:"serverId" form:"serverId"`
+	Pool       string `json:"pool" form:"pool"`             // ID or name of a server group, used instead of serverId
+	InboundId  int    `json:"inboundId" form:"inboundId"`   // Only with serverId, 0 = pick by inboundTag
+	InboundTag string `json:"inboundTag" form:"inboundTag"` // Empty = first enabled inbound that supports clients
+	Email      string `json:"email" form:"email"`           // Empty = generated
+	Hours      int    `json:"hours" form:"hours"`           // Lifetime, 0 = 24 hours
+	Total      int64  `json:"total" form:"total"`           // Traffic quota in bytes, 0 = 1 GiB
+	LimitIP    int    `json:"limitIp" form:"limitIp"`
+}
+
+// Trial is a created trial client with what its user needs to connect.
+type Trial struct {
+	*model.TrialClient
+	ServerName string `json:"serverName"`
+	SubURL     string `json:"subUrl"`
+	SubJsonURL string `json:"subJsonUrl,omitempty"`
+	QRCode     string `json:"qrCode"` // PNG of SubURL as a data URL
+}
+
+// GetTrials returns all trial clients, newest first.
+func (s *TrialService) GetTrials() ([]*model.TrialClient, error) {
+	var trials []*model.TrialClient
+	err := database.GetDB().Model(model.TrialClient{}).Order("id desc").Find(&trials).Error
+	return trials, err
+}
+
+// CreateTrial creates a trial client and returns its subscription link. With a pool, the
+// client is created on the online member with the fewest active trials that has a
+// matching inbound.
+func (s *TrialService) CreateTrial(ctx context.Context, req *TrialRequest) (*Trial, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		req.Email = "trial-" + strings.ToLower(random.Seq(8))
+	}
+	if req.Hours == 0 {
+		req.Hours = trialDefaultHours
+	}
+	if req.Hours < 0 || req.Hours > trialMaxHours {
+		return nil, fmt.Errorf("trial hours must be between 1 and %d", trialMaxHours)
+	}
+	if req.Total == 0 {
+		req.Total = trialDefaultTotal
+	}
+	if req.Total < 0 || req.LimitIP < 0 {
+		return nil, fmt.Errorf("total and limitIp must not be negative")
+	}
+
+	servers, err := s.candidates(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		trial, err := s.createOn(ctx, server, req)
+		if err == errNoTrialInbound && req.Pool != "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("server %s: %w", server.Name, err)
+		}
+		return s.withLinks(trial, server.Name)
+	}
+	return nil, fmt.Errorf("no online server in pool %s has a matching inbound", req.Pool)
+}
+
+// EndTrial removes an active trial client from its server before it lapses.
+func (s *TrialService) EndTrial(ctx context.Context, id int) error {
+	trial := &model.TrialClient{}
+	if err := database.GetDB().Model(model.TrialClient{}).Where("id = ?", id).First(trial).Error; err != nil {
+		return fmt.Errorf("trial %d not found", id)
+	}
+	if trial.Status != TrialActive {
+		return fmt.Errorf("trial %d was already removed", id)
+	}
+	connector, err := s.serverMgmt.GetConnector(trial.ServerId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, trialTimeout)
+	defer cancel()
+	return s.remove(ctx, connector, trial)
+}
+
+// CleanupTrials removes the active trial clients that expired or used up their traffic
+// and returns how many were removed. Servers that cannot be reached keep their trials
+// until a later run.
+func (s *TrialService) CleanupTrials(ctx context.Context) (int, error) {
+	var trials []*model.TrialClient
+	if err := database.GetDB().Model(model.TrialClient{}).Where("status = ?", TrialActive).Find(&trials).Error; err != nil {
+		return 0, err
+	}
+	if len(trials) == 0 {
+		return 0, nil
+	}
+
+	serverTrials := make(map[int][]*model.TrialClient)
+	for _, trial := range trials {
+		serverTrials[trial.ServerId] = append(serverTrials[trial.ServerId], trial)
+	}
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return 0, err
+	}
+	servers = slices.DeleteFunc(servers, func(server *model.Server) bool {
+		return serverTrials[server.Id] == nil
+	})
+
+	now := time.Now().Unix()
+	results := FanOut(ctx, servers, FanOutOptions{Timeout: trialTimeout},
+		func(ctx context.Context, server *model.Server) (int, error) {
+			connector, err := s.serverMgmt.GetConnector(server.Id)
+			if err != nil {
+				return 0, err
+			}
+			traffics, err := connector.GetClientTraffics(ctx)
+			if err != nil {
+				return 0, err
+			}
+			removed := 0
+			errs := make([]error, 0)
+			for _, trial := range serverTrials[server.Id] {
+				if trial.ExpiresAt > now && !trialDepleted(trial, traffics) {
+					continue
+				}
+				if err := s.remove(ctx, connector, trial); err != nil {
+					errs = append(errs, fmt.Errorf("trial %s: %w", trial.Email, err))
+					continue
+				}
+				removed++
+			}
+			return removed, common.Combine(errs...)
+		})
+
+	removed := 0
+	for _, result := range results {
+		removed += result.Value
+	}
+	return removed, FanOutErrors(results)
+}
+
+// candidates returns the servers to try for a trial, in order of preference.
+func (s *TrialService) candidates(req *TrialRequest) ([]*model.Server, error) {
+	if req.Pool == "" {
+		server, err := s.serverMgmt.GetServer(req.ServerId)
+		if err != nil {
+			return nil, fmt.Errorf("server %d not found", req.ServerId)
+		}
+		if !server.Enabled {
+			return nil, fmt.Errorf("server %s is disabled", server.Name)
+		}
+		return []*model.Server{server}, nil
+	}
+
+	group, err := s.serverGroups.GetGroup(req.Pool)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.serverGroups.GetMembers(group, true)
+	if err != nil {
+		return nil, err
+	}
+	members = slices.DeleteFunc(members, func(server *model.Server) bool {
+		return server.Status != "online"
+	})
+
+	type trialCount struct {
+		ServerId int
+		Count    int
+	}
+	var counts []trialCount
+	err = database.GetDB().Model(model.TrialClient{}).
+		Select("server_id, COUNT(*) AS count").
+		Where("status = ?", TrialActive).
+		Group("server_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[int]int, len(counts))
+	for _, count := range counts {
+		active[count.ServerId] = count.Count
+	}
+	slices.SortStableFunc(members, func(a, b *model.Server) int {
+		return active[a.Id] - active[b.Id]
+	})
+	return members, nil
+}
+
+// createOn creates the trial client on a server and records it.
+func (s *TrialService) createOn(ctx context.Context, server *model.Server, req *TrialRequest) (*model.TrialClient, error) {
+	connector, err := s.serverMgmt.GetConnector(server.Id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, trialTimeout)
+	defer cancel()
+
+	inbound, err := s.findInbound(ctx, connector, req)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+	subId := random.Seq(16)
+	client, err := newResellerClientSettings(inbound, &ResellerClientRequest{
+		Email:      req.Email,
+		Total:      req.Total,
+		ExpiryTime: expiresAt.UnixMilli(),
+		LimitIP:    req.LimitIP,
+		Comment:    "trial",
+	}, subId)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := json.Marshal(map[string]any{"clients": []any{client}})
+	if err != nil {
+		return nil, err
+	}
+	err = connector.AddClient(ctx, &model.Inbound{
+		Id:       inbound.Id,
+		ServerId: server.Id,
+		Settings: string(settings),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	trial := &model.TrialClient{
+		ServerId:  server.Id,
+		InboundId: inbound.Id,
+		Email:     req.Email,
+		SubId:     subId,
+		Total:     req.Total,
+		ExpiresAt: expiresAt.Unix(),
+		Status:    TrialActive,
+	}
+	if err := database.GetDB().Create(trial).Error; err != nil {
+		return nil, err
+	}
+	return trial, nil
+}
+
+// findInbound returns the inbound a trial is created in: the requested inbound, or the
+// first enabled inbound with the requested tag (any tag if empty) that supports clients.
+func (s *TrialService) findInbound(ctx context.Context, connector ServerConnector, req *TrialRequest) (*model.Inbound, error) {
+	if req.InboundId != 0 && req.Pool == "" {
+		return connector.GetInbound(ctx, req.InboundId)
+	}
+	inbounds, err := connector.ListInbounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		if !inbound.Enable || (req.InboundTag != "" && inbound.Tag != req.InboundTag) {
+			continue
+		}
+		switch inbound.Protocol {
+		case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+			return inbound, nil
+		}
+	}
+	return nil, errNoTrialInbound
+}
+
+// remove deletes a trial client from its server and marks the trial removed. A client that
+// is already gone from the server only marks the trial.
+func (s *TrialService) remove(ctx context.Context, connector ServerConnector, trial *model.TrialClient) error {
+	inbound, err := connector.GetInbound(ctx, trial.InboundId)
+	if err != nil {
+		return err
+	}
+	clients, err := s.inboundService.GetClients(inbound)
+	if err != nil {
+		return err
+	}
+	// Client deletion is keyed by the protocol's client ID, not the email
+	for _, client := range clients {
+		if client.Email == trial.Email {
+			if err := connector.DeleteClient(ctx, trial.InboundId, s.inboundService.GetClientId(inbound.Protocol, client)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return database.GetDB().Model(trial).Updates(map[string]any{
+		"status":     TrialRemoved,
+		"removed_at": time.Now().Unix(),
+	}).Error
+}
+
+// withLinks adds the subscription links of a trial for its user.
+func (s *TrialService) withLinks(trial *model.TrialClient, serverName string) (*Trial, error) {
+	result := &Trial{TrialClient: trial, ServerName: serverName}
+	result.SubURL, result.SubJsonURL = s.settingService.GetSubscriptionURLs(trial.SubId)
+	png, err := qrcode.Encode(result.SubURL, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	result.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	return result, nil
+}
+
+// trialDepleted reports whether a trial client used up its traffic quota.
+func trialDepleted(trial *model.TrialClient, traffics []*xray.ClientTraffic) bool {
+	for _, traffic := range traffics {
+		if traffic.Email == trial.Email && traffic.InboundId == trial.InboundId {
+			return trial.Total > 0 && traffic.Up+traffic.Down >= trial.Total
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+// Package service provides TunnelService for tunnel inbounds that relay to inbounds on other servers.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+)
+
+// TunnelOutboundPrefix prefixes the tag of the direct outbound paired with each tunnel inbound.
+const TunnelOutboundPrefix = "tunnel-"
+
+// tunnelRelayCacheTTL limits how often subscription requests list the tunnels of all servers.
+const tunnelRelayCacheTTL = time.Minute
+
+var (
+	tunnelRelayMu      sync.Mutex
+	tunnelRelayCache   map[tunnelTargetKey][]*TunnelRelay
+	tunnelRelayBuiltAt time.Time
+)
+
+// TunnelTarget is the panel-only "target" field of tunnel inbound settings. It points the
+// tunnel at an inbound on a managed server instead of a fixed address and port.
+type TunnelTarget struct {
+	ServerId  int `json:"serverId"`
+	InboundId int `json:"inboundId"`
+}
+
+// tunnelTargetKey identifies a relayed inbound across servers.
+type tunnelTargetKey struct {
+	serverId  int
+	inboundId int
+}
+
+// TunnelRelay is a tunnel inbound through which clients can reach a target inbound.
+// Host is empty when the tunnel runs on the local server.
+type TunnelRelay struct {
+	ServerId int    `json:"serverId"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Remark   string `json:"remark"`
+}
+
+// TunnelService resolves tunnel targets and finds the tunnels relaying to an inbound.
+type TunnelService struct {
+	serverMgmt ServerManagementService
+}
+
+// ResolveTarget fills the address, port and network of a tunnel inbound from its target
+// inbound, so the tunnel follows the target when it is moved. Tunnels without a target
+// keep their fixed address and port.
+func (s *TunnelService) ResolveTarget(ctx context.Context, inbound *model.Inbound) error {
+	if inbound.Protocol != model.Tunnel {
+		return nil
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return fmt.Errorf("invalid tunnel settings: %w", err)
+	}
+	target := parseTunnelTarget(settings)
+	if target == nil {
+		return nil
+	}
+
+	connector, err := s.serverMgmt.GetConnector(target.ServerId)
+	if err != nil {
+		return fmt.Errorf("tunnel target server %d: %w", target.ServerId, err)
+	}
+	targetInbound, err := connector.GetInbound(ctx, target.InboundId)
+	if err != nil {
+		return fmt.Errorf("tunnel target inbound %d: %w", target.InboundId, err)
+	}
+	if targetInbound.Protocol == model.Tunnel {
+		return fmt.Errorf("tunnel target inbound %d is itself a tunnel", target.InboundId)
+	}
+	host, err := s.targetHost(inbound.ServerId, target.ServerId)
+	if err != nil {
+		return err
+	}
+
+	settings["address"] = host
+	settings["port"] = targetInbound.Port
+	if targetInbound.Protocol == model.Shadowsocks {
+		settings["network"] = "tcp,udp"
+	} else {
+		settings["network"] = "tcp"
+	}
+	resolved, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = string(resolved)
+	return nil
+}
+
+// targetHost returns the address a tunnel on tunnelServerId uses to reach targetServerId.
+func (s *TunnelService) targetHost(tunnelServerId, targetServerId int) (string, error) {
+	if tunnelServerId == targetServerId {
+		return "127.0.0.1", nil
+	}
+	host, err := s.serverMgmt.GetPublicHost(targetServerId)
+	if err != nil {
+		return "", fmt.Errorf("tunnel target: %w", err)
+	}
+	return host, nil
+}
+
+// GetRelays returns the tunnels relaying to an inbound on a server.
+// The tunnel list of all servers is cached briefly since subscriptions are public.
+func (s *TunnelService) GetRelays(ctx context.Context, serverId int, inboundId int) []*TunnelRelay {
+	tunnelRelayMu.Lock()
+	defer tunnelRelayMu.Unlock()
+
+	if tunnelRelayCache == nil || time.Since(tunnelRelayBuiltAt) >= tunnelRelayCacheTTL {
+		tunnelRelayCache = s.buildRelays(ctx)
+		tunnelRelayBuiltAt = time.Now()
+	}
+	return tunnelRelayCache[tunnelTargetKey{serverId, inboundId}]
+}
+
+// buildRelays lists the enabled tunnel inbounds of every enabled server by target.
+func (s *TunnelService) buildRelays(ctx context.Context) map[tunnelTargetKey][]*TunnelRelay {
+	relays := make(map[tunnelTargetKey][]*TunnelRelay)
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		logger.Warning("Failed to list servers for tunnels:", err)
+		return relays
+	}
+
+	for _, server := range servers {
+		connector, err := s.serverMgmt.GetConnector(server.Id)
+		if err != nil {
+			continue
+		}
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		inbounds, err := connector.ListInbounds(listCtx)
+		cancel()
+		if err != nil {
+			logger.Warning("Failed to list tunnels of server", server.Id, ":", err)
+			continue
+		}
+
+		host := ""
+		if !server.IsLocal() {
+			host = EndpointHost(server.Endpoint)
+		}
+		for _, inbound := range inbounds {
+			if inbound.Protocol != model.Tunnel || !inbound.Enable {
+				continue
+			}
+			var settings map[string]any
+			if json.Unmarshal([]byte(inbound.Settings), &settings) != nil {
+				continue
+			}
+			target := parseTunnelTarget(settings)
+			if target == nil {
+				continue
+			}
+			key := tunnelTargetKey{target.ServerId, target.InboundId}
+			relays[key] = append(relays[key], &TunnelRelay{
+				ServerId: server.Id,
+				Host:     host,
+				Port:     inbound.Port,
+				Remark:   inbound.Remark,
+			})
+		}
+	}
+	return relays
+}
+
+// parseTunnelTarget returns the target of tunnel settings, or nil if there is none.
+func parseTunnelTarget(settings map[string]any) *TunnelTarget {
+	raw, ok := settings["target"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	serverId, _ := raw["serverId"].(float64)
+	inboundId, _ := raw["inboundId"].(float64)
+	if serverId <= 0 || inboundId <= 0 {
+		return nil
+	}
+	return &TunnelTarget{ServerId: int(serverId), InboundId: int(inboundId)}
+}
+
+// tunnelRoutes pairs each tunnel inbound with a direct outbound, so relayed traffic
+// leaves the server directly even when the default outbound is a proxy such as WARP.
+func tunnelRoutes(tunnelTags []string) []*pairedRoute {
+	routes := make([]*pairedRoute, 0, len(tunnelTags))
+	for _, tag := range tunnelTags {
+		routes = append(routes, &pairedRoute{
+			InboundTag:  tag,
+			OutboundTag: TunnelOutboundPrefix + tag,
+		})
+	}
+	return routes
+}
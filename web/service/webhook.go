@@ -0,0 +1,368 @@
+// Package service provides WebhookService for sending panel events to outgoing webhooks.
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cofedish/3x-UI-agents/database"
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/logger"
+	"github.com/cofedish/3x-UI-agents/util/random"
+)
+
+const (
+	// webhookTimeout bounds one delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// webhookCertTimeout bounds fetching the certificates of one server.
+	webhookCertTimeout = 30 * time.Second
+)
+
+// Events sent to webhooks.
+const (
+	WebhookServerOffline   = "server.offline"
+	WebhookServerOnline    = "server.online"
+	WebhookXrayCrashed     = "xray.crashed"
+	WebhookClientDepleted  = "client.depleted"
+	WebhookCertExpiring    = "cert.expiring"
+	WebhookBackupCompleted = "backup.completed"
+	WebhookTest            = "test"
+)
+
+var (
+	webhookEvents = []string{
+		WebhookServerOffline, WebhookServerOnline, WebhookXrayCrashed,
+		WebhookClientDepleted, WebhookCertExpiring, WebhookBackupCompleted,
+	}
+	// webhookRetryDelays are the pauses before retrying a delivery that failed to
+	// reach the receiver or got a 5xx answer.
+	webhookRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second}
+)
+
+// webhookCertKey identifies a certificate the cert.expiring event was sent for. A
+// renewed certificate has a new expiry and is reported again when it nears it.
+type webhookCertKey struct {
+	serverId int
+	domain   string
+	notAfter int64
+}
+
+var (
+	// webhookCertMu also serializes certificate checks.
+	webhookCertMu       sync.Mutex
+	webhookCertNotified = make(map[webhookCertKey]bool)
+)
+
+// WebhookPayload is the JSON body POSTed to webhooks.
+type WebhookPayload struct {
+	Id        string `json:"id"` // Unique per event, the same for all webhooks and retries
+	Event     string `json:"event"`
+	Timestamp int64  `json:"timestamp"` // Unix timestamp the event happened
+	Data      any    `json:"data"`
+}
+
+// WebhookService manages outgoing webhooks and delivers events to them: servers going
+// offline and coming back, Xray crashes, clients running out of traffic or time,
+// certificates nearing expiry and completed backups. Every request carries an
+// X-Webhook-Signature header, the hex HMAC-SHA256 of "<X-Webhook-Timestamp>.<body>"
+// keyed with the webhook's secret, so receivers can verify it came from the panel.
+type WebhookService struct {
+	settingService SettingService
+	serverMgmt     ServerManagementService
+}
+
+// GetWebhooks returns all webhooks.
+func (s *WebhookService) GetWebhooks() ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	err := database.GetDB().Model(model.Webhook{}).Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// AddWebhook creates a webhook, with a random secret if none is given.
+func (s *WebhookService) AddWebhook(webhook *model.Webhook) error {
+	if err := s.validateWebhook(webhook); err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = random.Seq(32)
+	}
+	webhook.Id = 0
+	webhook.LastDeliveryAt = 0
+	webhook.LastStatus = 0
+	webhook.LastError = ""
+	return database.GetDB().Create(webhook).Error
+}
+
+// UpdateWebhook replaces a webhook. An empty secret keeps the current one.
+func (s *WebhookService) UpdateWebhook(webhook *model.Webhook) error {
+	if err := s.validateWebhook(webhook); err != nil {
+		return err
+	}
+	existing, err := s.getWebhook(webhook.Id)
+	if err != nil {
+		return err
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	webhook.LastDeliveryAt = existing.LastDeliveryAt
+	webhook.LastStatus = existing.LastStatus
+	webhook.LastError = existing.LastError
+	webhook.CreatedAt = existing.CreatedAt
+	return database.GetDB().Save(webhook).Error
+}
+
+// DeleteWebhook removes a webhook.
+func (s *WebhookService) DeleteWebhook(id int) error {
+	result := database.GetDB().Delete(model.Webhook{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("webhook %d not found", id)
+	}
+	return nil
+}
+
+// TestWebhook sends a test event to a webhook, enabled or not, and waits for the answer.
+func (s *WebhookService) TestWebhook(ctx context.Context, id int) error {
+	webhook, err := s.getWebhook(id)
+	if err != nil {
+		return err
+	}
+	payload := newWebhookPayload(WebhookTest, map[string]any{"message": "This is a test event from the 3x-ui panel."})
+	status, err := s.send(ctx, webhook, payload)
+	s.recordDelivery(webhook.Id, status, err)
+	return err
+}
+
+// Emit sends an event to every enabled webhook subscribed to it. Everything runs in the
+// background, so callers are never held up by the database or slow receivers, and may
+// emit while inside a transaction.
+func (s *WebhookService) Emit(event string, data any) {
+	payload := newWebhookPayload(event, data)
+	go func() {
+		var webhooks []*model.Webhook
+		if err := database.GetDB().Model(model.Webhook{}).Where("enable = ?", true).Find(&webhooks).Error; err != nil {
+			logger.Warning("Failed to load webhooks for event", event, ":", err)
+			return
+		}
+		for _, webhook := range webhooks {
+			if webhookSubscribed(webhook, event) {
+				go s.deliver(webhook, payload)
+			}
+		}
+	}()
+}
+
+// CheckCertExpiry sends cert.expiring once for every certificate on an enabled server
+// that expires within webhookCertExpiryDays days. Servers are only polled if a webhook
+// subscribes to the event.
+func (s *WebhookService) CheckCertExpiry(ctx context.Context) error {
+	if !s.hasSubscribers(WebhookCertExpiring) {
+		return nil
+	}
+	days, err := s.settingService.GetWebhookCertExpiryDays()
+	if err != nil || days < 1 {
+		days = 14
+	}
+	servers, err := s.serverMgmt.GetEnabledServers()
+	if err != nil {
+		return err
+	}
+
+	webhookCertMu.Lock()
+	defer webhookCertMu.Unlock()
+
+	results := FanOut(ctx, servers, FanOutOptions{Timeout: webhookCertTimeout},
+		func(ctx context.Context, server *model.Server) ([]*CertInfo, error) {
+			connector, err := s.serverMgmt.GetConnector(server.Id)
+			if err != nil {
+				return nil, err
+			}
+			return connector.GetCerts(ctx)
+		})
+
+	deadline := time.Now().AddDate(0, 0, days).Unix()
+	expiring := make(map[webhookCertKey]bool)
+	polled := make(map[int]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			logger.Debug("Webhook certificate check:", result.Err)
+			continue
+		}
+		polled[result.Server.Id] = true
+		for _, cert := range result.Value {
+			if cert.NotAfter == 0 || cert.NotAfter > deadline {
+				continue
+			}
+			key := webhookCertKey{serverId: result.Server.Id, domain: cert.Domain, notAfter: cert.NotAfter}
+			expiring[key] = true
+			if webhookCertNotified[key] {
+				continue
+			}
+			webhookCertNotified[key] = true
+			s.Emit(WebhookCertExpiring, map[string]any{
+				"serverId":   result.Server.Id,
+				"serverName": result.Server.Name,
+				"domain":     cert.Domain,
+				"notAfter":   cert.NotAfter,
+				"daysLeft":   int(time.Until(time.Unix(cert.NotAfter, 0)).Hours() / 24),
+				"expired":    cert.IsExpired,
+			})
+		}
+	}
+
+	// Renewed or removed certificates are forgotten, those of servers that could not be
+	// polled are kept so they are not reported again
+	for key := range webhookCertNotified {
+		if polled[key.serverId] && !expiring[key] {
+			delete(webhookCertNotified, key)
+		}
+	}
+	return nil
+}
+
+// deliver sends a payload to a webhook, retrying after webhookRetryDelays while the
+// receiver cannot be reached or answers with a server error.
+func (s *WebhookService) deliver(webhook *model.Webhook, payload *WebhookPayload) {
+	for attempt := 0; ; attempt++ {
+		status, err := s.send(context.Background(), webhook, payload)
+		s.recordDelivery(webhook.Id, status, err)
+		if err == nil {
+			return
+		}
+		if attempt == len(webhookRetryDelays) || (status != 0 && status < 500) {
+			logger.Warning("Webhook", webhook.Name, "failed to receive", payload.Event, ":", err)
+			return
+		}
+		time.Sleep(webhookRetryDelays[attempt])
+	}
+}
+
+// send POSTs a signed payload to a webhook once and returns the HTTP status of the answer.
+func (s *WebhookService) send(ctx context.Context, webhook *model.Webhook, payload *WebhookPayload) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "3x-ui-webhook")
+	req.Header.Set("X-Webhook-Id", payload.Id)
+	req.Header.Set("X-Webhook-Event", payload.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(webhook.Secret, timestamp, body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordDelivery stores the outcome of a delivery attempt on the webhook.
+func (s *WebhookService) recordDelivery(id int, status int, err error) {
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+	database.GetDB().Model(model.Webhook{}).Where("id = ?", id).UpdateColumns(map[string]any{
+		"last_delivery_at": time.Now().Unix(),
+		"last_status":      status,
+		"last_error":       lastError,
+	})
+}
+
+// hasSubscribers reports whether an enabled webhook subscribes to event.
+func (s *WebhookService) hasSubscribers(event string) bool {
+	var webhooks []*model.Webhook
+	if err := database.GetDB().Model(model.Webhook{}).Where("enable = ?", true).Find(&webhooks).Error; err != nil {
+		return false
+	}
+	return slices.ContainsFunc(webhooks, func(webhook *model.Webhook) bool {
+		return webhookSubscribed(webhook, event)
+	})
+}
+
+// getWebhook returns one webhook.
+func (s *WebhookService) getWebhook(id int) (*model.Webhook, error) {
+	webhook := &model.Webhook{}
+	if err := database.GetDB().Model(model.Webhook{}).Where("id = ?", id).First(webhook).Error; err != nil {
+		return nil, fmt.Errorf("webhook %d not found", id)
+	}
+	return webhook, nil
+}
+
+// validateWebhook checks and normalizes a webhook's fields before it is saved.
+func (s *WebhookService) validateWebhook(webhook *model.Webhook) error {
+	webhook.Name = strings.TrimSpace(webhook.Name)
+	if webhook.Name == "" {
+		return fmt.Errorf("webhook name is required")
+	}
+	webhook.Url = strings.TrimSpace(webhook.Url)
+	if !isHTTPTarget(webhook.Url) {
+		return fmt.Errorf("webhook URL must be an http(s) URL")
+	}
+	webhook.Secret = strings.TrimSpace(webhook.Secret)
+
+	events := make([]string, 0, len(webhookEvents))
+	for _, event := range strings.Split(webhook.Events, ",") {
+		event = strings.TrimSpace(event)
+		if event == "" || slices.Contains(events, event) {
+			continue
+		}
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("events must be some of %s", strings.Join(webhookEvents, ", "))
+		}
+		events = append(events, event)
+	}
+	webhook.Events = strings.Join(events, ",")
+	return nil
+}
+
+// webhookSubscribed reports whether a webhook receives event.
+func webhookSubscribed(webhook *model.Webhook, event string) bool {
+	return webhook.Events == "" || slices.Contains(strings.Split(webhook.Events, ","), event)
+}
+
+// webhookSignature returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookPayload wraps the data of an event that happened now.
+func newWebhookPayload(event string, data any) *WebhookPayload {
+	return &WebhookPayload{
+		Id:        random.Seq(16),
+		Event:     event,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+}
@@ -0,0 +1,368 @@
+// Package service provides WireguardService for managing WireGuard inbound peers on any server.
+package service
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cofedish/3x-UI-agents/database/model"
+	"github.com/cofedish/3x-UI-agents/xray"
+)
+
+// WireguardOutboundPrefix prefixes the tag of the direct outbound paired with each peer.
+// Xray counts traffic per outbound, so the paired outbound carries the peer's traffic.
+const WireguardOutboundPrefix = "wg-"
+
+// wireguardSubnet is the tunnel network peers get their addresses from.
+// The server side uses .1; peers are assigned .2 to .254.
+const wireguardSubnet = "10.0.0.0/24"
+
+// WireguardPeer is a client of a WireGuard inbound. Email is a panel-only name for the
+// peer; the keys of the peer are kept so its config can be exported later.
+type WireguardPeer struct {
+	Email        string   `json:"email"`
+	PrivateKey   string   `json:"privateKey"`
+	PublicKey    string   `json:"publicKey"`
+	PreSharedKey string   `json:"preSharedKey,omitempty"`
+	AllowedIPs   []string `json:"allowedIPs"`
+	KeepAlive    int      `json:"keepAlive,omitempty"`
+	Up           int64    `json:"up"`
+	Down         int64    `json:"down"`
+}
+
+// WireguardService manages the peers of WireGuard inbounds through server connectors.
+type WireguardService struct {
+	serverMgmt ServerManagementService
+}
+
+// GenerateWireguardKeys returns a new X25519 key pair in WireGuard's base64 encoding.
+func GenerateWireguardKeys() (map[string]any, error) {
+	privateKey := make([]byte, 32)
+	if _, err := rand.Read(privateKey); err != nil {
+		return nil, err
+	}
+	// Clamp the scalar like wg genkey does
+	privateKey[0] &= 248
+	privateKey[31] = (privateKey[31] & 127) | 64
+
+	encoded := base64.StdEncoding.EncodeToString(privateKey)
+	publicKey, err := WireguardPublicKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"privateKey": encoded,
+		"publicKey":  publicKey,
+	}, nil
+}
+
+// WireguardPublicKey derives the base64 public key of a base64 private key.
+func WireguardPublicKey(privateKey string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid WireGuard private key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid WireGuard private key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ListPeers returns the peers of a WireGuard inbound with their traffic.
+func (s *WireguardService) ListPeers(ctx context.Context, serverId int, inboundId int) ([]*WireguardPeer, error) {
+	connector, inbound, settings, err := s.getInbound(ctx, serverId, inboundId)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := parseWireguardPeers(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	traffics, err := connector.GetOutboundTraffics(ctx)
+	if err != nil {
+		// Peers are still useful without traffic, e.g. on agents without outbound stats
+		return peers, nil
+	}
+	byTag := make(map[string]*model.OutboundTraffics, len(traffics))
+	for _, traffic := range traffics {
+		byTag[traffic.Tag] = traffic
+	}
+	for _, peer := range peers {
+		if traffic, ok := byTag[wireguardPeerTag(inbound.Tag, peer.Email)]; ok {
+			peer.Up = traffic.Up
+			peer.Down = traffic.Down
+		}
+	}
+	return peers, nil
+}
+
+// AddPeer creates a peer with new keys and the first free address of the tunnel subnet.
+func (s *WireguardService) AddPeer(ctx context.Context, serverId int, inboundId int, email string) (*WireguardPeer, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, fmt.Errorf("peer email is required")
+	}
+	connector, inbound, settings, err := s.getInbound(ctx, serverId, inboundId)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := parseWireguardPeers(settings)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		if strings.EqualFold(peer.Email, email) {
+			return nil, fmt.Errorf("peer %s already exists", email)
+		}
+	}
+
+	address, err := nextWireguardAddress(peers)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := GenerateWireguardKeys()
+	if err != nil {
+		return nil, err
+	}
+	psk := make([]byte, 32)
+	if _, err := rand.Read(psk); err != nil {
+		return nil, err
+	}
+	peer := &WireguardPeer{
+		Email:        email,
+		PrivateKey:   keys["privateKey"].(string),
+		PublicKey:    keys["publicKey"].(string),
+		PreSharedKey: base64.StdEncoding.EncodeToString(psk),
+		AllowedIPs:   []string{address + "/32"},
+	}
+
+	if err := s.savePeers(ctx, connector, inbound, settings, append(peers, peer)); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// DeletePeer removes a peer from a WireGuard inbound.
+func (s *WireguardService) DeletePeer(ctx context.Context, serverId int, inboundId int, email string) error {
+	connector, inbound, settings, err := s.getInbound(ctx, serverId, inboundId)
+	if err != nil {
+		return err
+	}
+	peers, err := parseWireguardPeers(settings)
+	if err != nil {
+		return err
+	}
+
+	kept := make([]*WireguardPeer, 0, len(peers))
+	for _, peer := range peers {
+		if !strings.EqualFold(peer.Email, email) {
+			kept = append(kept, peer)
+		}
+	}
+	if len(kept) == len(peers) {
+		return fmt.Errorf("peer %s not found", email)
+	}
+	return s.savePeers(ctx, connector, inbound, settings, kept)
+}
+
+// GetPeerConfig returns a wg-quick configuration file for a peer.
+func (s *WireguardService) GetPeerConfig(ctx context.Context, serverId int, inboundId int, email string) (string, error) {
+	_, inbound, settings, err := s.getInbound(ctx, serverId, inboundId)
+	if err != nil {
+		return "", err
+	}
+	peers, err := parseWireguardPeers(settings)
+	if err != nil {
+		return "", err
+	}
+	var peer *WireguardPeer
+	for _, p := range peers {
+		if strings.EqualFold(p.Email, email) {
+			peer = p
+			break
+		}
+	}
+	if peer == nil {
+		return "", fmt.Errorf("peer %s not found", email)
+	}
+	if peer.PrivateKey == "" {
+		return "", fmt.Errorf("the private key of peer %s is not stored", email)
+	}
+
+	secretKey, _ := settings["secretKey"].(string)
+	serverPublicKey, err := WireguardPublicKey(secretKey)
+	if err != nil {
+		return "", err
+	}
+	host, err := s.serverMgmt.ResolveInboundAddress(serverId, inbound)
+	if err != nil {
+		return "", err
+	}
+	mtu := 1420
+	if v, ok := settings["mtu"].(float64); ok && v > 0 {
+		mtu = int(v)
+	}
+
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", peer.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s\n", strings.Join(peer.AllowedIPs, ", "))
+	b.WriteString("DNS = 1.1.1.1, 1.0.0.1\n")
+	fmt.Fprintf(&b, "MTU = %d\n", mtu)
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", serverPublicKey)
+	if peer.PreSharedKey != "" {
+		fmt.Fprintf(&b, "PresharedKey = %s\n", peer.PreSharedKey)
+	}
+	b.WriteString("AllowedIPs = 0.0.0.0/0, ::/0\n")
+	fmt.Fprintf(&b, "Endpoint = %s\n", net.JoinHostPort(host, strconv.Itoa(inbound.Port)))
+	if peer.KeepAlive > 0 {
+		fmt.Fprintf(&b, "PersistentKeepalive = %d\n", peer.KeepAlive)
+	}
+	return b.String(), nil
+}
+
+// getInbound loads a WireGuard inbound and its settings from a server.
+func (s *WireguardService) getInbound(ctx context.Context, serverId int, inboundId int) (ServerConnector, *model.Inbound, map[string]any, error) {
+	connector, err := s.serverMgmt.GetConnector(serverId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	inbound, err := connector.GetInbound(ctx, inboundId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if inbound.Protocol != model.WireGuard {
+		return nil, nil, nil, fmt.Errorf("inbound %d is not a WireGuard inbound", inboundId)
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid WireGuard settings: %w", err)
+	}
+	return connector, inbound, settings, nil
+}
+
+// savePeers writes the peer list back to the inbound and restarts Xray on its server.
+func (s *WireguardService) savePeers(ctx context.Context, connector ServerConnector, inbound *model.Inbound, settings map[string]any, peers []*WireguardPeer) error {
+	rawPeers := make([]map[string]any, 0, len(peers))
+	for _, peer := range peers {
+		rawPeer := map[string]any{
+			"email":      peer.Email,
+			"privateKey": peer.PrivateKey,
+			"publicKey":  peer.PublicKey,
+			"allowedIPs": peer.AllowedIPs,
+		}
+		if peer.PreSharedKey != "" {
+			rawPeer["preSharedKey"] = peer.PreSharedKey
+		}
+		if peer.KeepAlive > 0 {
+			rawPeer["keepAlive"] = peer.KeepAlive
+		}
+		rawPeers = append(rawPeers, rawPeer)
+	}
+	settings["peers"] = rawPeers
+
+	newSettings, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = string(newSettings)
+	if err := connector.UpdateInbound(ctx, inbound); err != nil {
+		return err
+	}
+	return connector.RestartXray(ctx)
+}
+
+// parseWireguardPeers reads the peers of WireGuard settings.
+func parseWireguardPeers(settings map[string]any) ([]*WireguardPeer, error) {
+	raw, err := json.Marshal(settings["peers"])
+	if err != nil {
+		return nil, err
+	}
+	var peers []*WireguardPeer
+	if err := json.Unmarshal(raw, &peers); err != nil {
+		return nil, fmt.Errorf("invalid WireGuard peers: %w", err)
+	}
+	return peers, nil
+}
+
+// nextWireguardAddress returns the first address of the tunnel subnet not used by a peer.
+func nextWireguardAddress(peers []*WireguardPeer) (string, error) {
+	_, subnet, _ := net.ParseCIDR(wireguardSubnet)
+	used := make(map[string]bool)
+	for _, peer := range peers {
+		for _, allowed := range peer.AllowedIPs {
+			if ip, _, err := net.ParseCIDR(allowed); err == nil {
+				used[ip.String()] = true
+			}
+		}
+	}
+
+	base := subnet.IP.To4()
+	for host := 2; host < 255; host++ {
+		ip := net.IPv4(base[0], base[1], base[2], byte(host)).String()
+		if !used[ip] {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("no free address left in %s", wireguardSubnet)
+}
+
+// wireguardPeerTag returns the tag of the outbound paired with a peer.
+func wireguardPeerTag(inboundTag string, email string) string {
+	return WireguardOutboundPrefix + inboundTag + "-" + email
+}
+
+// wireguardRoutes pairs each named peer of a WireGuard inbound with its own direct
+// outbound, matched by the peer's tunnel addresses.
+func wireguardRoutes(inbound *model.Inbound, settings map[string]any) []*pairedRoute {
+	peers, err := parseWireguardPeers(settings)
+	if err != nil {
+		return nil
+	}
+	var routes []*pairedRoute
+	for _, peer := range peers {
+		if peer.Email == "" || len(peer.AllowedIPs) == 0 {
+			continue
+		}
+		routes = append(routes, &pairedRoute{
+			InboundTag:  inbound.Tag,
+			Sources:     peer.AllowedIPs,
+			OutboundTag: wireguardPeerTag(inbound.Tag, peer.Email),
+		})
+	}
+	return routes
+}
+
+// enableOutboundStats turns on the outbound counters peer traffic accounting relies on.
+func enableOutboundStats(xrayConfig *xray.Config) error {
+	policy := map[string]any{}
+	if len(xrayConfig.Policy) > 0 {
+		if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+			return err
+		}
+	}
+	system, _ := policy["system"].(map[string]any)
+	if system == nil {
+		system = map[string]any{}
+	}
+	system["statsOutboundUplink"] = true
+	system["statsOutboundDownlink"] = true
+	policy["system"] = system
+
+	newPolicy, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	xrayConfig.Policy = newPolicy
+	return nil
+}
@@ -1,11 +1,16 @@
 package service
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
 	"runtime"
 	"sync"
 
+	"github.com/cofedish/3x-UI-agents/config"
+	"github.com/cofedish/3x-UI-agents/database/model"
 	"github.com/cofedish/3x-UI-agents/logger"
 	"github.com/cofedish/3x-UI-agents/xray"
 
@@ -15,8 +20,9 @@ import (
 var (
 	p                 *xray.Process
 	lock              sync.Mutex
-	isNeedXrayRestart atomic.Bool // Indicates that restart was requested for Xray
-	isManuallyStopped atomic.Bool // Indicates that Xray was stopped manually from the panel
+	isNeedXrayRestart atomic.Bool   // Indicates that restart was requested for Xray
+	isManuallyStopped atomic.Bool   // Indicates that Xray was stopped manually from the panel
+	lastStartErr      atomic.String // Error of the last failed start, empty once Xray started
 	result            string
 )
 
@@ -25,7 +31,6 @@ var (
 type XrayService struct {
 	inboundService InboundService
 	settingService SettingService
-	xrayAPI        xray.XrayAPI
 }
 
 // IsXrayRunning checks if the Xray process is currently running.
@@ -33,6 +38,14 @@ func (s *XrayService) IsXrayRunning() bool {
 	return p != nil && p.IsRunning()
 }
 
+// GetXrayUptime returns the seconds since the Xray process started, or 0 if it is not running.
+func (s *XrayService) GetXrayUptime() uint64 {
+	if !s.IsXrayRunning() {
+		return 0
+	}
+	return p.GetUptime()
+}
+
 // GetXrayErr returns the error from the Xray process, if any.
 func (s *XrayService) GetXrayErr() error {
 	if p == nil {
@@ -50,6 +63,19 @@ func (s *XrayService) GetXrayErr() error {
 	return err
 }
 
+// GetXrayErrorMsg returns why Xray is not running: the error of the last failed start,
+// else the output of the exited process. It is empty while Xray runs or after it was
+// stopped on purpose.
+func (s *XrayService) GetXrayErrorMsg() string {
+	if s.IsXrayRunning() || isManuallyStopped.Load() {
+		return ""
+	}
+	if msg := lastStartErr.Load(); msg != "" {
+		return msg
+	}
+	return s.GetXrayResult()
+}
+
 // GetXrayResult returns the result string from the Xray process.
 func (s *XrayService) GetXrayResult() string {
 	if result != "" {
@@ -89,23 +115,41 @@ func RemoveIndex(s []any, index int) []any {
 
 // GetXrayConfig retrieves and builds the Xray configuration from settings and inbounds.
 func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
+	xrayConfig, _, err := s.buildXrayConfig()
+	return xrayConfig, err
+}
+
+// buildXrayConfig builds the Xray configuration and returns it with the rate limits its
+// marked outbounds stand for.
+func (s *XrayService) buildXrayConfig() (*xray.Config, []rateLimit, error) {
 	templateConfig, err := s.settingService.GetXrayConfigTemplate()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	xrayConfig := &xray.Config{}
 	err = json.Unmarshal([]byte(templateConfig), xrayConfig)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	blockedIPService := BlockedIPService{}
+	if err := blockedIPService.applyBlockedIPs(xrayConfig); err != nil {
+		logger.Warning("Failed to apply IP blocklist to Xray config:", err)
+	}
+	if err := applyFleetXraySettings(&s.settingService, xrayConfig); err != nil {
+		logger.Warning("Failed to apply fleet settings to Xray config:", err)
 	}
 
 	s.inboundService.AddTraffic(nil, nil)
 
 	inbounds, err := s.inboundService.GetAllInbounds()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	var tunnelTags []string
+	var limits []rateLimit
+	var peerRoutes []*pairedRoute
 	for _, inbound := range inbounds {
 		if !inbound.Enable {
 			continue
@@ -113,6 +157,24 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		// get settings clients
 		settings := map[string]any{}
 		json.Unmarshal([]byte(inbound.Settings), &settings)
+		if inbound.Protocol == model.Tunnel {
+			// The relay target is panel metadata; address and port are already resolved
+			if _, ok := settings["target"]; ok {
+				delete(settings, "target")
+				modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+				if err != nil {
+					return nil, nil, err
+				}
+				inbound.Settings = string(modifiedSettings)
+			}
+			tunnelTags = append(tunnelTags, inbound.Tag)
+		}
+		if limit, ok := inboundRateLimit(inbound); ok {
+			limits = append(limits, limit)
+		}
+		if inbound.Protocol == model.WireGuard {
+			peerRoutes = append(peerRoutes, wireguardRoutes(inbound, settings)...)
+		}
 		clients, ok := settings["clients"].([]any)
 		if ok {
 			// check users active or not
@@ -131,6 +193,10 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 				}
 			}
 
+			// The inbound's policy level applies to clients without their own
+			policyLevel, hasPolicyLevel := settings["policyLevel"].(float64)
+			delete(settings, "policyLevel")
+
 			// clear client config for additional parameters
 			var final_clients []any
 			for _, client := range clients {
@@ -140,8 +206,17 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 						continue
 					}
 				}
+				if limit, ok := clientRateLimit(inbound.Tag, c); ok {
+					limits = append(limits, limit)
+				}
+				if _, ok := c["level"].(float64); !ok {
+					delete(c, "level")
+					if hasPolicyLevel {
+						c["level"] = policyLevel
+					}
+				}
 				for key := range c {
-					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
+					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" && key != "level" {
 						delete(c, key)
 					}
 					if c["flow"] == "xtls-rprx-vision-udp443" {
@@ -154,7 +229,7 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 			settings["clients"] = final_clients
 			modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			inbound.Settings = string(modifiedSettings)
@@ -180,7 +255,7 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 
 			newStream, err := json.MarshalIndent(stream, "", "  ")
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			inbound.StreamSettings = string(newStream)
 		}
@@ -188,7 +263,21 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		inboundConfig := inbound.GenXrayInboundConfig()
 		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
 	}
-	return xrayConfig, nil
+	if err := applyPairedRoutes(xrayConfig, tunnelRoutes(tunnelTags)); err != nil {
+		logger.Warning("Failed to add tunnel outbounds to Xray config:", err)
+	}
+	if len(peerRoutes) > 0 {
+		if err := applyPairedRoutes(xrayConfig, peerRoutes); err != nil {
+			logger.Warning("Failed to add WireGuard peer outbounds to Xray config:", err)
+		} else if err := enableOutboundStats(xrayConfig); err != nil {
+			logger.Warning("Failed to enable outbound stats for WireGuard peers:", err)
+		}
+	}
+	if err := applyRateLimitRoutes(xrayConfig, limits); err != nil {
+		logger.Warning("Failed to add rate limit outbounds to Xray config:", err)
+		limits = nil
+	}
+	return xrayConfig, limits, nil
 }
 
 // GetXrayTraffic fetches the current traffic statistics from the running Xray process.
@@ -198,11 +287,13 @@ func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic,
 		logger.Debug("Attempted to fetch Xray traffic, but Xray is not running:", err)
 		return nil, nil, err
 	}
+	// Each call has its own client, as handlers share the service concurrently
+	var xrayAPI xray.XrayAPI
 	apiPort := p.GetAPIPort()
-	s.xrayAPI.Init(apiPort)
-	defer s.xrayAPI.Close()
+	xrayAPI.Init(apiPort)
+	defer xrayAPI.Close()
 
-	traffic, clientTraffic, err := s.xrayAPI.GetTraffic(true)
+	traffic, clientTraffic, err := xrayAPI.GetTraffic(true)
 	if err != nil {
 		logger.Debug("Failed to fetch Xray traffic:", err)
 		return nil, nil, err
@@ -210,6 +301,52 @@ func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic,
 	return traffic, clientTraffic, nil
 }
 
+// AddUserByApi adds a user to a running inbound through the Xray API without a restart.
+// The change is not persisted to the database and is lost when Xray restarts.
+func (s *XrayService) AddUserByApi(protocol, inboundTag string, user map[string]any) error {
+	if !s.IsXrayRunning() {
+		return errors.New("xray is not running")
+	}
+	var xrayAPI xray.XrayAPI
+	if err := xrayAPI.Init(p.GetAPIPort()); err != nil {
+		return err
+	}
+	defer xrayAPI.Close()
+
+	return xrayAPI.AddUser(protocol, inboundTag, user)
+}
+
+// RemoveUserByApi removes a user from a running inbound through the Xray API without a restart.
+// The change is not persisted to the database and is lost when Xray restarts.
+func (s *XrayService) RemoveUserByApi(inboundTag, email string) error {
+	if !s.IsXrayRunning() {
+		return errors.New("xray is not running")
+	}
+	var xrayAPI xray.XrayAPI
+	if err := xrayAPI.Init(p.GetAPIPort()); err != nil {
+		return err
+	}
+	defer xrayAPI.Close()
+
+	return xrayAPI.RemoveUser(inboundTag, email)
+}
+
+// QueryStatsByApi returns raw Xray counters matching pattern from the running Xray process.
+// The counters are never reset here: the traffic collector adds up what it reads and
+// resets, so a reset by anyone else would lose traffic.
+func (s *XrayService) QueryStatsByApi(pattern string) (map[string]int64, error) {
+	if !s.IsXrayRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	var xrayAPI xray.XrayAPI
+	if err := xrayAPI.Init(p.GetAPIPort()); err != nil {
+		return nil, err
+	}
+	defer xrayAPI.Close()
+
+	return xrayAPI.QueryStats(pattern, false)
+}
+
 // RestartXray restarts the Xray process, optionally forcing a restart even if config unchanged.
 func (s *XrayService) RestartXray(isForce bool) error {
 	lock.Lock()
@@ -217,10 +354,14 @@ func (s *XrayService) RestartXray(isForce bool) error {
 	logger.Debug("restart Xray, force:", isForce)
 	isManuallyStopped.Store(false)
 
-	xrayConfig, err := s.GetXrayConfig()
+	xrayConfig, limits, err := s.buildXrayConfig()
 	if err != nil {
+		lastStartErr.Store(fmt.Sprintf("failed to generate Xray config: %v", err))
 		return err
 	}
+	if err := shapeRateLimits(limits); err != nil {
+		logger.Warning("Failed to apply rate limits:", err)
+	}
 
 	if s.IsXrayRunning() {
 		if !isForce && p.GetConfig().Equals(xrayConfig) && !isNeedXrayRestart.Load() {
@@ -234,12 +375,68 @@ func (s *XrayService) RestartXray(isForce bool) error {
 	result = ""
 	err = p.Start()
 	if err != nil {
+		lastStartErr.Store(err.Error())
 		return err
 	}
+	lastStartErr.Store("")
 
 	return nil
 }
 
+// ApplyRoutingChange applies a config change that only touches the routing rules, such
+// as a blocklist update, through the Xray routing API, so connections stay up. Any other
+// change, or a failed API call, falls back to RestartXray.
+func (s *XrayService) ApplyRoutingChange() error {
+	applied, err := s.replaceRouting()
+	if applied {
+		return nil
+	}
+	if err != nil {
+		logger.Warning("Failed to update Xray routing without a restart:", err)
+	}
+	return s.RestartXray(false)
+}
+
+// replaceRouting switches the running Xray to the routing rules of the current config
+// if nothing else changed. It reports whether the running Xray is up to date.
+func (s *XrayService) replaceRouting() (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !s.IsXrayRunning() || isNeedXrayRestart.Load() {
+		return false, nil
+	}
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return false, err
+	}
+	running := p.GetConfig()
+	candidate := *running
+	candidate.RouterConfig = xrayConfig.RouterConfig
+	if !candidate.Equals(xrayConfig) {
+		return false, nil
+	}
+	if bytes.Equal(running.RouterConfig, xrayConfig.RouterConfig) {
+		return true, nil
+	}
+
+	// Rules are built in this process, so geo files must be found where Xray has them
+	if _, ok := os.LookupEnv("XRAY_LOCATION_ASSET"); !ok {
+		os.Setenv("XRAY_LOCATION_ASSET", config.GetBinFolderPath())
+	}
+	var xrayAPI xray.XrayAPI
+	if err := xrayAPI.Init(p.GetAPIPort()); err != nil {
+		return false, err
+	}
+	defer xrayAPI.Close()
+	if err := xrayAPI.ReplaceRouting(xrayConfig.RouterConfig); err != nil {
+		return false, err
+	}
+	running.RouterConfig = xrayConfig.RouterConfig
+	logger.Info("Xray routing updated without a restart")
+	return true, nil
+}
+
 // StopXray stops the running Xray process.
 func (s *XrayService) StopXray() error {
 	lock.Lock()
@@ -266,3 +463,67 @@ func (s *XrayService) IsNeedRestartAndSetFalse() bool {
 func (s *XrayService) DidXrayCrash() bool {
 	return !s.IsXrayRunning() && !isManuallyStopped.Load()
 }
+
+// pairedRoute is a direct outbound created for one inbound (or some of its sources)
+// together with the routing rule that sends the inbound's traffic to it.
+type pairedRoute struct {
+	InboundTag  string
+	Sources     []string // Optional source CIDRs the rule is limited to
+	OutboundTag string
+}
+
+// applyPairedRoutes adds a freedom outbound and a routing rule for each route. The rules
+// go right after the blocklist rule (first if there is none), which must keep winning
+// over them.
+func applyPairedRoutes(xrayConfig *xray.Config, routes []*pairedRoute) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	var outbounds []any
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+			return err
+		}
+	}
+
+	rules, _ := routing["rules"].([]any)
+	insertAt := blocklistRuleIndex(rules) + 1
+	pairedRules := make([]any, 0, len(routes))
+	for _, route := range routes {
+		outbounds = append(outbounds, map[string]any{
+			"protocol": "freedom",
+			"tag":      route.OutboundTag,
+		})
+		rule := map[string]any{
+			"type":        "field",
+			"inboundTag":  []string{route.InboundTag},
+			"outboundTag": route.OutboundTag,
+		}
+		if len(route.Sources) > 0 {
+			rule["source"] = route.Sources
+		}
+		pairedRules = append(pairedRules, rule)
+	}
+	newRules := append([]any{}, rules[:insertAt]...)
+	newRules = append(newRules, pairedRules...)
+	routing["rules"] = append(newRules, rules[insertAt:]...)
+
+	routerConfig, err := json.Marshal(routing)
+	if err != nil {
+		return err
+	}
+	outboundConfigs, err := json.Marshal(outbounds)
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = routerConfig
+	xrayConfig.OutboundConfigs = outboundConfigs
+	return nil
+}
@@ -18,7 +18,6 @@ import (
 
 	"github.com/cofedish/3x-UI-agents/config"
 	"github.com/cofedish/3x-UI-agents/logger"
-	"github.com/cofedish/3x-UI-agents/util/common"
 	"github.com/cofedish/3x-UI-agents/web/controller"
 	"github.com/cofedish/3x-UI-agents/web/job"
 	"github.com/cofedish/3x-UI-agents/web/locale"
@@ -44,6 +43,9 @@ var i18nFS embed.FS
 
 var startTime = time.Now()
 
+// shutdownTimeout bounds how long Stop waits for in-flight requests and running jobs.
+const shutdownTimeout = 15 * time.Second
+
 type wrapAssetsFS struct {
 	embed.FS
 }
@@ -95,13 +97,20 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 
-	index *controller.IndexController
-	panel *controller.XUIController
-	api   *controller.APIController
+	index    *controller.IndexController
+	panel    *controller.XUIController
+	api      *controller.APIController
+	reseller *controller.ResellerAPIController
+	renewal  *controller.RenewalWebhookController
+	metrics  *controller.MetricsController
+	node     *controller.NodeAPIController
+	enroll   *controller.AgentRegistrationController
 
 	xrayService    service.XrayService
 	settingService service.SettingService
 	tgbotService   service.Tgbot
+	serverMgmt     service.ServerManagementService
+	demoService    service.DemoService
 
 	cron *cron.Cron
 
@@ -176,6 +185,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	service.RegisterValidators()
 	engine := gin.Default()
 
 	webDomain, err := s.settingService.GetWebDomain()
@@ -187,6 +197,26 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		engine.Use(middleware.DomainValidatorMiddleware(webDomain))
 	}
 
+	hstsMaxAge, err := s.settingService.GetHstsMaxAge()
+	if err != nil {
+		return nil, err
+	}
+	csp, err := s.settingService.GetContentSecurityPolicy()
+	if err != nil {
+		return nil, err
+	}
+	engine.Use(middleware.SecurityHeadersMiddleware(middleware.SecurityHeaders{
+		HSTSMaxAge:            hstsMaxAge,
+		ContentSecurityPolicy: csp,
+	}))
+	corsOrigins, err := s.settingService.GetCorsAllowedOrigins()
+	if err != nil {
+		return nil, err
+	}
+	if len(corsOrigins) > 0 {
+		engine.Use(middleware.CORSMiddleware(corsOrigins))
+	}
+
 	secret, err := s.settingService.GetSecret()
 	if err != nil {
 		return nil, err
@@ -261,10 +291,16 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	engine.Use(middleware.RedirectMiddleware(basePath))
 
 	g := engine.Group(basePath)
+	g.Use(controller.ReadOnlyMiddleware())
 
 	s.index = controller.NewIndexController(g)
 	s.panel = controller.NewXUIController(g)
 	s.api = controller.NewAPIController(g)
+	s.reseller = controller.NewResellerAPIController(g)
+	s.renewal = controller.NewRenewalWebhookController(g)
+	s.metrics = controller.NewMetricsController(g)
+	s.node = controller.NewNodeAPIController(g)
+	s.enroll = controller.NewAgentRegistrationController(g)
 
 	// Chrome DevTools endpoint for debugging web apps
 	engine.GET("/.well-known/appspecific/com.chrome.devtools.json", func(c *gin.Context) {
@@ -311,17 +347,68 @@ func (s *Server) startTask() {
 	// check client ips from log file every day
 	s.cron.AddJob("@daily", job.NewClearLogsJob())
 
-	// Inbound traffic reset jobs
-	// Run once a day, midnight
-	s.cron.AddJob("@daily", job.NewPeriodicTrafficResetJob("daily"))
-	// Run once a week, midnight between Sat/Sun
-	s.cron.AddJob("@weekly", job.NewPeriodicTrafficResetJob("weekly"))
-	// Run once a month, midnight, first of month
-	s.cron.AddJob("@monthly", job.NewPeriodicTrafficResetJob("monthly"))
+	// Inbound traffic reset job
+	// Runs hourly; daily, weekly and monthly resets start at midnight in each server's timezone
+	s.cron.AddJob("@hourly", job.NewPeriodicTrafficResetJob())
 
 	// Multi-server health monitoring - check server health every 30 seconds
 	s.cron.AddJob("@every 30s", job.NewServerHealthJob())
 
+	// Fleet-wide client IP limit enforcement, no-op unless enabled in settings
+	s.cron.AddJob("@every 1m", job.NewGlobalIpLimitJob())
+
+	// Expire blocklist entries and propagate the change to all servers
+	s.cron.AddJob("@every 1m", job.NewBlockedIPJob())
+
+	// Prune old panel login attempts
+	s.cron.AddJob("@daily", job.NewLoginAttemptJob())
+
+	// Queued server tasks and their retries
+	s.cron.AddJob("@every 10s", job.NewTaskQueueJob())
+
+	// Outbound traffic of remote servers; the local server is covered by the Xray traffic job
+	s.cron.AddJob("@every 1m", job.NewOutboundTrafficJob())
+
+	// Hourly client traffic samples for billing exports
+	s.cron.AddJob("@hourly", job.NewTrafficSampleJob())
+
+	// Daily traffic history of inbounds and clients for traffic reports
+	s.cron.AddJob("@every 15m", job.NewTrafficHistoryJob())
+
+	// Server metric history for CSV exports, taken from the health check samples
+	s.cron.AddJob("@every 5m", job.NewMetricSampleJob())
+
+	// Traffic spike/drop alerts, no-op unless enabled in settings
+	s.cron.AddJob("@every 5m", job.NewTrafficAnomalyJob())
+
+	// Rotate the JWT secrets shared with agents, no-op if disabled in settings
+	s.cron.AddJob("@daily", job.NewJWTRotationJob())
+
+	// Clean up long expired or depleted clients, no-op unless enabled in settings
+	s.cron.AddJob("@daily", job.NewStaleClientJob())
+
+	// Remove trial clients once they expire or use up their traffic
+	s.cron.AddJob("@every 5m", job.NewTrialCleanupJob())
+
+	// Check that inbound ports are reachable from the panel, no-op unless enabled in settings
+	s.cron.AddJob("@every 5m", job.NewPortProbeJob())
+
+	// Export panel snapshots for disaster recovery, no-op unless a target is set
+	s.cron.AddJob("@every 1m", job.NewStandbyExportJob())
+
+	// Evaluate alert rules, no-op unless rules are enabled
+	s.cron.AddJob("@every 1m", job.NewAlertJob())
+
+	// Report expiring certificates to webhooks, no-op unless a webhook subscribes
+	s.cron.AddJob("@every 1h", job.NewWebhookCertJob())
+
+	// Scheduled geo file updates on all servers
+	if runtime, err := s.settingService.GetGeoUpdateCron(); err == nil && runtime != "" {
+		if _, err := s.cron.AddJob(runtime, job.NewGeoUpdateJob()); err != nil {
+			logger.Warning("Add NewGeoUpdateJob error:", err)
+		}
+	}
+
 	// LDAP sync scheduling
 	if ldapEnabled, _ := s.settingService.GetLdapEnable(); ldapEnabled {
 		runtime, err := s.settingService.GetLdapSyncCron()
@@ -378,6 +465,17 @@ func (s *Server) Start() (err error) {
 	s.cron = cron.New(cron.WithLocation(loc), cron.WithSeconds())
 	s.cron.Start()
 
+	// Encrypt server auth data stored before XUI_AUTHDATA_KEY was set
+	if err := s.serverMgmt.SealStoredAuthData(); err != nil {
+		logger.Warning("Failed to encrypt stored server auth data:", err)
+	}
+
+	if config.IsDemo() {
+		if err := s.demoService.Seed(); err != nil {
+			logger.Warning("Failed to seed demo servers:", err)
+		}
+	}
+
 	engine, err := s.initRouter()
 	if err != nil {
 		return err
@@ -442,24 +540,35 @@ func (s *Server) Start() (err error) {
 }
 
 // Stop gracefully shuts down the web server, stops Xray, cron jobs, and Telegram bot.
+// New connections are refused at once, while in-flight requests and running cron jobs
+// get up to shutdownTimeout to finish before they are cut.
 func (s *Server) Stop() error {
-	s.cancel()
-	s.xrayService.StopXray()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var err1 error
+	if s.httpServer != nil {
+		// Shutdown also closes the listener
+		if err1 = s.httpServer.Shutdown(ctx); err1 != nil {
+			logger.Warning("Web server did not drain in time:", err1)
+			s.httpServer.Close()
+		}
+	} else if s.listener != nil {
+		err1 = s.listener.Close()
+	}
 	if s.cron != nil {
-		s.cron.Stop()
+		select {
+		case <-s.cron.Stop().Done():
+		case <-ctx.Done():
+			logger.Warning("Cron jobs still running after", shutdownTimeout)
+		}
 	}
+	s.cancel()
 	if s.tgbotService.IsRunning() {
 		s.tgbotService.Stop()
 	}
-	var err1 error
-	var err2 error
-	if s.httpServer != nil {
-		err1 = s.httpServer.Shutdown(s.ctx)
-	}
-	if s.listener != nil {
-		err2 = s.listener.Close()
-	}
-	return common.Combine(err1, err2)
+	s.xrayService.StopXray()
+	return err1
 }
 
 // GetCtx returns the server's context for cancellation and deadline management.
@@ -15,6 +15,7 @@ import (
 	"github.com/cofedish/3x-UI-agents/util/common"
 
 	"github.com/xtls/xray-core/app/proxyman/command"
+	routingService "github.com/xtls/xray-core/app/router/command"
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
@@ -32,6 +33,7 @@ import (
 type XrayAPI struct {
 	HandlerServiceClient *command.HandlerServiceClient
 	StatsServiceClient   *statsService.StatsServiceClient
+	RoutingServiceClient *routingService.RoutingServiceClient
 	grpcClient           *grpc.ClientConn
 	isConnected          bool
 }
@@ -53,9 +55,11 @@ func (x *XrayAPI) Init(apiPort int) error {
 
 	hsClient := command.NewHandlerServiceClient(conn)
 	ssClient := statsService.NewStatsServiceClient(conn)
+	rsClient := routingService.NewRoutingServiceClient(conn)
 
 	x.HandlerServiceClient = &hsClient
 	x.StatsServiceClient = &ssClient
+	x.RoutingServiceClient = &rsClient
 
 	return nil
 }
@@ -67,6 +71,7 @@ func (x *XrayAPI) Close() {
 	}
 	x.HandlerServiceClient = nil
 	x.StatsServiceClient = nil
+	x.RoutingServiceClient = nil
 	x.isConnected = false
 }
 
@@ -92,6 +97,17 @@ func (x *XrayAPI) AddInbound(inbound []byte) error {
 	return err
 }
 
+// ValidateInbound checks that an inbound configuration builds into an Xray inbound,
+// without applying it.
+func ValidateInbound(inbound []byte) error {
+	conf := new(conf.InboundDetourConfig)
+	if err := json.Unmarshal(inbound, conf); err != nil {
+		return err
+	}
+	_, err := conf.Build()
+	return err
+}
+
 // DelInbound removes an inbound configuration from the Xray core by tag.
 func (x *XrayAPI) DelInbound(tag string) error {
 	client := *x.HandlerServiceClient
@@ -181,6 +197,80 @@ func (x *XrayAPI) RemoveUser(inboundTag, email string) error {
 	return nil
 }
 
+// GetOnlineIPs returns the source IPs of an online user mapped to their last-seen Unix timestamps.
+// Xray only tracks these when the statsUserOnline policy is enabled.
+func (x *XrayAPI) GetOnlineIPs(email string) (map[string]int64, error) {
+	if x.grpcClient == nil || x.StatsServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := (*x.StatsServiceClient).GetStatsOnlineIpList(ctx, &statsService.GetStatsRequest{
+		Name: "user>>>" + email + ">>>online",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetIps(), nil
+}
+
+// QueryStats returns the raw Xray counters whose names contain pattern (all counters if empty),
+// optionally resetting them.
+func (x *XrayAPI) QueryStats(pattern string, reset bool) (map[string]int64, error) {
+	if x.grpcClient == nil || x.StatsServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := (*x.StatsServiceClient).QueryStats(ctx, &statsService.QueryStatsRequest{
+		Pattern: pattern,
+		Reset_:  reset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stats: %w", err)
+	}
+
+	stats := make(map[string]int64, len(resp.GetStat()))
+	for _, stat := range resp.GetStat() {
+		stats[stat.Name] = stat.Value
+	}
+	return stats, nil
+}
+
+// ReplaceRouting replaces the routing rules and balancers of the running Xray with
+// routerConfig, the "routing" section of a config, without a restart. It needs the
+// RoutingService API; geo files in rules are loaded from the xray.location.asset folder.
+func (x *XrayAPI) ReplaceRouting(routerConfig []byte) error {
+	if x.grpcClient == nil || x.RoutingServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
+
+	routing := &conf.RouterConfig{}
+	if err := json.Unmarshal(routerConfig, routing); err != nil {
+		return fmt.Errorf("failed to parse routing config: %w", err)
+	}
+	config, err := routing.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build routing config: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = (*x.RoutingServiceClient).AddRule(ctx, &routingService.AddRuleRequest{
+		Config:       serial.ToTypedMessage(config),
+		ShouldAppend: false,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace routing rules: %w", err)
+	}
+	return nil
+}
+
 // GetTraffic queries traffic statistics from the Xray core, optionally resetting counters.
 func (x *XrayAPI) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 	if x.grpcClient == nil {
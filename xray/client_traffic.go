@@ -3,18 +3,20 @@ package xray
 // ClientTraffic represents traffic statistics and limits for a specific client.
 // It tracks upload/download usage, expiry times, and online status for inbound clients.
 type ClientTraffic struct {
-	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	InboundId  int    `json:"inboundId" form:"inboundId"`
-	ServerId   int    `json:"serverId" form:"serverId" gorm:"index"` // Foreign key to Server (for multi-server support)
-	Enable     bool   `json:"enable" form:"enable"`
-	Email      string `json:"email" form:"email" gorm:"unique"`
-	UUID       string `json:"uuid" form:"uuid" gorm:"-"`
-	SubId      string `json:"subId" form:"subId" gorm:"-"`
-	Up         int64  `json:"up" form:"up"`
-	Down       int64  `json:"down" form:"down"`
-	AllTime    int64  `json:"allTime" form:"allTime"`
-	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
-	Total      int64  `json:"total" form:"total"`
-	Reset      int    `json:"reset" form:"reset" gorm:"default:0"`
-	LastOnline int64  `json:"lastOnline" form:"lastOnline" gorm:"default:0"`
+	Id         int      `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId  int      `json:"inboundId" form:"inboundId"`
+	ServerId   int      `json:"serverId" form:"serverId" gorm:"index"` // Foreign key to Server (for multi-server support)
+	Enable     bool     `json:"enable" form:"enable"`
+	Email      string   `json:"email" form:"email" gorm:"unique"`
+	UUID       string   `json:"uuid" form:"uuid" gorm:"-"`
+	SubId      string   `json:"subId" form:"subId" gorm:"-"`
+	Up         int64    `json:"up" form:"up"`
+	Down       int64    `json:"down" form:"down"`
+	AllTime    int64    `json:"allTime" form:"allTime"`
+	ExpiryTime int64    `json:"expiryTime" form:"expiryTime"`
+	Total      int64    `json:"total" form:"total"`
+	Reset      int      `json:"reset" form:"reset" gorm:"default:0"`
+	LastOnline int64    `json:"lastOnline" form:"lastOnline" gorm:"default:0"`
+	UpdatedAt  int64    `json:"updatedAt" form:"updatedAt" gorm:"autoUpdateTime;index"` // Unix seconds of the last change, for incremental syncs
+	Warnings   []string `json:"warnings,omitempty" gorm:"-"`                            // Soft limit flags, e.g. "expiringSoon" (not stored in DB)
 }
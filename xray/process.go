@@ -43,6 +43,12 @@ func GetGeoipPath() string {
 	return config.GetBinFolderPath() + "/geoip.dat"
 }
 
+// GetGeoasnPath returns the path to the optional ASN data file.
+// It uses the geoip.dat format with ASNs (e.g. "AS13335") as entry codes.
+func GetGeoasnPath() string {
+	return config.GetBinFolderPath() + "/geoasn.dat"
+}
+
 // GetIPLimitLogPath returns the path to the IP limit log file.
 func GetIPLimitLogPath() string {
 	return config.GetLogFolder() + "/3xipl.log"
@@ -70,6 +76,18 @@ func GetAccessPersistentPrevLogPath() string {
 
 // GetAccessLogPath reads the Xray config and returns the access log file path.
 func GetAccessLogPath() (string, error) {
+	return getLogPath("access")
+}
+
+// GetErrorLogPath reads the Xray config and returns the error log file path, which
+// holds the leveled messages of Xray itself.
+func GetErrorLogPath() (string, error) {
+	return getLogPath("error")
+}
+
+// getLogPath returns the path configured for kind ("access" or "error") in the "log"
+// section of the Xray config.
+func getLogPath(kind string) (string, error) {
 	config, err := os.ReadFile(GetConfigPath())
 	if err != nil {
 		logger.Warningf("Failed to read configuration file: %s", err)
@@ -85,9 +103,8 @@ func GetAccessLogPath() (string, error) {
 
 	if jsonConfig["log"] != nil {
 		jsonLog := jsonConfig["log"].(map[string]any)
-		if jsonLog["access"] != nil {
-			accessLogPath := jsonLog["access"].(string)
-			return accessLogPath, nil
+		if path, ok := jsonLog[kind].(string); ok {
+			return path, nil
 		}
 	}
 	return "", err